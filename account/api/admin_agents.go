@@ -1,16 +1,50 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"account/internal/agentserver"
+	"account/internal/store"
 )
 
 type agentStatusReader interface {
 	Statuses() []agentserver.StatusSnapshot
+	UserTraffic() []agentserver.UserTraffic
+}
+
+// agentManager creates, rotates and revokes agent credentials at runtime.
+type agentManager interface {
+	ListAgents(ctx context.Context) ([]agentserver.AgentRecord, error)
+	CreateAgent(ctx context.Context, spec agentserver.AgentSpec) (agentserver.Identity, string, error)
+	RotateToken(ctx context.Context, id string) (string, error)
+	Revoke(ctx context.Context, id string) error
+	CreateEnrollment(ctx context.Context, spec agentserver.EnrollmentSpec) (agentserver.Enrollment, error)
+	StatusHistory(ctx context.Context, id string, limit int) ([]agentserver.StatusRecord, error)
+}
+
+const (
+	defaultAgentHistoryLimit = 100
+	maxAgentHistoryLimit     = 1000
+	maxAgentEnrollmentTTL    = 30 * 24 * time.Hour
+)
+
+type agentCreateRequest struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+type agentEnrollmentRequest struct {
+	agentCreateRequest
+	// TTL is the validity of the code in seconds.
+	TTL int `json:"ttlSeconds"`
 }
 
 type agentStatusEntry struct {
@@ -23,6 +57,25 @@ type agentStatusEntry struct {
 	SyncRevision string           `json:"syncRevision,omitempty"`
 	UpdatedAt    time.Time        `json:"updatedAt"`
 	Xray         agentXraySummary `json:"xray"`
+	Traffic      agentTraffic     `json:"traffic"`
+}
+
+type agentTrafficTotals struct {
+	Uplink      int64 `json:"uplink"`
+	Downlink    int64 `json:"downlink"`
+	Connections int64 `json:"connections"`
+}
+
+type agentTraffic struct {
+	agentTrafficTotals
+	Clients   int        `json:"clients"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+type agentUserTraffic struct {
+	UserID string `json:"userId"`
+	agentTrafficTotals
+	Agents []string `json:"agents"`
 }
 
 type agentXraySummary struct {
@@ -62,8 +115,288 @@ func (h *handler) adminAgentStatus(c *gin.Context) {
 			last := *snapshot.Report.Xray.LastSync
 			entry.Xray.LastSync = &last
 		}
+		entry.Traffic = agentTraffic{
+			agentTrafficTotals: trafficTotals(snapshot.Traffic.TrafficTotals),
+			Clients:            len(snapshot.Traffic.Clients),
+		}
+		if !snapshot.Traffic.UpdatedAt.IsZero() {
+			updated := snapshot.Traffic.UpdatedAt
+			entry.Traffic.UpdatedAt = &updated
+		}
 		entries = append(entries, entry)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"agents": entries})
+	traffic := h.agentStatusReader.UserTraffic()
+	users := make([]agentUserTraffic, 0, len(traffic))
+	for _, user := range traffic {
+		users = append(users, agentUserTraffic{
+			UserID:             user.UserID,
+			agentTrafficTotals: trafficTotals(user.TrafficTotals),
+			Agents:             append([]string(nil), user.Agents...),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"agents": entries, "users": users})
+}
+
+func trafficTotals(totals agentserver.TrafficTotals) agentTrafficTotals {
+	return agentTrafficTotals{Uplink: totals.Uplink, Downlink: totals.Downlink, Connections: totals.Connections}
+}
+
+// requireAgentAdmin restricts credential management to administrators.
+func (h *handler) requireAgentAdmin(c *gin.Context) (*store.User, bool) {
+	if h.agentManager == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_management_unavailable", "agent management is not configured")
+		return nil, false
+	}
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return nil, false
+	}
+	if actor.Role != store.RoleAdmin {
+		respondError(c, http.StatusForbidden, "forbidden", "only administrators can manage agent credentials")
+		return nil, false
+	}
+	return actor, true
+}
+
+func (h *handler) adminListAgents(c *gin.Context) {
+	if h.agentManager == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_management_unavailable", "agent management is not configured")
+		return
+	}
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	records, err := h.agentManager.ListAgents(c.Request.Context())
+	if err != nil {
+		slog.Error("failed to list agents", "err", err)
+		respondError(c, http.StatusInternalServerError, "agent_list_failed", "failed to list agents")
+		return
+	}
+	agents := make([]gin.H, 0, len(records))
+	for _, record := range records {
+		agents = append(agents, sanitizeAgentRecord(record))
+	}
+	c.JSON(http.StatusOK, gin.H{"agents": agents})
+}
+
+func (h *handler) adminCreateAgent(c *gin.Context) {
+	actor, ok := h.requireAgentAdmin(c)
+	if !ok {
+		return
+	}
+
+	var req agentCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	spec, ok := agentSpecFromRequest(c, req)
+	if !ok {
+		return
+	}
+
+	identity, token, err := h.agentManager.CreateAgent(c.Request.Context(), spec)
+	if err != nil {
+		h.respondAgentError(c, err, "agent_create_failed", "failed to create agent")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: identity.ID,
+		Action:    auditActionAgentCreate,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"name": identity.Name, "groups": identity.Groups},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"agent": sanitizeAgentRecord(agentserver.AgentRecord{Identity: identity}),
+		"token": token,
+	})
+}
+
+func (h *handler) adminRotateAgentToken(c *gin.Context) {
+	actor, ok := h.requireAgentAdmin(c)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	token, err := h.agentManager.RotateToken(c.Request.Context(), id)
+	if err != nil {
+		h.respondAgentError(c, err, "agent_rotate_failed", "failed to rotate agent token")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: id,
+		Action:    auditActionAgentRotate,
+		Outcome:   store.AuditOutcomeSuccess,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "token": token})
+}
+
+func (h *handler) adminRevokeAgent(c *gin.Context) {
+	actor, ok := h.requireAgentAdmin(c)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	if err := h.agentManager.Revoke(c.Request.Context(), id); err != nil {
+		h.respondAgentError(c, err, "agent_revoke_failed", "failed to revoke agent")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: id,
+		Action:    auditActionAgentRevoke,
+		Outcome:   store.AuditOutcomeSuccess,
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) adminCreateAgentEnrollment(c *gin.Context) {
+	actor, ok := h.requireAgentAdmin(c)
+	if !ok {
+		return
+	}
+
+	var req agentEnrollmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	spec, ok := agentSpecFromRequest(c, req.agentCreateRequest)
+	if !ok {
+		return
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if req.TTL < 0 || ttl > maxAgentEnrollmentTTL {
+		respondError(c, http.StatusBadRequest, "invalid_ttl", "ttlSeconds must be between 0 and 30 days")
+		return
+	}
+
+	enrollment, err := h.agentManager.CreateEnrollment(c.Request.Context(), agentserver.EnrollmentSpec{
+		AgentSpec: spec,
+		TTL:       ttl,
+		CreatedBy: actor.ID,
+	})
+	if err != nil {
+		h.respondAgentError(c, err, "agent_enrollment_failed", "failed to create enrollment code")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: enrollment.Agent.ID,
+		Action:    auditActionAgentEnrollment,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"name": enrollment.Agent.Name, "groups": enrollment.Agent.Groups, "expiresAt": enrollment.ExpiresAt},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"code":      enrollment.Code,
+		"agentId":   enrollment.Agent.ID,
+		"name":      enrollment.Agent.Name,
+		"groups":    enrollment.Agent.Groups,
+		"expiresAt": enrollment.ExpiresAt,
+	})
+}
+
+func (h *handler) adminAgentStatusHistory(c *gin.Context) {
+	if h.agentManager == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_management_unavailable", "agent management is not configured")
+		return
+	}
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_pagination", "limit must be a non-negative integer")
+		return
+	}
+	if limit == 0 {
+		limit = defaultAgentHistoryLimit
+	}
+	if limit > maxAgentHistoryLimit {
+		limit = maxAgentHistoryLimit
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	records, err := h.agentManager.StatusHistory(c.Request.Context(), id, limit)
+	if err != nil {
+		h.respondAgentError(c, err, "agent_history_failed", "failed to load agent status history")
+		return
+	}
+	history := make([]gin.H, 0, len(records))
+	for _, record := range records {
+		history = append(history, gin.H{
+			"healthy":      record.Report.Healthy,
+			"message":      record.Report.Message,
+			"users":        record.Report.Users,
+			"syncRevision": record.Report.SyncRevision,
+			"xray": agentXraySummary{
+				Running:  record.Report.Xray.Running,
+				Clients:  record.Report.Xray.Clients,
+				LastSync: record.Report.Xray.LastSync,
+			},
+			"reportedAt": record.ReportedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "history": history})
+}
+
+func agentSpecFromRequest(c *gin.Context, req agentCreateRequest) (agentserver.AgentSpec, bool) {
+	id := strings.TrimSpace(req.ID)
+	if strings.ContainsAny(id, "/ \t") {
+		respondError(c, http.StatusBadRequest, "invalid_agent_id", "agent id must not contain slashes or whitespace")
+		return agentserver.AgentSpec{}, false
+	}
+	return agentserver.AgentSpec{ID: id, Name: strings.TrimSpace(req.Name), Groups: req.Groups}, true
+}
+
+func (h *handler) respondAgentError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, agentserver.ErrPersistenceDisabled):
+		respondError(c, http.StatusServiceUnavailable, "agent_store_unavailable", "agent persistence is not configured")
+	case errors.Is(err, agentserver.ErrStaticAgent):
+		respondError(c, http.StatusConflict, "agent_static", "agent credential is defined in configuration")
+	case errors.Is(err, store.ErrAgentExists):
+		respondError(c, http.StatusConflict, "agent_exists", "agent already exists")
+	case errors.Is(err, store.ErrAgentNotFound):
+		respondError(c, http.StatusNotFound, "agent_not_found", "agent not found")
+	case errors.Is(err, store.ErrAgentRevoked):
+		respondError(c, http.StatusConflict, "agent_revoked", "agent credential has been revoked")
+	default:
+		slog.Error("agent management failed", "err", err, "code", code)
+		respondError(c, http.StatusInternalServerError, code, message)
+	}
+}
+
+func sanitizeAgentRecord(record agentserver.AgentRecord) gin.H {
+	payload := gin.H{
+		"id":      record.ID,
+		"name":    record.Name,
+		"groups":  append([]string{}, record.Groups...),
+		"dynamic": record.Dynamic,
+		"revoked": record.RevokedAt != nil,
+	}
+	if !record.CreatedAt.IsZero() {
+		payload["createdAt"] = record.CreatedAt
+		payload["updatedAt"] = record.UpdatedAt
+	}
+	if record.RevokedAt != nil {
+		payload["revokedAt"] = *record.RevokedAt
+	}
+	return payload
 }
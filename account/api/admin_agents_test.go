@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"account/internal/agentproto"
+	"account/internal/agentserver"
+	"account/internal/store"
+)
+
+func TestAdminAgentStatusReportsTraffic(t *testing.T) {
+	registry, err := agentserver.NewRegistry(agentserver.Config{Credentials: []agentserver.Credential{{ID: "edge-a", Token: "a"}, {ID: "edge-b", Token: "b"}}})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	agents := registry.Agents()
+	registry.ReportStatus(agents[0], agentproto.StatusReport{Healthy: true, Users: 2})
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{
+		{ID: "uuid-1", Uplink: 10, Downlink: 20, Connections: 1},
+		{ID: "uuid-2", Uplink: 5},
+	}})
+	registry.ReportTraffic(agents[1], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{{ID: "uuid-1", Downlink: 70, Connections: 2}}})
+
+	f := newAdminUsersFixture(t, WithAgentStatusReader(registry))
+	if rr := f.do(http.MethodGet, "/api/auth/admin/agents/status", f.session(t, f.createUser(t, "alice", store.RoleUser)), nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admin to be rejected, got %d", rr.Code)
+	}
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/agents/status", f.session(t, f.createUser(t, "root", store.RoleAdmin)), nil)
+	var resp struct {
+		Agents []struct {
+			ID      string `json:"id"`
+			Traffic struct {
+				Uplink      int64 `json:"uplink"`
+				Downlink    int64 `json:"downlink"`
+				Connections int64 `json:"connections"`
+				Clients     int   `json:"clients"`
+			} `json:"traffic"`
+		} `json:"agents"`
+		Users []struct {
+			UserID      string   `json:"userId"`
+			Downlink    int64    `json:"downlink"`
+			Connections int64    `json:"connections"`
+			Agents      []string `json:"agents"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected agent status, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(resp.Agents) != 2 {
+		t.Fatalf("expected two agents, got %+v", resp.Agents)
+	}
+	if traffic := resp.Agents[0].Traffic; traffic.Uplink != 15 || traffic.Downlink != 20 || traffic.Connections != 1 || traffic.Clients != 2 {
+		t.Fatalf("unexpected agent totals %+v", traffic)
+	}
+	if len(resp.Users) != 2 || resp.Users[0].UserID != "uuid-1" || resp.Users[0].Downlink != 90 || resp.Users[0].Connections != 3 || len(resp.Users[0].Agents) != 2 {
+		t.Fatalf("unexpected user totals %+v", resp.Users)
+	}
+}
+
+func TestAdminAgentCredentialManagement(t *testing.T) {
+	registry, err := agentserver.NewRegistry(agentserver.Config{
+		Credentials: []agentserver.Credential{{ID: "static", Token: "static-token"}},
+		Store:       store.NewMemoryStore(),
+	})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithAgentStatusReader(registry), WithAgentManager(registry))
+	admin := f.session(t, f.createUser(t, "root", store.RoleAdmin))
+	operator := f.session(t, f.createUser(t, "ops", store.RoleOperator))
+
+	if rr := f.do(http.MethodPost, "/api/auth/admin/agents", operator, map[string]any{"id": "edge"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected operators not to create credentials, got %d", rr.Code)
+	}
+	rr := f.do(http.MethodPost, "/api/auth/admin/agents", admin, map[string]any{"id": "edge", "name": "Edge", "groups": []string{"hk"}})
+	var created struct {
+		Agent struct {
+			ID      string `json:"id"`
+			Dynamic bool   `json:"dynamic"`
+		} `json:"agent"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("expected agent to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if created.Agent.ID != "edge" || !created.Agent.Dynamic || created.Token == "" {
+		t.Fatalf("unexpected created agent %+v", created)
+	}
+	if _, ok := registry.Authenticate(context.Background(), created.Token); !ok {
+		t.Fatalf("expected the returned token to authenticate")
+	}
+	if rr := f.do(http.MethodPost, "/api/auth/admin/agents", admin, map[string]any{"id": "edge"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate id to conflict, got %d", rr.Code)
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/admin/agents/edge/rotate", admin, nil)
+	var rotated struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &rotated); err != nil || rr.Code != http.StatusOK || rotated.Token == created.Token {
+		t.Fatalf("expected token rotation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPost, "/api/auth/admin/agents/static/rotate", admin, nil); rr.Code != http.StatusConflict {
+		t.Fatalf("expected static credentials to be immutable, got %d", rr.Code)
+	}
+	if rr := f.do(http.MethodDelete, "/api/auth/admin/agents/missing", admin, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown agent to be reported, got %d", rr.Code)
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/admin/agents/enrollments", admin, map[string]any{"name": "New node", "ttlSeconds": 600})
+	var enrollment struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &enrollment); err != nil || rr.Code != http.StatusCreated || enrollment.Code == "" {
+		t.Fatalf("expected enrollment code, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, _, err := registry.Enroll(context.Background(), enrollment.Code); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+
+	identity, _ := registry.Authenticate(context.Background(), rotated.Token)
+	if err := registry.RecordStatus(context.Background(), *identity, agentproto.StatusReport{Healthy: true, Users: 4}); err != nil {
+		t.Fatalf("record status: %v", err)
+	}
+	if rr := f.do(http.MethodDelete, "/api/auth/admin/agents/edge", admin, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected agent to be revoked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := registry.Authenticate(context.Background(), rotated.Token); ok {
+		t.Fatalf("expected revoked agent to be rejected")
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/agents/edge/history", operator, nil)
+	var history struct {
+		History []struct {
+			Healthy bool `json:"healthy"`
+			Users   int  `json:"users"`
+		} `json:"history"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected status history, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(history.History) != 1 || !history.History[0].Healthy || history.History[0].Users != 4 {
+		t.Fatalf("unexpected history %+v", history.History)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/agents", operator, nil)
+	var list struct {
+		Agents []struct {
+			ID      string `json:"id"`
+			Dynamic bool   `json:"dynamic"`
+			Revoked bool   `json:"revoked"`
+		} `json:"agents"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected agent list, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(list.Agents) != 3 || list.Agents[0].ID != "static" || list.Agents[0].Dynamic {
+		t.Fatalf("unexpected agent list %+v", list.Agents)
+	}
+
+	page, err := f.store.ListAuditEvents(context.Background(), store.AuditEventFilter{Action: auditActionAgentRevoke})
+	if err != nil || len(page.Events) != 1 || page.Events[0].SubjectID != "edge" {
+		t.Fatalf("expected revocation to be audited, got %+v (%v)", page, err)
+	}
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/store"
+)
+
+var assignableRoles = map[string]struct{}{
+	store.RoleAdmin:    {},
+	store.RoleOperator: {},
+	store.RoleUser:     {},
+}
+
+type adminUserCreateRequest struct {
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Password    string   `json:"password"`
+	Role        string   `json:"role"`
+	Groups      []string `json:"groups"`
+	Permissions []string `json:"permissions"`
+}
+
+type adminUserUpdateRequest struct {
+	Role        *string   `json:"role"`
+	Groups      *[]string `json:"groups"`
+	Permissions *[]string `json:"permissions"`
+	Disabled    *bool     `json:"disabled"`
+}
+
+func (h *handler) adminListUsers(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	opts := store.UserListOptions{
+		Query: c.Query("q"),
+		Role:  c.Query("role"),
+	}
+	if value := strings.TrimSpace(c.Query("disabled")); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_filter", "disabled must be true or false")
+			return
+		}
+		opts.Disabled = &disabled
+	}
+	var err error
+	if opts.Limit, err = queryInt(c, "limit"); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_pagination", "limit must be a non-negative integer")
+		return
+	}
+	if opts.Offset, err = queryInt(c, "offset"); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_pagination", "offset must be a non-negative integer")
+		return
+	}
+
+	page, err := h.store.ListUsers(c.Request.Context(), opts)
+	if err != nil {
+		slog.Error("failed to list users", "err", err)
+		respondError(c, http.StatusInternalServerError, "users_unavailable", "failed to list users")
+		return
+	}
+
+	users := make([]gin.H, 0, len(page.Users))
+	for i := range page.Users {
+		users = append(users, sanitizeAdminUser(&page.Users[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"users":  users,
+		"total":  page.Total,
+		"limit":  page.Limit,
+		"offset": page.Offset,
+	})
+}
+
+func (h *handler) adminGetUser(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	target, ok := h.loadAdminTargetUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": sanitizeAdminUser(target)})
+}
+
+func (h *handler) adminCreateUser(c *gin.Context) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	var req adminUserCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	password := strings.TrimSpace(req.Password)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "name_required", "name is required")
+		return
+	}
+	if email != "" && !strings.Contains(email, "@") {
+		respondError(c, http.StatusBadRequest, "invalid_email", "email must be a valid address")
+		return
+	}
+	if len(password) < 8 {
+		respondError(c, http.StatusBadRequest, "password_too_short", "password must be at least 8 characters")
+		return
+	}
+
+	role := store.RoleUser
+	if strings.TrimSpace(req.Role) != "" {
+		var valid bool
+		if role, valid = h.resolveAssignableRole(c, actor, req.Role); !valid {
+			return
+		}
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "hash_failure", "failed to secure password")
+		return
+	}
+
+	user := &store.User{
+		Name:          name,
+		Email:         email,
+		EmailVerified: email != "",
+		PasswordHash:  string(hashed),
+		Role:          role,
+		Groups:        req.Groups,
+		Permissions:   req.Permissions,
+	}
+	if err := h.store.CreateUser(c.Request.Context(), user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrEmailExists):
+			respondError(c, http.StatusConflict, "email_already_exists", "user with this email already exists")
+		case errors.Is(err, store.ErrNameExists):
+			respondError(c, http.StatusConflict, "name_already_exists", "user with this name already exists")
+		case errors.Is(err, store.ErrInvalidName):
+			respondError(c, http.StatusBadRequest, "invalid_name", "name is invalid")
+		default:
+			slog.Error("failed to create user", "err", err, "actorID", actor.ID)
+			respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
+		}
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: user.ID,
+		Action:    auditActionAdminUserCreate,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"role": user.Role},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"user": sanitizeAdminUser(user)})
+}
+
+func (h *handler) adminUpdateUser(c *gin.Context) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	var req adminUserUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	target, ok := h.loadAdminTargetUser(c)
+	if !ok || !h.authorizeAdminTarget(c, actor, target) {
+		return
+	}
+	if req.Disabled != nil && *req.Disabled && target.ID == actor.ID {
+		respondError(c, http.StatusBadRequest, "cannot_modify_self", "you cannot disable your own account")
+		return
+	}
+
+	ctx := c.Request.Context()
+	changed := false
+	if req.Role != nil {
+		role, valid := h.resolveAssignableRole(c, actor, *req.Role)
+		if !valid {
+			return
+		}
+		if target.ID == actor.ID && role != target.Role {
+			respondError(c, http.StatusBadRequest, "cannot_modify_self", "you cannot change your own role")
+			return
+		}
+		target.Role = role
+		changed = true
+	}
+	if req.Groups != nil {
+		target.Groups = *req.Groups
+		changed = true
+	}
+	if req.Permissions != nil {
+		target.Permissions = *req.Permissions
+		changed = true
+	}
+
+	if changed {
+		if err := h.store.UpdateUser(ctx, target); err != nil {
+			slog.Error("failed to update user", "err", err, "userID", target.ID, "actorID", actor.ID)
+			respondError(c, http.StatusInternalServerError, "user_update_failed", "failed to update user")
+			return
+		}
+	}
+
+	if req.Disabled != nil && *req.Disabled != target.Disabled {
+		if err := h.store.SetUserDisabled(ctx, target.ID, *req.Disabled); err != nil {
+			if errors.Is(err, store.ErrDisableNotSupported) {
+				respondError(c, http.StatusNotImplemented, "disable_not_supported", "the user store does not support disabling users")
+				return
+			}
+			slog.Error("failed to change user status", "err", err, "userID", target.ID, "actorID", actor.ID)
+			respondError(c, http.StatusInternalServerError, "user_update_failed", "failed to update user")
+			return
+		}
+		target.Disabled = *req.Disabled
+		if target.Disabled {
+			if err := h.store.RevokeUserRefreshTokens(ctx, target.ID, time.Now().UTC()); err != nil {
+				slog.Error("failed to revoke refresh tokens of disabled user", "err", err, "userID", target.ID, "actorID", actor.ID)
+				respondError(c, http.StatusInternalServerError, "user_update_failed", "failed to update user")
+				return
+			}
+		}
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: target.ID,
+		Action:    auditActionAdminUserUpdate,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  adminUserUpdateMetadata(req),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"user": sanitizeAdminUser(target)})
+}
+
+func (h *handler) adminDeleteUser(c *gin.Context) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	target, ok := h.loadAdminTargetUser(c)
+	if !ok || !h.authorizeAdminTarget(c, actor, target) {
+		return
+	}
+	if target.ID == actor.ID {
+		respondError(c, http.StatusBadRequest, "cannot_modify_self", "you cannot delete your own account")
+		return
+	}
+
+	if err := h.store.DeleteUser(c.Request.Context(), target.ID); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+			return
+		}
+		slog.Error("failed to delete user", "err", err, "userID", target.ID, "actorID", actor.ID)
+		respondError(c, http.StatusInternalServerError, "user_delete_failed", "failed to delete user")
+		return
+	}
+	// Sessions live in the cache, not in the user's rows, so they outlive
+	// the account unless dropped here.
+	sessionsRevoked := h.removeSessionsForUser(c.Request.Context(), target.ID, "")
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: target.ID,
+		Action:    auditActionAdminUserDelete,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"email": target.Email, "role": target.Role, "sessionsRevoked": sessionsRevoked},
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) loadAdminTargetUser(c *gin.Context) (*store.User, bool) {
+	id := strings.TrimSpace(c.Param("id"))
+	user, err := h.store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to load user")
+		return nil, false
+	}
+	return user, true
+}
+
+// authorizeAdminTarget prevents operators from modifying administrator
+// accounts.
+func (h *handler) authorizeAdminTarget(c *gin.Context, actor, target *store.User) bool {
+	if actor.Role != store.RoleAdmin && target.Role == store.RoleAdmin {
+		respondError(c, http.StatusForbidden, "forbidden", "only administrators can manage administrator accounts")
+		return false
+	}
+	return true
+}
+
+// resolveAssignableRole validates a requested role. Only administrators can
+// grant the administrator role.
+func (h *handler) resolveAssignableRole(c *gin.Context, actor *store.User, requested string) (string, bool) {
+	role := strings.ToLower(strings.TrimSpace(requested))
+	if _, ok := assignableRoles[role]; !ok {
+		respondError(c, http.StatusBadRequest, "invalid_role", "role must be one of admin, operator or user")
+		return "", false
+	}
+	if role == store.RoleAdmin && actor.Role != store.RoleAdmin {
+		respondError(c, http.StatusForbidden, "forbidden", "only administrators can grant the admin role")
+		return "", false
+	}
+	return role, true
+}
+
+// adminUserUpdateMetadata lists the fields an update request changed.
+func adminUserUpdateMetadata(req adminUserUpdateRequest) map[string]any {
+	metadata := make(map[string]any)
+	if req.Role != nil {
+		metadata["role"] = strings.ToLower(strings.TrimSpace(*req.Role))
+	}
+	if req.Groups != nil {
+		metadata["groups"] = *req.Groups
+	}
+	if req.Permissions != nil {
+		metadata["permissions"] = *req.Permissions
+	}
+	if req.Disabled != nil {
+		metadata["disabled"] = *req.Disabled
+	}
+	return metadata
+}
+
+func queryInt(c *gin.Context, key string) (int, error) {
+	value := strings.TrimSpace(c.Query(key))
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, errors.New("invalid integer")
+	}
+	return parsed, nil
+}
+
+func sanitizeAdminUser(user *store.User) gin.H {
+	payload := sanitizeUser(user, nil)
+	payload["createdAt"] = user.CreatedAt.UTC()
+	payload["updatedAt"] = user.UpdatedAt.UTC()
+	return payload
+}
@@ -43,7 +43,7 @@ func (h *handler) requireAdminOrOperator(c *gin.Context) (*store.User, bool) {
 		return nil, false
 	}
 
-	sess, ok := h.lookupSession(token)
+	sess, ok := h.lookupSession(c.Request.Context(), token)
 	if !ok {
 		respondError(c, http.StatusUnauthorized, "invalid_session", "session not found or expired")
 		return nil, false
@@ -54,6 +54,9 @@ func (h *handler) requireAdminOrOperator(c *gin.Context) (*store.User, bool) {
 		respondError(c, http.StatusInternalServerError, "session_user_lookup_failed", "failed to load session user")
 		return nil, false
 	}
+	if rejectDisabledUser(c, user) {
+		return nil, false
+	}
 
 	role := strings.ToLower(strings.TrimSpace(user.Role))
 	if role != store.RoleAdmin && role != store.RoleOperator {
@@ -85,5 +88,26 @@ func (h *handler) resolveSessionToken(c *gin.Context) string {
 func registerAdminRoutes(group *gin.RouterGroup, h *handler) {
 	admin := group.Group("/admin")
 	admin.GET("/users/metrics", h.adminUsersMetrics)
+	admin.GET("/users", h.adminListUsers)
+	admin.POST("/users", h.adminCreateUser)
+	admin.GET("/users/:id", h.adminGetUser)
+	admin.PATCH("/users/:id", h.adminUpdateUser)
+	admin.DELETE("/users/:id", h.adminDeleteUser)
+	admin.PATCH("/users/:id/subscriptions/:externalId", h.adminUpdateSubscriptionStatus)
 	admin.GET("/agents/status", h.adminAgentStatus)
+	admin.GET("/agents", h.adminListAgents)
+	admin.POST("/agents", h.adminCreateAgent)
+	admin.POST("/agents/enrollments", h.adminCreateAgentEnrollment)
+	admin.POST("/agents/:id/rotate", h.adminRotateAgentToken)
+	admin.DELETE("/agents/:id", h.adminRevokeAgent)
+	admin.GET("/agents/:id/history", h.adminAgentStatusHistory)
+	admin.GET("/xray/templates", h.adminListXrayTemplates)
+	admin.PUT("/xray/templates/:name", h.adminSaveXrayTemplate)
+	admin.DELETE("/xray/templates/:name", h.adminDeleteXrayTemplate)
+	admin.GET("/usage", h.adminListUsage)
+	admin.GET("/audit", h.adminListAuditEvents)
+	admin.GET("/audit/export", h.adminExportAuditEvents)
+	admin.GET("/email-templates", h.adminListEmailTemplates)
+	admin.GET("/email-templates/:name/preview", h.adminPreviewEmailTemplate)
+	admin.GET("/mail/queue", h.adminMailQueueMetrics)
 }
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/store"
+)
+
+type adminUsersFixture struct {
+	router *gin.Engine
+	store  store.Store
+}
+
+func newAdminUsersFixture(t *testing.T, opts ...Option) *adminUsersFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, append([]Option{WithStore(st), WithEmailVerification(false)}, opts...)...)
+	return &adminUsersFixture{router: router, store: st}
+}
+
+func (f *adminUsersFixture) createUser(t *testing.T, name, role string) *store.User {
+	t.Helper()
+	hashed, err := bcrypt.GenerateFromPassword([]byte("supersecure"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          name,
+		Email:         name + "@example.com",
+		EmailVerified: true,
+		PasswordHash:  string(hashed),
+		Role:          role,
+	}
+	if err := f.store.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func (f *adminUsersFixture) login(t *testing.T, user *store.User) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"identifier": user.Email, "password": "supersecure"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (f *adminUsersFixture) session(t *testing.T, user *store.User) string {
+	t.Helper()
+	rr := f.login(t, user)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	return decodeResponse(t, rr).Token
+}
+
+func (f *adminUsersFixture) do(method, path, token string, payload any) *httptest.ResponseRecorder {
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, authorizedRequest(method, path, token, body))
+	return rr
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	f.createUser(t, "bob", store.RoleUser)
+	token := f.session(t, admin)
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/users?q=ALI&limit=10", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected user listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Users []struct {
+			ID string `json:"id"`
+		} `json:"users"`
+		Total int `json:"total"`
+		Limit int `json:"limit"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	if listed.Total != 1 || len(listed.Users) != 1 || listed.Users[0].ID != alice.ID || listed.Limit != 10 {
+		t.Fatalf("unexpected listing %+v", listed)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/users?limit=2&offset=2", token, nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	if listed.Total != 3 || len(listed.Users) != 1 {
+		t.Fatalf("expected last page with one user, got %+v", listed)
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/admin/users", token, map[string]any{
+		"name": "carol", "email": "carol@example.com", "password": "supersecure", "role": "operator",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected user creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPatch, "/api/auth/admin/users/"+alice.ID, token, map[string]any{
+		"role": "operator", "groups": []string{"Ops"}, "permissions": []string{"nodes:read"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected user update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	updated, err := f.store.GetUserByID(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatalf("failed to load updated user: %v", err)
+	}
+	if updated.Role != store.RoleOperator || len(updated.Groups) != 1 || len(updated.Permissions) != 1 {
+		t.Fatalf("unexpected updated user %+v", updated)
+	}
+
+	refresh := &store.RefreshToken{ID: "refresh-alice", FamilyID: "family-alice", UserID: alice.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := f.store.CreateRefreshToken(context.Background(), refresh); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+
+	rr = f.do(http.MethodPatch, "/api/auth/admin/users/"+alice.ID, token, map[string]any{"disabled": true})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected user to be disabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := f.store.ConsumeRefreshToken(context.Background(), refresh.ID, time.Now()); !errors.Is(err, store.ErrRefreshTokenRevoked) {
+		t.Fatalf("expected disabling to revoke refresh tokens, got %v", err)
+	}
+	if rr := f.login(t, alice); rr.Code != http.StatusForbidden || decodeResponse(t, rr).Error != "account_disabled" {
+		t.Fatalf("expected disabled user login to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/users?disabled=true", token, nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	if listed.Total != 1 || listed.Users[0].ID != alice.ID {
+		t.Fatalf("expected disabled filter to match alice, got %+v", listed)
+	}
+
+	rr = f.do(http.MethodPatch, "/api/auth/admin/users/"+alice.ID, token, map[string]any{"disabled": false})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected user to be re-enabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.login(t, alice); rr.Code != http.StatusOK {
+		t.Fatalf("expected re-enabled user to sign in, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodDelete, "/api/auth/admin/users/"+alice.ID, token, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected user deletion, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = f.do(http.MethodGet, "/api/auth/admin/users/"+alice.ID, token, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted user to be gone, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodDelete, "/api/auth/admin/users/"+admin.ID, token, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected self deletion to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminUserManagementPermissions(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	operator := f.createUser(t, "ops", store.RoleOperator)
+	user := f.createUser(t, "dave", store.RoleUser)
+
+	userToken := f.session(t, user)
+	if rr := f.do(http.MethodGet, "/api/auth/admin/users", userToken, nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected standard user to be forbidden, got %d", rr.Code)
+	}
+
+	operatorToken := f.session(t, operator)
+	if rr := f.do(http.MethodGet, "/api/auth/admin/users", operatorToken, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected operator listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPatch, "/api/auth/admin/users/"+user.ID, operatorToken, map[string]any{"role": "admin"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected operator to be unable to grant admin, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPatch, "/api/auth/admin/users/"+admin.ID, operatorToken, map[string]any{"disabled": true}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected operator to be unable to disable an admin, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPatch, "/api/auth/admin/users/"+user.ID, operatorToken, map[string]any{"role": "superuser"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown role to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Disabling revokes access for existing sessions too.
+	adminToken := f.session(t, admin)
+	if rr := f.do(http.MethodPatch, "/api/auth/admin/users/"+operator.ID, adminToken, map[string]any{"disabled": true}); rr.Code != http.StatusOK {
+		t.Fatalf("expected admin to disable operator, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/admin/users", operatorToken, nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected disabled operator session to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+	"account/internal/xrayconfig"
+)
+
+type xrayTemplateRequest struct {
+	Content   string            `json:"content"`
+	AgentIDs  []string          `json:"agentIds"`
+	Groups    []string          `json:"groups"`
+	Variables map[string]string `json:"variables"`
+}
+
+type xrayTemplateResponse struct {
+	Name         string            `json:"name"`
+	Content      string            `json:"content"`
+	AgentIDs     []string          `json:"agentIds"`
+	Groups       []string          `json:"groups"`
+	Variables    map[string]string `json:"variables"`
+	Placeholders []string          `json:"placeholders"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+}
+
+func newXrayTemplateResponse(template store.XrayTemplate) xrayTemplateResponse {
+	response := xrayTemplateResponse{
+		Name:         template.Name,
+		Content:      string(template.Content),
+		AgentIDs:     append([]string{}, template.AgentIDs...),
+		Groups:       append([]string{}, template.Groups...),
+		Variables:    template.Variables,
+		Placeholders: xrayconfig.TemplateVariables(template.Content),
+		CreatedAt:    template.CreatedAt,
+		UpdatedAt:    template.UpdatedAt,
+	}
+	if response.Variables == nil {
+		response.Variables = map[string]string{}
+	}
+	return response
+}
+
+// requireTemplateAdmin restricts template changes to administrators.
+func (h *handler) requireTemplateAdmin(c *gin.Context) (*store.User, bool) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return nil, false
+	}
+	if actor.Role != store.RoleAdmin {
+		respondError(c, http.StatusForbidden, "forbidden", "only administrators can manage xray templates")
+		return nil, false
+	}
+	return actor, true
+}
+
+func (h *handler) adminListXrayTemplates(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	templates, err := h.store.ListXrayTemplates(c.Request.Context())
+	if err != nil {
+		slog.Error("failed to list xray templates", "err", err)
+		respondError(c, http.StatusInternalServerError, "xray_template_list_failed", "failed to list xray templates")
+		return
+	}
+	entries := make([]xrayTemplateResponse, 0, len(templates))
+	for _, template := range templates {
+		entries = append(entries, newXrayTemplateResponse(template))
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": entries})
+}
+
+func (h *handler) adminSaveXrayTemplate(c *gin.Context) {
+	actor, ok := h.requireTemplateAdmin(c)
+	if !ok {
+		return
+	}
+
+	var req xrayTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	name := strings.TrimSpace(c.Param("name"))
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "invalid_template_name", "template name is required")
+		return
+	}
+	if err := xrayconfig.ValidateTemplate([]byte(req.Content)); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_template", err.Error())
+		return
+	}
+
+	template := store.XrayTemplate{
+		Name:      name,
+		Content:   []byte(req.Content),
+		AgentIDs:  req.AgentIDs,
+		Groups:    req.Groups,
+		Variables: req.Variables,
+	}
+	if err := h.store.SaveXrayTemplate(c.Request.Context(), &template); err != nil {
+		slog.Error("failed to save xray template", "template", name, "err", err)
+		respondError(c, http.StatusInternalServerError, "xray_template_save_failed", "failed to save xray template")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: template.Name,
+		Action:    auditActionXrayTemplateSave,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"agentIds": template.AgentIDs, "groups": template.Groups},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"template": newXrayTemplateResponse(template)})
+}
+
+func (h *handler) adminDeleteXrayTemplate(c *gin.Context) {
+	actor, ok := h.requireTemplateAdmin(c)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(c.Param("name"))
+	if err := h.store.DeleteXrayTemplate(c.Request.Context(), name); err != nil {
+		if errors.Is(err, store.ErrXrayTemplateNotFound) {
+			respondError(c, http.StatusNotFound, "xray_template_not_found", "xray template not found")
+			return
+		}
+		slog.Error("failed to delete xray template", "template", name, "err", err)
+		respondError(c, http.StatusInternalServerError, "xray_template_delete_failed", "failed to delete xray template")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: name,
+		Action:    auditActionXrayTemplateDelete,
+		Outcome:   store.AuditOutcomeSuccess,
+	})
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"account/internal/store"
+)
+
+func TestAdminXrayTemplates(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	adminToken := f.session(t, f.createUser(t, "root", store.RoleAdmin))
+	operatorToken := f.session(t, f.createUser(t, "ops", store.RoleOperator))
+
+	payload := map[string]any{
+		"content":   `{"inbounds": [{"port": ${PORT}, "streamSettings": {"realitySettings": {"serverNames": ["${SNI}"]}}}]}`,
+		"groups":    []string{"hk"},
+		"variables": map[string]string{"PORT": "443", "SNI": "www.example.com"},
+	}
+	if rr := f.do(http.MethodPut, "/api/auth/admin/xray/templates/hk", operatorToken, payload); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected operator to be rejected, got %d", rr.Code)
+	}
+	if rr := f.do(http.MethodPut, "/api/auth/admin/xray/templates/hk", adminToken, map[string]any{"content": `{"port": }`}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid template to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPut, "/api/auth/admin/xray/templates/hk", adminToken, payload); rr.Code != http.StatusOK {
+		t.Fatalf("expected template to be saved, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/xray/templates", operatorToken, nil)
+	var resp struct {
+		Templates []struct {
+			Name         string   `json:"name"`
+			Groups       []string `json:"groups"`
+			Placeholders []string `json:"placeholders"`
+		} `json:"templates"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected template list, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(resp.Templates) != 1 || resp.Templates[0].Name != "hk" || len(resp.Templates[0].Placeholders) != 2 {
+		t.Fatalf("unexpected templates %+v", resp.Templates)
+	}
+
+	if rr := f.do(http.MethodDelete, "/api/auth/admin/xray/templates/hk", adminToken, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected template to be deleted, got %d", rr.Code)
+	}
+	if rr := f.do(http.MethodDelete, "/api/auth/admin/xray/templates/hk", adminToken, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected missing template to yield 404, got %d", rr.Code)
+	}
+
+	page, err := f.store.ListAuditEvents(context.Background(), store.AuditEventFilter{Action: auditActionXrayTemplateSave})
+	if err != nil || page.Total != 1 {
+		t.Fatalf("expected template save to be audited, got %+v (%v)", page, err)
+	}
+}
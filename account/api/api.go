@@ -8,12 +8,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"html"
 	"log/slog"
 	"math/big"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,8 +20,15 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"account/internal/auth"
+	"account/internal/cache"
+	"account/internal/emailtemplate"
+	"account/internal/oidc"
+	"account/internal/payment"
+	"account/internal/ratelimit"
 	"account/internal/service"
 	"account/internal/store"
+	"account/internal/subscription"
+	"account/internal/webauthn"
 )
 
 const defaultSessionTTL = 24 * time.Hour
@@ -34,39 +39,56 @@ const defaultPasswordResetTTL = 30 * time.Minute
 const maxMFAVerificationAttempts = 5
 const defaultMFALockoutDuration = 5 * time.Minute
 
+// MFA challenge purposes. Enrollment challenges are issued to signed in users
+// and may provision a new authenticator app. Login challenges are issued once
+// the first factor is verified and only accept factors that are already
+// enrolled; otherwise the password alone could enroll a new factor.
+const (
+	mfaPurposeEnroll = "enroll"
+	mfaPurposeLogin  = "login"
+)
+
 const sessionCookieName = "xc_session"
 
 type session struct {
-	userID    string
-	expiresAt time.Time
+	id         string
+	userID     string
+	expiresAt  time.Time
+	createdAt  time.Time
+	lastSeenAt time.Time
+	ip         string
+	userAgent  string
 }
 
 type handler struct {
-	store                     store.Store
-	sessions                  map[string]session
-	mu                        sync.RWMutex
-	sessionTTL                time.Duration
-	mfaChallenges             map[string]mfaChallenge
-	mfaMu                     sync.RWMutex
-	mfaChallengeTTL           time.Duration
-	totpIssuer                string
-	emailSender               EmailSender
-	emailVerificationEnabled  bool
-	verificationTTL           time.Duration
-	verifications             map[string]emailVerification
-	verificationMu            sync.RWMutex
-	registrationVerifications map[string]registrationVerification
-	registrationMu            sync.RWMutex
-	resetTTL                  time.Duration
-	passwordResets            map[string]passwordReset
-	resetMu                   sync.RWMutex
-	metricsProvider           service.UserMetricsProvider
-	agentStatusReader         agentStatusReader
-	tokenService              *auth.TokenService
+	store                    store.Store
+	cache                    cache.Cache
+	sessionTTL               time.Duration
+	mfaChallengeTTL          time.Duration
+	totpIssuer               string
+	emailSender              EmailSender
+	emailVerificationEnabled bool
+	emailTemplates           *emailtemplate.Set
+	subscriptionPlans        *subscription.Catalog
+	paymentWebhooks          map[string]payment.Adapter
+	verificationTTL          time.Duration
+	resetTTL                 time.Duration
+	metricsProvider          service.UserMetricsProvider
+	agentStatusReader        agentStatusReader
+	agentManager             agentManager
+	mailQueue                mailQueueMetricsReader
+	tokenService             *auth.TokenService
+	desktopSync              *DesktopSyncConfig
+	rateLimiter              ratelimit.Limiter
+	rateLimits               RateLimitConfig
+	webAuthn                 *webauthn.Config
+	oidcProviders            map[string]*oidc.Provider
+	oidcProviderOrder        []string
 }
 
 type mfaChallenge struct {
 	userID         string
+	purpose        string
 	expiresAt      time.Time
 	totpSecret     string
 	totpIssuer     string
@@ -108,6 +130,17 @@ func WithStore(st store.Store) Option {
 	}
 }
 
+// WithCache overrides the default in-memory cache used for sessions, MFA
+// challenges, email verifications and password reset tokens. Supplying a
+// shared backend such as Redis allows several replicas to serve the same users.
+func WithCache(c cache.Cache) Option {
+	return func(h *handler) {
+		if c != nil {
+			h.cache = c
+		}
+	}
+}
+
 // WithSessionTTL sets the TTL used for issued sessions.
 func WithSessionTTL(ttl time.Duration) Option {
 	return func(h *handler) {
@@ -160,6 +193,16 @@ func WithAgentStatusReader(reader agentStatusReader) Option {
 	}
 }
 
+// WithAgentManager wires the agent credential management used by admin
+// endpoints.
+func WithAgentManager(manager agentManager) Option {
+	return func(h *handler) {
+		if manager != nil {
+			h.agentManager = manager
+		}
+	}
+}
+
 // WithPasswordResetTTL overrides the default TTL for password reset tokens.
 func WithPasswordResetTTL(ttl time.Duration) Option {
 	return func(h *handler) {
@@ -181,19 +224,19 @@ func WithTokenService(tokenService *auth.TokenService) Option {
 // RegisterRoutes attaches account service endpoints to the router.
 func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	h := &handler{
-		store:                     store.NewMemoryStore(),
-		sessions:                  make(map[string]session),
-		sessionTTL:                defaultSessionTTL,
-		mfaChallenges:             make(map[string]mfaChallenge),
-		mfaChallengeTTL:           defaultMFAChallengeTTL,
-		totpIssuer:                defaultTOTPIssuer,
-		emailSender:               noopEmailSender,
-		emailVerificationEnabled:  true,
-		verificationTTL:           defaultEmailVerificationTTL,
-		verifications:             make(map[string]emailVerification),
-		registrationVerifications: make(map[string]registrationVerification),
-		resetTTL:                  defaultPasswordResetTTL,
-		passwordResets:            make(map[string]passwordReset),
+		store:                    store.NewMemoryStore(),
+		cache:                    cache.NewMemory(),
+		sessionTTL:               defaultSessionTTL,
+		mfaChallengeTTL:          defaultMFAChallengeTTL,
+		totpIssuer:               defaultTOTPIssuer,
+		emailSender:              noopEmailSender,
+		emailVerificationEnabled: true,
+		emailTemplates:           emailtemplate.Default(),
+		subscriptionPlans:        subscription.DefaultCatalog(),
+		verificationTTL:          defaultEmailVerificationTTL,
+		resetTTL:                 defaultPasswordResetTTL,
+		rateLimiter:              ratelimit.NewMemory(),
+		rateLimits:               DefaultRateLimitConfig(),
 	}
 
 	for _, opt := range opts {
@@ -204,6 +247,8 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	r.GET("/.well-known/jwks.json", h.jwks)
+
 	auth := r.Group("/api/auth")
 
 	auth.POST("/register", h.register)
@@ -215,32 +260,73 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	// Token exchange endpoint - converts public token to access/refresh tokens
 	auth.POST("/token/exchange", h.exchangeToken)
 
-	// Token refresh endpoint - generates new access token using refresh token
+	// Token refresh endpoint - rotates the refresh token and issues a new pair
 	auth.POST("/token/refresh", h.refreshToken)
 
+	// Token revoke endpoint - revokes the refresh token family
+	auth.POST("/token/revoke", h.revokeToken)
+
 	// Protected routes requiring authentication
-	authProtected := auth.Group("")
+	var protected []gin.HandlerFunc
 	if h.tokenService != nil {
-		authProtected.Use(h.tokenService.AuthMiddleware())
+		jwtMiddleware := h.tokenService.AuthMiddleware()
+		protected = append(protected, func(c *gin.Context) {
+			// Device tokens are verified by the handlers themselves.
+			if isDeviceToken(extractToken(c.GetHeader("Authorization"))) {
+				c.Next()
+				return
+			}
+			jwtMiddleware(c)
+		})
 	}
+	authProtected := auth.Group("", protected...)
 
 	authProtected.GET("/session", h.session)
 	authProtected.DELETE("/session", h.deleteSession)
+	authProtected.GET("/sessions", h.listUserSessions)
+	authProtected.DELETE("/sessions", h.revokeOtherSessions)
+	authProtected.DELETE("/sessions/:id", h.revokeUserSession)
 
 	authProtected.POST("/mfa/totp/provision", h.provisionTOTP)
 	authProtected.POST("/mfa/totp/verify", h.verifyTOTP)
 	authProtected.POST("/mfa/disable", h.disableMFA)
+	authProtected.POST("/mfa/recovery-codes", h.regenerateRecoveryCodes)
 	authProtected.GET("/mfa/status", h.mfaStatus)
 
+	auth.POST("/mfa/webauthn/login/begin", h.beginWebAuthnLogin)
+	auth.POST("/mfa/webauthn/login/finish", h.finishWebAuthnLogin)
+	authProtected.POST("/mfa/webauthn/register/begin", h.beginWebAuthnRegistration)
+	authProtected.POST("/mfa/webauthn/register/finish", h.finishWebAuthnRegistration)
+	authProtected.GET("/mfa/webauthn/credentials", h.listWebAuthnCredentials)
+	authProtected.PATCH("/mfa/webauthn/credentials/:id", h.renameWebAuthnCredential)
+	authProtected.DELETE("/mfa/webauthn/credentials/:id", h.deleteWebAuthnCredential)
+
+	auth.GET("/oidc/providers", h.listOIDCProviders)
+	auth.POST("/oidc/:provider/login", h.beginOIDCLogin)
+	auth.POST("/oidc/:provider/callback", h.finishOIDC)
+	authProtected.POST("/oidc/:provider/link", h.beginOIDCLink)
+	authProtected.GET("/identities", h.listIdentities)
+	authProtected.DELETE("/identities/:id", h.deleteIdentity)
+
 	authProtected.POST("/password/reset", h.requestPasswordReset)
 	authProtected.POST("/password/reset/confirm", h.confirmPasswordReset)
 
+	auth.GET("/subscriptions/plans", h.listSubscriptionPlans)
 	authProtected.GET("/subscriptions", h.listSubscriptions)
 	authProtected.POST("/subscriptions", h.upsertSubscription)
 	authProtected.POST("/subscriptions/cancel", h.cancelSubscription)
+	authProtected.GET("/usage", h.getUsage)
+	auth.POST("/payments/webhooks/:provider", h.receivePaymentWebhook)
 
+	// Desktop clients sync through /api/config/sync; the /api/auth alias
+	// stays for clients built against it.
+	r.Group("/api/config", protected...).POST("/sync", h.syncConfig)
 	authProtected.POST("/config/sync", h.syncConfig)
 
+	authProtected.GET("/devices", h.listDeviceTokens)
+	authProtected.POST("/devices", h.createDeviceToken)
+	authProtected.DELETE("/devices/:id", h.deleteDeviceToken)
+
 	authProtected.GET("/admin/settings", h.getAdminSettings)
 	authProtected.POST("/admin/settings", h.updateAdminSettings)
 
@@ -252,6 +338,9 @@ type registerRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Code     string `json:"code"`
+	// Locale is the preferred language of emails. The Accept-Language
+	// header is used when it is empty.
+	Locale string `json:"locale"`
 }
 
 type loginRequest struct {
@@ -260,6 +349,8 @@ type loginRequest struct {
 	Email      string `json:"email"`
 	Password   string `json:"password"`
 	TOTPCode   string `json:"totpCode"`
+	// RecoveryCode replaces TOTPCode when the authenticator app is lost.
+	RecoveryCode string `json:"recoveryCode"`
 }
 
 type verificationCodeRequest struct {
@@ -287,7 +378,6 @@ type subscriptionUpsertRequest struct {
 	PaymentQRCode string         `json:"paymentQr"`
 	Kind          string         `json:"kind"`
 	PlanID        string         `json:"planId"`
-	Status        string         `json:"status"`
 	Meta          map[string]any `json:"meta"`
 }
 
@@ -327,6 +417,10 @@ func (h *handler) register(c *gin.Context) {
 	password := strings.TrimSpace(req.Password)
 	code := strings.TrimSpace(req.Code)
 
+	if !h.allowAttempt(c, rateLimitScopeRegister, email) {
+		return
+	}
+
 	if name == "" {
 		respondError(c, http.StatusBadRequest, "name_required", "name is required")
 		return
@@ -353,7 +447,7 @@ func (h *handler) register(c *gin.Context) {
 			return
 		}
 
-		verification, ok := h.lookupRegistrationVerification(email)
+		verification, ok := h.lookupRegistrationVerification(c.Request.Context(), email)
 		if !ok {
 			respondError(c, http.StatusBadRequest, "verification_required", "verification code is required")
 			return
@@ -383,6 +477,7 @@ func (h *handler) register(c *gin.Context) {
 		Level:        store.LevelUser,
 		Role:         store.RoleUser,
 		Groups:       []string{"User"},
+		Locale:       h.emailTemplates.Match(append([]string{req.Locale}, emailtemplate.ParseAcceptLanguage(c.GetHeader("Accept-Language"))...)...),
 	}
 
 	if !h.emailVerificationEnabled || code != "" {
@@ -407,28 +502,10 @@ func (h *handler) register(c *gin.Context) {
 	}
 
 	if h.emailVerificationEnabled {
-		h.removeRegistrationVerification(email)
+		h.removeRegistrationVerification(c.Request.Context(), email)
 	}
 
-	trialExpiresAt := time.Now().UTC().Add(7 * 24 * time.Hour)
-	trial := &store.Subscription{
-		UserID:        user.ID,
-		Provider:      "trial",
-		PaymentMethod: "trial",
-		Kind:          "trial",
-		PlanID:        "TRIAL-7D",
-		ExternalID:    fmt.Sprintf("trial-%s", user.ID),
-		Status:        "active",
-		Meta: map[string]any{
-			"startsAt":  time.Now().UTC(),
-			"expiresAt": trialExpiresAt,
-			"note":      "new user full-access trial",
-		},
-	}
-
-	if err := h.store.UpsertSubscription(c.Request.Context(), trial); err != nil {
-		slog.Warn("failed to provision onboarding trial", "err", err, "userID", user.ID)
-	}
+	h.provisionTrialSubscription(c.Request.Context(), user)
 
 	message := "registration successful"
 
@@ -439,6 +516,40 @@ func (h *handler) register(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
+// provisionTrialSubscription grants a newly registered user the onboarding
+// trial. Failures are logged and do not block registration.
+func (h *handler) provisionTrialSubscription(ctx context.Context, user *store.User) {
+	plan, err := h.subscriptionPlans.Lookup(subscription.TrialPlanID)
+	if err != nil {
+		slog.Warn("onboarding trial plan is not configured", "err", err)
+		return
+	}
+	startsAt, expiresAt := plan.PeriodFrom(time.Now())
+	meta := map[string]any{
+		"startsAt": startsAt,
+		"note":     "new user full-access trial",
+	}
+	if expiresAt != nil {
+		meta["expiresAt"] = *expiresAt
+	}
+	trial := &store.Subscription{
+		UserID:             user.ID,
+		Provider:           "trial",
+		PaymentMethod:      "trial",
+		Kind:               plan.Kind,
+		PlanID:             plan.ID,
+		ExternalID:         fmt.Sprintf("trial-%s", user.ID),
+		Status:             store.SubscriptionStatusTrialing,
+		Meta:               meta,
+		CurrentPeriodStart: &startsAt,
+		CurrentPeriodEnd:   expiresAt,
+	}
+
+	if err := h.store.UpsertSubscription(ctx, trial); err != nil {
+		slog.Warn("failed to provision onboarding trial", "err", err, "userID", user.ID)
+	}
+}
+
 func (h *handler) verifyEmail(c *gin.Context) {
 	if hasQueryParameter(c, "token", "code") {
 		respondError(c, http.StatusBadRequest, "token_in_query", "verification code must be sent in the request body")
@@ -471,7 +582,7 @@ func (h *handler) verifyEmail(c *gin.Context) {
 		}
 	}
 
-	if verification, ok := h.lookupEmailVerification(email); ok {
+	if verification, ok := h.lookupEmailVerification(c.Request.Context(), email); ok {
 		if verification.code != code {
 			respondError(c, http.StatusBadRequest, "invalid_code", "verification code is invalid or expired")
 			return
@@ -485,7 +596,7 @@ func (h *handler) verifyEmail(c *gin.Context) {
 		}
 
 		if !strings.EqualFold(strings.TrimSpace(user.Email), verification.email) {
-			h.removeEmailVerification(email)
+			h.removeEmailVerification(c.Request.Context(), email)
 			respondError(c, http.StatusBadRequest, "invalid_code", "verification code is invalid or expired")
 			return
 		}
@@ -499,9 +610,9 @@ func (h *handler) verifyEmail(c *gin.Context) {
 			}
 		}
 
-		h.removeEmailVerification(email)
+		h.removeEmailVerification(c.Request.Context(), email)
 
-		sessionToken, expiresAt, err := h.createSession(user.ID)
+		sessionToken, expiresAt, err := h.createSession(c.Request.Context(), user.ID, clientOf(c))
 		if err != nil {
 			respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 			return
@@ -518,13 +629,13 @@ func (h *handler) verifyEmail(c *gin.Context) {
 		return
 	}
 
-	pending, ok := h.lookupRegistrationVerification(email)
+	pending, ok := h.lookupRegistrationVerification(c.Request.Context(), email)
 	if !ok || pending.code != code {
 		respondError(c, http.StatusBadRequest, "invalid_code", "verification code is invalid or expired")
 		return
 	}
 
-	if !h.markRegistrationVerified(email) {
+	if !h.markRegistrationVerified(c.Request.Context(), email) {
 		respondError(c, http.StatusBadRequest, "invalid_code", "verification code is invalid or expired")
 		return
 	}
@@ -550,6 +661,10 @@ func (h *handler) sendEmailVerification(c *gin.Context) {
 		return
 	}
 
+	if !h.allowAttempt(c, rateLimitScopeEmailVerification, email) {
+		return
+	}
+
 	// 与线上 SMTP 配置对齐：统一使用 10s 的超时控制
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
@@ -572,7 +687,7 @@ func (h *handler) sendEmailVerification(c *gin.Context) {
 			return
 		}
 
-		if err := h.enqueueEmailVerification(ctx, user); err != nil {
+		if err := h.enqueueEmailVerification(ctx, user, h.emailLocale(c, user)); err != nil {
 			slog.Error("failed to send verification email", "err", err, "email", user.Email)
 			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
 				respondError(c, http.StatusGatewayTimeout, "smtp_timeout", "email sending timed out")
@@ -591,7 +706,7 @@ func (h *handler) sendEmailVerification(c *gin.Context) {
 		return
 	}
 
-	if _, err := h.issueRegistrationVerification(ctx, email); err != nil {
+	if _, err := h.issueRegistrationVerification(ctx, email, h.emailLocale(c, nil)); err != nil {
 		slog.Error("failed to issue registration verification", "err", err, "email", email)
 		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
 			respondError(c, http.StatusGatewayTimeout, "smtp_timeout", "email sending timed out")
@@ -622,6 +737,10 @@ func (h *handler) requestPasswordReset(c *gin.Context) {
 		return
 	}
 
+	if !h.allowAttempt(c, rateLimitScopePasswordReset, email) {
+		return
+	}
+
 	user, err := h.store.GetUserByEmail(c.Request.Context(), email)
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
@@ -637,12 +756,18 @@ func (h *handler) requestPasswordReset(c *gin.Context) {
 		return
 	}
 
-	if err := h.enqueuePasswordReset(c.Request.Context(), user); err != nil {
+	if err := h.enqueuePasswordReset(c.Request.Context(), user, h.emailLocale(c, user)); err != nil {
 		slog.Error("failed to send password reset email", "err", err, "email", user.Email)
 		respondError(c, http.StatusInternalServerError, "password_reset_failed", "failed to initiate password reset")
 		return
 	}
 
+	h.recordAudit(c, store.AuditEvent{
+		SubjectID: user.ID,
+		Action:    auditActionPasswordResetRequest,
+		Outcome:   store.AuditOutcomeSuccess,
+	})
+
 	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists a reset email will be sent"})
 }
 
@@ -671,8 +796,13 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 		return
 	}
 
-	reset, ok := h.lookupPasswordReset(token)
+	reset, ok := h.takePasswordReset(c.Request.Context(), token)
 	if !ok {
+		h.recordAudit(c, store.AuditEvent{
+			Action:   auditActionPasswordReset,
+			Outcome:  store.AuditOutcomeFailure,
+			Metadata: map[string]any{"reason": "invalid_token"},
+		})
 		respondError(c, http.StatusBadRequest, "invalid_token", "reset token is invalid or expired")
 		return
 	}
@@ -685,11 +815,14 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 	}
 
 	if !strings.EqualFold(strings.TrimSpace(user.Email), reset.email) {
-		h.removePasswordReset(token)
 		respondError(c, http.StatusBadRequest, "invalid_token", "reset token is invalid or expired")
 		return
 	}
 
+	if rejectDisabledUser(c, user) {
+		return
+	}
+
 	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "password_reset_failed", "failed to reset password")
@@ -704,9 +837,22 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 		return
 	}
 
-	h.removePasswordReset(token)
+	// Whoever knew the old password may still hold a session, a refresh
+	// token or a device token.
+	revoked, err := h.revokeUserCredentials(c.Request.Context(), user.ID, "")
+	if err != nil {
+		slog.Error("failed to revoke credentials after password reset", "err", err, "userID", user.ID)
+		revoked["revocationFailed"] = true
+	}
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionPasswordReset,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  revoked,
+	})
 
-	sessionToken, expiresAt, err := h.createSession(user.ID)
+	sessionToken, expiresAt, err := h.createSession(c.Request.Context(), user.ID, clientOf(c))
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
@@ -748,7 +894,8 @@ func (h *handler) getAdminSettings(c *gin.Context) {
 }
 
 func (h *handler) updateAdminSettings(c *gin.Context) {
-	if _, ok := h.requireAdminOrOperator(c); !ok {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
 		return
 	}
 
@@ -773,6 +920,12 @@ func (h *handler) updateAdminSettings(c *gin.Context) {
 	})
 	if err != nil {
 		if errors.Is(err, service.ErrAdminSettingsVersionConflict) {
+			h.recordAudit(c, store.AuditEvent{
+				ActorID:  actor.ID,
+				Action:   auditActionAdminSettingsUpdate,
+				Outcome:  store.AuditOutcomeFailure,
+				Metadata: map[string]any{"reason": "version_conflict", "version": req.Version},
+			})
 			c.JSON(http.StatusConflict, gin.H{
 				"error":   err.Error(),
 				"version": updated.Version,
@@ -787,6 +940,12 @@ func (h *handler) updateAdminSettings(c *gin.Context) {
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:  actor.ID,
+		Action:   auditActionAdminSettingsUpdate,
+		Outcome:  store.AuditOutcomeSuccess,
+		Metadata: map[string]any{"version": updated.Version, "matrix": updated.Matrix},
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"version": updated.Version,
 		"matrix":  updated.Matrix,
@@ -824,7 +983,7 @@ func normalizeAdminMatrix(in map[string]map[string]bool) (map[string]map[string]
 }
 
 func (h *handler) login(c *gin.Context) {
-	if hasQueryParameter(c, "username", "password", "identifier", "totp") {
+	if hasQueryParameter(c, "username", "password", "identifier", "totp", "recoveryCode") {
 		respondError(c, http.StatusBadRequest, "credentials_in_query", "sensitive credentials must not be sent in the query string")
 		return
 	}
@@ -845,15 +1004,22 @@ func (h *handler) login(c *gin.Context) {
 
 	password := strings.TrimSpace(req.Password)
 	totpCode := strings.TrimSpace(req.TOTPCode)
+	recoveryCode := strings.TrimSpace(req.RecoveryCode)
 
 	if identifier == "" {
 		respondError(c, http.StatusBadRequest, "missing_credentials", "identifier is required")
 		return
 	}
 
+	if !h.allowAttempt(c, rateLimitScopeLogin, identifier) {
+		h.recordLoginFailure(c, identifier, nil, "rate_limited")
+		return
+	}
+
 	user, err := h.findUserByIdentifier(c.Request.Context(), identifier)
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
+			h.recordLoginFailure(c, identifier, nil, "user_not_found")
 			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
 			return
 		}
@@ -861,8 +1027,15 @@ func (h *handler) login(c *gin.Context) {
 		return
 	}
 
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_locked")
+		return
+	}
+
 	if password != "" {
 		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			h.registerSignInFailure(c, user)
+			h.recordLoginFailure(c, identifier, user, "invalid_credentials")
 			respondError(c, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
 			return
 		}
@@ -875,58 +1048,129 @@ func (h *handler) login(c *gin.Context) {
 			respondError(c, http.StatusUnauthorized, "password_required", "password required for this identifier")
 			return
 		}
+		// Without a password the TOTP code is the only factor, so it has to
+		// belong to an enabled authenticator app. Security keys sign in
+		// without a password through the user-verifying passkey ceremony.
+		if !user.MFAEnabled {
+			h.recordLoginFailure(c, identifier, user, "password_required")
+			respondError(c, http.StatusUnauthorized, "password_required", "password required for this account")
+			return
+		}
+	}
+
+	if rejectDisabledUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_disabled")
+		return
 	}
 
 	if strings.TrimSpace(user.Email) != "" && !user.EmailVerified {
+		h.recordLoginFailure(c, identifier, user, "email_not_verified")
 		respondError(c, http.StatusUnauthorized, "email_not_verified", "email must be verified before login")
 		return
 	}
 
-	if user.MFAEnabled {
-		if totpCode == "" {
-			respondError(c, http.StatusBadRequest, "mfa_code_required", "totp code is required")
+	// Accounts with security keys finish signing in through the WebAuthn
+	// ceremony unless a TOTP or recovery code for an enabled authenticator
+	// app is given. The MFA token is only issued once the password has been
+	// verified, as the ceremony it starts does not require user verification.
+	if h.webAuthn != nil && password != "" && (!user.MFAEnabled || (totpCode == "" && recoveryCode == "")) {
+		credentials, err := h.store.ListWebAuthnCredentials(c.Request.Context(), user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
 			return
 		}
-
-		valid, err := totp.ValidateCustom(totpCode, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
-			Period:    30,
-			Skew:      1,
-			Digits:    otp.DigitsSix,
-			Algorithm: otp.AlgorithmSHA1,
-		})
-		if err != nil {
-			respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
+		if len(credentials) > 0 {
+			h.respondWebAuthnRequired(c, user)
 			return
 		}
-		if !valid {
-			respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
+	}
+
+	if user.MFAEnabled {
+		if totpCode == "" && recoveryCode == "" {
+			respondError(c, http.StatusBadRequest, "mfa_code_required", "totp code is required")
 			return
 		}
 
-		token, expiresAt, err := h.createSession(user.ID)
+		method := "totp"
+		if totpCode != "" {
+			valid, err := totp.ValidateCustom(totpCode, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
+				Period:    30,
+				Skew:      1,
+				Digits:    otp.DigitsSix,
+				Algorithm: otp.AlgorithmSHA1,
+			})
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
+				return
+			}
+			if !valid {
+				h.registerSignInFailure(c, user)
+				h.recordLoginFailure(c, identifier, user, "invalid_mfa_code")
+				respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
+				return
+			}
+		} else {
+			method = "recovery_code"
+			redeemed, err := h.redeemRecoveryCode(c.Request.Context(), user, recoveryCode)
+			if err != nil {
+				slog.Error("failed to redeem recovery code", "err", err, "userID", user.ID)
+				respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+				return
+			}
+			if !redeemed {
+				h.registerSignInFailure(c, user)
+				h.recordLoginFailure(c, identifier, user, "invalid_recovery_code")
+				respondError(c, http.StatusUnauthorized, "invalid_recovery_code", "recovery code is invalid or has already been used")
+				return
+			}
+		}
+
+		token, expiresAt, err := h.createSession(c.Request.Context(), user.ID, clientOf(c))
 		if err != nil {
 			respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 			return
 		}
 
 		h.setSessionCookie(c, token, expiresAt)
+		h.clearSignInFailures(c, user)
+		h.recordAudit(c, store.AuditEvent{
+			ActorID:   user.ID,
+			SubjectID: user.ID,
+			Action:    auditActionLogin,
+			Outcome:   store.AuditOutcomeSuccess,
+			Metadata:  map[string]any{"identifier": identifier, "mfa": true, "method": method},
+		})
 
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message":   "login successful",
 			"token":     token,
 			"expiresAt": expiresAt.UTC(),
 			"user":      sanitizeUser(user, nil),
-		})
+		}
+		if method == "recovery_code" {
+			if remaining, err := h.store.CountMFARecoveryCodes(c.Request.Context(), user.ID); err == nil {
+				response["recoveryCodesRemaining"] = remaining
+			}
+		}
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	token, expiresAt, err := h.createSession(user.ID)
+	token, expiresAt, err := h.createSession(c.Request.Context(), user.ID, clientOf(c))
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
 	}
 
 	h.setSessionCookie(c, token, expiresAt)
+	h.clearSignInFailures(c, user)
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionLogin,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"identifier": identifier, "mfa": false},
+	})
 
 	response := gin.H{
 		"message":   "login successful",
@@ -935,7 +1179,7 @@ func (h *handler) login(c *gin.Context) {
 		"user":      sanitizeUser(user, nil),
 	}
 
-	if challengeToken, err := h.createMFAChallenge(user.ID); err != nil {
+	if challengeToken, err := h.createMFAChallenge(c.Request.Context(), user.ID, mfaPurposeEnroll); err != nil {
 		slog.Error("failed to create mfa challenge during login", "err", err, "userID", user.ID)
 	} else {
 		response["mfaToken"] = challengeToken
@@ -960,20 +1204,78 @@ func (h *handler) refreshToken(c *gin.Context) {
 		return
 	}
 
-	// Refresh access token
-	accessToken, err := h.tokenService.RefreshAccessToken(req.RefreshToken)
+	// Rotate the refresh token; the presented one can no longer be used.
+	tokenPair, err := h.tokenService.RefreshTokenPair(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		respondError(c, http.StatusUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			slog.Warn("refresh token reuse detected; token family revoked")
+			h.recordAudit(c, store.AuditEvent{
+				Action:   auditActionTokenRefresh,
+				Outcome:  store.AuditOutcomeFailure,
+				Metadata: map[string]any{"reason": "refresh_token_reused"},
+			})
+			respondError(c, http.StatusUnauthorized, "refresh_token_reused", "refresh token has already been used")
+		case errors.Is(err, auth.ErrInvalidRefreshToken):
+			respondError(c, http.StatusUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+		default:
+			slog.Error("failed to refresh token pair", "err", err)
+			respondError(c, http.StatusInternalServerError, "token_refresh_failed", "failed to refresh tokens")
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": accessToken,
-		"token_type":   "Bearer",
-		"expires_in":   int64(h.tokenService.GetAccessTokenExpiry().Seconds()),
+		"access_token":  tokenPair.AccessToken,
+		"refresh_token": tokenPair.RefreshToken,
+		"token_type":    tokenPair.TokenType,
+		"expires_in":    tokenPair.ExpiresIn,
 	})
 }
 
+// revokeToken signs a client out by revoking the family of the presented
+// refresh token. Possession of the refresh token is the credential.
+func (h *handler) revokeToken(c *gin.Context) {
+	if h.tokenService == nil {
+		respondError(c, http.StatusServiceUnavailable, "token_service_unavailable", "token service is not configured")
+		return
+	}
+
+	var req tokenRefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.RefreshToken) == "" {
+		respondError(c, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	if err := h.tokenService.RevokeRefreshToken(c.Request.Context(), req.RefreshToken); err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			respondError(c, http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+			return
+		}
+		slog.Error("failed to revoke refresh token", "err", err)
+		respondError(c, http.StatusInternalServerError, "token_revoke_failed", "failed to revoke refresh token")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// jwks publishes the public keys that verify access tokens so that other
+// services do not need the signing secret.
+func (h *handler) jwks(c *gin.Context) {
+	if h.tokenService == nil {
+		respondError(c, http.StatusNotFound, "jwks_unavailable", "token service is not configured")
+		return
+	}
+	doc, ok := h.tokenService.JWKS()
+	if !ok {
+		respondError(c, http.StatusNotFound, "jwks_unavailable", "access tokens are not signed with asymmetric keys")
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, doc)
+}
+
 type tokenExchangeRequest struct {
 	PublicToken string `json:"public_token"`
 	UserID      string `json:"user_id"`
@@ -1011,7 +1313,7 @@ func (h *handler) exchangeToken(c *gin.Context) {
 	}
 
 	// Generate token pair
-	tokenPair, err := h.tokenService.GenerateTokenPair(req.UserID, req.Email, roles)
+	tokenPair, err := h.tokenService.GenerateTokenPair(c.Request.Context(), req.UserID, req.Email, roles)
 	if err != nil {
 		slog.Error("failed to generate token pair", "err", err)
 		respondError(c, http.StatusInternalServerError, "token_generation_failed", "failed to generate tokens")
@@ -1058,7 +1360,7 @@ func (h *handler) session(c *gin.Context) {
 		return
 	}
 
-	sess, ok := h.lookupSession(token)
+	sess, ok := h.lookupSession(c.Request.Context(), token)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "session not found"})
 		return
@@ -1069,6 +1371,12 @@ func (h *handler) session(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load session user"})
 		return
 	}
+	if user.Disabled {
+		h.removeSession(c.Request.Context(), token)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "account disabled"})
+		return
+	}
+	h.touchSession(c.Request.Context(), token, sess, clientOf(c))
 
 	c.JSON(http.StatusOK, gin.H{"user": sanitizeUser(user, nil)})
 }
@@ -1093,32 +1401,24 @@ func (h *handler) deleteSession(c *gin.Context) {
 		return
 	}
 
-	h.removeSession(token)
+	h.removeSession(c.Request.Context(), token)
 	c.Status(http.StatusNoContent)
 }
 
-func (h *handler) requireAuthenticatedUser(c *gin.Context) (*store.User, bool) {
-	token := extractToken(c.GetHeader("Authorization"))
-	if token == "" {
-		if value := c.Query("token"); value != "" {
-			token = value
-		}
-	}
-	if token == "" {
-		if cookie, err := c.Cookie(sessionCookieName); err == nil {
-			candidate := strings.TrimSpace(cookie)
-			if candidate != "" {
-				token = candidate
-			}
-		}
-	}
-
+// requireAuthenticatedUser resolves the caller from a session token. Device
+// tokens are accepted as well when the endpoint lists the scopes it requires.
+func (h *handler) requireAuthenticatedUser(c *gin.Context, scopes ...string) (*store.User, bool) {
+	token := h.resolveSessionToken(c)
 	if token == "" {
 		respondError(c, http.StatusUnauthorized, "session_token_required", "session token is required")
 		return nil, false
 	}
 
-	sess, ok := h.lookupSession(token)
+	if isDeviceToken(token) {
+		return h.authenticateDeviceToken(c, token, scopes)
+	}
+
+	sess, ok := h.lookupSession(c.Request.Context(), token)
 	if !ok {
 		respondError(c, http.StatusUnauthorized, "invalid_session", "session token is invalid or expired")
 		return nil, false
@@ -1129,25 +1429,22 @@ func (h *handler) requireAuthenticatedUser(c *gin.Context) (*store.User, bool) {
 		respondError(c, http.StatusInternalServerError, "session_user_lookup_failed", "failed to load session user")
 		return nil, false
 	}
+	if rejectDisabledUser(c, user) {
+		return nil, false
+	}
 
+	h.touchSession(c.Request.Context(), token, sess, clientOf(c))
 	return user, true
 }
 
-func (h *handler) createSession(userID string) (string, time.Time, error) {
-	token, err := h.newRandomToken()
-	if err != nil {
-		return "", time.Time{}, err
-	}
-	ttl := h.sessionTTL
-	if ttl <= 0 {
-		ttl = defaultSessionTTL
+// rejectDisabledUser responds with 403 when an administrator has disabled
+// the account.
+func rejectDisabledUser(c *gin.Context, user *store.User) bool {
+	if user == nil || !user.Disabled {
+		return false
 	}
-	expiresAt := time.Now().Add(ttl)
-
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.sessions[token] = session{userID: userID, expiresAt: expiresAt}
-	return token, expiresAt, nil
+	respondError(c, http.StatusForbidden, "account_disabled", "account has been disabled")
+	return true
 }
 
 func (h *handler) setSessionCookie(c *gin.Context, token string, expiresAt time.Time) {
@@ -1160,26 +1457,6 @@ func (h *handler) setSessionCookie(c *gin.Context, token string, expiresAt time.
 	c.SetCookie(sessionCookieName, token, maxAge, "/", "", secure, true)
 }
 
-func (h *handler) lookupSession(token string) (session, bool) {
-	h.mu.RLock()
-	sess, ok := h.sessions[token]
-	h.mu.RUnlock()
-	if !ok {
-		return session{}, false
-	}
-	if time.Now().After(sess.expiresAt) {
-		h.removeSession(token)
-		return session{}, false
-	}
-	return sess, true
-}
-
-func (h *handler) removeSession(token string) {
-	h.mu.Lock()
-	delete(h.sessions, token)
-	h.mu.Unlock()
-}
-
 func (h *handler) newRandomToken() (string, error) {
 	buffer := make([]byte, 32)
 	if _, err := rand.Read(buffer); err != nil {
@@ -1205,71 +1482,7 @@ func (h *handler) effectiveMFAChallengeTTL() time.Duration {
 	return ttl
 }
 
-func clearMFAChallenge(ch *mfaChallenge) {
-	if ch == nil {
-		return
-	}
-	ch.totpSecret = ""
-	ch.totpIssuer = ""
-	ch.totpAccount = ""
-	ch.totpIssuedAt = time.Time{}
-	ch.failedAttempts = 0
-	ch.lockedUntil = time.Time{}
-}
-
-func (h *handler) updateMFAChallenge(token string, update func(*mfaChallenge) bool) (mfaChallenge, bool) {
-	h.mfaMu.Lock()
-	defer h.mfaMu.Unlock()
-
-	challenge, ok := h.mfaChallenges[token]
-	if !ok {
-		return mfaChallenge{}, false
-	}
-
-	if time.Now().After(challenge.expiresAt) {
-		clearMFAChallenge(&challenge)
-		delete(h.mfaChallenges, token)
-		return mfaChallenge{}, false
-	}
-
-	if update != nil {
-		if !update(&challenge) {
-			clearMFAChallenge(&challenge)
-			delete(h.mfaChallenges, token)
-			return mfaChallenge{}, false
-		}
-		h.mfaChallenges[token] = challenge
-	}
-
-	return challenge, true
-}
-
-func (h *handler) createMFAChallenge(userID string) (string, error) {
-	token, err := h.newRandomToken()
-	if err != nil {
-		return "", err
-	}
-	ttl := h.effectiveMFAChallengeTTL()
-	challenge := mfaChallenge{userID: userID, expiresAt: time.Now().Add(ttl)}
-	h.mfaMu.Lock()
-	h.mfaChallenges[token] = challenge
-	h.mfaMu.Unlock()
-	return token, nil
-}
-
-func (h *handler) lookupMFAChallenge(token string) (mfaChallenge, bool) {
-	return h.updateMFAChallenge(token, nil)
-}
-
-func (h *handler) refreshMFAChallenge(token string) (mfaChallenge, bool) {
-	ttl := h.effectiveMFAChallengeTTL()
-	return h.updateMFAChallenge(token, func(ch *mfaChallenge) bool {
-		ch.expiresAt = time.Now().Add(ttl)
-		return true
-	})
-}
-
-func (h *handler) enqueueEmailVerification(ctx context.Context, user *store.User) error {
+func (h *handler) enqueueEmailVerification(ctx context.Context, user *store.User, locale string) error {
 	email := strings.TrimSpace(user.Email)
 	if email == "" {
 		return errors.New("user email is empty")
@@ -1294,62 +1507,29 @@ func (h *handler) enqueueEmailVerification(ctx context.Context, user *store.User
 		expiresAt: expiresAt,
 	}
 
-	h.verificationMu.Lock()
-	h.verifications[normalizedEmail] = verification
-	h.verificationMu.Unlock()
-
-	name := strings.TrimSpace(user.Name)
-	if name == "" {
-		name = "there"
+	if err := h.storeEmailVerification(ctx, verification); err != nil {
+		return err
 	}
 
-	subject := "Verify your XControl account"
-	plainBody := fmt.Sprintf("Hello %s,\n\nUse the following verification code to verify your XControl account: %s\n\nThis code expires at %s UTC (in %d minutes).\nIf you did not request this email you can ignore it.\n", name, code, expiresAt.UTC().Format(time.RFC3339), int(ttl.Minutes()))
-	htmlBody := fmt.Sprintf("<p>Hello %s,</p><p>Use the following verification code to verify your XControl account:</p><p><strong>%s</strong></p><p>This code expires at %s UTC (in %d minutes).</p><p>If you did not request this email you can ignore it.</p>", html.EscapeString(name), code, expiresAt.UTC().Format(time.RFC3339), int(ttl.Minutes()))
-
-	msg := EmailMessage{
-		To:        []string{email},
-		Subject:   subject,
-		PlainBody: plainBody,
-		HTMLBody:  htmlBody,
+	msg, err := h.renderEmail(email, emailtemplate.Verification, locale, emailtemplate.Data{
+		Name:             strings.TrimSpace(user.Name),
+		Email:            email,
+		Code:             code,
+		ExpiresAt:        expiresAt.UTC().Format(time.RFC3339),
+		ExpiresInMinutes: int(ttl.Minutes()),
+	})
+	if err == nil {
+		err = h.emailSender.Send(ctx, msg)
 	}
-
-	if err := h.emailSender.Send(ctx, msg); err != nil {
-		h.removeEmailVerification(normalizedEmail)
+	if err != nil {
+		h.removeEmailVerification(ctx, normalizedEmail)
 		return err
 	}
 
 	return nil
 }
 
-func (h *handler) lookupEmailVerification(email string) (emailVerification, bool) {
-	email = strings.ToLower(strings.TrimSpace(email))
-	if email == "" {
-		return emailVerification{}, false
-	}
-
-	h.verificationMu.RLock()
-	verification, ok := h.verifications[email]
-	h.verificationMu.RUnlock()
-	if !ok {
-		return emailVerification{}, false
-	}
-
-	if time.Now().After(verification.expiresAt) {
-		h.removeEmailVerification(email)
-		return emailVerification{}, false
-	}
-
-	return verification, true
-}
-
-func (h *handler) removeEmailVerification(email string) {
-	h.verificationMu.Lock()
-	delete(h.verifications, strings.ToLower(strings.TrimSpace(email)))
-	h.verificationMu.Unlock()
-}
-
-func (h *handler) issueRegistrationVerification(ctx context.Context, email string) (registrationVerification, error) {
+func (h *handler) issueRegistrationVerification(ctx context.Context, email, locale string) (registrationVerification, error) {
 	normalized := strings.ToLower(strings.TrimSpace(email))
 	if normalized == "" {
 		return registrationVerification{}, errors.New("email is empty")
@@ -1371,96 +1551,33 @@ func (h *handler) issueRegistrationVerification(ctx context.Context, email strin
 		expiresAt: time.Now().Add(ttl),
 	}
 
-	h.registrationMu.Lock()
-	h.registrationVerifications[normalized] = verification
-	h.registrationMu.Unlock()
+	if err := h.storeRegistrationVerification(ctx, verification); err != nil {
+		return registrationVerification{}, err
+	}
 
 	trimmedEmail := strings.TrimSpace(email)
 	if trimmedEmail == "" {
 		trimmedEmail = normalized
 	}
 
-	subject := "Verify your email for XControl"
-	plainBody := fmt.Sprintf(
-		"Hello,\n\nUse the following verification code to continue creating your XControl account: %s\n\nThis code expires at %s UTC (in %d minutes).\nIf you did not request this email you can ignore it.\n",
-		code,
-		verification.expiresAt.UTC().Format(time.RFC3339),
-		int(ttl.Minutes()),
-	)
-	htmlBody := fmt.Sprintf(
-		"<p>Hello,</p><p>Use the following verification code to continue creating your XControl account:</p><p><strong>%s</strong></p><p>This code expires at %s UTC (in %d minutes).</p><p>If you did not request this email you can ignore it.</p>",
-		html.EscapeString(code),
-		verification.expiresAt.UTC().Format(time.RFC3339),
-		int(ttl.Minutes()),
-	)
-
-	msg := EmailMessage{
-		To:        []string{trimmedEmail},
-		Subject:   subject,
-		PlainBody: plainBody,
-		HTMLBody:  htmlBody,
+	msg, err := h.renderEmail(trimmedEmail, emailtemplate.Registration, locale, emailtemplate.Data{
+		Email:            trimmedEmail,
+		Code:             code,
+		ExpiresAt:        verification.expiresAt.UTC().Format(time.RFC3339),
+		ExpiresInMinutes: int(ttl.Minutes()),
+	})
+	if err == nil {
+		err = h.emailSender.Send(ctx, msg)
 	}
-
-	if err := h.emailSender.Send(ctx, msg); err != nil {
-		h.removeRegistrationVerification(normalized)
+	if err != nil {
+		h.removeRegistrationVerification(ctx, normalized)
 		return registrationVerification{}, err
 	}
 
 	return verification, nil
 }
 
-func (h *handler) lookupRegistrationVerification(email string) (registrationVerification, bool) {
-	email = strings.ToLower(strings.TrimSpace(email))
-	if email == "" {
-		return registrationVerification{}, false
-	}
-
-	h.registrationMu.RLock()
-	verification, ok := h.registrationVerifications[email]
-	h.registrationMu.RUnlock()
-	if !ok {
-		return registrationVerification{}, false
-	}
-
-	if time.Now().After(verification.expiresAt) {
-		h.removeRegistrationVerification(email)
-		return registrationVerification{}, false
-	}
-
-	return verification, true
-}
-
-func (h *handler) markRegistrationVerified(email string) bool {
-	email = strings.ToLower(strings.TrimSpace(email))
-	if email == "" {
-		return false
-	}
-
-	h.registrationMu.Lock()
-	defer h.registrationMu.Unlock()
-
-	verification, ok := h.registrationVerifications[email]
-	if !ok {
-		return false
-	}
-
-	if time.Now().After(verification.expiresAt) {
-		delete(h.registrationVerifications, email)
-		return false
-	}
-
-	verification.verified = true
-	h.registrationVerifications[email] = verification
-	return true
-}
-
-func (h *handler) removeRegistrationVerification(email string) {
-	h.registrationMu.Lock()
-	delete(h.registrationVerifications, strings.ToLower(strings.TrimSpace(email)))
-	h.registrationMu.Unlock()
-}
-
-func (h *handler) enqueuePasswordReset(ctx context.Context, user *store.User) error {
+func (h *handler) enqueuePasswordReset(ctx context.Context, user *store.User, locale string) error {
 	email := strings.TrimSpace(user.Email)
 	if email == "" {
 		return errors.New("user email is empty")
@@ -1483,84 +1600,28 @@ func (h *handler) enqueuePasswordReset(ctx context.Context, user *store.User) er
 		expiresAt: expiresAt,
 	}
 
-	h.resetMu.Lock()
-	h.passwordResets[token] = reset
-	h.resetMu.Unlock()
-
-	name := strings.TrimSpace(user.Name)
-	if name == "" {
-		name = "there"
+	if err := h.storePasswordReset(ctx, token, reset); err != nil {
+		return err
 	}
 
-	subject := "Reset your XControl password"
-	plainBody := fmt.Sprintf("Hello %s,\n\nUse the following token to reset your XControl account password: %s\n\nThis token expires at %s UTC.\nIf you did not request a reset you can ignore this email.\n", name, token, expiresAt.UTC().Format(time.RFC3339))
-	htmlBody := fmt.Sprintf("<p>Hello %s,</p><p>Use the following token to reset your XControl account password:</p><p><strong>%s</strong></p><p>This token expires at %s UTC.</p><p>If you did not request a reset you can ignore this email.</p>", html.EscapeString(name), token, expiresAt.UTC().Format(time.RFC3339))
-
-	msg := EmailMessage{
-		To:        []string{email},
-		Subject:   subject,
-		PlainBody: plainBody,
-		HTMLBody:  htmlBody,
+	msg, err := h.renderEmail(email, emailtemplate.PasswordReset, locale, emailtemplate.Data{
+		Name:             strings.TrimSpace(user.Name),
+		Email:            email,
+		Code:             token,
+		ExpiresAt:        expiresAt.UTC().Format(time.RFC3339),
+		ExpiresInMinutes: int(ttl.Minutes()),
+	})
+	if err == nil {
+		err = h.emailSender.Send(ctx, msg)
 	}
-
-	if err := h.emailSender.Send(ctx, msg); err != nil {
-		h.removePasswordReset(token)
+	if err != nil {
+		h.removePasswordReset(ctx, token)
 		return err
 	}
 
 	return nil
 }
 
-func (h *handler) lookupPasswordReset(token string) (passwordReset, bool) {
-	token = strings.TrimSpace(token)
-	if token == "" {
-		return passwordReset{}, false
-	}
-
-	h.resetMu.RLock()
-	reset, ok := h.passwordResets[token]
-	h.resetMu.RUnlock()
-	if !ok {
-		return passwordReset{}, false
-	}
-
-	if time.Now().After(reset.expiresAt) {
-		h.removePasswordReset(token)
-		return passwordReset{}, false
-	}
-
-	return reset, true
-}
-
-func (h *handler) removePasswordReset(token string) {
-	h.resetMu.Lock()
-	delete(h.passwordResets, strings.TrimSpace(token))
-	h.resetMu.Unlock()
-}
-
-func (h *handler) removeMFAChallenge(token string) {
-	h.mfaMu.Lock()
-	if challenge, ok := h.mfaChallenges[token]; ok {
-		clearMFAChallenge(&challenge)
-		delete(h.mfaChallenges, token)
-	}
-	h.mfaMu.Unlock()
-}
-
-func (h *handler) removeMFAChallengesForUser(userID string) {
-	if userID == "" {
-		return
-	}
-	h.mfaMu.Lock()
-	for token, challenge := range h.mfaChallenges {
-		if challenge.userID == userID {
-			clearMFAChallenge(&challenge)
-			delete(h.mfaChallenges, token)
-		}
-	}
-	h.mfaMu.Unlock()
-}
-
 func (h *handler) provisionTOTP(c *gin.Context) {
 	var req struct {
 		Token   string `json:"token"`
@@ -1583,11 +1644,15 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 	)
 
 	if token != "" {
-		challenge, ok = h.refreshMFAChallenge(token)
+		challenge, ok = h.refreshMFAChallenge(ctx, token)
 		if !ok {
 			respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
 			return
 		}
+		if challenge.purpose != mfaPurposeEnroll {
+			respondError(c, http.StatusForbidden, "mfa_enrollment_not_allowed", "sign in before enrolling an authenticator app")
+			return
+		}
 
 		user, err = h.store.GetUserByID(ctx, challenge.userID)
 		if err != nil {
@@ -1601,7 +1666,7 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 			return
 		}
 
-		sess, ok := h.lookupSession(sessionToken)
+		sess, ok := h.lookupSession(ctx, sessionToken)
 		if !ok {
 			respondError(c, http.StatusUnauthorized, "invalid_session", "session token is invalid or expired")
 			return
@@ -1613,14 +1678,14 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 			return
 		}
 
-		challengeToken, err := h.createMFAChallenge(user.ID)
+		challengeToken, err := h.createMFAChallenge(ctx, user.ID, mfaPurposeEnroll)
 		if err != nil {
 			respondError(c, http.StatusInternalServerError, "mfa_challenge_creation_failed", "failed to create mfa challenge")
 			return
 		}
 
 		token = challengeToken
-		challenge, ok = h.refreshMFAChallenge(token)
+		challenge, ok = h.refreshMFAChallenge(ctx, token)
 		if !ok {
 			respondError(c, http.StatusInternalServerError, "mfa_challenge_creation_failed", "failed to initialize mfa challenge")
 			return
@@ -1660,8 +1725,8 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 	issuedAt := time.Now().UTC()
 	ttl := h.effectiveMFAChallengeTTL()
 
-	pendingChallenge, ok := h.refreshMFAChallenge(token)
-	if !ok || pendingChallenge.userID != user.ID {
+	pendingChallenge, ok := h.refreshMFAChallenge(ctx, token)
+	if !ok || pendingChallenge.userID != user.ID || pendingChallenge.purpose != mfaPurposeEnroll {
 		respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
 		return
 	}
@@ -1686,8 +1751,8 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 		return
 	}
 
-	pendingChallenge, ok = h.updateMFAChallenge(token, func(ch *mfaChallenge) bool {
-		if ch.userID != user.ID {
+	pendingChallenge, ok = h.updateMFAChallenge(ctx, token, func(ch *mfaChallenge) bool {
+		if ch.userID != user.ID || ch.purpose != mfaPurposeEnroll {
 			return false
 		}
 		ch.totpSecret = secret
@@ -1711,6 +1776,14 @@ func (h *handler) provisionTOTP(c *gin.Context) {
 		return
 	}
 
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionMFAProvision,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"issuer": issuer},
+	})
+
 	state := buildMFAState(user, &pendingChallenge)
 	sanitized := sanitizeUser(user, &pendingChallenge)
 	c.JSON(http.StatusOK, gin.H{
@@ -1766,7 +1839,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 		return
 	}
 
-	challenge, ok := h.lookupMFAChallenge(token)
+	challenge, ok := h.lookupMFAChallenge(c.Request.Context(), token)
 	if !ok {
 		respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
 		return
@@ -1779,7 +1852,21 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 		return
 	}
 
-	challenge, ok = h.updateMFAChallenge(token, func(ch *mfaChallenge) bool {
+	// A login challenge completes a sign in with an enabled authenticator
+	// app; only enrollment challenges may confirm a newly provisioned one.
+	enrollment := challenge.purpose == mfaPurposeEnroll
+	if !enrollment && !user.MFAEnabled {
+		respondError(c, http.StatusForbidden, "mfa_enrollment_not_allowed", "sign in before enrolling an authenticator app")
+		return
+	}
+	// Login challenges are minted on every first factor, so the per-token
+	// attempt counter alone does not bound guesses; share the account lockout
+	// with the password and security key sign in paths.
+	if !enrollment && h.rejectLockedUser(c, user) {
+		return
+	}
+
+	challenge, ok = h.updateMFAChallenge(ctx, token, func(ch *mfaChallenge) bool {
 		if ch.userID != user.ID {
 			return false
 		}
@@ -1804,7 +1891,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 	}
 
 	secret := strings.TrimSpace(user.MFATOTPSecret)
-	if secret == "" {
+	if secret == "" && enrollment {
 		secret = strings.TrimSpace(challenge.totpSecret)
 	}
 	if secret == "" {
@@ -1830,7 +1917,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 	}
 	if !valid {
 		ttl := h.effectiveMFAChallengeTTL()
-		updatedChallenge, ok := h.updateMFAChallenge(token, func(ch *mfaChallenge) bool {
+		updatedChallenge, ok := h.updateMFAChallenge(ctx, token, func(ch *mfaChallenge) bool {
 			if ch.userID != user.ID {
 				return false
 			}
@@ -1849,7 +1936,20 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 			challenge = updatedChallenge
 		}
 
-		if !challenge.lockedUntil.IsZero() && now.Before(challenge.lockedUntil) {
+		if !enrollment {
+			h.registerSignInFailure(c, user)
+		}
+
+		locked := !challenge.lockedUntil.IsZero() && now.Before(challenge.lockedUntil)
+		h.recordAudit(c, store.AuditEvent{
+			ActorID:   user.ID,
+			SubjectID: user.ID,
+			Action:    auditActionMFAVerify,
+			Outcome:   store.AuditOutcomeFailure,
+			Metadata:  map[string]any{"reason": "invalid_mfa_code", "locked": locked},
+		})
+
+		if locked {
 			retryAt := challenge.lockedUntil.UTC()
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":    "mfa_challenge_locked",
@@ -1876,30 +1976,63 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 	if user.MFASecretIssuedAt.IsZero() {
 		user.MFASecretIssuedAt = issuedAt
 	}
+	firstConfirmation := !user.MFAEnabled
 	user.MFAEnabled = true
 	user.MFAConfirmedAt = confirmationTime
 
+	// Redeem the token before acting on it so concurrent requests with the
+	// same code complete at most one verification.
+	if _, ok := h.takeMFAChallenge(ctx, token); !ok {
+		respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
+		return
+	}
+
 	if err := h.store.UpdateUser(ctx, user); err != nil {
 		respondError(c, http.StatusInternalServerError, "mfa_update_failed", "failed to enable mfa")
 		return
 	}
 
-	h.removeMFAChallenge(token)
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionMFAVerify,
+		Outcome:   store.AuditOutcomeSuccess,
+	})
+
+	// Recovery codes are only shown once, right after TOTP is first
+	// confirmed; completing a sign in with an enabled authenticator app
+	// keeps the existing batch. MFA stays enabled if they cannot be issued;
+	// the user can regenerate them from /mfa/recovery-codes.
+	var recoveryCodes []string
+	if firstConfirmation {
+		if recoveryCodes, err = h.issueRecoveryCodes(ctx, user); err != nil {
+			slog.Error("failed to issue mfa recovery codes", "err", err, "userID", user.ID)
+		}
+	}
+
+	if rejectDisabledUser(c, user) {
+		return
+	}
 
-	sessionToken, expiresAt, err := h.createSession(user.ID)
+	sessionToken, expiresAt, err := h.createSession(ctx, user.ID, clientOf(c))
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
 	}
 
+	h.clearSignInFailures(c, user)
 	h.setSessionCookie(c, sessionToken, expiresAt)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":   "mfa_verified",
 		"token":     sessionToken,
 		"expiresAt": expiresAt.UTC(),
 		"user":      sanitizeUser(user, nil),
-	})
+	}
+	if len(recoveryCodes) > 0 {
+		response["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *handler) mfaStatus(c *gin.Context) {
@@ -1924,7 +2057,7 @@ func (h *handler) mfaStatus(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	if authToken != "" {
-		if sess, ok := h.lookupSession(authToken); ok {
+		if sess, ok := h.lookupSession(ctx, authToken); ok {
 			user, err = h.store.GetUserByID(ctx, sess.userID)
 			if err != nil {
 				respondError(c, http.StatusInternalServerError, "mfa_status_failed", "failed to load user for status")
@@ -1936,7 +2069,7 @@ func (h *handler) mfaStatus(c *gin.Context) {
 	}
 
 	if token != "" {
-		if refreshed, ok := h.refreshMFAChallenge(token); ok {
+		if refreshed, ok := h.refreshMFAChallenge(ctx, token); ok {
 			if user != nil && user.ID != refreshed.userID {
 				challenge = nil
 			} else {
@@ -1969,16 +2102,33 @@ func (h *handler) mfaStatus(c *gin.Context) {
 		return
 	}
 
+	credentials, err := h.store.ListWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "mfa_status_failed", "failed to load webauthn credentials for status")
+		return
+	}
+
+	recoveryCodesRemaining, err := h.store.CountMFARecoveryCodes(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "mfa_status_failed", "failed to load recovery codes for status")
+		return
+	}
+
 	state := buildMFAState(user, challenge)
+	state["recoveryCodesRemaining"] = recoveryCodesRemaining
+	factors := enrolledMFAFactors(user, credentials)
+	state["webauthnEnabled"] = len(credentials) > 0
+	state["webauthnCredentialCount"] = len(credentials)
+	state["factors"] = factors
 	c.JSON(http.StatusOK, gin.H{
-		"enabled": user.MFAEnabled,
+		"enabled": len(factors) > 0,
 		"mfa":     state,
 		"user":    sanitizeUser(user, challenge),
 	})
 }
 
 func (h *handler) listSubscriptions(c *gin.Context) {
-	user, ok := h.requireAuthenticatedUser(c)
+	user, ok := h.requireAuthenticatedUser(c, deviceScopeSubscriptionsRead)
 	if !ok {
 		return
 	}
@@ -1998,7 +2148,7 @@ func (h *handler) listSubscriptions(c *gin.Context) {
 }
 
 func (h *handler) upsertSubscription(c *gin.Context) {
-	user, ok := h.requireAuthenticatedUser(c)
+	user, ok := h.requireAuthenticatedUser(c, deviceScopeSubscriptionsWrite)
 	if !ok {
 		return
 	}
@@ -2024,13 +2174,40 @@ func (h *handler) upsertSubscription(c *gin.Context) {
 		paymentMethod = provider
 	}
 	paymentQRCode := strings.TrimSpace(req.PaymentQRCode)
+
+	var plan subscription.Plan
+	planID := strings.TrimSpace(req.PlanID)
+	if planID != "" {
+		var err error
+		if plan, err = h.subscriptionPlans.Lookup(planID); err != nil {
+			respondError(c, http.StatusBadRequest, "unknown_plan", "subscription plan is not available")
+			return
+		}
+	}
 	kind := strings.TrimSpace(req.Kind)
 	if kind == "" {
-		kind = "subscription"
+		kind = plan.Kind
 	}
-	status := strings.TrimSpace(req.Status)
-	if status == "" {
-		status = "active"
+	if kind == "" {
+		kind = subscription.KindSubscription
+	}
+
+	existing, err := h.findSubscription(c.Request.Context(), user.ID, externalID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "subscription_upsert_failed", "failed to persist subscription state")
+		return
+	}
+	// Only the payment provider or an administrator moves a subscription out
+	// of pending; self-service writes keep the stored status and period.
+	status := store.SubscriptionStatusPending
+	if existing != nil {
+		status = existing.Status
+		if planID == "" {
+			planID = existing.PlanID
+		} else if existing.Status != store.SubscriptionStatusPending && planID != existing.PlanID {
+			respondError(c, http.StatusConflict, "plan_change_not_allowed", "the plan of a confirmed subscription cannot be changed")
+			return
+		}
 	}
 
 	sub := &store.Subscription{
@@ -2039,22 +2216,33 @@ func (h *handler) upsertSubscription(c *gin.Context) {
 		PaymentMethod: paymentMethod,
 		PaymentQRCode: paymentQRCode,
 		Kind:          kind,
-		PlanID:        strings.TrimSpace(req.PlanID),
+		PlanID:        planID,
 		ExternalID:    externalID,
 		Status:        status,
 		Meta:          req.Meta,
 	}
 
 	if err := h.store.UpsertSubscription(c.Request.Context(), sub); err != nil {
+		if respondSubscriptionError(c, err) {
+			return
+		}
 		respondError(c, http.StatusInternalServerError, "subscription_upsert_failed", "failed to persist subscription state")
 		return
 	}
 
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionSubscriptionUpsert,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"externalId": sub.ExternalID, "provider": sub.Provider, "planId": sub.PlanID, "status": sub.Status},
+	})
+
 	c.JSON(http.StatusOK, gin.H{"subscription": sanitizeSubscription(sub)})
 }
 
 func (h *handler) cancelSubscription(c *gin.Context) {
-	user, ok := h.requireAuthenticatedUser(c)
+	user, ok := h.requireAuthenticatedUser(c, deviceScopeSubscriptionsWrite)
 	if !ok {
 		return
 	}
@@ -2077,10 +2265,21 @@ func (h *handler) cancelSubscription(c *gin.Context) {
 			respondError(c, http.StatusNotFound, "subscription_not_found", "subscription not found")
 			return
 		}
+		if respondSubscriptionError(c, err) {
+			return
+		}
 		respondError(c, http.StatusInternalServerError, "subscription_cancel_failed", "failed to update subscription")
 		return
 	}
 
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionSubscriptionCancel,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"externalId": sub.ExternalID},
+	})
+
 	c.JSON(http.StatusOK, gin.H{"subscription": sanitizeSubscription(sub)})
 }
 
@@ -2114,6 +2313,8 @@ func sanitizeUser(user *store.User, challenge *mfaChallenge) gin.H {
 		"role":          user.Role,
 		"groups":        groups,
 		"permissions":   permissions,
+		"disabled":      user.Disabled,
+		"locale":        user.Locale,
 	}
 }
 
@@ -2137,11 +2338,18 @@ func sanitizeSubscription(sub *store.Subscription) gin.H {
 		"planId":        sub.PlanID,
 		"externalId":    sub.ExternalID,
 		"status":        sub.Status,
+		"entitled":      sub.Entitled(time.Now()),
 		"meta":          meta,
 		"createdAt":     sub.CreatedAt.UTC(),
 		"updatedAt":     sub.UpdatedAt.UTC(),
 	}
 
+	if sub.CurrentPeriodStart != nil {
+		payload["currentPeriodStart"] = sub.CurrentPeriodStart.UTC()
+	}
+	if sub.CurrentPeriodEnd != nil {
+		payload["currentPeriodEnd"] = sub.CurrentPeriodEnd.UTC()
+	}
 	if sub.CancelledAt != nil {
 		payload["cancelledAt"] = sub.CancelledAt.UTC()
 	}
@@ -2188,7 +2396,7 @@ func (h *handler) disableMFA(c *gin.Context) {
 		return
 	}
 
-	sess, ok := h.lookupSession(token)
+	sess, ok := h.lookupSession(c.Request.Context(), token)
 	if !ok {
 		respondError(c, http.StatusUnauthorized, "invalid_session", "session token is invalid or expired")
 		return
@@ -2217,7 +2425,24 @@ func (h *handler) disableMFA(c *gin.Context) {
 		return
 	}
 
-	h.removeMFAChallengesForUser(user.ID)
+	if err := h.store.ReplaceMFARecoveryCodes(ctx, user.ID, nil); err != nil {
+		slog.Error("failed to remove mfa recovery codes", "err", err, "userID", user.ID)
+	}
+	h.removeMFAChallengesForUser(ctx, user.ID)
+	// Credentials issued elsewhere were established under the stronger
+	// policy; only the session that disabled MFA survives.
+	revoked, err := h.revokeUserCredentials(ctx, user.ID, token)
+	if err != nil {
+		slog.Error("failed to revoke credentials after disabling mfa", "err", err, "userID", user.ID)
+		revoked["revocationFailed"] = true
+	}
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionMFADisable,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  revoked,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "mfa_disabled",
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+// Audited actions. Each handler records one event per request outcome.
+const (
+	auditActionLogin                = "auth.login"
+	auditActionTokenRefresh         = "auth.token.refresh"
+	auditActionMFAProvision         = "mfa.provision"
+	auditActionMFAVerify            = "mfa.verify"
+	auditActionMFADisable           = "mfa.disable"
+	auditActionMFARecoveryCodes     = "mfa.recovery_codes.generate"
+	auditActionWebAuthnRegister     = "mfa.webauthn.register"
+	auditActionWebAuthnDelete       = "mfa.webauthn.delete"
+	auditActionIdentityLink         = "identity.link"
+	auditActionIdentityUnlink       = "identity.unlink"
+	auditActionSessionRevoke        = "session.revoke"
+	auditActionPasswordResetRequest = "password.reset.request"
+	auditActionPasswordReset        = "password.reset"
+	auditActionAdminSettingsUpdate  = "admin.settings.update"
+	auditActionAdminUserCreate      = "admin.user.create"
+	auditActionAdminUserUpdate      = "admin.user.update"
+	auditActionAdminUserDelete      = "admin.user.delete"
+	auditActionAuditExport          = "audit.export"
+	auditActionSubscriptionUpsert   = "subscription.upsert"
+	auditActionSubscriptionCancel   = "subscription.cancel"
+	auditActionSubscriptionWebhook  = "subscription.webhook"
+	auditActionSubscriptionStatus   = "admin.subscription.status"
+	auditActionAgentCreate          = "agent.create"
+	auditActionAgentRotate          = "agent.token.rotate"
+	auditActionAgentRevoke          = "agent.revoke"
+	auditActionAgentEnrollment      = "agent.enrollment.create"
+	auditActionXrayTemplateSave     = "xray.template.save"
+	auditActionXrayTemplateDelete   = "xray.template.delete"
+
+	requestIDHeader = "X-Request-ID"
+
+	// auditExportPageSize is the number of events fetched per store call
+	// while streaming an export.
+	auditExportPageSize = 500
+)
+
+// recordAudit appends an audit event enriched with the client address, user
+// agent and request ID of the current request. Failures are logged but never
+// fail the request being audited.
+func (h *handler) recordAudit(c *gin.Context, event store.AuditEvent) {
+	event.IP = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+	event.RequestID = strings.TrimSpace(c.GetHeader(requestIDHeader))
+	if err := h.store.AppendAuditEvent(c.Request.Context(), &event); err != nil {
+		slog.Error("failed to record audit event", "err", err, "action", event.Action, "outcome", event.Outcome, "actorID", event.ActorID)
+	}
+}
+
+// recordLoginFailure audits a rejected login. user is nil when the identifier
+// did not match an account.
+func (h *handler) recordLoginFailure(c *gin.Context, identifier string, user *store.User, reason string) {
+	event := store.AuditEvent{
+		Action:   auditActionLogin,
+		Outcome:  store.AuditOutcomeFailure,
+		Metadata: map[string]any{"identifier": identifier, "reason": reason},
+	}
+	if user != nil {
+		event.ActorID = user.ID
+		event.SubjectID = user.ID
+	}
+	h.recordAudit(c, event)
+}
+
+func (h *handler) adminListAuditEvents(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	filter, ok := parseAuditEventFilter(c)
+	if !ok {
+		return
+	}
+	var err error
+	if filter.Limit, err = queryInt(c, "limit"); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_pagination", "limit must be a non-negative integer")
+		return
+	}
+	if filter.Offset, err = queryInt(c, "offset"); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_pagination", "offset must be a non-negative integer")
+		return
+	}
+
+	page, err := h.store.ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		slog.Error("failed to list audit events", "err", err)
+		respondError(c, http.StatusInternalServerError, "audit_unavailable", "failed to list audit events")
+		return
+	}
+
+	events := make([]gin.H, 0, len(page.Events))
+	for i := range page.Events {
+		events = append(events, sanitizeAuditEvent(&page.Events[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  page.Total,
+		"limit":  page.Limit,
+		"offset": page.Offset,
+	})
+}
+
+// adminExportAuditEvents streams every matching audit event as JSON lines,
+// newest first. The upper bound of the range is pinned when the export starts
+// so events appended while streaming do not shift the pages.
+func (h *handler) adminExportAuditEvents(c *gin.Context) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	filter, ok := parseAuditEventFilter(c)
+	if !ok {
+		return
+	}
+	if filter.Until.IsZero() {
+		filter.Until = time.Now().UTC()
+	}
+	filter.Limit = auditExportPageSize
+
+	ctx := c.Request.Context()
+	page, err := h.store.ListAuditEvents(ctx, filter)
+	if err != nil {
+		slog.Error("failed to export audit events", "err", err)
+		respondError(c, http.StatusInternalServerError, "audit_unavailable", "failed to export audit events")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID: actor.ID,
+		Action:  auditActionAuditExport,
+		Outcome: store.AuditOutcomeSuccess,
+		Metadata: map[string]any{
+			"since":  formatAuditFilterTime(filter.Since),
+			"until":  formatAuditFilterTime(filter.Until),
+			"actor":  filter.ActorID,
+			"action": filter.Action,
+			"total":  page.Total,
+		},
+	})
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit-events.jsonl"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for {
+		for i := range page.Events {
+			if err := encoder.Encode(sanitizeAuditEvent(&page.Events[i])); err != nil {
+				slog.Error("failed to write audit export", "err", err)
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		filter.Offset += len(page.Events)
+		if len(page.Events) < filter.Limit || filter.Offset >= page.Total {
+			return
+		}
+		if page, err = h.store.ListAuditEvents(ctx, filter); err != nil {
+			// Headers are already sent; the truncated stream is the only
+			// signal left to the client.
+			slog.Error("failed to export audit events", "err", err, "offset", filter.Offset)
+			return
+		}
+	}
+}
+
+func parseAuditEventFilter(c *gin.Context) (store.AuditEventFilter, bool) {
+	filter := store.AuditEventFilter{
+		ActorID:   c.Query("actor"),
+		SubjectID: c.Query("subject"),
+		Action:    c.Query("action"),
+	}
+	for key, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := strings.TrimSpace(c.Query(key))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_filter", key+" must be an RFC 3339 timestamp")
+			return store.AuditEventFilter{}, false
+		}
+		*target = parsed.UTC()
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		respondError(c, http.StatusBadRequest, "invalid_filter", "since must be before until")
+		return store.AuditEventFilter{}, false
+	}
+	return filter, true
+}
+
+func formatAuditFilterTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339)
+}
+
+func sanitizeAuditEvent(event *store.AuditEvent) gin.H {
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	return gin.H{
+		"id":         event.ID,
+		"occurredAt": event.OccurredAt.UTC(),
+		"actorId":    event.ActorID,
+		"subjectId":  event.SubjectID,
+		"action":     event.Action,
+		"outcome":    event.Outcome,
+		"ip":         event.IP,
+		"userAgent":  event.UserAgent,
+		"requestId":  event.RequestID,
+		"metadata":   metadata,
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+type auditEventPayload struct {
+	ID        string         `json:"id"`
+	ActorID   string         `json:"actorId"`
+	SubjectID string         `json:"subjectId"`
+	Action    string         `json:"action"`
+	Outcome   string         `json:"outcome"`
+	IP        string         `json:"ip"`
+	UserAgent string         `json:"userAgent"`
+	RequestID string         `json:"requestId"`
+	Metadata  map[string]any `json:"metadata"`
+}
+
+func TestAuditLogRecordsLoginAttempts(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	alice := f.createUser(t, "alice", store.RoleUser)
+
+	body, _ := json.Marshal(map[string]string{"identifier": alice.Email, "password": "wrong-password"})
+	req := authorizedRequest(http.MethodPost, "/api/auth/login", "", body)
+	req.Header.Set("User-Agent", "audit-test")
+	req.Header.Set(requestIDHeader, "req-123")
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected failed login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	f.session(t, alice)
+	token := f.session(t, admin)
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/audit?action=auth.login&actor="+alice.ID, token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected audit listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Events []auditEventPayload `json:"events"`
+		Total  int                 `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode audit listing: %v", err)
+	}
+	if listed.Total != 2 || len(listed.Events) != 2 {
+		t.Fatalf("expected two login events for alice, got %+v", listed)
+	}
+
+	success, failure := listed.Events[0], listed.Events[1]
+	if success.Outcome != store.AuditOutcomeSuccess || success.SubjectID != alice.ID {
+		t.Fatalf("expected newest event to be the successful login, got %+v", success)
+	}
+	if failure.Outcome != store.AuditOutcomeFailure || failure.Metadata["reason"] != "invalid_credentials" {
+		t.Fatalf("expected failed login with reason, got %+v", failure)
+	}
+	if failure.UserAgent != "audit-test" || failure.RequestID != "req-123" || failure.IP == "" {
+		t.Fatalf("expected request context to be recorded, got %+v", failure)
+	}
+}
+
+func TestAuditLogFilters(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	token := f.session(t, admin)
+
+	ctx := context.Background()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, action := range []string{"mfa.verify", "mfa.disable", "mfa.verify"} {
+		if err := f.store.AppendAuditEvent(ctx, &store.AuditEvent{
+			OccurredAt: base.Add(time.Duration(i) * time.Hour),
+			ActorID:    "user-1",
+			Action:     action,
+		}); err != nil {
+			t.Fatalf("failed to append audit event: %v", err)
+		}
+	}
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/audit?actor=user-1&since=2025-01-01T00:30:00Z&until=2025-01-01T03:00:00Z", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected audit listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Events []auditEventPayload `json:"events"`
+		Total  int                 `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode audit listing: %v", err)
+	}
+	if listed.Total != 2 || listed.Events[0].Action != "mfa.verify" || listed.Events[1].Action != "mfa.disable" {
+		t.Fatalf("unexpected filtered events %+v", listed)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/audit?since=yesterday", token, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid timestamp to be rejected, got %d", rr.Code)
+	}
+
+	user := f.createUser(t, "alice", store.RoleUser)
+	rr = f.do(http.MethodGet, "/api/auth/admin/audit", f.session(t, user), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected regular user to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAuditLogExport(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	token := f.session(t, admin)
+
+	ctx := context.Background()
+	total := auditExportPageSize + 5
+	for i := 0; i < total; i++ {
+		if err := f.store.AppendAuditEvent(ctx, &store.AuditEvent{ActorID: "user-1", Action: "subscription.upsert"}); err != nil {
+			t.Fatalf("failed to append audit event: %v", err)
+		}
+	}
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/audit/export?action=subscription.upsert", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected export, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/x-ndjson") {
+		t.Fatalf("expected ndjson content type, got %q", ct)
+	}
+
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var event auditEventPayload
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to decode export line %q: %v", scanner.Text(), err)
+		}
+		seen[event.ID] = struct{}{}
+	}
+	if len(seen) != total {
+		t.Fatalf("expected %d unique exported events, got %d", total, len(seen))
+	}
+
+	page, err := f.store.ListAuditEvents(ctx, store.AuditEventFilter{Action: auditActionAuditExport})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	if page.Total != 1 || page.Events[0].ActorID != admin.ID {
+		t.Fatalf("expected the export itself to be audited, got %+v", page.Events)
+	}
+}
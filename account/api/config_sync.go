@@ -1,36 +1,278 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"account/internal/crypto/syncpayload"
+	"account/internal/ratelimit"
+	"account/internal/service"
+	"account/internal/store"
+	"account/internal/xrayconfig"
 )
 
-// syncConfig handles POST /api/config/sync requests. The endpoint currently
-// verifies that the caller has a valid authenticated session (using the
-// xc_session cookie or Authorization header) and returns a placeholder
-// response indicating that the desktop sync feature is not yet implemented.
-//
-// The full implementation is outlined in docs/account-xstream-desktop-integration.md
-// and will be wired in subsequent iterations.
+const (
+	defaultDesktopSyncTimestampSkew = 5 * time.Minute
+	maxDesktopSyncRequestSize       = 4 << 10
+	desktopSyncNonceKeyPrefix       = "sync-nonce:"
+	desktopSyncRateLimitScope       = "config_sync"
+
+	// desktopSyncModuleKey is the admin settings module that controls which
+	// roles may download desktop configurations. When the module is absent
+	// from the permission matrix every role is allowed.
+	desktopSyncModuleKey = "desktopSync"
+)
+
+// DesktopSyncConfig configures the desktop configuration sync endpoint.
+type DesktopSyncConfig struct {
+	// Generator renders the per-user client configuration. When its
+	// Definition is nil, xrayconfig.DefaultClientDefinition is used.
+	Generator xrayconfig.Generator
+
+	// TimestampSkew bounds the accepted difference between the client and
+	// server clocks. It defaults to five minutes.
+	TimestampSkew time.Duration
+
+	// RateLimitPerDevicePerDay caps the syncs of each device fingerprint of
+	// a user over a rolling day. It is enforced with the limiter configured
+	// through WithRateLimiter; zero disables the cap.
+	RateLimitPerDevicePerDay int
+}
+
+// WithDesktopSync enables POST /api/config/sync (also served under
+// /api/auth/config/sync). Without this option the endpoint responds with 404
+// so clients can tell that the feature is disabled.
+func WithDesktopSync(cfg DesktopSyncConfig) Option {
+	return func(h *handler) {
+		if cfg.TimestampSkew <= 0 {
+			cfg.TimestampSkew = defaultDesktopSyncTimestampSkew
+		}
+		h.desktopSync = &cfg
+	}
+}
+
+// syncConfig handles POST /api/config/sync requests from XStream desktop
+// clients. The body is an encrypted syncpayload.Request; the reply is an
+// encrypted syncpayload.Response carrying the gzip compressed client
+// configuration. See docs/account-xstream-desktop-integration.md for the wire
+// format.
 func (h *handler) syncConfig(c *gin.Context) {
-	token := extractToken(c.GetHeader("Authorization"))
-	if token == "" {
-		if cookie, err := c.Cookie(sessionCookieName); err == nil {
-			token = strings.TrimSpace(cookie)
+	if h.desktopSync == nil {
+		respondError(c, http.StatusNotFound, "desktop_sync_disabled", "desktop configuration sync is not enabled")
+		return
+	}
+
+	user, ok := h.requireAuthenticatedUser(c, deviceScopeConfigSync)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxDesktopSyncRequestSize+1))
+	if err != nil || len(body) == 0 {
+		respondError(c, http.StatusBadRequest, "invalid_request", "sync payload is required")
+		return
+	}
+	if len(body) > maxDesktopSyncRequestSize {
+		respondError(c, http.StatusRequestEntityTooLarge, "payload_too_large", "sync payload is too large")
+		return
+	}
+
+	secret := desktopSyncSecret(user)
+	envelopeNonce, plaintext, err := syncpayload.Decrypt(body, secret)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_payload", "sync payload could not be decrypted")
+		return
+	}
+
+	req, err := syncpayload.UnmarshalRequest(plaintext)
+	if err != nil {
+		if errors.Is(err, syncpayload.ErrUnsupportedVersion) {
+			respondError(c, http.StatusBadRequest, "unsupported_version", "sync payload version is not supported")
+			return
 		}
+		respondError(c, http.StatusBadRequest, "invalid_payload", "sync payload is malformed")
+		return
+	}
+	if req.Nonce != envelopeNonce {
+		respondError(c, http.StatusBadRequest, "invalid_payload", "sync payload nonce mismatch")
+		return
+	}
+
+	skew := h.desktopSync.TimestampSkew
+	if delta := time.Since(time.UnixMilli(req.Timestamp)); delta > skew || delta < -skew {
+		respondError(c, http.StatusBadRequest, "stale_request", "sync request timestamp is outside the accepted window")
+		return
+	}
+
+	// Nonces only need to be remembered for as long as their timestamp could
+	// still be accepted.
+	nonceKey := desktopSyncNonceKeyPrefix + user.ID + ":" + hex.EncodeToString(req.Nonce[:])
+	fresh, err := h.cache.SetIfAbsent(ctx, nonceKey, []byte{1}, 2*skew)
+	if err != nil {
+		slog.Error("failed to record desktop sync nonce", "err", err, "userID", user.ID)
+		respondError(c, http.StatusServiceUnavailable, "replay_check_failed", "failed to verify sync request")
+		return
+	}
+	if !fresh {
+		respondError(c, http.StatusConflict, "replayed_request", "sync request has already been processed")
+		return
+	}
+	if !h.allowDesktopSync(c, user, req.DeviceFingerprint) {
+		return
+	}
+
+	logger := slog.With(
+		"userID", user.ID,
+		"device", desktopSyncFingerprintHash(req.DeviceFingerprint),
+		"clientVersion", req.ClientVersion,
+	)
+	resp := syncpayload.Response{Version: syncpayload.Version}
+
+	allowed, err := hasDesktopSyncPrivilege(ctx, user)
+	if err != nil {
+		logger.Error("failed to evaluate desktop sync privilege", "err", err)
+		resp.Status = syncpayload.StatusError
+		h.writeSyncResponse(c, http.StatusInternalServerError, secret, resp)
+		return
+	}
+	if !allowed {
+		logger.Info("desktop sync denied", "status", "NO_PRIVILEGE")
+		resp.Status = syncpayload.StatusNoPrivilege
+		h.writeSyncResponse(c, http.StatusOK, secret, resp)
+		return
+	}
+
+	rendered, err := h.desktopSync.Generator.RenderClient(xrayconfig.Client{ID: user.ID, Email: user.Email})
+	if err != nil {
+		logger.Error("failed to render desktop config", "err", err)
+		resp.Status = syncpayload.StatusError
+		h.writeSyncResponse(c, http.StatusInternalServerError, secret, resp)
+		return
 	}
 
-	if token == "" {
-		respondError(c, http.StatusUnauthorized, "session_token_required", "session token is required")
+	resp.ConfigVersion = desktopConfigVersion(rendered)
+	if req.LastConfigVersion == resp.ConfigVersion {
+		logger.Info("desktop sync", "status", "NOT_MODIFIED", "configVersion", resp.ConfigVersion)
+		resp.Status = syncpayload.StatusNotModified
+		h.writeSyncResponse(c, http.StatusOK, secret, resp)
 		return
 	}
 
-	if _, ok := h.lookupSession(token); !ok {
-		respondError(c, http.StatusUnauthorized, "invalid_session", "session token is invalid or expired")
+	compressed, err := gzipBytes(rendered)
+	if err != nil {
+		logger.Error("failed to compress desktop config", "err", err)
+		resp.Status = syncpayload.StatusError
+		resp.ConfigVersion = 0
+		h.writeSyncResponse(c, http.StatusInternalServerError, secret, resp)
+		return
+	}
+
+	logger.Info("desktop sync", "status", "OK", "configVersion", resp.ConfigVersion)
+	resp.Status = syncpayload.StatusOK
+	resp.XrayConfigGzip = compressed
+	h.writeSyncResponse(c, http.StatusOK, secret, resp)
+}
+
+// allowDesktopSync applies the per-device daily sync limit. It responds with
+// 429 and returns false when the device used up its allowance. Limiter
+// failures are logged and let the request through, like allowAttempt.
+func (h *handler) allowDesktopSync(c *gin.Context, user *store.User, fingerprint [syncpayload.FingerprintSize]byte) bool {
+	perDay := h.desktopSync.RateLimitPerDevicePerDay
+	if h.rateLimiter == nil || perDay <= 0 {
+		return true
+	}
+
+	key := desktopSyncRateLimitScope + ":device:" + user.ID + ":" + hex.EncodeToString(fingerprint[:])
+	decision, err := h.rateLimiter.Allow(c.Request.Context(), key, ratelimit.Limit{Burst: perDay, Period: 24 * time.Hour})
+	if err != nil {
+		slog.Error("rate limiter unavailable", "err", err, "scope", desktopSyncRateLimitScope)
+		return true
+	}
+	if !decision.Allowed {
+		respondRetryAfter(c, decision.RetryAfter, "rate_limited", "too many sync requests from this device, try again later")
+		return false
+	}
+	return true
+}
+
+func (h *handler) writeSyncResponse(c *gin.Context, status int, secret []byte, resp syncpayload.Response) {
+	payload, err := resp.MarshalBinary()
+	if err == nil {
+		payload, err = syncpayload.Encrypt(payload, secret)
+	}
+	if err != nil {
+		slog.Error("failed to encode desktop sync response", "err", err)
+		respondError(c, http.StatusInternalServerError, "sync_encode_failed", "failed to encode sync response")
 		return
 	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, "application/octet-stream", payload)
+}
+
+// desktopSyncSecret returns the key material used to seal sync payloads for
+// user. Accounts without a dedicated secret fall back to their UUID.
+func desktopSyncSecret(user *store.User) []byte {
+	if secret := strings.TrimSpace(user.SyncSecret); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(user.ID)
+}
 
-	respondError(c, http.StatusNotImplemented, "desktop_sync_unavailable", "desktop configuration sync is not yet available")
+// hasDesktopSyncPrivilege consults the admin permission matrix. Deployments
+// without the admin settings database, or without a desktopSync module in
+// the matrix, allow every authenticated user.
+func hasDesktopSyncPrivilege(ctx context.Context, user *store.User) (bool, error) {
+	settings, err := service.GetAdminSettings(ctx)
+	if err != nil {
+		if errors.Is(err, service.ErrServiceDBNotInitialized) {
+			return true, nil
+		}
+		return false, err
+	}
+	roles, ok := settings.Matrix[desktopSyncModuleKey]
+	if !ok {
+		return true, nil
+	}
+	return roles[user.Role], nil
+}
+
+// desktopConfigVersion derives a stable, positive version number from the
+// rendered configuration so that every replica reports the same value.
+func desktopConfigVersion(rendered []byte) int32 {
+	sum := sha256.Sum256(rendered)
+	version := int32(binary.BigEndian.Uint32(sum[:4]) & 0x7fffffff)
+	if version == 0 {
+		version = 1
+	}
+	return version
+}
+
+func desktopSyncFingerprintHash(fingerprint [syncpayload.FingerprintSize]byte) string {
+	sum := sha256.Sum256(fingerprint[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
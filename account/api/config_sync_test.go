@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/crypto/syncpayload"
+	"account/internal/ratelimit"
+	"account/internal/store"
+)
+
+func newDesktopSyncRouter(t *testing.T, opts ...Option) (*gin.Engine, *store.User, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, append([]Option{WithStore(st), WithEmailVerification(false)}, opts...)...)
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("supersecure"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "desktop-user",
+		Email:         "desktop@example.com",
+		EmailVerified: true,
+		PasswordHash:  string(hashed),
+	}
+	if err := st.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"identifier": user.Email, "password": "supersecure"})
+	if err != nil {
+		t.Fatalf("failed to marshal login payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	token := decodeResponse(t, rr).Token
+	if token == "" {
+		t.Fatalf("expected session token")
+	}
+	return router, user, token
+}
+
+func newSyncRequest(t *testing.T, lastConfigVersion int32, timestamp time.Time) syncpayload.Request {
+	t.Helper()
+	req := syncpayload.Request{
+		Version:           syncpayload.Version,
+		ClientVersion:     "1.0.0",
+		Timestamp:         timestamp.UnixMilli(),
+		LastConfigVersion: lastConfigVersion,
+	}
+	if _, err := rand.Read(req.DeviceFingerprint[:]); err != nil {
+		t.Fatalf("fingerprint: %v", err)
+	}
+	if _, err := rand.Read(req.Nonce[:]); err != nil {
+		t.Fatalf("nonce: %v", err)
+	}
+	return req
+}
+
+func postSync(t *testing.T, router *gin.Engine, token string, secret []byte, payload syncpayload.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	plaintext, err := payload.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal sync request: %v", err)
+	}
+	envelope, err := syncpayload.EncryptWithNonce(payload.Nonce, plaintext, secret)
+	if err != nil {
+		t.Fatalf("encrypt sync request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/config/sync", bytes.NewReader(envelope))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeSyncResponse(t *testing.T, rr *httptest.ResponseRecorder, secret []byte) syncpayload.Response {
+	t.Helper()
+	_, plaintext, err := syncpayload.Decrypt(rr.Body.Bytes(), secret)
+	if err != nil {
+		t.Fatalf("decrypt sync response: %v", err)
+	}
+	resp, err := syncpayload.UnmarshalResponse(plaintext)
+	if err != nil {
+		t.Fatalf("decode sync response: %v", err)
+	}
+	return resp
+}
+
+func TestConfigSyncDisabledByDefault(t *testing.T) {
+	router, user, token := newDesktopSyncRouter(t)
+
+	rr := postSync(t, router, token, []byte(user.ID), newSyncRequest(t, 0, time.Now()))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when desktop sync is disabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestConfigSyncFlow(t *testing.T) {
+	router, user, token := newDesktopSyncRouter(t, WithDesktopSync(DesktopSyncConfig{}))
+	secret := []byte(user.ID)
+
+	first := newSyncRequest(t, 0, time.Now())
+	rr := postSync(t, router, token, secret, first)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected sync success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("expected binary response, got %q", ct)
+	}
+	resp := decodeSyncResponse(t, rr, secret)
+	if resp.Status != syncpayload.StatusOK {
+		t.Fatalf("expected OK status, got %d", resp.Status)
+	}
+	if resp.ConfigVersion <= 0 {
+		t.Fatalf("expected positive config version, got %d", resp.ConfigVersion)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(resp.XrayConfigGzip))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("read gzip: %v", err)
+	}
+	var cfg struct {
+		Outbounds []struct {
+			Settings struct {
+				Vnext []struct {
+					Users []struct {
+						ID string `json:"id"`
+					} `json:"users"`
+				} `json:"vnext"`
+			} `json:"settings"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	if len(cfg.Outbounds) == 0 || len(cfg.Outbounds[0].Settings.Vnext) == 0 ||
+		len(cfg.Outbounds[0].Settings.Vnext[0].Users) != 1 || cfg.Outbounds[0].Settings.Vnext[0].Users[0].ID != user.ID {
+		t.Fatalf("expected config to contain user %s, got %s", user.ID, raw)
+	}
+
+	rr = postSync(t, router, token, secret, newSyncRequest(t, resp.ConfigVersion, time.Now()))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected not modified response, got %d: %s", rr.Code, rr.Body.String())
+	}
+	notModified := decodeSyncResponse(t, rr, secret)
+	if notModified.Status != syncpayload.StatusNotModified {
+		t.Fatalf("expected NOT_MODIFIED status, got %d", notModified.Status)
+	}
+	if notModified.ConfigVersion != resp.ConfigVersion || len(notModified.XrayConfigGzip) != 0 {
+		t.Fatalf("unexpected not modified payload %+v", notModified)
+	}
+
+	rr = postSync(t, router, token, secret, first)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected replayed nonce to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestConfigSyncRejectsInvalidRequests(t *testing.T) {
+	router, user, token := newDesktopSyncRouter(t, WithDesktopSync(DesktopSyncConfig{}))
+	secret := []byte(user.ID)
+
+	rr := postSync(t, router, token, secret, newSyncRequest(t, 0, time.Now().Add(-10*time.Minute)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected stale timestamp to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp := decodeResponse(t, rr); resp.Error != "stale_request" {
+		t.Fatalf("expected stale_request error, got %q", resp.Error)
+	}
+
+	rr = postSync(t, router, token, []byte("wrong-secret"), newSyncRequest(t, 0, time.Now()))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected undecryptable payload to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	payload := newSyncRequest(t, 0, time.Now())
+	plaintext, err := payload.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	envelope, err := syncpayload.Encrypt(plaintext, secret)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/config/sync", bytes.NewReader(envelope))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected nonce mismatch to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, path := range []string{"/api/config/sync", "/api/auth/config/sync"} {
+		req = httptest.NewRequest(http.MethodPost, path, bytes.NewReader(envelope))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected missing session to be rejected on %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestConfigSyncRateLimitPerDevice(t *testing.T) {
+	router, user, token := newDesktopSyncRouter(t,
+		WithDesktopSync(DesktopSyncConfig{RateLimitPerDevicePerDay: 2}),
+		WithRateLimiter(ratelimit.NewMemory(), RateLimitConfig{}))
+	secret := []byte(user.ID)
+
+	device := newSyncRequest(t, 0, time.Now())
+	for i := 0; i < 2; i++ {
+		payload := newSyncRequest(t, 0, time.Now())
+		payload.DeviceFingerprint = device.DeviceFingerprint
+		if rr := postSync(t, router, token, secret, payload); rr.Code != http.StatusOK {
+			t.Fatalf("expected sync %d to succeed, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+	}
+	payload := newSyncRequest(t, 0, time.Now())
+	payload.DeviceFingerprint = device.DeviceFingerprint
+	assertRetryAfter(t, postSync(t, router, token, secret, payload), "rate_limited")
+
+	// Other devices of the user keep their own allowance.
+	if rr := postSync(t, router, token, secret, newSyncRequest(t, 0, time.Now())); rr.Code != http.StatusOK {
+		t.Fatalf("expected another device to sync, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+const (
+	// deviceTokenPrefix distinguishes device tokens from session tokens and
+	// JWTs presented in the Authorization header.
+	deviceTokenPrefix = "xcd_"
+
+	// deviceTokenTouchInterval throttles last-used bookkeeping so that busy
+	// clients do not write to the store on every request.
+	deviceTokenTouchInterval = time.Minute
+
+	maxDeviceTokenNameLength = 64
+)
+
+// Scopes that can be granted to device tokens.
+const (
+	deviceScopeConfigSync         = "config:sync"
+	deviceScopeSubscriptionsRead  = "subscriptions:read"
+	deviceScopeSubscriptionsWrite = "subscriptions:write"
+)
+
+var knownDeviceScopes = map[string]struct{}{
+	deviceScopeConfigSync:         {},
+	deviceScopeSubscriptionsRead:  {},
+	deviceScopeSubscriptionsWrite: {},
+}
+
+type deviceTokenCreateRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func isDeviceToken(token string) bool {
+	return strings.HasPrefix(token, deviceTokenPrefix)
+}
+
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateDeviceToken resolves the owner of a device token and verifies
+// that the token grants every requested scope. Endpoints that do not declare
+// scopes never accept device tokens.
+func (h *handler) authenticateDeviceToken(c *gin.Context, token string, scopes []string) (*store.User, bool) {
+	if len(scopes) == 0 {
+		respondError(c, http.StatusForbidden, "device_token_not_allowed", "device tokens cannot access this endpoint")
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	record, err := h.store.GetDeviceTokenByHash(ctx, hashDeviceToken(token))
+	if err != nil {
+		if errors.Is(err, store.ErrDeviceTokenNotFound) {
+			respondError(c, http.StatusUnauthorized, "invalid_device_token", "device token is invalid or revoked")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "device_token_lookup_failed", "failed to verify device token")
+		return nil, false
+	}
+
+	now := time.Now().UTC()
+	if record.Expired(now) {
+		respondError(c, http.StatusUnauthorized, "device_token_expired", "device token has expired")
+		return nil, false
+	}
+	for _, scope := range scopes {
+		if !record.HasScope(scope) {
+			respondError(c, http.StatusForbidden, "insufficient_scope", "device token does not grant "+scope)
+			return nil, false
+		}
+	}
+
+	user, err := h.store.GetUserByID(ctx, record.UserID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_user_lookup_failed", "failed to load session user")
+		return nil, false
+	}
+	if rejectDisabledUser(c, user) {
+		return nil, false
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= deviceTokenTouchInterval {
+		if err := h.store.TouchDeviceToken(ctx, record.ID, now); err != nil {
+			slog.Warn("failed to record device token usage", "err", err, "deviceTokenID", record.ID)
+		}
+	}
+
+	return user, true
+}
+
+func (h *handler) listDeviceTokens(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.ListDeviceTokens(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "device_tokens_unavailable", "failed to load device tokens")
+		return
+	}
+
+	devices := make([]gin.H, 0, len(tokens))
+	for i := range tokens {
+		devices = append(devices, sanitizeDeviceToken(&tokens[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+func (h *handler) createDeviceToken(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req deviceTokenCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "device_name_required", "name is required")
+		return
+	}
+	if len(name) > maxDeviceTokenNameLength {
+		respondError(c, http.StatusBadRequest, "device_name_too_long", "name must be at most 64 characters")
+		return
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		scope = strings.TrimSpace(scope)
+		if _, known := knownDeviceScopes[scope]; !known {
+			respondError(c, http.StatusBadRequest, "invalid_scope", "unsupported scope "+scope)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		respondError(c, http.StatusBadRequest, "scopes_required", "at least one scope is required")
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			respondError(c, http.StatusBadRequest, "invalid_expiry", "expiresAt must be in the future")
+			return
+		}
+		expiry := req.ExpiresAt.UTC()
+		expiresAt = &expiry
+	}
+
+	secret, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "device_token_creation_failed", "failed to create device token")
+		return
+	}
+	secret = deviceTokenPrefix + secret
+
+	token := &store.DeviceToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashDeviceToken(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := h.store.CreateDeviceToken(c.Request.Context(), token); err != nil {
+		slog.Error("failed to persist device token", "err", err, "userID", user.ID)
+		respondError(c, http.StatusInternalServerError, "device_token_creation_failed", "failed to create device token")
+		return
+	}
+
+	// The secret is only ever returned once; the store keeps its hash.
+	c.JSON(http.StatusCreated, gin.H{
+		"device": sanitizeDeviceToken(token),
+		"token":  secret,
+	})
+}
+
+func (h *handler) deleteDeviceToken(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	if err := h.store.DeleteDeviceToken(c.Request.Context(), user.ID, id); err != nil {
+		if errors.Is(err, store.ErrDeviceTokenNotFound) {
+			respondError(c, http.StatusNotFound, "device_token_not_found", "device token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "device_token_revoke_failed", "failed to revoke device token")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func sanitizeDeviceToken(token *store.DeviceToken) gin.H {
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	payload := gin.H{
+		"id":        token.ID,
+		"name":      token.Name,
+		"scopes":    scopes,
+		"createdAt": token.CreatedAt.UTC(),
+	}
+	if token.LastUsedAt != nil {
+		payload["lastUsedAt"] = token.LastUsedAt.UTC()
+	}
+	if token.ExpiresAt != nil {
+		payload["expiresAt"] = token.ExpiresAt.UTC()
+	}
+	return payload
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/crypto/syncpayload"
+)
+
+type deviceTokenResponse struct {
+	Token  string `json:"token"`
+	Device struct {
+		ID         string     `json:"id"`
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"lastUsedAt"`
+		ExpiresAt  *time.Time `json:"expiresAt"`
+	} `json:"device"`
+}
+
+func createDeviceTokenForTest(t *testing.T, router *gin.Engine, sessionToken string, payload map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal device payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/devices", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func authorizedRequest(method, path, token string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func TestDeviceTokenLifecycle(t *testing.T) {
+	router, _, sessionToken := newDesktopSyncRouter(t)
+
+	rr := createDeviceTokenForTest(t, router, sessionToken, map[string]any{
+		"name":   "work laptop",
+		"scopes": []string{"subscriptions:read"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected device token creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created deviceTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode device token: %v", err)
+	}
+	if !strings.HasPrefix(created.Token, deviceTokenPrefix) {
+		t.Fatalf("expected device token prefix, got %q", created.Token)
+	}
+	if created.Device.Name != "work laptop" || len(created.Device.Scopes) != 1 {
+		t.Fatalf("unexpected device payload %+v", created.Device)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodGet, "/api/auth/subscriptions", created.Token, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected scoped read to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	body, _ := json.Marshal(map[string]string{"externalId": "sub-1"})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodPost, "/api/auth/subscriptions", created.Token, body))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected missing scope to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp := decodeResponse(t, rr); resp.Error != "insufficient_scope" {
+		t.Fatalf("expected insufficient_scope, got %q", resp.Error)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodGet, "/api/auth/devices", created.Token, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected device token management to require a session, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodGet, "/api/auth/devices", sessionToken, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected device listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Devices []struct {
+			ID         string     `json:"id"`
+			LastUsedAt *time.Time `json:"lastUsedAt"`
+		} `json:"devices"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode device list: %v", err)
+	}
+	if len(listed.Devices) != 1 || listed.Devices[0].ID != created.Device.ID {
+		t.Fatalf("unexpected device list %+v", listed.Devices)
+	}
+	if listed.Devices[0].LastUsedAt == nil {
+		t.Fatalf("expected last used timestamp to be recorded")
+	}
+	if strings.Contains(rr.Body.String(), created.Token) {
+		t.Fatalf("device listing must not expose the token secret")
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodDelete, "/api/auth/devices/"+created.Device.ID, sessionToken, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected device revocation, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodGet, "/api/auth/subscriptions", created.Token, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked device token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodDelete, "/api/auth/devices/"+created.Device.ID, sessionToken, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected second revocation to return 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeviceTokenValidation(t *testing.T) {
+	router, _, sessionToken := newDesktopSyncRouter(t)
+
+	cases := []struct {
+		name    string
+		payload map[string]any
+		code    string
+	}{
+		{name: "missing name", payload: map[string]any{"scopes": []string{"config:sync"}}, code: "device_name_required"},
+		{name: "missing scopes", payload: map[string]any{"name": "cli"}, code: "scopes_required"},
+		{name: "unknown scope", payload: map[string]any{"name": "cli", "scopes": []string{"admin"}}, code: "invalid_scope"},
+		{name: "past expiry", payload: map[string]any{"name": "cli", "scopes": []string{"config:sync"}, "expiresAt": time.Now().Add(-time.Hour)}, code: "invalid_expiry"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := createDeviceTokenForTest(t, router, sessionToken, tc.payload)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if resp := decodeResponse(t, rr); resp.Error != tc.code {
+				t.Fatalf("expected %s, got %q", tc.code, resp.Error)
+			}
+		})
+	}
+}
+
+func TestDeviceTokenConfigSync(t *testing.T) {
+	router, user, sessionToken := newDesktopSyncRouter(t, WithDesktopSync(DesktopSyncConfig{}))
+
+	rr := createDeviceTokenForTest(t, router, sessionToken, map[string]any{
+		"name":   "desktop",
+		"scopes": []string{"config:sync"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected device token creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created deviceTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode device token: %v", err)
+	}
+
+	secret := []byte(user.ID)
+	rr = postSync(t, router, created.Token, secret, newSyncRequest(t, 0, time.Now()))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected device token sync to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp := decodeSyncResponse(t, rr, secret); resp.Status != syncpayload.StatusOK {
+		t.Fatalf("expected OK status, got %d", resp.Status)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorizedRequest(http.MethodGet, "/api/auth/subscriptions", created.Token, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected sync-only token to be rejected for subscriptions, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -3,6 +3,11 @@ package api
 import (
 	"context"
 	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/mailer"
 )
 
 // EmailMessage represents the contents of an email notification.
@@ -34,3 +39,33 @@ var noopEmailSender EmailSender = EmailSenderFunc(func(ctx context.Context, msg
 	slog.Warn("email sender not configured; suppressing email delivery", "subject", msg.Subject)
 	return nil
 })
+
+type mailQueueMetricsReader interface {
+	Metrics(ctx context.Context) (mailer.QueueMetrics, error)
+}
+
+// WithMailQueue exposes the depth and failure counters of the outbound mail
+// queue to administrators.
+func WithMailQueue(reader mailQueueMetricsReader) Option {
+	return func(h *handler) {
+		h.mailQueue = reader
+	}
+}
+
+func (h *handler) adminMailQueueMetrics(c *gin.Context) {
+	if h.mailQueue == nil {
+		respondError(c, http.StatusServiceUnavailable, "mail_queue_unavailable", "mail queue is not configured")
+		return
+	}
+
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+
+	metrics, err := h.mailQueue.Metrics(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "mail_queue_unavailable", "failed to read mail queue metrics")
+		return
+	}
+	c.JSON(http.StatusOK, metrics)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/emailtemplate"
+	"account/internal/store"
+)
+
+// WithEmailTemplates replaces the built-in transactional email templates.
+func WithEmailTemplates(templates *emailtemplate.Set) Option {
+	return func(h *handler) {
+		if templates != nil {
+			h.emailTemplates = templates
+		}
+	}
+}
+
+// emailLocale selects the locale of an email sent on behalf of the current
+// request. The stored preference of the user wins over the Accept-Language
+// header of the request.
+func (h *handler) emailLocale(c *gin.Context, user *store.User) string {
+	var preferred []string
+	if user != nil {
+		preferred = append(preferred, user.Locale)
+	}
+	preferred = append(preferred, emailtemplate.ParseAcceptLanguage(c.GetHeader("Accept-Language"))...)
+	return h.emailTemplates.Match(preferred...)
+}
+
+// renderEmail renders template name for recipient.
+func (h *handler) renderEmail(recipient, name, locale string, data emailtemplate.Data) (EmailMessage, error) {
+	rendered, err := h.emailTemplates.Render(name, locale, data)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	return EmailMessage{
+		To:        []string{recipient},
+		Subject:   rendered.Subject,
+		PlainBody: rendered.PlainBody,
+		HTMLBody:  rendered.HTMLBody,
+	}, nil
+}
+
+// adminListEmailTemplates lists the email templates and the locales they can
+// be previewed in.
+func (h *handler) adminListEmailTemplates(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"templates":     h.emailTemplates.Names(),
+		"locales":       h.emailTemplates.Locales(),
+		"defaultLocale": emailtemplate.DefaultLocale,
+	})
+}
+
+// adminPreviewEmailTemplate renders a template with sample data so that
+// overrides can be checked before they reach users.
+func (h *handler) adminPreviewEmailTemplate(c *gin.Context) {
+	admin, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	locale := strings.TrimSpace(c.Query("locale"))
+	if locale == "" {
+		locale = h.emailLocale(c, nil)
+	} else {
+		locale = h.emailTemplates.Match(locale)
+	}
+
+	name := c.Param("name")
+	ttl, code := h.verificationTTL, "123456"
+	if name == emailtemplate.PasswordReset {
+		ttl, code = h.resetTTL, strings.Repeat("0123456789abcdef", 4)
+	}
+	data := emailtemplate.Data{
+		Name:             admin.Name,
+		Email:            admin.Email,
+		Code:             code,
+		ExpiresAt:        time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		ExpiresInMinutes: int(ttl.Minutes()),
+	}
+
+	rendered, err := h.emailTemplates.Render(name, locale, data)
+	if err != nil {
+		if errors.Is(err, emailtemplate.ErrTemplateNotFound) {
+			respondError(c, http.StatusNotFound, "email_template_not_found", "email template not found")
+			return
+		}
+		respondError(c, http.StatusUnprocessableEntity, "email_template_render_failed", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":    name,
+		"locale":  locale,
+		"subject": rendered.Subject,
+		"text":    rendered.PlainBody,
+		"html":    rendered.HTMLBody,
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+func TestEmailLocaleSelection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	mailer := &testEmailSender{}
+	RegisterRoutes(router, WithStore(st), WithEmailSender(mailer))
+
+	post := func(path, acceptLanguage string, payload any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("/api/auth/register/send", "zh-CN,zh;q=0.9,en;q=0.8", map[string]string{"email": "li@example.com"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected verification send success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	msg, _ := mailer.last()
+	if !strings.Contains(msg.Subject, "验证") {
+		t.Fatalf("expected a zh-CN verification email, got %q", msg.Subject)
+	}
+	code := extractVerificationCodeFromMessage(t, msg)
+
+	rr = post("/api/auth/register/verify", "", map[string]string{"email": "li@example.com", "code": code})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected verification success, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The explicit preference is stored and outranks Accept-Language later on.
+	rr = post("/api/auth/register", "", map[string]string{
+		"name":     "li",
+		"email":    "li@example.com",
+		"password": "supersecure",
+		"code":     code,
+		"locale":   "zh",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected registration success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	user, err := st.GetUserByEmail(context.Background(), "li@example.com")
+	if err != nil || user.Locale != "zh-CN" {
+		t.Fatalf("expected stored zh-CN preference, got %+v (%v)", user, err)
+	}
+
+	rr = post("/api/auth/password/reset", "en-US", map[string]string{"email": "li@example.com"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected password reset request to return 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	msg, _ = mailer.last()
+	if !strings.Contains(msg.Subject, "重置") || !strings.Contains(msg.HTMLBody, "li，") {
+		t.Fatalf("expected a zh-CN reset email, got %+v", msg)
+	}
+	extractTokenFromMessage(t, msg)
+}
+
+func TestAdminEmailTemplatePreview(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "admin", store.RoleAdmin)
+	user := f.createUser(t, "user", store.RoleUser)
+	adminToken := f.session(t, admin)
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/email-templates", f.session(t, user), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admin to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/email-templates", adminToken, nil)
+	var listing struct {
+		Templates []string `json:"templates"`
+		Locales   []string `json:"locales"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected template listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(listing.Templates) != 3 || len(listing.Locales) != 2 {
+		t.Fatalf("unexpected template listing: %+v", listing)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/email-templates/password_reset/preview?locale=zh-CN", adminToken, nil)
+	var preview struct {
+		Locale  string `json:"locale"`
+		Subject string `json:"subject"`
+		Text    string `json:"text"`
+		HTML    string `json:"html"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected preview, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if preview.Locale != "zh-CN" || preview.Subject == "" || !strings.Contains(preview.Text, "admin") || !strings.Contains(preview.HTML, "<strong>") {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/email-templates/unknown/preview", adminToken, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown template to return 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"account/internal/mailer"
+	"account/internal/store"
+)
+
+type stubMailQueue struct {
+	metrics mailer.QueueMetrics
+}
+
+func (s stubMailQueue) Metrics(context.Context) (mailer.QueueMetrics, error) {
+	return s.metrics, nil
+}
+
+func TestAdminMailQueueMetrics(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	admin := f.createUser(t, "admin", store.RoleAdmin)
+
+	rr := f.do(http.MethodGet, "/api/auth/admin/mail/queue", f.session(t, admin), nil)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected unconfigured queue to return 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	f = newAdminUsersFixture(t, WithMailQueue(stubMailQueue{metrics: mailer.QueueMetrics{Pending: 3, DeadLettered: 1, Failed: 4}}))
+	admin = f.createUser(t, "admin", store.RoleAdmin)
+	user := f.createUser(t, "user", store.RoleUser)
+
+	if rr := f.do(http.MethodGet, "/api/auth/admin/mail/queue", f.session(t, user), nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admin to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = f.do(http.MethodGet, "/api/auth/admin/mail/queue", f.session(t, admin), nil)
+	var metrics mailer.QueueMetrics
+	if err := json.Unmarshal(rr.Body.Bytes(), &metrics); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected metrics, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if metrics.Pending != 3 || metrics.DeadLettered != 1 || metrics.Failed != 4 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
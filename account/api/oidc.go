@@ -0,0 +1,536 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/oidc"
+	"account/internal/store"
+)
+
+const (
+	oidcModeLogin = "login"
+	oidcModeLink  = "link"
+
+	defaultOIDCAuthorizationTTL = 10 * time.Minute
+	maxOIDCUserNameLength       = 32
+
+	oidcStateCookieName = "xc_oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc"
+)
+
+var oidcUserNameInvalidChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// WithOIDCProviders enables federated sign in through the given OpenID
+// Connect providers. Without this option the OIDC endpoints respond with 404.
+func WithOIDCProviders(providers ...*oidc.Provider) Option {
+	return func(h *handler) {
+		for _, provider := range providers {
+			if provider == nil {
+				continue
+			}
+			if h.oidcProviders == nil {
+				h.oidcProviders = make(map[string]*oidc.Provider)
+			}
+			if _, exists := h.oidcProviders[provider.Name()]; !exists {
+				h.oidcProviderOrder = append(h.oidcProviderOrder, provider.Name())
+			}
+			h.oidcProviders[provider.Name()] = provider
+		}
+	}
+}
+
+func (h *handler) requireOIDCProvider(c *gin.Context) (*oidc.Provider, bool) {
+	if len(h.oidcProviders) == 0 {
+		respondError(c, http.StatusNotFound, "oidc_disabled", "federated login is not enabled")
+		return nil, false
+	}
+	provider, ok := h.oidcProviders[strings.ToLower(strings.TrimSpace(c.Param("provider")))]
+	if !ok {
+		respondError(c, http.StatusNotFound, "oidc_provider_not_found", "identity provider not found")
+		return nil, false
+	}
+	return provider, true
+}
+
+func (h *handler) listOIDCProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(h.oidcProviderOrder))
+	for _, name := range h.oidcProviderOrder {
+		providers = append(providers, gin.H{
+			"name":        name,
+			"displayName": h.oidcProviders[name].DisplayName(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+func (h *handler) beginOIDCLogin(c *gin.Context) {
+	provider, ok := h.requireOIDCProvider(c)
+	if !ok {
+		return
+	}
+	h.startOIDCAuthorization(c, provider, oidcModeLogin, "")
+}
+
+// beginOIDCLink starts an authorization request whose callback links the
+// provider account to the signed in user instead of signing in.
+func (h *handler) beginOIDCLink(c *gin.Context) {
+	provider, ok := h.requireOIDCProvider(c)
+	if !ok {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	h.startOIDCAuthorization(c, provider, oidcModeLink, user.ID)
+}
+
+func (h *handler) startOIDCAuthorization(c *gin.Context, provider *oidc.Provider, mode, userID string) {
+	state, errState := oidc.NewNonce()
+	nonce, errNonce := oidc.NewNonce()
+	verifier, errVerifier := oidc.NewCodeVerifier()
+	if err := errors.Join(errState, errNonce, errVerifier); err != nil {
+		respondError(c, http.StatusInternalServerError, "oidc_authorization_failed", "failed to start federated login")
+		return
+	}
+
+	ctx := c.Request.Context()
+	authorizationURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		slog.Error("failed to build oidc authorization url", "err", err, "provider", provider.Name())
+		respondError(c, http.StatusBadGateway, "oidc_provider_unavailable", "identity provider is unavailable")
+		return
+	}
+
+	expiresAt := time.Now().Add(defaultOIDCAuthorizationTTL)
+	if err := h.storeOIDCAuthorization(ctx, state, oidcAuthorizationRecord{
+		Provider:     provider.Name(),
+		Mode:         mode,
+		UserID:       userID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    expiresAt,
+	}); err != nil {
+		respondError(c, http.StatusInternalServerError, "oidc_authorization_failed", "failed to start federated login")
+		return
+	}
+	setOIDCStateCookie(c, oidcStateBinding(state), int(defaultOIDCAuthorizationTTL.Seconds()))
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorizationUrl": authorizationURL,
+		"expiresAt":        expiresAt.UTC(),
+	})
+}
+
+// finishOIDC completes the authorization code flow. The page behind the
+// provider's redirect URL posts the code and state it received here.
+func (h *handler) finishOIDC(c *gin.Context) {
+	provider, ok := h.requireOIDCProvider(c)
+	if !ok {
+		return
+	}
+
+	var req oidcCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	code := strings.TrimSpace(req.Code)
+	if code == "" {
+		respondError(c, http.StatusBadRequest, "invalid_request", "authorization code is required")
+		return
+	}
+
+	// Only the browser that started the flow holds the state cookie, so a
+	// callback URL from someone else's flow cannot sign a victim in to, or
+	// link, the attacker's provider account.
+	binding, err := c.Cookie(oidcStateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(binding), []byte(oidcStateBinding(req.State))) != 1 {
+		respondError(c, http.StatusUnauthorized, "oidc_state_mismatch", "federated login was started in another browser")
+		return
+	}
+	setOIDCStateCookie(c, "", -1)
+
+	ctx := c.Request.Context()
+	authorization, ok := h.takeOIDCAuthorization(ctx, req.State)
+	if !ok || authorization.Provider != provider.Name() {
+		respondError(c, http.StatusUnauthorized, "invalid_oidc_state", "federated login state is invalid or expired")
+		return
+	}
+
+	// The browser may have switched accounts since it started a link, so
+	// the callback has to come from a session of the same user.
+	if authorization.Mode == oidcModeLink {
+		user, ok := h.requireAuthenticatedUser(c)
+		if !ok {
+			return
+		}
+		if user.ID != authorization.UserID {
+			respondError(c, http.StatusForbidden, "oidc_session_mismatch", "federated link was started by another account")
+			return
+		}
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, code, authorization.CodeVerifier)
+	if err != nil {
+		slog.Warn("oidc token exchange failed", "err", err, "provider", provider.Name())
+		h.recordLoginFailure(c, "", nil, "oidc_exchange_failed")
+		respondError(c, http.StatusUnauthorized, "oidc_exchange_failed", "identity provider rejected the sign in")
+		return
+	}
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, authorization.Nonce)
+	if err != nil {
+		slog.Warn("oidc id token rejected", "err", err, "provider", provider.Name())
+		h.recordLoginFailure(c, "", nil, "invalid_id_token")
+		respondError(c, http.StatusUnauthorized, "invalid_id_token", "identity provider returned an invalid token")
+		return
+	}
+
+	if authorization.Mode == oidcModeLink {
+		h.linkOIDCIdentity(c, provider, authorization.UserID, claims)
+		return
+	}
+	h.signInWithOIDC(c, provider, claims)
+}
+
+// oidcStateBinding is the value of the state cookie; the state itself stays
+// out of cookies that outlive the flow.
+func oidcStateBinding(state string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(state)))
+	return hex.EncodeToString(sum[:])
+}
+
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, value, maxAge, oidcStateCookiePath, "", secure, true)
+}
+
+func (h *handler) linkOIDCIdentity(c *gin.Context, provider *oidc.Provider, userID string, claims *oidc.Claims) {
+	ctx := c.Request.Context()
+	user, err := h.store.GetUserByID(ctx, userID)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "invalid_session", "session user no longer exists")
+		return
+	}
+
+	if existing, err := h.store.GetIdentity(ctx, provider.Name(), claims.Subject); err == nil {
+		if existing.UserID == user.ID {
+			c.JSON(http.StatusOK, gin.H{"identity": sanitizeIdentity(existing)})
+			return
+		}
+		respondError(c, http.StatusConflict, "identity_already_linked", "this account is linked to another user")
+		return
+	} else if !errors.Is(err, store.ErrIdentityNotFound) {
+		respondError(c, http.StatusInternalServerError, "identity_lookup_failed", "failed to look up identity")
+		return
+	}
+
+	identities, err := h.store.ListIdentitiesByUser(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "identity_lookup_failed", "failed to look up identity")
+		return
+	}
+	for _, identity := range identities {
+		if identity.Provider == provider.Name() {
+			respondError(c, http.StatusConflict, "provider_already_linked", "another account of this provider is already linked")
+			return
+		}
+	}
+
+	identity := &store.Identity{UserID: user.ID, Provider: provider.Name(), ExternalID: claims.Subject}
+	if err := h.store.CreateIdentity(ctx, identity); err != nil {
+		if errors.Is(err, store.ErrIdentityExists) {
+			respondError(c, http.StatusConflict, "identity_already_linked", "this account is linked to another user")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "identity_link_failed", "failed to link identity")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionIdentityLink,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"provider": provider.Name(), "identityId": identity.ID},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"identity": sanitizeIdentity(identity)})
+}
+
+func (h *handler) signInWithOIDC(c *gin.Context, provider *oidc.Provider, claims *oidc.Claims) {
+	ctx := c.Request.Context()
+	identifier := provider.Name() + ":" + claims.Subject
+
+	var (
+		user    *store.User
+		created bool
+	)
+	identity, err := h.store.GetIdentity(ctx, provider.Name(), claims.Subject)
+	switch {
+	case err == nil:
+		if user, err = h.store.GetUserByID(ctx, identity.UserID); err != nil {
+			respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+			return
+		}
+	case errors.Is(err, store.ErrIdentityNotFound):
+		if existing, ok := h.rejectOIDCEmailCollision(c, claims); !ok {
+			h.recordLoginFailure(c, identifier, existing, "account_exists")
+			return
+		}
+		if user, err = h.createOIDCUser(c, provider, claims); err != nil {
+			return
+		}
+		created = true
+	default:
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+		return
+	}
+
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_locked")
+		return
+	}
+	if rejectDisabledUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_disabled")
+		return
+	}
+	if strings.TrimSpace(user.Email) != "" && !user.EmailVerified {
+		h.recordLoginFailure(c, identifier, user, "email_not_verified")
+		respondError(c, http.StatusUnauthorized, "email_not_verified", "email must be verified before login")
+		return
+	}
+
+	// The provider replaces the password, not the second factor.
+	var credentials []store.WebAuthnCredential
+	if h.webAuthn != nil {
+		if credentials, err = h.store.ListWebAuthnCredentials(ctx, user.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+			return
+		}
+	}
+	if factors := enrolledMFAFactors(user, credentials); len(factors) > 0 {
+		h.respondSecondFactorRequired(c, user, "mfa_required", "multi-factor authentication is required", factors)
+		return
+	}
+
+	token, expiresAt, err := h.createSession(ctx, user.ID, clientOf(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
+		return
+	}
+
+	h.setSessionCookie(c, token, expiresAt)
+	h.clearSignInFailures(c, user)
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionLogin,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata: map[string]any{
+			"identifier": identifier,
+			"mfa":        false,
+			"method":     "oidc",
+			"provider":   provider.Name(),
+			"created":    created,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "login successful",
+		"token":     token,
+		"expiresAt": expiresAt.UTC(),
+		"created":   created,
+		"user":      sanitizeUser(user, nil),
+	})
+}
+
+// rejectOIDCEmailCollision refuses to create a second account for a verified
+// email that already belongs to a user. Existing users link providers after
+// signing in so that a provider can never take over an account by asserting
+// its email address.
+func (h *handler) rejectOIDCEmailCollision(c *gin.Context, claims *oidc.Claims) (*store.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, true
+	}
+	existing, err := h.store.GetUserByEmail(c.Request.Context(), strings.ToLower(claims.Email))
+	if err == nil {
+		respondError(c, http.StatusConflict, "account_exists", "an account with this email already exists; sign in and link the provider instead")
+		return existing, false
+	}
+	if !errors.Is(err, store.ErrUserNotFound) {
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+		return nil, false
+	}
+	return nil, true
+}
+
+// createOIDCUser registers a user for a first federated sign in. The account
+// has no password; the user can set one through a password reset. Emails
+// are only copied when the provider verified them.
+func (h *handler) createOIDCUser(c *gin.Context, provider *oidc.Provider, claims *oidc.Claims) (*store.User, error) {
+	ctx := c.Request.Context()
+	user := &store.User{
+		Level:  store.LevelUser,
+		Role:   store.RoleUser,
+		Groups: []string{"User"},
+		Locale: h.emailLocale(c, nil),
+	}
+	if claims.Email != "" && claims.EmailVerified {
+		user.Email = strings.ToLower(claims.Email)
+		user.EmailVerified = true
+	}
+
+	base := oidcUserNameBase(provider.Name(), claims)
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		user.Name = base
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			if _, err = rand.Read(suffix); err != nil {
+				break
+			}
+			user.Name = base + "-" + hex.EncodeToString(suffix)
+		}
+		if err = h.store.CreateUser(ctx, user); !errors.Is(err, store.ErrNameExists) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrEmailExists) {
+			respondError(c, http.StatusConflict, "account_exists", "an account with this email already exists; sign in and link the provider instead")
+			return nil, err
+		}
+		slog.Error("failed to create user for oidc sign in", "err", err, "provider", provider.Name())
+		respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
+		return nil, err
+	}
+
+	if err := h.store.CreateIdentity(ctx, &store.Identity{UserID: user.ID, Provider: provider.Name(), ExternalID: claims.Subject}); err != nil {
+		// A concurrent first sign in may have linked the identity already;
+		// drop the orphaned account so the retry signs into that one.
+		if deleteErr := h.store.DeleteUser(ctx, user.ID); deleteErr != nil {
+			slog.Error("failed to remove user after identity link failure", "err", deleteErr, "userID", user.ID)
+		}
+		respondError(c, http.StatusConflict, "identity_link_failed", "failed to link identity, please retry")
+		return nil, err
+	}
+
+	h.provisionTrialSubscription(ctx, user)
+	return user, nil
+}
+
+// oidcUserNameBase derives a user name from the profile claims, falling back
+// to the provider name.
+func oidcUserNameBase(providerName string, claims *oidc.Claims) string {
+	candidates := []string{claims.PreferredUsername, claims.Name}
+	if at := strings.Index(claims.Email, "@"); at > 0 {
+		candidates = append(candidates, claims.Email[:at])
+	}
+	for _, candidate := range candidates {
+		name := strings.Trim(oidcUserNameInvalidChars.ReplaceAllString(strings.ToLower(candidate), "-"), "-._")
+		if len(name) > maxOIDCUserNameLength {
+			name = strings.Trim(name[:maxOIDCUserNameLength], "-._")
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return providerName + "-user"
+}
+
+func (h *handler) listIdentities(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	identities, err := h.store.ListIdentitiesByUser(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "identity_lookup_failed", "failed to list identities")
+		return
+	}
+
+	payload := make([]gin.H, 0, len(identities))
+	for i := range identities {
+		payload = append(payload, sanitizeIdentity(&identities[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": payload})
+}
+
+// deleteIdentity unlinks a provider account. The last identity of an account
+// without a password cannot be removed because the user could no longer
+// sign in.
+func (h *handler) deleteIdentity(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := strings.TrimSpace(c.Param("id"))
+	identities, err := h.store.ListIdentitiesByUser(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "identity_lookup_failed", "failed to list identities")
+		return
+	}
+	var target *store.Identity
+	for i := range identities {
+		if identities[i].ID == id {
+			target = &identities[i]
+		}
+	}
+	if target == nil {
+		respondError(c, http.StatusNotFound, "identity_not_found", "identity not found")
+		return
+	}
+	if strings.TrimSpace(user.PasswordHash) == "" && len(identities) == 1 {
+		respondError(c, http.StatusConflict, "last_sign_in_method", "set a password before unlinking the last identity provider")
+		return
+	}
+
+	if err := h.store.DeleteIdentity(ctx, user.ID, id); err != nil {
+		if errors.Is(err, store.ErrIdentityNotFound) {
+			respondError(c, http.StatusNotFound, "identity_not_found", "identity not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "identity_unlink_failed", "failed to unlink identity")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionIdentityUnlink,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"provider": target.Provider, "identityId": target.ID},
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func sanitizeIdentity(identity *store.Identity) gin.H {
+	return gin.H{
+		"id":         identity.ID,
+		"provider":   identity.Provider,
+		"externalId": identity.ExternalID,
+		"createdAt":  identity.CreatedAt.UTC(),
+	}
+}
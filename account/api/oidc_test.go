@@ -0,0 +1,338 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"account/internal/oidc"
+	"account/internal/oidc/oidctest"
+	"account/internal/store"
+)
+
+type oidcResponse struct {
+	Error            string   `json:"error"`
+	Token            string   `json:"token"`
+	Created          bool     `json:"created"`
+	AuthorizationURL string   `json:"authorizationUrl"`
+	MFAToken         string   `json:"mfaToken"`
+	Methods          []string `json:"methods"`
+	RecoveryCodes    []string `json:"recoveryCodes"`
+	User             struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"user"`
+	Identity struct {
+		ID string `json:"id"`
+	} `json:"identity"`
+	Identities []struct {
+		ID       string `json:"id"`
+		Provider string `json:"provider"`
+	} `json:"identities"`
+}
+
+func decodeOIDCResponse(t *testing.T, body []byte) oidcResponse {
+	t.Helper()
+	var resp oidcResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func newOIDCFixture(t *testing.T) (*adminUsersFixture, *oidctest.Provider) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	idp, err := oidctest.New("account", "s3cret")
+	if err != nil {
+		t.Fatalf("failed to start identity provider: %v", err)
+	}
+	t.Cleanup(idp.Close)
+	provider, err := oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "example",
+		DisplayName:  "Example ID",
+		Issuer:       idp.Issuer(),
+		ClientID:     "account",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://accounts.example.com/oidc/callback",
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to configure provider: %v", err)
+	}
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false), WithOIDCProviders(provider))
+	return &adminUsersFixture{router: router, store: st}, idp
+}
+
+// oidcFlow starts a login, or a link when session is set, signs identity in
+// at the provider and posts the callback from the same session.
+func oidcFlow(t *testing.T, f *adminUsersFixture, idp *oidctest.Provider, session string, identity oidctest.Identity) (int, oidcResponse) {
+	t.Helper()
+	path := "/api/auth/oidc/example/login"
+	if session != "" {
+		path = "/api/auth/oidc/example/link"
+	}
+	rr := f.do(http.MethodPost, path, session, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected authorization url, got %d: %s", rr.Code, rr.Body.String())
+	}
+	code, state, err := idp.Authorize(decodeOIDCResponse(t, rr.Body.Bytes()).AuthorizationURL, identity)
+	if err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+
+	rr = postOIDCCallback(f, session, rr, code, state)
+	return rr.Code, decodeOIDCResponse(t, rr.Body.Bytes())
+}
+
+// postOIDCCallback posts the callback from the browser that received the
+// begin response, carrying its state cookie along.
+func postOIDCCallback(f *adminUsersFixture, session string, begin *httptest.ResponseRecorder, code, state string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code, "state": state})
+	req := authorizedRequest(http.MethodPost, "/api/auth/oidc/example/callback", session, body)
+	if begin != nil {
+		for _, cookie := range begin.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+	}
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOIDCLoginCreatesUserOnFirstSignIn(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	identity := oidctest.Identity{Subject: "subject-1", Email: "Carol@Example.com", EmailVerified: true, PreferredUsername: "Carol Smith"}
+
+	rr := f.do(http.MethodGet, "/api/auth/oidc/providers", "", nil)
+	if rr.Code != http.StatusOK || !json.Valid(rr.Body.Bytes()) {
+		t.Fatalf("expected provider list, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	status, resp := oidcFlow(t, f, idp, "", identity)
+	if status != http.StatusOK || resp.Token == "" || !resp.Created {
+		t.Fatalf("expected first sign in to create an account, got %d: %+v", status, resp)
+	}
+	if resp.User.Name != "carol-smith" || resp.User.Email != "carol@example.com" {
+		t.Fatalf("unexpected profile for created user: %+v", resp.User)
+	}
+	userID := resp.User.ID
+
+	status, resp = oidcFlow(t, f, idp, "", identity)
+	if status != http.StatusOK || resp.Created || resp.User.ID != userID {
+		t.Fatalf("expected second sign in to reuse the account, got %d: %+v", status, resp)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/identities", resp.Token, nil)
+	listed := decodeOIDCResponse(t, rr.Body.Bytes())
+	if rr.Code != http.StatusOK || len(listed.Identities) != 1 || listed.Identities[0].Provider != "example" {
+		t.Fatalf("expected linked identity to be listed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Without a password the only identity cannot be unlinked.
+	rr = f.do(http.MethodDelete, "/api/auth/identities/"+listed.Identities[0].ID, resp.Token, nil)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected last sign in method to be kept, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDCCallbackRejectsReusedState(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+
+	begin := f.do(http.MethodPost, "/api/auth/oidc/example/login", "", nil)
+	code, state, err := idp.Authorize(decodeOIDCResponse(t, begin.Body.Bytes()).AuthorizationURL, oidctest.Identity{Subject: "subject-1"})
+	if err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+	rr := postOIDCCallback(f, "", begin, code, state)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected sign in without email to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = postOIDCCallback(f, "", begin, code, state)
+	if resp := decodeOIDCResponse(t, rr.Body.Bytes()); rr.Code != http.StatusUnauthorized || resp.Error != "invalid_oidc_state" {
+		t.Fatalf("expected replayed state to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/oidc/unknown/login", "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown provider to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestOIDCLinkAndUnlink(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	bob := f.createUser(t, "bob", store.RoleUser)
+	identity := oidctest.Identity{Subject: "alice-at-idp", Email: alice.Email, EmailVerified: true}
+
+	// A verified email of an existing account does not sign in or create a
+	// duplicate; the owner has to link the provider first.
+	if status, resp := oidcFlow(t, f, idp, "", identity); status != http.StatusConflict || resp.Error != "account_exists" {
+		t.Fatalf("expected existing email to require linking, got %d: %+v", status, resp)
+	}
+
+	aliceSession := f.session(t, alice)
+	status, resp := oidcFlow(t, f, idp, aliceSession, identity)
+	if status != http.StatusCreated || resp.Identity.ID == "" {
+		t.Fatalf("expected identity to be linked, got %d: %+v", status, resp)
+	}
+	identityID := resp.Identity.ID
+
+	if status, resp := oidcFlow(t, f, idp, "", identity); status != http.StatusOK || resp.User.ID != alice.ID || resp.Created {
+		t.Fatalf("expected linked identity to sign in as alice, got %d: %+v", status, resp)
+	}
+	if status, resp := oidcFlow(t, f, idp, f.session(t, bob), identity); status != http.StatusConflict || resp.Error != "identity_already_linked" {
+		t.Fatalf("expected identity of another user to be refused, got %d: %+v", status, resp)
+	}
+
+	rr := f.do(http.MethodDelete, "/api/auth/identities/"+identityID, f.session(t, bob), nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users to be unable to unlink, got %d", rr.Code)
+	}
+	rr = f.do(http.MethodDelete, "/api/auth/identities/"+identityID, aliceSession, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected identity to be unlinked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if status, _ := oidcFlow(t, f, idp, "", identity); status != http.StatusConflict {
+		t.Fatalf("expected unlinked identity to stop signing in, got %d", status)
+	}
+}
+
+func TestOIDCLinkCallbackRequiresInitiatingUser(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	mallory := f.createUser(t, "mallory", store.RoleUser)
+	aliceSession := f.session(t, alice)
+
+	start := func() (*httptest.ResponseRecorder, string, string) {
+		rr := f.do(http.MethodPost, "/api/auth/oidc/example/link", f.session(t, mallory), nil)
+		code, state, err := idp.Authorize(decodeOIDCResponse(t, rr.Body.Bytes()).AuthorizationURL, oidctest.Identity{Subject: "mallory-at-idp"})
+		if err != nil {
+			t.Fatalf("authorization failed: %v", err)
+		}
+		return rr, code, state
+	}
+
+	// Even from the browser that started it, a link begun from mallory's
+	// account is refused once alice is signed in there, and without any
+	// session.
+	begin, code, state := start()
+	rr := postOIDCCallback(f, aliceSession, begin, code, state)
+	if resp := decodeOIDCResponse(t, rr.Body.Bytes()); rr.Code != http.StatusForbidden || resp.Error != "oidc_session_mismatch" {
+		t.Fatalf("expected callback in another user's session to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	begin, code, state = start()
+	rr = postOIDCCallback(f, "", begin, code, state)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected callback without a session to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, user := range []*store.User{alice, mallory} {
+		identities, err := f.store.ListIdentitiesByUser(t.Context(), user.ID)
+		if err != nil || len(identities) != 0 {
+			t.Fatalf("expected no identity to be linked to %s, got %+v (%v)", user.Name, identities, err)
+		}
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	aliceSession := f.session(t, alice)
+
+	// Mallory starts a sign in with her provider account and sends the
+	// callback to alice, whose browser only holds the cookie of its own flow.
+	malloryBegin := f.do(http.MethodPost, "/api/auth/oidc/example/login", "", nil)
+	code, state, err := idp.Authorize(decodeOIDCResponse(t, malloryBegin.Body.Bytes()).AuthorizationURL, oidctest.Identity{Subject: "mallory-at-idp"})
+	if err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+	aliceBegin := f.do(http.MethodPost, "/api/auth/oidc/example/link", aliceSession, nil)
+	for _, browser := range []*httptest.ResponseRecorder{nil, aliceBegin} {
+		for _, session := range []string{"", aliceSession} {
+			rr := postOIDCCallback(f, session, browser, code, state)
+			if resp := decodeOIDCResponse(t, rr.Body.Bytes()); rr.Code != http.StatusUnauthorized || resp.Error != "oidc_state_mismatch" {
+				t.Fatalf("expected callback from another browser to be refused, got %d: %s", rr.Code, rr.Body.String())
+			}
+		}
+	}
+
+	if identities, err := f.store.ListIdentitiesByUser(t.Context(), alice.ID); err != nil || len(identities) != 0 {
+		t.Fatalf("expected no identity to be linked to alice, got %+v (%v)", identities, err)
+	}
+	// The refused callbacks do not use up mallory's own flow.
+	if rr := postOIDCCallback(f, "", malloryBegin, code, state); rr.Code != http.StatusOK {
+		t.Fatalf("expected the starting browser to complete the sign in, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	enableTOTP(t, f, alice)
+	identity := oidctest.Identity{Subject: "alice-at-idp", Email: alice.Email, EmailVerified: true}
+
+	// The link endpoint needs a session, which the TOTP login provides.
+	rr := f.do(http.MethodPost, "/api/auth/login", "", map[string]string{"identifier": alice.Email, "password": "supersecure", "totpCode": currentTOTPCode(t, f, alice)})
+	if status, _ := oidcFlow(t, f, idp, decodeOIDCResponse(t, rr.Body.Bytes()).Token, identity); status != http.StatusCreated {
+		t.Fatalf("expected identity to be linked, got %d", status)
+	}
+
+	status, resp := oidcFlow(t, f, idp, "", identity)
+	if status != http.StatusUnauthorized || resp.Error != "mfa_required" || resp.MFAToken == "" || resp.Token != "" {
+		t.Fatalf("expected federated sign in to require mfa, got %d: %+v", status, resp)
+	}
+
+	// The token only completes the sign in; it cannot replace the enrolled
+	// authenticator app with one chosen by whoever holds it.
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/provision", "", map[string]string{"token": resp.MFAToken})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected provisioning with a login token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	waitForStableTOTPWindow(t)
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": resp.MFAToken, "code": currentTOTPCode(t, f, alice)})
+	verified := decodeOIDCResponse(t, rr.Body.Bytes())
+	if rr.Code != http.StatusOK || verified.Token == "" {
+		t.Fatalf("expected totp to complete the sign in, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(verified.RecoveryCodes) != 0 {
+		t.Fatal("expected existing recovery codes to be kept when completing a sign in")
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	rr := f.do(http.MethodPost, "/api/auth/oidc/example/login", "", nil)
+	if resp := decodeOIDCResponse(t, rr.Body.Bytes()); rr.Code != http.StatusNotFound || resp.Error != "oidc_disabled" {
+		t.Fatalf("expected federated login to be disabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func currentTOTPCode(t *testing.T, f *adminUsersFixture, user *store.User) string {
+	t.Helper()
+	stored, err := f.store.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	code, err := totp.GenerateCodeCustom(stored.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate totp code: %v", err)
+	}
+	return code
+}
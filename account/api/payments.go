@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/payment"
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+// maxPaymentWebhookBytes bounds the size of a webhook body.
+const maxPaymentWebhookBytes = 1 << 20
+
+// paymentEventClaimLease is how long a delivery may spend applying an event
+// before a redelivery takes it over, e.g. after the replica handling it
+// crashed.
+const paymentEventClaimLease = 5 * time.Minute
+
+// WithPaymentWebhooks enables webhook ingestion for the given provider
+// adapters at /api/auth/payments/webhooks/{name}.
+func WithPaymentWebhooks(adapters ...payment.Adapter) Option {
+	return func(h *handler) {
+		for _, adapter := range adapters {
+			if adapter == nil {
+				continue
+			}
+			if h.paymentWebhooks == nil {
+				h.paymentWebhooks = make(map[string]payment.Adapter)
+			}
+			h.paymentWebhooks[adapter.Name()] = adapter
+		}
+	}
+}
+
+// receivePaymentWebhook verifies a provider webhook, records it in the
+// payment event log and applies it to the subscription it refers to. Events
+// already processed are acknowledged without being applied again; events
+// whose processing failed are retried when the provider redelivers them.
+// Each event is claimed before it is applied, so concurrent deliveries apply
+// it at most once; a delivery arriving while another one is in flight is
+// answered with 409 for the provider to retry later.
+func (h *handler) receivePaymentWebhook(c *gin.Context) {
+	provider := strings.TrimSpace(c.Param("provider"))
+	adapter, ok := h.paymentWebhooks[provider]
+	if !ok {
+		respondError(c, http.StatusNotFound, "unknown_provider", "payment provider is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPaymentWebhookBytes))
+	if err != nil {
+		respondError(c, http.StatusRequestEntityTooLarge, "payload_too_large", "webhook payload is too large")
+		return
+	}
+	if err := adapter.Verify(c.Request.Header, body); err != nil {
+		slog.Warn("rejected payment webhook", "provider", provider, "err", err)
+		respondError(c, http.StatusUnauthorized, "invalid_signature", "webhook signature verification failed")
+		return
+	}
+	event, err := adapter.Parse(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_payload", "webhook payload could not be parsed")
+		return
+	}
+
+	ctx := c.Request.Context()
+	record := &store.PaymentEvent{Provider: provider, EventID: event.ID, Type: event.Type, Payload: body}
+	if err := h.store.RecordPaymentEvent(ctx, record); err != nil {
+		if !errors.Is(err, store.ErrPaymentEventExists) {
+			slog.Error("failed to record payment event", "err", err, "provider", provider, "eventID", event.ID)
+			respondError(c, http.StatusInternalServerError, "event_log_failed", "failed to record webhook event")
+			return
+		}
+		if record.ProcessedAt != nil && record.Result != store.PaymentEventResultFailed {
+			c.JSON(http.StatusOK, gin.H{"eventId": event.ID, "result": record.Result, "duplicate": true})
+			return
+		}
+		if err := h.store.ClaimPaymentEvent(ctx, record.ID, time.Now().UTC(), paymentEventClaimLease); err != nil {
+			if errors.Is(err, store.ErrPaymentEventClaimed) {
+				respondError(c, http.StatusConflict, "event_in_progress", "webhook event is already being processed")
+				return
+			}
+			slog.Error("failed to claim payment event", "err", err, "provider", provider, "eventID", event.ID)
+			respondError(c, http.StatusInternalServerError, "event_log_failed", "failed to record webhook event")
+			return
+		}
+	}
+
+	userID, result, reason, err := h.applyPaymentEvent(ctx, provider, event)
+	if err != nil {
+		slog.Error("failed to apply payment event", "err", err, "provider", provider, "eventID", event.ID)
+		if completeErr := h.store.CompletePaymentEvent(ctx, record.ID, time.Now().UTC(), store.PaymentEventResultFailed, err.Error()); completeErr != nil {
+			slog.Error("failed to update payment event", "err", completeErr, "eventID", event.ID)
+		}
+		respondError(c, http.StatusInternalServerError, "event_processing_failed", "failed to apply webhook event")
+		return
+	}
+	if err := h.store.CompletePaymentEvent(ctx, record.ID, time.Now().UTC(), result, reason); err != nil {
+		slog.Error("failed to update payment event", "err", err, "eventID", event.ID)
+	}
+
+	if result == store.PaymentEventResultApplied {
+		h.recordAudit(c, store.AuditEvent{
+			SubjectID: userID,
+			Action:    auditActionSubscriptionWebhook,
+			Outcome:   store.AuditOutcomeSuccess,
+			Metadata: map[string]any{
+				"provider":   provider,
+				"eventId":    event.ID,
+				"eventType":  event.Type,
+				"externalId": event.Subscription.ExternalID,
+				"status":     event.Subscription.Status,
+			},
+		})
+	}
+
+	response := gin.H{"eventId": event.ID, "result": result}
+	if reason != "" {
+		response["reason"] = reason
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// applyPaymentEvent maps a provider event onto the subscription store. Events
+// that cannot be applied, such as unknown subscriptions or transitions that
+// arrive out of order, are reported as ignored with a reason; only storage
+// failures are returned as errors so that the provider retries.
+func (h *handler) applyPaymentEvent(ctx context.Context, provider string, event *payment.Event) (userID, result, reason string, err error) {
+	if event.Action == payment.ActionIgnore {
+		return "", store.PaymentEventResultIgnored, "unsupported event type", nil
+	}
+	update := event.Subscription
+
+	userID = update.UserID
+	if userID == "" {
+		owned, err := h.store.FindSubscriptionByExternalID(ctx, provider, update.ExternalID)
+		if errors.Is(err, store.ErrSubscriptionNotFound) {
+			return "", store.PaymentEventResultIgnored, "subscription owner is unknown", nil
+		}
+		if err != nil {
+			return "", "", "", err
+		}
+		userID = owned.UserID
+	} else if _, err := h.store.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return "", store.PaymentEventResultIgnored, "user not found", nil
+		}
+		return "", "", "", err
+	}
+
+	existing, err := h.findSubscription(ctx, userID, update.ExternalID)
+	if err != nil {
+		return userID, "", "", err
+	}
+	// Providers do not guarantee delivery order; an event older than the one
+	// last applied would roll the subscription back.
+	if existing != nil && existing.ProviderUpdatedAt != nil && event.OccurredAt.Before(*existing.ProviderUpdatedAt) {
+		return userID, store.PaymentEventResultIgnored, "stale event", nil
+	}
+
+	if event.Action == payment.ActionCancel {
+		if existing == nil {
+			return userID, store.PaymentEventResultIgnored, "subscription not found", nil
+		}
+		_, err = h.store.CancelSubscription(ctx, userID, update.ExternalID, event.OccurredAt)
+	} else {
+		err = h.store.UpsertSubscription(ctx, h.mergePaymentUpdate(provider, existing, userID, update, event.OccurredAt))
+	}
+	switch {
+	case errors.Is(err, store.ErrInvalidSubscriptionTransition):
+		return userID, store.PaymentEventResultIgnored, err.Error(), nil
+	case err != nil:
+		return userID, "", "", err
+	}
+	return userID, store.PaymentEventResultApplied, "", nil
+}
+
+// mergePaymentUpdate overlays the provider's view of a subscription on the
+// stored one. Fields the provider did not report keep their stored values.
+func (h *handler) mergePaymentUpdate(provider string, existing *store.Subscription, userID string, update payment.SubscriptionUpdate, occurredAt time.Time) *store.Subscription {
+	sub := &store.Subscription{
+		UserID:        userID,
+		Provider:      provider,
+		PaymentMethod: provider,
+		ExternalID:    update.ExternalID,
+		Meta:          map[string]any{},
+	}
+	if existing != nil {
+		sub.PaymentMethod = existing.PaymentMethod
+		sub.PaymentQRCode = existing.PaymentQRCode
+		sub.Kind = existing.Kind
+		sub.PlanID = existing.PlanID
+		for key, value := range existing.Meta {
+			sub.Meta[key] = value
+		}
+	}
+	for key, value := range update.Meta {
+		sub.Meta[key] = value
+	}
+	if update.PlanID != "" {
+		sub.PlanID = update.PlanID
+	}
+	if update.PaymentQRCode != "" {
+		sub.PaymentQRCode = update.PaymentQRCode
+	}
+	sub.Status = update.Status
+	sub.CurrentPeriodStart = update.CurrentPeriodStart
+	sub.CurrentPeriodEnd = update.CurrentPeriodEnd
+	if !occurredAt.IsZero() {
+		providerAt := occurredAt.UTC()
+		sub.ProviderUpdatedAt = &providerAt
+	}
+
+	plan, err := h.subscriptionPlans.Lookup(sub.PlanID)
+	if err == nil {
+		if sub.Kind == "" {
+			sub.Kind = plan.Kind
+		}
+		if sub.CurrentPeriodEnd == nil {
+			sub.CurrentPeriodStart, sub.CurrentPeriodEnd = subscriptionPeriod(plan, existing, sub.Status, occurredAt)
+		}
+	}
+	if sub.Kind == "" {
+		sub.Kind = subscription.KindSubscription
+	}
+	return sub
+}
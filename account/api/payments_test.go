@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"account/internal/payment"
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// recordedPayload loads a captured provider webhook and points it at userID.
+func recordedPayload(t *testing.T, name, userID string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("..", "internal", "payment", "testdata", name))
+	if err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return bytes.ReplaceAll(body, []byte("USER_ID"), []byte(userID))
+}
+
+type webhookResponse struct {
+	EventID   string `json:"eventId"`
+	Result    string `json:"result"`
+	Reason    string `json:"reason"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+func (f *adminUsersFixture) postWebhook(t *testing.T, provider string, header http.Header, body []byte) (int, webhookResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/payments/webhooks/"+provider, bytes.NewReader(body))
+	req.Header = header
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, req)
+	var resp webhookResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp
+}
+
+func stripeHeader(body []byte) http.Header {
+	header := http.Header{}
+	header.Set(payment.StripeSignatureHeader, payment.SignStripePayload(testWebhookSecret, time.Now(), body))
+	return header
+}
+
+func TestPaymentWebhookReplaysRecordedStripeEvents(t *testing.T) {
+	stripe, err := payment.NewStripeAdapter(payment.Config{Name: "stripe", Secret: testWebhookSecret})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	catalog, err := subscription.NewCatalog([]subscription.Plan{{ID: "MONTHLY", Period: 30 * 24 * time.Hour}})
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithPaymentWebhooks(stripe), WithSubscriptionPlans(catalog))
+	user := f.createUser(t, "payer", store.RoleUser)
+	ctx := context.Background()
+
+	updated := recordedPayload(t, "stripe_subscription_updated.json", user.ID)
+	if code, _ := f.postWebhook(t, "paypal", stripeHeader(updated), updated); code != http.StatusNotFound {
+		t.Fatalf("expected unknown provider to be rejected, got %d", code)
+	}
+	tampered := http.Header{}
+	tampered.Set(payment.StripeSignatureHeader, payment.SignStripePayload("whsec_other", time.Now(), updated))
+	if code, _ := f.postWebhook(t, "stripe", tampered, updated); code != http.StatusUnauthorized {
+		t.Fatalf("expected bad signature to be rejected, got %d", code)
+	}
+
+	code, resp := f.postWebhook(t, "stripe", stripeHeader(updated), updated)
+	if code != http.StatusOK || resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected event to be applied, got %d %+v", code, resp)
+	}
+	sub, err := f.store.FindSubscriptionByExternalID(ctx, "stripe", "sub_1Q2w3E4r5T6y7U8i")
+	if err != nil {
+		t.Fatalf("find subscription: %v", err)
+	}
+	if sub.UserID != user.ID || sub.Status != store.SubscriptionStatusActive || sub.PlanID != "MONTHLY" || sub.CurrentPeriodEnd == nil || sub.CurrentPeriodEnd.Unix() != 1769904000 {
+		t.Fatalf("unexpected subscription %+v", sub)
+	}
+
+	code, resp = f.postWebhook(t, "stripe", stripeHeader(updated), updated)
+	if code != http.StatusOK || !resp.Duplicate || resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected redelivery to be acknowledged as duplicate, got %d %+v", code, resp)
+	}
+
+	invoice := recordedPayload(t, "stripe_invoice_paid.json", user.ID)
+	if code, resp := f.postWebhook(t, "stripe", stripeHeader(invoice), invoice); code != http.StatusOK || resp.Result != store.PaymentEventResultIgnored {
+		t.Fatalf("expected unsupported event to be ignored, got %d %+v", code, resp)
+	}
+
+	// The deletion carries no user metadata; the owner is resolved from the
+	// stored subscription.
+	deleted := recordedPayload(t, "stripe_subscription_deleted.json", user.ID)
+	if code, resp := f.postWebhook(t, "stripe", stripeHeader(deleted), deleted); code != http.StatusOK || resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected cancellation to be applied, got %d %+v", code, resp)
+	}
+	if sub, _ = f.store.FindSubscriptionByExternalID(ctx, "stripe", "sub_1Q2w3E4r5T6y7U8i"); sub.Status != store.SubscriptionStatusCancelled || sub.CancelledAt == nil {
+		t.Fatalf("expected cancelled subscription, got %+v", sub)
+	}
+
+	// A late update delivered after the deletion must not revive it.
+	late := bytes.Replace(updated, []byte("evt_1Q2w3E4r5T6y7U8i"), []byte("evt_late"), 1)
+	if code, resp := f.postWebhook(t, "stripe", stripeHeader(late), late); code != http.StatusOK || resp.Result != store.PaymentEventResultIgnored {
+		t.Fatalf("expected out of order update to be ignored, got %d %+v", code, resp)
+	}
+
+	events, err := f.store.ListAuditEvents(ctx, store.AuditEventFilter{Action: auditActionSubscriptionWebhook})
+	if err != nil || len(events.Events) != 2 {
+		t.Fatalf("expected two applied webhook audit events, got %+v (%v)", events, err)
+	}
+}
+
+func TestPaymentWebhookWaitsForDeliveryInFlight(t *testing.T) {
+	stripe, err := payment.NewStripeAdapter(payment.Config{Name: "stripe", Secret: testWebhookSecret})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	catalog, err := subscription.NewCatalog([]subscription.Plan{{ID: "MONTHLY", Period: 30 * 24 * time.Hour}})
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithPaymentWebhooks(stripe), WithSubscriptionPlans(catalog))
+	user := f.createUser(t, "payer", store.RoleUser)
+	ctx := context.Background()
+
+	// Another replica recorded the delivery and is still applying it.
+	updated := recordedPayload(t, "stripe_subscription_updated.json", user.ID)
+	inFlight := &store.PaymentEvent{Provider: "stripe", EventID: "evt_1Q2w3E4r5T6y7U8i", Payload: updated}
+	if err := f.store.RecordPaymentEvent(ctx, inFlight); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if code, resp := f.postWebhook(t, "stripe", stripeHeader(updated), updated); code != http.StatusConflict || resp.Result != "" {
+		t.Fatalf("expected redelivery to wait for the delivery in flight, got %d %+v", code, resp)
+	}
+	if _, err := f.store.FindSubscriptionByExternalID(ctx, "stripe", "sub_1Q2w3E4r5T6y7U8i"); !errors.Is(err, store.ErrSubscriptionNotFound) {
+		t.Fatalf("expected the event not to be applied twice, got %v", err)
+	}
+
+	// Once that delivery failed, the next one applies the event.
+	if err := f.store.CompletePaymentEvent(ctx, inFlight.ID, time.Now(), store.PaymentEventResultFailed, "boom"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if code, resp := f.postWebhook(t, "stripe", stripeHeader(updated), updated); code != http.StatusOK || resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected retried event to be applied, got %d %+v", code, resp)
+	}
+}
+
+func TestPaymentWebhookGenericAdapter(t *testing.T) {
+	generic, err := payment.NewGenericAdapter(payment.Config{Name: "alipay", Secret: testWebhookSecret})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithPaymentWebhooks(generic))
+	user := f.createUser(t, "payer", store.RoleUser)
+
+	body := recordedPayload(t, "generic_subscription_updated.json", user.ID)
+	timestamp, signature := payment.SignGenericPayload(testWebhookSecret, time.Now(), body)
+	header := http.Header{}
+	header.Set(payment.GenericTimestampHeader, timestamp)
+	header.Set(payment.GenericSignatureHeader, signature)
+	if code, resp := f.postWebhook(t, "alipay", header, body); code != http.StatusOK || resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected event to be applied, got %d %+v", code, resp)
+	}
+
+	subs, err := f.store.ListSubscriptionsByUser(context.Background(), user.ID)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("expected one subscription, got %+v (%v)", subs, err)
+	}
+	if sub := subs[0]; sub.Provider != "alipay" || sub.PaymentQRCode != "https://pay.example.com/qr/order-8842" || sub.Meta["channel"] != "alipay" {
+		t.Fatalf("unexpected subscription %+v", sub)
+	}
+}
+
+func TestPaymentWebhookRenewsExpiredSubscription(t *testing.T) {
+	generic, err := payment.NewGenericAdapter(payment.Config{Name: "alipay", Secret: testWebhookSecret})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithPaymentWebhooks(generic))
+	user := f.createUser(t, "payer", store.RoleUser)
+	ctx := context.Background()
+
+	post := func(id, occurredAt, status, periodEnd string) webhookResponse {
+		t.Helper()
+		body := []byte(`{"id": "` + id + `", "type": "subscription.updated", "occurredAt": "` + occurredAt + `",
+			"subscription": {"userId": "` + user.ID + `", "externalId": "order-1", "status": "` + status + `", "currentPeriodEnd": "` + periodEnd + `"}}`)
+		timestamp, signature := payment.SignGenericPayload(testWebhookSecret, time.Now(), body)
+		header := http.Header{}
+		header.Set(payment.GenericTimestampHeader, timestamp)
+		header.Set(payment.GenericSignatureHeader, signature)
+		code, resp := f.postWebhook(t, "alipay", header, body)
+		if code != http.StatusOK {
+			t.Fatalf("unexpected status %d for %s", code, id)
+		}
+		return resp
+	}
+
+	if resp := post("evt-1", "2026-01-01T00:00:00Z", "active", "2026-01-31T00:00:00Z"); resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected subscription to be created, got %+v", resp)
+	}
+	// The expiry job runs before the renewal arrives.
+	if expired, err := f.store.ExpireSubscriptions(ctx, time.Date(2026, 1, 31, 0, 1, 0, 0, time.UTC)); err != nil || len(expired) != 1 {
+		t.Fatalf("expected subscription to expire, got %+v (%v)", expired, err)
+	}
+
+	if resp := post("evt-2", "2026-01-31T00:05:00Z", "active", "2026-03-02T00:00:00Z"); resp.Result != store.PaymentEventResultApplied {
+		t.Fatalf("expected late renewal to be applied, got %+v", resp)
+	}
+	// A delivery older than the renewal must not roll it back.
+	if resp := post("evt-3", "2026-01-20T00:00:00Z", "past_due", "2026-01-31T00:00:00Z"); resp.Result != store.PaymentEventResultIgnored {
+		t.Fatalf("expected stale event to be ignored, got %+v", resp)
+	}
+
+	sub, err := f.store.FindSubscriptionByExternalID(ctx, "alipay", "order-1")
+	if err != nil {
+		t.Fatalf("find subscription: %v", err)
+	}
+	if sub.Status != store.SubscriptionStatusActive || sub.CurrentPeriodEnd == nil || !sub.CurrentPeriodEnd.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected renewed subscription, got %+v", sub)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/ratelimit"
+	"account/internal/store"
+)
+
+// Rate limited endpoints. Each scope has its own buckets so that, for example,
+// password reset requests do not consume login attempts.
+const (
+	rateLimitScopeLogin             = "login"
+	rateLimitScopeRegister          = "register"
+	rateLimitScopeEmailVerification = "verification"
+	rateLimitScopePasswordReset     = "password_reset"
+)
+
+// RateLimitConfig configures brute-force protection for the public
+// authentication endpoints. Zero values fall back to the defaults returned by
+// DefaultRateLimitConfig.
+type RateLimitConfig struct {
+	// PerIP limits attempts from a single client address.
+	PerIP ratelimit.Limit
+	// PerIdentifier limits attempts against a single email or user name,
+	// regardless of the client address.
+	PerIdentifier ratelimit.Limit
+	// Lockout locks an account's sign in after repeated failed password or
+	// TOTP attempts.
+	Lockout ratelimit.LockoutPolicy
+}
+
+// DefaultRateLimitConfig returns the limits applied when none are configured.
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		PerIP:         ratelimit.Limit{Burst: 30, Period: time.Minute},
+		PerIdentifier: ratelimit.Limit{Burst: 10, Period: time.Minute},
+		Lockout: ratelimit.LockoutPolicy{
+			Threshold:   5,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      24 * time.Hour,
+		},
+	}
+}
+
+func (cfg RateLimitConfig) withDefaults() RateLimitConfig {
+	defaults := DefaultRateLimitConfig()
+	if cfg.PerIP == (ratelimit.Limit{}) {
+		cfg.PerIP = defaults.PerIP
+	}
+	if cfg.PerIdentifier == (ratelimit.Limit{}) {
+		cfg.PerIdentifier = defaults.PerIdentifier
+	}
+	if cfg.Lockout == (ratelimit.LockoutPolicy{}) {
+		cfg.Lockout = defaults.Lockout
+	}
+	return cfg
+}
+
+// WithRateLimiter replaces the default in-memory limiter, for example with a
+// Redis backed one shared by every replica. A nil limiter disables rate
+// limiting and lockouts.
+func WithRateLimiter(limiter ratelimit.Limiter, cfg RateLimitConfig) Option {
+	return func(h *handler) {
+		h.rateLimiter = limiter
+		h.rateLimits = cfg.withDefaults()
+	}
+}
+
+type rateLimitBucket struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+// allowAttempt applies the per-IP and per-identifier limits of scope. It
+// responds with 429 and returns false when either bucket is empty. Limiter
+// failures are logged and let the request through.
+func (h *handler) allowAttempt(c *gin.Context, scope, identifier string) bool {
+	if h.rateLimiter == nil {
+		return true
+	}
+
+	ctx := c.Request.Context()
+	buckets := []rateLimitBucket{{key: scope + ":ip:" + c.ClientIP(), limit: h.rateLimits.PerIP}}
+	if normalized := strings.ToLower(strings.TrimSpace(identifier)); normalized != "" {
+		buckets = append(buckets, rateLimitBucket{
+			key:   scope + ":id:" + hashRateLimitIdentifier(normalized),
+			limit: h.rateLimits.PerIdentifier,
+		})
+	}
+
+	for _, bucket := range buckets {
+		decision, err := h.rateLimiter.Allow(ctx, bucket.key, bucket.limit)
+		if err != nil {
+			slog.Error("rate limiter unavailable", "err", err, "scope", scope)
+			return true
+		}
+		if !decision.Allowed {
+			respondRetryAfter(c, decision.RetryAfter, "rate_limited", "too many requests, try again later")
+			return false
+		}
+	}
+	return true
+}
+
+// rejectLockedUser responds with 429 when repeated sign in failures have
+// locked user's account.
+func (h *handler) rejectLockedUser(c *gin.Context, user *store.User) bool {
+	if h.rateLimiter == nil || user == nil {
+		return false
+	}
+	remaining, err := h.rateLimiter.LockedFor(c.Request.Context(), lockoutKey(user))
+	if err != nil {
+		slog.Error("rate limiter unavailable", "err", err, "userID", user.ID)
+		return false
+	}
+	if remaining <= 0 {
+		return false
+	}
+	respondRetryAfter(c, remaining, "account_locked", "too many failed sign in attempts, try again later")
+	return true
+}
+
+// registerSignInFailure counts a failed password or TOTP attempt towards the
+// progressive lockout of user's account.
+func (h *handler) registerSignInFailure(c *gin.Context, user *store.User) {
+	if h.rateLimiter == nil || user == nil {
+		return
+	}
+	locked, err := h.rateLimiter.RegisterFailure(c.Request.Context(), lockoutKey(user), h.rateLimits.Lockout)
+	if err != nil {
+		slog.Error("failed to record sign in failure", "err", err, "userID", user.ID)
+		return
+	}
+	if locked > 0 {
+		slog.Warn("account locked after repeated sign in failures", "userID", user.ID, "duration", locked)
+	}
+}
+
+// clearSignInFailures resets the lockout progression after a successful sign
+// in.
+func (h *handler) clearSignInFailures(c *gin.Context, user *store.User) {
+	if h.rateLimiter == nil || user == nil {
+		return
+	}
+	if err := h.rateLimiter.Reset(c.Request.Context(), lockoutKey(user)); err != nil {
+		slog.Error("failed to reset sign in failures", "err", err, "userID", user.ID)
+	}
+}
+
+func lockoutKey(user *store.User) string {
+	return "signin:user:" + user.ID
+}
+
+// hashRateLimitIdentifier keeps raw emails out of limiter keys.
+func hashRateLimitIdentifier(identifier string) string {
+	sum := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(sum[:16])
+}
+
+func respondRetryAfter(c *gin.Context, retryAfter time.Duration, code, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	respondError(c, http.StatusTooManyRequests, code, message)
+}
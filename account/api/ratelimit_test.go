@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/ratelimit"
+	"account/internal/store"
+	"account/internal/webauthn"
+	"account/internal/webauthn/webauthntest"
+)
+
+func newRateLimitFixture(t *testing.T, cfg RateLimitConfig) *adminUsersFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false), WithRateLimiter(ratelimit.NewMemory(), cfg))
+	return &adminUsersFixture{router: router, store: st}
+}
+
+func postJSONFrom(router *gin.Engine, path, remoteAddr string, payload any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func assertRetryAfter(t *testing.T, rr *httptest.ResponseRecorder, code string) {
+	t.Helper()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp := decodeResponse(t, rr); resp.Error != code {
+		t.Fatalf("expected %s, got %q", code, resp.Error)
+	}
+	seconds, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		t.Fatalf("expected Retry-After in seconds, got %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestLoginLockoutAfterRepeatedFailures(t *testing.T) {
+	f := newRateLimitFixture(t, RateLimitConfig{
+		Lockout: ratelimit.LockoutPolicy{Threshold: 3, Duration: time.Minute, Window: time.Hour},
+	})
+	alice := f.createUser(t, "alice", store.RoleUser)
+
+	for i := 0; i < 3; i++ {
+		rr := postJSONFrom(f.router, "/api/auth/login", "192.0.2.1:1234", map[string]string{
+			"identifier": alice.Email, "password": "wrong-password",
+		})
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected invalid credentials on attempt %d, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+	}
+
+	// The lockout applies to the account even with the correct password and
+	// from another address.
+	rr := postJSONFrom(f.router, "/api/auth/login", "198.51.100.7:1234", map[string]string{
+		"identifier": alice.Email, "password": "supersecure",
+	})
+	assertRetryAfter(t, rr, "account_locked")
+
+	page, err := f.store.ListAuditEvents(context.Background(), store.AuditEventFilter{ActorID: alice.ID, Action: auditActionLogin})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	if page.Total != 4 || page.Events[0].Metadata["reason"] != "account_locked" {
+		t.Fatalf("expected lockout to be audited, got %+v", page.Events)
+	}
+}
+
+func TestPasswordResetRateLimits(t *testing.T) {
+	f := newRateLimitFixture(t, RateLimitConfig{
+		PerIP:         ratelimit.Limit{Burst: 2, Period: time.Minute},
+		PerIdentifier: ratelimit.Limit{Burst: 1, Period: time.Minute},
+	})
+
+	rr := postJSONFrom(f.router, "/api/auth/password/reset", "192.0.2.1:1234", map[string]string{"email": "a@example.com"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected first reset request to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = postJSONFrom(f.router, "/api/auth/password/reset", "198.51.100.7:1234", map[string]string{"email": "A@example.com"})
+	assertRetryAfter(t, rr, "rate_limited")
+
+	rr = postJSONFrom(f.router, "/api/auth/password/reset", "192.0.2.1:1234", map[string]string{"email": "b@example.com"})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected other identifier to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = postJSONFrom(f.router, "/api/auth/password/reset", "192.0.2.1:1234", map[string]string{"email": "c@example.com"})
+	assertRetryAfter(t, rr, "rate_limited")
+}
+
+func TestTOTPVerifyCountsTowardsAccountLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false),
+		WithRateLimiter(ratelimit.NewMemory(), RateLimitConfig{
+			Lockout: ratelimit.LockoutPolicy{Threshold: 3, Duration: time.Minute, Window: time.Hour},
+		}),
+		WithWebAuthn(webauthn.Config{RPID: testWebAuthnRPID, RPName: "XControl", Origins: []string{testWebAuthnOrigin}}),
+	)
+	f := &adminUsersFixture{router: router, store: st}
+	alice := f.createUser(t, "alice", store.RoleUser)
+	session := f.session(t, alice)
+	enableTOTP(t, f, alice)
+	registerSecurityKey(t, f, webauthntest.New(testWebAuthnRPID, testWebAuthnOrigin), session, "YubiKey")
+
+	// Every password sign in mints a fresh login challenge, so guesses spread
+	// across challenges must still lock the account.
+	tokens := make([]string, 4)
+	for i := range tokens {
+		rr := f.login(t, alice)
+		if tokens[i] = decodeResponse(t, rr).MFAToken; rr.Code != http.StatusUnauthorized || tokens[i] == "" {
+			t.Fatalf("expected a login challenge, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	code := currentTOTPCode(t, f, alice)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i, token := range tokens[:3] {
+		rr := f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": token, "code": wrong})
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected invalid code on attempt %d, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": tokens[3], "code": code})
+	assertRetryAfter(t, rr, "account_locked")
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+const (
+	// mfaRecoveryCodeCount is the number of codes issued per batch.
+	mfaRecoveryCodeCount = 10
+	// mfaRecoveryCodeLength is the number of base32 characters per code,
+	// giving 50 bits of entropy.
+	mfaRecoveryCodeLength = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns a batch of codes formatted for display, such as
+// "abcde-fghij", together with their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	hashes := make([]string, 0, mfaRecoveryCodeCount)
+	buffer := make([]byte, 7)
+	for len(codes) < mfaRecoveryCodeCount {
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buffer))[:mfaRecoveryCodeLength]
+		codes = append(codes, raw[:mfaRecoveryCodeLength/2]+"-"+raw[mfaRecoveryCodeLength/2:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so that codes can be typed
+// the way they were displayed or without separators.
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRecoveryCodes replaces the user's recovery codes with a new batch and
+// returns the plaintext codes, which are never shown again.
+func (h *handler) issueRecoveryCodes(ctx context.Context, user *store.User) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.ReplaceMFARecoveryCodes(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// redeemRecoveryCode consumes code and reports whether it was valid and
+// unused.
+func (h *handler) redeemRecoveryCode(ctx context.Context, user *store.User, code string) (bool, error) {
+	err := h.store.ConsumeMFARecoveryCode(ctx, user.ID, hashRecoveryCode(code), time.Now().UTC())
+	if errors.Is(err, store.ErrMFARecoveryCodeNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// regenerateRecoveryCodes invalidates the remaining recovery codes of the
+// signed in user and issues a new batch.
+func (h *handler) regenerateRecoveryCodes(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if !user.MFAEnabled {
+		respondError(c, http.StatusBadRequest, "mfa_not_enabled", "multi-factor authentication is not enabled")
+		return
+	}
+
+	codes, err := h.issueRecoveryCodes(c.Request.Context(), user)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "recovery_codes_generation_failed", "failed to generate recovery codes")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionMFARecoveryCodes,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"count": len(codes)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
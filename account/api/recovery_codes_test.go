@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"account/internal/oidc/oidctest"
+	"account/internal/store"
+)
+
+type recoveryCodesResponse struct {
+	Token                  string   `json:"token"`
+	Error                  string   `json:"error"`
+	RecoveryCodes          []string `json:"recoveryCodes"`
+	RecoveryCodesRemaining *int     `json:"recoveryCodesRemaining"`
+}
+
+func decodeRecoveryCodes(t *testing.T, body []byte) recoveryCodesResponse {
+	t.Helper()
+	var resp recoveryCodesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// enableTOTP provisions and confirms TOTP for user and returns the recovery
+// codes issued on confirmation.
+func enableTOTP(t *testing.T, f *adminUsersFixture, user *store.User) []string {
+	t.Helper()
+	rr := f.login(t, user)
+	mfaToken := decodeResponse(t, rr).MFAToken
+	if mfaToken == "" {
+		t.Fatalf("expected mfa token in login response: %s", rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/provision", "", map[string]string{"token": mfaToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected provisioning success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	secret := decodeResponse(t, rr).Secret
+
+	waitForStableTOTPWindow(t)
+	code, err := totp.GenerateCodeCustom(secret, time.Now().UTC(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate totp code: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": mfaToken, "code": code})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected verification success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	return decodeRecoveryCodes(t, rr.Body.Bytes()).RecoveryCodes
+}
+
+func loginWithRecoveryCode(f *adminUsersFixture, user *store.User, code string) (int, recoveryCodesResponse) {
+	rr := f.do(http.MethodPost, "/api/auth/login", "", map[string]string{
+		"identifier":   user.Email,
+		"password":     "supersecure",
+		"recoveryCode": code,
+	})
+	var resp recoveryCodesResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+
+	codes := enableTOTP(t, f, alice)
+	if len(codes) != mfaRecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %v", mfaRecoveryCodeCount, codes)
+	}
+
+	// Codes are accepted regardless of case and separators, exactly once.
+	status, resp := loginWithRecoveryCode(f, alice, strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")))
+	if status != http.StatusOK || resp.Token == "" {
+		t.Fatalf("expected recovery code login, got %d: %+v", status, resp)
+	}
+	if resp.RecoveryCodesRemaining == nil || *resp.RecoveryCodesRemaining != mfaRecoveryCodeCount-1 {
+		t.Fatalf("expected remaining recovery codes to be reported, got %+v", resp)
+	}
+	if status, resp := loginWithRecoveryCode(f, alice, codes[0]); status != http.StatusUnauthorized || resp.Error != "invalid_recovery_code" {
+		t.Fatalf("expected redeemed code to be rejected, got %d: %+v", status, resp)
+	}
+
+	session := resp.Token
+	rr := f.do(http.MethodGet, "/api/auth/mfa/status", session, nil)
+	var mfaStatus struct {
+		MFA struct {
+			RecoveryCodesRemaining int `json:"recoveryCodesRemaining"`
+		} `json:"mfa"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &mfaStatus); err != nil || mfaStatus.MFA.RecoveryCodesRemaining != mfaRecoveryCodeCount-1 {
+		t.Fatalf("expected mfa status to report remaining codes, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Regenerating invalidates the previous batch.
+	rr = f.do(http.MethodPost, "/api/auth/mfa/recovery-codes", session, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected regeneration success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	regenerated := decodeRecoveryCodes(t, rr.Body.Bytes()).RecoveryCodes
+	if len(regenerated) != mfaRecoveryCodeCount {
+		t.Fatalf("expected a new batch of recovery codes, got %v", regenerated)
+	}
+	if status, _ := loginWithRecoveryCode(f, alice, codes[1]); status != http.StatusUnauthorized {
+		t.Fatalf("expected previous batch to be invalidated, got %d", status)
+	}
+	if status, _ := loginWithRecoveryCode(f, alice, regenerated[0]); status != http.StatusOK {
+		t.Fatalf("expected new recovery code to be accepted, got %d", status)
+	}
+}
+
+func TestRegenerateRecoveryCodesRequiresMFA(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+
+	rr := f.do(http.MethodPost, "/api/auth/mfa/recovery-codes", f.session(t, alice), nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected regeneration without mfa to fail, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTOTPSignInKeepsRecoveryCodes(t *testing.T) {
+	f, idp := newOIDCFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	codes := enableTOTP(t, f, alice)
+	identity := oidctest.Identity{Subject: "alice-at-idp", Email: alice.Email, EmailVerified: true}
+	rr := f.do(http.MethodPost, "/api/auth/login", "", map[string]string{"identifier": alice.Email, "password": "supersecure", "totpCode": currentTOTPCode(t, f, alice)})
+	if status, _ := oidcFlow(t, f, idp, decodeOIDCResponse(t, rr.Body.Bytes()).Token, identity); status != http.StatusCreated {
+		t.Fatalf("expected identity to be linked, got %d", status)
+	}
+
+	// A later sign in completed through the TOTP verify endpoint must not
+	// replace the batch issued when MFA was first confirmed.
+	for attempt := 0; attempt < 2; attempt++ {
+		status, resp := oidcFlow(t, f, idp, "", identity)
+		if status != http.StatusUnauthorized || resp.MFAToken == "" {
+			t.Fatalf("expected the sign in to require a second factor, got %d: %+v", status, resp)
+		}
+		waitForStableTOTPWindow(t)
+		rr = f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": resp.MFAToken, "code": currentTOTPCode(t, f, alice)})
+		if verified := decodeRecoveryCodes(t, rr.Body.Bytes()); rr.Code != http.StatusOK || verified.Token == "" || len(verified.RecoveryCodes) != 0 {
+			t.Fatalf("expected a sign in without new recovery codes, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	if status, resp := loginWithRecoveryCode(f, alice, codes[0]); status != http.StatusOK || resp.RecoveryCodesRemaining == nil || *resp.RecoveryCodesRemaining != mfaRecoveryCodeCount-1 {
+		t.Fatalf("expected the original recovery codes to stay valid, got %d: %+v", status, resp)
+	}
+}
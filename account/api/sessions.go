@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+const (
+	// sessionTouchInterval limits how often request activity is written back
+	// to the session record.
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+)
+
+// sessionClient describes the client a session was created or last used
+// from.
+type sessionClient struct {
+	ip        string
+	userAgent string
+}
+
+func clientOf(c *gin.Context) sessionClient {
+	userAgent := strings.TrimSpace(c.Request.UserAgent())
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return sessionClient{ip: c.ClientIP(), userAgent: userAgent}
+}
+
+// listUserSessions returns the active sessions of the signed in user, most
+// recently used first.
+func (h *handler) listUserSessions(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.listSessions(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_lookup_failed", "failed to list sessions")
+		return
+	}
+
+	current := hashStateToken(h.resolveSessionToken(c))
+	payload := make([]gin.H, 0, len(sessions))
+	for digest, sess := range sessions {
+		payload = append(payload, sanitizeSession(sess, digest == current))
+	}
+	sort.Slice(payload, func(i, j int) bool {
+		left, right := payload[i]["lastSeenAt"].(time.Time), payload[j]["lastSeenAt"].(time.Time)
+		if !left.Equal(right) {
+			return left.After(right)
+		}
+		return payload[i]["id"].(string) < payload[j]["id"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"sessions": payload})
+}
+
+// revokeUserSession signs out a single session of the signed in user.
+func (h *handler) revokeUserSession(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	sessions, err := h.listSessions(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_lookup_failed", "failed to list sessions")
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	for digest, sess := range sessions {
+		if id == "" || sess.id != id {
+			continue
+		}
+		h.removeSessionDigest(ctx, digest)
+		h.recordAudit(c, store.AuditEvent{
+			ActorID:   user.ID,
+			SubjectID: user.ID,
+			Action:    auditActionSessionRevoke,
+			Outcome:   store.AuditOutcomeSuccess,
+			Metadata:  map[string]any{"sessionId": id, "current": digest == hashStateToken(h.resolveSessionToken(c))},
+		})
+		c.Status(http.StatusNoContent)
+		return
+	}
+	respondError(c, http.StatusNotFound, "session_not_found", "session not found")
+}
+
+// revokeOtherSessions signs out every session of the signed in user except
+// the one making the request.
+func (h *handler) revokeOtherSessions(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	revoked := h.removeSessionsForUser(c.Request.Context(), user.ID, h.resolveSessionToken(c))
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionSessionRevoke,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"scope": "others", "revoked": revoked},
+	})
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// revokeUserCredentials signs a user out everywhere after a security
+// sensitive change: every session except keepToken, every refresh token
+// family and every device token. The returned counts are meant for the
+// audit log; they are filled in as far as revocation got when it fails.
+func (h *handler) revokeUserCredentials(ctx context.Context, userID, keepToken string) (map[string]any, error) {
+	metadata := map[string]any{"sessionsRevoked": h.removeSessionsForUser(ctx, userID, keepToken)}
+	errRefresh := h.store.RevokeUserRefreshTokens(ctx, userID, time.Now().UTC())
+	if errRefresh != nil {
+		errRefresh = fmt.Errorf("revoke refresh tokens: %w", errRefresh)
+	}
+	devices, errDevices := h.store.DeleteUserDeviceTokens(ctx, userID)
+	if errDevices != nil {
+		errDevices = fmt.Errorf("revoke device tokens: %w", errDevices)
+	}
+	metadata["deviceTokensRevoked"] = devices
+	return metadata, errors.Join(errRefresh, errDevices)
+}
+
+func sanitizeSession(sess session, current bool) gin.H {
+	lastSeen := sess.lastSeenAt
+	if lastSeen.IsZero() {
+		lastSeen = sess.createdAt
+	}
+	return gin.H{
+		"id":         sess.id,
+		"createdAt":  sess.createdAt.UTC(),
+		"lastSeenAt": lastSeen.UTC(),
+		"expiresAt":  sess.expiresAt.UTC(),
+		"ip":         sess.ip,
+		"userAgent":  sess.userAgent,
+		"current":    current,
+	}
+}
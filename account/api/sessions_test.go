@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/cache"
+	"account/internal/store"
+)
+
+type sessionListResponse struct {
+	Sessions []struct {
+		ID        string `json:"id"`
+		IP        string `json:"ip"`
+		UserAgent string `json:"userAgent"`
+		Current   bool   `json:"current"`
+	} `json:"sessions"`
+}
+
+func loginFromClient(t *testing.T, f *adminUsersFixture, user *store.User, remoteAddr, userAgent string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"identifier": user.Email, "password": "supersecure"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	return decodeResponse(t, rr).Token
+}
+
+func listSessionsForTest(t *testing.T, f *adminUsersFixture, token string) sessionListResponse {
+	t.Helper()
+	rr := f.do(http.MethodGet, "/api/auth/sessions", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected session listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp sessionListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode sessions: %v", err)
+	}
+	return resp
+}
+
+func TestSessionListingAndRevocation(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	laptop := loginFromClient(t, f, alice, "198.51.100.7:40000", "Laptop Browser")
+	phone := loginFromClient(t, f, alice, "203.0.113.9:50000", "Phone App")
+	tablet := loginFromClient(t, f, alice, "203.0.113.10:50000", "Tablet")
+	other := f.session(t, f.createUser(t, "bob", store.RoleUser))
+
+	listed := listSessionsForTest(t, f, laptop)
+	if len(listed.Sessions) != 3 {
+		t.Fatalf("expected three sessions, got %+v", listed)
+	}
+	var phoneID string
+	for _, sess := range listed.Sessions {
+		// The listing request itself refreshes the client details of the
+		// current session.
+		if sess.Current == (sess.UserAgent == "Phone App" || sess.UserAgent == "Tablet") {
+			t.Fatalf("expected only the laptop session to be current, got %+v", listed)
+		}
+		if sess.UserAgent == "Phone App" {
+			phoneID = sess.ID
+			if sess.IP != "203.0.113.9" {
+				t.Fatalf("expected client address to be recorded, got %q", sess.IP)
+			}
+		}
+	}
+
+	if rr := f.do(http.MethodDelete, "/api/auth/sessions/"+phoneID, other, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected sessions of other users to be hidden, got %d", rr.Code)
+	}
+	if rr := f.do(http.MethodDelete, "/api/auth/sessions/"+phoneID, laptop, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected session revocation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/session", phone, nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session to be rejected, got %d", rr.Code)
+	}
+
+	rr := f.do(http.MethodDelete, "/api/auth/sessions", laptop, nil)
+	var revoked struct {
+		Revoked int `json:"revoked"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &revoked); err != nil || rr.Code != http.StatusOK || revoked.Revoked != 1 {
+		t.Fatalf("expected the tablet session to be revoked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/session", tablet, nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other sessions to be revoked, got %d", rr.Code)
+	}
+	if listed := listSessionsForTest(t, f, laptop); len(listed.Sessions) != 1 || !listed.Sessions[0].Current {
+		t.Fatalf("expected only the current session to remain, got %+v", listed)
+	}
+}
+
+// issueOtherCredentials gives user a refresh token and a device token and
+// returns the refresh token ID.
+func issueOtherCredentials(t *testing.T, st store.Store, user *store.User) string {
+	t.Helper()
+	ctx := context.Background()
+	refresh := &store.RefreshToken{ID: "refresh-" + user.ID, FamilyID: "family-" + user.ID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := st.CreateRefreshToken(ctx, refresh); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if err := st.CreateDeviceToken(ctx, &store.DeviceToken{UserID: user.ID, Name: "cli", TokenHash: hashDeviceToken(deviceTokenPrefix + user.ID)}); err != nil {
+		t.Fatalf("failed to create device token: %v", err)
+	}
+	return refresh.ID
+}
+
+func requireOtherCredentialsRevoked(t *testing.T, st store.Store, user *store.User, refreshID string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := st.ConsumeRefreshToken(ctx, refreshID, time.Now()); !errors.Is(err, store.ErrRefreshTokenRevoked) {
+		t.Fatalf("expected refresh tokens to be revoked, got %v", err)
+	}
+	if tokens, err := st.ListDeviceTokens(ctx, user.ID); err != nil || len(tokens) != 0 {
+		t.Fatalf("expected device tokens to be revoked, got %+v (%v)", tokens, err)
+	}
+}
+
+func TestPasswordResetRevokesSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemoryStore()
+	sessions := cache.NewMemory()
+	router := gin.New()
+	mailer := &testEmailSender{}
+	RegisterRoutes(router, WithStore(st), WithCache(sessions), WithEmailSender(mailer), WithEmailVerification(false))
+	f := &adminUsersFixture{router: router, store: st}
+	alice := f.createUser(t, "alice", store.RoleUser)
+	stale := f.session(t, alice)
+	refreshID := issueOtherCredentials(t, st, alice)
+
+	// A session created before sessions were indexed per user joins the
+	// index once it is used.
+	legacy, _ := json.Marshal(map[string]any{"userId": alice.ID, "expiresAt": time.Now().Add(time.Hour)})
+	if err := sessions.Set(context.Background(), sessionKeyPrefix+hashStateToken("legacy"), legacy, time.Hour); err != nil {
+		t.Fatalf("failed to seed legacy session: %v", err)
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/session", "legacy", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected the legacy session to work, got %d", rr.Code)
+	}
+
+	if rr := f.do(http.MethodPost, "/api/auth/password/reset", "", map[string]string{"email": alice.Email}); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected reset request to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	msg, ok := mailer.last()
+	if !ok {
+		t.Fatal("expected password reset email")
+	}
+	rr := f.do(http.MethodPost, "/api/auth/password/reset/confirm", "", map[string]string{
+		"token":    extractTokenFromMessage(t, msg),
+		"password": "evenmoresecure",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected reset confirmation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	fresh := decodeResponse(t, rr).Token
+
+	for _, token := range []string{stale, "legacy"} {
+		if rr := f.do(http.MethodGet, "/api/auth/session", token, nil); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected sessions from before the reset to be revoked, got %d", rr.Code)
+		}
+	}
+	requireOtherCredentialsRevoked(t, st, alice, refreshID)
+	if rr := f.do(http.MethodGet, "/api/auth/session", fresh, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected the session issued by the reset to work, got %d", rr.Code)
+	}
+}
+
+func TestDisableMFARevokesOtherSessions(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	enableTOTP(t, f, alice)
+
+	login := func() string {
+		rr := f.do(http.MethodPost, "/api/auth/login", "", map[string]string{
+			"identifier": alice.Email,
+			"password":   "supersecure",
+			"totpCode":   currentTOTPCode(t, f, alice),
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected mfa login, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return decodeResponse(t, rr).Token
+	}
+	current, other := login(), login()
+	refreshID := issueOtherCredentials(t, f.store, alice)
+
+	if rr := f.do(http.MethodPost, "/api/auth/mfa/disable", current, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected mfa to be disabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/session", other, nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other sessions to be revoked, got %d", rr.Code)
+	}
+	requireOtherCredentialsRevoked(t, f.store, alice, refreshID)
+	if rr := f.do(http.MethodGet, "/api/auth/session", current, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected the session that disabled mfa to remain, got %d", rr.Code)
+	}
+}
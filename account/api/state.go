@@ -0,0 +1,701 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"account/internal/cache"
+)
+
+// Cache key prefixes for the short-lived authentication state kept by the
+// handler. Keeping the layout in one place makes it easy to inspect a shared
+// Redis instance. Entries for bearer tokens (sessions, MFA challenges and
+// password resets) are keyed, and indexed per user, by hashStateToken so that
+// the tokens cannot be read back from the cache.
+const (
+	sessionKeyPrefix                  = "session:"
+	sessionUserIndexKeyPrefix         = "session-user:"
+	mfaChallengeKeyPrefix             = "mfa:"
+	mfaUserIndexKeyPrefix             = "mfa-user:"
+	emailVerificationKeyPrefix        = "verify:"
+	registrationVerificationKeyPrefix = "register:"
+	passwordResetKeyPrefix            = "reset:"
+	webAuthnCeremonyKeyPrefix         = "webauthn:"
+	oidcAuthorizationKeyPrefix        = "oidc:"
+)
+
+type sessionRecord struct {
+	ID         string    `json:"id,omitempty"`
+	UserID     string    `json:"userId"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt,omitempty"`
+	LastSeenAt time.Time `json:"lastSeenAt,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+type mfaChallengeRecord struct {
+	UserID         string    `json:"userId"`
+	Purpose        string    `json:"purpose,omitempty"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	TOTPSecret     string    `json:"totpSecret,omitempty"`
+	TOTPIssuer     string    `json:"totpIssuer,omitempty"`
+	TOTPAccount    string    `json:"totpAccount,omitempty"`
+	TOTPIssuedAt   time.Time `json:"totpIssuedAt,omitempty"`
+	FailedAttempts int       `json:"failedAttempts,omitempty"`
+	LockedUntil    time.Time `json:"lockedUntil,omitempty"`
+}
+
+type emailVerificationRecord struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type registrationVerificationRecord struct {
+	Email     string    `json:"email"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Verified  bool      `json:"verified"`
+}
+
+type passwordResetRecord struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type webAuthnCeremonyRecord struct {
+	Kind             string    `json:"kind"`
+	UserID           string    `json:"userId,omitempty"`
+	Name             string    `json:"name,omitempty"`
+	MFAToken         string    `json:"mfaToken,omitempty"`
+	Challenge        []byte    `json:"challenge"`
+	UserVerification bool      `json:"userVerification,omitempty"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+// oidcAuthorizationRecord is the pending authorization request identified by
+// the OAuth state parameter.
+type oidcAuthorizationRecord struct {
+	Provider     string    `json:"provider"`
+	Mode         string    `json:"mode"`
+	UserID       string    `json:"userId,omitempty"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"codeVerifier"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// hashStateToken derives the cache key of a bearer token.
+func hashStateToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+// putState encodes value as JSON and stores it until expiresAt.
+func (h *handler) putState(ctx context.Context, key string, value any, expiresAt time.Time) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return h.cache.Delete(ctx, key)
+	}
+	return h.cache.Set(ctx, key, payload, ttl)
+}
+
+// getState decodes the value stored under key. Missing entries and backend
+// failures are both reported as absent so callers fail closed.
+func (h *handler) getState(ctx context.Context, key string, value any) bool {
+	payload, err := h.cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			slog.Error("failed to read authentication state", "err", err)
+		}
+		return false
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		slog.Error("failed to decode authentication state", "err", err)
+		return false
+	}
+	return true
+}
+
+// takeState decodes and removes the value stored under key in one step, so
+// that concurrent requests on any replica redeem it at most once.
+func (h *handler) takeState(ctx context.Context, key string, value any) bool {
+	payload, err := h.cache.Take(ctx, key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			slog.Error("failed to read authentication state", "err", err)
+		}
+		return false
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		slog.Error("failed to decode authentication state", "err", err)
+		return false
+	}
+	return true
+}
+
+// updateState atomically applies update to the value stored under key.
+// update returns when the new value expires, or false to delete it. The
+// last decoded value is returned together with whether it was kept, so
+// callers can clean up after deleted entries. update may run more than once
+// when other replicas write the key concurrently.
+func updateState[T any](ctx context.Context, h *handler, key string, update func(*T) (time.Time, bool)) (T, bool) {
+	var (
+		value T
+		kept  bool
+	)
+	err := h.cache.Update(ctx, key, func(payload []byte) ([]byte, time.Duration, error) {
+		var zero T
+		value, kept = zero, false
+		if err := json.Unmarshal(payload, &value); err != nil {
+			return nil, 0, err
+		}
+		expiresAt, keep := update(&value)
+		ttl := time.Until(expiresAt)
+		if !keep || ttl <= 0 {
+			return nil, 0, nil
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, 0, err
+		}
+		kept = true
+		return encoded, ttl, nil
+	})
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			slog.Error("failed to update authentication state", "err", err)
+		}
+		var zero T
+		return zero, false
+	}
+	return value, kept
+}
+
+func (h *handler) deleteState(ctx context.Context, keys ...string) {
+	if err := h.cache.Delete(ctx, keys...); err != nil {
+		slog.Error("failed to delete authentication state", "err", err)
+	}
+}
+
+func (h *handler) createSession(ctx context.Context, userID string, client sessionClient) (string, time.Time, error) {
+	token, err := h.newRandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ttl := h.sessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	record := sessionRecord{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  now.UTC(),
+		LastSeenAt: now.UTC(),
+		IP:         client.ip,
+		UserAgent:  client.userAgent,
+	}
+	digest := hashStateToken(token)
+	if err := h.putState(ctx, sessionKeyPrefix+digest, record, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := h.cache.AddMember(ctx, sessionUserIndexKeyPrefix+userID, digest, ttl); err != nil {
+		slog.Error("failed to update session index", "err", err, "userID", userID)
+	}
+	return token, expiresAt, nil
+}
+
+func (h *handler) lookupSession(ctx context.Context, token string) (session, bool) {
+	if strings.TrimSpace(token) == "" {
+		return session{}, false
+	}
+	return h.lookupSessionDigest(ctx, hashStateToken(token))
+}
+
+func (h *handler) lookupSessionDigest(ctx context.Context, digest string) (session, bool) {
+	var record sessionRecord
+	if !h.getState(ctx, sessionKeyPrefix+digest, &record) {
+		return session{}, false
+	}
+	if time.Now().After(record.ExpiresAt) {
+		h.removeSessionDigest(ctx, digest)
+		return session{}, false
+	}
+	return session{
+		id:         record.ID,
+		userID:     record.UserID,
+		expiresAt:  record.ExpiresAt,
+		createdAt:  record.CreatedAt,
+		lastSeenAt: record.LastSeenAt,
+		ip:         record.IP,
+		userAgent:  record.UserAgent,
+	}, true
+}
+
+// touchSession records activity on a session. Writes are throttled to one per
+// sessionTouchInterval unless the client address or user agent changed. Each
+// write also adds the session to the user's index, so sessions created before
+// the index existed become revocable on their first use. The write is an
+// atomic update of the stored record, so a session revoked concurrently on
+// any replica stays revoked.
+func (h *handler) touchSession(ctx context.Context, token string, sess session, client sessionClient) {
+	now := time.Now()
+	if now.Sub(sess.lastSeenAt) < sessionTouchInterval && sess.ip == client.ip && sess.userAgent == client.userAgent {
+		return
+	}
+	digest := hashStateToken(token)
+	record, kept := updateState(ctx, h, sessionKeyPrefix+digest, func(record *sessionRecord) (time.Time, bool) {
+		record.LastSeenAt = now.UTC()
+		record.IP = client.ip
+		record.UserAgent = client.userAgent
+		return record.ExpiresAt, true
+	})
+	if !kept {
+		return
+	}
+	if err := h.cache.AddMember(ctx, sessionUserIndexKeyPrefix+record.UserID, digest, time.Until(record.ExpiresAt)); err != nil {
+		slog.Error("failed to update session index", "err", err, "userID", record.UserID)
+	}
+}
+
+func (h *handler) removeSession(ctx context.Context, token string) {
+	h.removeSessionDigest(ctx, hashStateToken(token))
+}
+
+func (h *handler) removeSessionDigest(ctx context.Context, digest string) {
+	var record sessionRecord
+	if h.getState(ctx, sessionKeyPrefix+digest, &record) && record.UserID != "" {
+		if err := h.cache.RemoveMembers(ctx, sessionUserIndexKeyPrefix+record.UserID, digest); err != nil {
+			slog.Error("failed to update session index", "err", err, "userID", record.UserID)
+		}
+	}
+	h.deleteState(ctx, sessionKeyPrefix+digest)
+}
+
+// listSessions returns the active sessions of a user keyed by the
+// hashStateToken digest of their token. Index entries whose session has
+// expired are pruned.
+func (h *handler) listSessions(ctx context.Context, userID string) (map[string]session, error) {
+	indexKey := sessionUserIndexKeyPrefix + userID
+	digests, err := h.cache.Members(ctx, indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make(map[string]session, len(digests))
+	stale := make([]string, 0)
+	for _, digest := range digests {
+		sess, ok := h.lookupSessionDigest(ctx, digest)
+		if !ok || sess.userID != userID {
+			stale = append(stale, digest)
+			continue
+		}
+		sessions[digest] = sess
+	}
+	if len(stale) > 0 {
+		if err := h.cache.RemoveMembers(ctx, indexKey, stale...); err != nil {
+			slog.Error("failed to prune session index", "err", err, "userID", userID)
+		}
+	}
+	return sessions, nil
+}
+
+// removeSessionsForUser revokes every session of a user except keepToken and
+// returns how many were revoked.
+func (h *handler) removeSessionsForUser(ctx context.Context, userID, keepToken string) int {
+	if userID == "" {
+		return 0
+	}
+	sessions, err := h.listSessions(ctx, userID)
+	if err != nil {
+		slog.Error("failed to list sessions for user", "err", err, "userID", userID)
+		return 0
+	}
+
+	keep := ""
+	if strings.TrimSpace(keepToken) != "" {
+		keep = hashStateToken(keepToken)
+	}
+	keys := make([]string, 0, len(sessions))
+	revoked := make([]string, 0, len(sessions))
+	for digest := range sessions {
+		if digest == keep {
+			continue
+		}
+		keys = append(keys, sessionKeyPrefix+digest)
+		revoked = append(revoked, digest)
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	h.deleteState(ctx, keys...)
+	if err := h.cache.RemoveMembers(ctx, sessionUserIndexKeyPrefix+userID, revoked...); err != nil {
+		slog.Error("failed to update session index", "err", err, "userID", userID)
+	}
+	return len(revoked)
+}
+
+func (h *handler) storeMFAChallenge(ctx context.Context, token string, challenge mfaChallenge) error {
+	digest := hashStateToken(token)
+	if err := h.putState(ctx, mfaChallengeKeyPrefix+digest, newMFAChallengeRecord(challenge), challenge.expiresAt); err != nil {
+		return err
+	}
+	return h.cache.AddMember(ctx, mfaUserIndexKeyPrefix+challenge.userID, digest, time.Until(challenge.expiresAt))
+}
+
+func newMFAChallengeRecord(challenge mfaChallenge) mfaChallengeRecord {
+	return mfaChallengeRecord{
+		UserID:         challenge.userID,
+		Purpose:        challenge.purpose,
+		ExpiresAt:      challenge.expiresAt,
+		TOTPSecret:     challenge.totpSecret,
+		TOTPIssuer:     challenge.totpIssuer,
+		TOTPAccount:    challenge.totpAccount,
+		TOTPIssuedAt:   challenge.totpIssuedAt,
+		FailedAttempts: challenge.failedAttempts,
+		LockedUntil:    challenge.lockedUntil,
+	}
+}
+
+func (record mfaChallengeRecord) challenge() mfaChallenge {
+	return mfaChallenge{
+		userID:         record.UserID,
+		purpose:        record.Purpose,
+		expiresAt:      record.ExpiresAt,
+		totpSecret:     record.TOTPSecret,
+		totpIssuer:     record.TOTPIssuer,
+		totpAccount:    record.TOTPAccount,
+		totpIssuedAt:   record.TOTPIssuedAt,
+		failedAttempts: record.FailedAttempts,
+		lockedUntil:    record.LockedUntil,
+	}
+}
+
+func (h *handler) loadMFAChallenge(ctx context.Context, token string) (mfaChallenge, bool) {
+	var record mfaChallengeRecord
+	if !h.getState(ctx, mfaChallengeKeyPrefix+hashStateToken(token), &record) {
+		return mfaChallenge{}, false
+	}
+	return record.challenge(), true
+}
+
+// updateMFAChallenge applies update to a pending challenge in a single atomic
+// cache update, so that concurrent verification attempts on any replica
+// never overwrite each other's failed attempt counts. Challenges that expired
+// or that update rejects are deleted.
+func (h *handler) updateMFAChallenge(ctx context.Context, token string, update func(*mfaChallenge) bool) (mfaChallenge, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return mfaChallenge{}, false
+	}
+
+	digest := hashStateToken(token)
+	record, kept := updateState(ctx, h, mfaChallengeKeyPrefix+digest, func(record *mfaChallengeRecord) (time.Time, bool) {
+		if time.Now().After(record.ExpiresAt) {
+			return time.Time{}, false
+		}
+		challenge := record.challenge()
+		if !update(&challenge) {
+			return time.Time{}, false
+		}
+		*record = newMFAChallengeRecord(challenge)
+		return challenge.expiresAt, true
+	})
+	if !kept {
+		if record.UserID != "" {
+			h.deleteMFAChallenge(ctx, token, record.UserID)
+		}
+		return mfaChallenge{}, false
+	}
+	if err := h.cache.AddMember(ctx, mfaUserIndexKeyPrefix+record.UserID, digest, time.Until(record.ExpiresAt)); err != nil {
+		slog.Error("failed to update mfa challenge index", "err", err, "userID", record.UserID)
+	}
+	return record.challenge(), true
+}
+
+// createMFAChallenge issues an MFA token for userID. purpose is one of
+// mfaPurposeEnroll or mfaPurposeLogin.
+func (h *handler) createMFAChallenge(ctx context.Context, userID, purpose string) (string, error) {
+	token, err := h.newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	ttl := h.effectiveMFAChallengeTTL()
+	challenge := mfaChallenge{userID: userID, purpose: purpose, expiresAt: time.Now().Add(ttl)}
+	if err := h.storeMFAChallenge(ctx, token, challenge); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (h *handler) lookupMFAChallenge(ctx context.Context, token string) (mfaChallenge, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return mfaChallenge{}, false
+	}
+	challenge, ok := h.loadMFAChallenge(ctx, token)
+	if !ok {
+		return mfaChallenge{}, false
+	}
+	if time.Now().After(challenge.expiresAt) {
+		h.deleteMFAChallenge(ctx, token, challenge.userID)
+		return mfaChallenge{}, false
+	}
+	return challenge, true
+}
+
+func (h *handler) refreshMFAChallenge(ctx context.Context, token string) (mfaChallenge, bool) {
+	ttl := h.effectiveMFAChallengeTTL()
+	return h.updateMFAChallenge(ctx, token, func(ch *mfaChallenge) bool {
+		ch.expiresAt = time.Now().Add(ttl)
+		return true
+	})
+}
+
+func (h *handler) deleteMFAChallenge(ctx context.Context, token, userID string) {
+	digest := hashStateToken(token)
+	h.deleteState(ctx, mfaChallengeKeyPrefix+digest)
+	if userID == "" {
+		return
+	}
+	if err := h.cache.RemoveMembers(ctx, mfaUserIndexKeyPrefix+userID, digest); err != nil {
+		slog.Error("failed to update mfa challenge index", "err", err, "userID", userID)
+	}
+}
+
+// takeMFAChallenge loads and removes a pending challenge so that each token
+// completes at most one sign in, even when requests race across replicas.
+func (h *handler) takeMFAChallenge(ctx context.Context, token string) (mfaChallenge, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return mfaChallenge{}, false
+	}
+
+	digest := hashStateToken(token)
+	var record mfaChallengeRecord
+	if !h.takeState(ctx, mfaChallengeKeyPrefix+digest, &record) {
+		return mfaChallenge{}, false
+	}
+	if record.UserID != "" {
+		if err := h.cache.RemoveMembers(ctx, mfaUserIndexKeyPrefix+record.UserID, digest); err != nil {
+			slog.Error("failed to update mfa challenge index", "err", err, "userID", record.UserID)
+		}
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return mfaChallenge{}, false
+	}
+	return record.challenge(), true
+}
+
+func (h *handler) removeMFAChallengesForUser(ctx context.Context, userID string) {
+	if userID == "" {
+		return
+	}
+
+	indexKey := mfaUserIndexKeyPrefix + userID
+	digests, err := h.cache.Members(ctx, indexKey)
+	if err != nil {
+		slog.Error("failed to list mfa challenges for user", "err", err, "userID", userID)
+		return
+	}
+	keys := make([]string, 0, len(digests)+1)
+	for _, digest := range digests {
+		keys = append(keys, mfaChallengeKeyPrefix+digest)
+	}
+	keys = append(keys, indexKey)
+	h.deleteState(ctx, keys...)
+}
+
+func (h *handler) storeEmailVerification(ctx context.Context, verification emailVerification) error {
+	record := emailVerificationRecord{
+		UserID:    verification.userID,
+		Email:     verification.email,
+		Code:      verification.code,
+		ExpiresAt: verification.expiresAt,
+	}
+	return h.putState(ctx, emailVerificationKeyPrefix+verification.email, record, verification.expiresAt)
+}
+
+func (h *handler) lookupEmailVerification(ctx context.Context, email string) (emailVerification, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return emailVerification{}, false
+	}
+
+	var record emailVerificationRecord
+	if !h.getState(ctx, emailVerificationKeyPrefix+email, &record) {
+		return emailVerification{}, false
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		h.removeEmailVerification(ctx, email)
+		return emailVerification{}, false
+	}
+
+	return emailVerification{
+		userID:    record.UserID,
+		email:     record.Email,
+		code:      record.Code,
+		expiresAt: record.ExpiresAt,
+	}, true
+}
+
+func (h *handler) removeEmailVerification(ctx context.Context, email string) {
+	h.deleteState(ctx, emailVerificationKeyPrefix+strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (h *handler) storeRegistrationVerification(ctx context.Context, verification registrationVerification) error {
+	record := registrationVerificationRecord{
+		Email:     verification.email,
+		Code:      verification.code,
+		ExpiresAt: verification.expiresAt,
+		Verified:  verification.verified,
+	}
+	return h.putState(ctx, registrationVerificationKeyPrefix+verification.email, record, verification.expiresAt)
+}
+
+func (h *handler) loadRegistrationVerification(ctx context.Context, email string) (registrationVerification, bool) {
+	var record registrationVerificationRecord
+	if !h.getState(ctx, registrationVerificationKeyPrefix+email, &record) {
+		return registrationVerification{}, false
+	}
+	return registrationVerification{
+		email:     record.Email,
+		code:      record.Code,
+		expiresAt: record.ExpiresAt,
+		verified:  record.Verified,
+	}, true
+}
+
+func (h *handler) lookupRegistrationVerification(ctx context.Context, email string) (registrationVerification, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return registrationVerification{}, false
+	}
+
+	verification, ok := h.loadRegistrationVerification(ctx, email)
+	if !ok {
+		return registrationVerification{}, false
+	}
+
+	if time.Now().After(verification.expiresAt) {
+		h.removeRegistrationVerification(ctx, email)
+		return registrationVerification{}, false
+	}
+
+	return verification, true
+}
+
+func (h *handler) markRegistrationVerified(ctx context.Context, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	_, ok := updateState(ctx, h, registrationVerificationKeyPrefix+email, func(record *registrationVerificationRecord) (time.Time, bool) {
+		record.Verified = true
+		return record.ExpiresAt, time.Now().Before(record.ExpiresAt)
+	})
+	return ok
+}
+
+func (h *handler) removeRegistrationVerification(ctx context.Context, email string) {
+	h.deleteState(ctx, registrationVerificationKeyPrefix+strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (h *handler) storePasswordReset(ctx context.Context, token string, reset passwordReset) error {
+	record := passwordResetRecord{
+		UserID:    reset.userID,
+		Email:     reset.email,
+		ExpiresAt: reset.expiresAt,
+	}
+	return h.putState(ctx, passwordResetKeyPrefix+hashStateToken(token), record, reset.expiresAt)
+}
+
+// takePasswordReset loads and removes a pending reset so that concurrent
+// requests on any replica redeem each token at most once.
+func (h *handler) takePasswordReset(ctx context.Context, token string) (passwordReset, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return passwordReset{}, false
+	}
+
+	var record passwordResetRecord
+	if !h.takeState(ctx, passwordResetKeyPrefix+hashStateToken(token), &record) {
+		return passwordReset{}, false
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return passwordReset{}, false
+	}
+
+	return passwordReset{
+		userID:    record.UserID,
+		email:     record.Email,
+		expiresAt: record.ExpiresAt,
+	}, true
+}
+
+func (h *handler) removePasswordReset(ctx context.Context, token string) {
+	h.deleteState(ctx, passwordResetKeyPrefix+hashStateToken(token))
+}
+
+func (h *handler) storeWebAuthnCeremony(ctx context.Context, token string, ceremony webAuthnCeremonyRecord) error {
+	return h.putState(ctx, webAuthnCeremonyKeyPrefix+token, ceremony, ceremony.ExpiresAt)
+}
+
+// takeWebAuthnCeremony loads and removes a pending ceremony so that each
+// challenge can be answered at most once.
+func (h *handler) takeWebAuthnCeremony(ctx context.Context, token, kind string) (webAuthnCeremonyRecord, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return webAuthnCeremonyRecord{}, false
+	}
+
+	var record webAuthnCeremonyRecord
+	if !h.takeState(ctx, webAuthnCeremonyKeyPrefix+token, &record) {
+		return webAuthnCeremonyRecord{}, false
+	}
+
+	if record.Kind != kind || time.Now().After(record.ExpiresAt) {
+		return webAuthnCeremonyRecord{}, false
+	}
+	return record, true
+}
+
+func (h *handler) storeOIDCAuthorization(ctx context.Context, state string, authorization oidcAuthorizationRecord) error {
+	return h.putState(ctx, oidcAuthorizationKeyPrefix+state, authorization, authorization.ExpiresAt)
+}
+
+// takeOIDCAuthorization loads and removes a pending authorization request so
+// that each state value is redeemed at most once.
+func (h *handler) takeOIDCAuthorization(ctx context.Context, state string) (oidcAuthorizationRecord, bool) {
+	state = strings.TrimSpace(state)
+	if state == "" {
+		return oidcAuthorizationRecord{}, false
+	}
+
+	var record oidcAuthorizationRecord
+	if !h.takeState(ctx, oidcAuthorizationKeyPrefix+state, &record) {
+		return oidcAuthorizationRecord{}, false
+	}
+	return record, true
+}
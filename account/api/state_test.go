@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/cache"
+	"account/internal/store"
+)
+
+func TestSessionsSharedAcrossReplicasThroughRedis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+	shared := cache.NewRedisWithClient(client, "")
+
+	st := store.NewMemoryStore()
+	replicaA := gin.New()
+	RegisterRoutes(replicaA, WithStore(st), WithCache(shared), WithEmailVerification(false))
+	replicaB := gin.New()
+	RegisterRoutes(replicaB, WithStore(st), WithCache(shared), WithEmailVerification(false))
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("supersecure"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "replica-user",
+		Email:         "replica@example.com",
+		EmailVerified: true,
+		PasswordHash:  string(hashed),
+	}
+	if err := st.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"identifier": user.Email, "password": "supersecure"})
+	if err != nil {
+		t.Fatalf("failed to marshal login payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	replicaA.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	token := decodeResponse(t, rr).Token
+	if token == "" {
+		t.Fatalf("expected session token in login response")
+	}
+
+	if !srv.Exists(cache.DefaultRedisPrefix + sessionKeyPrefix + hashStateToken(token)) {
+		t.Fatalf("expected session to be persisted in redis")
+	}
+	if ttl := srv.TTL(cache.DefaultRedisPrefix + sessionKeyPrefix + hashStateToken(token)); ttl <= 0 {
+		t.Fatalf("expected session key to carry a ttl, got %s", ttl)
+	}
+	// Neither the session key nor the per-user index reveals the token.
+	for _, key := range srv.Keys() {
+		if strings.Contains(key, token) {
+			t.Fatalf("expected the session token to stay out of key %q", key)
+		}
+		if members, err := srv.SMembers(key); err == nil && slices.Contains(members, token) {
+			t.Fatalf("expected the session token to stay out of index %q", key)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	replicaB.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected session lookup on second replica to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	replicaB.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected logout success, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	replicaA.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session on first replica, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStateUpdatesAreAtomicAcrossReplicas(t *testing.T) {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+	shared := cache.NewRedisWithClient(client, "")
+	replicas := []*handler{{cache: shared}, {cache: shared}}
+	ctx := context.Background()
+
+	token, err := replicas[0].createMFAChallenge(ctx, "user-1", mfaPurposeLogin)
+	if err != nil {
+		t.Fatalf("create mfa challenge: %v", err)
+	}
+	state := "oidc-state"
+	if err := replicas[0].storeOIDCAuthorization(ctx, state, oidcAuthorizationRecord{Provider: "test", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("store oidc authorization: %v", err)
+	}
+	resetToken := "reset-token"
+	if err := replicas[0].storePasswordReset(ctx, resetToken, passwordReset{userID: "user-1", email: "a@example.com", expiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("store password reset: %v", err)
+	}
+
+	const attempts = 8
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		redeemed int
+		resets   int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(h *handler) {
+			defer wg.Done()
+			h.updateMFAChallenge(ctx, token, func(ch *mfaChallenge) bool {
+				ch.failedAttempts++
+				return true
+			})
+			if _, ok := h.takeOIDCAuthorization(ctx, state); ok {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+			if _, ok := h.takePasswordReset(ctx, resetToken); ok {
+				mu.Lock()
+				resets++
+				mu.Unlock()
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+
+	challenge, ok := replicas[1].lookupMFAChallenge(ctx, token)
+	if !ok || challenge.failedAttempts != attempts {
+		t.Fatalf("expected %d failed attempts to be recorded, got %+v", attempts, challenge)
+	}
+	if redeemed != 1 {
+		t.Fatalf("expected the authorization to be redeemed once, got %d", redeemed)
+	}
+	if resets != 1 {
+		t.Fatalf("expected the password reset to be redeemed once, got %d", resets)
+	}
+}
+
+func TestTouchSessionDoesNotRestoreRevokedSession(t *testing.T) {
+	h := &handler{cache: cache.NewMemory()}
+	ctx := context.Background()
+
+	token, _, err := h.createSession(ctx, "user-1", sessionClient{ip: "198.51.100.1"})
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	sess, ok := h.lookupSession(ctx, token)
+	if !ok {
+		t.Fatal("expected session to exist")
+	}
+
+	// Another request revokes the session between the lookup and the
+	// activity write of this one.
+	h.removeSessionsForUser(ctx, "user-1", "")
+	h.touchSession(ctx, token, sess, sessionClient{ip: "203.0.113.7"})
+
+	if _, ok := h.lookupSession(ctx, token); ok {
+		t.Fatal("expected revoked session to stay revoked")
+	}
+	if sessions, err := h.listSessions(ctx, "user-1"); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions to be listed, got %v (%v)", sessions, err)
+	}
+}
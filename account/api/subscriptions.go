@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+// WithSubscriptionPlans replaces the plan catalogue. The onboarding trial is
+// kept available when the catalogue does not define it.
+func WithSubscriptionPlans(catalog *subscription.Catalog) Option {
+	return func(h *handler) {
+		if catalog != nil {
+			h.subscriptionPlans = catalog.WithDefaults()
+		}
+	}
+}
+
+func (h *handler) listSubscriptionPlans(c *gin.Context) {
+	plans := h.subscriptionPlans.Plans()
+	sanitized := make([]gin.H, 0, len(plans))
+	for _, plan := range plans {
+		sanitized = append(sanitized, sanitizePlan(plan))
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": sanitized})
+}
+
+// subscriptionPeriod returns the period a subscription entering status under
+// plan should be stamped with. A new period starts whenever a subscription
+// begins or moves into trialing or active; otherwise the stored one is kept.
+func subscriptionPeriod(plan subscription.Plan, existing *store.Subscription, status string, now time.Time) (*time.Time, *time.Time) {
+	if status != store.SubscriptionStatusTrialing && status != store.SubscriptionStatusActive {
+		return nil, nil
+	}
+	if existing != nil && existing.Status == status {
+		return nil, nil
+	}
+	start, end := plan.PeriodFrom(now)
+	return &start, end
+}
+
+type adminSubscriptionStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// adminUpdateSubscriptionStatus moves a user's subscription to another
+// lifecycle state, for payments settled outside a provider webhook. Entering
+// trialing or active starts a new period of the subscription's plan.
+func (h *handler) adminUpdateSubscriptionStatus(c *gin.Context) {
+	actor, ok := h.requireAdminOrOperator(c)
+	if !ok {
+		return
+	}
+
+	var req adminSubscriptionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	status, valid := store.NormalizeSubscriptionStatus(req.Status)
+	if !valid {
+		respondError(c, http.StatusBadRequest, "invalid_status", "unknown subscription status")
+		return
+	}
+
+	target, ok := h.loadAdminTargetUser(c)
+	if !ok || !h.authorizeAdminTarget(c, actor, target) {
+		return
+	}
+	ctx := c.Request.Context()
+	existing, err := h.findSubscription(ctx, target.ID, strings.TrimSpace(c.Param("externalId")))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "subscription_update_failed", "failed to update subscription")
+		return
+	}
+	if existing == nil {
+		respondError(c, http.StatusNotFound, "subscription_not_found", "subscription not found")
+		return
+	}
+
+	plan, err := h.subscriptionPlans.Lookup(existing.PlanID)
+	if err != nil && (status == store.SubscriptionStatusTrialing || status == store.SubscriptionStatusActive) {
+		respondError(c, http.StatusConflict, "unknown_plan", "subscription plan is not available")
+		return
+	}
+	sub := *existing
+	sub.Status = status
+	sub.CurrentPeriodStart, sub.CurrentPeriodEnd = subscriptionPeriod(plan, existing, status, time.Now())
+	if err := h.store.UpsertSubscription(ctx, &sub); err != nil {
+		if respondSubscriptionError(c, err) {
+			return
+		}
+		slog.Error("failed to update subscription", "err", err, "userID", target.ID, "actorID", actor.ID)
+		respondError(c, http.StatusInternalServerError, "subscription_update_failed", "failed to update subscription")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   actor.ID,
+		SubjectID: target.ID,
+		Action:    auditActionSubscriptionStatus,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"externalId": sub.ExternalID, "from": existing.Status, "status": sub.Status},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"subscription": sanitizeSubscription(&sub)})
+}
+
+func (h *handler) findSubscription(ctx context.Context, userID, externalID string) (*store.Subscription, error) {
+	subscriptions, err := h.store.ListSubscriptionsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range subscriptions {
+		if subscriptions[i].ExternalID == externalID {
+			return &subscriptions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// respondSubscriptionError maps lifecycle errors returned by the store and
+// reports whether err was one of them.
+func respondSubscriptionError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, store.ErrInvalidSubscriptionStatus):
+		respondError(c, http.StatusBadRequest, "invalid_status", "unknown subscription status")
+	case errors.Is(err, store.ErrInvalidSubscriptionTransition):
+		respondError(c, http.StatusConflict, "invalid_status_transition", "subscription cannot move to the requested status")
+	default:
+		return false
+	}
+	return true
+}
+
+func sanitizePlan(plan subscription.Plan) gin.H {
+	return gin.H{
+		"id":            plan.ID,
+		"name":          plan.Name,
+		"description":   strings.TrimSpace(plan.Description),
+		"kind":          plan.Kind,
+		"periodSeconds": int64(plan.Period / time.Second),
+		"price":         plan.Price,
+		"currency":      plan.Currency,
+		"trafficQuota":  plan.TrafficQuota,
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	catalog, err := subscription.NewCatalog([]subscription.Plan{{ID: "MONTHLY", Name: "Monthly", Period: 30 * 24 * time.Hour, Price: 500, Currency: "USD"}})
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithSubscriptionPlans(catalog))
+	user := f.createUser(t, "subscriber", store.RoleUser)
+	token := f.session(t, user)
+
+	rr := f.do(http.MethodGet, "/api/auth/subscriptions/plans", "", nil)
+	var plans struct {
+		Plans []struct {
+			ID            string `json:"id"`
+			PeriodSeconds int64  `json:"periodSeconds"`
+		} `json:"plans"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &plans); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected plans, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(plans.Plans) != 2 || plans.Plans[0].ID != "MONTHLY" || plans.Plans[1].ID != subscription.TrialPlanID || plans.Plans[0].PeriodSeconds != 30*24*3600 {
+		t.Fatalf("unexpected plans %+v", plans.Plans)
+	}
+
+	if rr := f.do(http.MethodPost, "/api/auth/subscriptions", token, map[string]any{"externalId": "sub-1", "planId": "YEARLY"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown plan to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	type subscriptionResponse struct {
+		Subscription struct {
+			Status             string     `json:"status"`
+			Entitled           bool       `json:"entitled"`
+			CurrentPeriodStart *time.Time `json:"currentPeriodStart"`
+			CurrentPeriodEnd   *time.Time `json:"currentPeriodEnd"`
+		} `json:"subscription"`
+	}
+	rr = f.do(http.MethodPost, "/api/auth/subscriptions", token, map[string]any{"externalId": "sub-1", "planId": "MONTHLY", "status": "active"})
+	var created subscriptionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected subscription, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if sub := created.Subscription; sub.Status != store.SubscriptionStatusPending || sub.Entitled || sub.CurrentPeriodEnd != nil {
+		t.Fatalf("expected a self-service subscription to await payment, got %+v", sub)
+	}
+
+	admin := f.createUser(t, "billing-admin", store.RoleAdmin)
+	adminToken := f.session(t, admin)
+	statusPath := "/api/auth/admin/users/" + user.ID + "/subscriptions/sub-1"
+	if rr := f.do(http.MethodPatch, statusPath, token, map[string]any{"status": "active"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected users to be unable to activate subscriptions, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPatch, statusPath, adminToken, map[string]any{"status": "paused"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown status to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = f.do(http.MethodPatch, statusPath, adminToken, map[string]any{"status": "active"})
+	var activated subscriptionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &activated); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected activation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	sub := activated.Subscription
+	if sub.Status != store.SubscriptionStatusActive || !sub.Entitled || sub.CurrentPeriodEnd == nil || sub.CurrentPeriodEnd.Sub(*sub.CurrentPeriodStart) != 30*24*time.Hour {
+		t.Fatalf("expected active subscription with a monthly period, got %+v", sub)
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/subscriptions", token, map[string]any{"externalId": "sub-1", "paymentQr": "qr"})
+	var updated subscriptionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected subscription update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := updated.Subscription; got.Status != store.SubscriptionStatusActive || !got.CurrentPeriodEnd.Equal(*sub.CurrentPeriodEnd) {
+		t.Fatalf("expected self-service update to keep status and period, got %+v", got)
+	}
+	if rr := f.do(http.MethodPost, "/api/auth/subscriptions", token, map[string]any{"externalId": "sub-1", "planId": subscription.TrialPlanID}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected plan change of a confirmed subscription to conflict, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := f.do(http.MethodPatch, statusPath, adminToken, map[string]any{"status": "trialing"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected active -> trialing to conflict, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPost, "/api/auth/subscriptions/cancel", token, map[string]any{"externalId": "sub-1"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected cancellation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPost, "/api/auth/subscriptions/cancel", token, map[string]any{"externalId": "sub-1"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected repeated cancellation to be idempotent, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := f.do(http.MethodPatch, statusPath, adminToken, map[string]any{"status": "active"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected cancelled subscription to stay cancelled, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProvisionTrialSubscriptionStartsTrialPeriod(t *testing.T) {
+	f := newAdminUsersFixture(t)
+	user := f.createUser(t, "newcomer", store.RoleUser)
+	h := &handler{store: f.store, subscriptionPlans: subscription.DefaultCatalog()}
+
+	h.provisionTrialSubscription(context.Background(), user)
+
+	subs, err := f.store.ListSubscriptionsByUser(context.Background(), user.ID)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("expected trial subscription, got %+v (%v)", subs, err)
+	}
+	trial := subs[0]
+	if trial.Status != store.SubscriptionStatusTrialing || trial.PlanID != subscription.TrialPlanID || trial.CurrentPeriodEnd == nil {
+		t.Fatalf("unexpected trial %+v", trial)
+	}
+	if got := trial.CurrentPeriodEnd.Sub(*trial.CurrentPeriodStart); got != 7*24*time.Hour {
+		t.Fatalf("expected a 7 day trial, got %v", got)
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+type tokenPairResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+}
+
+func newTokenRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	tokenService := auth.NewTokenService(auth.TokenConfig{
+		PublicToken:   "public-token",
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 24 * time.Hour,
+		RefreshStore:  st,
+	})
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false), WithTokenService(tokenService))
+	return router
+}
+
+func postTokenJSON(t *testing.T, router *gin.Engine, path string, payload any) (*httptest.ResponseRecorder, tokenPairResponse) {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var resp tokenPairResponse
+	if rr.Body.Len() > 0 {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return rr, resp
+}
+
+func TestRefreshTokenRotationEndpoint(t *testing.T) {
+	router := newTokenRouter(t)
+
+	rr, issued := postTokenJSON(t, router, "/api/auth/token/exchange", map[string]string{
+		"public_token": "public-token",
+		"user_id":      "user-1",
+		"email":        "user@example.com",
+	})
+	if rr.Code != http.StatusOK || issued.RefreshToken == "" {
+		t.Fatalf("expected token exchange to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, rotated := postTokenJSON(t, router, "/api/auth/token/refresh", map[string]string{"refresh_token": issued.RefreshToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected refresh to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rotated.AccessToken == "" || rotated.RefreshToken == "" || rotated.RefreshToken == issued.RefreshToken {
+		t.Fatalf("expected a rotated token pair, got %+v", rotated)
+	}
+
+	rr, reused := postTokenJSON(t, router, "/api/auth/token/refresh", map[string]string{"refresh_token": issued.RefreshToken})
+	if rr.Code != http.StatusUnauthorized || reused.Error != "refresh_token_reused" {
+		t.Fatalf("expected reuse to be detected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, revoked := postTokenJSON(t, router, "/api/auth/token/refresh", map[string]string{"refresh_token": rotated.RefreshToken})
+	if rr.Code != http.StatusUnauthorized || revoked.Error != "invalid_refresh_token" {
+		t.Fatalf("expected family to be revoked after reuse, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRevokeTokenEndpoint(t *testing.T) {
+	router := newTokenRouter(t)
+
+	_, issued := postTokenJSON(t, router, "/api/auth/token/exchange", map[string]string{
+		"public_token": "public-token",
+		"user_id":      "user-1",
+	})
+
+	rr, _ := postTokenJSON(t, router, "/api/auth/token/revoke", map[string]string{"refresh_token": issued.RefreshToken})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected revoke to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, _ = postTokenJSON(t, router, "/api/auth/token/refresh", map[string]string{"refresh_token": issued.RefreshToken})
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, resp := postTokenJSON(t, router, "/api/auth/token/revoke", map[string]string{})
+	if rr.Code != http.StatusBadRequest || resp.Error != "invalid_request" {
+		t.Fatalf("expected missing token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, resp = postTokenJSON(t, router, "/api/auth/token/revoke", map[string]string{"refresh_token": "garbage"})
+	if rr.Code != http.StatusUnauthorized || resp.Error != "invalid_refresh_token" {
+		t.Fatalf("expected invalid token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := newTokenRouter(t)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without asymmetric keys, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	keys, err := auth.NewKeySet("k1", auth.SigningKey{ID: "k1", PrivateKey: private})
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	tokenService := auth.NewTokenService(auth.TokenConfig{
+		RefreshSecret: "refresh-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: time.Hour,
+		SigningKeys:   keys,
+	})
+	router = gin.New()
+	RegisterRoutes(router, WithTokenService(tokenService))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected jwks, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var doc auth.JWKS
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode jwks: %v", err)
+	}
+	if len(doc.Keys) != 1 || doc.Keys[0].KeyID != "k1" || doc.Keys[0].KeyType != "OKP" {
+		t.Fatalf("unexpected jwks %+v", doc)
+	}
+}
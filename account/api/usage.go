@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+// defaultUsageWindow is how many days of usage are returned when the request
+// does not name a range.
+const defaultUsageWindow = 30
+
+// getUsage returns the caller's daily traffic and the quota of their
+// subscription.
+func (h *handler) getUsage(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c, deviceScopeSubscriptionsRead)
+	if !ok {
+		return
+	}
+	filter, ok := parseTrafficUsageFilter(c)
+	if !ok {
+		return
+	}
+	filter.UserID = user.ID
+	h.respondTrafficUsage(c, filter)
+}
+
+// adminListUsage returns daily traffic across users, optionally narrowed to
+// one user with userId.
+func (h *handler) adminListUsage(c *gin.Context) {
+	if _, ok := h.requireAdminOrOperator(c); !ok {
+		return
+	}
+	filter, ok := parseTrafficUsageFilter(c)
+	if !ok {
+		return
+	}
+	filter.UserID = strings.TrimSpace(c.Query("userId"))
+	h.respondTrafficUsage(c, filter)
+}
+
+func (h *handler) respondTrafficUsage(c *gin.Context, filter store.TrafficUsageFilter) {
+	ctx := c.Request.Context()
+	usage, err := h.store.ListTrafficUsage(ctx, filter)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "usage_unavailable", "failed to load traffic usage")
+		return
+	}
+
+	var total store.TrafficUsage
+	entries := make([]gin.H, 0, len(usage))
+	for _, entry := range usage {
+		total.Uplink += entry.Uplink
+		total.Downlink += entry.Downlink
+		entries = append(entries, gin.H{
+			"userId":   entry.UserID,
+			"day":      entry.Day.Format(time.DateOnly),
+			"uplink":   entry.Uplink,
+			"downlink": entry.Downlink,
+			"total":    entry.Total(),
+		})
+	}
+	response := gin.H{
+		"since": filter.Since.Format(time.DateOnly),
+		"usage": entries,
+		"total": gin.H{"uplink": total.Uplink, "downlink": total.Downlink, "total": total.Total()},
+	}
+	if !filter.Until.IsZero() {
+		response["until"] = filter.Until.Format(time.DateOnly)
+	}
+
+	if filter.UserID != "" {
+		allowance, ok, err := h.trafficAllowance(ctx, filter.UserID, time.Now().UTC())
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "usage_unavailable", "failed to load traffic quota")
+			return
+		}
+		if ok {
+			response["quota"] = sanitizeTrafficAllowance(allowance)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// trafficAllowance evaluates the quota of the user's entitled subscriptions
+// against the usage recorded in their current periods. ok is false when the
+// user holds no entitled subscription.
+func (h *handler) trafficAllowance(ctx context.Context, userID string, now time.Time) (subscription.TrafficAllowance, bool, error) {
+	subscriptions, err := h.store.ListSubscriptionsByUser(ctx, userID)
+	if err != nil {
+		return subscription.TrafficAllowance{}, false, err
+	}
+	filter := store.TrafficUsageFilter{UserID: userID}
+	for i := range subscriptions {
+		start := subscriptions[i].CurrentPeriodStart
+		if !subscriptions[i].Entitled(now) {
+			continue
+		}
+		if start == nil {
+			filter.Since = time.Time{}
+			break
+		}
+		if filter.Since.IsZero() || start.Before(filter.Since) {
+			filter.Since = *start
+		}
+	}
+	usage, err := h.store.ListTrafficUsage(ctx, filter)
+	if err != nil {
+		return subscription.TrafficAllowance{}, false, err
+	}
+	allowance, ok := subscription.BestAllowance(h.subscriptionPlans.TrafficQuotas(), subscriptions, usage, now)
+	return allowance, ok, nil
+}
+
+func sanitizeTrafficAllowance(allowance subscription.TrafficAllowance) gin.H {
+	quota := gin.H{
+		"planId":    allowance.PlanID,
+		"unlimited": allowance.Unlimited(),
+		"limit":     allowance.Quota,
+		"used":      allowance.Used,
+		"remaining": allowance.Remaining(),
+		"exceeded":  allowance.Exceeded(),
+	}
+	if allowance.PeriodStart != nil {
+		quota["periodStart"] = allowance.PeriodStart.UTC()
+	}
+	if allowance.PeriodEnd != nil {
+		quota["periodEnd"] = allowance.PeriodEnd.UTC()
+	}
+	return quota
+}
+
+// parseTrafficUsageFilter reads the since (inclusive) and until (exclusive)
+// days, formatted as YYYY-MM-DD. since defaults to the start of the last 30
+// days.
+func parseTrafficUsageFilter(c *gin.Context) (store.TrafficUsageFilter, bool) {
+	filter := store.TrafficUsageFilter{
+		Since: store.UsageDay(time.Now()).AddDate(0, 0, -(defaultUsageWindow - 1)),
+	}
+	for key, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := strings.TrimSpace(c.Query(key))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_filter", key+" must be a date formatted as YYYY-MM-DD")
+			return store.TrafficUsageFilter{}, false
+		}
+		*target = parsed.UTC()
+	}
+	if !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		respondError(c, http.StatusBadRequest, "invalid_filter", "since must be before until")
+		return store.TrafficUsageFilter{}, false
+	}
+	return filter, true
+}
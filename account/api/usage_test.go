@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"account/internal/store"
+	"account/internal/subscription"
+)
+
+type usageResponse struct {
+	Usage []struct {
+		UserID string `json:"userId"`
+		Day    string `json:"day"`
+		Total  int64  `json:"total"`
+	} `json:"usage"`
+	Total struct {
+		Uplink   int64 `json:"uplink"`
+		Downlink int64 `json:"downlink"`
+	} `json:"total"`
+	Quota *struct {
+		PlanID    string `json:"planId"`
+		Limit     int64  `json:"limit"`
+		Used      int64  `json:"used"`
+		Remaining int64  `json:"remaining"`
+		Exceeded  bool   `json:"exceeded"`
+	} `json:"quota"`
+}
+
+func TestTrafficUsageEndpoints(t *testing.T) {
+	catalog, err := subscription.NewCatalog([]subscription.Plan{{ID: "BASIC", Period: 30 * 24 * time.Hour, TrafficQuota: 1000}})
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	f := newAdminUsersFixture(t, WithSubscriptionPlans(catalog))
+	alice := f.createUser(t, "alice", store.RoleUser)
+	bob := f.createUser(t, "bob", store.RoleUser)
+	admin := f.createUser(t, "root", store.RoleAdmin)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	periodStart := now.Add(-24 * time.Hour)
+	periodEnd := now.Add(29 * 24 * time.Hour)
+	if err := f.store.UpsertSubscription(ctx, &store.Subscription{
+		UserID: alice.ID, ExternalID: "sub-alice", PlanID: "BASIC", Status: store.SubscriptionStatusActive,
+		CurrentPeriodStart: &periodStart, CurrentPeriodEnd: &periodEnd,
+	}); err != nil {
+		t.Fatalf("upsert subscription: %v", err)
+	}
+	if err := f.store.AddTrafficUsage(ctx, []store.TrafficUsage{
+		{UserID: alice.ID, Day: now.AddDate(0, 0, -3), Uplink: 5000},
+		{UserID: alice.ID, Day: now.Add(-24 * time.Hour), Uplink: 300, Downlink: 400},
+		{UserID: alice.ID, Day: now, Downlink: 200},
+		{UserID: bob.ID, Day: now, Uplink: 1},
+	}); err != nil {
+		t.Fatalf("add usage: %v", err)
+	}
+
+	rr := f.do(http.MethodGet, "/api/auth/usage", f.session(t, alice), nil)
+	var own usageResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &own); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected usage, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(own.Usage) != 3 || own.Total.Uplink != 5300 || own.Total.Downlink != 600 {
+		t.Fatalf("unexpected usage %+v", own)
+	}
+	// Only usage since the period started counts against the quota.
+	if own.Quota == nil || own.Quota.PlanID != "BASIC" || own.Quota.Used != 900 || own.Quota.Remaining != 100 || own.Quota.Exceeded {
+		t.Fatalf("unexpected quota %+v", own.Quota)
+	}
+
+	if rr := f.do(http.MethodGet, "/api/auth/usage?since=yesterday", f.session(t, alice), nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected malformed date to be rejected, got %d", rr.Code)
+	}
+	if rr := f.do(http.MethodGet, "/api/auth/admin/usage", f.session(t, bob), nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admin to be rejected, got %d", rr.Code)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/usage?since="+now.Format(time.DateOnly), f.session(t, admin), nil)
+	var all usageResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &all); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected admin usage, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(all.Usage) != 2 || all.Quota != nil {
+		t.Fatalf("expected today's usage of both users, got %+v", all)
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/admin/usage?userId="+bob.ID, f.session(t, admin), nil)
+	var single usageResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &single); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected admin usage, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(single.Usage) != 1 || single.Usage[0].UserID != bob.ID || single.Quota != nil {
+		t.Fatalf("expected bob's usage without quota, got %+v", single)
+	}
+}
@@ -0,0 +1,557 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+	"account/internal/webauthn"
+)
+
+const (
+	webAuthnCeremonyRegister = "register"
+	webAuthnCeremonyLogin    = "login"
+
+	maxWebAuthnCredentialNameLength = 64
+
+	mfaFactorTOTP     = "totp"
+	mfaFactorWebAuthn = "webauthn"
+)
+
+type webAuthnRegistrationBeginRequest struct {
+	Name string `json:"name"`
+}
+
+type webAuthnRegistrationFinishRequest struct {
+	Token      string                       `json:"token"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+type webAuthnLoginBeginRequest struct {
+	MFAToken string `json:"mfaToken"`
+}
+
+type webAuthnLoginFinishRequest struct {
+	Token      string                     `json:"token"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+type webAuthnCredentialRenameRequest struct {
+	Name string `json:"name"`
+}
+
+// WithWebAuthn enables security key and passkey registration and sign in.
+// Without this option the WebAuthn endpoints respond with 404 and accounts
+// sign in with passwords and TOTP only.
+func WithWebAuthn(cfg webauthn.Config) Option {
+	return func(h *handler) {
+		h.webAuthn = &cfg
+	}
+}
+
+func (h *handler) requireWebAuthn(c *gin.Context) bool {
+	if h.webAuthn == nil {
+		respondError(c, http.StatusNotFound, "webauthn_disabled", "webauthn is not enabled")
+		return false
+	}
+	return true
+}
+
+// respondWebAuthnRequired ends a password sign in for an account with
+// registered security keys. The returned MFA token starts the assertion
+// ceremony through /mfa/webauthn/login/begin.
+func (h *handler) respondWebAuthnRequired(c *gin.Context, user *store.User) {
+	methods := []string{mfaFactorWebAuthn}
+	if user.MFAEnabled {
+		methods = append(methods, mfaFactorTOTP)
+	}
+	h.respondSecondFactorRequired(c, user, "webauthn_required", "security key verification is required", methods)
+}
+
+// respondSecondFactorRequired issues an MFA token for user and lists the
+// factors that can complete the sign in with it.
+func (h *handler) respondSecondFactorRequired(c *gin.Context, user *store.User, code, message string, methods []string) {
+	token, err := h.createMFAChallenge(c.Request.Context(), user.ID, mfaPurposeLogin)
+	if err != nil {
+		slog.Error("failed to create mfa challenge during login", "err", err, "userID", user.ID)
+		respondError(c, http.StatusInternalServerError, "mfa_challenge_creation_failed", "failed to create mfa challenge")
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":    code,
+		"message":  message,
+		"mfaToken": token,
+		"methods":  methods,
+	})
+}
+
+func (h *handler) beginWebAuthnRegistration(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req webAuthnRegistrationBeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "webauthn_name_required", "name is required")
+		return
+	}
+	if len(name) > maxWebAuthnCredentialNameLength {
+		respondError(c, http.StatusBadRequest, "webauthn_name_too_long", "name must be at most 64 characters")
+		return
+	}
+
+	ctx := c.Request.Context()
+	credentials, err := h.store.ListWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_credentials_unavailable", "failed to load webauthn credentials")
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+	token, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+
+	ceremony := webAuthnCeremonyRecord{
+		Kind:      webAuthnCeremonyRegister,
+		UserID:    user.ID,
+		Name:      name,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(h.webAuthn.CeremonyTimeout()),
+	}
+	if err := h.storeWebAuthnCeremony(ctx, token, ceremony); err != nil {
+		slog.Error("failed to persist webauthn ceremony", "err", err, "userID", user.ID)
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Name
+	}
+	options := h.webAuthn.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          webauthn.EncodeBase64([]byte(user.ID)),
+		Name:        account,
+		DisplayName: user.Name,
+	}, webAuthnDescriptors(credentials))
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"publicKey": options,
+	})
+}
+
+func (h *handler) finishWebAuthnRegistration(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req webAuthnRegistrationFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	ctx := c.Request.Context()
+	ceremony, ok := h.takeWebAuthnCeremony(ctx, req.Token, webAuthnCeremonyRegister)
+	if !ok || ceremony.UserID != user.ID {
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_token", "webauthn token is invalid or expired")
+		return
+	}
+
+	verified, err := h.webAuthn.VerifyRegistration(ceremony.Challenge, req.Credential, false)
+	if err != nil {
+		slog.Warn("webauthn registration rejected", "err", err, "userID", user.ID)
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_attestation", "security key registration could not be verified")
+		return
+	}
+
+	credential := &store.WebAuthnCredential{
+		UserID:       user.ID,
+		Name:         ceremony.Name,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		AAGUID:       verified.AAGUID,
+		Transports:   verified.Transports,
+	}
+	if err := h.store.CreateWebAuthnCredential(ctx, credential); err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialExists) {
+			respondError(c, http.StatusConflict, "webauthn_credential_exists", "security key is already registered")
+			return
+		}
+		slog.Error("failed to persist webauthn credential", "err", err, "userID", user.ID)
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to register security key")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionWebAuthnRegister,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"credentialId": credential.ID, "name": credential.Name},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"credential": sanitizeWebAuthnCredential(credential)})
+}
+
+// beginWebAuthnLogin starts an assertion ceremony. With an mfaToken from a
+// password sign in the user's registered credentials are offered as a second
+// factor; without one any discoverable passkey may be used and user
+// verification is required in place of the password.
+func (h *handler) beginWebAuthnLogin(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+
+	var req webAuthnLoginBeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	ctx := c.Request.Context()
+	ceremony := webAuthnCeremonyRecord{
+		Kind:      webAuthnCeremonyLogin,
+		ExpiresAt: time.Now().Add(h.webAuthn.CeremonyTimeout()),
+	}
+	var allow []webauthn.CredentialDescriptor
+	userVerification := webauthn.UserVerificationRequired
+
+	if mfaToken := strings.TrimSpace(req.MFAToken); mfaToken != "" {
+		challenge, ok := h.lookupMFAChallenge(ctx, mfaToken)
+		if !ok {
+			respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
+			return
+		}
+		credentials, err := h.store.ListWebAuthnCredentials(ctx, challenge.userID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "webauthn_credentials_unavailable", "failed to load webauthn credentials")
+			return
+		}
+		if len(credentials) == 0 {
+			respondError(c, http.StatusBadRequest, "webauthn_not_enrolled", "no security keys are registered for this account")
+			return
+		}
+		ceremony.UserID = challenge.userID
+		ceremony.MFAToken = mfaToken
+		allow = webAuthnDescriptors(credentials)
+		userVerification = webauthn.UserVerificationPreferred
+	} else {
+		if !h.allowAttempt(c, rateLimitScopeLogin, "") {
+			return
+		}
+		ceremony.UserVerification = true
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+	token, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+	ceremony.Challenge = challenge
+	if err := h.storeWebAuthnCeremony(ctx, token, ceremony); err != nil {
+		slog.Error("failed to persist webauthn ceremony", "err", err)
+		respondError(c, http.StatusInternalServerError, "webauthn_challenge_failed", "failed to create webauthn challenge")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"publicKey": h.webAuthn.RequestOptions(challenge, allow, userVerification),
+	})
+}
+
+func (h *handler) finishWebAuthnLogin(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+
+	var req webAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	ctx := c.Request.Context()
+	ceremony, ok := h.takeWebAuthnCeremony(ctx, req.Token, webAuthnCeremonyLogin)
+	if !ok {
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_token", "webauthn token is invalid or expired")
+		return
+	}
+	passwordless := ceremony.MFAToken == ""
+
+	credentialID, err := req.Credential.CredentialID()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "credential id is required")
+		return
+	}
+	credential, err := h.store.GetWebAuthnCredentialByCredentialID(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+			h.recordLoginFailure(c, "", nil, "unknown_webauthn_credential")
+			respondError(c, http.StatusUnauthorized, "invalid_webauthn_assertion", "security key could not be verified")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+		return
+	}
+	if !passwordless && credential.UserID != ceremony.UserID {
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_assertion", "security key could not be verified")
+		return
+	}
+	if passwordless {
+		// Discoverable credentials identify their owner through the user
+		// handle, which was set to the user ID at registration.
+		if handle, err := req.Credential.UserHandle(); err != nil || string(handle) != credential.UserID {
+			respondError(c, http.StatusUnauthorized, "invalid_webauthn_assertion", "security key could not be verified")
+			return
+		}
+	}
+
+	user, err := h.store.GetUserByID(ctx, credential.UserID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+		return
+	}
+	identifier := user.Email
+	if identifier == "" {
+		identifier = user.Name
+	}
+
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_locked")
+		return
+	}
+
+	signCount, err := h.webAuthn.VerifyAssertion(ceremony.Challenge, webauthn.Credential{
+		ID:        credential.CredentialID,
+		PublicKey: credential.PublicKey,
+		SignCount: credential.SignCount,
+	}, req.Credential, ceremony.UserVerification)
+	if err != nil {
+		reason := "invalid_webauthn_assertion"
+		if errors.Is(err, webauthn.ErrSignCountRegressed) {
+			reason = "webauthn_sign_count_regressed"
+			slog.Warn("webauthn signature counter regressed; the authenticator may be cloned", "userID", user.ID, "credentialID", credential.ID)
+		}
+		h.registerSignInFailure(c, user)
+		h.recordLoginFailure(c, identifier, user, reason)
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_assertion", "security key could not be verified")
+		return
+	}
+
+	if rejectDisabledUser(c, user) {
+		h.recordLoginFailure(c, identifier, user, "account_disabled")
+		return
+	}
+	if strings.TrimSpace(user.Email) != "" && !user.EmailVerified {
+		h.recordLoginFailure(c, identifier, user, "email_not_verified")
+		respondError(c, http.StatusUnauthorized, "email_not_verified", "email must be verified before login")
+		return
+	}
+
+	// Several ceremonies may be started from one MFA token; redeeming it
+	// here lets at most one of them complete the sign in.
+	if !passwordless {
+		if challenge, ok := h.takeMFAChallenge(ctx, ceremony.MFAToken); !ok || challenge.userID != user.ID {
+			respondError(c, http.StatusUnauthorized, "invalid_mfa_token", "mfa token is invalid or expired")
+			return
+		}
+	}
+
+	if err := h.store.UpdateWebAuthnCredentialUsage(ctx, credential.ID, signCount, time.Now().UTC()); err != nil {
+		slog.Warn("failed to record webauthn credential usage", "err", err, "credentialID", credential.ID)
+	}
+
+	token, expiresAt, err := h.createSession(ctx, user.ID, clientOf(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
+		return
+	}
+
+	h.setSessionCookie(c, token, expiresAt)
+	h.clearSignInFailures(c, user)
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionLogin,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata: map[string]any{
+			"identifier":   identifier,
+			"mfa":          !passwordless,
+			"method":       mfaFactorWebAuthn,
+			"passwordless": passwordless,
+			"credentialId": credential.ID,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "login successful",
+		"token":     token,
+		"expiresAt": expiresAt.UTC(),
+		"user":      sanitizeUser(user, nil),
+	})
+}
+
+func (h *handler) listWebAuthnCredentials(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	credentials, err := h.store.ListWebAuthnCredentials(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_credentials_unavailable", "failed to load webauthn credentials")
+		return
+	}
+
+	sanitized := make([]gin.H, 0, len(credentials))
+	for i := range credentials {
+		sanitized = append(sanitized, sanitizeWebAuthnCredential(&credentials[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"credentials": sanitized})
+}
+
+func (h *handler) renameWebAuthnCredential(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req webAuthnCredentialRenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "webauthn_name_required", "name is required")
+		return
+	}
+	if len(name) > maxWebAuthnCredentialNameLength {
+		respondError(c, http.StatusBadRequest, "webauthn_name_too_long", "name must be at most 64 characters")
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	if err := h.store.RenameWebAuthnCredential(c.Request.Context(), user.ID, id, name); err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+			respondError(c, http.StatusNotFound, "webauthn_credential_not_found", "security key not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "webauthn_rename_failed", "failed to rename security key")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) deleteWebAuthnCredential(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	if err := h.store.DeleteWebAuthnCredential(c.Request.Context(), user.ID, id); err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+			respondError(c, http.StatusNotFound, "webauthn_credential_not_found", "security key not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "webauthn_delete_failed", "failed to remove security key")
+		return
+	}
+
+	h.recordAudit(c, store.AuditEvent{
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Action:    auditActionWebAuthnDelete,
+		Outcome:   store.AuditOutcomeSuccess,
+		Metadata:  map[string]any{"credentialId": id},
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+// enrolledMFAFactors lists the second factors the user can sign in with.
+func enrolledMFAFactors(user *store.User, credentials []store.WebAuthnCredential) []string {
+	factors := make([]string, 0, 2)
+	if user.MFAEnabled {
+		factors = append(factors, mfaFactorTOTP)
+	}
+	if len(credentials) > 0 {
+		factors = append(factors, mfaFactorWebAuthn)
+	}
+	return factors
+}
+
+func webAuthnDescriptors(credentials []store.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         webauthn.EncodeBase64(credential.CredentialID),
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
+
+func sanitizeWebAuthnCredential(credential *store.WebAuthnCredential) gin.H {
+	transports := credential.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	payload := gin.H{
+		"id":           credential.ID,
+		"name":         credential.Name,
+		"credentialId": webauthn.EncodeBase64(credential.CredentialID),
+		"transports":   transports,
+		"createdAt":    credential.CreatedAt.UTC(),
+	}
+	if credential.LastUsedAt != nil {
+		payload["lastUsedAt"] = credential.LastUsedAt.UTC()
+	}
+	return payload
+}
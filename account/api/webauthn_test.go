@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+
+	"account/internal/store"
+	"account/internal/webauthn"
+	"account/internal/webauthn/webauthntest"
+)
+
+const (
+	testWebAuthnRPID   = "accounts.example.com"
+	testWebAuthnOrigin = "https://accounts.example.com"
+)
+
+type webAuthnCeremonyResponse struct {
+	Token     string          `json:"token"`
+	PublicKey json.RawMessage `json:"publicKey"`
+}
+
+func newWebAuthnFixture(t *testing.T) (*adminUsersFixture, *webauthntest.Authenticator) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false), WithWebAuthn(webauthn.Config{
+		RPID:    testWebAuthnRPID,
+		RPName:  "XControl",
+		Origins: []string{testWebAuthnOrigin},
+	}))
+	return &adminUsersFixture{router: router, store: st}, webauthntest.New(testWebAuthnRPID, testWebAuthnOrigin)
+}
+
+func decodeCeremony(t *testing.T, rr *httptest.ResponseRecorder, options any) string {
+	t.Helper()
+	var resp webAuthnCeremonyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode ceremony: %v", err)
+	}
+	if err := json.Unmarshal(resp.PublicKey, options); err != nil {
+		t.Fatalf("failed to decode ceremony options: %v", err)
+	}
+	return resp.Token
+}
+
+func registerSecurityKey(t *testing.T, f *adminUsersFixture, authenticator *webauthntest.Authenticator, session, name string) string {
+	t.Helper()
+	rr := f.do(http.MethodPost, "/api/auth/mfa/webauthn/register/begin", session, map[string]string{"name": name})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected registration options, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var options webauthn.CreationOptions
+	token := decodeCeremony(t, rr, &options)
+
+	credential, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create credential: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/register/finish", session, gin.H{"token": token, "credential": credential})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected credential to be registered, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Credential struct {
+			ID string `json:"id"`
+		} `json:"credential"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	return created.Credential.ID
+}
+
+func TestWebAuthnSecondFactorLogin(t *testing.T) {
+	f, authenticator := newWebAuthnFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	session := f.session(t, alice)
+
+	registerSecurityKey(t, f, authenticator, session, "YubiKey")
+	registerSecurityKey(t, f, webauthntest.New(testWebAuthnRPID, testWebAuthnOrigin), session, "Laptop")
+
+	rr := f.do(http.MethodGet, "/api/auth/mfa/status", session, nil)
+	var status struct {
+		Enabled bool `json:"enabled"`
+		MFA     struct {
+			Factors                 []string `json:"factors"`
+			WebAuthnCredentialCount int      `json:"webauthnCredentialCount"`
+		} `json:"mfa"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected mfa status, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !status.Enabled || status.MFA.WebAuthnCredentialCount != 2 || len(status.MFA.Factors) != 1 || status.MFA.Factors[0] != "webauthn" {
+		t.Fatalf("expected webauthn factor with two credentials, got %+v", status)
+	}
+
+	// The password alone no longer signs the user in.
+	rr = f.login(t, alice)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected webauthn to be required, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var required struct {
+		Error    string `json:"error"`
+		MFAToken string `json:"mfaToken"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &required)
+	if required.Error != "webauthn_required" || required.MFAToken == "" {
+		t.Fatalf("expected webauthn_required with mfa token, got %s", rr.Body.String())
+	}
+
+	// Without a password no first factor is verified, so no MFA token that
+	// would skip user verification is issued.
+	rr = f.do(http.MethodPost, "/api/auth/login", "", map[string]string{"identifier": alice.Email, "totpCode": "123456"})
+	var passwordless struct {
+		Error    string `json:"error"`
+		MFAToken string `json:"mfaToken"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &passwordless)
+	if rr.Code != http.StatusUnauthorized || passwordless.Error != "password_required" || passwordless.MFAToken != "" {
+		t.Fatalf("expected password to be required, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/begin", "", map[string]string{"mfaToken": required.MFAToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected assertion options, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var options webauthn.RequestOptions
+	token := decodeCeremony(t, rr, &options)
+	if len(options.AllowCredentials) != 2 {
+		t.Fatalf("expected both credentials to be allowed, got %+v", options.AllowCredentials)
+	}
+
+	assertion, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to assert: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/finish", "", gin.H{"token": token, "credential": assertion})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected webauthn login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if decodeResponse(t, rr).Token == "" {
+		t.Fatalf("expected session token, got %s", rr.Body.String())
+	}
+
+	// Ceremonies are single use.
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/finish", "", gin.H{"token": token, "credential": assertion})
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replay to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// The MFA token issued after the password only completes the sign in with an
+// enrolled factor. It must not enroll an authenticator app that would let
+// the password alone skip the security key.
+func TestWebAuthnLoginTokenCannotEnrollTOTP(t *testing.T) {
+	f, authenticator := newWebAuthnFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	registerSecurityKey(t, f, authenticator, f.session(t, alice), "YubiKey")
+
+	rr := f.login(t, alice)
+	var required struct {
+		Error    string `json:"error"`
+		MFAToken string `json:"mfaToken"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &required)
+	if rr.Code != http.StatusUnauthorized || required.Error != "webauthn_required" || required.MFAToken == "" {
+		t.Fatalf("expected webauthn_required with mfa token, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/provision", "", map[string]string{"token": required.MFAToken})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected provisioning with a login token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "attacker", AccountName: "alice"})
+	if err != nil {
+		t.Fatalf("failed to generate totp key: %v", err)
+	}
+	code, err := totp.GenerateCode(key.Secret(), time.Now().UTC())
+	if err != nil {
+		t.Fatalf("failed to generate totp code: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/totp/verify", "", map[string]string{"token": required.MFAToken, "code": code})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected verification with a login token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	stored, err := f.store.GetUserByID(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if stored.MFAEnabled || stored.MFATOTPSecret != "" {
+		t.Fatalf("expected no authenticator app to be enrolled, got %+v", stored)
+	}
+
+	// The token still completes the sign in with the security key, but only
+	// once even when several ceremonies were started from it.
+	begin := func() (string, webauthn.RequestOptions) {
+		rr := f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/begin", "", map[string]string{"mfaToken": required.MFAToken})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected assertion options, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var options webauthn.RequestOptions
+		return decodeCeremony(t, rr, &options), options
+	}
+	firstToken, firstOptions := begin()
+	secondToken, secondOptions := begin()
+	for i, ceremony := range []struct {
+		token   string
+		options webauthn.RequestOptions
+		want    int
+	}{
+		{firstToken, firstOptions, http.StatusOK},
+		{secondToken, secondOptions, http.StatusUnauthorized},
+	} {
+		assertion, err := authenticator.Get(ceremony.options)
+		if err != nil {
+			t.Fatalf("authenticator failed to assert: %v", err)
+		}
+		rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/finish", "", gin.H{"token": ceremony.token, "credential": assertion})
+		if rr.Code != ceremony.want {
+			t.Fatalf("ceremony %d: expected %d, got %d: %s", i, ceremony.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestWebAuthnPasswordlessLogin(t *testing.T) {
+	f, authenticator := newWebAuthnFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	registerSecurityKey(t, f, authenticator, f.session(t, alice), "Phone")
+
+	rr := f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/begin", "", map[string]string{})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected assertion options, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var options webauthn.RequestOptions
+	token := decodeCeremony(t, rr, &options)
+	if len(options.AllowCredentials) != 0 || options.UserVerification != webauthn.UserVerificationRequired {
+		t.Fatalf("expected discoverable ceremony with user verification, got %+v", options)
+	}
+
+	// Passwordless sign in requires user verification by the authenticator.
+	authenticator.SkipUserVerification = true
+	assertion, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to assert: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/finish", "", gin.H{"token": token, "credential": assertion})
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected assertion without user verification to fail, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	authenticator.SkipUserVerification = false
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/begin", "", map[string]string{})
+	token = decodeCeremony(t, rr, &options)
+	assertion, err = authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to assert: %v", err)
+	}
+	rr = f.do(http.MethodPost, "/api/auth/mfa/webauthn/login/finish", "", gin.H{"token": token, "credential": assertion})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected passwordless login, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	page, err := f.store.ListAuditEvents(context.Background(), store.AuditEventFilter{ActorID: alice.ID, Action: auditActionLogin})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	if page.Events[0].Metadata["method"] != "webauthn" || page.Events[0].Metadata["passwordless"] != true {
+		t.Fatalf("expected passwordless login to be audited, got %+v", page.Events[0])
+	}
+}
+
+func TestWebAuthnCredentialManagement(t *testing.T) {
+	f, authenticator := newWebAuthnFixture(t)
+	alice := f.createUser(t, "alice", store.RoleUser)
+	bob := f.createUser(t, "bob", store.RoleUser)
+	session := f.session(t, alice)
+	id := registerSecurityKey(t, f, authenticator, session, "YubiKey")
+
+	rr := f.do(http.MethodPatch, "/api/auth/mfa/webauthn/credentials/"+id, f.session(t, bob), map[string]string{"name": "Stolen"})
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users' credentials to be hidden, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodPatch, "/api/auth/mfa/webauthn/credentials/"+id, session, map[string]string{"name": "Backup key"})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected rename to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodGet, "/api/auth/mfa/webauthn/credentials", session, nil)
+	var listed struct {
+		Credentials []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed.Credentials) != 1 || listed.Credentials[0].Name != "Backup key" {
+		t.Fatalf("expected renamed credential, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = f.do(http.MethodDelete, "/api/auth/mfa/webauthn/credentials/"+id, session, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected delete to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Without security keys the password is enough again.
+	if rr := f.login(t, alice); rr.Code != http.StatusOK {
+		t.Fatalf("expected password login, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"account/config"
+	"account/internal/mailer"
+	"account/internal/store"
+)
+
+// newMailSender builds the configured mail transport. It returns nil when
+// mail delivery is not configured, which disables email verification.
+func newMailSender(cfg config.SMTP, logger *slog.Logger) (mailer.Sender, error) {
+	transport := strings.ToLower(strings.TrimSpace(cfg.Transport))
+	if transport == "" {
+		transport = mailer.TransportSMTP
+	}
+	smtpHost := strings.TrimSpace(cfg.Host)
+	if transport == mailer.TransportSMTP {
+		if smtpHost == "" {
+			return nil, nil
+		}
+		if isExampleDomain(smtpHost) {
+			logger.Warn("smtp host is a placeholder; disabling email delivery", "host", smtpHost)
+			return nil, nil
+		}
+	}
+
+	mailCfg := mailer.Config{
+		Transport:          transport,
+		Host:               smtpHost,
+		Port:               cfg.Port,
+		Username:           cfg.Username,
+		Password:           cfg.Password,
+		From:               cfg.From,
+		ReplyTo:            cfg.ReplyTo,
+		Timeout:            cfg.Timeout,
+		TLSMode:            mailer.ParseTLSMode(cfg.TLS.Mode),
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+		HTTP: mailer.HTTPConfig{
+			URL:     cfg.HTTP.URL,
+			Headers: cfg.HTTP.Headers,
+			Timeout: cfg.HTTP.Timeout,
+		},
+		File: mailer.FileConfig{Dir: cfg.File.Dir},
+		Sendmail: mailer.SendmailConfig{
+			Path:    cfg.Sendmail.Path,
+			Args:    cfg.Sendmail.Args,
+			Timeout: cfg.Sendmail.Timeout,
+		},
+	}
+	if cfg.DKIM.Enabled() {
+		if transport != mailer.TransportSMTP {
+			logger.Warn("dkim signing only applies to the smtp transport; ignoring", "transport", transport)
+		} else {
+			data, err := os.ReadFile(strings.TrimSpace(cfg.DKIM.PrivateKeyFile))
+			if err != nil {
+				return nil, fmt.Errorf("read dkim private key: %w", err)
+			}
+			key, err := mailer.ParseDKIMPrivateKey(data)
+			if err != nil {
+				return nil, err
+			}
+			mailCfg.DKIM = &mailer.DKIMConfig{
+				Domain:     cfg.DKIM.Domain,
+				Selector:   cfg.DKIM.Selector,
+				PrivateKey: key,
+				Headers:    cfg.DKIM.Headers,
+			}
+		}
+	}
+
+	sender, err := mailer.New(mailCfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mail transport configuration: %w", err)
+	}
+	logger.Info("mail delivery enabled", "transport", transport, "dkim", mailCfg.DKIM != nil)
+	return sender, nil
+}
+
+// mailQueueStore persists the outbound mail queue in the account store so
+// that queued messages survive restarts and are shared between replicas.
+type mailQueueStore struct {
+	store store.Store
+}
+
+func (m mailQueueStore) Enqueue(ctx context.Context, msg mailer.Message) error {
+	return m.store.EnqueueOutboundEmail(ctx, &store.OutboundEmail{
+		Recipients: append([]string(nil), msg.To...),
+		Subject:    msg.Subject,
+		PlainBody:  msg.PlainBody,
+		HTMLBody:   msg.HTMLBody,
+	})
+}
+
+func (m mailQueueStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]mailer.QueuedMessage, error) {
+	emails, err := m.store.ClaimOutboundEmails(ctx, now, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]mailer.QueuedMessage, 0, len(emails))
+	for _, email := range emails {
+		claimed = append(claimed, mailer.QueuedMessage{
+			ID: email.ID,
+			Message: mailer.Message{
+				To:        email.Recipients,
+				Subject:   email.Subject,
+				PlainBody: email.PlainBody,
+				HTMLBody:  email.HTMLBody,
+			},
+			Attempts: email.Attempts,
+		})
+	}
+	return claimed, nil
+}
+
+func (m mailQueueStore) Complete(ctx context.Context, id string) error {
+	return m.store.DeleteOutboundEmail(ctx, id)
+}
+
+func (m mailQueueStore) Retry(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return m.store.RescheduleOutboundEmail(ctx, id, attempts, nextAttemptAt, lastError)
+}
+
+func (m mailQueueStore) DeadLetter(ctx context.Context, id string, attempts int, lastError string) error {
+	return m.store.DeadLetterOutboundEmail(ctx, id, attempts, lastError, time.Now())
+}
+
+func (m mailQueueStore) Stats(ctx context.Context) (mailer.QueueStats, error) {
+	stats, err := m.store.OutboundEmailStats(ctx)
+	if err != nil {
+		return mailer.QueueStats{}, err
+	}
+	return mailer.QueueStats{Pending: stats.Pending, DeadLettered: stats.DeadLettered}, nil
+}
@@ -12,7 +12,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -28,10 +30,16 @@ import (
 	"account/internal/agentproto"
 	"account/internal/agentserver"
 	"account/internal/auth"
+	"account/internal/cache"
+	"account/internal/emailtemplate"
 	"account/internal/mailer"
 	"account/internal/model"
+	"account/internal/oidc"
+	"account/internal/ratelimit"
 	"account/internal/service"
 	"account/internal/store"
+	"account/internal/subscription"
+	"account/internal/webauthn"
 	"account/internal/xrayconfig"
 )
 
@@ -69,6 +77,9 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 	}
 
 	r := gin.New()
+	if err := configureTrustedProxies(r, cfg.Server); err != nil {
+		return err
+	}
 	corsConfig := buildCORSConfig(logger, cfg.Server)
 	if corsConfig.AllowAllOrigins {
 		logger.Info("configured cors", "allowAllOrigins", true)
@@ -103,37 +114,35 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		}
 	}()
 
-	var emailSender api.EmailSender
-	emailVerificationEnabled := true
-	smtpHost := strings.TrimSpace(cfg.SMTP.Host)
-	if smtpHost == "" {
-		emailVerificationEnabled = false
-	}
-	if smtpHost != "" && isExampleDomain(smtpHost) {
-		emailVerificationEnabled = false
-		logger.Warn("smtp host is a placeholder; disabling email delivery", "host", smtpHost)
-		smtpHost = ""
-	}
-	if smtpHost != "" {
-		tlsMode := mailer.ParseTLSMode(cfg.SMTP.TLS.Mode)
-		sender, err := mailer.New(mailer.Config{
-			Host:               smtpHost,
-			Port:               cfg.SMTP.Port,
-			Username:           cfg.SMTP.Username,
-			Password:           cfg.SMTP.Password,
-			From:               cfg.SMTP.From,
-			ReplyTo:            cfg.SMTP.ReplyTo,
-			Timeout:            cfg.SMTP.Timeout,
-			TLSMode:            tlsMode,
-			InsecureSkipVerify: cfg.SMTP.TLS.InsecureSkipVerify,
-		})
-		if err != nil {
-			return err
-		}
-		emailSender = mailerAdapter{sender: sender}
+	var (
+		emailSender api.EmailSender
+		mailQueue   *mailer.Queue
+	)
+	sender, err := newMailSender(cfg.SMTP, logger)
+	if err != nil {
+		return err
 	}
-	if emailSender == nil {
-		emailVerificationEnabled = false
+	emailVerificationEnabled := sender != nil
+	if sender != nil {
+		mailQueue = mailer.NewQueue(sender, mailQueueStore{store: st}, mailer.QueueConfig{
+			MaxAttempts:    cfg.SMTP.Queue.MaxAttempts,
+			InitialBackoff: cfg.SMTP.Queue.InitialBackoff,
+			MaxBackoff:     cfg.SMTP.Queue.MaxBackoff,
+			Logger:         logger.With("component", "mailqueue"),
+		})
+		mailQueue.Start()
+		defer func() {
+			drainTimeout := cfg.SMTP.Queue.DrainTimeout
+			if drainTimeout <= 0 {
+				drainTimeout = 30 * time.Second
+			}
+			drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			if err := mailQueue.Close(drainCtx); err != nil {
+				logger.Error("failed to drain mail queue", "err", err)
+			}
+		}()
+		emailSender = mailerAdapter{sender: mailQueue}
 	}
 
 	// Initialize TokenService for authentication
@@ -148,14 +157,22 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 			refreshExpiry = 168 * time.Hour // 7 days
 		}
 
+		signingKeys, err := loadSigningKeys(cfg.Auth.Token.Signing)
+		if err != nil {
+			return err
+		}
+
 		tokenService = auth.NewTokenService(auth.TokenConfig{
 			PublicToken:   cfg.Auth.Token.PublicToken,
 			RefreshSecret: cfg.Auth.Token.RefreshSecret,
 			AccessSecret:  cfg.Auth.Token.AccessSecret,
 			AccessExpiry:  accessExpiry,
 			RefreshExpiry: refreshExpiry,
+			RefreshStore:  st,
+			SigningKeys:   signingKeys,
+			Users:         st,
 		})
-		logger.Info("token service initialized", "auth_enabled", cfg.Auth.Enable)
+		logger.Info("token service initialized", "auth_enabled", cfg.Auth.Enable, "asymmetric_signing", signingKeys != nil)
 	}
 
 	gormDB, gormCleanup, err := openAdminSettingsDB(cfg.Store)
@@ -171,26 +188,46 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 	}()
 	service.SetDB(gormDB)
 
+	subscriptionPlans, err := newSubscriptionCatalog(cfg.Subscriptions)
+	if err != nil {
+		return fmt.Errorf("invalid subscription plans: %w", err)
+	}
+
 	gormSource, err := xrayconfig.NewGormClientSource(gormDB)
 	if err != nil {
 		return err
 	}
+	gormSource.TrafficQuotas = subscriptionPlans.TrafficQuotas()
 
-	var agentRegistry *agentserver.Registry
-	if len(cfg.Agents.Credentials) > 0 {
-		creds := make([]agentserver.Credential, 0, len(cfg.Agents.Credentials))
-		for _, c := range cfg.Agents.Credentials {
-			creds = append(creds, agentserver.Credential{
-				ID:     c.ID,
-				Name:   c.Name,
-				Token:  c.Token,
-				Groups: append([]string(nil), c.Groups...),
-			})
-		}
-		agentRegistry, err = agentserver.NewRegistry(agentserver.Config{Credentials: creds})
-		if err != nil {
-			return err
-		}
+	creds := make([]agentserver.Credential, 0, len(cfg.Agents.Credentials))
+	for _, c := range cfg.Agents.Credentials {
+		creds = append(creds, agentserver.Credential{
+			ID:     c.ID,
+			Name:   c.Name,
+			Token:  c.Token,
+			Groups: append([]string(nil), c.Groups...),
+		})
+	}
+	agentRegistry, err := agentserver.NewRegistry(agentserver.Config{
+		Credentials:      creds,
+		Store:            st,
+		StatusRetention:  cfg.Agents.StatusRetention,
+		CredentialTTL:    cfg.Agents.CredentialTTL,
+		TrafficRetention: cfg.Agents.TrafficRetention,
+	})
+	if err != nil {
+		return err
+	}
+	if err := agentRegistry.Restore(ctx); err != nil {
+		return fmt.Errorf("restore agent registry: %w", err)
+	}
+	templateFiles, err := loadXrayTemplates(cfg.Agents.Templates)
+	if err != nil {
+		return err
+	}
+	templateCatalog, err := agentserver.NewTemplateCatalog(templateFiles, st)
+	if err != nil {
+		return err
 	}
 
 	var stopXraySync func(context.Context) error
@@ -207,9 +244,14 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 			Logger:          logger.With("component", "xray-sync"),
 			Interval:        syncInterval,
 			Source:          gormSource,
-			Generator:       xrayconfig.Generator{Definition: xrayconfig.DefaultDefinition(), OutputPath: outputPath},
+			Generator:       xrayconfig.Generator{Definition: xrayconfig.DefaultDefinition(), OutputPath: outputPath, Inbounds: xrayInbounds(cfg.Xray.Sync.Inbounds)},
 			ValidateCommand: cfg.Xray.Sync.ValidateCommand,
 			RestartCommand:  cfg.Xray.Sync.RestartCommand,
+			Users:           xrayUserManager(cfg.Xray.Sync.HotReload),
+			HealthCommand:   cfg.Xray.Sync.HealthCommand,
+			HealthTimeout:   cfg.Xray.Sync.HealthTimeout,
+			History:         cfg.Xray.Sync.History,
+			HistoryDir:      cfg.Xray.Sync.HistoryDir,
 		})
 		if err != nil {
 			return err
@@ -232,25 +274,170 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		}()
 	}
 
+	expiryInterval := cfg.Subscriptions.ExpiryInterval
+	if expiryInterval <= 0 {
+		expiryInterval = time.Minute
+	}
+	expirer, err := subscription.NewExpirer(subscription.ExpirerOptions{
+		Store:    st,
+		Interval: expiryInterval,
+		Logger:   logger.With("component", "subscription-expiry"),
+	})
+	if err != nil {
+		return err
+	}
+	stopExpirer, err := expirer.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopExpirer(waitCtx); err != nil {
+			logger.Warn("subscription expirer shutdown", "err", err)
+		}
+	}()
+	logger.Info("subscription expiry enabled", "interval", expiryInterval, "plans", len(subscriptionPlans.Plans()))
+
+	sessionCache, cacheCleanup, err := openSessionCache(ctx, cfg.Session)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cacheCleanup != nil {
+			if err := cacheCleanup(context.Background()); err != nil {
+				logger.Error("failed to close session cache", "err", err)
+			}
+		}
+	}()
+	logger.Info("session cache initialized", "backend", sessionCacheBackend(cfg.Session))
+
+	rateLimiter, rateLimiterCleanup, err := openRateLimiter(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rateLimiterCleanup != nil {
+			if err := rateLimiterCleanup(context.Background()); err != nil {
+				logger.Error("failed to close rate limiter", "err", err)
+			}
+		}
+	}()
+	if rateLimiter != nil {
+		logger.Info("rate limiting enabled", "backend", rateLimitBackend(cfg))
+	} else {
+		logger.Warn("rate limiting disabled")
+	}
+
 	options := []api.Option{
 		api.WithStore(st),
 		api.WithSessionTTL(cfg.Session.TTL),
+		api.WithCache(sessionCache),
+		api.WithRateLimiter(rateLimiter, api.RateLimitConfig{
+			PerIP:         ratelimit.Limit{Burst: cfg.RateLimit.PerIP.Burst, Period: cfg.RateLimit.PerIP.Period},
+			PerIdentifier: ratelimit.Limit{Burst: cfg.RateLimit.PerIdentifier.Burst, Period: cfg.RateLimit.PerIdentifier.Period},
+			Lockout: ratelimit.LockoutPolicy{
+				Threshold:   cfg.RateLimit.Lockout.Threshold,
+				Duration:    cfg.RateLimit.Lockout.Duration,
+				MaxDuration: cfg.RateLimit.Lockout.MaxDuration,
+				Window:      cfg.RateLimit.Lockout.Window,
+			},
+		}),
 	}
 	if emailSender != nil {
 		options = append(options, api.WithEmailSender(emailSender))
 	}
+	if mailQueue != nil {
+		options = append(options, api.WithMailQueue(mailQueue))
+	}
 	options = append(options, api.WithEmailVerification(emailVerificationEnabled))
+	options = append(options, api.WithSubscriptionPlans(subscriptionPlans))
+	paymentAdapters, err := newPaymentAdapters(cfg.Payments)
+	if err != nil {
+		return fmt.Errorf("invalid payment webhooks: %w", err)
+	}
+	if len(paymentAdapters) > 0 {
+		options = append(options, api.WithPaymentWebhooks(paymentAdapters...))
+		logger.Info("payment webhooks enabled", "providers", len(paymentAdapters))
+	}
+	if templateDir := strings.TrimSpace(cfg.SMTP.TemplateDir); templateDir != "" {
+		templates, err := emailtemplate.Load(templateDir)
+		if err != nil {
+			return fmt.Errorf("invalid email templates: %w", err)
+		}
+		options = append(options, api.WithEmailTemplates(templates))
+		logger.Info("email template overrides loaded", "dir", templateDir, "locales", templates.Locales())
+	}
 	if tokenService != nil {
 		options = append(options, api.WithTokenService(tokenService))
 	}
-	if agentRegistry != nil {
-		options = append(options, api.WithAgentStatusReader(agentRegistry))
+	options = append(options, api.WithAgentStatusReader(agentRegistry), api.WithAgentManager(agentRegistry))
+	if cfg.DesktopSync.Enabled {
+		desktopGenerator := xrayconfig.Generator{Definition: xrayconfig.DefaultClientDefinition()}
+		if templatePath := strings.TrimSpace(cfg.DesktopSync.TemplatePath); templatePath != "" {
+			payload, err := os.ReadFile(templatePath)
+			if err != nil {
+				return fmt.Errorf("load desktop sync template %s: %w", templatePath, err)
+			}
+			desktopGenerator.Definition = xrayconfig.JSONDefinition{Raw: payload}
+		}
+		if cfg.DesktopSync.RateLimitPerDevicePerDay > 0 && rateLimiter == nil {
+			logger.Warn("desktop sync per-device limit ignored because rate limiting is disabled")
+		}
+		options = append(options, api.WithDesktopSync(api.DesktopSyncConfig{
+			Generator:                desktopGenerator,
+			TimestampSkew:            cfg.DesktopSync.TimestampSkew,
+			RateLimitPerDevicePerDay: cfg.DesktopSync.RateLimitPerDevicePerDay,
+		}))
+		logger.Info("desktop config sync enabled", "rateLimitPerDevicePerDay", cfg.DesktopSync.RateLimitPerDevicePerDay)
+	}
+	if cfg.WebAuthn.Enabled {
+		origins := cfg.WebAuthn.Origins
+		if len(origins) == 0 {
+			origins = cfg.Server.AllowedOrigins
+		}
+		webAuthnConfig := webauthn.Config{
+			RPID:    strings.TrimSpace(cfg.WebAuthn.RPID),
+			RPName:  strings.TrimSpace(cfg.WebAuthn.RPName),
+			Origins: origins,
+			Timeout: cfg.WebAuthn.Timeout,
+		}
+		if err := webAuthnConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid webauthn configuration: %w", err)
+		}
+		options = append(options, api.WithWebAuthn(webAuthnConfig))
+		logger.Info("webauthn enabled", "rpId", webAuthnConfig.RPID)
+	}
+	if len(cfg.OIDC.Providers) > 0 {
+		providers := make([]*oidc.Provider, 0, len(cfg.OIDC.Providers))
+		for _, providerCfg := range cfg.OIDC.Providers {
+			provider, err := oidc.NewProvider(oidc.ProviderConfig{
+				Name:             strings.ToLower(strings.TrimSpace(providerCfg.Name)),
+				DisplayName:      strings.TrimSpace(providerCfg.DisplayName),
+				Issuer:           providerCfg.Issuer,
+				ClientID:         strings.TrimSpace(providerCfg.ClientID),
+				ClientSecret:     providerCfg.ClientSecret,
+				RedirectURL:      strings.TrimSpace(providerCfg.RedirectURL),
+				Scopes:           providerCfg.Scopes,
+				AuthorizationURL: strings.TrimSpace(providerCfg.AuthorizationURL),
+				TokenURL:         strings.TrimSpace(providerCfg.TokenURL),
+				JWKSURL:          strings.TrimSpace(providerCfg.JWKSURL),
+			}, nil)
+			if err != nil {
+				return fmt.Errorf("invalid oidc configuration: %w", err)
+			}
+			providers = append(providers, provider)
+		}
+		options = append(options, api.WithOIDCProviders(providers...))
+		logger.Info("oidc federated login enabled", "providers", len(providers))
 	}
 	api.RegisterRoutes(r, options...)
 
-	if agentRegistry != nil {
-		registerAgentAPIRoutes(r, agentRegistry, gormSource, logger)
+	clientFeed, err := agentserver.NewClientFeed(gormSource, cfg.Agents.ClientRefreshInterval)
+	if err != nil {
+		return err
 	}
+	registerAgentAPIRoutes(r, agentRegistry, clientFeed, templateCatalog, st, logger)
 
 	addr := strings.TrimSpace(cfg.Server.Addr)
 	if addr == "" {
@@ -346,6 +533,28 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 
 	logger.Info("starting account service", "addr", addr, "tls", useTLS)
 
+	// Stop serving when ctx is cancelled and wait for in-flight requests so
+	// that deferred cleanups, such as draining the mail queue, run last.
+	serveDone := make(chan struct{})
+	shutdownDone := make(chan struct{})
+	defer func() {
+		close(serveDone)
+		<-shutdownDone
+	}()
+	go func() {
+		defer close(shutdownDone)
+		select {
+		case <-ctx.Done():
+		case <-serveDone:
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shut down account service", "err", err)
+		}
+	}()
+
 	var listenCertFile, listenKeyFile string
 	if useTLS {
 		if tlsSettings.RedirectHTTP {
@@ -456,16 +665,66 @@ func runAgent(ctx context.Context, cfg *config.Config, logger *slog.Logger) erro
 	return agentmode.Run(ctx, options)
 }
 
+// xrayInbounds converts the configured inbound assignments for the generator.
+func xrayInbounds(inbounds []config.XrayInbound) []xrayconfig.Inbound {
+	converted := make([]xrayconfig.Inbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		converted = append(converted, xrayconfig.Inbound{
+			Tag:    strings.TrimSpace(inbound.Tag),
+			Groups: append([]string(nil), inbound.Groups...),
+		})
+	}
+	return converted
+}
+
+// loadXrayTemplates reads the configured per-node Xray template files.
+func loadXrayTemplates(templates []config.AgentTemplate) ([]store.XrayTemplate, error) {
+	loaded := make([]store.XrayTemplate, 0, len(templates))
+	for _, template := range templates {
+		path := strings.TrimSpace(template.Path)
+		if path == "" {
+			return nil, fmt.Errorf("xray template %q has no path", template.Name)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("load xray template %s: %w", path, err)
+		}
+		loaded = append(loaded, store.XrayTemplate{
+			Name:      template.Name,
+			Content:   content,
+			AgentIDs:  append([]string(nil), template.Agents...),
+			Groups:    append([]string(nil), template.Groups...),
+			Variables: template.Variables,
+		})
+	}
+	return loaded, nil
+}
+
+// xrayUserManager returns the user manager for hot reloads, or nil when they
+// are disabled.
+func xrayUserManager(cfg config.XrayHotReload) xrayconfig.UserManager {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &xrayconfig.CommandUserManager{Command: cfg.Command, Server: cfg.Server}
+}
+
 const agentIdentityContextKey = "xcontrol-account-agent-identity"
 
-func registerAgentAPIRoutes(r *gin.Engine, registry *agentserver.Registry, source xrayconfig.ClientSource, logger *slog.Logger) {
+func registerAgentAPIRoutes(r *gin.Engine, registry *agentserver.Registry, clients *agentserver.ClientFeed, templates *agentserver.TemplateCatalog, usage trafficUsageRecorder, logger *slog.Logger) {
 	if registry == nil {
 		return
 	}
+	// Enrollment is authenticated by the one-time code in the request body.
+	r.POST("/api/agent/v1/enroll", agentEnrollHandler(registry, logger))
+
 	group := r.Group("/api/agent/v1")
 	group.Use(agentAuthMiddleware(registry))
-	group.GET("/users", agentListUsersHandler(source))
+	group.GET("/users", agentListUsersHandler(clients))
+	group.GET("/template", agentTemplateHandler(templates, logger))
 	group.POST("/status", agentReportStatusHandler(registry, logger))
+	group.POST("/usage", agentReportUsageHandler(usage, logger))
+	group.POST("/traffic", agentReportTrafficHandler(registry))
 }
 
 func agentAuthMiddleware(registry *agentserver.Registry) gin.HandlerFunc {
@@ -479,7 +738,7 @@ func agentAuthMiddleware(registry *agentserver.Registry) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "agent_token_required", "message": "agent token is required"})
 			return
 		}
-		identity, ok := registry.Authenticate(token)
+		identity, ok := registry.Authenticate(c.Request.Context(), token)
 		if !ok || identity == nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_agent_token", "message": "invalid agent token"})
 			return
@@ -489,23 +748,108 @@ func agentAuthMiddleware(registry *agentserver.Registry) gin.HandlerFunc {
 	}
 }
 
-func agentListUsersHandler(source xrayconfig.ClientSource) gin.HandlerFunc {
+const (
+	// maxAgentLongPollWait caps how long a long-polling agent request is held.
+	maxAgentLongPollWait = time.Minute
+	// agentLongPollWriteSlack is the time left to write the response after
+	// a long poll ends, on top of the wait itself.
+	agentLongPollWriteSlack = 10 * time.Second
+)
+
+// agentListUsersHandler serves the client list with its revision as ETag.
+// Agents sending the revision they hold in If-None-Match receive 304 when
+// nothing changed; adding ?wait=<duration> holds the request until the list
+// changes or the wait elapses.
+func agentListUsersHandler(feed *agentserver.ClientFeed) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if source == nil {
+		if feed == nil {
 			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "client_source_unavailable", "message": "client source not configured"})
 			return
 		}
-		clients, err := source.ListClients(c.Request.Context())
+		var wait time.Duration
+		if raw := strings.TrimSpace(c.Query("wait")); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_wait", "message": "wait must be a non-negative duration"})
+				return
+			}
+			wait = min(parsed, maxAgentLongPollWait)
+		}
+		known := parseETag(c.GetHeader("If-None-Match"))
+
+		var (
+			snapshot agentserver.ClientSnapshot
+			err      error
+		)
+		if known != "" && wait > 0 {
+			// The server WriteTimeout is shorter than a long poll; push the
+			// deadline of this response past the wait so it can be written.
+			if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(wait + agentLongPollWriteSlack)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "long_poll_unavailable", "message": "failed to extend the response deadline"})
+				return
+			}
+			snapshot, err = feed.Wait(c.Request.Context(), known, wait)
+		} else {
+			snapshot, err = feed.Snapshot(c.Request.Context())
+		}
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "list_clients_failed", "message": "failed to list clients"})
 			return
 		}
-		response := agentproto.ClientListResponse{
-			Clients:     clients,
-			Total:       len(clients),
-			GeneratedAt: time.Now().UTC(),
+
+		c.Header("ETag", `"`+snapshot.Revision+`"`)
+		if known == snapshot.Revision {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, agentproto.ClientListResponse{
+			Clients:     snapshot.Clients,
+			Total:       len(snapshot.Clients),
+			GeneratedAt: snapshot.GeneratedAt,
+			Revision:    snapshot.Revision,
+		})
+	}
+}
+
+// parseETag returns the first entity tag of an If-None-Match header without
+// its weak prefix and quotes.
+func parseETag(header string) string {
+	tag, _, _ := strings.Cut(header, ",")
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	return strings.Trim(tag, `"`)
+}
+
+// agentTemplateHandler serves the Xray server template assigned to the
+// calling agent, or 204 when the agent should keep its local template.
+func agentTemplateHandler(templates *agentserver.TemplateCatalog, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get(agentIdentityContextKey)
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "agent_identity_missing", "message": "agent identity missing"})
+			return
+		}
+		identity, ok := value.(agentserver.Identity)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "agent_identity_invalid", "message": "agent identity malformed"})
+			return
+		}
+		if templates == nil {
+			c.Status(http.StatusNoContent)
+			return
 		}
-		c.JSON(http.StatusOK, response)
+		template, assigned, err := templates.Resolve(c.Request.Context(), identity)
+		if err != nil {
+			if logger != nil {
+				logger.Error("failed to resolve xray template", "agent", identity.ID, "err", err)
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "template_resolve_failed", "message": "failed to resolve xray template"})
+			return
+		}
+		if !assigned {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusOK, template)
 	}
 }
 
@@ -526,7 +870,9 @@ func agentReportStatusHandler(registry *agentserver.Registry, logger *slog.Logge
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_status_payload", "message": "invalid status payload"})
 			return
 		}
-		registry.ReportStatus(identity, report)
+		if err := registry.RecordStatus(c.Request.Context(), identity, report); err != nil && logger != nil {
+			logger.Error("failed to persist agent status", "agent", identity.ID, "err", err)
+		}
 		if logger != nil {
 			logger.Info("agent status updated", "agent", identity.ID, "healthy", report.Healthy, "clients", report.Xray.Clients)
 		}
@@ -534,6 +880,120 @@ func agentReportStatusHandler(registry *agentserver.Registry, logger *slog.Logge
 	}
 }
 
+// agentEnrollHandler exchanges a one-time enrollment code for a long-lived
+// agent credential.
+func agentEnrollHandler(registry *agentserver.Registry, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req agentproto.EnrollRequest
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_enrollment_payload", "message": "enrollment code is required"})
+			return
+		}
+		identity, token, err := registry.Enroll(c.Request.Context(), req.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrAgentEnrollmentInvalid):
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_enrollment_code", "message": "enrollment code is invalid, used or expired"})
+			case errors.Is(err, store.ErrAgentExists):
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "agent_exists", "message": "agent already exists"})
+			default:
+				if logger != nil {
+					logger.Error("failed to enroll agent", "err", err)
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "agent_enrollment_failed", "message": "failed to enroll agent"})
+			}
+			return
+		}
+		if logger != nil {
+			logger.Info("agent enrolled", "agent", identity.ID, "ip", c.ClientIP())
+		}
+		c.JSON(http.StatusCreated, agentproto.EnrollResponse{ID: identity.ID, Name: identity.Name, Groups: identity.Groups, Token: token})
+	}
+}
+
+// agentReportTrafficHandler aggregates an agent's traffic report in the
+// registry.
+func agentReportTrafficHandler(registry *agentserver.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get(agentIdentityContextKey)
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "agent_identity_missing", "message": "agent identity missing"})
+			return
+		}
+		identity, ok := value.(agentserver.Identity)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "agent_identity_invalid", "message": "agent identity malformed"})
+			return
+		}
+		var report agentproto.TrafficReport
+		if err := c.ShouldBindJSON(&report); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_traffic_payload", "message": "invalid traffic payload"})
+			return
+		}
+		registry.ReportTraffic(identity, report)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type trafficUsageRecorder interface {
+	AddTrafficUsage(ctx context.Context, entries []store.TrafficUsage) error
+	AddReportedTrafficUsage(ctx context.Context, agentID, reportID string, entries []store.TrafficUsage) (bool, error)
+}
+
+// agentReportUsageHandler adds the traffic deltas reported by an agent to the
+// daily usage of each user. Deltas are attributed to the UTC day they were
+// collected on. Reports carrying a report ID are applied once per agent, so
+// retries of a report that was already recorded are acknowledged and ignored.
+func agentReportUsageHandler(recorder trafficUsageRecorder, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, _ := c.Get(agentIdentityContextKey)
+		agent, _ := identity.(agentserver.Identity)
+		var report agentproto.UsageReport
+		if err := c.ShouldBindJSON(&report); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_usage_payload", "message": "invalid usage payload"})
+			return
+		}
+		collectedAt := report.CollectedAt
+		if collectedAt.IsZero() {
+			collectedAt = time.Now()
+		}
+		entries := make([]store.TrafficUsage, 0, len(report.Users))
+		for _, usage := range report.Users {
+			day := usage.Day
+			if day.IsZero() {
+				day = collectedAt
+			}
+			entries = append(entries, store.TrafficUsage{UserID: usage.ID, Day: day, Uplink: usage.Uplink, Downlink: usage.Downlink})
+		}
+		var err error
+		applied := true
+		if reportID := strings.TrimSpace(report.ReportID); reportID != "" && agent.ID != "" {
+			applied, err = recorder.AddReportedTrafficUsage(c.Request.Context(), agent.ID, reportID, entries)
+		} else {
+			err = recorder.AddTrafficUsage(c.Request.Context(), entries)
+		}
+		if err != nil {
+			if errors.Is(err, store.ErrInvalidTrafficUsage) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_usage_payload", "message": "usage entries must name a client and carry non-negative counters"})
+				return
+			}
+			if logger != nil {
+				logger.Error("failed to record traffic usage", "err", err)
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "usage_record_failed", "message": "failed to record traffic usage"})
+			return
+		}
+		if logger != nil {
+			if !applied {
+				logger.Debug("duplicate agent usage report ignored", "agent", agent.ID, "report", report.ReportID)
+			} else if agent.ID != "" {
+				logger.Debug("agent usage recorded", "agent", agent.ID, "users", len(entries))
+			}
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
 func extractBearerToken(header string) string {
 	header = strings.TrimSpace(header)
 	if header == "" {
@@ -571,7 +1031,8 @@ var rootCmd = &cobra.Command{
 		logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 		slog.SetDefault(logger)
 
-		ctx := context.Background()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 		mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
 		if mode == "" {
 			mode = "server"
@@ -590,6 +1051,104 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// loadSigningKeys reads the configured access token signing keys. It returns
+// nil when no keys are configured so that tokens keep using the shared secret.
+func loadSigningKeys(cfg config.TokenSigning) (*auth.KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	keys := make([]auth.SigningKey, 0, len(cfg.Keys))
+	for _, keyCfg := range cfg.Keys {
+		data, err := os.ReadFile(keyCfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key %q: %w", keyCfg.ID, err)
+		}
+		key, err := auth.ParseSigningKeyPEM(keyCfg.ID, data)
+		if err != nil {
+			return nil, err
+		}
+		key.RetireAt = keyCfg.RetireAt
+		keys = append(keys, key)
+	}
+	activeID := cfg.ActiveKeyID
+	if strings.TrimSpace(activeID) == "" && len(keys) == 1 {
+		activeID = keys[0].ID
+	}
+	return auth.NewKeySet(activeID, keys...)
+}
+
+func sessionCacheBackend(cfg config.Session) string {
+	backend := strings.ToLower(strings.TrimSpace(cfg.Cache))
+	if backend == "" {
+		return "memory"
+	}
+	return backend
+}
+
+func openSessionCache(ctx context.Context, cfg config.Session) (cache.Cache, func(context.Context) error, error) {
+	switch sessionCacheBackend(cfg) {
+	case "memory":
+		return cache.NewMemory(), nil, nil
+	case "redis":
+		connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		c, cleanup, err := cache.NewRedis(connectCtx, cache.RedisConfig{
+			Addr:     cfg.Redis.Addr,
+			Username: cfg.Redis.Username,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+			Prefix:   cfg.Redis.Prefix,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect session redis: %w", err)
+		}
+		return c, cleanup, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported session cache %q", cfg.Cache)
+	}
+}
+
+func rateLimitBackend(cfg *config.Config) string {
+	backend := strings.ToLower(strings.TrimSpace(cfg.RateLimit.Backend))
+	if backend == "" {
+		return sessionCacheBackend(cfg.Session)
+	}
+	return backend
+}
+
+func openRateLimiter(ctx context.Context, cfg *config.Config) (ratelimit.Limiter, func(context.Context) error, error) {
+	if !cfg.RateLimit.IsEnabled() {
+		return nil, nil, nil
+	}
+	switch rateLimitBackend(cfg) {
+	case "memory":
+		return ratelimit.NewMemory(), nil, nil
+	case "redis":
+		connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		redisCfg := cfg.Session.Redis
+		client, err := cache.DialRedis(connectCtx, cache.RedisConfig{
+			Addr:     redisCfg.Addr,
+			Username: redisCfg.Username,
+			Password: redisCfg.Password,
+			DB:       redisCfg.DB,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect rate limit redis: %w", err)
+		}
+		prefix := ""
+		if strings.TrimSpace(redisCfg.Prefix) != "" {
+			prefix = redisCfg.Prefix + "ratelimit:"
+		}
+		cleanup := func(context.Context) error {
+			return client.Close()
+		}
+		return ratelimit.NewRedis(client, prefix), cleanup, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported rate limit backend %q", cfg.RateLimit.Backend)
+	}
+}
+
 func openAdminSettingsDB(cfg config.Store) (*gorm.DB, func(context.Context) error, error) {
 	driver := strings.ToLower(strings.TrimSpace(cfg.Driver))
 	var (
@@ -657,6 +1216,25 @@ func isExampleDomain(host string) bool {
 	return strings.HasSuffix(normalized, ".example.com")
 }
 
+// configureTrustedProxies limits the proxies whose forwarding headers gin
+// uses to resolve the client address. Without any configured, the address of
+// the connection is used, so clients cannot pick their own rate limit bucket.
+func configureTrustedProxies(r *gin.Engine, serverCfg config.Server) error {
+	proxies := make([]string, 0, len(serverCfg.TrustedProxies))
+	for _, proxy := range serverCfg.TrustedProxies {
+		if trimmed := strings.TrimSpace(proxy); trimmed != "" {
+			proxies = append(proxies, trimmed)
+		}
+	}
+	if len(proxies) == 0 {
+		proxies = nil
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("invalid server.trustedProxies: %w", err)
+	}
+	return nil
+}
+
 func buildCORSConfig(logger *slog.Logger, serverCfg config.Server) cors.Config {
 	allowOrigins, allowAll := resolveAllowedOrigins(logger, serverCfg)
 
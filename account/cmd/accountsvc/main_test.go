@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/api"
+	"account/config"
+	"account/internal/agentproto"
+	"account/internal/agentserver"
+	"account/internal/ratelimit"
+	"account/internal/store"
+	"account/internal/xrayconfig"
+)
+
+type switchingSource struct {
+	clients atomic.Value
+}
+
+func (s *switchingSource) ListClients(context.Context) ([]xrayconfig.Client, error) {
+	return s.clients.Load().([]xrayconfig.Client), nil
+}
+
+func TestAgentListUsersLongPollOutlivesWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	source := &switchingSource{}
+	source.clients.Store([]xrayconfig.Client{{ID: "uuid-a"}})
+	feed, err := agentserver.NewClientFeed(source, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new client feed: %v", err)
+	}
+	snapshot, err := feed.Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/users", agentListUsersHandler(feed))
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	poll := func() *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/users?wait=300ms", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("If-None-Match", `"`+snapshot.Revision+`"`)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("long poll failed past the write timeout: %v", err)
+		}
+		return resp
+	}
+
+	// Nothing changes: the poll ends with 304 after the write timeout.
+	resp := poll()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", resp.StatusCode)
+	}
+
+	// The list changes after the write timeout: the new list is delivered.
+	timer := time.AfterFunc(200*time.Millisecond, func() {
+		source.clients.Store([]xrayconfig.Client{{ID: "uuid-b"}})
+	})
+	defer timer.Stop()
+	resp = poll()
+	defer resp.Body.Close()
+	var body agentproto.ClientListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the changed list, got %d (%v)", resp.StatusCode, err)
+	}
+	if len(body.Clients) != 1 || body.Clients[0].ID != "uuid-b" || body.Revision == snapshot.Revision {
+		t.Fatalf("unexpected client list %+v", body)
+	}
+}
+
+func TestAgentReportUsageIgnoresRetriedReports(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	user := &store.User{Name: "alice", Email: "alice@example.com", Role: store.RoleUser}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/usage", func(c *gin.Context) {
+		c.Set(agentIdentityContextKey, agentserver.Identity{ID: "edge"})
+	}, agentReportUsageHandler(st, nil))
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	report := agentproto.UsageReport{
+		ReportID:    "report-1",
+		CollectedAt: day.Add(36 * time.Hour),
+		Users:       []agentproto.UserUsage{{ID: user.ID, Day: day, Uplink: 10, Downlink: 100}},
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("marshal report: %v", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/usage", bytes.NewReader(body)))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("attempt %d: expected 204, got %d: %s", attempt, rr.Code, rr.Body.String())
+		}
+	}
+
+	usage, err := st.ListTrafficUsage(ctx, store.TrafficUsageFilter{})
+	if err != nil {
+		t.Fatalf("list usage: %v", err)
+	}
+	// Counted once, on the day it was collected rather than the day it was
+	// delivered.
+	if len(usage) != 1 || !usage[0].Day.Equal(day) || usage[0].Uplink != 10 || usage[0].Downlink != 100 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestForwardedForDoesNotResetRateLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(serverCfg config.Server) *gin.Engine {
+		r := gin.New()
+		if err := configureTrustedProxies(r, serverCfg); err != nil {
+			t.Fatalf("configure trusted proxies: %v", err)
+		}
+		api.RegisterRoutes(r, api.WithStore(store.NewMemoryStore()), api.WithEmailVerification(false),
+			api.WithRateLimiter(ratelimit.NewMemory(), api.RateLimitConfig{
+				PerIP:         ratelimit.Limit{Burst: 2, Period: time.Minute},
+				PerIdentifier: ratelimit.Limit{Burst: 10, Period: time.Minute},
+			}))
+		return r
+	}
+	requestReset := func(r *gin.Engine, i int) int {
+		body, _ := json.Marshal(map[string]string{"email": fmt.Sprintf("user-%d@example.com", i)})
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Without trusted proxies a client rotating the header stays in the
+	// bucket of its connection address.
+	r := newRouter(config.Server{})
+	for i := 0; i < 2; i++ {
+		if code := requestReset(r, i); code != http.StatusAccepted {
+			t.Fatalf("expected request %d to be accepted, got %d", i, code)
+		}
+	}
+	if code := requestReset(r, 2); code != http.StatusTooManyRequests {
+		t.Fatalf("expected spoofed X-Forwarded-For to be ignored, got %d", code)
+	}
+
+	// Behind a configured proxy the forwarded client address is used.
+	r = newRouter(config.Server{TrustedProxies: []string{"192.0.2.0/24"}})
+	for i := 0; i < 3; i++ {
+		if code := requestReset(r, i); code != http.StatusAccepted {
+			t.Fatalf("expected forwarded client %d to be accepted, got %d", i, code)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"account/config"
+	"account/internal/payment"
+	"account/internal/subscription"
+)
+
+// newSubscriptionCatalog builds the plan catalogue from configuration,
+// falling back to the built-in catalogue when no plans are declared.
+func newSubscriptionCatalog(cfg config.Subscriptions) (*subscription.Catalog, error) {
+	if len(cfg.Plans) == 0 {
+		return subscription.DefaultCatalog(), nil
+	}
+	plans := make([]subscription.Plan, 0, len(cfg.Plans))
+	for _, plan := range cfg.Plans {
+		plans = append(plans, subscription.Plan{
+			ID:           strings.TrimSpace(plan.ID),
+			Name:         strings.TrimSpace(plan.Name),
+			Description:  strings.TrimSpace(plan.Description),
+			Kind:         plan.Kind,
+			Period:       plan.Period,
+			Price:        plan.Price,
+			Currency:     plan.Currency,
+			TrafficQuota: plan.TrafficQuota,
+		})
+	}
+	catalog, err := subscription.NewCatalog(plans)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.WithDefaults(), nil
+}
+
+// newPaymentAdapters builds the configured payment webhook adapters.
+func newPaymentAdapters(cfg config.Payments) ([]payment.Adapter, error) {
+	adapters := make([]payment.Adapter, 0, len(cfg.Webhooks))
+	seen := make(map[string]struct{}, len(cfg.Webhooks))
+	for _, webhook := range cfg.Webhooks {
+		name := strings.TrimSpace(webhook.Name)
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate payment webhook %q", name)
+		}
+		seen[name] = struct{}{}
+		adapter, err := payment.New(webhook.Type, payment.Config{
+			Name:      name,
+			Secret:    strings.TrimSpace(webhook.Secret),
+			Tolerance: webhook.Tolerance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		adapters = append(adapters, adapter)
+	}
+	return adapters, nil
+}
@@ -29,6 +29,13 @@ type Config struct {
 	Xray    Xray    `yaml:"xray"`
 	Agent   Agent   `yaml:"agent"`
 	Agents  Agents  `yaml:"agents"`
+
+	DesktopSync   DesktopSync   `yaml:"desktopSync"`
+	RateLimit     RateLimit     `yaml:"rateLimit"`
+	WebAuthn      WebAuthn      `yaml:"webauthn"`
+	OIDC          OIDC          `yaml:"oidc"`
+	Subscriptions Subscriptions `yaml:"subscriptions"`
+	Payments      Payments      `yaml:"payments"`
 }
 
 // Server defines HTTP server configuration.
@@ -39,6 +46,11 @@ type Server struct {
 	TLS            TLS           `yaml:"tls"`
 	PublicURL      string        `yaml:"publicUrl"`
 	AllowedOrigins []string      `yaml:"allowedOrigins"`
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers name the client. Client
+	// addresses feed the per-IP rate limits, so the headers are ignored
+	// unless the connection comes from one of these.
+	TrustedProxies []string `yaml:"trustedProxies"`
 }
 
 // TLS describes TLS configuration for the server listener.
@@ -72,6 +84,55 @@ type Store struct {
 // Session defines session management configuration.
 type Session struct {
 	TTL time.Duration `yaml:"ttl"`
+	// Cache selects where sessions and MFA challenges are kept. Supported
+	// values are "memory" (default) and "redis".
+	Cache string       `yaml:"cache"`
+	Redis SessionRedis `yaml:"redis"`
+}
+
+// SessionRedis describes the Redis connection used when Session.Cache is
+// "redis".
+type SessionRedis struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"`
+}
+
+// RateLimit configures brute-force protection for the login, registration,
+// email verification and password reset endpoints.
+type RateLimit struct {
+	Enabled *bool `yaml:"enabled"`
+	// Backend selects where limits are tracked. Supported values are
+	// "memory" and "redis"; it defaults to Session.Cache and the Redis
+	// backend reuses Session.Redis.
+	Backend       string        `yaml:"backend"`
+	PerIP         RateLimitRule `yaml:"perIp"`
+	PerIdentifier RateLimitRule `yaml:"perIdentifier"`
+	Lockout       Lockout       `yaml:"lockout"`
+}
+
+// IsEnabled reports whether rate limiting is enabled. It is on unless
+// explicitly disabled.
+func (r RateLimit) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// RateLimitRule allows Burst requests at once, refilling over Period.
+type RateLimitRule struct {
+	Burst  int           `yaml:"burst"`
+	Period time.Duration `yaml:"period"`
+}
+
+// Lockout locks an account after Threshold failed password or TOTP attempts
+// within Window. The lockout starts at Duration and doubles with every
+// further failure up to MaxDuration.
+type Lockout struct {
+	Threshold   int           `yaml:"threshold"`
+	Duration    time.Duration `yaml:"duration"`
+	MaxDuration time.Duration `yaml:"maxDuration"`
+	Window      time.Duration `yaml:"window"`
 }
 
 // Auth defines authentication configuration.
@@ -87,18 +148,95 @@ type Token struct {
 	AccessSecret  string        `yaml:"accessSecret"`
 	AccessExpiry  time.Duration `yaml:"accessExpiry"`
 	RefreshExpiry time.Duration `yaml:"refreshExpiry"`
+	Signing       TokenSigning  `yaml:"signing"`
+}
+
+// TokenSigning configures asymmetric access token signing. When no keys are
+// configured access tokens are signed with AccessSecret.
+type TokenSigning struct {
+	ActiveKeyID string       `yaml:"activeKeyId"`
+	Keys        []SigningKey `yaml:"keys"`
+}
+
+// SigningKey references a PEM encoded RSA or Ed25519 private key. Keys other
+// than the active one are kept for verification until RetireAt.
+type SigningKey struct {
+	ID             string    `yaml:"id"`
+	PrivateKeyFile string    `yaml:"privateKeyFile"`
+	RetireAt       time.Time `yaml:"retireAt"`
 }
 
 // SMTP defines outbound SMTP configuration used for transactional email.
 type SMTP struct {
-	Host     string        `yaml:"host"`
-	Port     int           `yaml:"port"`
-	Username string        `yaml:"username"`
-	Password string        `yaml:"password"`
-	From     string        `yaml:"from"`
-	ReplyTo  string        `yaml:"replyTo"`
-	Timeout  time.Duration `yaml:"timeout"`
-	TLS      SMTPTLS       `yaml:"tls"`
+	// Transport selects how mail is delivered: "smtp" (the default), "http"
+	// for a JSON webhook, "file" for a local Maildir or "sendmail".
+	Transport string        `yaml:"transport"`
+	Host      string        `yaml:"host"`
+	Port      int           `yaml:"port"`
+	Username  string        `yaml:"username"`
+	Password  string        `yaml:"password"`
+	From      string        `yaml:"from"`
+	ReplyTo   string        `yaml:"replyTo"`
+	Timeout   time.Duration `yaml:"timeout"`
+	TLS       SMTPTLS       `yaml:"tls"`
+	// TemplateDir overrides the built-in email templates. It holds one
+	// directory per locale, such as en/ and zh-CN/, with the files to replace.
+	TemplateDir string       `yaml:"templateDir"`
+	Queue       SMTPQueue    `yaml:"queue"`
+	HTTP        SMTPHTTP     `yaml:"http"`
+	File        SMTPFile     `yaml:"file"`
+	Sendmail    SMTPSendmail `yaml:"sendmail"`
+	DKIM        SMTPDKIM     `yaml:"dkim"`
+}
+
+// SMTPHTTP configures the "http" transport, which posts every message as
+// JSON to URL with the given extra headers.
+type SMTPHTTP struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+// SMTPFile configures the "file" transport, which writes messages to a
+// local Maildir for development.
+type SMTPFile struct {
+	Dir string `yaml:"dir"`
+}
+
+// SMTPSendmail configures the "sendmail" transport. Path defaults to
+// /usr/sbin/sendmail and Args to ["-i"].
+type SMTPSendmail struct {
+	Path    string        `yaml:"path"`
+	Args    []string      `yaml:"args"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SMTPDKIM enables DKIM signing of messages sent by the "smtp" transport.
+// PrivateKeyFile holds a PEM encoded RSA or Ed25519 key; Headers overrides
+// the list of signed header fields.
+type SMTPDKIM struct {
+	Domain         string   `yaml:"domain"`
+	Selector       string   `yaml:"selector"`
+	PrivateKeyFile string   `yaml:"privateKeyFile"`
+	Headers        []string `yaml:"headers"`
+}
+
+// Enabled reports whether DKIM signing is configured.
+func (d SMTPDKIM) Enabled() bool {
+	return strings.TrimSpace(d.PrivateKeyFile) != ""
+}
+
+// SMTPQueue tunes the outbound mail queue. Messages are persisted in the
+// account store and retried with exponential backoff starting at
+// InitialBackoff and capped at MaxBackoff. After MaxAttempts failures, or a
+// permanent SMTP rejection, a message is dead-lettered. DrainTimeout bounds
+// the delivery of due messages on shutdown. Zero values select the defaults
+// of 8 attempts, 30s, 1h and 30s.
+type SMTPQueue struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	DrainTimeout   time.Duration `yaml:"drainTimeout"`
 }
 
 // SMTPTLS describes TLS settings for SMTP connections. Mode supports "auto",
@@ -112,7 +250,21 @@ type SMTPTLS struct {
 
 // Xray groups configuration related to synchronizing the Xray proxy.
 type Xray struct {
-	Sync XraySync `yaml:"sync"`
+	Sync  XraySync  `yaml:"sync"`
+	Stats XrayStats `yaml:"stats"`
+}
+
+// XrayStats configures per-user traffic accounting in agent mode. The agent
+// reads and resets the Xray user counters and reports them to the controller.
+type XrayStats struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval between two collections. Defaults to 1m.
+	Interval time.Duration `yaml:"interval"`
+	// Server is the Xray API listener. Defaults to 127.0.0.1:10085.
+	Server string `yaml:"server"`
+	// Command is the statsquery invocation, defaults to
+	// ["xray", "api", "statsquery"].
+	Command []string `yaml:"command"`
 }
 
 // XraySync defines options for periodically updating the Xray configuration.
@@ -123,17 +275,164 @@ type XraySync struct {
 	TemplatePath    string        `yaml:"templatePath"`
 	ValidateCommand []string      `yaml:"validateCommand"`
 	RestartCommand  []string      `yaml:"restartCommand"`
+	// HealthCommand probes Xray after RestartCommand, retried for up to
+	// HealthTimeout (default 10s). When validation, the restart or the probe
+	// fails the last-known-good configuration is restored.
+	HealthCommand []string      `yaml:"healthCommand"`
+	HealthTimeout time.Duration `yaml:"healthTimeout"`
+	// History is the number of applied configurations kept in HistoryDir,
+	// which defaults to OutputPath with a ".history" suffix. Defaults to 5;
+	// a negative value disables history.
+	History    int    `yaml:"history"`
+	HistoryDir string `yaml:"historyDir"`
+	// Inbounds selects the template inbounds, by tag, that receive clients.
+	// When empty every VLESS, VMess, Trojan and Shadowsocks inbound with a
+	// clients array is managed.
+	Inbounds []XrayInbound `yaml:"inbounds"`
+	// HotReload applies client-only changes through the Xray API instead of
+	// RestartCommand.
+	HotReload XrayHotReload `yaml:"hotReload"`
+	// Variables override the variables of the template served by the
+	// controller in agent mode, keeping node secrets such as Reality private
+	// keys on the node.
+	Variables map[string]string `yaml:"variables"`
+}
+
+// XrayHotReload configures applying client changes to a running Xray through
+// "xray api adu" and "xray api rmu", which requires HandlerService in the
+// template's api.services. Other changes still restart Xray.
+type XrayHotReload struct {
+	Enabled bool `yaml:"enabled"`
+	// Server is the Xray API listener. Defaults to 127.0.0.1:10085.
+	Server string `yaml:"server"`
+	// Command is the api invocation without subcommand, defaults to
+	// ["xray", "api"].
+	Command []string `yaml:"command"`
+}
+
+// XrayInbound assigns clients to a template inbound. Only users belonging to
+// one of Groups are added; an empty list admits every user.
+type XrayInbound struct {
+	Tag    string   `yaml:"tag"`
+	Groups []string `yaml:"groups"`
+}
+
+// Subscriptions configures the plan catalogue and lifecycle jobs.
+type Subscriptions struct {
+	// ExpiryInterval is how often lapsed subscriptions are expired. Defaults
+	// to 1m.
+	ExpiryInterval time.Duration `yaml:"expiryInterval"`
+	// Plans replaces the built-in catalogue. The onboarding trial (TRIAL-7D)
+	// stays available unless a plan with the same ID overrides it.
+	Plans []SubscriptionPlan `yaml:"plans"`
+}
+
+// SubscriptionPlan declares a plan users may subscribe to.
+type SubscriptionPlan struct {
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Kind is "subscription" (default) or "trial".
+	Kind string `yaml:"kind"`
+	// Period is the length of one billing period, for example 720h. Zero
+	// means the plan never lapses.
+	Period time.Duration `yaml:"period"`
+	// Price is expressed in the minor unit of Currency, for example cents.
+	Price    int64  `yaml:"price"`
+	Currency string `yaml:"currency"`
+	// TrafficQuota is the proxy traffic allowance per period in bytes. Zero
+	// means unlimited.
+	TrafficQuota int64 `yaml:"trafficQuota"`
+}
+
+// Payments configures payment provider integrations.
+type Payments struct {
+	Webhooks []PaymentWebhook `yaml:"webhooks"`
+}
+
+// PaymentWebhook registers a signed webhook endpoint at
+// /api/auth/payments/webhooks/{name}.
+type PaymentWebhook struct {
+	// Name identifies the provider in the webhook URL and is stored as the
+	// provider of the subscriptions it updates.
+	Name string `yaml:"name"`
+	// Type selects the adapter: "stripe" or "generic".
+	Type string `yaml:"type"`
+	// Secret is the HMAC signing secret shared with the provider.
+	Secret string `yaml:"secret"`
+	// Tolerance bounds the age of signed timestamps. Defaults to 5m.
+	Tolerance time.Duration `yaml:"tolerance"`
+}
+
+// DesktopSync configures the XStream desktop configuration sync endpoint.
+type DesktopSync struct {
+	Enabled bool `yaml:"enabled"`
+	// TemplatePath overrides the built-in client configuration template.
+	TemplatePath string `yaml:"templatePath"`
+	// TimestampSkew bounds the accepted client clock drift. Defaults to 5m.
+	TimestampSkew time.Duration `yaml:"timestampSkew"`
+	// RateLimitPerDevicePerDay caps syncs per device and user over a rolling
+	// day. It uses the rateLimit backend; zero disables the cap.
+	RateLimitPerDevicePerDay int `yaml:"rateLimitPerDevicePerDay"`
+}
+
+// WebAuthn configures security key and passkey sign in.
+type WebAuthn struct {
+	Enabled bool `yaml:"enabled"`
+	// RPID is the relying party ID, the registrable domain shared by the
+	// sign in pages, for example "svc.plus".
+	RPID   string `yaml:"rpId"`
+	RPName string `yaml:"rpName"`
+	// Origins lists the origins allowed to run ceremonies. Defaults to
+	// Server.AllowedOrigins.
+	Origins []string `yaml:"origins"`
+	// Timeout bounds a single ceremony. Defaults to 5m.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// OIDC configures federated sign in through OpenID Connect providers.
+type OIDC struct {
+	Providers []OIDCProvider `yaml:"providers"`
+}
+
+// OIDCProvider registers a single OpenID Connect provider.
+type OIDCProvider struct {
+	// Name identifies the provider in URLs and linked identities, for
+	// example "google". Changing it orphans existing links.
+	Name         string `yaml:"name"`
+	DisplayName  string `yaml:"displayName"`
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectURL is the sign in page that posts the code and state back to
+	// /api/auth/oidc/{name}/callback.
+	RedirectURL string   `yaml:"redirectUrl"`
+	Scopes      []string `yaml:"scopes"`
+	// AuthorizationURL, TokenURL and JWKSURL override discovered endpoints.
+	AuthorizationURL string `yaml:"authorizationUrl"`
+	TokenURL         string `yaml:"tokenUrl"`
+	JWKSURL          string `yaml:"jwksUrl"`
 }
 
 // Agent defines configuration for agent mode deployments.
 type Agent struct {
-	ID             string        `yaml:"id"`
-	ControllerURL  string        `yaml:"controllerUrl"`
-	APIToken       string        `yaml:"apiToken"`
-	HTTPTimeout    time.Duration `yaml:"httpTimeout"`
-	StatusInterval time.Duration `yaml:"statusInterval"`
-	SyncInterval   time.Duration `yaml:"syncInterval"`
-	TLS            AgentTLS      `yaml:"tls"`
+	ID            string `yaml:"id"`
+	ControllerURL string `yaml:"controllerUrl"`
+	APIToken      string `yaml:"apiToken"`
+	// EnrollmentCode is a one-time code exchanged for an agent credential
+	// when APIToken is empty. The issued credential is saved to
+	// CredentialsPath and reused on later starts.
+	EnrollmentCode  string        `yaml:"enrollmentCode"`
+	CredentialsPath string        `yaml:"credentialsPath"`
+	HTTPTimeout     time.Duration `yaml:"httpTimeout"`
+	StatusInterval  time.Duration `yaml:"statusInterval"`
+	SyncInterval    time.Duration `yaml:"syncInterval"`
+	// LongPollTimeout is how long the controller holds a client list
+	// request until a user changes, so changes apply within seconds instead
+	// of at the next sync interval. Defaults to 30s; negative disables long
+	// polling. The controller caps it at one minute.
+	LongPollTimeout time.Duration `yaml:"longPollTimeout"`
+	TLS             AgentTLS      `yaml:"tls"`
 }
 
 // AgentTLS configures TLS behaviour for the agent HTTP client.
@@ -144,6 +443,36 @@ type AgentTLS struct {
 // Agents describes the controller-side agent configuration.
 type Agents struct {
 	Credentials []AgentCredential `yaml:"credentials"`
+	// StatusRetention bounds how long agent status reports are kept in the
+	// database. Defaults to seven days.
+	StatusRetention time.Duration `yaml:"statusRetention"`
+	// CredentialTTL bounds how long a cached agent token is trusted before
+	// it is checked against the database again. Defaults to 30s.
+	CredentialTTL time.Duration `yaml:"credentialTTL"`
+	// TrafficRetention bounds how long the live traffic totals of a client
+	// are kept after it stopped appearing in agent reports. Defaults to 24h.
+	TrafficRetention time.Duration `yaml:"trafficRetention"`
+	// ClientRefreshInterval bounds how stale the client list served to
+	// agents may be and how quickly long-polling agents see a change.
+	// Defaults to 2s.
+	ClientRefreshInterval time.Duration `yaml:"clientRefreshInterval"`
+	// Templates assigns Xray server templates to agents by ID or group.
+	// Templates saved through the admin API replace the entries with the
+	// same name.
+	Templates []AgentTemplate `yaml:"templates"`
+}
+
+// AgentTemplate is an Xray server template file served to the agents listed
+// in Agents and to those belonging to one of Groups. A template listing
+// neither is served to every agent without a more specific match. ${NAME}
+// placeholders in the file are substituted with Variables and the built-in
+// NODE_ID and NODE_NAME when the agent renders it.
+type AgentTemplate struct {
+	Name      string            `yaml:"name"`
+	Path      string            `yaml:"path"`
+	Agents    []string          `yaml:"agents"`
+	Groups    []string          `yaml:"groups"`
+	Variables map[string]string `yaml:"variables"`
 }
 
 // AgentCredential represents a single agent identity authorised to call the
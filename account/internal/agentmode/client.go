@@ -46,7 +46,36 @@ func NewClient(baseURL, token string, opts ClientOptions) (*Client, error) {
 	if token == "" {
 		return nil, errors.New("controller token is required")
 	}
+	return newClient(parsed, token, opts), nil
+}
+
+// Enroll redeems a one-time enrollment code at the controller and returns the
+// issued agent credential.
+func Enroll(ctx context.Context, baseURL, code string, opts ClientOptions) (agentproto.EnrollResponse, error) {
+	trimmedURL := strings.TrimSpace(baseURL)
+	if trimmedURL == "" {
+		return agentproto.EnrollResponse{}, errors.New("controller url is required")
+	}
+	parsed, err := url.Parse(trimmedURL)
+	if err != nil {
+		return agentproto.EnrollResponse{}, fmt.Errorf("parse controller url: %w", err)
+	}
+	buf, err := json.Marshal(agentproto.EnrollRequest{Code: strings.TrimSpace(code)})
+	if err != nil {
+		return agentproto.EnrollResponse{}, fmt.Errorf("encode enrollment request: %w", err)
+	}
+
+	var payload agentproto.EnrollResponse
+	if err := newClient(parsed, "", opts).post(ctx, "/api/agent/v1/enroll", buf, &payload); err != nil {
+		return agentproto.EnrollResponse{}, err
+	}
+	if strings.TrimSpace(payload.Token) == "" {
+		return agentproto.EnrollResponse{}, errors.New("controller returned an empty agent token")
+	}
+	return payload, nil
+}
 
+func newClient(baseURL *url.URL, token string, opts ClientOptions) *Client {
 	timeout := opts.Timeout
 	if timeout <= 0 {
 		timeout = 15 * time.Second
@@ -75,54 +104,126 @@ func NewClient(baseURL, token string, opts ClientOptions) (*Client, error) {
 	}
 
 	return &Client{
-		baseURL:   parsed,
+		baseURL:   baseURL,
 		token:     token,
 		http:      client,
 		userAgent: userAgent,
-	}, nil
+	}
 }
 
 // ListClients fetches the current set of Xray clients from the controller.
-func (c *Client) ListClients(ctx context.Context) (agentproto.ClientListResponse, error) {
+// When revision is set it is sent as If-None-Match and changed is false if
+// the controller still holds that revision. A positive wait asks the
+// controller to hold the request until the list changes, for at most wait.
+func (c *Client) ListClients(ctx context.Context, revision string, wait time.Duration) (payload agentproto.ClientListResponse, changed bool, err error) {
 	endpoint, err := url.JoinPath(c.baseURL.String(), "/api/agent/v1/users")
 	if err != nil {
-		return agentproto.ClientListResponse{}, err
+		return agentproto.ClientListResponse{}, false, err
+	}
+	httpClient := c.http
+	if wait > 0 {
+		endpoint += "?wait=" + url.QueryEscape(wait.String())
+		extended := *c.http
+		extended.Timeout += wait
+		httpClient = &extended
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return agentproto.ClientListResponse{}, err
+		return agentproto.ClientListResponse{}, false, err
 	}
 	c.applyHeaders(req)
 	req.Header.Set("Accept", "application/json")
+	if revision != "" {
+		req.Header.Set("If-None-Match", `"`+revision+`"`)
+	}
 
-	resp, err := c.http.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return agentproto.ClientListResponse{}, err
+		return agentproto.ClientListResponse{}, false, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return agentproto.ClientListResponse{}, false, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<14))
-		return agentproto.ClientListResponse{}, fmt.Errorf("controller returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return agentproto.ClientListResponse{}, false, fmt.Errorf("controller returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
 	}
 
-	var payload agentproto.ClientListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return agentproto.ClientListResponse{}, fmt.Errorf("decode client list: %w", err)
+		return agentproto.ClientListResponse{}, false, fmt.Errorf("decode client list: %w", err)
 	}
-	return payload, nil
+	return payload, true, nil
+}
+
+// FetchTemplate fetches the Xray server template the controller assigned to
+// this agent. ok is false when no template is assigned.
+func (c *Client) FetchTemplate(ctx context.Context) (template agentproto.TemplateResponse, ok bool, err error) {
+	endpoint, err := url.JoinPath(c.baseURL.String(), "/api/agent/v1/template")
+	if err != nil {
+		return agentproto.TemplateResponse{}, false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return agentproto.TemplateResponse{}, false, err
+	}
+	c.applyHeaders(req)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return agentproto.TemplateResponse{}, false, err
+	}
+	defer resp.Body.Close()
+
+	// Controllers predating per-node templates answer 404.
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+		return agentproto.TemplateResponse{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<14))
+		return agentproto.TemplateResponse{}, false, fmt.Errorf("controller returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&template); err != nil {
+		return agentproto.TemplateResponse{}, false, fmt.Errorf("decode template: %w", err)
+	}
+	return template, true, nil
 }
 
 // ReportStatus submits the agent status report to the controller.
 func (c *Client) ReportStatus(ctx context.Context, report agentproto.StatusReport) error {
-	endpoint, err := url.JoinPath(c.baseURL.String(), "/api/agent/v1/status")
+	buf, err := json.Marshal(report)
 	if err != nil {
-		return err
+		return fmt.Errorf("encode status report: %w", err)
 	}
+	return c.post(ctx, "/api/agent/v1/status", buf, nil)
+}
 
+// ReportUsage submits per-client traffic deltas to the controller.
+func (c *Client) ReportUsage(ctx context.Context, report agentproto.UsageReport) error {
 	buf, err := json.Marshal(report)
 	if err != nil {
-		return fmt.Errorf("encode status report: %w", err)
+		return fmt.Errorf("encode usage report: %w", err)
+	}
+	return c.post(ctx, "/api/agent/v1/usage", buf, nil)
+}
+
+// ReportTraffic submits per-client traffic counters to the controller.
+func (c *Client) ReportTraffic(ctx context.Context, report agentproto.TrafficReport) error {
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("encode traffic report: %w", err)
+	}
+	return c.post(ctx, "/api/agent/v1/traffic", buf, nil)
+}
+
+// post sends buf as JSON and decodes the response into out unless it is nil.
+func (c *Client) post(ctx context.Context, path string, buf []byte, out any) error {
+	endpoint, err := url.JoinPath(c.baseURL.String(), path)
+	if err != nil {
+		return err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
@@ -142,10 +243,17 @@ func (c *Client) ReportStatus(ctx context.Context, report agentproto.StatusRepor
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<14))
 		return fmt.Errorf("controller returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
 	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode controller response: %w", err)
+		}
+	}
 	return nil
 }
 
 func (c *Client) applyHeaders(req *http.Request) {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 	req.Header.Set("User-Agent", c.userAgent)
 }
@@ -0,0 +1,94 @@
+package agentmode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"account/config"
+	"account/internal/agentproto"
+)
+
+// DefaultCredentialsPath is where an enrolled agent saves its credential when
+// agent.credentialsPath is unset.
+const DefaultCredentialsPath = "/var/lib/xcontrol/agent-credentials.json"
+
+type enrollFunc func(ctx context.Context, baseURL, code string, opts ClientOptions) (agentproto.EnrollResponse, error)
+
+// resolveToken returns the bearer token the agent authenticates with:
+// agent.apiToken when set, otherwise the credential saved by a previous
+// enrollment, otherwise one obtained by redeeming agent.enrollmentCode, which
+// is saved for later starts.
+func resolveToken(ctx context.Context, agent config.Agent, opts ClientOptions, enroll enrollFunc, logger *slog.Logger) (string, error) {
+	if token := strings.TrimSpace(agent.APIToken); token != "" {
+		return token, nil
+	}
+
+	path := strings.TrimSpace(agent.CredentialsPath)
+	if path == "" {
+		path = DefaultCredentialsPath
+	}
+	saved, err := loadCredentials(path)
+	if err == nil {
+		return saved.Token, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	code := strings.TrimSpace(agent.EnrollmentCode)
+	if code == "" {
+		return "", errors.New("agent.apiToken or agent.enrollmentCode is required")
+	}
+	credentials, err := enroll(ctx, agent.ControllerURL, code, opts)
+	if err != nil {
+		return "", fmt.Errorf("enroll agent: %w", err)
+	}
+	if err := saveCredentials(path, credentials); err != nil {
+		return "", err
+	}
+	logger.Info("agent enrolled", "id", credentials.ID, "credentials", path)
+	return credentials.Token, nil
+}
+
+func loadCredentials(path string) (agentproto.EnrollResponse, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return agentproto.EnrollResponse{}, err
+	}
+	var credentials agentproto.EnrollResponse
+	if err := json.Unmarshal(payload, &credentials); err != nil {
+		return agentproto.EnrollResponse{}, fmt.Errorf("decode agent credentials %s: %w", path, err)
+	}
+	if strings.TrimSpace(credentials.Token) == "" {
+		return agentproto.EnrollResponse{}, fmt.Errorf("agent credentials %s contain no token", path)
+	}
+	return credentials, nil
+}
+
+// saveCredentials writes the credential readable by the owner only. The file
+// is written to a temporary sibling first so a crash never leaves a
+// truncated credential behind.
+func saveCredentials(path string, credentials agentproto.EnrollResponse) error {
+	payload, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create agent credentials directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, payload, 0o600); err != nil {
+		return fmt.Errorf("write agent credentials: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write agent credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package agentmode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"account/config"
+	"account/internal/agentproto"
+)
+
+func TestResolveTokenEnrollsOnceAndReusesCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent", "credentials.json")
+	agent := config.Agent{ControllerURL: "https://controller.example", EnrollmentCode: "xce_code", CredentialsPath: path}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	calls := 0
+	enroll := func(ctx context.Context, baseURL, code string, opts ClientOptions) (agentproto.EnrollResponse, error) {
+		calls++
+		if code != "xce_code" {
+			return agentproto.EnrollResponse{}, errors.New("unexpected code")
+		}
+		return agentproto.EnrollResponse{ID: "edge", Token: "xca_token"}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := resolveToken(context.Background(), agent, ClientOptions{}, enroll, logger)
+		if err != nil {
+			t.Fatalf("resolve token: %v", err)
+		}
+		if token != "xca_token" {
+			t.Fatalf("unexpected token %q", token)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single enrollment, got %d", calls)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat credentials: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected credentials to be private, got %v", info.Mode().Perm())
+	}
+
+	agent.APIToken = "static"
+	if token, err := resolveToken(context.Background(), agent, ClientOptions{}, enroll, logger); err != nil || token != "static" {
+		t.Fatalf("expected apiToken to take precedence, got %q (%v)", token, err)
+	}
+
+	if _, err := resolveToken(context.Background(), config.Agent{CredentialsPath: filepath.Join(t.TempDir(), "missing.json")}, ClientOptions{}, enroll, logger); err == nil {
+		t.Fatalf("expected an error without token or enrollment code")
+	}
+}
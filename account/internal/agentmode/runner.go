@@ -13,6 +13,7 @@ import (
 	"account/config"
 	"account/internal/agentproto"
 	"account/internal/xrayconfig"
+	"account/internal/xraystats"
 )
 
 // Options configures the agent runtime.
@@ -22,6 +23,9 @@ type Options struct {
 	Xray   config.Xray
 }
 
+// defaultLongPollTimeout is used when config.Agent.LongPollTimeout is unset.
+const defaultLongPollTimeout = 30 * time.Second
+
 // Run launches the agent mode control loop. It blocks until the context is
 // cancelled or a fatal error occurs during setup.
 func Run(ctx context.Context, opts Options) error {
@@ -38,10 +42,6 @@ func Run(ctx context.Context, opts Options) error {
 	if controllerURL == "" {
 		return errors.New("agent.controllerUrl is required")
 	}
-	token := strings.TrimSpace(opts.Agent.APIToken)
-	if token == "" {
-		return errors.New("agent.apiToken is required")
-	}
 
 	syncInterval := opts.Agent.SyncInterval
 	if syncInterval <= 0 {
@@ -66,25 +66,51 @@ func Run(ctx context.Context, opts Options) error {
 		outputPath = "/usr/local/etc/xray/config.json"
 	}
 
-	client, err := NewClient(controllerURL, token, ClientOptions{
+	clientOptions := ClientOptions{
 		Timeout:            httpTimeout,
 		InsecureSkipVerify: opts.Agent.TLS.InsecureSkipVerify,
 		UserAgent:          buildUserAgent(opts.Agent.ID),
-	})
+	}
+	token, err := resolveToken(ctx, opts.Agent, clientOptions, Enroll, logger)
+	if err != nil {
+		return err
+	}
+	client, err := NewClient(controllerURL, token, clientOptions)
 	if err != nil {
 		return err
 	}
 
 	tracker := newSyncTracker()
 	source := NewHTTPClientSource(client, tracker)
+	source.longPoll = opts.Agent.LongPollTimeout
+	if source.longPoll == 0 {
+		source.longPoll = defaultLongPollTimeout
+	}
 
-	generator := xrayconfig.Generator{Definition: xrayconfig.DefaultDefinition(), OutputPath: outputPath}
+	localDefinition := xrayconfig.DefaultDefinition()
 	if templatePath := strings.TrimSpace(opts.Xray.Sync.TemplatePath); templatePath != "" {
 		payload, err := os.ReadFile(templatePath)
 		if err != nil {
 			return fmt.Errorf("load xray template %s: %w", templatePath, err)
 		}
-		generator.Definition = xrayconfig.JSONDefinition{Raw: append([]byte(nil), payload...)}
+		localDefinition = xrayconfig.TemplateDefinition{Raw: append([]byte(nil), payload...), Variables: opts.Xray.Sync.Variables}
+	}
+	// The template assigned by the controller takes precedence over the
+	// local one.
+	definition := newRemoteDefinition(localDefinition, opts.Xray.Sync.Variables)
+	source.templates = definition
+
+	generator := xrayconfig.Generator{Definition: definition, OutputPath: outputPath}
+	for _, inbound := range opts.Xray.Sync.Inbounds {
+		generator.Inbounds = append(generator.Inbounds, xrayconfig.Inbound{
+			Tag:    strings.TrimSpace(inbound.Tag),
+			Groups: append([]string(nil), inbound.Groups...),
+		})
+	}
+
+	var users xrayconfig.UserManager
+	if hotReload := opts.Xray.Sync.HotReload; hotReload.Enabled {
+		users = &xrayconfig.CommandUserManager{Command: hotReload.Command, Server: hotReload.Server}
 	}
 
 	syncLogger := logger.With("component", "agent-xray-sync")
@@ -95,12 +121,20 @@ func Run(ctx context.Context, opts Options) error {
 		Generator:       generator,
 		ValidateCommand: opts.Xray.Sync.ValidateCommand,
 		RestartCommand:  opts.Xray.Sync.RestartCommand,
+		Users:           users,
+		HealthCommand:   opts.Xray.Sync.HealthCommand,
+		HealthTimeout:   opts.Xray.Sync.HealthTimeout,
+		History:         opts.Xray.Sync.History,
+		HistoryDir:      opts.Xray.Sync.HistoryDir,
 		OnSync: func(result xrayconfig.SyncResult) {
+			if result.RolledBack {
+				tracker.MarkRollback(result.Error, result.ConfigHash, result.CompletedAt)
+			}
 			if result.Error != nil {
 				tracker.MarkError(result.Error, result.CompletedAt)
 				return
 			}
-			tracker.MarkSuccess(result.CompletedAt)
+			tracker.MarkSuccess(result.CompletedAt, result.ConfigHash)
 		},
 	})
 	if err != nil {
@@ -122,11 +156,28 @@ func Run(ctx context.Context, opts Options) error {
 	reporterCtx, reporterCancel := context.WithCancel(ctx)
 	defer reporterCancel()
 
-	var wg sync.WaitGroup
+	var (
+		wg        sync.WaitGroup
+		collector *usageCollector
+	)
+	if opts.Xray.Stats.Enabled {
+		usageInterval := opts.Xray.Stats.Interval
+		if usageInterval <= 0 {
+			usageInterval = time.Minute
+		}
+		querier := &xraystats.CommandQuerier{Command: opts.Xray.Stats.Command, Server: opts.Xray.Stats.Server}
+		collector = newUsageCollector(querier, client, tracker, logger.With("component", "agent-usage"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collector.run(reporterCtx, usageInterval)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runStatusReporter(reporterCtx, client, tracker, statusInterval, syncInterval, logger)
+		runStatusReporter(reporterCtx, client, tracker, collector, statusInterval, syncInterval, logger)
 	}()
 
 	<-ctx.Done()
@@ -143,7 +194,9 @@ func buildUserAgent(id string) string {
 	return fmt.Sprintf("xcontrol-agent/%s", id)
 }
 
-func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker, interval, syncInterval time.Duration, logger *slog.Logger) {
+// runStatusReporter periodically reports the agent status and, when traffic
+// collection is enabled, the traffic counters gathered by collector.
+func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker, collector *usageCollector, interval, syncInterval time.Duration, logger *slog.Logger) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -153,6 +206,12 @@ func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker
 		if err := client.ReportStatus(ctx, report); err != nil {
 			logger.Warn("failed to report agent status", "err", err)
 		}
+		if collector == nil {
+			return
+		}
+		if err := collector.flushTraffic(ctx); err != nil {
+			logger.Warn("failed to report traffic stats", "err", err)
+		}
 	}
 
 	send()
@@ -193,6 +252,8 @@ func buildStatusReport(snapshot trackerSnapshot, syncInterval time.Duration) age
 				copy := *lastSyncPtr
 				return &copy
 			}(),
+			ConfigHash:   snapshot.ConfigHash,
+			LastRollback: snapshot.Rollback,
 		},
 	}
 
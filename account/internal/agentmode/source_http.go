@@ -2,15 +2,31 @@ package agentmode
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"account/internal/xrayconfig"
 )
 
-// HTTPClientSource retrieves Xray clients from the controller over HTTP.
+// HTTPClientSource retrieves Xray clients from the controller over HTTP. It
+// remembers the revision of the last list and only downloads the list again
+// once the controller reports a new revision.
 type HTTPClientSource struct {
 	client  *Client
 	tracker *syncTracker
+	// templates, when set, receives the template assigned to this agent,
+	// fetched together with every client list.
+	templates *remoteDefinition
+	// longPoll is how long WaitForChange asks the controller to hold a
+	// request. Zero disables watching.
+	longPoll time.Duration
+
+	mu       sync.Mutex
+	clients  []xrayconfig.Client
+	revision string
+	fetched  bool
 }
 
 // NewHTTPClientSource constructs a source backed by the provided client and
@@ -20,14 +36,71 @@ func NewHTTPClientSource(client *Client, tracker *syncTracker) *HTTPClientSource
 }
 
 // ListClients implements xrayconfig.ClientSource by fetching the latest client
-// list via the controller API.
+// list via the controller API. The assigned template is refreshed as well so
+// the render that follows uses both.
 func (s *HTTPClientSource) ListClients(ctx context.Context) ([]xrayconfig.Client, error) {
-	resp, err := s.client.ListClients(ctx)
+	s.mu.Lock()
+	revision := s.revision
+	s.mu.Unlock()
+
+	resp, changed, err := s.client.ListClients(ctx, revision, 0)
 	if err != nil {
 		return nil, err
 	}
+	if s.templates != nil {
+		template, ok, err := s.client.FetchTemplate(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetch xray template: %w", err)
+		}
+		s.templates.update(template, ok)
+	}
+
+	s.mu.Lock()
+	if changed {
+		s.clients = resp.Clients
+		s.revision = resp.Revision
+		s.fetched = true
+	}
+	clients, revision := s.clients, s.revision
+	s.mu.Unlock()
+
 	if s.tracker != nil {
-		s.tracker.UpdateFetch(len(resp.Clients), resp.Revision, time.Now().UTC())
+		s.tracker.UpdateFetch(clients, revision, time.Now().UTC())
+	}
+	return clients, nil
+}
+
+// WaitForChange implements xrayconfig.ChangeWatcher by long-polling the
+// controller with the revision of the last client list. A changed list is
+// kept so the following ListClients call does not download it again.
+// Template changes are not watched and are picked up on the sync interval.
+func (s *HTTPClientSource) WaitForChange(ctx context.Context) (bool, error) {
+	if s.longPoll <= 0 {
+		return false, xrayconfig.ErrWatchUnsupported
+	}
+	s.mu.Lock()
+	revision, fetched := s.revision, s.fetched
+	s.mu.Unlock()
+	if !fetched {
+		return false, errors.New("no client list fetched yet")
+	}
+	if revision == "" {
+		// The controller predates client list revisions.
+		return false, xrayconfig.ErrWatchUnsupported
+	}
+
+	resp, changed, err := s.client.ListClients(ctx, revision, s.longPoll)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revision != revision {
+		// ListClients stored a newer list meanwhile.
+		return true, nil
 	}
-	return resp.Clients, nil
+	s.clients = resp.Clients
+	s.revision = resp.Revision
+	return resp.Revision != revision, nil
 }
@@ -0,0 +1,90 @@
+package agentmode
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"account/internal/agentproto"
+	"account/internal/xrayconfig"
+)
+
+func TestHTTPClientSourceUsesRevisions(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		revision = "rev-1"
+		clients  = []xrayconfig.Client{{ID: "uuid-a"}}
+		changed  = make(chan struct{})
+		full     int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/agent/v1/users" {
+			http.NotFound(w, r)
+			return
+		}
+		known := strings.Trim(r.Header.Get("If-None-Match"), `"`)
+		if r.URL.Query().Get("wait") != "" {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if known == revision {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		_ = json.NewEncoder(w).Encode(agentproto.ClientListResponse{Clients: clients, Total: len(clients), Revision: revision})
+	}))
+	defer server.Close()
+	downloads := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return full
+	}
+
+	client, err := NewClient(server.URL, "token", ClientOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	source := NewHTTPClientSource(client, newSyncTracker())
+	ctx := context.Background()
+
+	if _, err := source.WaitForChange(ctx); err != xrayconfig.ErrWatchUnsupported {
+		t.Fatalf("expected watching to require a long poll timeout, got %v", err)
+	}
+	source.longPoll = time.Second
+
+	for i := 0; i < 2; i++ {
+		listed, err := source.ListClients(ctx)
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("list clients: %+v, %v", listed, err)
+		}
+	}
+	if downloads() != 1 {
+		t.Fatalf("expected the unchanged list to be served from cache, got %d downloads", downloads())
+	}
+
+	mu.Lock()
+	revision = "rev-2"
+	clients = append(clients, xrayconfig.Client{ID: "uuid-b"})
+	mu.Unlock()
+	close(changed)
+
+	ok, err := source.WaitForChange(ctx)
+	if err != nil || !ok {
+		t.Fatalf("expected change to be reported, got %v, %v", ok, err)
+	}
+	listed, err := source.ListClients(ctx)
+	if err != nil || len(listed) != 2 || downloads() != 2 {
+		t.Fatalf("expected the changed list without another download, got %+v (%d downloads, %v)", listed, downloads(), err)
+	}
+}
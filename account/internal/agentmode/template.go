@@ -0,0 +1,55 @@
+package agentmode
+
+import (
+	"sync"
+
+	"account/internal/agentproto"
+	"account/internal/xrayconfig"
+)
+
+// remoteDefinition renders the Xray template the controller assigned to this
+// agent and falls back to the local definition while none is assigned. Local
+// variables override those served by the controller.
+type remoteDefinition struct {
+	fallback  xrayconfig.Definition
+	variables map[string]string
+
+	mu       sync.RWMutex
+	template *agentproto.TemplateResponse
+}
+
+func newRemoteDefinition(fallback xrayconfig.Definition, variables map[string]string) *remoteDefinition {
+	return &remoteDefinition{fallback: fallback, variables: variables}
+}
+
+// update replaces the assigned template. ok is false when the controller has
+// no template for this agent.
+func (d *remoteDefinition) update(template agentproto.TemplateResponse, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !ok {
+		d.template = nil
+		return
+	}
+	d.template = &template
+}
+
+// Base implements xrayconfig.Definition.
+func (d *remoteDefinition) Base() (map[string]interface{}, error) {
+	d.mu.RLock()
+	template := d.template
+	d.mu.RUnlock()
+
+	if template == nil {
+		return d.fallback.Base()
+	}
+	variables := make(map[string]string, len(template.Variables)+len(d.variables))
+	for key, value := range template.Variables {
+		variables[key] = value
+	}
+	for key, value := range d.variables {
+		variables[key] = value
+	}
+	return xrayconfig.TemplateDefinition{Raw: []byte(template.Template), Variables: variables}.Base()
+}
@@ -0,0 +1,71 @@
+package agentmode
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"account/internal/agentproto"
+	"account/internal/xrayconfig"
+)
+
+func TestHTTPClientSourceRendersAssignedTemplate(t *testing.T) {
+	var template *agentproto.TemplateResponse
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/agent/v1/users":
+			_ = json.NewEncoder(w).Encode(agentproto.ClientListResponse{Clients: []xrayconfig.Client{{ID: "uuid-a"}}, Total: 1})
+		case "/api/agent/v1/template":
+			if template == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(template)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token", ClientOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	local := xrayconfig.JSONDefinition{Raw: []byte(`{"inbounds": [{"tag": "local", "protocol": "vless", "settings": {"clients": []}}]}`)}
+	definition := newRemoteDefinition(local, map[string]string{"PRIVATE_KEY": "node-secret"})
+	source := NewHTTPClientSource(client, newSyncTracker())
+	source.templates = definition
+	generator := xrayconfig.Generator{Definition: definition}
+
+	render := func() string {
+		t.Helper()
+		clients, err := source.ListClients(context.Background())
+		if err != nil {
+			t.Fatalf("list clients: %v", err)
+		}
+		raw, err := generator.Render(clients)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		return string(raw)
+	}
+
+	if output := render(); !strings.Contains(output, `"local"`) {
+		t.Fatalf("expected local template without an assignment, got %s", output)
+	}
+
+	template = &agentproto.TemplateResponse{
+		Name:      "hk",
+		Template:  `{"inbounds": [{"tag": "${NODE_ID}", "port": ${PORT}, "protocol": "vless", "settings": {"clients": [], "key": "${PRIVATE_KEY}"}}]}`,
+		Variables: map[string]string{"NODE_ID": "edge-1", "PORT": "443", "PRIVATE_KEY": "controller-value"},
+	}
+	output := render()
+	for _, expected := range []string{`"edge-1"`, `"port": 443`, `"node-secret"`, `"uuid-a"`} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %s in rendered config, got %s", expected, output)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package agentmode
 
 import (
+	"strings"
 	"sync"
 	"time"
+
+	"account/internal/agentproto"
+	"account/internal/xrayconfig"
 )
 
 type syncTracker struct {
@@ -13,26 +17,53 @@ type syncTracker struct {
 	lastSuccess time.Time
 	lastError   string
 	lastErrorAt time.Time
+	configHash  string
+	rollback    *agentproto.RollbackEvent
+	// labels maps the email label Xray keys user stats by to the client ID.
+	// Entries are kept after a client is removed so traffic it served before
+	// the removal can still be attributed.
+	labels map[string]string
 }
 
 func newSyncTracker() *syncTracker {
-	return &syncTracker{}
+	return &syncTracker{labels: make(map[string]string)}
 }
 
-func (t *syncTracker) UpdateFetch(count int, revision string, when time.Time) {
+func (t *syncTracker) UpdateFetch(clients []xrayconfig.Client, revision string, when time.Time) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	t.clients = count
+	t.clients = len(clients)
 	t.revision = revision
 	t.lastFetch = when
+	for _, client := range clients {
+		id := strings.TrimSpace(client.ID)
+		if id == "" {
+			continue
+		}
+		t.labels[id] = id
+		if email := strings.TrimSpace(client.Email); email != "" {
+			t.labels[email] = id
+		}
+	}
+}
+
+// ResolveClient returns the ID of the client Xray reports stats for under
+// label.
+func (t *syncTracker) ResolveClient(label string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	id, ok := t.labels[label]
+	return id, ok
 }
 
-func (t *syncTracker) MarkSuccess(at time.Time) {
+func (t *syncTracker) MarkSuccess(at time.Time, configHash string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	t.lastSuccess = at
+	t.configHash = configHash
 	t.lastError = ""
 	t.lastErrorAt = time.Time{}
 }
@@ -48,6 +79,19 @@ func (t *syncTracker) MarkError(err error, at time.Time) {
 	t.lastErrorAt = at
 }
 
+// MarkRollback records a rollback to the last-known-good configuration with
+// the given hash.
+func (t *syncTracker) MarkRollback(reason error, configHash string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.configHash = configHash
+	t.rollback = &agentproto.RollbackEvent{At: at, ConfigHash: configHash}
+	if reason != nil {
+		t.rollback.Reason = reason.Error()
+	}
+}
+
 type trackerSnapshot struct {
 	Clients     int
 	Revision    string
@@ -55,6 +99,8 @@ type trackerSnapshot struct {
 	LastSuccess time.Time
 	LastError   string
 	LastErrorAt time.Time
+	ConfigHash  string
+	Rollback    *agentproto.RollbackEvent
 }
 
 func (t *syncTracker) Snapshot() trackerSnapshot {
@@ -68,5 +114,7 @@ func (t *syncTracker) Snapshot() trackerSnapshot {
 		LastSuccess: t.lastSuccess,
 		LastError:   t.lastError,
 		LastErrorAt: t.lastErrorAt,
+		ConfigHash:  t.configHash,
+		Rollback:    t.rollback,
 	}
 }
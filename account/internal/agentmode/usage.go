@@ -0,0 +1,214 @@
+package agentmode
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"account/internal/agentproto"
+	"account/internal/xraystats"
+)
+
+type usageSink interface {
+	ReportUsage(ctx context.Context, report agentproto.UsageReport) error
+	ReportTraffic(ctx context.Context, report agentproto.TrafficReport) error
+}
+
+// usageKey identifies the pending usage of a client on one UTC day.
+type usageKey struct {
+	id  string
+	day time.Time
+}
+
+// usageCollector reads and resets the Xray user counters and forwards the
+// deltas to the controller, both as persisted usage and as live traffic
+// stats. A usage report that could not be delivered is resent unchanged under
+// the same report ID before newer deltas are reported, so a controller outage
+// neither loses nor double counts traffic.
+type usageCollector struct {
+	querier xraystats.Querier
+	sink    usageSink
+	tracker *syncTracker
+	logger  *slog.Logger
+	now     func() time.Time
+
+	mu          sync.Mutex
+	pending     map[usageKey]*agentproto.UserUsage
+	inflight    *agentproto.UsageReport
+	traffic     map[string]*agentproto.ClientTraffic
+	connections map[string]int64
+}
+
+func newUsageCollector(querier xraystats.Querier, sink usageSink, tracker *syncTracker, logger *slog.Logger) *usageCollector {
+	return &usageCollector{
+		querier: querier,
+		sink:    sink,
+		tracker: tracker,
+		logger:  logger,
+		now:     time.Now,
+		pending: make(map[usageKey]*agentproto.UserUsage),
+		traffic: make(map[string]*agentproto.ClientTraffic),
+	}
+}
+
+func (u *usageCollector) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Deliver what was counted since the last tick before exiting.
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			u.tick(finalCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			u.tick(ctx)
+		}
+	}
+}
+
+func (u *usageCollector) tick(ctx context.Context) {
+	if err := u.collect(ctx); err != nil {
+		u.logger.Warn("failed to query xray stats", "err", err)
+	}
+	if err := u.flush(ctx); err != nil {
+		u.logger.Warn("failed to report traffic usage", "err", err)
+	}
+}
+
+// collect reads and resets the counters and adds them to the pending deltas
+// of the current UTC day. When the querier can report live connections they are refreshed for the
+// clients that transferred data since the previous collection; the others
+// are considered idle.
+func (u *usageCollector) collect(ctx context.Context) error {
+	entries, err := u.querier.QueryUserTraffic(ctx, true)
+	if err != nil {
+		return err
+	}
+	connQuerier, countConnections := u.querier.(xraystats.ConnectionQuerier)
+	connections := make(map[string]int64)
+	now := u.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Uplink == 0 && entry.Downlink == 0 {
+			continue
+		}
+		id, ok := u.tracker.ResolveClient(entry.Email)
+		if !ok {
+			u.logger.Debug("dropping traffic of unknown xray user", "email", entry.Email)
+			continue
+		}
+		key := usageKey{id: id, day: day}
+		usage, ok := u.pending[key]
+		if !ok {
+			usage = &agentproto.UserUsage{ID: id, Day: day}
+			u.pending[key] = usage
+		}
+		usage.Uplink += entry.Uplink
+		usage.Downlink += entry.Downlink
+
+		traffic, ok := u.traffic[id]
+		if !ok {
+			traffic = &agentproto.ClientTraffic{ID: id}
+			u.traffic[id] = traffic
+		}
+		traffic.Uplink += entry.Uplink
+		traffic.Downlink += entry.Downlink
+
+		if countConnections {
+			count, err := connQuerier.QueryUserConnections(ctx, entry.Email)
+			if err != nil {
+				u.logger.Debug("failed to query xray connections", "email", entry.Email, "err", err)
+				continue
+			}
+			if count > 0 {
+				connections[id] += count
+			}
+		}
+	}
+	if countConnections {
+		u.connections = connections
+	}
+	return nil
+}
+
+// flush resends the report the controller has not accepted yet, then reports
+// the pending deltas under a new report ID. A report is dropped only once the
+// controller has accepted it.
+func (u *usageCollector) flush(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for {
+		if u.inflight == nil {
+			if len(u.pending) == 0 {
+				return nil
+			}
+			u.inflight = u.takePendingLocked()
+		}
+		if err := u.sink.ReportUsage(ctx, *u.inflight); err != nil {
+			return err
+		}
+		u.inflight = nil
+	}
+}
+
+// takePendingLocked moves the pending deltas into a new usage report.
+func (u *usageCollector) takePendingLocked() *agentproto.UsageReport {
+	report := &agentproto.UsageReport{
+		ReportID:    uuid.NewString(),
+		CollectedAt: u.now().UTC(),
+		Users:       make([]agentproto.UserUsage, 0, len(u.pending)),
+	}
+	for _, usage := range u.pending {
+		report.Users = append(report.Users, *usage)
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		if report.Users[i].ID != report.Users[j].ID {
+			return report.Users[i].ID < report.Users[j].ID
+		}
+		return report.Users[i].Day.Before(report.Users[j].Day)
+	})
+	u.pending = make(map[usageKey]*agentproto.UserUsage)
+	return report
+}
+
+// flushTraffic reports the byte deltas accumulated since the previous
+// traffic report together with the latest connection counts. It is sent even
+// when idle so the controller sees connections drop to zero.
+func (u *usageCollector) flushTraffic(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	clients := make(map[string]agentproto.ClientTraffic, len(u.traffic))
+	for id, traffic := range u.traffic {
+		clients[id] = *traffic
+	}
+	for id, count := range u.connections {
+		entry := clients[id]
+		entry.ID = id
+		entry.Connections = count
+		clients[id] = entry
+	}
+	report := agentproto.TrafficReport{CollectedAt: u.now().UTC(), Clients: make([]agentproto.ClientTraffic, 0, len(clients))}
+	for _, entry := range clients {
+		report.Clients = append(report.Clients, entry)
+	}
+	sort.Slice(report.Clients, func(i, j int) bool { return report.Clients[i].ID < report.Clients[j].ID })
+
+	if err := u.sink.ReportTraffic(ctx, report); err != nil {
+		return err
+	}
+	u.traffic = make(map[string]*agentproto.ClientTraffic)
+	return nil
+}
@@ -0,0 +1,166 @@
+package agentmode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"account/internal/agentproto"
+	"account/internal/xrayconfig"
+	"account/internal/xraystats"
+)
+
+// fakeStats stands in for the Xray stats service. Counters accumulate until
+// they are read with reset.
+type fakeStats struct {
+	counters    map[string]*xraystats.UserTraffic
+	connections map[string]int64
+	resets      int
+}
+
+func (f *fakeStats) QueryUserConnections(ctx context.Context, email string) (int64, error) {
+	return f.connections[email], nil
+}
+
+func (f *fakeStats) add(email string, up, down int64) {
+	if f.counters == nil {
+		f.counters = make(map[string]*xraystats.UserTraffic)
+	}
+	traffic, ok := f.counters[email]
+	if !ok {
+		traffic = &xraystats.UserTraffic{Email: email}
+		f.counters[email] = traffic
+	}
+	traffic.Uplink += up
+	traffic.Downlink += down
+}
+
+func (f *fakeStats) QueryUserTraffic(ctx context.Context, reset bool) ([]xraystats.UserTraffic, error) {
+	users := make([]xraystats.UserTraffic, 0, len(f.counters))
+	for _, traffic := range f.counters {
+		users = append(users, *traffic)
+	}
+	if reset {
+		f.resets++
+		f.counters = nil
+	}
+	return users, nil
+}
+
+type recordingSink struct {
+	reports []agentproto.UsageReport
+	traffic []agentproto.TrafficReport
+	err     error
+}
+
+func (s *recordingSink) ReportTraffic(ctx context.Context, report agentproto.TrafficReport) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.traffic = append(s.traffic, report)
+	return nil
+}
+
+func (s *recordingSink) ReportUsage(ctx context.Context, report agentproto.UsageReport) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.reports = append(s.reports, report)
+	return nil
+}
+
+func TestUsageCollectorReportsDeltasByClientID(t *testing.T) {
+	tracker := newSyncTracker()
+	tracker.UpdateFetch([]xrayconfig.Client{{ID: "uuid-a", Email: "a@example.com"}, {ID: "uuid-b"}}, "rev-1", time.Now())
+
+	stats := &fakeStats{}
+	sink := &recordingSink{err: errors.New("controller unavailable")}
+	collector := newUsageCollector(stats, sink, tracker, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	stats.add("a@example.com", 100, 1000)
+	stats.add("uuid-b", 5, 0)
+	stats.add("stranger@example.com", 7, 7)
+	collector.tick(ctx)
+	if len(sink.reports) != 0 || collector.inflight == nil || len(collector.inflight.Users) != 2 {
+		t.Fatalf("expected undelivered deltas to be kept, got %+v", collector.inflight)
+	}
+	failed := *collector.inflight
+
+	// Clients removed from the config keep their label mapping.
+	tracker.UpdateFetch([]xrayconfig.Client{{ID: "uuid-b"}}, "rev-2", time.Now())
+	sink.err = nil
+	now = now.Add(2 * time.Minute)
+	stats.add("a@example.com", 1, 1)
+	collector.tick(ctx)
+
+	// The failed report is resent as it was, so the controller can drop it
+	// if it was applied before; the newer deltas follow in their own report
+	// and keep the day they were collected on.
+	if len(sink.reports) != 2 {
+		t.Fatalf("expected two reports, got %d", len(sink.reports))
+	}
+	if !reflect.DeepEqual(sink.reports[0], failed) || failed.ReportID == "" || sink.reports[1].ReportID == failed.ReportID {
+		t.Fatalf("expected the failed report to be resent unchanged, got %+v", sink.reports)
+	}
+	firstDay := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	expected := []agentproto.UserUsage{
+		{ID: "uuid-a", Day: firstDay, Uplink: 100, Downlink: 1000},
+		{ID: "uuid-b", Day: firstDay, Uplink: 5},
+	}
+	if !reflect.DeepEqual(sink.reports[0].Users, expected) {
+		t.Fatalf("unexpected report %+v", sink.reports[0].Users)
+	}
+	expected = []agentproto.UserUsage{{ID: "uuid-a", Day: firstDay.AddDate(0, 0, 1), Uplink: 1, Downlink: 1}}
+	if !reflect.DeepEqual(sink.reports[1].Users, expected) {
+		t.Fatalf("unexpected report %+v", sink.reports[1].Users)
+	}
+	if stats.resets != 2 || len(collector.pending) != 0 || collector.inflight != nil {
+		t.Fatalf("expected counters to be reset and pending cleared, got %d resets and %+v", stats.resets, collector.pending)
+	}
+
+	collector.tick(ctx)
+	if len(sink.reports) != 2 {
+		t.Fatalf("expected idle ticks not to report, got %d reports", len(sink.reports))
+	}
+}
+
+func TestUsageCollectorReportsTrafficAndConnections(t *testing.T) {
+	tracker := newSyncTracker()
+	tracker.UpdateFetch([]xrayconfig.Client{{ID: "uuid-a", Email: "a@example.com"}, {ID: "uuid-b"}}, "rev-1", time.Now())
+
+	stats := &fakeStats{connections: map[string]int64{"a@example.com": 3, "uuid-b": 1}}
+	sink := &recordingSink{}
+	collector := newUsageCollector(stats, sink, tracker, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := context.Background()
+
+	stats.add("a@example.com", 10, 20)
+	collector.tick(ctx)
+	stats.add("a@example.com", 1, 2)
+	collector.tick(ctx)
+	if err := collector.flushTraffic(ctx); err != nil {
+		t.Fatalf("flush traffic: %v", err)
+	}
+	// Idle clients are not queried for connections.
+	expected := []agentproto.ClientTraffic{{ID: "uuid-a", Uplink: 11, Downlink: 22, Connections: 3}}
+	if len(sink.traffic) != 1 || !reflect.DeepEqual(sink.traffic[0].Clients, expected) {
+		t.Fatalf("unexpected traffic report %+v", sink.traffic)
+	}
+	if len(sink.reports) != 2 {
+		t.Fatalf("expected usage to be reported independently, got %d reports", len(sink.reports))
+	}
+
+	collector.tick(ctx)
+	if err := collector.flushTraffic(ctx); err != nil {
+		t.Fatalf("flush traffic: %v", err)
+	}
+	if len(sink.traffic) != 2 || len(sink.traffic[1].Clients) != 0 {
+		t.Fatalf("expected an empty report once the client went idle, got %+v", sink.traffic)
+	}
+}
@@ -31,4 +31,73 @@ type XrayStatus struct {
 	Clients    int        `json:"clients"`
 	LastSync   *time.Time `json:"lastSync,omitempty"`
 	ConfigHash string     `json:"configHash,omitempty"`
+	// LastRollback describes the most recent automatic rollback to the
+	// last-known-good configuration.
+	LastRollback *RollbackEvent `json:"lastRollback,omitempty"`
+}
+
+// RollbackEvent records a configuration that failed validation, the restart
+// or the health probe and was replaced by the last-known-good configuration.
+type RollbackEvent struct {
+	At         time.Time `json:"at"`
+	Reason     string    `json:"reason"`
+	ConfigHash string    `json:"configHash"`
+}
+
+// UsageReport carries the traffic the managed Xray instance served since the
+// previous successful report. ReportID identifies the report across retries
+// so the controller applies it only once.
+type UsageReport struct {
+	ReportID    string      `json:"reportId,omitempty"`
+	CollectedAt time.Time   `json:"collectedAt"`
+	Users       []UserUsage `json:"users"`
+}
+
+// UserUsage is the traffic delta of a single client in bytes, collected on
+// the UTC day Day. Reports without Day are attributed to CollectedAt.
+type UserUsage struct {
+	ID       string    `json:"id"`
+	Day      time.Time `json:"day,omitzero"`
+	Uplink   int64     `json:"uplink"`
+	Downlink int64     `json:"downlink"`
+}
+
+// TrafficReport carries per-client traffic counters of the managed Xray
+// instance. Byte counters are deltas since the previous accepted report;
+// connection counts are the live values at CollectedAt.
+type TrafficReport struct {
+	CollectedAt time.Time       `json:"collectedAt"`
+	Clients     []ClientTraffic `json:"clients"`
+}
+
+// ClientTraffic is the traffic of a single client.
+type ClientTraffic struct {
+	ID          string `json:"id"`
+	Uplink      int64  `json:"uplink"`
+	Downlink    int64  `json:"downlink"`
+	Connections int64  `json:"connections"`
+}
+
+// EnrollRequest exchanges a one-time enrollment code for an agent credential.
+type EnrollRequest struct {
+	Code string `json:"code"`
+}
+
+// EnrollResponse carries the credential issued to a newly enrolled agent.
+type EnrollResponse struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Token  string   `json:"token"`
+}
+
+// TemplateResponse carries the Xray server template assigned to an agent.
+// Template is the JSON document with ${NAME} placeholders, which the agent
+// substitutes with Variables, overlaid by its local variables, on every
+// render. Revision changes whenever the template or its variables change.
+type TemplateResponse struct {
+	Name      string            `json:"name"`
+	Revision  string            `json:"revision"`
+	Template  string            `json:"template"`
+	Variables map[string]string `json:"variables,omitempty"`
 }
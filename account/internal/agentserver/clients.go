@@ -0,0 +1,113 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"account/internal/xrayconfig"
+)
+
+// DefaultClientRefreshInterval bounds how stale the client list served to
+// agents may be, and therefore how quickly a long-polling agent learns about
+// a change.
+const DefaultClientRefreshInterval = 2 * time.Second
+
+// ClientSnapshot is a client list together with its content revision.
+type ClientSnapshot struct {
+	Clients     []xrayconfig.Client
+	Revision    string
+	GeneratedAt time.Time
+}
+
+// ClientFeed serves the client list to agents. The list is read from the
+// source at most once per refresh interval and shared by all agents, so any
+// number of long-polling agents cost one query per interval.
+type ClientFeed struct {
+	source  xrayconfig.ClientSource
+	refresh time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	current   ClientSnapshot
+	fetchedAt time.Time
+}
+
+// NewClientFeed constructs a feed reading from source. A non-positive refresh
+// defaults to DefaultClientRefreshInterval.
+func NewClientFeed(source xrayconfig.ClientSource, refresh time.Duration) (*ClientFeed, error) {
+	if source == nil {
+		return nil, errors.New("client source is required")
+	}
+	if refresh <= 0 {
+		refresh = DefaultClientRefreshInterval
+	}
+	return &ClientFeed{source: source, refresh: refresh, now: time.Now}, nil
+}
+
+// Snapshot returns the current client list, reading it from the source when
+// the cached copy is older than the refresh interval. The returned clients
+// are shared and must not be modified.
+func (f *ClientFeed) Snapshot(ctx context.Context) (ClientSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	if !f.fetchedAt.IsZero() && now.Sub(f.fetchedAt) < f.refresh {
+		return f.current, nil
+	}
+	clients, err := f.source.ListClients(ctx)
+	if err != nil {
+		return ClientSnapshot{}, err
+	}
+	revision, err := clientRevision(clients)
+	if err != nil {
+		return ClientSnapshot{}, err
+	}
+	if revision != f.current.Revision {
+		f.current = ClientSnapshot{Clients: clients, Revision: revision, GeneratedAt: now.UTC()}
+	}
+	f.fetchedAt = now
+	return f.current, nil
+}
+
+// Wait returns the client list once its revision differs from revision. When
+// the list does not change within timeout, or ctx ends first, the unchanged
+// snapshot is returned.
+func (f *ClientFeed) Wait(ctx context.Context, revision string, timeout time.Duration) (ClientSnapshot, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(f.refresh)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := f.Snapshot(ctx)
+		if err != nil || snapshot.Revision != revision {
+			return snapshot, err
+		}
+		select {
+		case <-ctx.Done():
+			return snapshot, nil
+		case <-deadline.C:
+			return snapshot, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// clientRevision hashes the clients independently of their order.
+func clientRevision(clients []xrayconfig.Client) (string, error) {
+	sorted := append([]xrayconfig.Client(nil), clients...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	payload, err := json.Marshal(sorted)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:16]), nil
+}
@@ -0,0 +1,67 @@
+package agentserver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"account/internal/xrayconfig"
+)
+
+type countingSource struct {
+	clients atomic.Value
+	calls   atomic.Int32
+}
+
+func (s *countingSource) ListClients(context.Context) ([]xrayconfig.Client, error) {
+	s.calls.Add(1)
+	return s.clients.Load().([]xrayconfig.Client), nil
+}
+
+func TestClientFeedRevisions(t *testing.T) {
+	ctx := context.Background()
+	source := &countingSource{}
+	source.clients.Store([]xrayconfig.Client{{ID: "b"}, {ID: "a"}})
+	feed, err := NewClientFeed(source, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new feed: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	feed.now = func() time.Time { return now }
+
+	first, err := feed.Snapshot(ctx)
+	if err != nil || first.Revision == "" {
+		t.Fatalf("snapshot: %+v, %v", first, err)
+	}
+	if _, err := feed.Snapshot(ctx); err != nil || source.calls.Load() != 1 {
+		t.Fatalf("expected cached snapshot within the refresh interval, got %d calls (%v)", source.calls.Load(), err)
+	}
+
+	// Reordering the same clients keeps the revision.
+	source.clients.Store([]xrayconfig.Client{{ID: "a"}, {ID: "b"}})
+	now = now.Add(time.Second)
+	if second, _ := feed.Snapshot(ctx); second.Revision != first.Revision || !second.GeneratedAt.Equal(first.GeneratedAt) {
+		t.Fatalf("expected unchanged revision, got %+v", second)
+	}
+
+	source.clients.Store([]xrayconfig.Client{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	now = now.Add(time.Second)
+	third, _ := feed.Snapshot(ctx)
+	if third.Revision == first.Revision || len(third.Clients) != 3 {
+		t.Fatalf("expected a new revision, got %+v", third)
+	}
+
+	feed.now = time.Now
+	if snapshot, err := feed.Wait(ctx, third.Revision, 30*time.Millisecond); err != nil || snapshot.Revision != third.Revision {
+		t.Fatalf("expected wait to time out unchanged, got %+v (%v)", snapshot, err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		source.clients.Store([]xrayconfig.Client{{ID: "a"}})
+	}()
+	snapshot, err := feed.Wait(ctx, third.Revision, time.Second)
+	if err != nil || snapshot.Revision == third.Revision || len(snapshot.Clients) != 1 {
+		t.Fatalf("expected wait to return the changed list, got %+v (%v)", snapshot, err)
+	}
+}
@@ -1,14 +1,20 @@
 package agentserver
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"account/internal/agentproto"
+	"account/internal/store"
 )
 
 // Credential defines the authentication material assigned to a managed agent.
@@ -22,13 +28,113 @@ type Credential struct {
 // Config groups the credential set exposed through configuration.
 type Config struct {
 	Credentials []Credential `yaml:"credentials"`
+	// Store persists agents managed at runtime and the status history. When
+	// nil only the configured credentials are accepted and statuses are kept
+	// in memory.
+	Store Store `yaml:"-"`
+	// StatusRetention bounds how long status reports are kept in the store.
+	// Defaults to DefaultStatusRetention.
+	StatusRetention time.Duration `yaml:"statusRetention"`
+	// CredentialTTL bounds how long a dynamic agent credential is served from
+	// memory before it is checked against the store again, so tokens rotated
+	// or revoked through another controller replica stop working. Defaults to
+	// DefaultCredentialTTL.
+	CredentialTTL time.Duration `yaml:"credentialTTL"`
+	// TrafficRetention bounds how long the in-memory traffic totals of a
+	// client are kept after it last appeared in a traffic report. Defaults
+	// to DefaultTrafficRetention.
+	TrafficRetention time.Duration `yaml:"trafficRetention"`
 }
 
+// Store is the persistence used for agents managed at runtime. store.Store
+// satisfies it.
+type Store interface {
+	CreateAgent(ctx context.Context, agent *store.Agent) error
+	ListAgents(ctx context.Context) ([]store.Agent, error)
+	GetAgentByTokenHash(ctx context.Context, tokenHash string) (*store.Agent, error)
+	UpdateAgentToken(ctx context.Context, id, tokenHash string) error
+	RevokeAgent(ctx context.Context, id string, revokedAt time.Time) error
+	CreateAgentEnrollment(ctx context.Context, enrollment *store.AgentEnrollment) error
+	RedeemAgentEnrollment(ctx context.Context, codeHash string, agent *store.Agent, now time.Time) error
+	AppendAgentStatus(ctx context.Context, status *store.AgentStatus) error
+	ListAgentStatuses(ctx context.Context, agentID string, limit int) ([]store.AgentStatus, error)
+	LatestAgentStatuses(ctx context.Context) ([]store.AgentStatus, error)
+	PruneAgentStatuses(ctx context.Context, before time.Time) (int, error)
+}
+
+const (
+	// DefaultStatusRetention is how long status reports are kept when
+	// Config.StatusRetention is unset.
+	DefaultStatusRetention = 7 * 24 * time.Hour
+	// DefaultEnrollmentTTL is how long an enrollment code stays valid when
+	// EnrollmentSpec.TTL is unset.
+	DefaultEnrollmentTTL = 24 * time.Hour
+	// DefaultCredentialTTL is how long a dynamic agent credential is trusted
+	// without consulting the store when Config.CredentialTTL is unset.
+	DefaultCredentialTTL = 30 * time.Second
+	// DefaultTrafficRetention is how long idle clients keep their traffic
+	// totals when Config.TrafficRetention is unset.
+	DefaultTrafficRetention = 24 * time.Hour
+
+	tokenPrefix          = "xca_"
+	enrollmentCodePrefix = "xce_"
+	statusPruneInterval  = time.Hour
+)
+
+var (
+	// ErrPersistenceDisabled is returned by operations that need a store when
+	// the registry was created without one.
+	ErrPersistenceDisabled = errors.New("agent registry has no store")
+	// ErrStaticAgent is returned when trying to modify an agent whose
+	// credential is defined in configuration.
+	ErrStaticAgent = errors.New("agent credential is defined in configuration")
+)
+
 // Identity represents an authenticated agent instance.
 type Identity struct {
 	ID     string
 	Name   string
 	Groups []string
+	// Dynamic reports whether the agent is stored in the database rather than
+	// defined in configuration.
+	Dynamic bool
+}
+
+// AgentRecord describes a registered agent credential. Timestamps are only
+// known for dynamic agents.
+type AgentRecord struct {
+	Identity
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// AgentSpec describes an agent to create.
+type AgentSpec struct {
+	ID     string
+	Name   string
+	Groups []string
+}
+
+// EnrollmentSpec describes the agent a new enrollment code will create.
+type EnrollmentSpec struct {
+	AgentSpec
+	TTL       time.Duration
+	CreatedBy string
+}
+
+// Enrollment is a freshly issued enrollment code. The code is only available
+// at creation time.
+type Enrollment struct {
+	Code      string
+	Agent     AgentSpec
+	ExpiresAt time.Time
+}
+
+// StatusRecord is a persisted status report.
+type StatusRecord struct {
+	Report     agentproto.StatusReport
+	ReportedAt time.Time
 }
 
 // StatusSnapshot captures the last reported status for an agent.
@@ -36,23 +142,96 @@ type StatusSnapshot struct {
 	Agent     Identity
 	Report    agentproto.StatusReport
 	UpdatedAt time.Time
+	Traffic   AgentTraffic
+}
+
+// TrafficTotals sums byte counters and live connections.
+type TrafficTotals struct {
+	Uplink      int64
+	Downlink    int64
+	Connections int64
+}
+
+func (t *TrafficTotals) add(other TrafficTotals) {
+	t.Uplink += other.Uplink
+	t.Downlink += other.Downlink
+	t.Connections += other.Connections
+}
+
+// ClientTraffic is the traffic an agent served for one client. Byte counters
+// accumulate over the reports received since the client last went idle for
+// longer than the traffic retention.
+type ClientTraffic struct {
+	ClientID string
+	TrafficTotals
+}
+
+// AgentTraffic aggregates the traffic reports of an agent.
+type AgentTraffic struct {
+	TrafficTotals
+	Clients   []ClientTraffic
+	UpdatedAt time.Time
 }
 
-// Registry manages agent credentials and status reports in-memory.
+// UserTraffic aggregates the traffic of one client across all agents.
+type UserTraffic struct {
+	UserID string
+	TrafficTotals
+	Agents []string
+}
+
+// Registry manages agent credentials and status reports. Credentials from
+// configuration are always accepted; when a store is configured agents can
+// also be created, rotated and revoked at runtime and status reports are
+// persisted. Authentication is served from memory; dynamic credentials are
+// re-checked against the store once they are older than the credential TTL,
+// and unknown tokens are looked up in the store, so agents created, rotated
+// or revoked through another controller replica are recognised without a
+// restart.
 type Registry struct {
+	store            Store
+	retention        time.Duration
+	credentialTTL    time.Duration
+	trafficRetention time.Duration
+	now              func() time.Time
+
 	mu          sync.RWMutex
 	credentials map[[32]byte]Identity
-	byID        map[string]Identity
-	statuses    map[string]StatusSnapshot
+	// checked records when each dynamic credential was last confirmed by
+	// the store.
+	checked   map[[32]byte]time.Time
+	byID      map[string]Identity
+	digests   map[string][32]byte
+	statuses  map[string]StatusSnapshot
+	traffic   map[string]*agentTraffic
+	lastPrune time.Time
+}
+
+type agentTraffic struct {
+	clients   map[string]*clientTotals
+	updatedAt time.Time
+}
+
+type clientTotals struct {
+	TrafficTotals
+	lastSeen time.Time
 }
 
 // NewRegistry constructs a registry from configuration, validating credentials
 // and normalising their representation.
 func NewRegistry(cfg Config) (*Registry, error) {
 	r := &Registry{
-		credentials: make(map[[32]byte]Identity),
-		byID:        make(map[string]Identity),
-		statuses:    make(map[string]StatusSnapshot),
+		store:            cfg.Store,
+		retention:        cfg.StatusRetention,
+		credentialTTL:    cfg.CredentialTTL,
+		trafficRetention: cfg.TrafficRetention,
+		now:              time.Now,
+		credentials:      make(map[[32]byte]Identity),
+		checked:          make(map[[32]byte]time.Time),
+		byID:             make(map[string]Identity),
+		digests:          make(map[string][32]byte),
+		statuses:         make(map[string]StatusSnapshot),
+		traffic:          make(map[string]*agentTraffic),
 	}
 
 	for _, cred := range cfg.Credentials {
@@ -78,16 +257,264 @@ func NewRegistry(cfg Config) (*Registry, error) {
 			Name:   strings.TrimSpace(cred.Name),
 			Groups: normalizeStrings(cred.Groups),
 		}
-		r.credentials[digest] = identity
-		r.byID[id] = identity
+		r.addLocked(identity, digest)
 	}
 
+	if r.retention <= 0 {
+		r.retention = DefaultStatusRetention
+	}
+	if r.credentialTTL <= 0 {
+		r.credentialTTL = DefaultCredentialTTL
+	}
+	if r.trafficRetention <= 0 {
+		r.trafficRetention = DefaultTrafficRetention
+	}
 	return r, nil
 }
 
+// Restore loads the active dynamic agents and the latest status of every agent
+// from the store. It is a no-op without a store.
+func (r *Registry) Restore(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+	agents, err := r.store.ListAgents(ctx)
+	if err != nil {
+		return fmt.Errorf("load agents: %w", err)
+	}
+	latest, err := r.store.LatestAgentStatuses(ctx)
+	if err != nil {
+		return fmt.Errorf("load agent statuses: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, agent := range agents {
+		if agent.Revoked() {
+			continue
+		}
+		if existing, ok := r.byID[agent.ID]; ok && !existing.Dynamic {
+			return fmt.Errorf("agent %s is defined both in configuration and in the store", agent.ID)
+		}
+		digest, err := decodeTokenHash(agent.TokenHash)
+		if err != nil {
+			return fmt.Errorf("agent %s: %w", agent.ID, err)
+		}
+		r.addLocked(Identity{ID: agent.ID, Name: agent.Name, Groups: normalizeStrings(agent.Groups), Dynamic: true}, digest)
+	}
+	for _, status := range latest {
+		identity, ok := r.byID[status.AgentID]
+		if !ok {
+			continue
+		}
+		var report agentproto.StatusReport
+		if err := json.Unmarshal(status.Report, &report); err != nil {
+			continue
+		}
+		r.statuses[status.AgentID] = StatusSnapshot{Agent: identity, Report: report, UpdatedAt: status.ReportedAt}
+	}
+	return nil
+}
+
+func (r *Registry) addLocked(identity Identity, digest [32]byte) {
+	r.forgetCredentialLocked(identity.ID)
+	r.credentials[digest] = identity
+	r.digests[identity.ID] = digest
+	r.byID[identity.ID] = identity
+	if identity.Dynamic {
+		r.checked[digest] = r.now()
+	}
+}
+
+// forgetCredentialLocked drops the current credential of an agent while
+// keeping the agent and its status.
+func (r *Registry) forgetCredentialLocked(id string) {
+	if digest, ok := r.digests[id]; ok {
+		delete(r.credentials, digest)
+		delete(r.checked, digest)
+		delete(r.digests, id)
+	}
+}
+
+func (r *Registry) removeLocked(id string) {
+	r.forgetCredentialLocked(id)
+	delete(r.byID, id)
+	delete(r.statuses, id)
+	delete(r.traffic, id)
+}
+
+// ListAgents returns the configured agents followed by the dynamic ones,
+// including revoked agents, each group sorted by ID.
+func (r *Registry) ListAgents(ctx context.Context) ([]AgentRecord, error) {
+	records := make([]AgentRecord, 0)
+	for _, identity := range r.Agents() {
+		if !identity.Dynamic {
+			records = append(records, AgentRecord{Identity: identity})
+		}
+	}
+	if r.store == nil {
+		return records, nil
+	}
+	agents, err := r.store.ListAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, agent := range agents {
+		records = append(records, AgentRecord{
+			Identity:  Identity{ID: agent.ID, Name: agent.Name, Groups: normalizeStrings(agent.Groups), Dynamic: true},
+			CreatedAt: agent.CreatedAt,
+			UpdatedAt: agent.UpdatedAt,
+			RevokedAt: agent.RevokedAt,
+		})
+	}
+	return records, nil
+}
+
+// CreateAgent stores a new dynamic agent and returns its bearer token. An
+// empty ID is replaced by a generated one.
+func (r *Registry) CreateAgent(ctx context.Context, spec AgentSpec) (Identity, string, error) {
+	if r.store == nil {
+		return Identity{}, "", ErrPersistenceDisabled
+	}
+	if r.isStatic(spec.ID) {
+		return Identity{}, "", store.ErrAgentExists
+	}
+	token, digest, err := newSecret(tokenPrefix)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	agent := &store.Agent{ID: spec.ID, Name: spec.Name, Groups: spec.Groups, TokenHash: hex.EncodeToString(digest[:])}
+	if err := r.store.CreateAgent(ctx, agent); err != nil {
+		return Identity{}, "", err
+	}
+	return r.activate(agent, digest), token, nil
+}
+
+// RotateToken issues a new bearer token for a dynamic agent. The previous
+// token stops working immediately.
+func (r *Registry) RotateToken(ctx context.Context, id string) (string, error) {
+	if r.store == nil {
+		return "", ErrPersistenceDisabled
+	}
+	id = strings.TrimSpace(id)
+	if r.isStatic(id) {
+		return "", ErrStaticAgent
+	}
+	token, digest, err := newSecret(tokenPrefix)
+	if err != nil {
+		return "", err
+	}
+	if err := r.store.UpdateAgentToken(ctx, id, hex.EncodeToString(digest[:])); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if identity, ok := r.byID[id]; ok {
+		r.addLocked(identity, digest)
+	}
+	return token, nil
+}
+
+// Revoke permanently disables a dynamic agent. Its status history is kept.
+func (r *Registry) Revoke(ctx context.Context, id string) error {
+	if r.store == nil {
+		return ErrPersistenceDisabled
+	}
+	id = strings.TrimSpace(id)
+	if r.isStatic(id) {
+		return ErrStaticAgent
+	}
+	if err := r.store.RevokeAgent(ctx, id, r.now()); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(id)
+	return nil
+}
+
+// CreateEnrollment issues a one-time code a new node exchanges for its agent
+// credential through Enroll.
+func (r *Registry) CreateEnrollment(ctx context.Context, spec EnrollmentSpec) (Enrollment, error) {
+	if r.store == nil {
+		return Enrollment{}, ErrPersistenceDisabled
+	}
+	if r.isStatic(spec.ID) {
+		return Enrollment{}, store.ErrAgentExists
+	}
+	ttl := spec.TTL
+	if ttl <= 0 {
+		ttl = DefaultEnrollmentTTL
+	}
+	code, digest, err := newSecret(enrollmentCodePrefix)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	enrollment := &store.AgentEnrollment{
+		CodeHash:  hex.EncodeToString(digest[:]),
+		AgentID:   spec.ID,
+		Name:      spec.Name,
+		Groups:    spec.Groups,
+		CreatedBy: spec.CreatedBy,
+		ExpiresAt: r.now().Add(ttl),
+	}
+	if err := r.store.CreateAgentEnrollment(ctx, enrollment); err != nil {
+		return Enrollment{}, err
+	}
+	return Enrollment{
+		Code:      code,
+		Agent:     AgentSpec{ID: enrollment.AgentID, Name: enrollment.Name, Groups: enrollment.Groups},
+		ExpiresAt: enrollment.ExpiresAt,
+	}, nil
+}
+
+// Enroll redeems an enrollment code, creating the agent it describes, and
+// returns the agent's bearer token. Unknown, used and expired codes yield
+// store.ErrAgentEnrollmentInvalid.
+func (r *Registry) Enroll(ctx context.Context, code string) (Identity, string, error) {
+	if r.store == nil {
+		return Identity{}, "", ErrPersistenceDisabled
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return Identity{}, "", store.ErrAgentEnrollmentInvalid
+	}
+	token, digest, err := newSecret(tokenPrefix)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	codeDigest := sha256.Sum256([]byte(code))
+	agent := &store.Agent{TokenHash: hex.EncodeToString(digest[:])}
+	if err := r.store.RedeemAgentEnrollment(ctx, hex.EncodeToString(codeDigest[:]), agent, r.now()); err != nil {
+		return Identity{}, "", err
+	}
+	return r.activate(agent, digest), token, nil
+}
+
+func (r *Registry) activate(agent *store.Agent, digest [32]byte) Identity {
+	identity := Identity{ID: agent.ID, Name: agent.Name, Groups: normalizeStrings(agent.Groups), Dynamic: true}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addLocked(identity, digest)
+	return identity
+}
+
+func (r *Registry) isStatic(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	identity, ok := r.byID[strings.TrimSpace(id)]
+	return ok && !identity.Dynamic
+}
+
 // Authenticate validates the provided token and returns the associated agent
-// identity when successful.
-func (r *Registry) Authenticate(token string) (*Identity, bool) {
+// identity when successful. Configured credentials and recently confirmed
+// dynamic ones are served from memory; other tokens are checked against the
+// store. While the store is unreachable, cached credentials stay valid.
+func (r *Registry) Authenticate(ctx context.Context, token string) (*Identity, bool) {
 	token = strings.TrimSpace(token)
 	if token == "" {
 		return nil, false
@@ -95,26 +522,217 @@ func (r *Registry) Authenticate(token string) (*Identity, bool) {
 	digest := sha256.Sum256([]byte(token))
 
 	r.mu.RLock()
-	identity, ok := r.credentials[digest]
+	identity, cached := r.credentials[digest]
+	checkedAt := r.checked[digest]
 	r.mu.RUnlock()
-	if !ok {
+	if cached && (!identity.Dynamic || r.now().Sub(checkedAt) < r.credentialTTL) {
+		copy := identity
+		return &copy, true
+	}
+	if r.store == nil {
 		return nil, false
 	}
 
-	copy := identity
-	return &copy, true
+	agent, err := r.store.GetAgentByTokenHash(ctx, hex.EncodeToString(digest[:]))
+	switch {
+	case err == nil && !agent.Revoked() && !r.isStatic(agent.ID):
+		identity := r.activate(agent, digest)
+		return &identity, true
+	case err == nil && agent.Revoked():
+		r.mu.Lock()
+		r.removeLocked(agent.ID)
+		r.mu.Unlock()
+		return nil, false
+	case err == nil, errors.Is(err, store.ErrAgentNotFound):
+		// The token was rotated, or never existed.
+		if cached {
+			r.mu.Lock()
+			if r.digests[identity.ID] == digest {
+				r.forgetCredentialLocked(identity.ID)
+			}
+			r.mu.Unlock()
+		}
+		return nil, false
+	default:
+		if cached {
+			copy := identity
+			return &copy, true
+		}
+		return nil, false
+	}
 }
 
-// ReportStatus records the status report for the provided agent identity.
+// ReportStatus records the status report for the provided agent identity in
+// memory only. Use RecordStatus to also persist it.
 func (r *Registry) ReportStatus(agent Identity, report agentproto.StatusReport) {
+	r.reportStatus(agent, report)
+}
+
+func (r *Registry) reportStatus(agent Identity, report agentproto.StatusReport) time.Time {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	updatedAt := r.now().UTC()
 	r.statuses[agent.ID] = StatusSnapshot{
 		Agent:     agent,
 		Report:    report,
-		UpdatedAt: time.Now().UTC(),
+		UpdatedAt: updatedAt,
 	}
+	return updatedAt
+}
+
+// RecordStatus records the status report and appends it to the persisted
+// history. Reports older than the retention are pruned at most once an hour.
+func (r *Registry) RecordStatus(ctx context.Context, agent Identity, report agentproto.StatusReport) error {
+	reportedAt := r.reportStatus(agent, report)
+	if r.store == nil {
+		return nil
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := r.store.AppendAgentStatus(ctx, &store.AgentStatus{
+		AgentID:    agent.ID,
+		Healthy:    report.Healthy,
+		Report:     payload,
+		ReportedAt: reportedAt,
+	}); err != nil {
+		return fmt.Errorf("persist agent status: %w", err)
+	}
+
+	r.mu.Lock()
+	due := reportedAt.Sub(r.lastPrune) >= statusPruneInterval
+	if due {
+		r.lastPrune = reportedAt
+	}
+	r.mu.Unlock()
+	if due {
+		if _, err := r.store.PruneAgentStatuses(ctx, reportedAt.Add(-r.retention)); err != nil {
+			return fmt.Errorf("prune agent statuses: %w", err)
+		}
+	}
+	return nil
+}
+
+// StatusHistory returns up to limit persisted status reports of an agent,
+// newest first.
+func (r *Registry) StatusHistory(ctx context.Context, id string, limit int) ([]StatusRecord, error) {
+	if r.store == nil {
+		return nil, ErrPersistenceDisabled
+	}
+	statuses, err := r.store.ListAgentStatuses(ctx, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]StatusRecord, 0, len(statuses))
+	for _, status := range statuses {
+		var report agentproto.StatusReport
+		if err := json.Unmarshal(status.Report, &report); err != nil {
+			return nil, fmt.Errorf("decode agent status: %w", err)
+		}
+		records = append(records, StatusRecord{Report: report, ReportedAt: status.ReportedAt})
+	}
+	return records, nil
+}
+
+// ReportTraffic adds the byte deltas of a traffic report to the agent's
+// per-client totals and replaces its live connection counts. Entries with
+// negative counters are ignored. Clients of any agent that have not appeared
+// in a report for the traffic retention are dropped.
+func (r *Registry) ReportTraffic(agent Identity, report agentproto.TrafficReport) {
+	now := r.now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	traffic, ok := r.traffic[agent.ID]
+	if !ok {
+		traffic = &agentTraffic{clients: make(map[string]*clientTotals)}
+		r.traffic[agent.ID] = traffic
+	}
+	for _, totals := range traffic.clients {
+		totals.Connections = 0
+	}
+	for _, client := range report.Clients {
+		id := strings.TrimSpace(client.ID)
+		if id == "" || client.Uplink < 0 || client.Downlink < 0 || client.Connections < 0 {
+			continue
+		}
+		totals, ok := traffic.clients[id]
+		if !ok {
+			totals = &clientTotals{}
+			traffic.clients[id] = totals
+		}
+		totals.Uplink += client.Uplink
+		totals.Downlink += client.Downlink
+		totals.Connections = client.Connections
+		totals.lastSeen = now
+	}
+	traffic.updatedAt = now
+	r.pruneTrafficLocked(now)
+}
+
+// pruneTrafficLocked drops the totals of clients that have been missing from
+// traffic reports for longer than the traffic retention, and agents left
+// without clients.
+func (r *Registry) pruneTrafficLocked(now time.Time) {
+	cutoff := now.Add(-r.trafficRetention)
+	for agentID, traffic := range r.traffic {
+		for clientID, totals := range traffic.clients {
+			if totals.lastSeen.Before(cutoff) {
+				delete(traffic.clients, clientID)
+			}
+		}
+		if len(traffic.clients) == 0 && traffic.updatedAt.Before(cutoff) {
+			delete(r.traffic, agentID)
+		}
+	}
+}
+
+// UserTraffic returns the traffic of every client summed across agents,
+// sorted by user ID.
+func (r *Registry) UserTraffic() []UserTraffic {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byUser := make(map[string]*UserTraffic)
+	for agentID, traffic := range r.traffic {
+		for clientID, totals := range traffic.clients {
+			user, ok := byUser[clientID]
+			if !ok {
+				user = &UserTraffic{UserID: clientID}
+				byUser[clientID] = user
+			}
+			user.add(totals.TrafficTotals)
+			user.Agents = append(user.Agents, agentID)
+		}
+	}
+
+	users := make([]UserTraffic, 0, len(byUser))
+	for _, user := range byUser {
+		sort.Strings(user.Agents)
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+func (t *agentTraffic) snapshot() AgentTraffic {
+	if t == nil {
+		return AgentTraffic{}
+	}
+	snapshot := AgentTraffic{Clients: make([]ClientTraffic, 0, len(t.clients)), UpdatedAt: t.updatedAt}
+	for id, totals := range t.clients {
+		snapshot.add(totals.TrafficTotals)
+		snapshot.Clients = append(snapshot.Clients, ClientTraffic{ClientID: id, TrafficTotals: totals.TrafficTotals})
+	}
+	sort.Slice(snapshot.Clients, func(i, j int) bool {
+		return snapshot.Clients[i].ClientID < snapshot.Clients[j].ClientID
+	})
+	return snapshot
 }
 
 // Statuses returns the latest status snapshot for all agents sorted by ID.
@@ -128,6 +746,7 @@ func (r *Registry) Statuses() []StatusSnapshot {
 		if !ok {
 			snapshot = StatusSnapshot{Agent: identity}
 		}
+		snapshot.Traffic = r.traffic[id].snapshot()
 		snapshots = append(snapshots, snapshot)
 	}
 
@@ -138,7 +757,7 @@ func (r *Registry) Statuses() []StatusSnapshot {
 	return snapshots
 }
 
-// Agents returns the configured agent identities in a deterministic order.
+// Agents returns the active agent identities in a deterministic order.
 func (r *Registry) Agents() []Identity {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -153,6 +772,26 @@ func (r *Registry) Agents() []Identity {
 	return agents
 }
 
+// newSecret returns a random prefixed secret and its SHA-256 digest.
+func newSecret(prefix string) (string, [32]byte, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", [32]byte{}, err
+	}
+	secret := prefix + hex.EncodeToString(buf)
+	return secret, sha256.Sum256([]byte(secret)), nil
+}
+
+func decodeTokenHash(value string) ([32]byte, error) {
+	var digest [32]byte
+	raw, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(raw) != len(digest) {
+		return digest, errors.New("malformed token hash")
+	}
+	copy(digest[:], raw)
+	return digest, nil
+}
+
 // normalizeStrings trims whitespace and removes duplicates from the provided
 // slice while preserving the original order for unique entries.
 func normalizeStrings(values []string) []string {
@@ -1,10 +1,13 @@
 package agentserver
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"account/internal/agentproto"
+	"account/internal/store"
 )
 
 func TestNewRegistryValidation(t *testing.T) {
@@ -39,7 +42,7 @@ func TestRegistryAuthenticateAndStatus(t *testing.T) {
 	if err != nil {
 		t.Fatalf("new registry: %v", err)
 	}
-	identity, ok := registry.Authenticate("secret")
+	identity, ok := registry.Authenticate(context.Background(), "secret")
 	if !ok || identity == nil {
 		t.Fatalf("expected authentication to succeed")
 	}
@@ -102,3 +105,258 @@ func TestRegistryAuthenticateAndStatus(t *testing.T) {
 		t.Fatalf("expected updated timestamp to be after initial time")
 	}
 }
+
+func TestRegistryAggregatesTraffic(t *testing.T) {
+	registry, err := NewRegistry(Config{Credentials: []Credential{{ID: "edge-a", Token: "a"}, {ID: "edge-b", Token: "b"}}})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	agents := registry.Agents()
+
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{
+		{ID: "uuid-1", Uplink: 10, Downlink: 100, Connections: 2},
+		{ID: "uuid-2", Uplink: 1, Downlink: 1, Connections: 1},
+		{ID: "uuid-3", Uplink: -5},
+	}})
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{
+		{ID: "uuid-1", Uplink: 5, Downlink: 50, Connections: 1},
+	}})
+	registry.ReportTraffic(agents[1], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{
+		{ID: "uuid-1", Downlink: 1000, Connections: 4},
+	}})
+
+	snapshots := registry.Statuses()
+	first := snapshots[0].Traffic
+	if first.Uplink != 16 || first.Downlink != 151 || first.Connections != 1 || len(first.Clients) != 2 || first.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected agent traffic %+v", first)
+	}
+	// uuid-2 was absent from the latest report, so it has no live connections.
+	if first.Clients[1].ClientID != "uuid-2" || first.Clients[1].Connections != 0 || first.Clients[1].Uplink != 1 {
+		t.Fatalf("unexpected client traffic %+v", first.Clients[1])
+	}
+
+	users := registry.UserTraffic()
+	if len(users) != 2 || users[0].UserID != "uuid-1" {
+		t.Fatalf("unexpected user traffic %+v", users)
+	}
+	if users[0].Uplink != 15 || users[0].Downlink != 1150 || users[0].Connections != 5 || len(users[0].Agents) != 2 {
+		t.Fatalf("expected traffic summed across agents, got %+v", users[0])
+	}
+}
+
+func TestRegistryPrunesIdleTraffic(t *testing.T) {
+	registry, err := NewRegistry(Config{Credentials: []Credential{{ID: "edge-a", Token: "a"}, {ID: "edge-b", Token: "b"}}, TrafficRetention: time.Hour})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+	agents := registry.Agents()
+
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{
+		{ID: "uuid-1", Uplink: 10},
+		{ID: "uuid-2", Uplink: 20},
+	}})
+	registry.ReportTraffic(agents[1], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{{ID: "uuid-2", Uplink: 5}}})
+
+	// uuid-1 keeps reporting; uuid-2 and edge-b go quiet past the retention.
+	now = now.Add(45 * time.Minute)
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{{ID: "uuid-1", Uplink: 1}}})
+	now = now.Add(30 * time.Minute)
+	registry.ReportTraffic(agents[0], agentproto.TrafficReport{Clients: []agentproto.ClientTraffic{{ID: "uuid-1", Uplink: 1}}})
+
+	users := registry.UserTraffic()
+	if len(users) != 1 || users[0].UserID != "uuid-1" || users[0].Uplink != 12 || len(users[0].Agents) != 1 {
+		t.Fatalf("expected idle clients to be pruned, got %+v", users)
+	}
+	if traffic := registry.Statuses()[1].Traffic; len(traffic.Clients) != 0 || !traffic.UpdatedAt.IsZero() {
+		t.Fatalf("expected the idle agent's traffic to be dropped, got %+v", traffic)
+	}
+}
+
+func TestRegistryDynamicAgentsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	cfg := Config{Credentials: []Credential{{ID: "static", Token: "static-token"}}, Store: st}
+	registry, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	if err := registry.Restore(ctx); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	if _, _, err := registry.CreateAgent(ctx, AgentSpec{ID: "static"}); !errors.Is(err, store.ErrAgentExists) {
+		t.Fatalf("expected static id to be reserved, got %v", err)
+	}
+	if _, err := registry.RotateToken(ctx, "static"); !errors.Is(err, ErrStaticAgent) {
+		t.Fatalf("expected static agent rotation to be rejected, got %v", err)
+	}
+
+	edge, token, err := registry.CreateAgent(ctx, AgentSpec{ID: "edge", Groups: []string{"hk"}})
+	if err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	if identity, ok := registry.Authenticate(ctx, token); !ok || identity.ID != "edge" || !identity.Dynamic {
+		t.Fatalf("expected created agent to authenticate, got %+v", identity)
+	}
+	if err := registry.RecordStatus(ctx, edge, agentproto.StatusReport{Healthy: true, Users: 3}); err != nil {
+		t.Fatalf("record status: %v", err)
+	}
+
+	rotated, err := registry.RotateToken(ctx, "edge")
+	if err != nil {
+		t.Fatalf("rotate token: %v", err)
+	}
+	if _, ok := registry.Authenticate(ctx, token); ok {
+		t.Fatalf("expected the previous token to stop working")
+	}
+
+	enrollment, err := registry.CreateEnrollment(ctx, EnrollmentSpec{AgentSpec: AgentSpec{Name: "New node"}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("create enrollment: %v", err)
+	}
+	enrolled, enrolledToken, err := registry.Enroll(ctx, enrollment.Code)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if enrolled.ID == "" || enrolled.Name != "New node" {
+		t.Fatalf("unexpected enrolled identity %+v", enrolled)
+	}
+	if _, _, err := registry.Enroll(ctx, enrollment.Code); !errors.Is(err, store.ErrAgentEnrollmentInvalid) {
+		t.Fatalf("expected enrollment code to be single use, got %v", err)
+	}
+
+	// A new controller instance sees the same agents, tokens and statuses.
+	restarted, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	if err := restarted.Restore(ctx); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, ok := restarted.Authenticate(ctx, rotated); !ok {
+		t.Fatalf("expected rotated token to survive restart")
+	}
+	if _, ok := restarted.Authenticate(ctx, enrolledToken); !ok {
+		t.Fatalf("expected enrolled token to survive restart")
+	}
+	snapshots := restarted.Statuses()
+	if len(snapshots) != 3 {
+		t.Fatalf("expected 3 snapshots, got %+v", snapshots)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Agent.ID == "edge" && (snapshot.Report.Users != 3 || snapshot.UpdatedAt.IsZero()) {
+			t.Fatalf("expected last status to be restored, got %+v", snapshot)
+		}
+	}
+
+	if err := restarted.Revoke(ctx, "edge"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, ok := restarted.Authenticate(ctx, rotated); ok {
+		t.Fatalf("expected revoked agent to be rejected")
+	}
+	history, err := restarted.StatusHistory(ctx, "edge", 10)
+	if err != nil || len(history) != 1 || !history[0].Report.Healthy {
+		t.Fatalf("expected status history to be kept after revocation, got %+v (%v)", history, err)
+	}
+	records, err := restarted.ListAgents(ctx)
+	if err != nil {
+		t.Fatalf("list agents: %v", err)
+	}
+	if len(records) != 3 || records[0].ID != "static" || records[0].Dynamic || records[1].RevokedAt == nil && records[2].RevokedAt == nil {
+		t.Fatalf("unexpected agent records %+v", records)
+	}
+}
+
+func TestRegistryPrunesStatusHistory(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	registry, err := NewRegistry(Config{Credentials: []Credential{{ID: "edge", Token: "secret"}}, Store: st, StatusRetention: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+	agent := registry.Agents()[0]
+
+	for i := 0; i < 4; i++ {
+		if err := registry.RecordStatus(ctx, agent, agentproto.StatusReport{Users: i}); err != nil {
+			t.Fatalf("record status: %v", err)
+		}
+		now = now.Add(90 * time.Minute)
+	}
+
+	history, err := registry.StatusHistory(ctx, "edge", 0)
+	if err != nil {
+		t.Fatalf("status history: %v", err)
+	}
+	// The last prune ran at 04:30 and dropped the reports before 02:30.
+	if len(history) != 2 || history[0].Report.Users != 3 || history[1].Report.Users != 2 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
+
+func TestRegistryRechecksCredentialsAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	primary, err := NewRegistry(Config{Store: st})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	replica, err := NewRegistry(Config{Store: st, CredentialTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	replica.now = func() time.Time { return now }
+
+	// Agents created or enrolled on another replica are found in the store.
+	_, token, err := primary.CreateAgent(ctx, AgentSpec{ID: "edge"})
+	if err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	if identity, ok := replica.Authenticate(ctx, token); !ok || identity.ID != "edge" {
+		t.Fatalf("expected an agent created elsewhere to authenticate, got %+v", identity)
+	}
+	enrollment, err := primary.CreateEnrollment(ctx, EnrollmentSpec{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("create enrollment: %v", err)
+	}
+	_, enrolledToken, err := primary.Enroll(ctx, enrollment.Code)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if _, ok := replica.Authenticate(ctx, enrolledToken); !ok {
+		t.Fatalf("expected an agent enrolled elsewhere to authenticate")
+	}
+
+	// Rotation elsewhere retires the cached token once the TTL has elapsed.
+	rotated, err := primary.RotateToken(ctx, "edge")
+	if err != nil {
+		t.Fatalf("rotate token: %v", err)
+	}
+	if _, ok := replica.Authenticate(ctx, token); !ok {
+		t.Fatalf("expected the cached token to be served within the TTL")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := replica.Authenticate(ctx, token); ok {
+		t.Fatalf("expected the rotated token to be rechecked and rejected")
+	}
+	if _, ok := replica.Authenticate(ctx, rotated); !ok {
+		t.Fatalf("expected the new token to authenticate")
+	}
+
+	// Revocation elsewhere also takes effect after the TTL.
+	if err := primary.Revoke(ctx, "edge"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := replica.Authenticate(ctx, rotated); ok {
+		t.Fatalf("expected the revoked agent to be rejected")
+	}
+	if _, ok := replica.Authenticate(ctx, enrolledToken); !ok {
+		t.Fatalf("expected other agents to keep working")
+	}
+}
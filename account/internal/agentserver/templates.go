@@ -0,0 +1,162 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"account/internal/agentproto"
+	"account/internal/store"
+	"account/internal/xrayconfig"
+)
+
+// TemplateStore lists the Xray templates managed at runtime. store.Store
+// satisfies it.
+type TemplateStore interface {
+	ListXrayTemplates(ctx context.Context) ([]store.XrayTemplate, error)
+}
+
+// TemplateCatalog assigns Xray server templates to agents. Templates come from
+// configuration files and, when a store is configured, from the database; a
+// stored template replaces the file template with the same name.
+//
+// An agent receives the first template, in name order, that lists its ID. It
+// otherwise receives the first template sharing one of its groups, and finally
+// a template that lists neither agents nor groups. Agents without a matching
+// template keep rendering their local template.
+type TemplateCatalog struct {
+	files []store.XrayTemplate
+	store TemplateStore
+}
+
+// NewTemplateCatalog validates the file templates and constructs a catalog.
+// st may be nil.
+func NewTemplateCatalog(files []store.XrayTemplate, st TemplateStore) (*TemplateCatalog, error) {
+	seen := make(map[string]struct{}, len(files))
+	normalized := make([]store.XrayTemplate, 0, len(files))
+	for _, template := range files {
+		template.Name = strings.TrimSpace(template.Name)
+		if template.Name == "" {
+			return nil, errors.New("xray template name is required")
+		}
+		if _, exists := seen[template.Name]; exists {
+			return nil, errors.New("duplicate xray template name: " + template.Name)
+		}
+		seen[template.Name] = struct{}{}
+		if err := xrayconfig.ValidateTemplate(template.Content); err != nil {
+			return nil, fmt.Errorf("xray template %s: %w", template.Name, err)
+		}
+		template.AgentIDs = normalizeStrings(template.AgentIDs)
+		template.Groups = normalizeStrings(template.Groups)
+		normalized = append(normalized, template)
+	}
+	return &TemplateCatalog{files: normalized, store: st}, nil
+}
+
+// Resolve returns the template assigned to the agent. The response variables
+// hold the built-in NODE_ID and NODE_NAME values overlaid by the template
+// variables. ok is false when no template is assigned.
+func (c *TemplateCatalog) Resolve(ctx context.Context, agent Identity) (agentproto.TemplateResponse, bool, error) {
+	templates, err := c.templates(ctx)
+	if err != nil {
+		return agentproto.TemplateResponse{}, false, err
+	}
+	template, ok := selectTemplate(templates, agent)
+	if !ok {
+		return agentproto.TemplateResponse{}, false, nil
+	}
+
+	name := agent.Name
+	if name == "" {
+		name = agent.ID
+	}
+	variables := map[string]string{
+		xrayconfig.VariableNodeID:   agent.ID,
+		xrayconfig.VariableNodeName: name,
+	}
+	for key, value := range template.Variables {
+		variables[key] = value
+	}
+
+	return agentproto.TemplateResponse{
+		Name:      template.Name,
+		Revision:  templateRevision(template.Name, template.Content, variables),
+		Template:  string(template.Content),
+		Variables: variables,
+	}, true, nil
+}
+
+func (c *TemplateCatalog) templates(ctx context.Context) ([]store.XrayTemplate, error) {
+	byName := make(map[string]store.XrayTemplate, len(c.files))
+	for _, template := range c.files {
+		byName[template.Name] = template
+	}
+	if c.store != nil {
+		stored, err := c.store.ListXrayTemplates(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list xray templates: %w", err)
+		}
+		for _, template := range stored {
+			byName[template.Name] = template
+		}
+	}
+
+	templates := make([]store.XrayTemplate, 0, len(byName))
+	for _, template := range byName {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func selectTemplate(templates []store.XrayTemplate, agent Identity) (store.XrayTemplate, bool) {
+	for _, template := range templates {
+		if containsString(template.AgentIDs, agent.ID) {
+			return template, true
+		}
+	}
+	for _, template := range templates {
+		for _, group := range agent.Groups {
+			if containsString(template.Groups, group) {
+				return template, true
+			}
+		}
+	}
+	for _, template := range templates {
+		if len(template.AgentIDs) == 0 && len(template.Groups) == 0 {
+			return template, true
+		}
+	}
+	return store.XrayTemplate{}, false
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func templateRevision(name string, content []byte, variables map[string]string) string {
+	keys := make([]string, 0, len(variables))
+	for key := range variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(name))
+	hash.Write([]byte{0})
+	hash.Write(content)
+	for _, key := range keys {
+		hash.Write([]byte{0})
+		hash.Write([]byte(key + "=" + variables[key]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package agentserver
+
+import (
+	"context"
+	"testing"
+
+	"account/internal/store"
+)
+
+func TestTemplateCatalogResolve(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+
+	files := []store.XrayTemplate{
+		{Name: "default", Content: []byte(`{"inbounds": [], "name": "file-default"}`)},
+		{Name: "hk", Content: []byte(`{"inbounds": [], "port": ${PORT}}`), Groups: []string{"hk"}, Variables: map[string]string{"PORT": "443"}},
+		{Name: "pinned", Content: []byte(`{"inbounds": []}`), AgentIDs: []string{"edge-1"}, Groups: []string{"hk"}},
+	}
+	catalog, err := NewTemplateCatalog(files, st)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+
+	cases := map[string]struct {
+		agent    Identity
+		expected string
+	}{
+		"by id":    {Identity{ID: "edge-1", Groups: []string{"us"}}, "pinned"},
+		"by group": {Identity{ID: "edge-2", Groups: []string{"us", "hk"}}, "hk"},
+		"fallback": {Identity{ID: "edge-3"}, "default"},
+	}
+	for name, tc := range cases {
+		template, ok, err := catalog.Resolve(ctx, tc.agent)
+		if err != nil || !ok || template.Name != tc.expected {
+			t.Fatalf("%s: expected template %s, got %+v (%v, %v)", name, tc.expected, template, ok, err)
+		}
+	}
+
+	template, _, _ := catalog.Resolve(ctx, Identity{ID: "edge-2", Name: "HK 2", Groups: []string{"hk"}})
+	if template.Variables["NODE_ID"] != "edge-2" || template.Variables["NODE_NAME"] != "HK 2" || template.Variables["PORT"] != "443" {
+		t.Fatalf("unexpected variables %v", template.Variables)
+	}
+	revision := template.Revision
+
+	// A stored template replaces the file template with the same name.
+	if err := st.SaveXrayTemplate(ctx, &store.XrayTemplate{Name: "hk", Content: []byte(`{"inbounds": [], "port": ${PORT}}`), Groups: []string{"hk"}, Variables: map[string]string{"PORT": "8443"}}); err != nil {
+		t.Fatalf("save template: %v", err)
+	}
+	template, _, _ = catalog.Resolve(ctx, Identity{ID: "edge-2", Name: "HK 2", Groups: []string{"hk"}})
+	if template.Variables["PORT"] != "8443" || template.Revision == revision {
+		t.Fatalf("expected stored template to take precedence, got %+v", template)
+	}
+
+	empty, err := NewTemplateCatalog(files[1:], nil)
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	if _, ok, err := empty.Resolve(ctx, Identity{ID: "edge-3"}); ok || err != nil {
+		t.Fatalf("expected no template for an unmatched agent, got %v, %v", ok, err)
+	}
+
+	if _, err := NewTemplateCatalog([]store.XrayTemplate{{Name: "broken", Content: []byte(`{"port": }`)}}, nil); err == nil {
+		t.Fatalf("expected invalid template to be rejected")
+	}
+}
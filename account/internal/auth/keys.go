@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnknownSigningKey is returned when a token references a key ID that is
+// not part of the key set or whose overlap window has ended.
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// SigningKey is an asymmetric key used to sign access tokens. RSA keys sign
+// with RS256 and Ed25519 keys with EdDSA.
+type SigningKey struct {
+	ID         string
+	PrivateKey crypto.Signer
+
+	// RetireAt ends the overlap window of a rotated-out key: until then the
+	// key is still published and accepted for verification. A zero value
+	// keeps the key indefinitely.
+	RetireAt time.Time
+}
+
+func (k SigningKey) method() (jwt.SigningMethod, error) {
+	switch k.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", k.PrivateKey)
+	}
+}
+
+func (k SigningKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeySet holds the active signing key and any previous keys that are still
+// within their overlap window.
+type KeySet struct {
+	active SigningKey
+	keys   map[string]SigningKey
+	order  []string
+}
+
+// NewKeySet builds a key set that signs with activeID. Every other key is
+// only used to verify tokens issued before a rotation.
+func NewKeySet(activeID string, keys ...SigningKey) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]SigningKey, len(keys))}
+	for _, key := range keys {
+		key.ID = strings.TrimSpace(key.ID)
+		if key.ID == "" {
+			return nil, errors.New("signing key id is required")
+		}
+		if key.PrivateKey == nil {
+			return nil, fmt.Errorf("signing key %q has no private key", key.ID)
+		}
+		if _, err := key.method(); err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", key.ID, err)
+		}
+		if _, exists := set.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+		set.order = append(set.order, key.ID)
+	}
+
+	active, ok := set.keys[strings.TrimSpace(activeID)]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeID)
+	}
+	if !active.RetireAt.IsZero() {
+		return nil, fmt.Errorf("active signing key %q cannot have a retirement time", active.ID)
+	}
+	set.active = active
+	return set, nil
+}
+
+// ParseSigningKeyPEM decodes a PEM encoded PKCS#8 (RSA or Ed25519) or PKCS#1
+// (RSA) private key.
+func ParseSigningKeyPEM(id string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("signing key %q: no PEM block found", id)
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("signing key %q: %w", id, err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, fmt.Errorf("signing key %q: unsupported key type %T", id, parsed)
+	}
+	key := SigningKey{ID: id, PrivateKey: signer}
+	if _, err := key.method(); err != nil {
+		return SigningKey{}, fmt.Errorf("signing key %q: %w", id, err)
+	}
+	return key, nil
+}
+
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	method, err := s.active.method()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.PrivateKey)
+}
+
+// verificationKey resolves the public key for a parsed token header.
+func (s *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok || key.retired(time.Now()) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSigningKey, kid)
+	}
+	method, err := key.method()
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PrivateKey.Public(), nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517, RFC 8037).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// PublicKey decodes the key. RSA, P-256 EC and Ed25519 keys are supported.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: invalid modulus: %w", k.KeyID, err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwk %q: invalid exponent", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("jwk %q: invalid coordinates", k.KeyID)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("jwk %q: point is not on the curve", k.KeyID)
+		}
+		return key, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk %q: invalid Ed25519 key", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("jwk %q: unsupported key type %q", k.KeyID, k.KeyType)
+	}
+}
+
+// JWKS is the document served from /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that verifiers should currently accept.
+func (s *KeySet) JWKS(now time.Time) JWKS {
+	doc := JWKS{Keys: make([]JWK, 0, len(s.order))}
+	for _, id := range s.order {
+		key := s.keys[id]
+		if key.retired(now) {
+			continue
+		}
+		switch pub := key.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			doc.Keys = append(doc.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: jwt.SigningMethodRS256.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			doc.Keys = append(doc.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: jwt.SigningMethodEdDSA.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return doc
+}
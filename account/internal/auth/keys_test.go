@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSASigningKey(t *testing.T, id string) SigningKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	return SigningKey{ID: id, PrivateKey: key}
+}
+
+func newEd25519SigningKey(t *testing.T, id string) SigningKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	return SigningKey{ID: id, PrivateKey: key}
+}
+
+func TestAsymmetricAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		key  SigningKey
+		alg  string
+	}{
+		{name: "rsa", key: newRSASigningKey(t, "rsa-1"), alg: "RS256"},
+		{name: "ed25519", key: newEd25519SigningKey(t, "ed-1"), alg: "EdDSA"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := NewKeySet(tc.key.ID, tc.key)
+			if err != nil {
+				t.Fatalf("key set: %v", err)
+			}
+			svc := NewTokenService(TokenConfig{RefreshSecret: "refresh", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, SigningKeys: keys})
+
+			pair, err := svc.GenerateTokenPair(ctx, "user-1", "user@example.com", []string{"user"})
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(pair.AccessToken, &Claims{})
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if parsed.Header["kid"] != tc.key.ID || parsed.Header["alg"] != tc.alg {
+				t.Fatalf("unexpected header %v", parsed.Header)
+			}
+			if _, err := svc.ValidateAccessToken(pair.AccessToken); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+
+			doc, ok := svc.JWKS()
+			if !ok || len(doc.Keys) != 1 || doc.Keys[0].KeyID != tc.key.ID || doc.Keys[0].Algorithm != tc.alg {
+				t.Fatalf("unexpected jwks %+v", doc)
+			}
+
+			hmac := NewTokenService(TokenConfig{AccessSecret: "secret", RefreshSecret: "refresh", AccessExpiry: time.Hour, RefreshExpiry: time.Hour})
+			forged, err := hmac.GenerateTokenPair(ctx, "user-1", "", nil)
+			if err != nil {
+				t.Fatalf("generate hmac: %v", err)
+			}
+			if _, err := svc.ValidateAccessToken(forged.AccessToken); err == nil {
+				t.Fatalf("expected HS256 token to be rejected once asymmetric keys are configured")
+			}
+		})
+	}
+}
+
+func TestKeyRotationOverlap(t *testing.T) {
+	ctx := context.Background()
+	previous := newEd25519SigningKey(t, "old")
+	current := newRSASigningKey(t, "new")
+
+	oldKeys, err := NewKeySet("old", previous)
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	oldSvc := NewTokenService(TokenConfig{RefreshSecret: "refresh", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, SigningKeys: oldKeys})
+	issued, err := oldSvc.GenerateTokenPair(ctx, "user-1", "", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	previous.RetireAt = time.Now().Add(time.Hour)
+	rotated, err := NewKeySet("new", current, previous)
+	if err != nil {
+		t.Fatalf("rotated key set: %v", err)
+	}
+	svc := NewTokenService(TokenConfig{RefreshSecret: "refresh", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, SigningKeys: rotated})
+	if _, err := svc.ValidateAccessToken(issued.AccessToken); err != nil {
+		t.Fatalf("expected token from previous key to validate during overlap: %v", err)
+	}
+	if doc, _ := svc.JWKS(); len(doc.Keys) != 2 {
+		t.Fatalf("expected both keys to be published, got %+v", doc)
+	}
+
+	previous.RetireAt = time.Now().Add(-time.Minute)
+	retired, err := NewKeySet("new", current, previous)
+	if err != nil {
+		t.Fatalf("retired key set: %v", err)
+	}
+	svc = NewTokenService(TokenConfig{RefreshSecret: "refresh", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, SigningKeys: retired})
+	if _, err := svc.ValidateAccessToken(issued.AccessToken); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Fatalf("expected retired key to be rejected, got %v", err)
+	}
+	if doc, _ := svc.JWKS(); len(doc.Keys) != 1 || doc.Keys[0].KeyID != "new" {
+		t.Fatalf("expected only the active key to be published, got %+v", doc)
+	}
+
+	if _, err := NewKeySet("missing", current); err == nil {
+		t.Fatalf("expected unknown active key to be rejected")
+	}
+	if _, err := NewKeySet("old", previous); err == nil {
+		t.Fatalf("expected retiring active key to be rejected")
+	}
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	key, err := ParseSigningKeyPEM("ed", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("parse pkcs8: %v", err)
+	}
+	if _, ok := key.PrivateKey.(ed25519.PrivateKey); !ok {
+		t.Fatalf("expected ed25519 key, got %T", key.PrivateKey)
+	}
+
+	rsaKey := newRSASigningKey(t, "rsa").PrivateKey.(*rsa.PrivateKey)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if _, err := ParseSigningKeyPEM("rsa", pkcs1); err != nil {
+		t.Fatalf("parse pkcs1: %v", err)
+	}
+
+	if _, err := ParseSigningKeyPEM("bad", []byte("not pem")); err == nil {
+		t.Fatalf("expected invalid PEM to be rejected")
+	}
+}
+
+func TestJWKPublicKeyRoundTrip(t *testing.T) {
+	rsaKey, edKey := newRSASigningKey(t, "rsa-1"), newEd25519SigningKey(t, "ed-1")
+	keys, err := NewKeySet(rsaKey.ID, rsaKey, edKey)
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	for _, jwk := range keys.JWKS(time.Now()).Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("decode %s: %v", jwk.KeyID, err)
+		}
+		want := rsaKey.PrivateKey.Public()
+		if jwk.KeyID == edKey.ID {
+			want = edKey.PrivateKey.Public()
+		}
+		if !want.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+			t.Fatalf("decoded key %s does not match", jwk.KeyID)
+		}
+	}
+
+	if _, err := (JWK{KeyType: "EC", Curve: "P-384"}).PublicKey(); err == nil {
+		t.Fatal("expected unsupported curve to be rejected")
+	}
+}
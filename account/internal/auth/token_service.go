@@ -1,10 +1,18 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"account/internal/store"
 )
 
 // TokenPair represents a pair of Public and Access tokens
@@ -25,6 +33,38 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// RefreshTokenStore persists issued refresh tokens so that they can be
+// rotated and revoked. store.Store satisfies this interface.
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, token *store.RefreshToken) error
+	ConsumeRefreshToken(ctx context.Context, id string, usedAt time.Time) (*store.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	PruneRefreshTokens(ctx context.Context, before time.Time) (int, error)
+}
+
+// refreshTokenPruneInterval throttles how often rotation deletes expired and
+// revoked refresh tokens.
+const refreshTokenPruneInterval = time.Hour
+
+// UserLookup loads the account a refresh token was issued to. store.Store
+// satisfies this interface.
+type UserLookup interface {
+	GetUserByID(ctx context.Context, id string) (*store.User, error)
+}
+
+// Errors returned by refresh token operations.
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// refreshClaims identifies a single refresh token (ID) and the rotation
+// family it belongs to.
+type refreshClaims struct {
+	Family string `json:"fam"`
+	jwt.RegisteredClaims
+}
+
 // TokenService handles token generation and validation
 type TokenService struct {
 	publicToken   string
@@ -32,6 +72,12 @@ type TokenService struct {
 	accessSecret  string
 	accessExpiry  time.Duration
 	refreshExpiry time.Duration
+	refreshStore  RefreshTokenStore
+	signingKeys   *KeySet
+	users         UserLookup
+
+	pruneMu   sync.Mutex
+	lastPrune time.Time
 }
 
 // TokenConfig holds configuration for token service
@@ -41,16 +87,37 @@ type TokenConfig struct {
 	AccessSecret  string
 	AccessExpiry  time.Duration
 	RefreshExpiry time.Duration
+
+	// RefreshStore records issued refresh tokens. When nil an in-memory
+	// store is used, which does not survive restarts.
+	RefreshStore RefreshTokenStore
+
+	// SigningKeys signs access tokens with RS256 or EdDSA so that other
+	// services can verify them from the published JWKS. When nil, access
+	// tokens are signed with the shared AccessSecret (HS256).
+	SigningKeys *KeySet
+
+	// Users, when set, is consulted on every refresh so that disabled and
+	// deleted accounts cannot mint new tokens, and so that rotated tokens
+	// carry the account's current email and role.
+	Users UserLookup
 }
 
 // NewTokenService creates a new TokenService instance
 func NewTokenService(config TokenConfig) *TokenService {
+	refreshStore := config.RefreshStore
+	if refreshStore == nil {
+		refreshStore = store.NewMemoryStore()
+	}
 	return &TokenService{
 		publicToken:   config.PublicToken,
 		refreshSecret: config.RefreshSecret,
 		accessSecret:  config.AccessSecret,
 		accessExpiry:  config.AccessExpiry,
 		refreshExpiry: config.RefreshExpiry,
+		refreshStore:  refreshStore,
+		signingKeys:   config.SigningKeys,
+		users:         config.Users,
 	}
 }
 
@@ -59,15 +126,35 @@ func (s *TokenService) ValidatePublicToken(publicToken string) bool {
 	return publicToken == s.publicToken
 }
 
-// GenerateTokenPair generates a new token pair
-func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (*TokenPair, error) {
-	// Generate refresh token (JWT)
-	refreshClaims := jwt.RegisteredClaims{
-		Subject:   userID,
-		Audience:  []string{"xcontrol-refresh"},
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.refreshExpiry)),
-		NotBefore: jwt.NewNumericDate(time.Now()),
-		Issuer:    "xcontrol-account",
+// GenerateTokenPair generates a new token pair that starts a new refresh
+// token family.
+func (s *TokenService) GenerateTokenPair(ctx context.Context, userID, email string, roles []string) (*TokenPair, error) {
+	return s.issueTokenPair(ctx, uuid.NewString(), userID, email, roles)
+}
+
+func (s *TokenService) issueTokenPair(ctx context.Context, familyID, userID, email string, roles []string) (*TokenPair, error) {
+	now := time.Now()
+
+	// Generate refresh token (JWT) and record it so that it can only be
+	// used once.
+	record := &store.RefreshToken{
+		ID:        uuid.NewString(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		Email:     email,
+		Roles:     roles,
+		ExpiresAt: now.Add(s.refreshExpiry),
+	}
+	refreshClaims := refreshClaims{
+		Family: familyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        record.ID,
+			Subject:   userID,
+			Audience:  []string{"xcontrol-refresh"},
+			ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "xcontrol-account",
+		},
 	}
 
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
@@ -75,6 +162,9 @@ func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign refresh token: %w", err)
 	}
+	if err := s.refreshStore.CreateRefreshToken(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to record refresh token: %w", err)
+	}
 
 	// Generate access token (JWT)
 	claims := Claims{
@@ -85,14 +175,13 @@ func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID,
 			Audience:  []string{"xcontrol-access"},
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessExpiry)),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessExpiry)),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "xcontrol-account",
 		},
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessTokenString, err := accessToken.SignedString([]byte(s.accessSecret))
+	accessTokenString, err := s.signAccessToken(claims)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign access token: %w", err)
 	}
@@ -106,9 +195,19 @@ func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (
 	}, nil
 }
 
+func (s *TokenService) signAccessToken(claims Claims) (string, error) {
+	if s.signingKeys != nil {
+		return s.signingKeys.sign(claims)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.accessSecret))
+}
+
 // ValidateAccessToken validates and parses an access token
 func (s *TokenService) ValidateAccessToken(accessToken string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if s.signingKeys != nil {
+			return s.signingKeys.verificationKey(token)
+		}
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
@@ -126,54 +225,143 @@ func (s *TokenService) ValidateAccessToken(accessToken string) (*Claims, error)
 	return claims, nil
 }
 
-// RefreshAccessToken generates a new access token using refresh token
-func (s *TokenService) RefreshAccessToken(refreshToken string) (string, error) {
-	token, err := jwt.ParseWithClaims(refreshToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// RefreshTokenPair exchanges a refresh token for a new token pair. The
+// presented refresh token is invalidated; presenting it again is treated as
+// theft and revokes every token in its family.
+func (s *TokenService) RefreshTokenPair(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	claims, err := s.parseRefreshToken(refreshToken, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	record, err := s.refreshStore.ConsumeRefreshToken(ctx, claims.ID, now)
+	switch {
+	case err == nil:
+	case errors.Is(err, store.ErrRefreshTokenReused):
+		if revokeErr := s.refreshStore.RevokeRefreshTokenFamily(ctx, record.FamilyID, now); revokeErr != nil {
+			return nil, fmt.Errorf("failed to revoke refresh token family: %w", revokeErr)
 		}
-		return []byte(s.refreshSecret), nil
-	})
+		return nil, ErrRefreshTokenReused
+	case errors.Is(err, store.ErrRefreshTokenNotFound), errors.Is(err, store.ErrRefreshTokenRevoked):
+		return nil, ErrInvalidRefreshToken
+	default:
+		return nil, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	if record.UserID != claims.Subject || record.FamilyID != claims.Family {
+		return nil, ErrInvalidRefreshToken
+	}
+	user, err := s.requireActiveUser(ctx, record.UserID)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse refresh token: %w", err)
+		return nil, err
 	}
+	s.pruneRefreshTokens(ctx, now)
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
-	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid refresh token")
+	// Roles come from the account rather than the stored token, so a
+	// demotion takes effect on the next rotation of every family.
+	email, roles := record.Email, record.Roles
+	if user != nil {
+		email = user.Email
+		roles = nil
+		if role := strings.TrimSpace(user.Role); role != "" {
+			roles = []string{role}
+		}
+	}
+	if len(roles) == 0 {
+		roles = []string{store.RoleUser}
 	}
+	return s.issueTokenPair(ctx, record.FamilyID, record.UserID, email, roles)
+}
 
-	// Verify issuer and audience
-	if claims.Issuer != "xcontrol-account" {
-		return "", fmt.Errorf("invalid token issuer")
+// requireActiveUser rejects refreshes for accounts that were disabled or
+// deleted after the token was issued. The user is nil when no UserLookup is
+// configured.
+func (s *TokenService) requireActiveUser(ctx context.Context, userID string) (*store.User, error) {
+	if s.users == nil {
+		return nil, nil
 	}
+	user, err := s.users.GetUserByID(ctx, userID)
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return nil, ErrInvalidRefreshToken
+	case err != nil:
+		return nil, fmt.Errorf("failed to load refresh token user: %w", err)
+	case user.Disabled:
+		return nil, ErrInvalidRefreshToken
+	}
+	return user, nil
+}
 
-	if !contains(claims.Audience, "xcontrol-refresh") {
-		return "", fmt.Errorf("invalid token audience")
+// pruneRefreshTokens deletes expired and revoked refresh tokens at most once
+// per refreshTokenPruneInterval. Failures are logged; they do not fail the
+// rotation that triggered them.
+func (s *TokenService) pruneRefreshTokens(ctx context.Context, now time.Time) {
+	s.pruneMu.Lock()
+	due := now.Sub(s.lastPrune) >= refreshTokenPruneInterval
+	if due {
+		s.lastPrune = now
+	}
+	s.pruneMu.Unlock()
+	if !due {
+		return
+	}
+	if _, err := s.refreshStore.PruneRefreshTokens(ctx, now); err != nil {
+		slog.Warn("failed to prune refresh tokens", "err", err)
 	}
+}
 
-	// Generate new access token
-	newClaims := Claims{
-		UserID: claims.Subject,
-		Email:  "", // Will be populated from user store
-		Roles:  []string{"user"},
-		MFA:    true,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   claims.Subject,
-			Audience:  []string{"xcontrol-access"},
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessExpiry)),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "xcontrol-account",
-		},
+// RevokeRefreshToken revokes the family that refreshToken belongs to. Expired
+// tokens are accepted so that clients can always sign out.
+func (s *TokenService) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	claims, err := s.parseRefreshToken(refreshToken, false)
+	if err != nil {
+		return err
+	}
+	if err := s.refreshStore.RevokeRefreshTokenFamily(ctx, claims.Family, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
 	}
+	return nil
+}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(s.accessSecret))
+func (s *TokenService) parseRefreshToken(refreshToken string, validateExpiry bool) (*refreshClaims, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})}
+	if !validateExpiry {
+		options = append(options, jwt.WithoutClaimsValidation())
+	}
+	token, err := jwt.ParseWithClaims(refreshToken, &refreshClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.refreshSecret), nil
+	}, options...)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign access token: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefreshToken, err)
+	}
+
+	claims, ok := token.Claims.(*refreshClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	return accessTokenString, nil
+	// Verify issuer and audience
+	if claims.Issuer != "xcontrol-account" || !contains(claims.Audience, "xcontrol-refresh") {
+		return nil, ErrInvalidRefreshToken
+	}
+	// Tokens issued before rotation was introduced carry no identifiers and
+	// cannot be tracked, so they are no longer accepted.
+	if claims.ID == "" || claims.Family == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys for verifying access tokens. The boolean is
+// false when tokens are signed with a shared secret and nothing can be
+// published.
+func (s *TokenService) JWKS() (JWKS, bool) {
+	if s.signingKeys == nil {
+		return JWKS{}, false
+	}
+	return s.signingKeys.JWKS(time.Now()), true
 }
 
 // GetAccessTokenExpiry returns the access token expiry duration
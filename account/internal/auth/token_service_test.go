@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func newTestTokenService() *TokenService {
+	return NewTokenService(TokenConfig{
+		PublicToken:   "public",
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 24 * time.Hour,
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestTokenService()
+
+	pair, err := svc.GenerateTokenPair(ctx, "user-1", "user@example.com", []string{"admin"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	rotated, err := svc.RefreshTokenPair(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.RefreshToken == pair.RefreshToken {
+		t.Fatalf("expected a new refresh token")
+	}
+	claims, err := svc.ValidateAccessToken(rotated.AccessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.Email != "user@example.com" || !contains(claims.Roles, "admin") {
+		t.Fatalf("expected identity to carry over, got %+v", claims)
+	}
+
+	// Reusing the first token revokes the family, including the rotated one.
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected rotated token to be revoked, got %v", err)
+	}
+
+	// Other families are unaffected.
+	other, err := svc.GenerateTokenPair(ctx, "user-1", "user@example.com", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, other.RefreshToken); err != nil {
+		t.Fatalf("expected independent family to refresh, got %v", err)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestTokenService()
+
+	pair, err := svc.GenerateTokenPair(ctx, "user-1", "", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := svc.RevokeRefreshToken(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+
+	if err := svc.RevokeRefreshToken(ctx, "not-a-token"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected malformed token to be rejected, got %v", err)
+	}
+	forged := NewTokenService(TokenConfig{RefreshSecret: "other", RefreshExpiry: time.Hour})
+	foreign, err := forged.GenerateTokenPair(ctx, "user-1", "", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, foreign.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected token signed with another secret to be rejected, got %v", err)
+	}
+}
+
+func TestRefreshTokenRejectsDisabledAndDeletedUsers(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	svc := NewTokenService(TokenConfig{
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 24 * time.Hour,
+		RefreshStore:  st,
+		Users:         st,
+	})
+	user := &store.User{Name: "alice", Email: "alice@example.com"}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	pair, err := svc.GenerateTokenPair(ctx, user.ID, user.Email, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := st.SetUserDisabled(ctx, user.ID, true); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected disabled user to be rejected, got %v", err)
+	}
+
+	if err := st.SetUserDisabled(ctx, user.ID, false); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	pair, err = svc.GenerateTokenPair(ctx, user.ID, user.Email, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := st.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected deleted user to be rejected, got %v", err)
+	}
+}
+
+func TestRefreshTokenReloadsRolesFromUser(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	svc := NewTokenService(TokenConfig{
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 24 * time.Hour,
+		RefreshStore:  st,
+		Users:         st,
+	})
+	user := &store.User{Name: "alice", Email: "alice@example.com", Role: store.RoleAdmin}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	pair, err := svc.GenerateTokenPair(ctx, user.ID, user.Email, []string{store.RoleAdmin})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	user.Role = store.RoleUser
+	if err := st.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("demote: %v", err)
+	}
+
+	rotated, err := svc.RefreshTokenPair(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	claims, err := svc.ValidateAccessToken(rotated.AccessToken)
+	if err != nil {
+		t.Fatalf("validate access token: %v", err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != store.RoleUser {
+		t.Fatalf("expected demoted roles after refresh, got %v", claims.Roles)
+	}
+}
+
+func TestRefreshTokenRotationPrunesExpiredAndRevokedTokens(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	svc := NewTokenService(TokenConfig{
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 24 * time.Hour,
+		RefreshStore:  st,
+	})
+
+	past := time.Now().Add(-time.Hour)
+	if err := st.CreateRefreshToken(ctx, &store.RefreshToken{ID: "expired", FamilyID: "old", UserID: "user-1", ExpiresAt: past}); err != nil {
+		t.Fatalf("create expired token: %v", err)
+	}
+	revoked, err := svc.GenerateTokenPair(ctx, "user-1", "", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := svc.RevokeRefreshToken(ctx, revoked.RefreshToken); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	revokedClaims, err := svc.parseRefreshToken(revoked.RefreshToken, true)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	pair, err := svc.GenerateTokenPair(ctx, "user-1", "", nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	for _, id := range []string{"expired", revokedClaims.ID} {
+		if _, err := st.ConsumeRefreshToken(ctx, id, time.Now()); !errors.Is(err, store.ErrRefreshTokenNotFound) {
+			t.Fatalf("expected token %s to be pruned, got %v", id, err)
+		}
+	}
+	// The token that was just rotated stays, so replaying it is still
+	// detected as reuse.
+	if _, err := svc.RefreshTokenPair(ctx, pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse to be detected after pruning, got %v", err)
+	}
+}
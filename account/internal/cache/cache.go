@@ -1,6 +1,51 @@
 package cache
 
-// Cache defines session cache behavior.
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist or has already expired.
+	ErrNotFound = errors.New("cache: key not found")
+	// ErrConflict is returned when an update keeps racing with concurrent
+	// writers of the same key.
+	ErrConflict = errors.New("cache: concurrent update conflict")
+)
+
+// Cache defines the short-lived state storage shared by account service
+// replicas. Implementations are responsible for expiring entries once their
+// TTL elapses so callers never observe stale sessions or challenges.
 type Cache interface {
-	SetSession(token string, userID int64) error
+	// Get returns the value stored for key or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for the provided TTL. A non-positive TTL
+	// keeps the entry until it is deleted explicitly.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetIfAbsent stores value under key only when the key does not exist yet
+	// and reports whether the value was written.
+	SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Take returns the value stored for key and removes it in one step, so
+	// that concurrent callers never both observe it. It returns ErrNotFound
+	// when the key does not exist.
+	Take(ctx context.Context, key string) ([]byte, error)
+	// Update atomically replaces the value stored for key with the one fn
+	// derives from it, using ttl as in Set. A nil value deletes the key.
+	// Update returns ErrNotFound without calling fn when the key does not
+	// exist and returns errors from fn unchanged. fn may be called more than
+	// once and must not have side effects.
+	Update(ctx context.Context, key string, fn func(value []byte) (next []byte, ttl time.Duration, err error)) error
+	// Delete removes the provided keys. Missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+
+	// AddMember records member in the set identified by key and extends the
+	// lifetime of the set to at least ttl. A non-positive TTL keeps the
+	// lifetime of an existing set; a new set created that way does not
+	// expire.
+	AddMember(ctx context.Context, key, member string, ttl time.Duration) error
+	// Members returns the members of the set identified by key.
+	Members(ctx context.Context, key string) ([]string, error)
+	// RemoveMembers removes the provided members from the set identified by key.
+	RemoveMembers(ctx context.Context, key string, members ...string) error
 }
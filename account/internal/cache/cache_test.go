@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryCacheExpiresEntries(t *testing.T) {
+	c := NewMemory().(*memoryCache)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "session:a", []byte("alice"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	value, err := c.Get(ctx, "session:a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if string(value) != "alice" {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := c.Get(ctx, "session:a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after expiry, got %v", err)
+	}
+}
+
+func TestMemoryCacheSetIfAbsent(t *testing.T) {
+	c := NewMemory().(*memoryCache)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	stored, err := c.SetIfAbsent(ctx, "nonce", []byte("1"), time.Minute)
+	if err != nil || !stored {
+		t.Fatalf("expected first write to succeed, got %v, %v", stored, err)
+	}
+	stored, err = c.SetIfAbsent(ctx, "nonce", []byte("2"), time.Minute)
+	if err != nil || stored {
+		t.Fatalf("expected duplicate write to be rejected, got %v, %v", stored, err)
+	}
+
+	now = now.Add(time.Minute)
+	stored, err = c.SetIfAbsent(ctx, "nonce", []byte("3"), time.Minute)
+	if err != nil || !stored {
+		t.Fatalf("expected write after expiry to succeed, got %v, %v", stored, err)
+	}
+}
+
+func TestMemoryCacheSets(t *testing.T) {
+	c := NewMemory().(*memoryCache)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := c.AddMember(ctx, "idx", "b", 2*time.Minute); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := c.AddMember(ctx, "idx", "a", time.Minute); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	members, err := c.Members(ctx, "idx")
+	if err != nil {
+		t.Fatalf("members: %v", err)
+	}
+	if !reflect.DeepEqual(members, []string{"a", "b"}) {
+		t.Fatalf("unexpected members %v", members)
+	}
+
+	// Members without a TTL must not make the set permanent.
+	if err := c.AddMember(ctx, "idx", "c", 0); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := c.RemoveMembers(ctx, "idx", "c"); err != nil {
+		t.Fatalf("remove members: %v", err)
+	}
+
+	// The shorter TTL of the second member must not truncate the set.
+	now = now.Add(90 * time.Second)
+	members, _ = c.Members(ctx, "idx")
+	if len(members) != 2 {
+		t.Fatalf("expected set to survive, got %v", members)
+	}
+
+	if err := c.RemoveMembers(ctx, "idx", "a"); err != nil {
+		t.Fatalf("remove members: %v", err)
+	}
+	members, _ = c.Members(ctx, "idx")
+	if !reflect.DeepEqual(members, []string{"b"}) {
+		t.Fatalf("unexpected members after removal %v", members)
+	}
+
+	now = now.Add(time.Minute)
+	members, _ = c.Members(ctx, "idx")
+	if len(members) != 0 {
+		t.Fatalf("expected expired set, got %v", members)
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	c := NewRedisWithClient(client, "")
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "session:a", []byte("alice"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if !srv.Exists(DefaultRedisPrefix + "session:a") {
+		t.Fatalf("expected key to be written with default prefix")
+	}
+	value, err := c.Get(ctx, "session:a")
+	if err != nil || string(value) != "alice" {
+		t.Fatalf("unexpected get result %q, %v", value, err)
+	}
+
+	srv.FastForward(time.Minute)
+	if _, err := c.Get(ctx, "session:a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after expiry, got %v", err)
+	}
+
+	stored, err := c.SetIfAbsent(ctx, "nonce", []byte("1"), time.Minute)
+	if err != nil || !stored {
+		t.Fatalf("expected first write to succeed, got %v, %v", stored, err)
+	}
+	stored, err = c.SetIfAbsent(ctx, "nonce", []byte("2"), time.Minute)
+	if err != nil || stored {
+		t.Fatalf("expected duplicate write to be rejected, got %v, %v", stored, err)
+	}
+
+	if err := c.AddMember(ctx, "idx", "b", 2*time.Minute); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := c.AddMember(ctx, "idx", "a", time.Minute); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := c.AddMember(ctx, "idx", "c", 0); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if ttl := srv.TTL(DefaultRedisPrefix + "idx"); ttl != 2*time.Minute {
+		t.Fatalf("expected set ttl to be kept at 2m, got %s", ttl)
+	}
+	if err := c.RemoveMembers(ctx, "idx", "c"); err != nil {
+		t.Fatalf("remove members: %v", err)
+	}
+	members, err := c.Members(ctx, "idx")
+	if err != nil {
+		t.Fatalf("members: %v", err)
+	}
+	if !reflect.DeepEqual(members, []string{"a", "b"}) {
+		t.Fatalf("unexpected members %v", members)
+	}
+
+	if err := c.RemoveMembers(ctx, "idx", "a", "b"); err != nil {
+		t.Fatalf("remove members: %v", err)
+	}
+	if err := c.Delete(ctx, "idx", "missing"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	members, _ = c.Members(ctx, "idx")
+	if len(members) != 0 {
+		t.Fatalf("expected empty set, got %v", members)
+	}
+}
+
+func TestCacheTakeAndUpdate(t *testing.T) {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	caches := map[string]Cache{
+		"memory": NewMemory(),
+		"redis":  NewRedisWithClient(client, ""),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := c.Set(ctx, "ceremony", []byte("challenge"), time.Minute); err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			value, err := c.Take(ctx, "ceremony")
+			if err != nil || string(value) != "challenge" {
+				t.Fatalf("unexpected take result %q, %v", value, err)
+			}
+			if _, err := c.Take(ctx, "ceremony"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected a taken key to be gone, got %v", err)
+			}
+
+			increment := func(value []byte) ([]byte, time.Duration, error) {
+				return append(value, '+'), time.Minute, nil
+			}
+			if err := c.Update(ctx, "counter", increment); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected missing key to be reported, got %v", err)
+			}
+			if err := c.Set(ctx, "counter", nil, time.Minute); err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			const writers = 10
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				go func() { errs <- c.Update(ctx, "counter", increment) }()
+			}
+			for i := 0; i < writers; i++ {
+				if err := <-errs; err != nil {
+					t.Fatalf("update: %v", err)
+				}
+			}
+			value, err = c.Get(ctx, "counter")
+			if err != nil || len(value) != writers {
+				t.Fatalf("expected every concurrent update to be kept, got %q, %v", value, err)
+			}
+
+			abort := errors.New("abort")
+			if err := c.Update(ctx, "counter", func([]byte) ([]byte, time.Duration, error) { return nil, 0, abort }); !errors.Is(err, abort) {
+				t.Fatalf("expected update error to be returned, got %v", err)
+			}
+			if err := c.Update(ctx, "counter", func([]byte) ([]byte, time.Duration, error) { return nil, 0, nil }); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			if _, err := c.Get(ctx, "counter"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected nil update to delete the key, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memorySet struct {
+	members   map[string]struct{}
+	expiresAt time.Time
+}
+
+// memoryCache provides an in-process Cache implementation. It is the default
+// for single replica deployments and unit tests.
+type memoryCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]memoryEntry
+	sets    map[string]memorySet
+}
+
+// NewMemory creates an in-memory Cache. Expired entries are evicted lazily on
+// access.
+func NewMemory() Cache {
+	return &memoryCache{
+		now:     time.Now,
+		entries: make(map[string]memoryEntry),
+		sets:    make(map[string]memorySet),
+	}
+}
+
+func expiryFor(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func expired(now, expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// Get implements Cache.
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if expired(c.now(), entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+// Set implements Cache.
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = memoryEntry{
+		value:     append([]byte(nil), value...),
+		expiresAt: expiryFor(c.now(), ttl),
+	}
+	return nil
+}
+
+// SetIfAbsent implements Cache.
+func (c *memoryCache) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if entry, ok := c.entries[key]; ok && !expired(now, entry.expiresAt) {
+		return false, nil
+	}
+	c.entries[key] = memoryEntry{
+		value:     append([]byte(nil), value...),
+		expiresAt: expiryFor(now, ttl),
+	}
+	return true, nil
+}
+
+// Take implements Cache.
+func (c *memoryCache) Take(ctx context.Context, key string) ([]byte, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(c.entries, key)
+	if expired(c.now(), entry.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return entry.value, nil
+}
+
+// Update implements Cache.
+func (c *memoryCache) Update(ctx context.Context, key string, fn func([]byte) ([]byte, time.Duration, error)) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	entry, ok := c.entries[key]
+	if !ok || expired(now, entry.expiresAt) {
+		delete(c.entries, key)
+		return ErrNotFound
+	}
+	next, ttl, err := fn(append([]byte(nil), entry.value...))
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		delete(c.entries, key)
+		return nil
+	}
+	c.entries[key] = memoryEntry{
+		value:     append([]byte(nil), next...),
+		expiresAt: expiryFor(now, ttl),
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (c *memoryCache) Delete(ctx context.Context, keys ...string) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+		delete(c.sets, key)
+	}
+	return nil
+}
+
+// AddMember implements Cache.
+func (c *memoryCache) AddMember(ctx context.Context, key, member string, ttl time.Duration) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	expiresAt := expiryFor(now, ttl)
+	set, ok := c.sets[key]
+	switch {
+	case !ok || expired(now, set.expiresAt):
+		set = memorySet{members: make(map[string]struct{}), expiresAt: expiresAt}
+	case expiresAt.IsZero():
+		// Members without a TTL leave the lifetime of the set unchanged.
+	case set.expiresAt.IsZero() || expiresAt.After(set.expiresAt):
+		set.expiresAt = expiresAt
+	}
+	set.members[member] = struct{}{}
+	c.sets[key] = set
+	return nil
+}
+
+// Members implements Cache.
+func (c *memoryCache) Members(ctx context.Context, key string) ([]string, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	set, ok := c.sets[key]
+	if !ok {
+		return nil, nil
+	}
+	if expired(c.now(), set.expiresAt) {
+		delete(c.sets, key)
+		return nil, nil
+	}
+	members := make([]string, 0, len(set.members))
+	for member := range set.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// RemoveMembers implements Cache.
+func (c *memoryCache) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	set, ok := c.sets[key]
+	if !ok {
+		return nil
+	}
+	for _, member := range members {
+		delete(set.members, member)
+	}
+	if len(set.members) == 0 {
+		delete(c.sets, key)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix namespaces keys written by the account service.
+const DefaultRedisPrefix = "xcontrol:account:"
+
+// RedisConfig describes how to connect to a Redis server.
+type RedisConfig struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	Prefix   string
+}
+
+// addMemberScript adds a set member and only ever extends the set lifetime so
+// that shorter-lived members never truncate longer-lived siblings. Members
+// added without a TTL leave the lifetime of the set unchanged.
+var addMemberScript = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+  return 1
+end
+if redis.call('PTTL', KEYS[1]) < ttl then
+  redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// maxUpdateAttempts bounds how often Update retries an optimistic transaction
+// that lost a race with another writer.
+const maxUpdateAttempts = 16
+
+// redisCache stores entries in Redis so that sessions and challenges are
+// shared by every replica and survive restarts. Expiry is delegated to Redis.
+type redisCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedis connects to the configured Redis server and returns a Cache backed
+// by it together with a cleanup function that closes the connection.
+func NewRedis(ctx context.Context, cfg RedisConfig) (Cache, func(context.Context) error, error) {
+	client, err := DialRedis(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func(context.Context) error {
+		return client.Close()
+	}
+	return NewRedisWithClient(client, cfg.Prefix), cleanup, nil
+}
+
+// DialRedis opens a Redis client for cfg and verifies the connection. Callers
+// are responsible for closing the client.
+func DialRedis(ctx context.Context, cfg RedisConfig) (*redis.Client, error) {
+	addr := strings.TrimSpace(cfg.Addr)
+	if addr == "" {
+		return nil, errors.New("redis addr is required")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Username: strings.TrimSpace(cfg.Username),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRedisWithClient wraps an existing Redis client. An empty prefix falls back
+// to DefaultRedisPrefix.
+func NewRedisWithClient(client redis.UniversalClient, prefix string) Cache {
+	if strings.TrimSpace(prefix) == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &redisCache{client: client, prefix: prefix}
+}
+
+func (c *redisCache) key(key string) string {
+	return c.prefix + key
+}
+
+// Get implements Cache.
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set implements Cache.
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return c.client.Set(ctx, c.key(key), value, ttl).Err()
+}
+
+// SetIfAbsent implements Cache.
+func (c *redisCache) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return c.client.SetNX(ctx, c.key(key), value, ttl).Result()
+}
+
+// Take implements Cache.
+func (c *redisCache) Take(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.GetDel(ctx, c.key(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Update implements Cache. The read and the write run in a WATCH/MULTI
+// transaction that is retried when another client modifies the key in
+// between.
+func (c *redisCache) Update(ctx context.Context, key string, fn func([]byte) ([]byte, time.Duration, error)) error {
+	key = c.key(key)
+	update := func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return ErrNotFound
+			}
+			return err
+		}
+		next, ttl, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if next == nil {
+				pipe.Del(ctx, key)
+			} else {
+				pipe.Set(ctx, key, next, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := c.client.Watch(ctx, update, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// Delete implements Cache.
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, c.key(key))
+	}
+	return c.client.Del(ctx, prefixed...).Err()
+}
+
+// AddMember implements Cache.
+func (c *redisCache) AddMember(ctx context.Context, key, member string, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return addMemberScript.Run(ctx, c.client, []string{c.key(key)}, member, ttl.Milliseconds()).Err()
+}
+
+// Members implements Cache.
+func (c *redisCache) Members(ctx context.Context, key string) ([]string, error) {
+	members, err := c.client.SMembers(ctx, c.key(key)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// RemoveMembers implements Cache.
+func (c *redisCache) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		values = append(values, member)
+	}
+	return c.client.SRem(ctx, c.key(key), values...).Err()
+}
@@ -0,0 +1,258 @@
+// Package syncpayload implements the binary envelope exchanged between the
+// account service and XStream desktop clients on POST /api/config/sync.
+//
+// Requests and responses are serialised with the layouts described in
+// docs/account-xstream-desktop-integration.md and sealed with
+// XChaCha20-Poly1305. A sealed envelope is the 24 byte nonce followed by the
+// ciphertext.
+package syncpayload
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// Version is the only wire format version currently understood.
+	Version uint8 = 1
+
+	// FingerprintSize is the length of the device fingerprint in bytes.
+	FingerprintSize = 32
+	// NonceSize is the length of the XChaCha20-Poly1305 nonce in bytes.
+	NonceSize = chacha20poly1305.NonceSizeX
+	// MaxClientVersionLength bounds the UTF-8 encoded client version string.
+	MaxClientVersionLength = 32
+)
+
+// Status values carried by Response.
+const (
+	StatusOK          uint8 = 0
+	StatusNoPrivilege uint8 = 1
+	StatusError       uint8 = 2
+	StatusNotModified uint8 = 3
+)
+
+var (
+	// ErrEmptySecret is returned when no encryption secret is supplied.
+	ErrEmptySecret = errors.New("syncpayload: secret is required")
+	// ErrMalformed is returned when a payload cannot be decoded.
+	ErrMalformed = errors.New("syncpayload: malformed payload")
+	// ErrUnsupportedVersion is returned for payloads with an unknown version.
+	ErrUnsupportedVersion = errors.New("syncpayload: unsupported version")
+)
+
+// Request is the plaintext sync request sent by desktop clients.
+type Request struct {
+	Version           uint8
+	DeviceFingerprint [FingerprintSize]byte
+	ClientVersion     string
+	Nonce             [NonceSize]byte
+	// Timestamp is the client clock in Unix milliseconds.
+	Timestamp         int64
+	LastConfigVersion int32
+}
+
+// MarshalBinary encodes the request using the version 1 layout.
+func (r Request) MarshalBinary() ([]byte, error) {
+	if len(r.ClientVersion) > MaxClientVersionLength {
+		return nil, fmt.Errorf("syncpayload: client version exceeds %d bytes", MaxClientVersionLength)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(r.Version)
+	buf.Write(r.DeviceFingerprint[:])
+	buf.WriteByte(uint8(len(r.ClientVersion)))
+	buf.WriteString(r.ClientVersion)
+	buf.Write(r.Nonce[:])
+	_ = binary.Write(&buf, binary.BigEndian, r.Timestamp)
+	_ = binary.Write(&buf, binary.BigEndian, r.LastConfigVersion)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalRequest decodes a request produced by Request.MarshalBinary.
+func UnmarshalRequest(data []byte) (Request, error) {
+	var req Request
+	r := bytes.NewReader(data)
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return Request{}, ErrMalformed
+	}
+	if version != Version {
+		return Request{}, ErrUnsupportedVersion
+	}
+	req.Version = version
+
+	if _, err := io.ReadFull(r, req.DeviceFingerprint[:]); err != nil {
+		return Request{}, ErrMalformed
+	}
+	clientVersion, err := readString8(r)
+	if err != nil {
+		return Request{}, err
+	}
+	req.ClientVersion = clientVersion
+	if _, err := io.ReadFull(r, req.Nonce[:]); err != nil {
+		return Request{}, ErrMalformed
+	}
+	if err := binary.Read(r, binary.BigEndian, &req.Timestamp); err != nil {
+		return Request{}, ErrMalformed
+	}
+	if err := binary.Read(r, binary.BigEndian, &req.LastConfigVersion); err != nil {
+		return Request{}, ErrMalformed
+	}
+	if r.Len() != 0 {
+		return Request{}, ErrMalformed
+	}
+	return req, nil
+}
+
+// Response is the plaintext sync response returned to desktop clients.
+type Response struct {
+	Version       uint8
+	Status        uint8
+	ConfigVersion int32
+	// XrayConfigGzip holds the gzip compressed client configuration. It is
+	// empty unless Status is StatusOK.
+	XrayConfigGzip       []byte
+	SubscriptionMetadata string
+}
+
+// MarshalBinary encodes the response using the version 1 layout. Variable
+// length fields are prefixed with their big endian length: four bytes for the
+// configuration and two bytes for the subscription metadata.
+func (r Response) MarshalBinary() ([]byte, error) {
+	if len(r.SubscriptionMetadata) > 0xFFFF {
+		return nil, errors.New("syncpayload: subscription metadata too large")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(r.Version)
+	buf.WriteByte(r.Status)
+	_ = binary.Write(&buf, binary.BigEndian, r.ConfigVersion)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(r.XrayConfigGzip)))
+	buf.Write(r.XrayConfigGzip)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(r.SubscriptionMetadata)))
+	buf.WriteString(r.SubscriptionMetadata)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalResponse decodes a response produced by Response.MarshalBinary.
+func UnmarshalResponse(data []byte) (Response, error) {
+	var resp Response
+	r := bytes.NewReader(data)
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return Response{}, ErrMalformed
+	}
+	if version != Version {
+		return Response{}, ErrUnsupportedVersion
+	}
+	resp.Version = version
+
+	if resp.Status, err = r.ReadByte(); err != nil {
+		return Response{}, ErrMalformed
+	}
+	if err := binary.Read(r, binary.BigEndian, &resp.ConfigVersion); err != nil {
+		return Response{}, ErrMalformed
+	}
+
+	var configLen uint32
+	if err := binary.Read(r, binary.BigEndian, &configLen); err != nil {
+		return Response{}, ErrMalformed
+	}
+	if int64(configLen) > int64(r.Len()) {
+		return Response{}, ErrMalformed
+	}
+	if configLen > 0 {
+		resp.XrayConfigGzip = make([]byte, configLen)
+		if _, err := io.ReadFull(r, resp.XrayConfigGzip); err != nil {
+			return Response{}, ErrMalformed
+		}
+	}
+
+	var metaLen uint16
+	if err := binary.Read(r, binary.BigEndian, &metaLen); err != nil {
+		return Response{}, ErrMalformed
+	}
+	meta := make([]byte, metaLen)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return Response{}, ErrMalformed
+	}
+	resp.SubscriptionMetadata = string(meta)
+	if r.Len() != 0 {
+		return Response{}, ErrMalformed
+	}
+	return resp, nil
+}
+
+func readString8(r *bytes.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", ErrMalformed
+	}
+	if int(length) > MaxClientVersionLength {
+		return "", ErrMalformed
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", ErrMalformed
+	}
+	return string(value), nil
+}
+
+// Encrypt seals payload with a freshly generated random nonce.
+func Encrypt(payload, secret []byte) ([]byte, error) {
+	var nonce [NonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return EncryptWithNonce(nonce, payload, secret)
+}
+
+// EncryptWithNonce seals payload using the provided nonce. Callers must never
+// reuse a nonce with the same secret.
+func EncryptWithNonce(nonce [NonceSize]byte, payload, secret []byte) ([]byte, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, NonceSize+len(payload)+aead.Overhead())
+	out = append(out, nonce[:]...)
+	return aead.Seal(out, nonce[:], payload, nil), nil
+}
+
+// Decrypt opens an envelope produced by Encrypt and returns the nonce it was
+// sealed with alongside the plaintext.
+func Decrypt(envelope, secret []byte) ([NonceSize]byte, []byte, error) {
+	var nonce [NonceSize]byte
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nonce, nil, err
+	}
+	if len(envelope) < NonceSize+aead.Overhead() {
+		return nonce, nil, ErrMalformed
+	}
+	copy(nonce[:], envelope[:NonceSize])
+	plaintext, err := aead.Open(nil, nonce[:], envelope[NonceSize:], nil)
+	if err != nil {
+		return nonce, nil, fmt.Errorf("syncpayload: decrypt: %w", err)
+	}
+	return nonce, plaintext, nil
+}
+
+// newAEAD derives a 256-bit key from secret so that secrets of any length can
+// be used.
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	key := sha256.Sum256(secret)
+	return chacha20poly1305.NewX(key[:])
+}
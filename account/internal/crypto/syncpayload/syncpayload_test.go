@@ -0,0 +1,115 @@
+package syncpayload
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	req := Request{
+		Version:           Version,
+		ClientVersion:     "1.4.2",
+		Timestamp:         1700000000123,
+		LastConfigVersion: 42,
+	}
+	req.DeviceFingerprint[0] = 0xAB
+	req.Nonce[23] = 0xCD
+
+	encoded, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if want := 1 + FingerprintSize + 1 + len(req.ClientVersion) + NonceSize + 8 + 4; len(encoded) != want {
+		t.Fatalf("expected %d bytes, got %d", want, len(encoded))
+	}
+
+	decoded, err := UnmarshalRequest(encoded)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded != req {
+		t.Fatalf("round trip mismatch: %+v != %+v", decoded, req)
+	}
+
+	if _, err := UnmarshalRequest(encoded[:len(encoded)-1]); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected ErrMalformed for truncated payload, got %v", err)
+	}
+	encoded[0] = 9
+	if _, err := UnmarshalRequest(encoded); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	req.ClientVersion = string(bytes.Repeat([]byte("x"), MaxClientVersionLength+1))
+	if _, err := req.MarshalBinary(); err == nil {
+		t.Fatalf("expected error for oversized client version")
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	resp := Response{
+		Version:              Version,
+		Status:               StatusOK,
+		ConfigVersion:        7,
+		XrayConfigGzip:       []byte{0x1f, 0x8b, 0x08},
+		SubscriptionMetadata: `{"plan":"pro"}`,
+	}
+	encoded, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	decoded, err := UnmarshalResponse(encoded)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Status != resp.Status || decoded.ConfigVersion != resp.ConfigVersion ||
+		!bytes.Equal(decoded.XrayConfigGzip, resp.XrayConfigGzip) || decoded.SubscriptionMetadata != resp.SubscriptionMetadata {
+		t.Fatalf("round trip mismatch: %+v != %+v", decoded, resp)
+	}
+
+	empty, err := Response{Version: Version, Status: StatusNotModified, ConfigVersion: 7}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal empty: %v", err)
+	}
+	decoded, err = UnmarshalResponse(empty)
+	if err != nil {
+		t.Fatalf("unmarshal empty: %v", err)
+	}
+	if decoded.Status != StatusNotModified || len(decoded.XrayConfigGzip) != 0 {
+		t.Fatalf("unexpected empty response %+v", decoded)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	secret := []byte("user-secret")
+	var nonce [NonceSize]byte
+	nonce[0] = 1
+
+	sealed, err := EncryptWithNonce(nonce, []byte("payload"), secret)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	gotNonce, plaintext, err := Decrypt(sealed, secret)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if gotNonce != nonce {
+		t.Fatalf("expected nonce to be carried in the envelope")
+	}
+	if string(plaintext) != "payload" {
+		t.Fatalf("unexpected plaintext %q", plaintext)
+	}
+
+	if _, _, err := Decrypt(sealed, []byte("other-secret")); err == nil {
+		t.Fatalf("expected decrypt with wrong secret to fail")
+	}
+	if _, err := Encrypt([]byte("payload"), nil); !errors.Is(err, ErrEmptySecret) {
+		t.Fatalf("expected ErrEmptySecret, got %v", err)
+	}
+
+	first, _ := Encrypt([]byte("payload"), secret)
+	second, _ := Encrypt([]byte("payload"), secret)
+	if bytes.Equal(first[:NonceSize], second[:NonceSize]) {
+		t.Fatalf("expected random nonces for each envelope")
+	}
+}
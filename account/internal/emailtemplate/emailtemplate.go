@@ -0,0 +1,300 @@
+// Package emailtemplate renders the transactional emails sent by the account
+// service. Each template has a subject, a plain text body and an HTML body
+// per locale. Built-in English and Simplified Chinese templates are embedded
+// and can be overridden file by file from a directory with the same layout:
+//
+//	<dir>/<locale>/<name>.subject.txt
+//	<dir>/<locale>/<name>.txt
+//	<dir>/<locale>/<name>.html
+//
+// New locales can be added the same way. A template missing for a locale
+// falls back to DefaultLocale.
+package emailtemplate
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names.
+const (
+	Verification  = "verification"
+	Registration  = "registration"
+	PasswordReset = "password_reset"
+)
+
+// DefaultLocale is used when no preferred locale is available.
+const DefaultLocale = "en"
+
+// ErrTemplateNotFound is returned by Render for unknown template names.
+var ErrTemplateNotFound = errors.New("email template not found")
+
+//go:embed templates
+var builtin embed.FS
+
+var names = []string{Verification, Registration, PasswordReset}
+
+// Data is passed to every template. Fields that do not apply to a template
+// are left empty.
+type Data struct {
+	// Name is the display name of the recipient.
+	Name  string
+	Email string
+	// Code is the verification code or password reset token.
+	Code string
+	// ExpiresAt is formatted as RFC 3339 in UTC.
+	ExpiresAt        string
+	ExpiresInMinutes int
+}
+
+// Message is a rendered email.
+type Message struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+type template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Set holds the parsed templates of every locale.
+type Set struct {
+	templates map[string]map[string]*template
+	locales   []string
+}
+
+// Default returns the built-in templates.
+func Default() *Set {
+	set, err := Load("")
+	if err != nil {
+		panic(fmt.Sprintf("emailtemplate: invalid built-in templates: %v", err))
+	}
+	return set
+}
+
+// Load parses the built-in templates and applies the overrides found in dir.
+// An empty dir loads the built-in templates only.
+func Load(dir string) (*Set, error) {
+	embedded, err := fs.Sub(builtin, "templates")
+	if err != nil {
+		return nil, err
+	}
+	sources := []fs.FS{embedded}
+	if dir = strings.TrimSpace(dir); dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("email template directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("email template directory %s is not a directory", dir)
+		}
+		// Later sources take precedence.
+		sources = append(sources, os.DirFS(dir))
+	}
+
+	locales := make(map[string]struct{})
+	for _, source := range sources {
+		entries, err := fs.ReadDir(source, ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				locales[entry.Name()] = struct{}{}
+			}
+		}
+	}
+
+	set := &Set{templates: make(map[string]map[string]*template)}
+	for locale := range locales {
+		for _, name := range names {
+			tmpl, err := parse(sources, locale, name)
+			if err != nil {
+				return nil, err
+			}
+			if tmpl == nil {
+				continue
+			}
+			if set.templates[locale] == nil {
+				set.templates[locale] = make(map[string]*template)
+			}
+			set.templates[locale][name] = tmpl
+		}
+		if set.templates[locale] != nil {
+			set.locales = append(set.locales, locale)
+		}
+	}
+	sort.Strings(set.locales)
+
+	for _, name := range names {
+		if set.templates[DefaultLocale][name] == nil {
+			return nil, fmt.Errorf("email template %s/%s is incomplete", DefaultLocale, name)
+		}
+	}
+	return set, nil
+}
+
+// parse returns nil when the locale defines none of the files of name. A
+// locale that defines only some of them is an error.
+func parse(sources []fs.FS, locale, name string) (*template, error) {
+	read := func(suffix string) (string, bool, error) {
+		file := path.Join(locale, name+suffix)
+		for i := len(sources) - 1; i >= 0; i-- {
+			content, err := fs.ReadFile(sources[i], file)
+			if err == nil {
+				return string(content), true, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", false, err
+			}
+		}
+		return "", false, nil
+	}
+
+	subject, hasSubject, err := read(".subject.txt")
+	if err != nil {
+		return nil, err
+	}
+	text, hasText, err := read(".txt")
+	if err != nil {
+		return nil, err
+	}
+	html, hasHTML, err := read(".html")
+	if err != nil {
+		return nil, err
+	}
+	if !hasSubject && !hasText && !hasHTML {
+		return nil, nil
+	}
+	if !hasSubject || !hasText || !hasHTML {
+		return nil, fmt.Errorf("email template %s/%s needs a subject, text and html file", locale, name)
+	}
+
+	id := locale + "/" + name
+	tmpl := &template{}
+	if tmpl.subject, err = texttemplate.New(id + ".subject").Option("missingkey=error").Parse(strings.TrimSpace(subject)); err != nil {
+		return nil, fmt.Errorf("email template %s subject: %w", id, err)
+	}
+	if tmpl.text, err = texttemplate.New(id + ".txt").Option("missingkey=error").Parse(text); err != nil {
+		return nil, fmt.Errorf("email template %s text: %w", id, err)
+	}
+	if tmpl.html, err = htmltemplate.New(id + ".html").Option("missingkey=error").Parse(html); err != nil {
+		return nil, fmt.Errorf("email template %s html: %w", id, err)
+	}
+	return tmpl, nil
+}
+
+// Names lists the template names.
+func (s *Set) Names() []string {
+	return append([]string(nil), names...)
+}
+
+// Locales lists the locales that define at least one template.
+func (s *Set) Locales() []string {
+	return append([]string(nil), s.locales...)
+}
+
+// Render renders template name in locale, falling back to DefaultLocale
+// when the locale is unknown or does not define the template.
+func (s *Set) Render(name, locale string, data Data) (Message, error) {
+	tmpl := s.templates[s.Match(locale)][name]
+	if tmpl == nil {
+		tmpl = s.templates[DefaultLocale][name]
+	}
+	if tmpl == nil {
+		return Message{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		// Subjects must stay on a single header line.
+		Subject:   strings.Join(strings.Fields(subject.String()), " "),
+		PlainBody: text.String(),
+		HTMLBody:  html.String(),
+	}, nil
+}
+
+// Match returns the supported locale closest to the first usable entry of
+// preferred. Entries match exactly, ignoring case, or by primary language,
+// so "zh-TW" and "zh" select "zh-CN" when that is the only Chinese locale.
+// DefaultLocale is returned when nothing matches.
+func (s *Set) Match(preferred ...string) string {
+	for _, candidate := range preferred {
+		candidate = strings.TrimSpace(strings.ReplaceAll(candidate, "_", "-"))
+		if candidate == "" {
+			continue
+		}
+		for _, locale := range s.locales {
+			if strings.EqualFold(locale, candidate) {
+				return locale
+			}
+		}
+		primary, _, _ := strings.Cut(candidate, "-")
+		for _, locale := range s.locales {
+			localePrimary, _, _ := strings.Cut(locale, "-")
+			if strings.EqualFold(localePrimary, primary) {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header
+// ordered by preference. Tags with a zero quality and the "*" wildcard are
+// dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: tag, quality: quality})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, tag.tag)
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package emailtemplate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderBuiltinLocales(t *testing.T) {
+	set := Default()
+	if got := set.Locales(); !reflect.DeepEqual(got, []string{"en", "zh-CN"}) {
+		t.Fatalf("unexpected locales: %v", got)
+	}
+
+	data := Data{Name: "<alice>", Code: "123456", ExpiresAt: "2025-01-01T00:00:00Z", ExpiresInMinutes: 10}
+	for _, name := range set.Names() {
+		for _, locale := range set.Locales() {
+			msg, err := set.Render(name, locale, data)
+			if err != nil {
+				t.Fatalf("render %s/%s: %v", locale, name, err)
+			}
+			if msg.Subject == "" || !strings.Contains(msg.PlainBody, "123456") || !strings.Contains(msg.HTMLBody, "123456") {
+				t.Fatalf("incomplete %s/%s: %+v", locale, name, msg)
+			}
+		}
+	}
+
+	msg, _ := set.Render(Verification, "zh-CN", data)
+	if !strings.Contains(msg.Subject, "验证") || strings.Contains(msg.HTMLBody, "<alice>") {
+		t.Fatalf("expected a localized, escaped message, got %+v", msg)
+	}
+	msg, _ = set.Render(PasswordReset, "en", Data{Code: "token"})
+	if !strings.HasPrefix(msg.PlainBody, "Hello there,") {
+		t.Fatalf("expected a generic greeting without a name, got %q", msg.PlainBody)
+	}
+	if _, err := set.Render("unknown", "en", data); err == nil {
+		t.Fatal("expected unknown template to fail")
+	}
+}
+
+func TestMatchAndAcceptLanguage(t *testing.T) {
+	set := Default()
+	for _, tc := range []struct {
+		preferred []string
+		want      string
+	}{
+		{preferred: []string{"zh-cn"}, want: "zh-CN"},
+		{preferred: []string{"zh_TW"}, want: "zh-CN"},
+		{preferred: []string{"", "fr", "en-GB"}, want: "en"},
+		{preferred: []string{"fr"}, want: DefaultLocale},
+		{preferred: ParseAcceptLanguage("fr;q=0.9, zh-Hans;q=0.8, en;q=0.1, *"), want: "zh-CN"},
+	} {
+		if got := set.Match(tc.preferred...); got != tc.want {
+			t.Errorf("Match(%q) = %q, want %q", tc.preferred, got, tc.want)
+		}
+	}
+
+	if got := ParseAcceptLanguage("en;q=0, de-DE, fr;q=0.5"); !reflect.DeepEqual(got, []string{"de-DE", "fr"}) {
+		t.Fatalf("unexpected accept-language parse: %v", got)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("en/verification.subject.txt", "Custom {{.Code}}\n")
+	write("de/password_reset.subject.txt", "Passwort zurücksetzen")
+	write("de/password_reset.txt", "Token: {{.Code}}")
+	write("de/password_reset.html", "<p>Token: {{.Code}}</p>")
+
+	set, err := Load(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	msg, err := set.Render(Verification, "en", Data{Code: "42"})
+	if err != nil || msg.Subject != "Custom 42" || !strings.Contains(msg.PlainBody, "verification code") {
+		t.Fatalf("expected subject override with built-in bodies, got %+v (%v)", msg, err)
+	}
+	if msg, _ := set.Render(PasswordReset, "de-AT", Data{Code: "t"}); msg.PlainBody != "Token: t" {
+		t.Fatalf("expected added locale to be used, got %+v", msg)
+	}
+	if msg, _ := set.Render(Verification, "de", Data{Code: "t"}); msg.Subject != "Custom t" {
+		t.Fatalf("expected missing template to fall back to the default locale, got %+v", msg)
+	}
+
+	write("fr/verification.subject.txt", "Vérifiez")
+	if _, err := Load(dir); err == nil {
+		t.Fatal("expected a partially defined template to be rejected")
+	}
+}
@@ -0,0 +1,240 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDKIMHeaders are signed when DKIMConfig.Headers is empty. Headers
+// missing from a message are left out of the signature.
+var defaultDKIMHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "Reply-To",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// DKIMConfig configures DKIM signing (RFC 6376) with relaxed/relaxed
+// canonicalization. RSA keys sign with rsa-sha256 and Ed25519 keys with
+// ed25519-sha256 (RFC 8463).
+type DKIMConfig struct {
+	Domain     string
+	Selector   string
+	PrivateKey crypto.Signer
+	// Headers lists the header fields to sign. From is always signed.
+	Headers []string
+}
+
+// ParseDKIMPrivateKey decodes a PEM encoded PKCS #1 RSA key or a PKCS #8 RSA
+// or Ed25519 key.
+func ParseDKIMPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("dkim private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse dkim private key: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported dkim private key type %T", key)
+	}
+}
+
+type dkimSigner struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+	headers   []string
+	now       func() time.Time
+}
+
+func newDKIMSigner(cfg DKIMConfig) (*dkimSigner, error) {
+	domain := strings.TrimSpace(cfg.Domain)
+	selector := strings.TrimSpace(cfg.Selector)
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim domain and selector are required")
+	}
+	var algorithm string
+	switch cfg.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	case nil:
+		return nil, errors.New("dkim private key is required")
+	default:
+		return nil, fmt.Errorf("unsupported dkim private key type %T", cfg.PrivateKey)
+	}
+
+	names := cfg.Headers
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+	headers := []string{"from"}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && name != "from" && name != "dkim-signature" {
+			headers = append(headers, name)
+		}
+	}
+	return &dkimSigner{
+		domain:    domain,
+		selector:  selector,
+		key:       cfg.PrivateKey,
+		algorithm: algorithm,
+		headers:   headers,
+		now:       time.Now,
+	}, nil
+}
+
+// Sign returns message with a DKIM-Signature header prepended. message must
+// use CRLF line endings.
+func (s *dkimSigner) Sign(message []byte) ([]byte, error) {
+	header, body, ok := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("message has no header separator")
+	}
+	fields := parseHeaderFields(string(header) + "\r\n")
+
+	bodyHash := sha256.Sum256(canonicalBodyRelaxed(body))
+
+	// Repeated fields are signed from the bottom up.
+	used := make(map[string]int)
+	signedNames := make([]string, 0, len(s.headers))
+	var signed strings.Builder
+	for _, name := range s.headers {
+		field, ok := lastUnusedField(fields, name, used[name])
+		if !ok {
+			continue
+		}
+		used[name]++
+		signedNames = append(signedNames, name)
+		signed.WriteString(canonicalHeaderRelaxed(field))
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%s; h=%s; bh=%s; b=",
+		s.algorithm, s.domain, s.selector, strconv.FormatInt(s.now().Unix(), 10),
+		strings.Join(signedNames, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	signed.WriteString(strings.TrimSuffix(canonicalHeaderRelaxed("DKIM-Signature: "+value+"\r\n"), "\r\n"))
+
+	digest := sha256.Sum256([]byte(signed.String()))
+	var (
+		signature []byte
+		err       error
+	)
+	if s.algorithm == "ed25519-sha256" {
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	} else {
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signedMessage := make([]byte, 0, len(message)+len(value)+512)
+	signedMessage = append(signedMessage, "DKIM-Signature: "...)
+	signedMessage = append(signedMessage, value...)
+	signedMessage = append(signedMessage, foldBase64(base64.StdEncoding.EncodeToString(signature))...)
+	signedMessage = append(signedMessage, "\r\n"...)
+	return append(signedMessage, message...), nil
+}
+
+// parseHeaderFields splits a CRLF terminated header block into fields,
+// keeping folded continuation lines with their field.
+func parseHeaderFields(header string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// lastUnusedField returns the skip-th field named name counting from the
+// bottom of the header.
+func lastUnusedField(fields []string, name string, skip int) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		fieldName, _, ok := strings.Cut(fields[i], ":")
+		if !ok || !strings.EqualFold(strings.TrimRight(fieldName, " \t"), name) {
+			continue
+		}
+		if skip == 0 {
+			return fields[i], true
+		}
+		skip--
+	}
+	return "", false
+}
+
+// canonicalHeaderRelaxed implements the relaxed header canonicalization of
+// RFC 6376 section 3.4.2.
+func canonicalHeaderRelaxed(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalBodyRelaxed implements the relaxed body canonicalization of
+// RFC 6376 section 3.4.4.
+func canonicalBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		collapsed := strings.Join(strings.FieldsFunc(line, isWSP), " ")
+		if line != "" && isWSP(rune(line[0])) {
+			collapsed = " " + collapsed
+		}
+		lines[i] = strings.TrimRight(collapsed, " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldBase64 breaks a long signature over continuation lines, which DKIM
+// verifiers ignore in the b= tag.
+func foldBase64(value string) string {
+	const width = 72
+	var builder strings.Builder
+	for len(value) > width {
+		builder.WriteString(value[:width])
+		builder.WriteString("\r\n\t")
+		value = value[width:]
+	}
+	builder.WriteString(value)
+	return builder.String()
+}
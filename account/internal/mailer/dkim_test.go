@@ -0,0 +1,134 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	// Example from RFC 6376 section 3.4.5.
+	fields := parseHeaderFields("A: X\r\nB : Y\t\r\n\tZ  \r\n")
+	var header string
+	for _, field := range fields {
+		header += canonicalHeaderRelaxed(field)
+	}
+	if header != "a:X\r\nb:Y Z\r\n" {
+		t.Fatalf("unexpected canonical header %q", header)
+	}
+	if body := canonicalBodyRelaxed([]byte(" C \r\nD \t E\r\n\r\n\r\n")); string(body) != " C\r\nD E\r\n" {
+		t.Fatalf("unexpected canonical body %q", body)
+	}
+	if body := canonicalBodyRelaxed([]byte("\r\n\r\n")); len(body) != 0 {
+		t.Fatalf("expected empty body, got %q", body)
+	}
+}
+
+// verifyDKIM checks the first DKIM-Signature of message with the public key.
+func verifyDKIM(t *testing.T, message []byte, publicKey crypto.PublicKey) bool {
+	t.Helper()
+	header, body, _ := bytes.Cut(message, []byte("\r\n\r\n"))
+	fields := parseHeaderFields(string(header) + "\r\n")
+	signature := fields[0]
+	tags := make(map[string]string)
+	_, value, _ := strings.Cut(signature, ":")
+	for _, tag := range strings.Split(value, ";") {
+		name, tagValue, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(tagValue), "")
+	}
+
+	bodyHash := sha256.Sum256(canonicalBodyRelaxed(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return false
+	}
+
+	var signed strings.Builder
+	used := make(map[string]int)
+	for _, name := range strings.Split(tags["h"], ":") {
+		field, ok := lastUnusedField(fields[1:], name, used[name])
+		if !ok {
+			t.Fatalf("signed header %q is missing", name)
+		}
+		used[name]++
+		signed.WriteString(canonicalHeaderRelaxed(field))
+	}
+	b := signature[strings.Index(signature, "b=")+2:]
+	signed.WriteString(strings.TrimSuffix(canonicalHeaderRelaxed(strings.Replace(signature, b, "", 1)+"\r\n"), "\r\n"))
+	digest := sha256.Sum256([]byte(signed.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, digest[:], sig)
+	}
+	return false
+}
+
+func TestDKIMSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	for _, tc := range []struct {
+		pem       []byte
+		algorithm string
+	}{
+		{pem: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), algorithm: "a=rsa-sha256"},
+		{pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), algorithm: "a=ed25519-sha256"},
+	} {
+		key, err := ParseDKIMPrivateKey(tc.pem)
+		if err != nil {
+			t.Fatalf("parse key: %v", err)
+		}
+		signer, err := newDKIMSigner(DKIMConfig{Domain: "example.test", Selector: "mail", PrivateKey: key})
+		if err != nil {
+			t.Fatalf("new signer: %v", err)
+		}
+
+		composer, _ := newComposer(Config{From: "XControl <no-reply@example.test>"})
+		message, err := composer.buildMessage(Message{Subject: "验证", PlainBody: "code  123456 \n", HTMLBody: "<p>code</p>"}, []string{"<user@test>"})
+		if err != nil {
+			t.Fatalf("build: %v", err)
+		}
+		signed, err := signer.Sign(message)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		if !bytes.HasPrefix(signed, []byte("DKIM-Signature: v=1; "+tc.algorithm+"; c=relaxed/relaxed; d=example.test; s=mail;")) {
+			t.Fatalf("unexpected signature header: %q", signed[:120])
+		}
+		if !strings.Contains(string(signed), "h=from:to:subject:date:message-id:mime-version:content-type;") {
+			t.Fatalf("expected only present headers to be signed: %q", signed[:400])
+		}
+		if !verifyDKIM(t, signed, key.Public()) {
+			t.Fatalf("signature does not verify: %q", signed)
+		}
+		tampered := bytes.Replace(signed, []byte("<p>code</p>"), []byte("<p>c0de</p>"), 1)
+		if verifyDKIM(t, tampered, key.Public()) {
+			t.Fatal("expected tampered body to fail verification")
+		}
+	}
+
+	if _, err := newDKIMSigner(DKIMConfig{Domain: "example.test", PrivateKey: rsaKey}); err == nil {
+		t.Fatal("expected missing selector to be rejected")
+	}
+}
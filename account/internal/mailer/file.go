@@ -0,0 +1,85 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileConfig configures the file transport, which delivers into a local
+// Maildir for development instead of sending anything.
+type FileConfig struct {
+	// Dir is the Maildir; its tmp, new and cur subdirectories are created
+	// when missing.
+	Dir string
+}
+
+type fileSender struct {
+	composer
+	dir      string
+	hostname string
+}
+
+func newFileSender(cfg Config) (Sender, error) {
+	dir := strings.TrimSpace(cfg.File.Dir)
+	if dir == "" {
+		return nil, errors.New("file mail transport requires a directory")
+	}
+	composer, err := newComposer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("create maildir: %w", err)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	// Maildir reserves "/" and ":" in file names.
+	hostname = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname)
+	return &fileSender{composer: composer, dir: dir, hostname: hostname}, nil
+}
+
+// Send writes the message to tmp and moves it to new once complete, so that
+// readers never see partial messages.
+func (s *fileSender) Send(ctx context.Context, msg Message) error {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	_, headerTo, err := s.parseRecipients(msg.To)
+	if err != nil {
+		return Permanent(err)
+	}
+	data, err := s.buildMessage(msg, headerTo)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dR%s.%s", now.Unix(), now.Nanosecond()/1000, hex.EncodeToString(unique), s.hostname)
+
+	tmpPath := filepath.Join(s.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.dir, "new", name)); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
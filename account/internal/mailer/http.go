@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPConfig configures the HTTP transport, which posts every message as
+// JSON to a webhook such as an email API gateway:
+//
+//	{"from": "...", "replyTo": "...", "to": ["..."], "subject": "...",
+//	 "text": "...", "html": "..."}
+//
+// Any 2xx status is a successful delivery. 4xx statuses other than 408 and
+// 429 are permanent failures.
+type HTTPConfig struct {
+	URL string
+	// Headers are added to every request, for example an Authorization
+	// header carrying the API key.
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+type httpPayload struct {
+	From    string   `json:"from"`
+	ReplyTo string   `json:"replyTo,omitempty"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html,omitempty"`
+}
+
+type httpSender struct {
+	composer
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPSender(cfg Config) (Sender, error) {
+	endpoint := strings.TrimSpace(cfg.HTTP.URL)
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("http mail transport requires an absolute http(s) url, got %q", endpoint)
+	}
+	composer, err := newComposer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.HTTP.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	headers := make(map[string]string, len(cfg.HTTP.Headers))
+	for key, value := range cfg.HTTP.Headers {
+		headers[key] = value
+	}
+	return &httpSender{
+		composer: composer,
+		url:      endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *httpSender) Send(ctx context.Context, msg Message) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, headerTo, err := s.parseRecipients(msg.To)
+	if err != nil {
+		return Permanent(err)
+	}
+	if len(headerTo) == 0 {
+		return Permanent(errors.New("no recipients specified"))
+	}
+
+	payload := httpPayload{
+		From:    s.from.String(),
+		To:      headerTo,
+		Subject: msg.Subject,
+		Text:    msg.PlainBody,
+		HTML:    msg.HTMLBody,
+	}
+	if s.replyTo != nil {
+		payload.ReplyTo = s.replyTo.String()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("mail webhook returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
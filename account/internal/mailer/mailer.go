@@ -55,8 +55,12 @@ func normalizeTLSMode(mode TLSMode) TLSMode {
 	return ParseTLSMode(string(mode))
 }
 
-// Config contains the information required to send email via SMTP.
+// Config selects a transport and holds its settings. Host through
+// InsecureSkipVerify configure the SMTP transport; From and ReplyTo apply to
+// every transport.
 type Config struct {
+	// Transport names a registered transport. Empty selects TransportSMTP.
+	Transport          string
 	Host               string
 	Port               int
 	Username           string
@@ -66,6 +70,12 @@ type Config struct {
 	Timeout            time.Duration
 	TLSMode            TLSMode
 	InsecureSkipVerify bool
+	// DKIM signs messages sent by the SMTP transport when set.
+	DKIM *DKIMConfig
+
+	HTTP     HTTPConfig
+	File     FileConfig
+	Sendmail SendmailConfig
 }
 
 // Message represents an outbound email.
@@ -76,25 +86,50 @@ type Message struct {
 	HTMLBody  string
 }
 
-// Sender sends email messages over SMTP.
+// Sender delivers email messages.
 type Sender interface {
 	Send(ctx context.Context, msg Message) error
 }
 
 type smtpSender struct {
+	composer
 	host               string
 	port               int
 	username           string
 	password           string
-	from               *mail.Address
-	replyTo            *mail.Address
+	dkim               *dkimSigner
 	timeout            time.Duration
 	tlsMode            TLSMode
 	insecureSkipVerify bool
 }
 
-// New constructs a Sender based on the provided configuration.
-func New(cfg Config) (Sender, error) {
+// composer renders messages in RFC 5322 format for the transports that
+// deliver raw messages.
+type composer struct {
+	from    *mail.Address
+	replyTo *mail.Address
+}
+
+func newComposer(cfg Config) (composer, error) {
+	from := strings.TrimSpace(cfg.From)
+	if from == "" {
+		return composer{}, errors.New("from address is required")
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return composer{}, fmt.Errorf("invalid from address: %w", err)
+	}
+	var replyAddr *mail.Address
+	if reply := strings.TrimSpace(cfg.ReplyTo); reply != "" {
+		replyAddr, err = mail.ParseAddress(reply)
+		if err != nil {
+			return composer{}, fmt.Errorf("invalid reply-to address: %w", err)
+		}
+	}
+	return composer{from: fromAddr, replyTo: replyAddr}, nil
+}
+
+func newSMTPSender(cfg Config) (Sender, error) {
 	host := strings.TrimSpace(cfg.Host)
 	if host == "" {
 		return nil, errors.New("smtp host is required")
@@ -105,19 +140,14 @@ func New(cfg Config) (Sender, error) {
 	if cfg.Timeout <= 0 {
 		cfg.Timeout = 10 * time.Second
 	}
-	from := strings.TrimSpace(cfg.From)
-	if from == "" {
-		return nil, errors.New("smtp from address is required")
-	}
-	fromAddr, err := mail.ParseAddress(from)
+	composer, err := newComposer(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("invalid from address: %w", err)
+		return nil, err
 	}
-	var replyAddr *mail.Address
-	if reply := strings.TrimSpace(cfg.ReplyTo); reply != "" {
-		replyAddr, err = mail.ParseAddress(reply)
-		if err != nil {
-			return nil, fmt.Errorf("invalid reply-to address: %w", err)
+	var signer *dkimSigner
+	if cfg.DKIM != nil {
+		if signer, err = newDKIMSigner(*cfg.DKIM); err != nil {
+			return nil, err
 		}
 	}
 
@@ -131,8 +161,8 @@ func New(cfg Config) (Sender, error) {
 		port:               cfg.Port,
 		username:           strings.TrimSpace(cfg.Username),
 		password:           cfg.Password,
-		from:               fromAddr,
-		replyTo:            replyAddr,
+		composer:           composer,
+		dkim:               signer,
 		timeout:            cfg.Timeout,
 		tlsMode:            mode,
 		insecureSkipVerify: cfg.InsecureSkipVerify,
@@ -154,16 +184,21 @@ func (s *smtpSender) Send(ctx context.Context, msg Message) error {
 
 	recipients, headerTo, err := s.parseRecipients(msg.To)
 	if err != nil {
-		return err
+		return Permanent(err)
 	}
 	if len(recipients) == 0 {
-		return errors.New("no recipients specified")
+		return Permanent(errors.New("no recipients specified"))
 	}
 
 	data, err := s.buildMessage(msg, headerTo)
 	if err != nil {
 		return err
 	}
+	if s.dkim != nil {
+		if data, err = s.dkim.Sign(data); err != nil {
+			return fmt.Errorf("dkim sign: %w", err)
+		}
+	}
 
 	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
 	dialer := &net.Dialer{Timeout: s.timeout}
@@ -261,7 +296,7 @@ func (s *smtpSender) Send(ctx context.Context, msg Message) error {
 	return nil
 }
 
-func (s *smtpSender) parseRecipients(addresses []string) ([]*mail.Address, []string, error) {
+func (c composer) parseRecipients(addresses []string) ([]*mail.Address, []string, error) {
 	parsed := make([]*mail.Address, 0, len(addresses))
 	headerValues := make([]string, 0, len(addresses))
 	for _, addr := range addresses {
@@ -279,27 +314,39 @@ func (s *smtpSender) parseRecipients(addresses []string) ([]*mail.Address, []str
 	return parsed, headerValues, nil
 }
 
-func (s *smtpSender) buildMessage(msg Message, headerTo []string) ([]byte, error) {
+func (c composer) buildMessage(msg Message, headerTo []string) ([]byte, error) {
 	if len(headerTo) == 0 {
 		return nil, errors.New("no recipients specified")
 	}
 	var builder strings.Builder
 	builder.Grow(512 + len(msg.PlainBody) + len(msg.HTMLBody))
 
+	messageID, err := c.messageID()
+	if err != nil {
+		return nil, err
+	}
 	subject := encodeHeader(msg.Subject)
 	headers := []string{
-		fmt.Sprintf("From: %s", s.from.String()),
+		fmt.Sprintf("From: %s", c.from.String()),
 		fmt.Sprintf("To: %s", strings.Join(headerTo, ", ")),
 		fmt.Sprintf("Subject: %s", subject),
+		fmt.Sprintf("Date: %s", time.Now().Format(time.RFC1123Z)),
+		fmt.Sprintf("Message-ID: %s", messageID),
 		"MIME-Version: 1.0",
 	}
-	if s.replyTo != nil {
-		headers = append(headers, fmt.Sprintf("Reply-To: %s", s.replyTo.String()))
+	if c.replyTo != nil {
+		headers = append(headers, fmt.Sprintf("Reply-To: %s", c.replyTo.String()))
 	}
 
 	htmlBody := strings.TrimSpace(msg.HTMLBody)
 	plainBody := strings.TrimSpace(msg.PlainBody)
 
+	// Localized bodies are not 7-bit clean.
+	plainEncoding, plainContent := "7bit", normalizeNewlines(plainBody)
+	if !isASCII(plainBody) {
+		plainEncoding, plainContent = "quoted-printable", toQuotedPrintable(plainBody)
+	}
+
 	if htmlBody != "" {
 		boundary, err := randomBoundary()
 		if err != nil {
@@ -313,8 +360,8 @@ func (s *smtpSender) buildMessage(msg Message, headerTo []string) ([]byte, error
 		builder.WriteString("\r\n")
 		builder.WriteString(fmt.Sprintf("--%s\r\n", boundary))
 		builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-		builder.WriteString("Content-Transfer-Encoding: 7bit\r\n\r\n")
-		builder.WriteString(normalizeNewlines(plainBody))
+		builder.WriteString(fmt.Sprintf("Content-Transfer-Encoding: %s\r\n\r\n", plainEncoding))
+		builder.WriteString(plainContent)
 		builder.WriteString("\r\n\r\n")
 		builder.WriteString(fmt.Sprintf("--%s\r\n", boundary))
 		builder.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
@@ -324,19 +371,32 @@ func (s *smtpSender) buildMessage(msg Message, headerTo []string) ([]byte, error
 		builder.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
 	} else {
 		headers = append(headers, "Content-Type: text/plain; charset=UTF-8")
-		headers = append(headers, "Content-Transfer-Encoding: 7bit")
+		headers = append(headers, fmt.Sprintf("Content-Transfer-Encoding: %s", plainEncoding))
 		for _, header := range headers {
 			builder.WriteString(header)
 			builder.WriteString("\r\n")
 		}
 		builder.WriteString("\r\n")
-		builder.WriteString(normalizeNewlines(plainBody))
+		builder.WriteString(plainContent)
 		builder.WriteString("\r\n")
 	}
 
 	return []byte(builder.String()), nil
 }
 
+// messageID returns a unique Message-ID in the domain of the sender.
+func (c composer) messageID() (string, error) {
+	id, err := randomBoundary()
+	if err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if _, host, ok := strings.Cut(c.from.Address, "@"); ok && host != "" {
+		domain = host
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), id, domain), nil
+}
+
 func (s *smtpSender) tlsConfig() *tls.Config {
 	return &tls.Config{
 		ServerName:         s.host,
@@ -1,6 +1,9 @@
 package mailer
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestParseTLSMode(t *testing.T) {
 	cases := map[string]TLSMode{
@@ -47,3 +50,26 @@ func TestNewImplicitModeAutodetect(t *testing.T) {
 		t.Fatalf("expected tlsMode %q, got %q", TLSModeImplicit, s.tlsMode)
 	}
 }
+
+func TestBuildMessageEncodesNonASCIIPlainBody(t *testing.T) {
+	sender, err := New(Config{Host: "smtp.test", From: "no-reply@test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	smtp := sender.(*smtpSender)
+
+	for _, htmlBody := range []string{"", "<p>验证码</p>"} {
+		data, err := smtp.buildMessage(Message{Subject: "验证", PlainBody: "验证码：123456", HTMLBody: htmlBody}, []string{"user@test"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, b := range data {
+			if b >= 128 {
+				t.Fatalf("expected a 7-bit clean message, got %q", data)
+			}
+		}
+		if strings.Contains(string(data), "Content-Transfer-Encoding: 7bit") {
+			t.Fatalf("expected non-ASCII text to be quoted-printable encoded, got %q", data)
+		}
+	}
+}
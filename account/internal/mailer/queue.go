@@ -0,0 +1,328 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueuePollInterval   = 5 * time.Second
+	defaultQueueBatchSize      = 20
+	defaultQueueMaxAttempts    = 8
+	defaultQueueInitialBackoff = 30 * time.Second
+	defaultQueueMaxBackoff     = time.Hour
+	defaultQueueLease          = 5 * time.Minute
+)
+
+// ErrQueueClosed is returned by Queue.Send after Close has been called.
+var ErrQueueClosed = errors.New("mail queue is closed")
+
+// PermanentError marks a delivery failure that retrying cannot fix, such as
+// a malformed recipient. Queued messages failing this way are dead-lettered
+// immediately.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err so that IsPermanent reports true for it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err is a PermanentError or a 5xx SMTP reply.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500 && reply.Code < 600
+}
+
+// QueuedMessage is a message claimed from a QueueStore.
+type QueuedMessage struct {
+	ID      string
+	Message Message
+	// Attempts counts the failed deliveries so far.
+	Attempts int
+}
+
+// QueueStats summarises the messages held by a QueueStore.
+type QueueStats struct {
+	Pending      int
+	DeadLettered int
+}
+
+// QueueStore persists queued messages so that they survive restarts.
+type QueueStore interface {
+	Enqueue(ctx context.Context, msg Message) error
+	// Claim returns up to limit messages due at now and hides them from
+	// other claims until now+lease.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]QueuedMessage, error)
+	Complete(ctx context.Context, id string) error
+	Retry(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error
+	DeadLetter(ctx context.Context, id string, attempts int, lastError string) error
+	Stats(ctx context.Context) (QueueStats, error)
+}
+
+// QueueConfig tunes delivery retries. Zero values select the defaults.
+type QueueConfig struct {
+	// PollInterval is how often the store is checked for due messages when
+	// no new message wakes the worker.
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts is the number of deliveries tried before a message is
+	// dead-lettered.
+	MaxAttempts int
+	// InitialBackoff is the delay after the first failure. It doubles with
+	// every further failure up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Logger         *slog.Logger
+}
+
+// QueueMetrics reports the queue depth and delivery outcomes since start.
+type QueueMetrics struct {
+	Pending      int   `json:"pending"`
+	DeadLettered int   `json:"deadLettered"`
+	Sent         int64 `json:"sent"`
+	Failed       int64 `json:"failed"`
+	Retried      int64 `json:"retried"`
+	// Abandoned counts messages dead-lettered since start.
+	Abandoned int64 `json:"abandoned"`
+}
+
+// Queue is a Sender that persists messages and delivers them in the
+// background with exponential backoff.
+type Queue struct {
+	sender Sender
+	store  QueueStore
+	cfg    QueueConfig
+	logger *slog.Logger
+	now    func() time.Time
+
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+	closed    atomic.Bool
+
+	sent      atomic.Int64
+	failed    atomic.Int64
+	retried   atomic.Int64
+	abandoned atomic.Int64
+}
+
+// NewQueue returns a queue delivering through sender. Call Start to begin
+// delivery and Close to drain it.
+func NewQueue(sender Sender, store QueueStore, cfg QueueConfig) *Queue {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultQueuePollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultQueueBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultQueueMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultQueueInitialBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = defaultQueueMaxBackoff
+		if cfg.MaxBackoff < cfg.InitialBackoff {
+			cfg.MaxBackoff = cfg.InitialBackoff
+		}
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Queue{
+		sender: sender,
+		store:  store,
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Send persists msg for delivery. It only fails when the message cannot be
+// queued; delivery errors are retried in the background.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	if q.closed.Load() {
+		return ErrQueueClosed
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		if trimmed := strings.TrimSpace(to); trimmed != "" {
+			recipients = append(recipients, trimmed)
+		}
+	}
+	if len(recipients) == 0 {
+		return errors.New("no recipients specified")
+	}
+	msg.To = recipients
+
+	if err := q.store.Enqueue(ctx, msg); err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start launches the delivery worker. It is a no-op after the first call.
+func (q *Queue) Start() {
+	q.startOnce.Do(func() {
+		go q.run()
+	})
+}
+
+// Close stops accepting messages, waits for the worker and then delivers the
+// messages that are already due until none are left or ctx expires. Messages
+// that are backing off stay in the store for the next start.
+func (q *Queue) Close(ctx context.Context) error {
+	q.closeOnce.Do(func() {
+		q.closed.Store(true)
+		close(q.stop)
+	})
+	q.startOnce.Do(func() { close(q.done) })
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for {
+		delivered, err := q.deliverDue(ctx)
+		if err != nil {
+			return err
+		}
+		if delivered == 0 {
+			return nil
+		}
+	}
+}
+
+// Metrics returns the queue depth from the store and the delivery counters
+// of this process.
+func (q *Queue) Metrics(ctx context.Context) (QueueMetrics, error) {
+	stats, err := q.store.Stats(ctx)
+	if err != nil {
+		return QueueMetrics{}, err
+	}
+	return QueueMetrics{
+		Pending:      stats.Pending,
+		DeadLettered: stats.DeadLettered,
+		Sent:         q.sent.Load(),
+		Failed:       q.failed.Load(),
+		Retried:      q.retried.Load(),
+		Abandoned:    q.abandoned.Load(),
+	}, nil
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+
+	// In-flight deliveries are not cancelled on Close; the sender enforces
+	// its own timeout and Close waits for the current batch.
+	ctx := context.Background()
+	for {
+		for {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+			delivered, err := q.deliverDue(ctx)
+			if err != nil {
+				q.logger.Error("mail queue delivery failed", "err", err)
+			}
+			if err != nil || delivered == 0 {
+				break
+			}
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue claims one batch of due messages and attempts each of them. It
+// returns the number of messages claimed.
+func (q *Queue) deliverDue(ctx context.Context) (int, error) {
+	batch, err := q.store.Claim(ctx, q.now(), defaultQueueLease, q.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, queued := range batch {
+		if err := q.deliver(ctx, queued); err != nil {
+			return len(batch), err
+		}
+	}
+	return len(batch), nil
+}
+
+func (q *Queue) deliver(ctx context.Context, queued QueuedMessage) error {
+	sendErr := q.sender.Send(ctx, queued.Message)
+	if sendErr == nil {
+		q.sent.Add(1)
+		return q.store.Complete(ctx, queued.ID)
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the message is retried.
+		return ctx.Err()
+	}
+
+	q.failed.Add(1)
+	attempts := queued.Attempts + 1
+	if IsPermanent(sendErr) || attempts >= q.cfg.MaxAttempts {
+		q.abandoned.Add(1)
+		q.logger.Error("mail queue gave up on message", "id", queued.ID, "attempts", attempts, "err", sendErr)
+		return q.store.DeadLetter(ctx, queued.ID, attempts, sendErr.Error())
+	}
+
+	q.retried.Add(1)
+	next := q.now().Add(q.backoff(attempts))
+	q.logger.Warn("mail delivery failed; retrying", "id", queued.ID, "attempts", attempts, "nextAttemptAt", next, "err", sendErr)
+	return q.store.Retry(ctx, queued.ID, attempts, next, sendErr.Error())
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.cfg.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= q.cfg.MaxBackoff {
+			return q.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
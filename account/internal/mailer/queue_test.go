@@ -0,0 +1,214 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeQueueEntry struct {
+	queued       QueuedMessage
+	next         time.Time
+	lastError    string
+	deadLettered bool
+}
+
+type fakeQueueStore struct {
+	mu      sync.Mutex
+	seq     int
+	entries map[string]*fakeQueueEntry
+}
+
+func newFakeQueueStore() *fakeQueueStore {
+	return &fakeQueueStore{entries: make(map[string]*fakeQueueEntry)}
+}
+
+func (s *fakeQueueStore) Enqueue(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	id := strconv.Itoa(s.seq)
+	s.entries[id] = &fakeQueueEntry{queued: QueuedMessage{ID: id, Message: msg}}
+	return nil
+}
+
+func (s *fakeQueueStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]QueuedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0)
+	for id, entry := range s.entries {
+		if !entry.deadLettered && !entry.next.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	claimed := make([]QueuedMessage, 0, len(ids))
+	for _, id := range ids {
+		s.entries[id].next = now.Add(lease)
+		claimed = append(claimed, s.entries[id].queued)
+	}
+	return claimed, nil
+}
+
+func (s *fakeQueueStore) Complete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *fakeQueueStore) Retry(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[id]
+	entry.queued.Attempts = attempts
+	entry.next = nextAttemptAt
+	entry.lastError = lastError
+	return nil
+}
+
+func (s *fakeQueueStore) DeadLetter(ctx context.Context, id string, attempts int, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[id]
+	entry.queued.Attempts = attempts
+	entry.lastError = lastError
+	entry.deadLettered = true
+	return nil
+}
+
+func (s *fakeQueueStore) Stats(ctx context.Context) (QueueStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stats QueueStats
+	for _, entry := range s.entries {
+		if entry.deadLettered {
+			stats.DeadLettered++
+		} else {
+			stats.Pending++
+		}
+	}
+	return stats, nil
+}
+
+// scriptedSender fails with the queued errors in order and succeeds once
+// they are used up.
+type scriptedSender struct {
+	mu       sync.Mutex
+	failures []error
+	sent     []Message
+}
+
+func (s *scriptedSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) > 0 {
+		err := s.failures[0]
+		s.failures = s.failures[1:]
+		return err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func waitForMetrics(t *testing.T, q *Queue, done func(QueueMetrics) bool) QueueMetrics {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		metrics, err := q.Metrics(context.Background())
+		if err != nil {
+			t.Fatalf("metrics: %v", err)
+		}
+		if done(metrics) {
+			return metrics
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for queue, last metrics %+v", metrics)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	transient := &textproto.Error{Code: 451, Msg: "try again later"}
+	sender := &scriptedSender{failures: []error{transient, errors.New("connection reset")}}
+	q := NewQueue(sender, newFakeQueueStore(), QueueConfig{PollInterval: 5 * time.Millisecond, InitialBackoff: 10 * time.Millisecond})
+	q.Start()
+	defer q.Close(context.Background())
+
+	if err := q.Send(context.Background(), Message{To: []string{" user@test "}, Subject: "hello"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	metrics := waitForMetrics(t, q, func(m QueueMetrics) bool { return m.Sent == 1 })
+	if metrics.Failed != 2 || metrics.Retried != 2 || metrics.Pending != 0 || metrics.DeadLettered != 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].To[0] != "user@test" {
+		t.Fatalf("unexpected deliveries: %+v", sender.sent)
+	}
+}
+
+func TestQueueDeadLettersPermanentFailures(t *testing.T) {
+	sender := &scriptedSender{failures: []error{
+		&textproto.Error{Code: 550, Msg: "mailbox unavailable"},
+		errors.New("timeout"),
+		errors.New("timeout"),
+	}}
+	q := NewQueue(sender, newFakeQueueStore(), QueueConfig{PollInterval: 5 * time.Millisecond, MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	q.Start()
+	defer q.Close(context.Background())
+
+	for _, to := range []string{"gone@test", "flaky@test"} {
+		if err := q.Send(context.Background(), Message{To: []string{to}}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	metrics := waitForMetrics(t, q, func(m QueueMetrics) bool { return m.DeadLettered == 2 })
+	if metrics.Abandoned != 2 || metrics.Failed != 3 || metrics.Sent != 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
+
+func TestQueueCloseDrainsDueMessages(t *testing.T) {
+	sender := &scriptedSender{}
+	store := newFakeQueueStore()
+	q := NewQueue(sender, store, QueueConfig{})
+
+	for i := 0; i < 3; i++ {
+		if err := q.Send(context.Background(), Message{To: []string{"user@test"}}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	if err := q.Send(context.Background(), Message{}); err == nil {
+		t.Fatal("expected message without recipients to be rejected")
+	}
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if len(sender.sent) != 3 {
+		t.Fatalf("expected queued messages to be delivered on close, got %d", len(sender.sent))
+	}
+	if err := q.Send(context.Background(), Message{To: []string{"user@test"}}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("expected closed queue to reject messages, got %v", err)
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := NewQueue(&scriptedSender{}, newFakeQueueStore(), QueueConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+	if !IsPermanent(Permanent(errors.New("bad address"))) || IsPermanent(&textproto.Error{Code: 421}) {
+		t.Fatal("unexpected permanent error classification")
+	}
+}
@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultSendmailPath = "/usr/sbin/sendmail"
+
+// SendmailConfig configures the sendmail transport, which pipes messages to
+// a sendmail compatible binary such as the one shipped with Postfix or
+// msmtp.
+type SendmailConfig struct {
+	// Path defaults to /usr/sbin/sendmail.
+	Path string
+	// Args precede the envelope options. They default to "-i" so that a
+	// line containing a single dot does not end the message.
+	Args    []string
+	Timeout time.Duration
+}
+
+// Exit codes from sysexits.h that retrying will not fix.
+var permanentSendmailExitCodes = map[int]bool{
+	64: true, // EX_USAGE
+	65: true, // EX_DATAERR
+	67: true, // EX_NOUSER
+	68: true, // EX_NOHOST
+}
+
+type sendmailSender struct {
+	composer
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+func newSendmailSender(cfg Config) (Sender, error) {
+	composer, err := newComposer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimSpace(cfg.Sendmail.Path)
+	if path == "" {
+		path = defaultSendmailPath
+	}
+	args := append([]string(nil), cfg.Sendmail.Args...)
+	if len(args) == 0 {
+		args = []string{"-i"}
+	}
+	timeout := cfg.Sendmail.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &sendmailSender{composer: composer, path: path, args: args, timeout: timeout}, nil
+}
+
+func (s *sendmailSender) Send(ctx context.Context, msg Message) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	recipients, headerTo, err := s.parseRecipients(msg.To)
+	if err != nil {
+		return Permanent(err)
+	}
+	if len(recipients) == 0 {
+		return Permanent(errors.New("no recipients specified"))
+	}
+	data, err := s.buildMessage(msg, headerTo)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	args := append(append([]string(nil), s.args...), "-f", s.from.Address, "--")
+	for _, rcpt := range recipients {
+		args = append(args, rcpt.Address)
+	}
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = fmt.Errorf("sendmail exited with status %d: %s", exitErr.ExitCode(), detail)
+			if permanentSendmailExitCodes[exitErr.ExitCode()] {
+				return Permanent(err)
+			}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Built-in transport names.
+const (
+	TransportSMTP     = "smtp"
+	TransportHTTP     = "http"
+	TransportFile     = "file"
+	TransportSendmail = "sendmail"
+)
+
+// TransportFactory builds a Sender from the configuration.
+type TransportFactory func(cfg Config) (Sender, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]TransportFactory{
+		TransportSMTP:     newSMTPSender,
+		TransportHTTP:     newHTTPSender,
+		TransportFile:     newFileSender,
+		TransportSendmail: newSendmailSender,
+	}
+)
+
+// RegisterTransport makes a transport selectable through Config.Transport.
+// Registering an existing name replaces it.
+func RegisterTransport(name string, factory TransportFactory) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || factory == nil {
+		panic("mailer: transport name and factory are required")
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = factory
+}
+
+// Transports lists the registered transport names.
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New constructs the Sender of the transport selected by cfg.Transport.
+func New(cfg Config) (Sender, error) {
+	name := strings.ToLower(strings.TrimSpace(cfg.Transport))
+	if name == "" {
+		name = TransportSMTP
+	}
+	transportsMu.RLock()
+	factory, ok := transports[name]
+	transportsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported mail transport %q", cfg.Transport)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewSelectsTransport(t *testing.T) {
+	dir := t.TempDir()
+	for transport, want := range map[string]string{
+		"":                "*mailer.smtpSender",
+		" SMTP ":          "*mailer.smtpSender",
+		TransportHTTP:     "*mailer.httpSender",
+		TransportFile:     "*mailer.fileSender",
+		TransportSendmail: "*mailer.sendmailSender",
+	} {
+		sender, err := New(Config{
+			Transport: transport,
+			Host:      "smtp.test",
+			From:      "no-reply@test",
+			HTTP:      HTTPConfig{URL: "https://mail.test/send"},
+			File:      FileConfig{Dir: dir},
+		})
+		if err != nil {
+			t.Fatalf("New(%q): %v", transport, err)
+		}
+		if got := fmt.Sprintf("%T", sender); got != want {
+			t.Fatalf("New(%q) = %s, want %s", transport, got, want)
+		}
+	}
+
+	if _, err := New(Config{Transport: "carrier-pigeon", From: "no-reply@test"}); err == nil {
+		t.Fatal("expected unknown transport to be rejected")
+	}
+	if _, err := New(Config{Transport: TransportHTTP, From: "no-reply@test", HTTP: HTTPConfig{URL: "/relative"}}); err == nil {
+		t.Fatal("expected relative webhook url to be rejected")
+	}
+
+	RegisterTransport("test-null", func(Config) (Sender, error) { return &scriptedSender{}, nil })
+	if sender, err := New(Config{Transport: "test-null"}); err != nil || fmt.Sprintf("%T", sender) != "*mailer.scriptedSender" {
+		t.Fatalf("expected registered transport to be used, got %T (%v)", sender, err)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	var (
+		payload httpPayload
+		status  = http.StatusAccepted
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender, err := New(Config{
+		Transport: TransportHTTP,
+		From:      "XControl <no-reply@test>",
+		ReplyTo:   "support@test",
+		HTTP:      HTTPConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer key"}},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	msg := Message{To: []string{"user@test"}, Subject: "验证", PlainBody: "text", HTMLBody: "<p>html</p>"}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if payload.From != `"XControl" <no-reply@test>` || payload.ReplyTo != "<support@test>" || payload.To[0] != "<user@test>" ||
+		payload.Subject != "验证" || payload.Text != "text" || payload.HTML != "<p>html</p>" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	status = http.StatusUnprocessableEntity
+	if err := sender.Send(context.Background(), msg); !IsPermanent(err) {
+		t.Fatalf("expected 422 to be permanent, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := sender.Send(context.Background(), msg); err == nil || IsPermanent(err) {
+		t.Fatalf("expected 503 to be retried, got %v", err)
+	}
+}
+
+func TestFileTransportWritesMaildir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	sender, err := New(Config{Transport: TransportFile, From: "no-reply@test", File: FileConfig{Dir: dir}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := sender.Send(context.Background(), Message{To: []string{"user@test"}, Subject: "hello", PlainBody: "body"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one delivered message, got %v (%v)", entries, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.Contains(string(data), "Subject: hello\r\n") || !strings.Contains(string(data), "Message-ID: <") {
+		t.Fatalf("unexpected message: %q", data)
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Fatalf("expected tmp to be empty, got %v", tmp)
+	}
+}
+
+func TestSendmailTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sendmail")
+	out := filepath.Join(dir, "out")
+	content := "#!/bin/sh\n" +
+		"if [ \"$5\" = \"bounce@test\" ]; then echo 'no such user' >&2; exit 67; fi\n" +
+		"echo \"$@\" > " + out + ".args\ncat > " + out + "\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	sender, err := New(Config{Transport: TransportSendmail, From: "XControl <no-reply@test>", Sendmail: SendmailConfig{Path: script}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := sender.Send(context.Background(), Message{To: []string{"user@test"}, Subject: "hello", PlainBody: "body"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	args, _ := os.ReadFile(out + ".args")
+	if strings.TrimSpace(string(args)) != "-i -f no-reply@test -- user@test" {
+		t.Fatalf("unexpected sendmail arguments: %q", args)
+	}
+	if data, _ := os.ReadFile(out); !strings.Contains(string(data), "To: <user@test>\r\n") {
+		t.Fatalf("unexpected message: %q", data)
+	}
+
+	err = sender.Send(context.Background(), Message{To: []string{"bounce@test"}, Subject: "hello"})
+	if !IsPermanent(err) || !strings.Contains(err.Error(), "no such user") {
+		t.Fatalf("expected EX_NOUSER to be permanent, got %v", err)
+	}
+}
@@ -41,6 +41,9 @@ type UserRecord struct {
 	MFAEnabled        bool       `yaml:"mfaEnabled"`
 	MFASecretIssuedAt *time.Time `yaml:"mfaSecretIssuedAt,omitempty"`
 	MFAConfirmedAt    *time.Time `yaml:"mfaConfirmedAt,omitempty"`
+	SyncSecret        string     `yaml:"syncSecret,omitempty"`
+	Disabled          bool       `yaml:"disabled"`
+	Locale            string     `yaml:"locale,omitempty"`
 }
 
 // IdentityRecord captures a federated identity row associated with a user.
@@ -446,7 +449,7 @@ func (i *Importer) Import(ctx context.Context, dsn string, dump *AccountDump, op
 	return report, nil
 }
 
-const userSelectColumns = `uuid, username, password, email, email_verified, email_verified_at, level, role, groups, permissions, created_at, updated_at, mfa_totp_secret, mfa_enabled, mfa_secret_issued_at, mfa_confirmed_at`
+const userSelectColumns = `uuid, username, password, email, email_verified, email_verified_at, level, role, groups, permissions, created_at, updated_at, mfa_totp_secret, mfa_enabled, mfa_secret_issued_at, mfa_confirmed_at, sync_secret, disabled, locale`
 
 type rowScanner interface {
 	Scan(dest ...any) error
@@ -533,6 +536,9 @@ func scanUserRow(scanner rowScanner) (UserRecord, error) {
 		mfaEnabled      sql.NullBool
 		mfaIssuedAt     sql.NullTime
 		mfaConfirmedAt  sql.NullTime
+		syncSecret      sql.NullString
+		disabled        sql.NullBool
+		locale          sql.NullString
 		user            UserRecord
 	)
 
@@ -553,6 +559,9 @@ func scanUserRow(scanner rowScanner) (UserRecord, error) {
 		&mfaEnabled,
 		&mfaIssuedAt,
 		&mfaConfirmedAt,
+		&syncSecret,
+		&disabled,
+		&locale,
 	); err != nil {
 		return UserRecord{}, err
 	}
@@ -595,6 +604,13 @@ func scanUserRow(scanner rowScanner) (UserRecord, error) {
 		ts := mfaConfirmedAt.Time
 		user.MFAConfirmedAt = &ts
 	}
+	if syncSecret.Valid {
+		user.SyncSecret = syncSecret.String
+	}
+	user.Disabled = disabled.Bool
+	if locale.Valid {
+		user.Locale = locale.String
+	}
 
 	ensureUserDefaults(&user)
 
@@ -645,6 +661,12 @@ func mergeUserRecord(incoming UserRecord, existing UserRecord, merge bool, hasEx
 		if incoming.MFAConfirmedAt == nil {
 			incoming.MFAConfirmedAt = cloneTimePtr(existing.MFAConfirmedAt)
 		}
+		if incoming.SyncSecret == "" {
+			incoming.SyncSecret = existing.SyncSecret
+		}
+		if incoming.Locale == "" {
+			incoming.Locale = existing.Locale
+		}
 	}
 
 	changed := userDiffers(incoming, existing)
@@ -697,6 +719,15 @@ func userDiffers(a, b UserRecord) bool {
 	if !timePtrEqual(a.MFAConfirmedAt, b.MFAConfirmedAt) {
 		return true
 	}
+	if a.SyncSecret != b.SyncSecret {
+		return true
+	}
+	if a.Disabled != b.Disabled {
+		return true
+	}
+	if a.Locale != b.Locale {
+		return true
+	}
 	return false
 }
 
@@ -1007,11 +1038,13 @@ func upsertUser(ctx context.Context, tx *sql.Tx, user *UserRecord) error {
 INSERT INTO users (
         uuid, username, password, email, email_verified_at,
         level, role, groups, permissions, created_at, updated_at,
-        mfa_totp_secret, mfa_enabled, mfa_secret_issued_at, mfa_confirmed_at
+        mfa_totp_secret, mfa_enabled, mfa_secret_issued_at, mfa_confirmed_at,
+        sync_secret, disabled, locale
 ) VALUES (
         $1, $2, $3, $4, $5,
         $6, $7, $8::jsonb, $9::jsonb, $10, $11,
-        $12, $13, $14, $15
+        $12, $13, $14, $15,
+        $16, $17, $18
 )
 ON CONFLICT (uuid) DO UPDATE SET
         username = EXCLUDED.username,
@@ -1027,7 +1060,10 @@ ON CONFLICT (uuid) DO UPDATE SET
         mfa_totp_secret = EXCLUDED.mfa_totp_secret,
         mfa_enabled = EXCLUDED.mfa_enabled,
         mfa_secret_issued_at = EXCLUDED.mfa_secret_issued_at,
-        mfa_confirmed_at = EXCLUDED.mfa_confirmed_at
+        mfa_confirmed_at = EXCLUDED.mfa_confirmed_at,
+        sync_secret = EXCLUDED.sync_secret,
+        disabled = EXCLUDED.disabled,
+        locale = EXCLUDED.locale
 `,
 		user.UUID,
 		user.Username,
@@ -1044,6 +1080,9 @@ ON CONFLICT (uuid) DO UPDATE SET
 		user.MFAEnabled,
 		nullableTime(user.MFASecretIssuedAt),
 		nullableTime(user.MFAConfirmedAt),
+		nullableString(user.SyncSecret),
+		user.Disabled,
+		nullableString(user.Locale),
 	)
 	return err
 }
@@ -0,0 +1,429 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE for the account service. Provider
+// metadata is discovered from the issuer and ID tokens are verified against
+// the provider's published JSON Web Key Set.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"account/internal/auth"
+)
+
+// ErrInvalidIDToken is returned, wrapped with the reason, when an ID token
+// does not verify.
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// keyRefreshInterval rate limits JWKS refetches triggered by unknown key
+	// IDs so that forged tokens cannot be used to hammer the provider.
+	keyRefreshInterval = time.Minute
+	clockSkew          = time.Minute
+	maxResponseSize    = 1 << 20
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ProviderConfig describes an OpenID Connect provider registered with the
+// account service.
+type ProviderConfig struct {
+	// Name identifies the provider in URLs and in the identities table, for
+	// example "google". It must be lowercase.
+	Name string
+	// DisplayName is shown on sign in buttons. It defaults to Name.
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider. The page
+	// behind it posts the code and state back to the account service.
+	RedirectURL string
+	// Scopes defaults to openid, email and profile. The openid scope is
+	// always requested.
+	Scopes []string
+	// AuthorizationURL, TokenURL and JWKSURL override the endpoints found
+	// through discovery. Discovery is skipped when all three are set.
+	AuthorizationURL string
+	TokenURL         string
+	JWKSURL          string
+}
+
+// Validate reports configuration errors.
+func (c ProviderConfig) Validate() error {
+	if !providerNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("oidc provider name %q must be lowercase letters, digits, dashes or underscores", c.Name)
+	}
+	if _, err := url.ParseRequestURI(strings.TrimSpace(c.Issuer)); err != nil {
+		return fmt.Errorf("oidc provider %s: invalid issuer: %w", c.Name, err)
+	}
+	if strings.TrimSpace(c.ClientID) == "" {
+		return fmt.Errorf("oidc provider %s: client id is required", c.Name)
+	}
+	if _, err := url.ParseRequestURI(strings.TrimSpace(c.RedirectURL)); err != nil {
+		return fmt.Errorf("oidc provider %s: invalid redirect url: %w", c.Name, err)
+	}
+	return nil
+}
+
+// Claims holds the ID token claims used by the account service.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+// flexibleBool accepts both JSON booleans and the "true"/"false" strings some
+// providers emit for email_verified.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(strings.EqualFold(v, "true"))
+	default:
+		*b = false
+	}
+	return nil
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a configured OpenID Connect provider. Metadata and keys are
+// fetched lazily so that an unreachable provider does not prevent the
+// service from starting.
+type Provider struct {
+	cfg    ProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewProvider validates cfg and returns a provider that uses client for
+// outgoing requests. A nil client selects a client with a 10 second timeout.
+func NewProvider(cfg ProviderConfig, client *http.Client) (*Provider, error) {
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	cfg.Issuer = strings.TrimRight(strings.TrimSpace(cfg.Issuer), "/")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(cfg.DisplayName) == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	scopes := []string{"openid"}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	for _, scope := range cfg.Scopes {
+		if scope = strings.TrimSpace(scope); scope != "" && scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	cfg.Scopes = scopes
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	provider := &Provider{cfg: cfg, client: client}
+	if cfg.AuthorizationURL != "" && cfg.TokenURL != "" && cfg.JWKSURL != "" {
+		provider.metadata = &metadata{
+			Issuer:                cfg.Issuer,
+			AuthorizationEndpoint: cfg.AuthorizationURL,
+			TokenEndpoint:         cfg.TokenURL,
+			JWKSURI:               cfg.JWKSURL,
+		}
+	}
+	return provider, nil
+}
+
+// Name returns the provider name.
+func (p *Provider) Name() string { return p.cfg.Name }
+
+// DisplayName returns the human readable provider name.
+func (p *Provider) DisplayName() string { return p.cfg.DisplayName }
+
+// NewCodeVerifier returns a random PKCE code verifier.
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewNonce returns a random value suitable for the state and nonce
+// parameters.
+func NewNonce() (string, error) {
+	return randomString(24)
+}
+
+func randomString(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// CodeChallengeS256 derives the S256 PKCE code challenge of verifier.
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL the browser is sent to in order to sign in at
+// the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc provider %s: invalid authorization endpoint: %w", p.cfg.Name, err)
+	}
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &token)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || token.Error != "" {
+		if token.Error == "" {
+			token.Error = http.StatusText(status)
+		}
+		return "", fmt.Errorf("oidc provider %s: token exchange failed: %s %s", p.cfg.Name, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("oidc provider %s: token response did not include an id token", p.cfg.Name)
+	}
+	return token.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce of
+// an ID token and returns its claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, parsed, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, meta.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if parsed.Nonce == "" || parsed.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if parsed.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &Claims{
+		Subject:           parsed.Subject,
+		Email:             strings.TrimSpace(parsed.Email),
+		EmailVerified:     bool(parsed.EmailVerified),
+		Name:              strings.TrimSpace(parsed.Name),
+		PreferredUsername: strings.TrimSpace(parsed.PreferredUsername),
+	}, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	status, err := p.doJSON(req, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc provider %s: discovery returned %d", p.cfg.Name, status)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc provider %s: discovered issuer %q does not match %q", p.cfg.Name, meta.Issuer, p.cfg.Issuer)
+	}
+	if p.cfg.AuthorizationURL != "" {
+		meta.AuthorizationEndpoint = p.cfg.AuthorizationURL
+	}
+	if p.cfg.TokenURL != "" {
+		meta.TokenEndpoint = p.cfg.TokenURL
+	}
+	if p.cfg.JWKSURL != "" {
+		meta.JWKSURI = p.cfg.JWKSURL
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc provider %s: discovery document is missing endpoints", p.cfg.Name)
+	}
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+func (p *Provider) verificationKey(ctx context.Context, jwksURL, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if !p.keysFetchedAt.IsZero() && time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set auth.JWKS
+	status, err := p.doJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %d", status)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped rather than failing the set.
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds the key with the given ID. Tokens without a key ID are
+// accepted only when the provider publishes a single key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) doJSON(req *http.Request, target any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("oidc provider %s: %w", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("oidc provider %s: %w", p.cfg.Name, err)
+	}
+	if err := json.Unmarshal(body, target); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc provider %s: invalid response from %s: %w", p.cfg.Name, req.URL.Path, err)
+	}
+	return resp.StatusCode, nil
+}
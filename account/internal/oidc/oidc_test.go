@@ -0,0 +1,98 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"account/internal/oidc"
+	"account/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T, secret string) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	idp, err := oidctest.New("account", secret)
+	if err != nil {
+		t.Fatalf("failed to start identity provider: %v", err)
+	}
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "example",
+		Issuer:       idp.Issuer(),
+		ClientID:     "account",
+		ClientSecret: secret,
+		RedirectURL:  "https://accounts.example.com/oidc/callback",
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to configure provider: %v", err)
+	}
+	return idp, provider
+}
+
+// signIn runs the authorization code flow and returns the verified claims.
+func signIn(t *testing.T, idp *oidctest.Provider, provider *oidc.Provider, verifierOverride string) (*oidc.Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	verifier, _ := oidc.NewCodeVerifier()
+	nonce, _ := oidc.NewNonce()
+	authURL, err := provider.AuthCodeURL(ctx, "state-value", nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatalf("failed to build authorization url: %v", err)
+	}
+	code, state, err := idp.Authorize(authURL, oidctest.Identity{Subject: "subject-1", Email: "alice@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+	if state != "state-value" {
+		t.Fatalf("expected state to round trip, got %q", state)
+	}
+	if verifierOverride != "" {
+		verifier = verifierOverride
+	}
+	rawIDToken, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return provider.VerifyIDToken(ctx, rawIDToken, nonce)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	for _, secret := range []string{"", "s3cret"} {
+		idp, provider := newTestProvider(t, secret)
+		claims, err := signIn(t, idp, provider, "")
+		if err != nil {
+			t.Fatalf("expected sign in to succeed with secret %q: %v", secret, err)
+		}
+		if claims.Subject != "subject-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+			t.Fatalf("unexpected claims: %+v", claims)
+		}
+	}
+}
+
+func TestAuthorizationCodeFlowRejections(t *testing.T) {
+	idp, provider := newTestProvider(t, "")
+	if _, err := signIn(t, idp, provider, "wrong-verifier"); err == nil {
+		t.Fatal("expected token exchange with the wrong code verifier to fail")
+	}
+
+	idp.Nonce = "replayed"
+	if _, err := signIn(t, idp, provider, ""); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected nonce mismatch to be rejected, got %v", err)
+	}
+
+	idp.Nonce = ""
+	idp.Audience = "another-client"
+	if _, err := signIn(t, idp, provider, ""); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected foreign audience to be rejected, got %v", err)
+	}
+}
+
+func TestNewProviderValidatesConfig(t *testing.T) {
+	if _, err := oidc.NewProvider(oidc.ProviderConfig{Name: "Bad Name", Issuer: "https://idp.example.com", ClientID: "x", RedirectURL: "https://a.example.com/cb"}, nil); err == nil {
+		t.Fatal("expected invalid provider name to be rejected")
+	}
+	if _, err := oidc.NewProvider(oidc.ProviderConfig{Name: "idp", Issuer: "https://idp.example.com", RedirectURL: "https://a.example.com/cb"}, nil); err == nil {
+		t.Fatal("expected missing client id to be rejected")
+	}
+}
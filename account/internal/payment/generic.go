@@ -0,0 +1,144 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"account/internal/store"
+)
+
+// Headers of the generic webhook format.
+const (
+	GenericTimestampHeader = "X-Webhook-Timestamp"
+	GenericSignatureHeader = "X-Webhook-Signature"
+)
+
+type genericAdapter struct {
+	cfg Config
+}
+
+// NewGenericAdapter handles the provider-neutral webhook format used by
+// gateways without a dedicated adapter. Requests carry the unix time in
+// X-Webhook-Timestamp and "sha256=" followed by the hex HMAC-SHA256 of
+// "timestamp.body" in X-Webhook-Signature.
+//
+// The body is a JSON object:
+//
+//	{"id": "evt-1", "type": "subscription.updated", "occurredAt": "2026-01-02T15:04:05Z",
+//	 "subscription": {"userId": "…", "externalId": "…", "planId": "…", "status": "active",
+//	                  "paymentQr": "…", "currentPeriodStart": "…", "currentPeriodEnd": "…", "meta": {}}}
+//
+// subscription.created and subscription.updated upsert the subscription,
+// subscription.cancelled cancels it and other types are ignored.
+func NewGenericAdapter(cfg Config) (Adapter, error) {
+	cfg, err := cfg.normalize()
+	if err != nil {
+		return nil, err
+	}
+	return &genericAdapter{cfg: cfg}, nil
+}
+
+func (a *genericAdapter) Name() string { return a.cfg.Name }
+
+func (a *genericAdapter) Verify(header http.Header, body []byte) error {
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(header.Get(GenericTimestampHeader)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing %s header", ErrInvalidSignature, GenericTimestampHeader)
+	}
+	if err := checkTimestamp(timestamp, a.cfg.Now(), a.cfg.Tolerance); err != nil {
+		return err
+	}
+	signature, ok := strings.CutPrefix(strings.TrimSpace(header.Get(GenericSignatureHeader)), "sha256=")
+	if !ok || !validSignature(sign(a.cfg.Secret, timestamp, body), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type genericEvent struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	OccurredAt   time.Time `json:"occurredAt"`
+	Subscription struct {
+		UserID             string         `json:"userId"`
+		ExternalID         string         `json:"externalId"`
+		PlanID             string         `json:"planId"`
+		Status             string         `json:"status"`
+		PaymentQRCode      string         `json:"paymentQr"`
+		CurrentPeriodStart *time.Time     `json:"currentPeriodStart"`
+		CurrentPeriodEnd   *time.Time     `json:"currentPeriodEnd"`
+		Meta               map[string]any `json:"meta"`
+	} `json:"subscription"`
+}
+
+func (a *genericAdapter) Parse(body []byte) (*Event, error) {
+	var payload genericEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if strings.TrimSpace(payload.ID) == "" {
+		return nil, fmt.Errorf("%w: missing event id", ErrInvalidPayload)
+	}
+
+	occurredAt := payload.OccurredAt.UTC()
+	if payload.OccurredAt.IsZero() {
+		occurredAt = a.cfg.Now().UTC()
+	}
+	event := &Event{ID: payload.ID, Type: payload.Type, Action: ActionIgnore, OccurredAt: occurredAt}
+
+	sub := payload.Subscription
+	event.Subscription = SubscriptionUpdate{
+		UserID:             strings.TrimSpace(sub.UserID),
+		ExternalID:         strings.TrimSpace(sub.ExternalID),
+		PlanID:             strings.TrimSpace(sub.PlanID),
+		PaymentQRCode:      strings.TrimSpace(sub.PaymentQRCode),
+		CurrentPeriodStart: utcTime(sub.CurrentPeriodStart),
+		CurrentPeriodEnd:   utcTime(sub.CurrentPeriodEnd),
+		Meta:               sub.Meta,
+	}
+
+	switch payload.Type {
+	case "subscription.created", "subscription.updated":
+	case "subscription.cancelled", "subscription.canceled":
+		event.Action = ActionCancel
+		event.Subscription.Status = store.SubscriptionStatusCancelled
+	default:
+		return event, nil
+	}
+	if event.Subscription.ExternalID == "" {
+		return nil, fmt.Errorf("%w: missing subscription externalId", ErrInvalidPayload)
+	}
+	if event.Action == ActionCancel {
+		return event, nil
+	}
+
+	status, ok := store.NormalizeSubscriptionStatus(sub.Status)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown subscription status %q", ErrInvalidPayload, sub.Status)
+	}
+	event.Subscription.Status = status
+	event.Action = ActionUpsert
+	if status == store.SubscriptionStatusCancelled {
+		event.Action = ActionCancel
+	}
+	return event, nil
+}
+
+// SignGenericPayload returns the X-Webhook-Timestamp and X-Webhook-Signature
+// header values for body. Senders of the generic format can use it as the
+// reference implementation.
+func SignGenericPayload(secret string, at time.Time, body []byte) (timestamp, signature string) {
+	return strconv.FormatInt(at.Unix(), 10), "sha256=" + sign(secret, at.Unix(), body)
+}
+
+func utcTime(value *time.Time) *time.Time {
+	if value == nil || value.IsZero() {
+		return nil
+	}
+	at := value.UTC()
+	return &at
+}
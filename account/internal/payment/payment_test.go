@@ -0,0 +1,148 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+const testSecret = "whsec_test"
+
+func loadPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return body
+}
+
+func fixedClock(at time.Time) func() time.Time {
+	return func() time.Time { return at }
+}
+
+func TestStripeVerify(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	adapter, err := New(KindStripe, Config{Name: "stripe", Secret: testSecret, Now: fixedClock(now)})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	body := loadPayload(t, "stripe_subscription_updated.json")
+
+	header := http.Header{}
+	header.Set(StripeSignatureHeader, "t=1767225600,v1=deadbeef,v1="+sign(testSecret, now.Unix(), body))
+	if err := adapter.Verify(header, body); err != nil {
+		t.Fatalf("expected any matching v1 signature to verify, got %v", err)
+	}
+
+	cases := map[string]string{
+		"missing":      "",
+		"wrong secret": SignStripePayload("whsec_other", now, body),
+		"stale":        SignStripePayload(testSecret, now.Add(-10*time.Minute), body),
+	}
+	for name, value := range cases {
+		header.Set(StripeSignatureHeader, value)
+		if err := adapter.Verify(header, body); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected invalid signature, got %v", name, err)
+		}
+	}
+
+	header.Set(StripeSignatureHeader, SignStripePayload(testSecret, now, body))
+	if err := adapter.Verify(header, append([]byte(" "), body...)); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered body to be rejected, got %v", err)
+	}
+}
+
+func TestStripeParseRecordedEvents(t *testing.T) {
+	adapter, err := NewStripeAdapter(Config{Name: "stripe", Secret: testSecret})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+
+	updated, err := adapter.Parse(loadPayload(t, "stripe_subscription_updated.json"))
+	if err != nil {
+		t.Fatalf("parse updated: %v", err)
+	}
+	sub := updated.Subscription
+	if updated.ID != "evt_1Q2w3E4r5T6y7U8i" || updated.Action != ActionUpsert || sub.Status != store.SubscriptionStatusActive {
+		t.Fatalf("unexpected event %+v", updated)
+	}
+	if sub.UserID != "USER_ID" || sub.ExternalID != "sub_1Q2w3E4r5T6y7U8i" || sub.PlanID != "MONTHLY" {
+		t.Fatalf("unexpected subscription update %+v", sub)
+	}
+	if sub.CurrentPeriodEnd == nil || sub.CurrentPeriodEnd.Unix() != 1769904000 || sub.Meta["customer"] != "cus_R2d2C3po4Bb8" {
+		t.Fatalf("unexpected period or meta %+v", sub)
+	}
+
+	deleted, err := adapter.Parse(loadPayload(t, "stripe_subscription_deleted.json"))
+	if err != nil {
+		t.Fatalf("parse deleted: %v", err)
+	}
+	if deleted.Action != ActionCancel || deleted.Subscription.UserID != "" || deleted.Subscription.CurrentPeriodEnd == nil {
+		t.Fatalf("expected cancellation with the item period, got %+v", deleted)
+	}
+
+	invoice, err := adapter.Parse(loadPayload(t, "stripe_invoice_paid.json"))
+	if err != nil || invoice.Action != ActionIgnore {
+		t.Fatalf("expected invoice events to be ignored, got %+v (%v)", invoice, err)
+	}
+
+	if _, err := adapter.Parse([]byte(`{"type":"customer.subscription.updated"}`)); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected event without id to be rejected, got %v", err)
+	}
+}
+
+func TestGenericAdapter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	adapter, err := New(KindGeneric, Config{Name: "alipay", Secret: testSecret, Now: fixedClock(now)})
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	body := loadPayload(t, "generic_subscription_updated.json")
+
+	timestamp, signature := SignGenericPayload(testSecret, now, body)
+	header := http.Header{}
+	header.Set(GenericTimestampHeader, timestamp)
+	header.Set(GenericSignatureHeader, signature)
+	if err := adapter.Verify(header, body); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	header.Set(GenericSignatureHeader, signature[:len(signature)-1]+"0")
+	if err := adapter.Verify(header, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected bad signature to be rejected, got %v", err)
+	}
+
+	event, err := adapter.Parse(body)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sub := event.Subscription
+	if event.Action != ActionUpsert || sub.Status != store.SubscriptionStatusActive || sub.ExternalID != "order-8842" || sub.PaymentQRCode == "" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if sub.CurrentPeriodEnd == nil || !sub.CurrentPeriodEnd.Equal(time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected period end %v", sub.CurrentPeriodEnd)
+	}
+
+	cancelled, err := adapter.Parse([]byte(`{"id":"pay-2","type":"subscription.canceled","subscription":{"externalId":"order-8842"}}`))
+	if err != nil || cancelled.Action != ActionCancel || !cancelled.OccurredAt.Equal(now) {
+		t.Fatalf("expected cancellation, got %+v (%v)", cancelled, err)
+	}
+	if _, err := adapter.Parse([]byte(`{"id":"pay-3","type":"subscription.updated","subscription":{"externalId":"x","status":"paused"}}`)); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected unknown status to be rejected, got %v", err)
+	}
+}
+
+func TestNewRejectsUnknownKindAndMissingSecret(t *testing.T) {
+	if _, err := New("paypal", Config{Name: "paypal", Secret: testSecret}); err == nil {
+		t.Fatal("expected unknown kind to be rejected")
+	}
+	if _, err := New(KindGeneric, Config{Name: "generic"}); err == nil {
+		t.Fatal("expected missing secret to be rejected")
+	}
+}
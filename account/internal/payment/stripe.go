@@ -0,0 +1,178 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"account/internal/store"
+)
+
+// StripeSignatureHeader carries the Stripe webhook signature.
+const StripeSignatureHeader = "Stripe-Signature"
+
+// stripeStatuses maps Stripe subscription statuses onto the lifecycle.
+// Incomplete and paused subscriptions are not reflected.
+var stripeStatuses = map[string]string{
+	"trialing":           store.SubscriptionStatusTrialing,
+	"active":             store.SubscriptionStatusActive,
+	"past_due":           store.SubscriptionStatusPastDue,
+	"unpaid":             store.SubscriptionStatusPastDue,
+	"canceled":           store.SubscriptionStatusCancelled,
+	"incomplete_expired": store.SubscriptionStatusExpired,
+}
+
+type stripeAdapter struct {
+	cfg Config
+}
+
+// NewStripeAdapter handles webhooks signed with a Stripe endpoint secret and
+// maps customer.subscription.* events. The owning account is read from the
+// user_id metadata set at checkout.
+func NewStripeAdapter(cfg Config) (Adapter, error) {
+	cfg, err := cfg.normalize()
+	if err != nil {
+		return nil, err
+	}
+	return &stripeAdapter{cfg: cfg}, nil
+}
+
+func (a *stripeAdapter) Name() string { return a.cfg.Name }
+
+// Verify checks the t= timestamp and any of the v1= signatures of the
+// Stripe-Signature header.
+func (a *stripeAdapter) Verify(header http.Header, body []byte) error {
+	var (
+		timestamp  int64
+		signatures []string
+	)
+	for _, part := range strings.Split(header.Get(StripeSignatureHeader), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+			}
+			timestamp = parsed
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("%w: missing %s header", ErrInvalidSignature, StripeSignatureHeader)
+	}
+	if err := checkTimestamp(timestamp, a.cfg.Now(), a.cfg.Tolerance); err != nil {
+		return err
+	}
+	expected := sign(a.cfg.Secret, timestamp, body)
+	for _, signature := range signatures {
+		if validSignature(expected, signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+type stripePeriod struct {
+	CurrentPeriodStart int64 `json:"current_period_start"`
+	CurrentPeriodEnd   int64 `json:"current_period_end"`
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object struct {
+			ID     string `json:"id"`
+			Object string `json:"object"`
+			stripePeriod
+			Status            string            `json:"status"`
+			Customer          string            `json:"customer"`
+			CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+			Metadata          map[string]string `json:"metadata"`
+			Items             struct {
+				Data []struct {
+					stripePeriod
+					Price struct {
+						ID        string `json:"id"`
+						LookupKey string `json:"lookup_key"`
+					} `json:"price"`
+				} `json:"data"`
+			} `json:"items"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+func (a *stripeAdapter) Parse(body []byte) (*Event, error) {
+	var payload stripeEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if strings.TrimSpace(payload.ID) == "" {
+		return nil, fmt.Errorf("%w: missing event id", ErrInvalidPayload)
+	}
+
+	event := &Event{ID: payload.ID, Type: payload.Type, Action: ActionIgnore, OccurredAt: time.Unix(payload.Created, 0).UTC()}
+	if !strings.HasPrefix(payload.Type, "customer.subscription.") || payload.Data.Object.Object != "subscription" {
+		return event, nil
+	}
+
+	object := payload.Data.Object
+	period := object.stripePeriod
+	planID := strings.TrimSpace(object.Metadata["plan_id"])
+	if len(object.Items.Data) > 0 {
+		item := object.Items.Data[0]
+		// Newer API versions only report the period on subscription items.
+		if period.CurrentPeriodEnd == 0 {
+			period = item.stripePeriod
+		}
+		if planID == "" {
+			planID = firstNonEmpty(item.Price.LookupKey, item.Price.ID)
+		}
+	}
+	event.Subscription = SubscriptionUpdate{
+		UserID:             firstNonEmpty(object.Metadata["user_id"], object.Metadata["userId"]),
+		ExternalID:         object.ID,
+		PlanID:             planID,
+		CurrentPeriodStart: unixTime(period.CurrentPeriodStart),
+		CurrentPeriodEnd:   unixTime(period.CurrentPeriodEnd),
+		Meta: map[string]any{
+			"customer":          object.Customer,
+			"cancelAtPeriodEnd": object.CancelAtPeriodEnd,
+		},
+	}
+
+	status, known := stripeStatuses[object.Status]
+	switch {
+	case payload.Type == "customer.subscription.deleted" || status == store.SubscriptionStatusCancelled:
+		event.Action = ActionCancel
+		event.Subscription.Status = store.SubscriptionStatusCancelled
+	case known:
+		event.Action = ActionUpsert
+		event.Subscription.Status = status
+	}
+	return event, nil
+}
+
+// SignStripePayload returns the Stripe-Signature header value for body, as
+// sent by Stripe. It is intended for tests and local tooling.
+func SignStripePayload(secret string, at time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), sign(secret, at.Unix(), body))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			return trimmed
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepThreshold bounds how many entries accumulate before expired state is
+// swept, so keys that are never seen again do not leak.
+const sweepThreshold = 10000
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+type failures struct {
+	count       int
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// memoryLimiter keeps buckets and failure counters in process.
+type memoryLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	buckets  map[string]bucket
+	failures map[string]failures
+}
+
+// NewMemory creates an in-process Limiter. Expired state is evicted lazily on
+// access.
+func NewMemory() Limiter {
+	return &memoryLimiter{
+		now:      time.Now,
+		buckets:  make(map[string]bucket),
+		failures: make(map[string]failures),
+	}
+}
+
+// Allow implements Limiter.
+func (l *memoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	_ = ctx
+	if !limit.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	interval := limit.interval()
+	capacity := float64(limit.Burst)
+
+	if len(l.buckets) >= sweepThreshold {
+		for k, existing := range l.buckets {
+			if !now.Before(existing.expiresAt) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok || !now.Before(b.expiresAt) {
+		b = bucket{tokens: capacity, updatedAt: now}
+	} else if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(interval))
+		b.updatedAt = now
+	}
+
+	decision := Decision{Allowed: b.tokens >= 1}
+	if decision.Allowed {
+		b.tokens--
+	} else {
+		decision.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) * float64(interval)))
+	}
+	b.expiresAt = now.Add(limit.Period)
+	l.buckets[key] = b
+	return decision, nil
+}
+
+// RegisterFailure implements Limiter.
+func (l *memoryLimiter) RegisterFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	_ = ctx
+	if !policy.Enabled() {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.failures) >= sweepThreshold {
+		for k, existing := range l.failures {
+			if !now.Before(existing.expiresAt) {
+				delete(l.failures, k)
+			}
+		}
+	}
+
+	f, ok := l.failures[key]
+	if !ok || !now.Before(f.expiresAt) {
+		f = failures{}
+	}
+	f.count++
+	f.expiresAt = now.Add(policy.window())
+	locked := policy.lockDuration(f.count)
+	if locked > 0 {
+		f.lockedUntil = now.Add(locked)
+	}
+	l.failures[key] = f
+	return locked, nil
+}
+
+// LockedFor implements Limiter.
+func (l *memoryLimiter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	_ = ctx
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	f, ok := l.failures[key]
+	if !ok {
+		return 0, nil
+	}
+	if !now.Before(f.expiresAt) {
+		delete(l.failures, key)
+		return 0, nil
+	}
+	if remaining := f.lockedUntil.Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Reset implements Limiter.
+func (l *memoryLimiter) Reset(ctx context.Context, key string) error {
+	_ = ctx
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+	return nil
+}
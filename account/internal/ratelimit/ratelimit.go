@@ -0,0 +1,100 @@
+// Package ratelimit provides token bucket rate limiting and progressive
+// lockout for the account service's public authentication endpoints. The
+// memory backend suits single replica deployments; the Redis backend shares
+// limits between replicas.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket. Burst requests may be made at once and the
+// bucket refills completely over Period.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// interval returns the time it takes to refill a single token.
+func (l Limit) interval() time.Duration {
+	interval := l.Period / time.Duration(l.Burst)
+	if interval <= 0 {
+		interval = time.Nanosecond
+	}
+	return interval
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed bool
+	// RetryAfter is how long the caller has to wait for the next token when
+	// the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// LockoutPolicy describes progressive lockout after repeated failures. Once
+// Threshold failures have been recorded within Window the key is locked for
+// Duration; every further failure doubles the lockout up to MaxDuration.
+type LockoutPolicy struct {
+	Threshold   int
+	Duration    time.Duration
+	MaxDuration time.Duration
+	Window      time.Duration
+}
+
+// Enabled reports whether the policy ever locks a key.
+func (p LockoutPolicy) Enabled() bool {
+	return p.Threshold > 0 && p.Duration > 0
+}
+
+// lockDuration returns the lockout that applies after failures consecutive
+// failures, or zero when the threshold has not been reached.
+func (p LockoutPolicy) lockDuration(failures int) time.Duration {
+	if !p.Enabled() || failures < p.Threshold {
+		return 0
+	}
+	duration := p.Duration
+	for i := p.Threshold; i < failures; i++ {
+		duration *= 2
+		if p.MaxDuration > 0 && duration >= p.MaxDuration {
+			return p.MaxDuration
+		}
+	}
+	if p.MaxDuration > 0 && duration > p.MaxDuration {
+		return p.MaxDuration
+	}
+	return duration
+}
+
+// window returns how long failures are remembered. It always outlives the
+// longest lockout so the progression survives the lock expiring.
+func (p LockoutPolicy) window() time.Duration {
+	window := p.Window
+	if p.MaxDuration > window {
+		window = p.MaxDuration
+	}
+	if p.Duration > window {
+		window = p.Duration
+	}
+	return window
+}
+
+// Limiter enforces token bucket limits and failure lockouts. Keys are opaque
+// to the limiter; callers namespace them per endpoint and subject.
+type Limiter interface {
+	// Allow takes a token from the bucket identified by key.
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+	// RegisterFailure records a failed attempt for key and returns the
+	// lockout it triggered, or zero when key is not locked.
+	RegisterFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error)
+	// LockedFor returns the remaining lockout for key.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset clears the failures and lockout recorded for key.
+	Reset(ctx context.Context, key string) error
+}
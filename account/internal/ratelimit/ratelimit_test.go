@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestLimiters returns both backends driven by a shared fake clock and a
+// function that advances it.
+func newTestLimiters(t *testing.T) (map[string]Limiter, func(time.Duration)) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	memory := NewMemory().(*memoryLimiter)
+	memory.now = clock
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	redisLimiter := NewRedis(client, "").(*redisLimiter)
+	redisLimiter.now = clock
+
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		// Redis expires keys on its own clock; keep it in step.
+		server.FastForward(d)
+	}
+	return map[string]Limiter{"memory": memory, "redis": redisLimiter}, advance
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	limiters, advance := newTestLimiters(t)
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limit := Limit{Burst: 3, Period: 3 * time.Second}
+
+			for i := 0; i < 3; i++ {
+				decision, err := limiter.Allow(ctx, "login:ip:"+name, limit)
+				if err != nil || !decision.Allowed {
+					t.Fatalf("expected request %d to be allowed, got %+v, %v", i, decision, err)
+				}
+			}
+			decision, err := limiter.Allow(ctx, "login:ip:"+name, limit)
+			if err != nil || decision.Allowed {
+				t.Fatalf("expected burst to be exhausted, got %+v, %v", decision, err)
+			}
+			if decision.RetryAfter <= 0 || decision.RetryAfter > time.Second {
+				t.Fatalf("expected retry within one refill interval, got %s", decision.RetryAfter)
+			}
+
+			advance(time.Second)
+			if decision, err := limiter.Allow(ctx, "login:ip:"+name, limit); err != nil || !decision.Allowed {
+				t.Fatalf("expected refilled token to be allowed, got %+v, %v", decision, err)
+			}
+			if decision, err := limiter.Allow(ctx, "other:"+name, limit); err != nil || !decision.Allowed {
+				t.Fatalf("expected independent key to be allowed, got %+v, %v", decision, err)
+			}
+		})
+	}
+}
+
+func TestLimiterProgressiveLockout(t *testing.T) {
+	limiters, advance := newTestLimiters(t)
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			policy := LockoutPolicy{Threshold: 3, Duration: time.Minute, MaxDuration: 3 * time.Minute, Window: time.Hour}
+			key := "user:" + name
+
+			for i := 0; i < 2; i++ {
+				locked, err := limiter.RegisterFailure(ctx, key, policy)
+				if err != nil || locked != 0 {
+					t.Fatalf("expected no lockout below threshold, got %s, %v", locked, err)
+				}
+			}
+			expected := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+			for _, want := range expected {
+				locked, err := limiter.RegisterFailure(ctx, key, policy)
+				if err != nil || locked != want {
+					t.Fatalf("expected lockout of %s, got %s, %v", want, locked, err)
+				}
+			}
+
+			remaining, err := limiter.LockedFor(ctx, key)
+			if err != nil || remaining <= 2*time.Minute {
+				t.Fatalf("expected active lockout, got %s, %v", remaining, err)
+			}
+
+			advance(3 * time.Minute)
+			if remaining, err := limiter.LockedFor(ctx, key); err != nil || remaining != 0 {
+				t.Fatalf("expected lockout to expire, got %s, %v", remaining, err)
+			}
+
+			if err := limiter.Reset(ctx, key); err != nil {
+				t.Fatalf("reset: %v", err)
+			}
+			if locked, err := limiter.RegisterFailure(ctx, key, policy); err != nil || locked != 0 {
+				t.Fatalf("expected reset to clear failures, got %s, %v", locked, err)
+			}
+		})
+	}
+}
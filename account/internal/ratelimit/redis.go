@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix namespaces keys written by the limiter.
+const DefaultRedisPrefix = "xcontrol:account:ratelimit:"
+
+// takeTokenScript refills and takes from a token bucket atomically. The
+// caller supplies the clock so the script stays deterministic.
+//
+// KEYS[1] bucket, ARGV[1] capacity, ARGV[2] refill interval per token (ms),
+// ARGV[3] now (ms). Returns {allowed, retry after (ms)}.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) / interval)
+  ts = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) * interval)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * interval))
+return {allowed, retry}
+`)
+
+// registerFailureScript increments a failure counter and refreshes its TTL.
+var registerFailureScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return count
+`)
+
+// redisLimiter stores buckets and failure counters in Redis so that limits
+// hold across replicas.
+type redisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+	now    func() time.Time
+}
+
+// NewRedis wraps an existing Redis client. An empty prefix falls back to
+// DefaultRedisPrefix.
+func NewRedis(client redis.UniversalClient, prefix string) Limiter {
+	if strings.TrimSpace(prefix) == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &redisLimiter{client: client, prefix: prefix, now: time.Now}
+}
+
+func (l *redisLimiter) bucketKey(key string) string {
+	return l.prefix + "bucket:" + key
+}
+
+func (l *redisLimiter) failuresKey(key string) string {
+	return l.prefix + "failures:" + key
+}
+
+func (l *redisLimiter) lockKey(key string) string {
+	return l.prefix + "lock:" + key
+}
+
+// Allow implements Limiter.
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	if !limit.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	interval := limit.interval().Milliseconds()
+	if interval <= 0 {
+		interval = 1
+	}
+	result, err := takeTokenScript.Run(ctx, l.client, []string{l.bucketKey(key)}, limit.Burst, interval, l.now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{
+		Allowed:    result[0] == 1,
+		RetryAfter: time.Duration(result[1]) * time.Millisecond,
+	}, nil
+}
+
+// RegisterFailure implements Limiter.
+func (l *redisLimiter) RegisterFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	if !policy.Enabled() {
+		return 0, nil
+	}
+
+	count, err := registerFailureScript.Run(ctx, l.client, []string{l.failuresKey(key)}, policy.window().Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	locked := policy.lockDuration(count)
+	if locked > 0 {
+		if err := l.client.Set(ctx, l.lockKey(key), count, locked).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return locked, nil
+}
+
+// LockedFor implements Limiter.
+func (l *redisLimiter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := l.client.PTTL(ctx, l.lockKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		// Missing keys report -2 and keys without expiry -1; neither is an
+		// active lockout.
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Reset implements Limiter.
+func (l *redisLimiter) Reset(ctx context.Context, key string) error {
+	return l.client.Del(ctx, l.failuresKey(key), l.lockKey(key)).Err()
+}
@@ -0,0 +1,381 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Agent is a node agent enrolled at runtime. Only the SHA-256 hash of its
+// bearer token is persisted.
+type Agent struct {
+	ID        string
+	Name      string
+	Groups    []string
+	TokenHash string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// Revoked reports whether the agent's credential has been revoked.
+func (a *Agent) Revoked() bool {
+	return a.RevokedAt != nil
+}
+
+// AgentEnrollment is a one-time bootstrap code that a new node exchanges for
+// its long-lived agent credential. Only the hash of the code is persisted.
+type AgentEnrollment struct {
+	ID        string
+	CodeHash  string
+	AgentID   string
+	Name      string
+	Groups    []string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// AgentStatus is one status report received from an agent. Report holds the
+// JSON encoded report.
+type AgentStatus struct {
+	ID         string
+	AgentID    string
+	Healthy    bool
+	Report     []byte
+	ReportedAt time.Time
+}
+
+var (
+	ErrAgentExists   = errors.New("agent already exists")
+	ErrAgentNotFound = errors.New("agent not found")
+	ErrAgentRevoked  = errors.New("agent credential revoked")
+	// ErrAgentEnrollmentInvalid is returned for unknown, used or expired
+	// enrollment codes alike.
+	ErrAgentEnrollmentInvalid = errors.New("agent enrollment code is invalid")
+)
+
+func validateAgent(agent *Agent) error {
+	if agent == nil {
+		return errors.New("agent is required")
+	}
+	agent.ID = strings.TrimSpace(agent.ID)
+	if agent.ID == "" {
+		agent.ID = uuid.NewString()
+	}
+	agent.Name = strings.TrimSpace(agent.Name)
+	agent.Groups = normalizeStringSlice(agent.Groups)
+	agent.TokenHash = strings.TrimSpace(agent.TokenHash)
+	if agent.TokenHash == "" {
+		return errors.New("agent token hash is required")
+	}
+	return nil
+}
+
+func validateAgentEnrollment(enrollment *AgentEnrollment) error {
+	if enrollment == nil {
+		return errors.New("agent enrollment is required")
+	}
+	enrollment.CodeHash = strings.TrimSpace(enrollment.CodeHash)
+	if enrollment.CodeHash == "" {
+		return errors.New("agent enrollment code hash is required")
+	}
+	if enrollment.ExpiresAt.IsZero() {
+		return errors.New("agent enrollment expiry is required")
+	}
+	enrollment.AgentID = strings.TrimSpace(enrollment.AgentID)
+	enrollment.Name = strings.TrimSpace(enrollment.Name)
+	enrollment.Groups = normalizeStringSlice(enrollment.Groups)
+	enrollment.CreatedBy = strings.TrimSpace(enrollment.CreatedBy)
+	enrollment.ExpiresAt = enrollment.ExpiresAt.UTC()
+	return nil
+}
+
+func cloneAgent(agent *Agent) Agent {
+	clone := *agent
+	clone.Groups = cloneStringSlice(agent.Groups)
+	clone.RevokedAt = cloneTimePointer(agent.RevokedAt)
+	return clone
+}
+
+func cloneAgentEnrollment(enrollment *AgentEnrollment) AgentEnrollment {
+	clone := *enrollment
+	clone.Groups = cloneStringSlice(enrollment.Groups)
+	clone.UsedAt = cloneTimePointer(enrollment.UsedAt)
+	return clone
+}
+
+// CreateAgent persists a new agent.
+func (s *memoryStore) CreateAgent(ctx context.Context, agent *Agent) error {
+	_ = ctx
+	if err := validateAgent(agent); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createAgentLocked(agent)
+}
+
+func (s *memoryStore) createAgentLocked(agent *Agent) error {
+	if _, exists := s.agents[agent.ID]; exists {
+		return ErrAgentExists
+	}
+	now := time.Now().UTC()
+	agent.CreatedAt = now
+	agent.UpdatedAt = now
+	agent.RevokedAt = nil
+	stored := cloneAgent(agent)
+	s.agents[agent.ID] = &stored
+	return nil
+}
+
+// GetAgent returns the agent with the given ID.
+func (s *memoryStore) GetAgent(ctx context.Context, id string) (*Agent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agent, ok := s.agents[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrAgentNotFound
+	}
+	clone := cloneAgent(agent)
+	return &clone, nil
+}
+
+// GetAgentByTokenHash returns the agent whose current token has the given
+// hash, including revoked agents.
+func (s *memoryStore) GetAgentByTokenHash(ctx context.Context, tokenHash string) (*Agent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	normalized := strings.TrimSpace(tokenHash)
+	for _, agent := range s.agents {
+		if agent.TokenHash == normalized {
+			clone := cloneAgent(agent)
+			return &clone, nil
+		}
+	}
+	return nil, ErrAgentNotFound
+}
+
+// ListAgents returns all agents, including revoked ones, ordered by ID.
+func (s *memoryStore) ListAgents(ctx context.Context) ([]Agent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agents := make([]Agent, 0, len(s.agents))
+	for _, agent := range s.agents {
+		agents = append(agents, cloneAgent(agent))
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents, nil
+}
+
+// UpdateAgentToken replaces the token hash of an active agent.
+func (s *memoryStore) UpdateAgentToken(ctx context.Context, id, tokenHash string) error {
+	_ = ctx
+	tokenHash = strings.TrimSpace(tokenHash)
+	if tokenHash == "" {
+		return errors.New("agent token hash is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.agents[strings.TrimSpace(id)]
+	if !ok {
+		return ErrAgentNotFound
+	}
+	if agent.Revoked() {
+		return ErrAgentRevoked
+	}
+	agent.TokenHash = tokenHash
+	agent.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// RevokeAgent marks the agent's credential as revoked. Revoking an already
+// revoked agent keeps the original revocation time.
+func (s *memoryStore) RevokeAgent(ctx context.Context, id string, revokedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.agents[strings.TrimSpace(id)]
+	if !ok {
+		return ErrAgentNotFound
+	}
+	if agent.Revoked() {
+		return nil
+	}
+	at := revokedAt.UTC()
+	agent.RevokedAt = &at
+	agent.UpdatedAt = at
+	return nil
+}
+
+// CreateAgentEnrollment persists a new enrollment code.
+func (s *memoryStore) CreateAgentEnrollment(ctx context.Context, enrollment *AgentEnrollment) error {
+	_ = ctx
+	if err := validateAgentEnrollment(enrollment); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment.ID = uuid.NewString()
+	enrollment.CreatedAt = time.Now().UTC()
+	enrollment.UsedAt = nil
+	stored := cloneAgentEnrollment(enrollment)
+	s.agentEnrollments[enrollment.CodeHash] = &stored
+	return nil
+}
+
+// RedeemAgentEnrollment consumes the enrollment code with the given hash and
+// creates agent from it. The agent's ID, name and groups default to those of
+// the enrollment. Unknown, used and expired codes yield
+// ErrAgentEnrollmentInvalid.
+func (s *memoryStore) RedeemAgentEnrollment(ctx context.Context, codeHash string, agent *Agent, now time.Time) error {
+	_ = ctx
+	if agent == nil {
+		return errors.New("agent is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, ok := s.agentEnrollments[strings.TrimSpace(codeHash)]
+	if !ok || enrollment.UsedAt != nil || !now.Before(enrollment.ExpiresAt) {
+		return ErrAgentEnrollmentInvalid
+	}
+	applyAgentEnrollment(agent, enrollment)
+	if err := validateAgent(agent); err != nil {
+		return err
+	}
+	if err := s.createAgentLocked(agent); err != nil {
+		return err
+	}
+	usedAt := now.UTC()
+	enrollment.UsedAt = &usedAt
+	return nil
+}
+
+func applyAgentEnrollment(agent *Agent, enrollment *AgentEnrollment) {
+	if strings.TrimSpace(agent.ID) == "" {
+		agent.ID = enrollment.AgentID
+	}
+	if strings.TrimSpace(agent.Name) == "" {
+		agent.Name = enrollment.Name
+	}
+	if len(agent.Groups) == 0 {
+		agent.Groups = cloneStringSlice(enrollment.Groups)
+	}
+}
+
+// AppendAgentStatus records a status report of an agent.
+func (s *memoryStore) AppendAgentStatus(ctx context.Context, status *AgentStatus) error {
+	_ = ctx
+	if status == nil {
+		return errors.New("agent status is required")
+	}
+	status.AgentID = strings.TrimSpace(status.AgentID)
+	if status.AgentID == "" {
+		return ErrAgentNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status.ID = uuid.NewString()
+	if status.ReportedAt.IsZero() {
+		status.ReportedAt = time.Now()
+	}
+	status.ReportedAt = status.ReportedAt.UTC()
+	stored := *status
+	stored.Report = append([]byte(nil), status.Report...)
+	s.agentStatuses = append(s.agentStatuses, stored)
+	return nil
+}
+
+// ListAgentStatuses returns up to limit reports of an agent, newest first.
+// A non-positive limit returns every report.
+func (s *memoryStore) ListAgentStatuses(ctx context.Context, agentID string, limit int) ([]AgentStatus, error) {
+	_ = ctx
+	agentID = strings.TrimSpace(agentID)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]AgentStatus, 0)
+	for i := len(s.agentStatuses) - 1; i >= 0; i-- {
+		if s.agentStatuses[i].AgentID != agentID {
+			continue
+		}
+		statuses = append(statuses, cloneAgentStatus(s.agentStatuses[i]))
+		if limit > 0 && len(statuses) == limit {
+			break
+		}
+	}
+	return statuses, nil
+}
+
+// LatestAgentStatuses returns the most recent report of every agent ordered
+// by agent ID.
+func (s *memoryStore) LatestAgentStatuses(ctx context.Context) ([]AgentStatus, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	latest := make(map[string]AgentStatus)
+	for _, status := range s.agentStatuses {
+		if current, ok := latest[status.AgentID]; !ok || !status.ReportedAt.Before(current.ReportedAt) {
+			latest[status.AgentID] = status
+		}
+	}
+	statuses := make([]AgentStatus, 0, len(latest))
+	for _, status := range latest {
+		statuses = append(statuses, cloneAgentStatus(status))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].AgentID < statuses[j].AgentID })
+	return statuses, nil
+}
+
+// PruneAgentStatuses deletes reports received before the cutoff, always
+// keeping the latest report of each agent.
+func (s *memoryStore) PruneAgentStatuses(ctx context.Context, before time.Time) (int, error) {
+	latest, _ := s.LatestAgentStatuses(ctx)
+	keep := make(map[string]struct{}, len(latest))
+	for _, status := range latest {
+		keep[status.ID] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retained := s.agentStatuses[:0]
+	pruned := 0
+	for _, status := range s.agentStatuses {
+		if _, ok := keep[status.ID]; !ok && status.ReportedAt.Before(before) {
+			pruned++
+			continue
+		}
+		retained = append(retained, status)
+	}
+	s.agentStatuses = retained
+	return pruned, nil
+}
+
+func cloneAgentStatus(status AgentStatus) AgentStatus {
+	status.Report = append([]byte(nil), status.Report...)
+	return status
+}
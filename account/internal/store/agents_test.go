@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryAgentEnrollmentIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, enrollment := range []*AgentEnrollment{
+		{CodeHash: "valid", Name: "edge", Groups: []string{"hk", " hk "}, ExpiresAt: now.Add(time.Hour)},
+		{CodeHash: "expired", ExpiresAt: now},
+	} {
+		if err := s.CreateAgentEnrollment(ctx, enrollment); err != nil {
+			t.Fatalf("create enrollment: %v", err)
+		}
+	}
+
+	for _, hash := range []string{"unknown", "expired"} {
+		if err := s.RedeemAgentEnrollment(ctx, hash, &Agent{TokenHash: "t-" + hash}, now); !errors.Is(err, ErrAgentEnrollmentInvalid) {
+			t.Fatalf("expected %s code to be rejected, got %v", hash, err)
+		}
+	}
+
+	agent := &Agent{TokenHash: "token-1"}
+	if err := s.RedeemAgentEnrollment(ctx, "valid", agent, now); err != nil {
+		t.Fatalf("redeem enrollment: %v", err)
+	}
+	if agent.ID == "" || agent.Name != "edge" || len(agent.Groups) != 1 || agent.CreatedAt.IsZero() {
+		t.Fatalf("expected agent to inherit the enrollment, got %+v", agent)
+	}
+	if err := s.RedeemAgentEnrollment(ctx, "valid", &Agent{TokenHash: "token-2"}, now); !errors.Is(err, ErrAgentEnrollmentInvalid) {
+		t.Fatalf("expected used code to be rejected, got %v", err)
+	}
+
+	if err := s.RevokeAgent(ctx, agent.ID, now); err != nil {
+		t.Fatalf("revoke agent: %v", err)
+	}
+	if err := s.UpdateAgentToken(ctx, agent.ID, "token-3"); !errors.Is(err, ErrAgentRevoked) {
+		t.Fatalf("expected revoked agent not to be rotated, got %v", err)
+	}
+	stored, err := s.GetAgent(ctx, agent.ID)
+	if err != nil || !stored.Revoked() || stored.TokenHash != "token-1" {
+		t.Fatalf("unexpected stored agent %+v (%v)", stored, err)
+	}
+}
+
+func TestMemoryAgentStatusPruneKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"a", "a", "b"} {
+		if err := s.AppendAgentStatus(ctx, &AgentStatus{AgentID: id, Report: []byte(`{}`), ReportedAt: start.Add(time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatalf("append status: %v", err)
+		}
+	}
+
+	pruned, err := s.PruneAgentStatuses(ctx, start.Add(24*time.Hour))
+	if err != nil || pruned != 1 {
+		t.Fatalf("expected one pruned report, got %d (%v)", pruned, err)
+	}
+	latest, err := s.LatestAgentStatuses(ctx)
+	if err != nil || len(latest) != 2 || latest[0].AgentID != "a" || !latest[0].ReportedAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected the latest report of each agent to be kept, got %+v (%v)", latest, err)
+	}
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit event outcomes.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEvent is an append-only record of a security-relevant account event.
+// ActorID identifies the user that performed the action and SubjectID the
+// user it was performed on; either may be empty, for example for a failed
+// login against an unknown identifier.
+type AuditEvent struct {
+	ID         string
+	OccurredAt time.Time
+	ActorID    string
+	SubjectID  string
+	Action     string
+	Outcome    string
+	IP         string
+	UserAgent  string
+	RequestID  string
+	Metadata   map[string]any
+}
+
+// AuditEventFilter filters and paginates ListAuditEvents. Since is
+// inclusive and Until exclusive; zero values leave the range open.
+type AuditEventFilter struct {
+	Since     time.Time
+	Until     time.Time
+	ActorID   string
+	SubjectID string
+	Action    string
+	Offset    int
+	Limit     int
+}
+
+// AuditEventPage is a page of audit events, newest first, along with the
+// total number of matches and the effective pagination window.
+type AuditEventPage struct {
+	Events []AuditEvent
+	Total  int
+	Offset int
+	Limit  int
+}
+
+const (
+	defaultAuditEventLimit = 100
+	maxAuditEventLimit     = 1000
+)
+
+func normalizeAuditEventFilter(filter AuditEventFilter) AuditEventFilter {
+	filter.ActorID = strings.TrimSpace(filter.ActorID)
+	filter.SubjectID = strings.TrimSpace(filter.SubjectID)
+	filter.Action = strings.ToLower(strings.TrimSpace(filter.Action))
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditEventLimit
+	}
+	if filter.Limit > maxAuditEventLimit {
+		filter.Limit = maxAuditEventLimit
+	}
+	return filter
+}
+
+func validateAuditEvent(event *AuditEvent) error {
+	if event == nil {
+		return errors.New("audit event is required")
+	}
+	event.Action = strings.ToLower(strings.TrimSpace(event.Action))
+	if event.Action == "" {
+		return errors.New("audit event action is required")
+	}
+	event.Outcome = strings.ToLower(strings.TrimSpace(event.Outcome))
+	if event.Outcome == "" {
+		event.Outcome = AuditOutcomeSuccess
+	}
+	event.ActorID = strings.TrimSpace(event.ActorID)
+	event.SubjectID = strings.TrimSpace(event.SubjectID)
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	event.OccurredAt = event.OccurredAt.UTC()
+	return nil
+}
+
+func (f AuditEventFilter) matches(event *AuditEvent) bool {
+	if !f.Since.IsZero() && event.OccurredAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !event.OccurredAt.Before(f.Until) {
+		return false
+	}
+	if f.ActorID != "" && event.ActorID != f.ActorID {
+		return false
+	}
+	if f.SubjectID != "" && event.SubjectID != f.SubjectID {
+		return false
+	}
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+	return true
+}
+
+func cloneAuditEvent(event *AuditEvent) *AuditEvent {
+	if event == nil {
+		return nil
+	}
+	clone := *event
+	clone.Metadata = cloneSubscriptionMeta(event.Metadata)
+	return &clone
+}
+
+// AppendAuditEvent records an audit event. Events cannot be modified or
+// removed once appended.
+func (s *memoryStore) AppendAuditEvent(ctx context.Context, event *AuditEvent) error {
+	_ = ctx
+	if err := validateAuditEvent(event); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = uuid.NewString()
+	s.auditEvents = append(s.auditEvents, cloneAuditEvent(event))
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching filter, newest first.
+func (s *memoryStore) ListAuditEvents(ctx context.Context, filter AuditEventFilter) (AuditEventPage, error) {
+	_ = ctx
+	filter = normalizeAuditEventFilter(filter)
+
+	s.mu.RLock()
+	matches := make([]AuditEvent, 0)
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if event := s.auditEvents[i]; filter.matches(event) {
+			matches = append(matches, *cloneAuditEvent(event))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].OccurredAt.After(matches[j].OccurredAt)
+	})
+
+	page := AuditEventPage{Total: len(matches), Events: []AuditEvent{}, Offset: filter.Offset, Limit: filter.Limit}
+	if filter.Offset < len(matches) {
+		end := filter.Offset + filter.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		page.Events = matches[filter.Offset:end]
+	}
+	return page, nil
+}
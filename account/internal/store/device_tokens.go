@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceToken is a named, revocable credential minted by a user for a desktop
+// or CLI client that cannot rely on the session cookie. Only the SHA-256 hash
+// of the secret is persisted.
+type DeviceToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+// Expired reports whether the token has passed its expiry time.
+func (t *DeviceToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// HasScope reports whether the token grants scope.
+func (t *DeviceToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneDeviceToken(token *DeviceToken) *DeviceToken {
+	if token == nil {
+		return nil
+	}
+	clone := *token
+	clone.Scopes = cloneStringSlice(token.Scopes)
+	if token.LastUsedAt != nil {
+		lastUsed := *token.LastUsedAt
+		clone.LastUsedAt = &lastUsed
+	}
+	if token.ExpiresAt != nil {
+		expires := *token.ExpiresAt
+		clone.ExpiresAt = &expires
+	}
+	return &clone
+}
+
+func validateDeviceToken(token *DeviceToken) error {
+	if token == nil {
+		return errors.New("device token is required")
+	}
+	token.UserID = strings.TrimSpace(token.UserID)
+	if token.UserID == "" {
+		return ErrUserNotFound
+	}
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" {
+		return errors.New("device token name is required")
+	}
+	token.TokenHash = strings.TrimSpace(token.TokenHash)
+	if token.TokenHash == "" {
+		return errors.New("device token hash is required")
+	}
+	token.Scopes = normalizeStringSlice(token.Scopes)
+	return nil
+}
+
+// CreateDeviceToken persists a new device token for an existing user.
+func (s *memoryStore) CreateDeviceToken(ctx context.Context, token *DeviceToken) error {
+	_ = ctx
+	if err := validateDeviceToken(token); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[token.UserID]; !ok {
+		return ErrUserNotFound
+	}
+
+	stored := cloneDeviceToken(token)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now().UTC()
+	}
+	s.deviceTokens[stored.ID] = stored
+
+	*token = *cloneDeviceToken(stored)
+	return nil
+}
+
+// ListDeviceTokens returns the device tokens owned by a user, newest first.
+func (s *memoryStore) ListDeviceTokens(ctx context.Context, userID string) ([]DeviceToken, error) {
+	_ = ctx
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]DeviceToken, 0)
+	for _, token := range s.deviceTokens {
+		if token.UserID == normalized {
+			result = append(result, *cloneDeviceToken(token))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// GetDeviceTokenByHash looks up a device token by the hash of its secret.
+func (s *memoryStore) GetDeviceTokenByHash(ctx context.Context, tokenHash string) (*DeviceToken, error) {
+	_ = ctx
+	normalized := strings.TrimSpace(tokenHash)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.deviceTokens {
+		if normalized != "" && token.TokenHash == normalized {
+			return cloneDeviceToken(token), nil
+		}
+	}
+	return nil, ErrDeviceTokenNotFound
+}
+
+// TouchDeviceToken records the time a device token was last used.
+func (s *memoryStore) TouchDeviceToken(ctx context.Context, id string, usedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.deviceTokens[strings.TrimSpace(id)]
+	if !ok {
+		return ErrDeviceTokenNotFound
+	}
+	used := usedAt.UTC()
+	token.LastUsedAt = &used
+	return nil
+}
+
+// DeleteDeviceToken revokes a device token owned by userID.
+func (s *memoryStore) DeleteDeviceToken(ctx context.Context, userID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimSpace(id)
+	token, ok := s.deviceTokens[key]
+	if !ok || token.UserID != strings.TrimSpace(userID) {
+		return ErrDeviceTokenNotFound
+	}
+	delete(s.deviceTokens, key)
+	return nil
+}
+
+// DeleteUserDeviceTokens revokes every device token owned by userID and
+// reports how many were removed.
+func (s *memoryStore) DeleteUserDeviceTokens(ctx context.Context, userID string) (int, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(userID)
+	removed := 0
+	for id, token := range s.deviceTokens {
+		if token.UserID == normalized {
+			delete(s.deviceTokens, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
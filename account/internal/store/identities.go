@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links a user to an account at an external identity provider.
+// ExternalID is the subject identifier issued by that provider.
+type Identity struct {
+	ID         string
+	UserID     string
+	Provider   string
+	ExternalID string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Identity errors.
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityExists   = errors.New("identity already linked")
+)
+
+func validateIdentity(identity *Identity) error {
+	if identity == nil {
+		return errors.New("identity is required")
+	}
+	identity.UserID = strings.TrimSpace(identity.UserID)
+	if identity.UserID == "" {
+		return ErrUserNotFound
+	}
+	identity.Provider = strings.ToLower(strings.TrimSpace(identity.Provider))
+	if identity.Provider == "" {
+		return errors.New("identity provider is required")
+	}
+	identity.ExternalID = strings.TrimSpace(identity.ExternalID)
+	if identity.ExternalID == "" {
+		return errors.New("identity external id is required")
+	}
+	return nil
+}
+
+func identityKey(provider, externalID string) string {
+	return strings.ToLower(strings.TrimSpace(provider)) + "\x00" + strings.TrimSpace(externalID)
+}
+
+// CreateIdentity links an external account to an existing user.
+func (s *memoryStore) CreateIdentity(ctx context.Context, identity *Identity) error {
+	_ = ctx
+	if err := validateIdentity(identity); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[identity.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	key := identityKey(identity.Provider, identity.ExternalID)
+	if _, exists := s.identities[key]; exists {
+		return ErrIdentityExists
+	}
+
+	stored := *identity
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	now := time.Now().UTC()
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = now
+	}
+	stored.UpdatedAt = stored.CreatedAt
+	s.identities[key] = &stored
+
+	*identity = stored
+	return nil
+}
+
+// GetIdentity looks up the identity issued by provider for externalID.
+func (s *memoryStore) GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[identityKey(provider, externalID)]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+	clone := *identity
+	return &clone, nil
+}
+
+// ListIdentitiesByUser returns the identities linked to a user, oldest first.
+func (s *memoryStore) ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error) {
+	_ = ctx
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Identity, 0)
+	for _, identity := range s.identities {
+		if identity.UserID == normalized {
+			result = append(result, *identity)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// DeleteIdentity unlinks an identity owned by userID.
+func (s *memoryStore) DeleteIdentity(ctx context.Context, userID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalizedID := strings.TrimSpace(id)
+	normalizedUserID := strings.TrimSpace(userID)
+	for key, identity := range s.identities {
+		if identity.ID == normalizedID && identity.UserID == normalizedUserID {
+			delete(s.identities, key)
+			return nil
+		}
+	}
+	return ErrIdentityNotFound
+}
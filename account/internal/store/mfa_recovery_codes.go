@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrMFARecoveryCodeNotFound is returned when a recovery code does not exist
+// or has already been redeemed.
+var ErrMFARecoveryCodeNotFound = errors.New("mfa recovery code not found")
+
+// mfaRecoveryCode is a single use code that replaces the TOTP code during
+// sign in. Only the SHA-256 hash of the code is persisted.
+type mfaRecoveryCode struct {
+	codeHash  string
+	createdAt time.Time
+	usedAt    *time.Time
+}
+
+func normalizeRecoveryCodeHashes(codeHashes []string) ([]string, error) {
+	normalized := make([]string, 0, len(codeHashes))
+	seen := make(map[string]struct{}, len(codeHashes))
+	for _, hash := range codeHashes {
+		hash = strings.TrimSpace(hash)
+		if hash == "" {
+			return nil, errors.New("mfa recovery code hash is required")
+		}
+		if _, exists := seen[hash]; exists {
+			continue
+		}
+		seen[hash] = struct{}{}
+		normalized = append(normalized, hash)
+	}
+	return normalized, nil
+}
+
+// ReplaceMFARecoveryCodes discards every recovery code of a user, redeemed or
+// not, and stores codeHashes in their place. An empty slice removes them all.
+func (s *memoryStore) ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	_ = ctx
+	normalizedUserID := strings.TrimSpace(userID)
+	hashes, err := normalizeRecoveryCodeHashes(codeHashes)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[normalizedUserID]; !ok {
+		return ErrUserNotFound
+	}
+	if len(hashes) == 0 {
+		delete(s.recoveryCodes, normalizedUserID)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	codes := make([]*mfaRecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, &mfaRecoveryCode{codeHash: hash, createdAt: now})
+	}
+	s.recoveryCodes[normalizedUserID] = codes
+	return nil
+}
+
+// ConsumeMFARecoveryCode redeems an unused recovery code of a user.
+func (s *memoryStore) ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash string, usedAt time.Time) error {
+	_ = ctx
+	normalized := strings.TrimSpace(codeHash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range s.recoveryCodes[strings.TrimSpace(userID)] {
+		if normalized != "" && code.codeHash == normalized && code.usedAt == nil {
+			used := usedAt.UTC()
+			code.usedAt = &used
+			return nil
+		}
+	}
+	return ErrMFARecoveryCodeNotFound
+}
+
+// CountMFARecoveryCodes returns how many unused recovery codes a user has.
+func (s *memoryStore) CountMFARecoveryCodes(ctx context.Context, userID string) (int, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	remaining := 0
+	for _, code := range s.recoveryCodes[strings.TrimSpace(userID)] {
+		if code.usedAt == nil {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
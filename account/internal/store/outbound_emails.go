@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboundEmail is a message waiting in the mail queue. Delivered messages
+// are deleted; messages that failed permanently stay with DeadLetteredAt set
+// so that operators can inspect their recipients and last error. Their
+// bodies are blanked, as they carry verification codes and reset links.
+type OutboundEmail struct {
+	ID             string
+	Recipients     []string
+	Subject        string
+	PlainBody      string
+	HTMLBody       string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      string
+	DeadLetteredAt *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// OutboundEmailStats summarises the mail queue.
+type OutboundEmailStats struct {
+	Pending      int
+	DeadLettered int
+}
+
+// ErrOutboundEmailNotFound is returned when a queued email does not exist.
+var ErrOutboundEmailNotFound = errors.New("outbound email not found")
+
+func validateOutboundEmail(email *OutboundEmail) error {
+	if email == nil {
+		return errors.New("outbound email is required")
+	}
+	email.Recipients = normalizeStringSlice(email.Recipients)
+	if len(email.Recipients) == 0 {
+		return errors.New("outbound email recipients are required")
+	}
+	return nil
+}
+
+func cloneOutboundEmail(email *OutboundEmail) OutboundEmail {
+	clone := *email
+	clone.Recipients = cloneStringSlice(email.Recipients)
+	if email.DeadLetteredAt != nil {
+		deadLetteredAt := *email.DeadLetteredAt
+		clone.DeadLetteredAt = &deadLetteredAt
+	}
+	return clone
+}
+
+// EnqueueOutboundEmail stores a message for delivery. A zero NextAttemptAt
+// makes it due immediately.
+func (s *memoryStore) EnqueueOutboundEmail(ctx context.Context, email *OutboundEmail) error {
+	_ = ctx
+	if err := validateOutboundEmail(email); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	email.ID = uuid.NewString()
+	email.Attempts = 0
+	email.LastError = ""
+	email.DeadLetteredAt = nil
+	if email.NextAttemptAt.IsZero() {
+		email.NextAttemptAt = now
+	}
+	email.NextAttemptAt = email.NextAttemptAt.UTC()
+	email.CreatedAt = now
+	email.UpdatedAt = now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneOutboundEmail(email)
+	s.outboundEmails[email.ID] = &stored
+	return nil
+}
+
+// ClaimOutboundEmails returns up to limit messages that are due at now and
+// hides them from other claims until now+lease, so that a crashed worker's
+// messages are retried once the lease expires.
+func (s *memoryStore) ClaimOutboundEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboundEmail, error) {
+	_ = ctx
+	if limit <= 0 {
+		return nil, nil
+	}
+	now = now.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]*OutboundEmail, 0)
+	for _, email := range s.outboundEmails {
+		if email.DeadLetteredAt == nil && !email.NextAttemptAt.After(now) {
+			due = append(due, email)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].CreatedAt.Before(due[j].CreatedAt)
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]OutboundEmail, 0, len(due))
+	for _, email := range due {
+		email.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, cloneOutboundEmail(email))
+	}
+	return claimed, nil
+}
+
+// DeleteOutboundEmail removes a delivered message.
+func (s *memoryStore) DeleteOutboundEmail(ctx context.Context, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(id)
+	if _, ok := s.outboundEmails[normalized]; !ok {
+		return ErrOutboundEmailNotFound
+	}
+	delete(s.outboundEmails, normalized)
+	return nil
+}
+
+// RescheduleOutboundEmail records a failed attempt and the time of the next.
+func (s *memoryStore) RescheduleOutboundEmail(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email, ok := s.outboundEmails[strings.TrimSpace(id)]
+	if !ok {
+		return ErrOutboundEmailNotFound
+	}
+	email.Attempts = attempts
+	email.NextAttemptAt = nextAttemptAt.UTC()
+	email.LastError = lastError
+	email.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// DeadLetterOutboundEmail gives up on a message and blanks its bodies.
+func (s *memoryStore) DeadLetterOutboundEmail(ctx context.Context, id string, attempts int, lastError string, deadLetteredAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email, ok := s.outboundEmails[strings.TrimSpace(id)]
+	if !ok {
+		return ErrOutboundEmailNotFound
+	}
+	at := deadLetteredAt.UTC()
+	email.Attempts = attempts
+	email.LastError = lastError
+	email.PlainBody = ""
+	email.HTMLBody = ""
+	email.DeadLetteredAt = &at
+	email.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// OutboundEmailStats counts pending and dead-lettered messages.
+func (s *memoryStore) OutboundEmailStats(ctx context.Context) (OutboundEmailStats, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats OutboundEmailStats
+	for _, email := range s.outboundEmails {
+		if email.DeadLetteredAt != nil {
+			stats.DeadLettered++
+		} else {
+			stats.Pending++
+		}
+	}
+	return stats, nil
+}
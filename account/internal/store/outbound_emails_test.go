@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryOutboundEmailQueue(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	if err := s.EnqueueOutboundEmail(ctx, &OutboundEmail{Subject: "no recipients"}); err == nil {
+		t.Fatal("expected email without recipients to be rejected")
+	}
+	first := &OutboundEmail{Recipients: []string{" a@test ", "a@test"}, Subject: "first"}
+	second := &OutboundEmail{Recipients: []string{"b@test"}, Subject: "second", PlainBody: "code 123456", HTMLBody: "<p>code 123456</p>", NextAttemptAt: time.Now().Add(time.Hour)}
+	for _, email := range []*OutboundEmail{first, second} {
+		if err := s.EnqueueOutboundEmail(ctx, email); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	if len(first.Recipients) != 1 || first.ID == "" {
+		t.Fatalf("expected normalized recipients and an id, got %+v", first)
+	}
+
+	now := time.Now()
+	claimed, err := s.ClaimOutboundEmails(ctx, now, time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != first.ID {
+		t.Fatalf("expected only the due email to be claimed, got %+v (%v)", claimed, err)
+	}
+	if again, _ := s.ClaimOutboundEmails(ctx, now, time.Minute, 10); len(again) != 0 {
+		t.Fatalf("expected claimed email to be leased, got %+v", again)
+	}
+	if expired, _ := s.ClaimOutboundEmails(ctx, now.Add(2*time.Minute), time.Minute, 10); len(expired) != 1 {
+		t.Fatalf("expected email to be claimable after the lease expires, got %+v", expired)
+	}
+
+	if err := s.RescheduleOutboundEmail(ctx, first.ID, 1, now.Add(-time.Second), "421 busy"); err != nil {
+		t.Fatalf("reschedule: %v", err)
+	}
+	claimed, _ = s.ClaimOutboundEmails(ctx, now, time.Minute, 10)
+	if len(claimed) != 1 || claimed[0].Attempts != 1 || claimed[0].LastError != "421 busy" {
+		t.Fatalf("expected rescheduled email with its failure, got %+v", claimed)
+	}
+
+	if err := s.DeadLetterOutboundEmail(ctx, second.ID, 8, "550 rejected", now); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	// Dead letters keep what operators need, but not the codes and links in
+	// the bodies.
+	dead := s.(*memoryStore).outboundEmails[second.ID]
+	if dead.PlainBody != "" || dead.HTMLBody != "" || dead.Recipients[0] != "b@test" || dead.LastError != "550 rejected" || dead.DeadLetteredAt == nil {
+		t.Fatalf("expected dead letter without bodies, got %+v", dead)
+	}
+	if err := s.DeleteOutboundEmail(ctx, first.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := s.DeleteOutboundEmail(ctx, first.ID); err != ErrOutboundEmailNotFound {
+		t.Fatalf("expected deleted email to be gone, got %v", err)
+	}
+	stats, err := s.OutboundEmailStats(ctx)
+	if err != nil || stats.Pending != 0 || stats.DeadLettered != 1 {
+		t.Fatalf("unexpected stats: %+v (%v)", stats, err)
+	}
+	if claimed, _ := s.ClaimOutboundEmails(ctx, now.Add(2*time.Hour), time.Minute, 10); len(claimed) != 0 {
+		t.Fatalf("expected dead-lettered email not to be claimed, got %+v", claimed)
+	}
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment event processing outcomes.
+const (
+	PaymentEventResultApplied = "applied"
+	PaymentEventResultIgnored = "ignored"
+	PaymentEventResultFailed  = "failed"
+)
+
+// PaymentEvent is a webhook delivery received from a payment provider. The
+// provider and its event ID form the idempotency key of the delivery.
+type PaymentEvent struct {
+	ID         string
+	Provider   string
+	EventID    string
+	Type       string
+	Payload    []byte
+	ReceivedAt time.Time
+	// ProcessingAt is when the delivery currently applying the event claimed
+	// it; it is cleared once the outcome is recorded.
+	ProcessingAt *time.Time
+	ProcessedAt  *time.Time
+	Result       string
+	Error        string
+}
+
+var (
+	// ErrPaymentEventExists is returned when an event was already recorded.
+	ErrPaymentEventExists   = errors.New("payment event already recorded")
+	ErrPaymentEventNotFound = errors.New("payment event not found")
+	// ErrPaymentEventClaimed is returned when an event cannot be claimed
+	// because it was processed or another delivery is processing it.
+	ErrPaymentEventClaimed = errors.New("payment event already claimed")
+)
+
+// paymentEventClaimable reports whether a delivery may start processing
+// event at claimedAt: it has no outcome yet, or failed, and no other
+// delivery claimed it within lease.
+func paymentEventClaimable(event *PaymentEvent, claimedAt time.Time, lease time.Duration) bool {
+	if event.ProcessedAt != nil && event.Result != PaymentEventResultFailed {
+		return false
+	}
+	return event.ProcessingAt == nil || event.ProcessingAt.Before(claimedAt.Add(-lease))
+}
+
+func validatePaymentEvent(event *PaymentEvent) error {
+	if event == nil {
+		return errors.New("payment event is required")
+	}
+	event.Provider = strings.TrimSpace(event.Provider)
+	event.EventID = strings.TrimSpace(event.EventID)
+	event.Type = strings.TrimSpace(event.Type)
+	if event.Provider == "" || event.EventID == "" {
+		return errors.New("payment event provider and id are required")
+	}
+	return nil
+}
+
+func clonePaymentEvent(event *PaymentEvent) PaymentEvent {
+	clone := *event
+	clone.Payload = append([]byte(nil), event.Payload...)
+	clone.ProcessingAt = cloneTimePointer(event.ProcessingAt)
+	clone.ProcessedAt = cloneTimePointer(event.ProcessedAt)
+	return clone
+}
+
+func paymentEventKey(provider, eventID string) string {
+	return provider + "\x00" + eventID
+}
+
+// RecordPaymentEvent stores a newly received event, claimed for processing
+// by the caller. When the event was already recorded, event is replaced by
+// the stored copy and ErrPaymentEventExists is returned.
+func (s *memoryStore) RecordPaymentEvent(ctx context.Context, event *PaymentEvent) error {
+	_ = ctx
+	if err := validatePaymentEvent(event); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := paymentEventKey(event.Provider, event.EventID)
+	if existing, ok := s.paymentEvents[key]; ok {
+		*event = clonePaymentEvent(existing)
+		return ErrPaymentEventExists
+	}
+
+	event.ID = uuid.NewString()
+	event.ReceivedAt = time.Now().UTC()
+	event.ProcessingAt = &event.ReceivedAt
+	event.ProcessedAt = nil
+	event.Result = ""
+	event.Error = ""
+	stored := clonePaymentEvent(event)
+	s.paymentEvents[key] = &stored
+	return nil
+}
+
+// ClaimPaymentEvent marks an already recorded event as being processed by
+// the caller. ErrPaymentEventClaimed is returned when it was processed or
+// another delivery claimed it less than lease ago.
+func (s *memoryStore) ClaimPaymentEvent(ctx context.Context, id string, claimedAt time.Time, lease time.Duration) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(id)
+	for _, event := range s.paymentEvents {
+		if event.ID != normalized {
+			continue
+		}
+		if !paymentEventClaimable(event, claimedAt, lease) {
+			return ErrPaymentEventClaimed
+		}
+		at := claimedAt.UTC()
+		event.ProcessingAt = &at
+		return nil
+	}
+	return ErrPaymentEventNotFound
+}
+
+// CompletePaymentEvent records the outcome of processing an event and
+// releases its claim.
+func (s *memoryStore) CompletePaymentEvent(ctx context.Context, id string, processedAt time.Time, result, processingError string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(id)
+	for _, event := range s.paymentEvents {
+		if event.ID != normalized {
+			continue
+		}
+		at := processedAt.UTC()
+		event.ProcessingAt = nil
+		event.ProcessedAt = &at
+		event.Result = result
+		event.Error = processingError
+		return nil
+	}
+	return ErrPaymentEventNotFound
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryPaymentEventsAreIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	if err := s.RecordPaymentEvent(ctx, &PaymentEvent{Provider: "stripe"}); err == nil {
+		t.Fatal("expected event without id to be rejected")
+	}
+
+	event := &PaymentEvent{Provider: "stripe", EventID: "evt_1", Type: "customer.subscription.updated", Payload: []byte(`{}`)}
+	if err := s.RecordPaymentEvent(ctx, event); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if event.ID == "" || event.ReceivedAt.IsZero() {
+		t.Fatalf("expected stored event, got %+v", event)
+	}
+
+	processedAt := time.Now()
+	if err := s.CompletePaymentEvent(ctx, event.ID, processedAt, PaymentEventResultApplied, ""); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	duplicate := &PaymentEvent{Provider: "stripe", EventID: "evt_1", Payload: []byte(`{"replayed":true}`)}
+	if err := s.RecordPaymentEvent(ctx, duplicate); !errors.Is(err, ErrPaymentEventExists) {
+		t.Fatalf("expected duplicate to be reported, got %v", err)
+	}
+	if duplicate.ID != event.ID || duplicate.ProcessedAt == nil || duplicate.Result != PaymentEventResultApplied || string(duplicate.Payload) != `{}` {
+		t.Fatalf("expected the stored event, got %+v", duplicate)
+	}
+
+	if err := s.RecordPaymentEvent(ctx, &PaymentEvent{Provider: "generic", EventID: "evt_1"}); err != nil {
+		t.Fatalf("expected event ids to be scoped by provider, got %v", err)
+	}
+	if err := s.CompletePaymentEvent(ctx, "missing", processedAt, PaymentEventResultFailed, "boom"); !errors.Is(err, ErrPaymentEventNotFound) {
+		t.Fatalf("expected missing event, got %v", err)
+	}
+}
+
+func TestMemoryPaymentEventClaims(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	lease := time.Minute
+
+	event := &PaymentEvent{Provider: "stripe", EventID: "evt_1", Payload: []byte(`{}`)}
+	if err := s.RecordPaymentEvent(ctx, event); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if event.ProcessingAt == nil {
+		t.Fatal("expected the recording delivery to hold the claim")
+	}
+
+	now := time.Now()
+	if err := s.ClaimPaymentEvent(ctx, event.ID, now, lease); !errors.Is(err, ErrPaymentEventClaimed) {
+		t.Fatalf("expected concurrent delivery to be refused, got %v", err)
+	}
+	// A claim whose holder stopped reporting back expires after the lease.
+	if err := s.ClaimPaymentEvent(ctx, event.ID, now.Add(2*lease), lease); err != nil {
+		t.Fatalf("expected stale claim to be taken over, got %v", err)
+	}
+
+	if err := s.CompletePaymentEvent(ctx, event.ID, now, PaymentEventResultFailed, "boom"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if err := s.ClaimPaymentEvent(ctx, event.ID, now, lease); err != nil {
+		t.Fatalf("expected failed event to be claimable, got %v", err)
+	}
+	if err := s.CompletePaymentEvent(ctx, event.ID, now, PaymentEventResultApplied, ""); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if err := s.ClaimPaymentEvent(ctx, event.ID, now.Add(2*lease), lease); !errors.Is(err, ErrPaymentEventClaimed) {
+		t.Fatalf("expected applied event to stay closed, got %v", err)
+	}
+	if err := s.ClaimPaymentEvent(ctx, "missing", now, lease); !errors.Is(err, ErrPaymentEventNotFound) {
+		t.Fatalf("expected missing event, got %v", err)
+	}
+}
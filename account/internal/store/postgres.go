@@ -86,6 +86,9 @@ type schemaCapabilities struct {
 	hasRole              bool
 	hasGroups            bool
 	hasPermissions       bool
+	hasSyncSecret        bool
+	hasDisabled          bool
+	hasLocale            bool
 }
 
 func (c schemaCapabilities) supportsMFA() bool {
@@ -180,6 +183,12 @@ func (s *postgresStore) CreateUser(ctx context.Context, user *User) error {
 		args = append(args, encoded)
 		idx++
 	}
+	if caps.hasLocale && strings.TrimSpace(user.Locale) != "" {
+		columns = append(columns, "locale")
+		placeholders = append(placeholders, fmt.Sprintf("$%d", idx))
+		args = append(args, strings.TrimSpace(user.Locale))
+		idx++
+	}
 
 	query := fmt.Sprintf(`INSERT INTO users (%s)
       VALUES (%s)
@@ -314,9 +323,12 @@ func scanUser(row rowScanner) (*User, error) {
 		roleValue       sql.NullString
 		groupsRaw       []byte
 		permissionsRaw  []byte
+		syncSecret      sql.NullString
+		disabled        sql.NullBool
+		locale          sql.NullString
 	)
 
-	if err := row.Scan(&idValue, &username, &email, &emailVerified, &password, &mfaSecret, &mfaEnabled, &mfaSecretIssued, &mfaConfirmed, &createdAt, &updatedAt, &levelValue, &roleValue, &groupsRaw, &permissionsRaw); err != nil {
+	if err := row.Scan(&idValue, &username, &email, &emailVerified, &password, &mfaSecret, &mfaEnabled, &mfaSecretIssued, &mfaConfirmed, &createdAt, &updatedAt, &levelValue, &roleValue, &groupsRaw, &permissionsRaw, &syncSecret, &disabled, &locale); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
@@ -338,6 +350,9 @@ func scanUser(row rowScanner) (*User, error) {
 		MFAEnabled:        mfaEnabled.Bool,
 		MFASecretIssuedAt: toUTCTime(mfaSecretIssued),
 		MFAConfirmedAt:    toUTCTime(mfaConfirmed),
+		SyncSecret:        syncSecret.String,
+		Disabled:          disabled.Bool,
+		Locale:            strings.TrimSpace(locale.String),
 		CreatedAt:         createdAt.UTC(),
 		UpdatedAt:         updatedAt.UTC(),
 	}
@@ -419,6 +434,18 @@ func (s *postgresStore) UpdateUser(ctx context.Context, user *User) error {
 		return ErrMFANotSupported
 	}
 
+	if caps.hasSyncSecret {
+		builder.WriteString(fmt.Sprintf(", sync_secret = $%d", idx))
+		args = append(args, nullForEmpty(user.SyncSecret))
+		idx++
+	}
+
+	if caps.hasLocale {
+		builder.WriteString(fmt.Sprintf(", locale = $%d", idx))
+		args = append(args, nullForEmpty(user.Locale))
+		idx++
+	}
+
 	if caps.hasUpdatedAt {
 		builder.WriteString(", updated_at = now()")
 	}
@@ -501,6 +528,131 @@ func (s *postgresStore) UpdateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// ListUsers returns users ordered from newest to oldest.
+func (s *postgresStore) ListUsers(ctx context.Context, opts UserListOptions) (UserPage, error) {
+	opts = normalizeUserListOptions(opts)
+
+	caps, err := s.capabilities(ctx)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	conditions := make([]string, 0, 3)
+	args := make([]any, 0, 5)
+	if opts.Query != "" {
+		args = append(args, "%"+escapeLikePattern(opts.Query)+"%")
+		conditions = append(conditions, fmt.Sprintf("(lower(username) LIKE $%d OR lower(email) LIKE $%d)", len(args), len(args)))
+	}
+	if opts.Role != "" {
+		if caps.hasRole {
+			args = append(args, opts.Role)
+			conditions = append(conditions, fmt.Sprintf("lower(coalesce(role, '%s')) = $%d", RoleUser, len(args)))
+		} else if opts.Role != RoleUser {
+			return UserPage{Users: []User{}, Offset: opts.Offset, Limit: opts.Limit}, nil
+		}
+	}
+	if opts.Disabled != nil {
+		if caps.hasDisabled {
+			args = append(args, *opts.Disabled)
+			conditions = append(conditions, fmt.Sprintf("coalesce(disabled, false) = $%d", len(args)))
+		} else if *opts.Disabled {
+			return UserPage{Users: []User{}, Offset: opts.Offset, Limit: opts.Limit}, nil
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := UserPage{Offset: opts.Offset, Limit: opts.Limit}
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&page.Total); err != nil {
+		return UserPage{}, err
+	}
+
+	order := "ORDER BY uuid"
+	if caps.hasCreatedAt {
+		order = "ORDER BY created_at DESC, uuid"
+	}
+	args = append(args, opts.Limit, opts.Offset)
+	query := s.selectUserQuery(caps, fmt.Sprintf("%s %s LIMIT $%d OFFSET $%d", where, order, len(args)-1, len(args)))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+
+	page.Users = make([]User, 0, opts.Limit)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return UserPage{}, err
+		}
+		page.Users = append(page.Users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, err
+	}
+	return page, nil
+}
+
+// SetUserDisabled enables or disables sign in for a user.
+func (s *postgresStore) SetUserDisabled(ctx context.Context, id string, disabled bool) error {
+	caps, err := s.capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.hasDisabled {
+		return ErrDisableNotSupported
+	}
+
+	query := "UPDATE users SET disabled = $2"
+	if caps.hasUpdatedAt {
+		query += ", updated_at = now()"
+	}
+	query += " WHERE uuid = $1"
+
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(id), disabled)
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrUserNotFound)
+}
+
+// DeleteUser removes a user. Subscriptions, identities, sessions and device
+// tokens are removed by their ON DELETE CASCADE foreign keys; refresh tokens,
+// whose user_id is not a foreign key, are deleted in the same transaction.
+func (s *postgresStore) DeleteUser(ctx context.Context, id string) (err error) {
+	normalized := strings.TrimSpace(id)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE uuid = $1", normalized)
+	if err != nil {
+		return err
+	}
+	if err = requireAffectedRow(result, ErrUserNotFound); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id = $1", normalized); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	return err
+}
+
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
 func (s *postgresStore) CountSuperAdmins(ctx context.Context) (int, error) {
 	if !s.allowSuperAdminCounting {
 		return 0, ErrSuperAdminCountingDisabled
@@ -539,8 +691,9 @@ func (s *postgresStore) CountSuperAdmins(ctx context.Context) (int, error) {
 	return count, nil
 }
 
-// UpsertSubscription creates or updates a subscription row.
-func (s *postgresStore) UpsertSubscription(ctx context.Context, subscription *Subscription) error {
+// UpsertSubscription creates or updates a subscription row. The existing row
+// is locked while the status transition is validated.
+func (s *postgresStore) UpsertSubscription(ctx context.Context, subscription *Subscription) (err error) {
 	if subscription == nil {
 		return errors.New("subscription is required")
 	}
@@ -554,6 +707,9 @@ func (s *postgresStore) UpsertSubscription(ctx context.Context, subscription *Su
 	if externalID == "" {
 		return errors.New("external id is required")
 	}
+	if err := normalizeSubscriptionForWrite(subscription); err != nil {
+		return err
+	}
 	if strings.TrimSpace(subscription.PaymentMethod) == "" {
 		subscription.PaymentMethod = strings.TrimSpace(subscription.Provider)
 	}
@@ -564,13 +720,35 @@ func (s *postgresStore) UpsertSubscription(ctx context.Context, subscription *Su
 		return err
 	}
 
-	var cancelledAt any
-	if subscription.CancelledAt != nil {
-		cancelledAt = subscription.CancelledAt.UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var (
+		currentStatus string
+		currentEnd    sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `SELECT status, current_period_end FROM subscriptions WHERE user_uuid = $1 AND external_id = $2 FOR UPDATE`,
+		normalizedUserID, externalID).Scan(&currentStatus, &currentEnd)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = nil
+	case err != nil:
+		return err
+	default:
+		if err = validateSubscriptionUpdate(currentStatus, nullTimePointer(currentEnd), subscription); err != nil {
+			return err
+		}
 	}
 
-	const query = `INSERT INTO subscriptions (user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, cancelled_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, '{}'::jsonb), $10)
+	const query = `INSERT INTO subscriptions (user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, current_period_start, current_period_end, cancelled_at, provider_updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, '{}'::jsonb), $10, $11, $12, $13)
 ON CONFLICT (user_uuid, external_id) DO UPDATE SET
   provider = EXCLUDED.provider,
   payment_method = EXCLUDED.payment_method,
@@ -579,18 +757,14 @@ ON CONFLICT (user_uuid, external_id) DO UPDATE SET
   status = EXCLUDED.status,
   payment_qr = EXCLUDED.payment_qr,
   meta = EXCLUDED.meta,
+  current_period_start = COALESCE(EXCLUDED.current_period_start, subscriptions.current_period_start),
+  current_period_end = COALESCE(EXCLUDED.current_period_end, subscriptions.current_period_end),
   cancelled_at = EXCLUDED.cancelled_at,
+  provider_updated_at = COALESCE(EXCLUDED.provider_updated_at, subscriptions.provider_updated_at),
   updated_at = now()
-RETURNING uuid, created_at, updated_at, cancelled_at`
+RETURNING ` + subscriptionColumns
 
-	var (
-		idValue   any
-		createdAt time.Time
-		updatedAt time.Time
-		cancelled sql.NullTime
-	)
-
-	err = s.db.QueryRowContext(
+	stored, err := scanSubscription(tx.QueryRowContext(
 		ctx,
 		query,
 		normalizedUserID,
@@ -599,33 +773,22 @@ RETURNING uuid, created_at, updated_at, cancelled_at`
 		strings.TrimSpace(subscription.Kind),
 		strings.TrimSpace(subscription.PlanID),
 		externalID,
-		strings.TrimSpace(subscription.Status),
+		subscription.Status,
 		strings.TrimSpace(subscription.PaymentQRCode),
 		encodedMeta,
-		cancelledAt,
-	).Scan(&idValue, &createdAt, &updatedAt, &cancelled)
+		nullableTime(subscription.CurrentPeriodStart),
+		nullableTime(subscription.CurrentPeriodEnd),
+		nullableTime(subscription.CancelledAt),
+		nullableTime(subscription.ProviderUpdatedAt),
+	))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrSubscriptionNotFound
-		}
 		return err
 	}
-
-	identifier, err := formatIdentifier(idValue)
-	if err != nil {
+	if err = tx.Commit(); err != nil {
 		return err
 	}
 
-	subscription.ID = identifier
-	subscription.UserID = normalizedUserID
-	subscription.ExternalID = externalID
-	subscription.CreatedAt = createdAt.UTC()
-	subscription.UpdatedAt = updatedAt.UTC()
-	subscription.Meta, _ = decodeSubscriptionMeta(encodedMeta)
-	if cancelled.Valid {
-		subscription.CancelledAt = &cancelled.Time
-	}
-
+	assignSubscription(subscription, stored)
 	return nil
 }
 
@@ -636,7 +799,7 @@ func (s *postgresStore) ListSubscriptionsByUser(ctx context.Context, userID stri
 		return nil, ErrUserNotFound
 	}
 
-	const query = `SELECT uuid, user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, created_at, updated_at, cancelled_at
+	query := `SELECT ` + subscriptionColumns + `
 FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
 
 	rows, err := s.db.QueryContext(ctx, query, normalizedUserID)
@@ -647,53 +810,11 @@ FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
 
 	var subs []Subscription
 	for rows.Next() {
-		var (
-			idValue       any
-			provider      string
-			paymentMethod string
-			kind          string
-			planID        sql.NullString
-			externalID    string
-			status        string
-			paymentQR     sql.NullString
-			metaBytes     []byte
-			createdAt     time.Time
-			updatedAt     time.Time
-			cancelled     sql.NullTime
-		)
-		if err := rows.Scan(&idValue, &normalizedUserID, &provider, &paymentMethod, &kind, &planID, &externalID, &status, &paymentQR, &metaBytes, &createdAt, &updatedAt, &cancelled); err != nil {
-			return nil, err
-		}
-
-		identifier, err := formatIdentifier(idValue)
-		if err != nil {
-			return nil, err
-		}
-
-		meta, err := decodeSubscriptionMeta(metaBytes)
+		sub, err := scanSubscription(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		sub := Subscription{
-			ID:            identifier,
-			UserID:        userID,
-			Provider:      provider,
-			PaymentMethod: paymentMethod,
-			PaymentQRCode: paymentQR.String,
-			Kind:          kind,
-			PlanID:        planID.String,
-			ExternalID:    externalID,
-			Status:        status,
-			Meta:          meta,
-			CreatedAt:     createdAt.UTC(),
-			UpdatedAt:     updatedAt.UTC(),
-		}
-		if cancelled.Valid {
-			sub.CancelledAt = &cancelled.Time
-		}
-
-		subs = append(subs, sub)
+		subs = append(subs, *sub)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -704,7 +825,7 @@ FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
 }
 
 // CancelSubscription marks the subscription as cancelled.
-func (s *postgresStore) CancelSubscription(ctx context.Context, userID, externalID string, cancelledAt time.Time) (*Subscription, error) {
+func (s *postgresStore) CancelSubscription(ctx context.Context, userID, externalID string, cancelledAt time.Time) (sub *Subscription, err error) {
 	normalizedUserID := strings.TrimSpace(userID)
 	if normalizedUserID == "" {
 		return nil, ErrUserNotFound
@@ -715,73 +836,41 @@ func (s *postgresStore) CancelSubscription(ctx context.Context, userID, external
 		return nil, ErrSubscriptionNotFound
 	}
 
-	const query = `UPDATE subscriptions
-SET status = 'cancelled', cancelled_at = $3, updated_at = now()
-WHERE user_uuid = $1 AND external_id = $2
-RETURNING uuid, provider, payment_method, kind, plan_id, status, payment_qr, meta, created_at, updated_at, cancelled_at`
-
-	var (
-		idValue       any
-		provider      string
-		paymentMethod string
-		kind          string
-		planID        sql.NullString
-		status        string
-		paymentQR     sql.NullString
-		metaBytes     []byte
-		createdAt     time.Time
-		updatedAt     time.Time
-		cancelled     sql.NullTime
-	)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
 
-	err := s.db.QueryRowContext(ctx, query, normalizedUserID, key, cancelledAt.UTC()).Scan(
-		&idValue,
-		&provider,
-		&paymentMethod,
-		&kind,
-		&planID,
-		&status,
-		&paymentQR,
-		&metaBytes,
-		&createdAt,
-		&updatedAt,
-		&cancelled,
-	)
+	var currentStatus string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM subscriptions WHERE user_uuid = $1 AND external_id = $2 FOR UPDATE`,
+		normalizedUserID, key).Scan(&currentStatus)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrSubscriptionNotFound
+			err = ErrSubscriptionNotFound
 		}
 		return nil, err
 	}
-
-	identifier, err := formatIdentifier(idValue)
-	if err != nil {
+	if err = validateSubscriptionTransition(currentStatus, SubscriptionStatusCancelled); err != nil {
 		return nil, err
 	}
 
-	meta, err := decodeSubscriptionMeta(metaBytes)
+	const query = `UPDATE subscriptions
+SET status = 'cancelled', cancelled_at = $3, updated_at = now()
+WHERE user_uuid = $1 AND external_id = $2
+RETURNING ` + subscriptionColumns
+
+	sub, err = scanSubscription(tx.QueryRowContext(ctx, query, normalizedUserID, key, cancelledAt.UTC()))
 	if err != nil {
 		return nil, err
 	}
-
-	sub := &Subscription{
-		ID:            identifier,
-		UserID:        normalizedUserID,
-		Provider:      provider,
-		PaymentMethod: paymentMethod,
-		PaymentQRCode: paymentQR.String,
-		Kind:          kind,
-		PlanID:        planID.String,
-		ExternalID:    key,
-		Status:        status,
-		Meta:          meta,
-		CreatedAt:     createdAt.UTC(),
-		UpdatedAt:     updatedAt.UTC(),
-	}
-	if cancelled.Valid {
-		sub.CancelledAt = &cancelled.Time
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
-
 	return sub, nil
 }
 
@@ -932,7 +1021,25 @@ func (s *postgresStore) capabilities(ctx context.Context) (schemaCapabilities, e
     WHERE table_name = 'users'
       AND table_schema = ANY (current_schemas(false))
       AND column_name = 'permissions'
-  ) AS has_permissions`
+  ) AS has_permissions,
+  EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'users'
+      AND table_schema = ANY (current_schemas(false))
+      AND column_name = 'sync_secret'
+  ) AS has_sync_secret,
+  EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'users'
+      AND table_schema = ANY (current_schemas(false))
+      AND column_name = 'disabled'
+  ) AS has_disabled,
+  EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'users'
+      AND table_schema = ANY (current_schemas(false))
+      AND column_name = 'locale'
+  ) AS has_locale`
 
 	row := s.db.QueryRowContext(ctx, query)
 	var caps schemaCapabilities
@@ -947,6 +1054,9 @@ func (s *postgresStore) capabilities(ctx context.Context) (schemaCapabilities, e
 		&caps.hasRole,
 		&caps.hasGroups,
 		&caps.hasPermissions,
+		&caps.hasSyncSecret,
+		&caps.hasDisabled,
+		&caps.hasLocale,
 	); err != nil {
 		return schemaCapabilities{}, err
 	}
@@ -1007,8 +1117,23 @@ func (s *postgresStore) selectUserQuery(caps schemaCapabilities, whereClause str
 		permissionsExpr = "coalesce(permissions, '[]'::jsonb)"
 	}
 
-	return fmt.Sprintf(`SELECT uuid, username, email, email_verified, password, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s FROM users %s`,
-		secretExpr, enabledExpr, issuedExpr, confirmedExpr, createdExpr, updatedExpr, levelExpr, roleExpr, groupsExpr, permissionsExpr, whereClause)
+	syncSecretExpr := "NULL::text"
+	if caps.hasSyncSecret {
+		syncSecretExpr = "sync_secret"
+	}
+
+	disabledExpr := "false"
+	if caps.hasDisabled {
+		disabledExpr = "coalesce(disabled, false)"
+	}
+
+	localeExpr := "NULL::text"
+	if caps.hasLocale {
+		localeExpr = "locale"
+	}
+
+	return fmt.Sprintf(`SELECT uuid, username, email, email_verified, password, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s FROM users %s`,
+		secretExpr, enabledExpr, issuedExpr, confirmedExpr, createdExpr, updatedExpr, levelExpr, roleExpr, groupsExpr, permissionsExpr, syncSecretExpr, disabledExpr, localeExpr, whereClause)
 }
 
 func encodeStringSlice(values []string) ([]byte, error) {
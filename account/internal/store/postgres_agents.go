@@ -0,0 +1,316 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const agentColumns = `id, name, groups, token_hash, created_at, updated_at, revoked_at`
+
+const agentStatusColumns = `uuid, agent_id, healthy, report, reported_at`
+
+// CreateAgent inserts a new agent row.
+func (s *postgresStore) CreateAgent(ctx context.Context, agent *Agent) error {
+	if err := validateAgent(agent); err != nil {
+		return err
+	}
+	return insertAgent(ctx, s.db, agent)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertAgent(ctx context.Context, db queryRower, agent *Agent) error {
+	groups, err := encodeStringSlice(agent.Groups)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO agents (id, name, groups, token_hash)
+VALUES ($1, $2, $3::jsonb, $4)
+RETURNING ` + agentColumns
+
+	created, err := scanAgent(db.QueryRowContext(ctx, query, agent.ID, agent.Name, groups, agent.TokenHash))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return ErrAgentExists
+		}
+		return err
+	}
+	*agent = *created
+	return nil
+}
+
+// GetAgent returns the agent with the given ID.
+func (s *postgresStore) GetAgent(ctx context.Context, id string) (*Agent, error) {
+	const query = `SELECT ` + agentColumns + ` FROM agents WHERE id = $1`
+	return scanAgent(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+}
+
+// GetAgentByTokenHash returns the agent whose current token has the given
+// hash, including revoked agents.
+func (s *postgresStore) GetAgentByTokenHash(ctx context.Context, tokenHash string) (*Agent, error) {
+	const query = `SELECT ` + agentColumns + ` FROM agents WHERE token_hash = $1`
+	return scanAgent(s.db.QueryRowContext(ctx, query, strings.TrimSpace(tokenHash)))
+}
+
+// ListAgents returns all agents, including revoked ones, ordered by ID.
+func (s *postgresStore) ListAgents(ctx context.Context) ([]Agent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+agentColumns+` FROM agents ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := make([]Agent, 0)
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, *agent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+// UpdateAgentToken replaces the token hash of an active agent.
+func (s *postgresStore) UpdateAgentToken(ctx context.Context, id, tokenHash string) error {
+	tokenHash = strings.TrimSpace(tokenHash)
+	if tokenHash == "" {
+		return errors.New("agent token hash is required")
+	}
+
+	normalized := strings.TrimSpace(id)
+	result, err := s.db.ExecContext(ctx, `UPDATE agents SET token_hash = $2 WHERE id = $1 AND revoked_at IS NULL`, normalized, tokenHash)
+	if err != nil {
+		return err
+	}
+	if err := requireAffectedRow(result, ErrAgentRevoked); err != nil {
+		if _, getErr := s.GetAgent(ctx, normalized); getErr != nil {
+			return getErr
+		}
+		return err
+	}
+	return nil
+}
+
+// RevokeAgent marks the agent's credential as revoked. Revoking an already
+// revoked agent keeps the original revocation time.
+func (s *postgresStore) RevokeAgent(ctx context.Context, id string, revokedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE agents SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`, strings.TrimSpace(id), revokedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrAgentNotFound)
+}
+
+// CreateAgentEnrollment inserts a new enrollment code row.
+func (s *postgresStore) CreateAgentEnrollment(ctx context.Context, enrollment *AgentEnrollment) error {
+	if err := validateAgentEnrollment(enrollment); err != nil {
+		return err
+	}
+	groups, err := encodeStringSlice(enrollment.Groups)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO agent_enrollments (code_hash, agent_id, name, groups, created_by, expires_at)
+VALUES ($1, $2, $3, $4::jsonb, $5, $6)
+RETURNING uuid, created_at`
+
+	var idValue any
+	if err := s.db.QueryRowContext(ctx, query, enrollment.CodeHash, enrollment.AgentID, enrollment.Name, groups,
+		enrollment.CreatedBy, enrollment.ExpiresAt).Scan(&idValue, &enrollment.CreatedAt); err != nil {
+		return err
+	}
+	if enrollment.ID, err = formatIdentifier(idValue); err != nil {
+		return err
+	}
+	enrollment.CreatedAt = enrollment.CreatedAt.UTC()
+	enrollment.UsedAt = nil
+	return nil
+}
+
+// RedeemAgentEnrollment consumes the enrollment code with the given hash and
+// creates agent from it in a single transaction.
+func (s *postgresStore) RedeemAgentEnrollment(ctx context.Context, codeHash string, agent *Agent, now time.Time) (err error) {
+	if agent == nil {
+		return errors.New("agent is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var (
+		enrollment AgentEnrollment
+		groupsRaw  []byte
+		usedAt     sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `SELECT agent_id, name, groups, expires_at, used_at FROM agent_enrollments
+WHERE code_hash = $1 FOR UPDATE`, strings.TrimSpace(codeHash)).Scan(&enrollment.AgentID, &enrollment.Name, &groupsRaw, &enrollment.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrAgentEnrollmentInvalid
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if usedAt.Valid || !now.Before(enrollment.ExpiresAt) {
+		err = ErrAgentEnrollmentInvalid
+		return err
+	}
+	enrollment.Groups = decodeStringSlice(groupsRaw)
+
+	applyAgentEnrollment(agent, &enrollment)
+	if err = validateAgent(agent); err != nil {
+		return err
+	}
+	if err = insertAgent(ctx, tx, agent); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE agent_enrollments SET used_at = $2, used_by = $3 WHERE code_hash = $1`,
+		strings.TrimSpace(codeHash), now.UTC(), agent.ID); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	return err
+}
+
+// AppendAgentStatus records a status report of an agent.
+func (s *postgresStore) AppendAgentStatus(ctx context.Context, status *AgentStatus) error {
+	if status == nil {
+		return errors.New("agent status is required")
+	}
+	status.AgentID = strings.TrimSpace(status.AgentID)
+	if status.AgentID == "" {
+		return ErrAgentNotFound
+	}
+	if status.ReportedAt.IsZero() {
+		status.ReportedAt = time.Now()
+	}
+
+	const query = `INSERT INTO agent_status_history (agent_id, healthy, report, reported_at)
+VALUES ($1, $2, $3::jsonb, $4)
+RETURNING ` + agentStatusColumns
+
+	created, err := scanAgentStatus(s.db.QueryRowContext(ctx, query, status.AgentID, status.Healthy, status.Report, status.ReportedAt.UTC()))
+	if err != nil {
+		return err
+	}
+	*status = *created
+	return nil
+}
+
+// ListAgentStatuses returns up to limit reports of an agent, newest first.
+// A non-positive limit returns every report.
+func (s *postgresStore) ListAgentStatuses(ctx context.Context, agentID string, limit int) ([]AgentStatus, error) {
+	query := `SELECT ` + agentStatusColumns + ` FROM agent_status_history WHERE agent_id = $1 ORDER BY reported_at DESC`
+	args := []any{strings.TrimSpace(agentID)}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	return s.queryAgentStatuses(ctx, query, args...)
+}
+
+// LatestAgentStatuses returns the most recent report of every agent ordered
+// by agent ID.
+func (s *postgresStore) LatestAgentStatuses(ctx context.Context) ([]AgentStatus, error) {
+	const query = `SELECT DISTINCT ON (agent_id) ` + agentStatusColumns + ` FROM agent_status_history
+ORDER BY agent_id, reported_at DESC`
+	return s.queryAgentStatuses(ctx, query)
+}
+
+// PruneAgentStatuses deletes reports received before the cutoff, always
+// keeping the latest report of each agent.
+func (s *postgresStore) PruneAgentStatuses(ctx context.Context, before time.Time) (int, error) {
+	const query = `DELETE FROM agent_status_history h
+WHERE h.reported_at < $1
+  AND EXISTS (SELECT 1 FROM agent_status_history newer WHERE newer.agent_id = h.agent_id AND newer.reported_at > h.reported_at)`
+
+	result, err := s.db.ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func (s *postgresStore) queryAgentStatuses(ctx context.Context, query string, args ...any) ([]AgentStatus, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make([]AgentStatus, 0)
+	for rows.Next() {
+		status, err := scanAgentStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func scanAgent(row rowScanner) (*Agent, error) {
+	var (
+		agent     Agent
+		groupsRaw []byte
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(&agent.ID, &agent.Name, &groupsRaw, &agent.TokenHash, &agent.CreatedAt, &agent.UpdatedAt, &revokedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+	agent.Groups = decodeStringSlice(groupsRaw)
+	agent.CreatedAt = agent.CreatedAt.UTC()
+	agent.UpdatedAt = agent.UpdatedAt.UTC()
+	agent.RevokedAt = nullTimePointer(revokedAt)
+	return &agent, nil
+}
+
+func scanAgentStatus(row rowScanner) (*AgentStatus, error) {
+	var (
+		idValue any
+		status  AgentStatus
+	)
+	if err := row.Scan(&idValue, &status.AgentID, &status.Healthy, &status.Report, &status.ReportedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+	var err error
+	if status.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	status.ReportedAt = status.ReportedAt.UTC()
+	return &status, nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const auditEventColumns = `uuid, occurred_at, actor_id, subject_id, action, outcome, ip, user_agent, request_id, metadata`
+
+// AppendAuditEvent records an audit event. The audit_events table rejects
+// updates and deletes, so events cannot be altered once appended.
+func (s *postgresStore) AppendAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if err := validateAuditEvent(event); err != nil {
+		return err
+	}
+
+	if event.Metadata == nil {
+		event.Metadata = map[string]any{}
+	}
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO audit_events (occurred_at, actor_id, subject_id, action, outcome, ip, user_agent, request_id, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::jsonb)
+RETURNING uuid`
+
+	var idValue any
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		event.OccurredAt,
+		nullForEmpty(event.ActorID),
+		nullForEmpty(event.SubjectID),
+		event.Action,
+		event.Outcome,
+		nullForEmpty(event.IP),
+		nullForEmpty(event.UserAgent),
+		nullForEmpty(event.RequestID),
+		metadata,
+	).Scan(&idValue); err != nil {
+		return err
+	}
+
+	identifier, err := formatIdentifier(idValue)
+	if err != nil {
+		return err
+	}
+	event.ID = identifier
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching filter, newest first.
+func (s *postgresStore) ListAuditEvents(ctx context.Context, filter AuditEventFilter) (AuditEventPage, error) {
+	filter = normalizeAuditEventFilter(filter)
+
+	conditions := make([]string, 0, 5)
+	args := make([]any, 0, 7)
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		conditions = append(conditions, fmt.Sprintf("occurred_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until.UTC())
+		conditions = append(conditions, fmt.Sprintf("occurred_at < $%d", len(args)))
+	}
+	if filter.ActorID != "" {
+		args = append(args, filter.ActorID)
+		conditions = append(conditions, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	if filter.SubjectID != "" {
+		args = append(args, filter.SubjectID)
+		conditions = append(conditions, fmt.Sprintf("subject_id = $%d", len(args)))
+	}
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := AuditEventPage{Offset: filter.Offset, Limit: filter.Limit}
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&page.Total); err != nil {
+		return AuditEventPage{}, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf("SELECT %s FROM audit_events%s ORDER BY occurred_at DESC, uuid DESC LIMIT $%d OFFSET $%d",
+		auditEventColumns, where, len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	defer rows.Close()
+
+	page.Events = make([]AuditEvent, 0, filter.Limit)
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return AuditEventPage{}, err
+		}
+		page.Events = append(page.Events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		return AuditEventPage{}, err
+	}
+	return page, nil
+}
+
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	var (
+		idValue     any
+		event       AuditEvent
+		occurredAt  time.Time
+		actorID     sql.NullString
+		subjectID   sql.NullString
+		ip          sql.NullString
+		userAgent   sql.NullString
+		requestID   sql.NullString
+		metadataRaw []byte
+	)
+	if err := row.Scan(&idValue, &occurredAt, &actorID, &subjectID, &event.Action, &event.Outcome, &ip, &userAgent, &requestID, &metadataRaw); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if event.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	if event.Metadata, err = decodeSubscriptionMeta(metadataRaw); err != nil {
+		return nil, err
+	}
+	event.OccurredAt = occurredAt.UTC()
+	event.ActorID = actorID.String
+	event.SubjectID = subjectID.String
+	event.IP = ip.String
+	event.UserAgent = userAgent.String
+	event.RequestID = requestID.String
+	return &event, nil
+}
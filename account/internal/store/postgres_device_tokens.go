@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const deviceTokenColumns = `uuid, user_uuid, name, token_hash, scopes, created_at, last_used_at, expires_at`
+
+// CreateDeviceToken inserts a new device token row.
+func (s *postgresStore) CreateDeviceToken(ctx context.Context, token *DeviceToken) error {
+	if err := validateDeviceToken(token); err != nil {
+		return err
+	}
+
+	scopes, err := encodeStringSlice(token.Scopes)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt any
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.UTC()
+	}
+
+	const query = `INSERT INTO device_tokens (user_uuid, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4::jsonb, $5)
+RETURNING ` + deviceTokenColumns
+
+	row := s.db.QueryRowContext(ctx, query, token.UserID, token.Name, token.TokenHash, scopes, expiresAt)
+	created, err := scanDeviceToken(row)
+	if err != nil {
+		return err
+	}
+	*token = *created
+	return nil
+}
+
+// ListDeviceTokens returns the device tokens owned by a user, newest first.
+func (s *postgresStore) ListDeviceTokens(ctx context.Context, userID string) ([]DeviceToken, error) {
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	const query = `SELECT ` + deviceTokenColumns + ` FROM device_tokens WHERE user_uuid = $1 ORDER BY created_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, normalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]DeviceToken, 0)
+	for rows.Next() {
+		token, err := scanDeviceToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetDeviceTokenByHash looks up a device token by the hash of its secret.
+func (s *postgresStore) GetDeviceTokenByHash(ctx context.Context, tokenHash string) (*DeviceToken, error) {
+	normalized := strings.TrimSpace(tokenHash)
+	if normalized == "" {
+		return nil, ErrDeviceTokenNotFound
+	}
+
+	const query = `SELECT ` + deviceTokenColumns + ` FROM device_tokens WHERE token_hash = $1`
+	return scanDeviceToken(s.db.QueryRowContext(ctx, query, normalized))
+}
+
+// TouchDeviceToken records the time a device token was last used.
+func (s *postgresStore) TouchDeviceToken(ctx context.Context, id string, usedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE device_tokens SET last_used_at = $2 WHERE uuid = $1`, strings.TrimSpace(id), usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrDeviceTokenNotFound)
+}
+
+// DeleteDeviceToken revokes a device token owned by userID.
+func (s *postgresStore) DeleteDeviceToken(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM device_tokens WHERE uuid = $1 AND user_uuid = $2`, strings.TrimSpace(id), strings.TrimSpace(userID))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrDeviceTokenNotFound)
+}
+
+// DeleteUserDeviceTokens revokes every device token owned by userID and
+// reports how many were removed.
+func (s *postgresStore) DeleteUserDeviceTokens(ctx context.Context, userID string) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM device_tokens WHERE user_uuid = $1`, strings.TrimSpace(userID))
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
+
+func scanDeviceToken(row rowScanner) (*DeviceToken, error) {
+	var (
+		idValue     any
+		userIDValue any
+		token       DeviceToken
+		scopesRaw   []byte
+		lastUsedAt  sql.NullTime
+		expiresAt   sql.NullTime
+	)
+	if err := row.Scan(&idValue, &userIDValue, &token.Name, &token.TokenHash, &scopesRaw, &token.CreatedAt, &lastUsedAt, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDeviceTokenNotFound
+		}
+		return nil, err
+	}
+
+	var err error
+	if token.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = formatIdentifier(userIDValue); err != nil {
+		return nil, err
+	}
+	token.Scopes = decodeStringSlice(scopesRaw)
+	token.CreatedAt = token.CreatedAt.UTC()
+	if lastUsedAt.Valid {
+		used := lastUsedAt.Time.UTC()
+		token.LastUsedAt = &used
+	}
+	if expiresAt.Valid {
+		expires := expiresAt.Time.UTC()
+		token.ExpiresAt = &expires
+	}
+	return &token, nil
+}
+
+func requireAffectedRow(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const identityColumns = `uuid, user_uuid, provider, external_id, created_at, updated_at`
+
+// CreateIdentity inserts a new identity row.
+func (s *postgresStore) CreateIdentity(ctx context.Context, identity *Identity) error {
+	if err := validateIdentity(identity); err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO identities (user_uuid, provider, external_id)
+VALUES ($1, $2, $3)
+RETURNING ` + identityColumns
+
+	created, err := scanIdentity(s.db.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.ExternalID))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return ErrIdentityExists
+		}
+		return err
+	}
+	*identity = *created
+	return nil
+}
+
+// GetIdentity looks up the identity issued by provider for externalID.
+func (s *postgresStore) GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error) {
+	const query = `SELECT ` + identityColumns + ` FROM identities WHERE provider = $1 AND external_id = $2`
+	return scanIdentity(s.db.QueryRowContext(ctx, query, strings.ToLower(strings.TrimSpace(provider)), strings.TrimSpace(externalID)))
+}
+
+// ListIdentitiesByUser returns the identities linked to a user, oldest first.
+func (s *postgresStore) ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error) {
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	const query = `SELECT ` + identityColumns + ` FROM identities WHERE user_uuid = $1 ORDER BY created_at ASC, uuid ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, normalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]Identity, 0)
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// DeleteIdentity unlinks an identity owned by userID.
+func (s *postgresStore) DeleteIdentity(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM identities WHERE uuid = $1 AND user_uuid = $2`, strings.TrimSpace(id), strings.TrimSpace(userID))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrIdentityNotFound)
+}
+
+func scanIdentity(row rowScanner) (*Identity, error) {
+	var (
+		idValue     any
+		userIDValue any
+		identity    Identity
+	)
+	if err := row.Scan(&idValue, &userIDValue, &identity.Provider, &identity.ExternalID, &identity.CreatedAt, &identity.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+
+	var err error
+	if identity.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	if identity.UserID, err = formatIdentifier(userIDValue); err != nil {
+		return nil, err
+	}
+	identity.CreatedAt = identity.CreatedAt.UTC()
+	identity.UpdatedAt = identity.UpdatedAt.UTC()
+	return &identity, nil
+}
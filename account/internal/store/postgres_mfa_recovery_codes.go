@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ReplaceMFARecoveryCodes discards every recovery code of a user and stores
+// codeHashes in their place within a single transaction.
+func (s *postgresStore) ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) (err error) {
+	normalizedUserID := strings.TrimSpace(userID)
+	if normalizedUserID == "" {
+		return ErrUserNotFound
+	}
+	hashes, err := normalizeRecoveryCodeHashes(codeHashes)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_uuid = $1`, normalizedUserID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_uuid, code_hash) VALUES ($1, $2)`, normalizedUserID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ConsumeMFARecoveryCode redeems an unused recovery code of a user. The
+// conditional update keeps concurrent sign ins from redeeming a code twice.
+func (s *postgresStore) ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash string, usedAt time.Time) error {
+	const query = `UPDATE mfa_recovery_codes SET used_at = $3
+WHERE user_uuid = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(userID), strings.TrimSpace(codeHash), usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrMFARecoveryCodeNotFound)
+}
+
+// CountMFARecoveryCodes returns how many unused recovery codes a user has.
+func (s *postgresStore) CountMFARecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var remaining int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_uuid = $1 AND used_at IS NULL`,
+		strings.TrimSpace(userID)).Scan(&remaining)
+	return remaining, err
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const outboundEmailColumns = `uuid, recipients, subject, plain_body, html_body, attempts, next_attempt_at, last_error, dead_lettered_at, created_at, updated_at`
+
+// EnqueueOutboundEmail inserts a message into the mail queue.
+func (s *postgresStore) EnqueueOutboundEmail(ctx context.Context, email *OutboundEmail) error {
+	if err := validateOutboundEmail(email); err != nil {
+		return err
+	}
+	recipients, err := encodeStringSlice(email.Recipients)
+	if err != nil {
+		return err
+	}
+	var nextAttemptAt any
+	if !email.NextAttemptAt.IsZero() {
+		nextAttemptAt = email.NextAttemptAt.UTC()
+	}
+
+	const query = `INSERT INTO outbound_emails (recipients, subject, plain_body, html_body, next_attempt_at)
+VALUES ($1, $2, $3, $4, coalesce($5, now()))
+RETURNING ` + outboundEmailColumns
+
+	created, err := scanOutboundEmail(s.db.QueryRowContext(ctx, query, recipients, email.Subject, email.PlainBody, email.HTMLBody, nextAttemptAt))
+	if err != nil {
+		return err
+	}
+	*email = *created
+	return nil
+}
+
+// ClaimOutboundEmails leases due messages. SKIP LOCKED lets several service
+// replicas drain the same queue without delivering a message twice.
+func (s *postgresStore) ClaimOutboundEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboundEmail, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	const query = `UPDATE outbound_emails SET next_attempt_at = $2
+WHERE uuid IN (
+  SELECT uuid FROM outbound_emails
+  WHERE dead_lettered_at IS NULL AND next_attempt_at <= $1
+  ORDER BY next_attempt_at ASC, created_at ASC
+  LIMIT $3
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + outboundEmailColumns
+
+	rows, err := s.db.QueryContext(ctx, query, now.UTC(), now.Add(lease).UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make([]OutboundEmail, 0)
+	for rows.Next() {
+		email, err := scanOutboundEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, *email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// DeleteOutboundEmail removes a delivered message.
+func (s *postgresStore) DeleteOutboundEmail(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM outbound_emails WHERE uuid = $1`, strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrOutboundEmailNotFound)
+}
+
+// RescheduleOutboundEmail records a failed attempt and the time of the next.
+func (s *postgresStore) RescheduleOutboundEmail(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	const query = `UPDATE outbound_emails SET attempts = $2, next_attempt_at = $3, last_error = $4 WHERE uuid = $1`
+
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(id), attempts, nextAttemptAt.UTC(), lastError)
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrOutboundEmailNotFound)
+}
+
+// DeadLetterOutboundEmail gives up on a message and blanks its bodies.
+func (s *postgresStore) DeadLetterOutboundEmail(ctx context.Context, id string, attempts int, lastError string, deadLetteredAt time.Time) error {
+	const query = `UPDATE outbound_emails
+SET attempts = $2, last_error = $3, dead_lettered_at = $4, plain_body = '', html_body = ''
+WHERE uuid = $1`
+
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(id), attempts, lastError, deadLetteredAt.UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrOutboundEmailNotFound)
+}
+
+// OutboundEmailStats counts pending and dead-lettered messages.
+func (s *postgresStore) OutboundEmailStats(ctx context.Context) (OutboundEmailStats, error) {
+	const query = `SELECT
+  COUNT(*) FILTER (WHERE dead_lettered_at IS NULL),
+  COUNT(*) FILTER (WHERE dead_lettered_at IS NOT NULL)
+FROM outbound_emails`
+
+	var stats OutboundEmailStats
+	err := s.db.QueryRowContext(ctx, query).Scan(&stats.Pending, &stats.DeadLettered)
+	return stats, err
+}
+
+func scanOutboundEmail(row rowScanner) (*OutboundEmail, error) {
+	var (
+		idValue        any
+		recipientsRaw  []byte
+		email          OutboundEmail
+		lastError      sql.NullString
+		deadLetteredAt sql.NullTime
+	)
+	if err := row.Scan(&idValue, &recipientsRaw, &email.Subject, &email.PlainBody, &email.HTMLBody, &email.Attempts,
+		&email.NextAttemptAt, &lastError, &deadLetteredAt, &email.CreatedAt, &email.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOutboundEmailNotFound
+		}
+		return nil, err
+	}
+
+	identifier, err := formatIdentifier(idValue)
+	if err != nil {
+		return nil, err
+	}
+	email.ID = identifier
+	email.Recipients = decodeStringSlice(recipientsRaw)
+	email.LastError = lastError.String
+	email.NextAttemptAt = email.NextAttemptAt.UTC()
+	email.CreatedAt = email.CreatedAt.UTC()
+	email.UpdatedAt = email.UpdatedAt.UTC()
+	if deadLetteredAt.Valid {
+		at := deadLetteredAt.Time.UTC()
+		email.DeadLetteredAt = &at
+	}
+	return &email, nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const paymentEventColumns = `uuid, provider, event_id, event_type, payload, received_at, processing_at, processed_at, result, error`
+
+// RecordPaymentEvent inserts a received event, claimed for processing by the
+// caller. The unique (provider, event_id) constraint makes concurrent
+// deliveries of the same event record it only once.
+func (s *postgresStore) RecordPaymentEvent(ctx context.Context, event *PaymentEvent) error {
+	if err := validatePaymentEvent(event); err != nil {
+		return err
+	}
+
+	const insert = `INSERT INTO payment_events (provider, event_id, event_type, payload, processing_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING ` + paymentEventColumns
+
+	stored, err := scanPaymentEvent(s.db.QueryRowContext(ctx, insert, event.Provider, event.EventID, event.Type, event.Payload))
+	if err == nil {
+		*event = *stored
+		return nil
+	}
+	if !errors.Is(err, ErrPaymentEventNotFound) {
+		return err
+	}
+
+	const query = `SELECT ` + paymentEventColumns + ` FROM payment_events WHERE provider = $1 AND event_id = $2`
+	stored, err = scanPaymentEvent(s.db.QueryRowContext(ctx, query, event.Provider, event.EventID))
+	if err != nil {
+		return err
+	}
+	*event = *stored
+	return ErrPaymentEventExists
+}
+
+// ClaimPaymentEvent marks an already recorded event as being processed by
+// the caller. The conditional update lets only one of several concurrent
+// deliveries claim it; ErrPaymentEventClaimed is returned when it was
+// processed or another delivery claimed it less than lease ago.
+func (s *postgresStore) ClaimPaymentEvent(ctx context.Context, id string, claimedAt time.Time, lease time.Duration) error {
+	const query = `UPDATE payment_events SET processing_at = $2
+WHERE uuid = $1
+  AND (processed_at IS NULL OR result = $4)
+  AND (processing_at IS NULL OR processing_at < $3)`
+
+	normalized := strings.TrimSpace(id)
+	claimedAt = claimedAt.UTC()
+	res, err := s.db.ExecContext(ctx, query, normalized, claimedAt, claimedAt.Add(-lease), PaymentEventResultFailed)
+	if err != nil {
+		return err
+	}
+	if err := requireAffectedRow(res, ErrPaymentEventClaimed); !errors.Is(err, ErrPaymentEventClaimed) {
+		return err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM payment_events WHERE uuid = $1)`, normalized).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPaymentEventNotFound
+	}
+	return ErrPaymentEventClaimed
+}
+
+// CompletePaymentEvent records the outcome of processing an event and
+// releases its claim.
+func (s *postgresStore) CompletePaymentEvent(ctx context.Context, id string, processedAt time.Time, result, processingError string) error {
+	const query = `UPDATE payment_events SET processing_at = NULL, processed_at = $2, result = $3, error = $4 WHERE uuid = $1`
+
+	res, err := s.db.ExecContext(ctx, query, strings.TrimSpace(id), processedAt.UTC(), result, nullForEmpty(processingError))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(res, ErrPaymentEventNotFound)
+}
+
+func scanPaymentEvent(row rowScanner) (*PaymentEvent, error) {
+	var (
+		idValue      any
+		event        PaymentEvent
+		processingAt sql.NullTime
+		processedAt  sql.NullTime
+		result       sql.NullString
+		lastError    sql.NullString
+	)
+	if err := row.Scan(&idValue, &event.Provider, &event.EventID, &event.Type, &event.Payload, &event.ReceivedAt,
+		&processingAt, &processedAt, &result, &lastError); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPaymentEventNotFound
+		}
+		return nil, err
+	}
+
+	identifier, err := formatIdentifier(idValue)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = identifier
+	event.ReceivedAt = event.ReceivedAt.UTC()
+	event.ProcessingAt = nullTimePointer(processingAt)
+	event.ProcessedAt = nullTimePointer(processedAt)
+	event.Result = result.String
+	event.Error = lastError.String
+	return &event, nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const refreshTokenColumns = `uuid, family_uuid, user_id, email, roles, created_at, expires_at, used_at, revoked_at`
+
+// CreateRefreshToken records a newly issued refresh token.
+func (s *postgresStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	if err := validateRefreshToken(token); err != nil {
+		return err
+	}
+
+	roles, err := encodeStringSlice(token.Roles)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO refresh_tokens (uuid, family_uuid, user_id, email, roles, expires_at)
+VALUES ($1, $2, $3, $4, $5::jsonb, $6)
+RETURNING created_at`
+
+	var createdAt time.Time
+	if err := s.db.QueryRowContext(ctx, query, token.ID, token.FamilyID, token.UserID, nullForEmpty(token.Email), roles, token.ExpiresAt.UTC()).Scan(&createdAt); err != nil {
+		return err
+	}
+	token.CreatedAt = createdAt.UTC()
+	return nil
+}
+
+// ConsumeRefreshToken marks a refresh token as used. The update only succeeds
+// for tokens that are neither used nor revoked so concurrent refreshes with
+// the same token cannot both win.
+func (s *postgresStore) ConsumeRefreshToken(ctx context.Context, id string, usedAt time.Time) (*RefreshToken, error) {
+	normalized := strings.TrimSpace(id)
+
+	const consume = `UPDATE refresh_tokens SET used_at = $2
+WHERE uuid = $1 AND used_at IS NULL AND revoked_at IS NULL
+RETURNING ` + refreshTokenColumns
+
+	token, err := scanRefreshToken(s.db.QueryRowContext(ctx, consume, normalized, usedAt.UTC()))
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil, err
+	}
+
+	const lookup = `SELECT ` + refreshTokenColumns + ` FROM refresh_tokens WHERE uuid = $1`
+	token, err = scanRefreshToken(s.db.QueryRowContext(ctx, lookup, normalized))
+	if err != nil {
+		return nil, err
+	}
+	if token.RevokedAt != nil {
+		return token, ErrRefreshTokenRevoked
+	}
+	return token, ErrRefreshTokenReused
+}
+
+// RevokeRefreshTokenFamily revokes every token that belongs to familyID.
+func (s *postgresStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	const query = `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_uuid = $1 AND revoked_at IS NULL`
+	_, err := s.db.ExecContext(ctx, query, strings.TrimSpace(familyID), revokedAt.UTC())
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token family of userID.
+func (s *postgresStore) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	const query = `UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := s.db.ExecContext(ctx, query, strings.TrimSpace(userID), revokedAt.UTC())
+	return err
+}
+
+// PruneRefreshTokens deletes tokens that expired or were revoked before the
+// cutoff. Used tokens are kept until they expire so that presenting one again
+// is still detected as reuse.
+func (s *postgresStore) PruneRefreshTokens(ctx context.Context, before time.Time) (int, error) {
+	const query = `DELETE FROM refresh_tokens WHERE expires_at < $1 OR revoked_at < $1`
+	result, err := s.db.ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func scanRefreshToken(row rowScanner) (*RefreshToken, error) {
+	var (
+		idValue     any
+		familyValue any
+		token       RefreshToken
+		email       sql.NullString
+		rolesRaw    []byte
+		usedAt      sql.NullTime
+		revokedAt   sql.NullTime
+	)
+	if err := row.Scan(&idValue, &familyValue, &token.UserID, &email, &rolesRaw, &token.CreatedAt, &token.ExpiresAt, &usedAt, &revokedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+
+	var err error
+	if token.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	if token.FamilyID, err = formatIdentifier(familyValue); err != nil {
+		return nil, err
+	}
+	token.Email = email.String
+	token.Roles = decodeStringSlice(rolesRaw)
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if usedAt.Valid {
+		used := usedAt.Time.UTC()
+		token.UsedAt = &used
+	}
+	if revokedAt.Valid {
+		revoked := revokedAt.Time.UTC()
+		token.RevokedAt = &revoked
+	}
+	return &token, nil
+}
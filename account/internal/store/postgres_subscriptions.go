@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const subscriptionColumns = `uuid, user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, current_period_start, current_period_end, provider_updated_at, created_at, updated_at, cancelled_at`
+
+// ExpireSubscriptions flips entitled subscriptions whose period has lapsed to
+// expired in a single statement so concurrent sweeps never expire a row twice.
+func (s *postgresStore) ExpireSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error) {
+	const query = `UPDATE subscriptions SET status = 'expired', updated_at = now()
+WHERE status IN ('trialing', 'active', 'past_due') AND current_period_end IS NOT NULL AND current_period_end <= $1
+RETURNING ` + subscriptionColumns
+
+	rows, err := s.db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expired := []Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, *sub)
+	}
+	return expired, rows.Err()
+}
+
+// FindSubscriptionByExternalID returns the most recently updated subscription
+// a provider knows under externalID, regardless of the owning user.
+func (s *postgresStore) FindSubscriptionByExternalID(ctx context.Context, provider, externalID string) (*Subscription, error) {
+	const query = `SELECT ` + subscriptionColumns + `
+FROM subscriptions WHERE provider = $1 AND external_id = $2
+ORDER BY updated_at DESC LIMIT 1`
+
+	return scanSubscription(s.db.QueryRowContext(ctx, query, strings.TrimSpace(provider), strings.TrimSpace(externalID)))
+}
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var (
+		idValue     any
+		userIDValue any
+		sub         Subscription
+		planID      sql.NullString
+		paymentQR   sql.NullString
+		metaBytes   []byte
+		periodStart sql.NullTime
+		periodEnd   sql.NullTime
+		providerAt  sql.NullTime
+		cancelled   sql.NullTime
+	)
+	if err := row.Scan(&idValue, &userIDValue, &sub.Provider, &sub.PaymentMethod, &sub.Kind, &planID, &sub.ExternalID,
+		&sub.Status, &paymentQR, &metaBytes, &periodStart, &periodEnd, &providerAt, &sub.CreatedAt, &sub.UpdatedAt, &cancelled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, err
+	}
+
+	identifier, err := formatIdentifier(idValue)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := formatIdentifier(userIDValue)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := decodeSubscriptionMeta(metaBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.ID = identifier
+	sub.UserID = userID
+	sub.PlanID = planID.String
+	sub.PaymentQRCode = paymentQR.String
+	sub.Meta = meta
+	sub.CurrentPeriodStart = nullTimePointer(periodStart)
+	sub.CurrentPeriodEnd = nullTimePointer(periodEnd)
+	sub.ProviderUpdatedAt = nullTimePointer(providerAt)
+	sub.CancelledAt = nullTimePointer(cancelled)
+	sub.CreatedAt = sub.CreatedAt.UTC()
+	sub.UpdatedAt = sub.UpdatedAt.UTC()
+	return &sub, nil
+}
+
+func nullableTime(value *time.Time) any {
+	if value == nil {
+		return nil
+	}
+	return value.UTC()
+}
+
+func nullTimePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	at := value.Time.UTC()
+	return &at
+}
@@ -75,6 +75,16 @@ func TestSelectUserQuery(t *testing.T) {
 			},
 			want: "mfa_totp_secret",
 		},
+		{
+			name: "with disabled column",
+			caps: schemaCapabilities{hasDisabled: true},
+			want: "coalesce(disabled, false)",
+		},
+		{
+			name: "with locale column",
+			caps: schemaCapabilities{hasLocale: true},
+			want: "false, locale FROM users",
+		},
 	}
 
 	for _, tc := range tests {
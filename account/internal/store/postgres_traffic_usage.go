@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const trafficUsageColumns = `user_uuid, day, uplink, downlink, updated_at`
+
+// AddTrafficUsage adds the counters of entries to the per-day totals of their
+// users in a single transaction. Entries for unknown users are dropped.
+func (s *postgresStore) AddTrafficUsage(ctx context.Context, entries []TrafficUsage) (err error) {
+	normalized, err := normalizeTrafficUsage(entries)
+	if err != nil || len(normalized) == 0 {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = addTrafficUsageTx(ctx, tx, normalized); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddReportedTrafficUsage adds the entries of a usage report once per agent
+// and report ID. It reports false without changing the totals when the report
+// was applied before. Report IDs older than UsageReportRetention are pruned.
+func (s *postgresStore) AddReportedTrafficUsage(ctx context.Context, agentID, reportID string, entries []TrafficUsage) (applied bool, err error) {
+	agentID = strings.TrimSpace(agentID)
+	reportID = strings.TrimSpace(reportID)
+	if agentID == "" || reportID == "" {
+		return false, ErrInvalidTrafficUsage
+	}
+	normalized, err := normalizeTrafficUsage(entries)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !applied {
+			_ = tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO usage_reports (agent_id, report_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, agentID, reportID)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return false, err
+	}
+	if err = addTrafficUsageTx(ctx, tx, normalized); err != nil {
+		return false, err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM usage_reports WHERE received_at < $1`, time.Now().Add(-UsageReportRetention).UTC()); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func addTrafficUsageTx(ctx context.Context, tx *sql.Tx, normalized []TrafficUsage) error {
+	const query = `INSERT INTO traffic_usage (user_uuid, day, uplink, downlink)
+SELECT users.uuid, $2, $3, $4 FROM users WHERE users.uuid::text = $1
+ON CONFLICT (user_uuid, day) DO UPDATE SET
+  uplink = traffic_usage.uplink + EXCLUDED.uplink,
+  downlink = traffic_usage.downlink + EXCLUDED.downlink,
+  updated_at = now()`
+
+	for _, entry := range normalized {
+		if _, err := tx.ExecContext(ctx, query, entry.UserID, entry.Day, entry.Uplink, entry.Downlink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTrafficUsage returns daily usage ordered by day and user.
+func (s *postgresStore) ListTrafficUsage(ctx context.Context, filter TrafficUsageFilter) ([]TrafficUsage, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	if userID := strings.TrimSpace(filter.UserID); userID != "" {
+		args = append(args, userID)
+		conditions = append(conditions, fmt.Sprintf("user_uuid::text = $%d", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, UsageDay(filter.Since))
+		conditions = append(conditions, fmt.Sprintf("day >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, UsageDay(filter.Until))
+		conditions = append(conditions, fmt.Sprintf("day < $%d", len(args)))
+	}
+
+	query := `SELECT ` + trafficUsageColumns + ` FROM traffic_usage`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY day ASC, user_uuid ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make([]TrafficUsage, 0)
+	for rows.Next() {
+		entry, err := scanTrafficUsage(rows)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, *entry)
+	}
+	return usage, rows.Err()
+}
+
+func scanTrafficUsage(row rowScanner) (*TrafficUsage, error) {
+	var (
+		userValue any
+		day       time.Time
+		updatedAt sql.NullTime
+		usage     TrafficUsage
+	)
+	if err := row.Scan(&userValue, &day, &usage.Uplink, &usage.Downlink, &updatedAt); err != nil {
+		return nil, err
+	}
+	userID, err := formatIdentifier(userValue)
+	if err != nil {
+		return nil, err
+	}
+	usage.UserID = userID
+	usage.Day = UsageDay(day)
+	if updatedAt.Valid {
+		usage.UpdatedAt = updatedAt.Time.UTC()
+	}
+	return &usage, nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const webAuthnCredentialColumns = `uuid, user_uuid, name, credential_id, public_key, sign_count, aaguid, transports, created_at, last_used_at`
+
+// CreateWebAuthnCredential inserts a new WebAuthn credential row.
+func (s *postgresStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	if err := validateWebAuthnCredential(credential); err != nil {
+		return err
+	}
+
+	transports, err := encodeStringSlice(credential.Transports)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO webauthn_credentials (user_uuid, name, credential_id, public_key, sign_count, aaguid, transports)
+VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb)
+RETURNING ` + webAuthnCredentialColumns
+
+	row := s.db.QueryRowContext(ctx, query, credential.UserID, credential.Name, credential.CredentialID,
+		credential.PublicKey, int64(credential.SignCount), credential.AAGUID, transports)
+	created, err := scanWebAuthnCredential(row)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return ErrWebAuthnCredentialExists
+		}
+		return err
+	}
+	*credential = *created
+	return nil
+}
+
+// ListWebAuthnCredentials returns the credentials registered by a user,
+// oldest first.
+func (s *postgresStore) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	const query = `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE user_uuid = $1 ORDER BY created_at ASC, uuid ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, normalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]WebAuthnCredential, 0)
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// GetWebAuthnCredentialByCredentialID looks up a credential by the ID chosen
+// by the authenticator.
+func (s *postgresStore) GetWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	if len(credentialID) == 0 {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+
+	const query = `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE credential_id = $1`
+	return scanWebAuthnCredential(s.db.QueryRowContext(ctx, query, credentialID))
+}
+
+// UpdateWebAuthnCredentialUsage records a successful assertion.
+func (s *postgresStore) UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count = $2, last_used_at = $3 WHERE uuid = $1`,
+		strings.TrimSpace(id), int64(signCount), usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrWebAuthnCredentialNotFound)
+}
+
+// RenameWebAuthnCredential changes the display name of a credential owned by
+// userID.
+func (s *postgresStore) RenameWebAuthnCredential(ctx context.Context, userID, id, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("webauthn credential name is required")
+	}
+	result, err := s.db.ExecContext(ctx, `UPDATE webauthn_credentials SET name = $3 WHERE uuid = $1 AND user_uuid = $2`,
+		strings.TrimSpace(id), strings.TrimSpace(userID), name)
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrWebAuthnCredentialNotFound)
+}
+
+// DeleteWebAuthnCredential removes a credential owned by userID.
+func (s *postgresStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE uuid = $1 AND user_uuid = $2`, strings.TrimSpace(id), strings.TrimSpace(userID))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrWebAuthnCredentialNotFound)
+}
+
+func scanWebAuthnCredential(row rowScanner) (*WebAuthnCredential, error) {
+	var (
+		idValue       any
+		userIDValue   any
+		credential    WebAuthnCredential
+		signCount     int64
+		transportsRaw []byte
+		lastUsedAt    sql.NullTime
+	)
+	if err := row.Scan(&idValue, &userIDValue, &credential.Name, &credential.CredentialID, &credential.PublicKey,
+		&signCount, &credential.AAGUID, &transportsRaw, &credential.CreatedAt, &lastUsedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, err
+	}
+
+	var err error
+	if credential.ID, err = formatIdentifier(idValue); err != nil {
+		return nil, err
+	}
+	if credential.UserID, err = formatIdentifier(userIDValue); err != nil {
+		return nil, err
+	}
+	credential.SignCount = uint32(signCount)
+	credential.Transports = decodeStringSlice(transportsRaw)
+	credential.CreatedAt = credential.CreatedAt.UTC()
+	if lastUsedAt.Valid {
+		used := lastUsedAt.Time.UTC()
+		credential.LastUsedAt = &used
+	}
+	return &credential, nil
+}
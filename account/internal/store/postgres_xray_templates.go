@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const xrayTemplateColumns = `name, content, agent_ids, groups, variables, created_at, updated_at`
+
+// SaveXrayTemplate creates the template or replaces the template with the
+// same name.
+func (s *postgresStore) SaveXrayTemplate(ctx context.Context, template *XrayTemplate) error {
+	if err := validateXrayTemplate(template); err != nil {
+		return err
+	}
+	agentIDs, err := encodeStringSlice(template.AgentIDs)
+	if err != nil {
+		return err
+	}
+	groups, err := encodeStringSlice(template.Groups)
+	if err != nil {
+		return err
+	}
+	variables, err := encodeStringMap(template.Variables)
+	if err != nil {
+		return err
+	}
+
+	const query = `INSERT INTO xray_templates (name, content, agent_ids, groups, variables)
+VALUES ($1, $2, $3::jsonb, $4::jsonb, $5::jsonb)
+ON CONFLICT (name) DO UPDATE SET content = EXCLUDED.content, agent_ids = EXCLUDED.agent_ids,
+  groups = EXCLUDED.groups, variables = EXCLUDED.variables
+RETURNING ` + xrayTemplateColumns
+
+	saved, err := scanXrayTemplate(s.db.QueryRowContext(ctx, query, template.Name, string(template.Content), agentIDs, groups, variables))
+	if err != nil {
+		return err
+	}
+	*template = *saved
+	return nil
+}
+
+// ListXrayTemplates returns every template ordered by name.
+func (s *postgresStore) ListXrayTemplates(ctx context.Context) ([]XrayTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+xrayTemplateColumns+` FROM xray_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]XrayTemplate, 0)
+	for rows.Next() {
+		template, err := scanXrayTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// DeleteXrayTemplate removes the template with the given name.
+func (s *postgresStore) DeleteXrayTemplate(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM xray_templates WHERE name = $1`, strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	return requireAffectedRow(result, ErrXrayTemplateNotFound)
+}
+
+func encodeStringMap(values map[string]string) ([]byte, error) {
+	if len(values) == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(values)
+}
+
+func scanXrayTemplate(row rowScanner) (*XrayTemplate, error) {
+	var (
+		template     XrayTemplate
+		content      string
+		agentIDsRaw  []byte
+		groupsRaw    []byte
+		variablesRaw []byte
+	)
+	if err := row.Scan(&template.Name, &content, &agentIDsRaw, &groupsRaw, &variablesRaw, &template.CreatedAt, &template.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrXrayTemplateNotFound
+		}
+		return nil, err
+	}
+	template.Content = []byte(content)
+	template.AgentIDs = decodeStringSlice(agentIDsRaw)
+	template.Groups = decodeStringSlice(groupsRaw)
+	if len(variablesRaw) > 0 {
+		if err := json.Unmarshal(variablesRaw, &template.Variables); err != nil {
+			return nil, err
+		}
+	}
+	template.CreatedAt = template.CreatedAt.UTC()
+	template.UpdatedAt = template.UpdatedAt.UTC()
+	return &template, nil
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors returned when consuming refresh tokens.
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+	ErrRefreshTokenRevoked  = errors.New("refresh token has been revoked")
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Tokens
+// produced by rotating one another share a FamilyID so that a compromised
+// family can be revoked at once.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	UserID    string
+	Email     string
+	Roles     []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func cloneRefreshToken(token *RefreshToken) *RefreshToken {
+	if token == nil {
+		return nil
+	}
+	clone := *token
+	clone.Roles = cloneStringSlice(token.Roles)
+	if token.UsedAt != nil {
+		used := *token.UsedAt
+		clone.UsedAt = &used
+	}
+	if token.RevokedAt != nil {
+		revoked := *token.RevokedAt
+		clone.RevokedAt = &revoked
+	}
+	return &clone
+}
+
+func validateRefreshToken(token *RefreshToken) error {
+	if token == nil {
+		return errors.New("refresh token is required")
+	}
+	token.ID = strings.TrimSpace(token.ID)
+	token.FamilyID = strings.TrimSpace(token.FamilyID)
+	if token.ID == "" || token.FamilyID == "" {
+		return errors.New("refresh token id and family are required")
+	}
+	token.UserID = strings.TrimSpace(token.UserID)
+	token.Roles = normalizeStringSlice(token.Roles)
+	return nil
+}
+
+// CreateRefreshToken records a newly issued refresh token.
+func (s *memoryStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	_ = ctx
+	if err := validateRefreshToken(token); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now().UTC()
+	}
+	s.refreshTokens[token.ID] = cloneRefreshToken(token)
+	return nil
+}
+
+// ConsumeRefreshToken marks a refresh token as used. Tokens that were already
+// used or revoked are reported with ErrRefreshTokenReused and
+// ErrRefreshTokenRevoked respectively, alongside the stored record.
+func (s *memoryStore) ConsumeRefreshToken(ctx context.Context, id string, usedAt time.Time) (*RefreshToken, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		return cloneRefreshToken(token), ErrRefreshTokenRevoked
+	}
+	if token.UsedAt != nil {
+		return cloneRefreshToken(token), ErrRefreshTokenReused
+	}
+	used := usedAt.UTC()
+	token.UsedAt = &used
+	return cloneRefreshToken(token), nil
+}
+
+// RevokeRefreshTokenFamily revokes every token that belongs to familyID.
+func (s *memoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	family := strings.TrimSpace(familyID)
+	revoked := revokedAt.UTC()
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == family && token.RevokedAt == nil {
+			stamp := revoked
+			token.RevokedAt = &stamp
+		}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token family of userID.
+func (s *memoryStore) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(userID)
+	revoked := revokedAt.UTC()
+	for _, token := range s.refreshTokens {
+		if token.UserID == normalized && token.RevokedAt == nil {
+			stamp := revoked
+			token.RevokedAt = &stamp
+		}
+	}
+	return nil
+}
+
+// PruneRefreshTokens deletes tokens that expired or were revoked before the
+// cutoff. Used tokens are kept until they expire so that presenting one again
+// is still detected as reuse.
+func (s *memoryStore) PruneRefreshTokens(ctx context.Context, before time.Time) (int, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for id, token := range s.refreshTokens {
+		if token.ExpiresAt.Before(before) || (token.RevokedAt != nil && token.RevokedAt.Before(before)) {
+			delete(s.refreshTokens, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -26,8 +26,58 @@ type User struct {
 	MFAEnabled        bool
 	MFASecretIssuedAt time.Time
 	MFAConfirmedAt    time.Time
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
+	// SyncSecret encrypts desktop configuration sync payloads. When empty the
+	// user ID is used instead.
+	SyncSecret string
+	// Disabled accounts cannot sign in. It is only changed through
+	// SetUserDisabled; UpdateUser leaves it untouched.
+	Disabled bool
+	// Locale is the preferred language of transactional emails, such as
+	// "en" or "zh-CN". Empty means no preference.
+	Locale    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UserListOptions filters and paginates ListUsers.
+type UserListOptions struct {
+	// Query matches a case-insensitive substring of the name or email.
+	Query string
+	// Role restricts results to a single role when set.
+	Role string
+	// Disabled restricts results to disabled or enabled users when set.
+	Disabled *bool
+	Offset   int
+	Limit    int
+}
+
+// UserPage is a page of users along with the total number of matches and
+// the effective pagination window.
+type UserPage struct {
+	Users  []User
+	Total  int
+	Offset int
+	Limit  int
+}
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+func normalizeUserListOptions(opts UserListOptions) UserListOptions {
+	opts.Query = strings.ToLower(strings.TrimSpace(opts.Query))
+	opts.Role = strings.ToLower(strings.TrimSpace(opts.Role))
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultUserListLimit
+	}
+	if opts.Limit > maxUserListLimit {
+		opts.Limit = maxUserListLimit
+	}
+	return opts
 }
 
 // Subscription represents a recurring or usage-based billing relationship.
@@ -42,9 +92,16 @@ type Subscription struct {
 	ExternalID    string
 	Status        string
 	Meta          map[string]any
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	CancelledAt   *time.Time
+	// CurrentPeriodStart and CurrentPeriodEnd bound the paid or trial period.
+	// A nil end means the subscription does not lapse on its own.
+	CurrentPeriodStart *time.Time
+	CurrentPeriodEnd   *time.Time
+	// ProviderUpdatedAt is when the payment provider event last applied to
+	// the subscription occurred. Older events are stale and are dropped.
+	ProviderUpdatedAt *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	CancelledAt       *time.Time
 }
 
 // Store provides persistence operations for users.
@@ -54,10 +111,79 @@ type Store interface {
 	GetUserByID(ctx context.Context, id string) (*User, error)
 	GetUserByName(ctx context.Context, name string) (*User, error)
 	UpdateUser(ctx context.Context, user *User) error
+	ListUsers(ctx context.Context, opts UserListOptions) (UserPage, error)
+	SetUserDisabled(ctx context.Context, id string, disabled bool) error
+	DeleteUser(ctx context.Context, id string) error
 
 	UpsertSubscription(ctx context.Context, subscription *Subscription) error
 	ListSubscriptionsByUser(ctx context.Context, userID string) ([]Subscription, error)
 	CancelSubscription(ctx context.Context, userID, externalID string, cancelledAt time.Time) (*Subscription, error)
+	ExpireSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error)
+	FindSubscriptionByExternalID(ctx context.Context, provider, externalID string) (*Subscription, error)
+
+	RecordPaymentEvent(ctx context.Context, event *PaymentEvent) error
+	ClaimPaymentEvent(ctx context.Context, id string, claimedAt time.Time, lease time.Duration) error
+	CompletePaymentEvent(ctx context.Context, id string, processedAt time.Time, result, processingError string) error
+
+	AddTrafficUsage(ctx context.Context, entries []TrafficUsage) error
+	AddReportedTrafficUsage(ctx context.Context, agentID, reportID string, entries []TrafficUsage) (bool, error)
+	ListTrafficUsage(ctx context.Context, filter TrafficUsageFilter) ([]TrafficUsage, error)
+
+	CreateAgent(ctx context.Context, agent *Agent) error
+	GetAgent(ctx context.Context, id string) (*Agent, error)
+	GetAgentByTokenHash(ctx context.Context, tokenHash string) (*Agent, error)
+	ListAgents(ctx context.Context) ([]Agent, error)
+	UpdateAgentToken(ctx context.Context, id, tokenHash string) error
+	RevokeAgent(ctx context.Context, id string, revokedAt time.Time) error
+	CreateAgentEnrollment(ctx context.Context, enrollment *AgentEnrollment) error
+	RedeemAgentEnrollment(ctx context.Context, codeHash string, agent *Agent, now time.Time) error
+	AppendAgentStatus(ctx context.Context, status *AgentStatus) error
+	ListAgentStatuses(ctx context.Context, agentID string, limit int) ([]AgentStatus, error)
+	LatestAgentStatuses(ctx context.Context) ([]AgentStatus, error)
+	PruneAgentStatuses(ctx context.Context, before time.Time) (int, error)
+
+	SaveXrayTemplate(ctx context.Context, template *XrayTemplate) error
+	ListXrayTemplates(ctx context.Context) ([]XrayTemplate, error)
+	DeleteXrayTemplate(ctx context.Context, name string) error
+
+	CreateDeviceToken(ctx context.Context, token *DeviceToken) error
+	ListDeviceTokens(ctx context.Context, userID string) ([]DeviceToken, error)
+	GetDeviceTokenByHash(ctx context.Context, tokenHash string) (*DeviceToken, error)
+	TouchDeviceToken(ctx context.Context, id string, usedAt time.Time) error
+	DeleteDeviceToken(ctx context.Context, userID, id string) error
+	DeleteUserDeviceTokens(ctx context.Context, userID string) (int, error)
+
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	ConsumeRefreshToken(ctx context.Context, id string, usedAt time.Time) (*RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error
+	PruneRefreshTokens(ctx context.Context, before time.Time) (int, error)
+
+	AppendAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) (AuditEventPage, error)
+
+	CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error)
+	GetWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error)
+	UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, usedAt time.Time) error
+	RenameWebAuthnCredential(ctx context.Context, userID, id, name string) error
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) error
+
+	ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash string, usedAt time.Time) error
+	CountMFARecoveryCodes(ctx context.Context, userID string) (int, error)
+
+	CreateIdentity(ctx context.Context, identity *Identity) error
+	GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error)
+	ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error)
+	DeleteIdentity(ctx context.Context, userID, id string) error
+
+	EnqueueOutboundEmail(ctx context.Context, email *OutboundEmail) error
+	ClaimOutboundEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboundEmail, error)
+	DeleteOutboundEmail(ctx context.Context, id string) error
+	RescheduleOutboundEmail(ctx context.Context, id string, attempts int, nextAttemptAt time.Time, lastError string) error
+	DeadLetterOutboundEmail(ctx context.Context, id string, attempts int, lastError string, deadLetteredAt time.Time) error
+	OutboundEmailStats(ctx context.Context) (OutboundEmailStats, error)
 }
 
 // Domain level errors returned by the store implementation.
@@ -67,8 +193,10 @@ var (
 	ErrInvalidName                = errors.New("invalid user name")
 	ErrUserNotFound               = errors.New("user not found")
 	ErrMFANotSupported            = errors.New("mfa is not supported by the current store schema")
+	ErrDisableNotSupported        = errors.New("disabling users is not supported by the current store schema")
 	ErrSuperAdminCountingDisabled = errors.New("super administrator counting is disabled")
 	ErrSubscriptionNotFound       = errors.New("subscription not found")
+	ErrDeviceTokenNotFound        = errors.New("device token not found")
 )
 
 // memoryStore provides an in-memory implementation of Store. It is suitable for
@@ -81,6 +209,20 @@ type memoryStore struct {
 	byEmail                 map[string]*User
 	byName                  map[string]*User
 	subscriptions           map[string]map[string]*Subscription
+	deviceTokens            map[string]*DeviceToken
+	refreshTokens           map[string]*RefreshToken
+	auditEvents             []*AuditEvent
+	webAuthnCredentials     map[string]*WebAuthnCredential
+	recoveryCodes           map[string][]*mfaRecoveryCode
+	identities              map[string]*Identity
+	outboundEmails          map[string]*OutboundEmail
+	paymentEvents           map[string]*PaymentEvent
+	trafficUsage            map[string]*TrafficUsage
+	usageReports            map[string]time.Time
+	agents                  map[string]*Agent
+	agentEnrollments        map[string]*AgentEnrollment
+	agentStatuses           []AgentStatus
+	xrayTemplates           map[string]*XrayTemplate
 }
 
 // NewMemoryStore creates a new in-memory store implementation with super
@@ -105,6 +247,18 @@ func newMemoryStore(allowSuperAdminCounting bool) Store {
 		byEmail:                 make(map[string]*User),
 		byName:                  make(map[string]*User),
 		subscriptions:           make(map[string]map[string]*Subscription),
+		deviceTokens:            make(map[string]*DeviceToken),
+		refreshTokens:           make(map[string]*RefreshToken),
+		webAuthnCredentials:     make(map[string]*WebAuthnCredential),
+		recoveryCodes:           make(map[string][]*mfaRecoveryCode),
+		identities:              make(map[string]*Identity),
+		outboundEmails:          make(map[string]*OutboundEmail),
+		paymentEvents:           make(map[string]*PaymentEvent),
+		trafficUsage:            make(map[string]*TrafficUsage),
+		usageReports:            make(map[string]time.Time),
+		agents:                  make(map[string]*Agent),
+		agentEnrollments:        make(map[string]*AgentEnrollment),
+		xrayTemplates:           make(map[string]*XrayTemplate),
 	}
 }
 
@@ -279,6 +433,109 @@ func (s *memoryStore) UpdateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// ListUsers returns users ordered from newest to oldest.
+func (s *memoryStore) ListUsers(ctx context.Context, opts UserListOptions) (UserPage, error) {
+	_ = ctx
+	opts = normalizeUserListOptions(opts)
+
+	s.mu.RLock()
+	matches := make([]User, 0, len(s.byID))
+	for _, user := range s.byID {
+		if opts.Query != "" && !strings.Contains(strings.ToLower(user.Name), opts.Query) && !strings.Contains(user.Email, opts.Query) {
+			continue
+		}
+		if opts.Role != "" && user.Role != opts.Role {
+			continue
+		}
+		if opts.Disabled != nil && user.Disabled != *opts.Disabled {
+			continue
+		}
+		matches = append(matches, *cloneUser(user))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].CreatedAt.Equal(matches[j].CreatedAt) {
+			return matches[i].CreatedAt.After(matches[j].CreatedAt)
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	page := UserPage{Total: len(matches), Users: []User{}, Offset: opts.Offset, Limit: opts.Limit}
+	if opts.Offset < len(matches) {
+		end := opts.Offset + opts.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		page.Users = matches[opts.Offset:end]
+	}
+	return page, nil
+}
+
+// SetUserDisabled enables or disables sign in for a user.
+func (s *memoryStore) SetUserDisabled(ctx context.Context, id string, disabled bool) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.byID[strings.TrimSpace(id)]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Disabled = disabled
+	user.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// DeleteUser removes a user together with their subscriptions, device tokens,
+// refresh tokens, WebAuthn credentials, MFA recovery codes and linked
+// identities.
+func (s *memoryStore) DeleteUser(ctx context.Context, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := strings.TrimSpace(id)
+	user, ok := s.byID[normalized]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	delete(s.byID, normalized)
+	delete(s.byName, strings.ToLower(user.Name))
+	if user.Email != "" {
+		delete(s.byEmail, user.Email)
+	}
+	delete(s.subscriptions, normalized)
+	delete(s.recoveryCodes, normalized)
+	for tokenID, token := range s.deviceTokens {
+		if token.UserID == normalized {
+			delete(s.deviceTokens, tokenID)
+		}
+	}
+	for tokenID, token := range s.refreshTokens {
+		if token.UserID == normalized {
+			delete(s.refreshTokens, tokenID)
+		}
+	}
+	for credentialID, credential := range s.webAuthnCredentials {
+		if credential.UserID == normalized {
+			delete(s.webAuthnCredentials, credentialID)
+		}
+	}
+	for key, identity := range s.identities {
+		if identity.UserID == normalized {
+			delete(s.identities, key)
+		}
+	}
+	for key, usage := range s.trafficUsage {
+		if usage.UserID == normalized {
+			delete(s.trafficUsage, key)
+		}
+	}
+	return nil
+}
+
 // UpsertSubscription creates or updates a subscription for a user.
 func (s *memoryStore) UpsertSubscription(ctx context.Context, subscription *Subscription) error {
 	_ = ctx
@@ -308,6 +565,9 @@ func (s *memoryStore) UpsertSubscription(ctx context.Context, subscription *Subs
 	if key == "" {
 		return errors.New("external id is required")
 	}
+	if err := normalizeSubscriptionForWrite(subscription); err != nil {
+		return err
+	}
 	if strings.TrimSpace(subscription.PaymentMethod) == "" {
 		subscription.PaymentMethod = strings.TrimSpace(subscription.Provider)
 	}
@@ -315,7 +575,11 @@ func (s *memoryStore) UpsertSubscription(ctx context.Context, subscription *Subs
 
 	now := time.Now().UTC()
 	stored, exists := userSubs[key]
-	if !exists {
+	if exists {
+		if err := validateSubscriptionUpdate(stored.Status, stored.CurrentPeriodEnd, subscription); err != nil {
+			return err
+		}
+	} else {
 		stored = &Subscription{ID: uuid.NewString(), UserID: userID, ExternalID: key, CreatedAt: now}
 		userSubs[key] = stored
 	}
@@ -327,6 +591,15 @@ func (s *memoryStore) UpsertSubscription(ctx context.Context, subscription *Subs
 	stored.PlanID = strings.TrimSpace(subscription.PlanID)
 	stored.Status = strings.TrimSpace(subscription.Status)
 	stored.Meta = cloneSubscriptionMeta(subscription.Meta)
+	if subscription.CurrentPeriodStart != nil {
+		stored.CurrentPeriodStart = cloneTimePointer(subscription.CurrentPeriodStart)
+	}
+	if subscription.CurrentPeriodEnd != nil {
+		stored.CurrentPeriodEnd = cloneTimePointer(subscription.CurrentPeriodEnd)
+	}
+	if subscription.ProviderUpdatedAt != nil {
+		stored.ProviderUpdatedAt = cloneTimePointer(subscription.ProviderUpdatedAt)
+	}
 	stored.UpdatedAt = now
 	if subscription.CancelledAt != nil {
 		cancelled := subscription.CancelledAt.UTC()
@@ -386,8 +659,12 @@ func (s *memoryStore) CancelSubscription(ctx context.Context, userID, externalID
 		return nil, ErrSubscriptionNotFound
 	}
 
+	if err := validateSubscriptionTransition(existing.Status, SubscriptionStatusCancelled); err != nil {
+		return nil, err
+	}
+
 	cancelled := cancelledAt.UTC()
-	existing.Status = "cancelled"
+	existing.Status = SubscriptionStatusCancelled
 	existing.CancelledAt = &cancelled
 	existing.UpdatedAt = time.Now().UTC()
 
@@ -501,6 +778,9 @@ func cloneSubscription(sub *Subscription) *Subscription {
 	}
 	clone := *sub
 	clone.Meta = cloneSubscriptionMeta(sub.Meta)
+	clone.CurrentPeriodStart = cloneTimePointer(sub.CurrentPeriodStart)
+	clone.CurrentPeriodEnd = cloneTimePointer(sub.CurrentPeriodEnd)
+	clone.ProviderUpdatedAt = cloneTimePointer(sub.ProviderUpdatedAt)
 	if sub.CancelledAt != nil {
 		cancelled := sub.CancelledAt.UTC()
 		clone.CancelledAt = &cancelled
@@ -540,6 +820,9 @@ func assignUser(dst, src *User) {
 func assignSubscription(dst, src *Subscription) {
 	*dst = *src
 	dst.Meta = cloneSubscriptionMeta(src.Meta)
+	dst.CurrentPeriodStart = cloneTimePointer(src.CurrentPeriodStart)
+	dst.CurrentPeriodEnd = cloneTimePointer(src.CurrentPeriodEnd)
+	dst.ProviderUpdatedAt = cloneTimePointer(src.ProviderUpdatedAt)
 	if src.CancelledAt != nil {
 		cancelled := src.CancelledAt.UTC()
 		dst.CancelledAt = &cancelled
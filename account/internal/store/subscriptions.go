@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subscription lifecycle states.
+const (
+	SubscriptionStatusPending   = "pending"
+	SubscriptionStatusTrialing  = "trialing"
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusPastDue   = "past_due"
+	SubscriptionStatusCancelled = "cancelled"
+	SubscriptionStatusExpired   = "expired"
+)
+
+var (
+	ErrInvalidSubscriptionStatus     = errors.New("invalid subscription status")
+	ErrInvalidSubscriptionTransition = errors.New("invalid subscription status transition")
+)
+
+// subscriptionTransitions lists the states each state may move to. Pending
+// subscriptions await the payment provider's confirmation. Cancelled
+// and expired are terminal; renewing requires a new subscription, except that
+// an expired subscription the provider renews for a later period is revived
+// (see validateSubscriptionUpdate).
+var subscriptionTransitions = map[string][]string{
+	SubscriptionStatusPending:  {SubscriptionStatusTrialing, SubscriptionStatusActive, SubscriptionStatusCancelled, SubscriptionStatusExpired},
+	SubscriptionStatusTrialing: {SubscriptionStatusActive, SubscriptionStatusPastDue, SubscriptionStatusCancelled, SubscriptionStatusExpired},
+	SubscriptionStatusActive:   {SubscriptionStatusPastDue, SubscriptionStatusCancelled, SubscriptionStatusExpired},
+	SubscriptionStatusPastDue:  {SubscriptionStatusActive, SubscriptionStatusCancelled, SubscriptionStatusExpired},
+}
+
+// entitledSubscriptionStatuses grant access to the service. Past due
+// subscriptions keep access while the provider retries the payment.
+var entitledSubscriptionStatuses = []string{
+	SubscriptionStatusTrialing,
+	SubscriptionStatusActive,
+	SubscriptionStatusPastDue,
+}
+
+// NormalizeSubscriptionStatus canonicalizes a status string and reports
+// whether it names a known lifecycle state.
+func NormalizeSubscriptionStatus(status string) (string, bool) {
+	normalized := strings.ToLower(strings.TrimSpace(status))
+	switch normalized {
+	case "canceled":
+		normalized = SubscriptionStatusCancelled
+	case "pastdue", "past-due":
+		normalized = SubscriptionStatusPastDue
+	}
+	switch normalized {
+	case SubscriptionStatusPending, SubscriptionStatusTrialing, SubscriptionStatusActive, SubscriptionStatusPastDue,
+		SubscriptionStatusCancelled, SubscriptionStatusExpired:
+		return normalized, true
+	}
+	return normalized, false
+}
+
+// CanTransitionSubscription reports whether a subscription may move from one
+// status to another. Staying in the same state is always allowed, and rows
+// written before the lifecycle existed (unknown statuses) may move anywhere.
+func CanTransitionSubscription(from, to string) bool {
+	to, ok := NormalizeSubscriptionStatus(to)
+	if !ok {
+		return false
+	}
+	from, known := NormalizeSubscriptionStatus(from)
+	if !known || from == to {
+		return true
+	}
+	for _, next := range subscriptionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// EntitledSubscriptionStatuses returns the statuses that grant service access.
+func EntitledSubscriptionStatuses() []string {
+	return cloneStringSlice(entitledSubscriptionStatuses)
+}
+
+// Entitled reports whether the subscription grants access at the given time.
+func (s *Subscription) Entitled(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	status, _ := NormalizeSubscriptionStatus(s.Status)
+	if !isEntitledSubscriptionStatus(status) {
+		return false
+	}
+	return s.CurrentPeriodEnd == nil || s.CurrentPeriodEnd.After(now)
+}
+
+func isEntitledSubscriptionStatus(status string) bool {
+	for _, entitled := range entitledSubscriptionStatuses {
+		if status == entitled {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSubscriptionTransition(from, to string) error {
+	if CanTransitionSubscription(from, to) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrInvalidSubscriptionTransition, from, to)
+}
+
+// validateSubscriptionUpdate checks that a stored subscription in status
+// current, whose period ends at currentEnd, may be overwritten by next. On top
+// of the regular transitions, an expired subscription may become active or
+// trialing again when next carries a period ending after currentEnd: the
+// expiry job can run before a late provider renewal arrives.
+func validateSubscriptionUpdate(current string, currentEnd *time.Time, next *Subscription) error {
+	if isSubscriptionRenewal(current, currentEnd, next) {
+		return nil
+	}
+	return validateSubscriptionTransition(current, next.Status)
+}
+
+func isSubscriptionRenewal(current string, currentEnd *time.Time, next *Subscription) bool {
+	from, _ := NormalizeSubscriptionStatus(current)
+	to, _ := NormalizeSubscriptionStatus(next.Status)
+	if from != SubscriptionStatusExpired || (to != SubscriptionStatusActive && to != SubscriptionStatusTrialing) {
+		return false
+	}
+	if next.CurrentPeriodEnd == nil {
+		return false
+	}
+	return currentEnd == nil || next.CurrentPeriodEnd.After(*currentEnd)
+}
+
+// normalizeSubscriptionForWrite validates the status of a subscription about
+// to be stored and rewrites it to its canonical form.
+func normalizeSubscriptionForWrite(subscription *Subscription) error {
+	status, ok := NormalizeSubscriptionStatus(subscription.Status)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidSubscriptionStatus, subscription.Status)
+	}
+	subscription.Status = status
+	return nil
+}
+
+// ExpireSubscriptions moves every entitled subscription whose period ended at
+// or before now to the expired state and returns the affected subscriptions.
+func (s *memoryStore) ExpireSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updatedAt := time.Now().UTC()
+	expired := []Subscription{}
+	for _, subs := range s.subscriptions {
+		for _, sub := range subs {
+			if !isEntitledSubscriptionStatus(sub.Status) || sub.CurrentPeriodEnd == nil || sub.CurrentPeriodEnd.After(now) {
+				continue
+			}
+			sub.Status = SubscriptionStatusExpired
+			sub.UpdatedAt = updatedAt
+			expired = append(expired, *cloneSubscription(sub))
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].CurrentPeriodEnd.Before(*expired[j].CurrentPeriodEnd)
+	})
+	return expired, nil
+}
+
+// FindSubscriptionByExternalID returns the most recently updated subscription
+// a provider knows under externalID, regardless of the owning user.
+func (s *memoryStore) FindSubscriptionByExternalID(ctx context.Context, provider, externalID string) (*Subscription, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	provider = strings.TrimSpace(provider)
+	externalID = strings.TrimSpace(externalID)
+	var found *Subscription
+	for _, subs := range s.subscriptions {
+		sub, ok := subs[externalID]
+		if !ok || sub.Provider != provider {
+			continue
+		}
+		if found == nil || sub.UpdatedAt.After(found.UpdatedAt) {
+			found = sub
+		}
+	}
+	if found == nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	return cloneSubscription(found), nil
+}
+
+func cloneTimePointer(value *time.Time) *time.Time {
+	if value == nil {
+		return nil
+	}
+	clone := value.UTC()
+	return &clone
+}
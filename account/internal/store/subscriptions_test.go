@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanTransitionSubscription(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{SubscriptionStatusTrialing, SubscriptionStatusActive, true},
+		{SubscriptionStatusActive, SubscriptionStatusPastDue, true},
+		{SubscriptionStatusPastDue, SubscriptionStatusActive, true},
+		{SubscriptionStatusActive, SubscriptionStatusActive, true},
+		{SubscriptionStatusActive, "canceled", true},
+		{SubscriptionStatusActive, SubscriptionStatusTrialing, false},
+		{SubscriptionStatusCancelled, SubscriptionStatusActive, false},
+		{SubscriptionStatusExpired, SubscriptionStatusActive, false},
+		{"pending", SubscriptionStatusActive, true},
+		{SubscriptionStatusActive, "paused", false},
+	}
+	for _, tc := range cases {
+		if got := CanTransitionSubscription(tc.from, tc.to); got != tc.want {
+			t.Errorf("CanTransitionSubscription(%q, %q) = %v, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestMemorySubscriptionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	user := &User{Name: "subscriber", Email: "subscriber@example.com"}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	now := time.Now().UTC()
+	periodEnd := now.Add(time.Hour)
+	sub := &Subscription{UserID: user.ID, Provider: "paypal", ExternalID: "sub-1", Status: "Trialing", CurrentPeriodStart: &now, CurrentPeriodEnd: &periodEnd}
+	if err := s.UpsertSubscription(ctx, sub); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if sub.Status != SubscriptionStatusTrialing || !sub.Entitled(now) || sub.Entitled(periodEnd) {
+		t.Fatalf("expected normalized trial entitled until its period end, got %+v", sub)
+	}
+
+	if err := s.UpsertSubscription(ctx, &Subscription{UserID: user.ID, ExternalID: "sub-2", Status: "paused"}); !errors.Is(err, ErrInvalidSubscriptionStatus) {
+		t.Fatalf("expected unknown status to be rejected, got %v", err)
+	}
+
+	// Omitting the period on update keeps the stored one.
+	if err := s.UpsertSubscription(ctx, &Subscription{UserID: user.ID, Provider: "paypal", ExternalID: "sub-1", Status: SubscriptionStatusActive}); err != nil {
+		t.Fatalf("activate: %v", err)
+	}
+	subs, _ := s.ListSubscriptionsByUser(ctx, user.ID)
+	if len(subs) != 1 || subs[0].CurrentPeriodEnd == nil || !subs[0].CurrentPeriodEnd.Equal(periodEnd) {
+		t.Fatalf("expected period to be preserved, got %+v", subs)
+	}
+	if err := s.UpsertSubscription(ctx, &Subscription{UserID: user.ID, ExternalID: "sub-1", Status: SubscriptionStatusTrialing}); !errors.Is(err, ErrInvalidSubscriptionTransition) {
+		t.Fatalf("expected active -> trialing to be rejected, got %v", err)
+	}
+
+	if expired, err := s.ExpireSubscriptions(ctx, now); err != nil || len(expired) != 0 {
+		t.Fatalf("expected nothing to expire before the period end, got %+v (%v)", expired, err)
+	}
+	expired, err := s.ExpireSubscriptions(ctx, periodEnd)
+	if err != nil || len(expired) != 1 || expired[0].Status != SubscriptionStatusExpired {
+		t.Fatalf("expected subscription to expire, got %+v (%v)", expired, err)
+	}
+	if _, err := s.CancelSubscription(ctx, user.ID, "sub-1", now); !errors.Is(err, ErrInvalidSubscriptionTransition) {
+		t.Fatalf("expected expired subscription to reject cancellation, got %v", err)
+	}
+
+	// A provider renewal for a later period revives the expired subscription;
+	// one without a new period does not.
+	if err := s.UpsertSubscription(ctx, &Subscription{UserID: user.ID, ExternalID: "sub-1", Status: SubscriptionStatusActive}); !errors.Is(err, ErrInvalidSubscriptionTransition) {
+		t.Fatalf("expected reactivation without a new period to be rejected, got %v", err)
+	}
+	renewedEnd := periodEnd.Add(30 * 24 * time.Hour)
+	if err := s.UpsertSubscription(ctx, &Subscription{UserID: user.ID, ExternalID: "sub-1", Status: SubscriptionStatusActive, CurrentPeriodStart: &periodEnd, CurrentPeriodEnd: &renewedEnd}); err != nil {
+		t.Fatalf("expected renewal after expiry to be accepted, got %v", err)
+	}
+}
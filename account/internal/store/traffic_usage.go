@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrafficUsage is the proxy traffic a user transferred on one UTC day.
+type TrafficUsage struct {
+	UserID    string
+	Day       time.Time
+	Uplink    int64
+	Downlink  int64
+	UpdatedAt time.Time
+}
+
+// Total returns the combined uplink and downlink bytes.
+func (u TrafficUsage) Total() int64 {
+	return u.Uplink + u.Downlink
+}
+
+// TrafficUsageFilter narrows ListTrafficUsage results. Since is inclusive and
+// Until exclusive; both are truncated to the UTC day.
+type TrafficUsageFilter struct {
+	UserID string
+	Since  time.Time
+	Until  time.Time
+}
+
+// ErrInvalidTrafficUsage is returned for negative counters or entries
+// without a user.
+var ErrInvalidTrafficUsage = errors.New("invalid traffic usage")
+
+// UsageReportRetention is how long the IDs of applied usage reports are kept
+// to recognise retries. Agents retry a report until it is accepted, far
+// sooner than this.
+const UsageReportRetention = 7 * 24 * time.Hour
+
+// UsageDay truncates at to the start of its UTC day.
+func UsageDay(at time.Time) time.Time {
+	at = at.UTC()
+	return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func normalizeTrafficUsage(entries []TrafficUsage) ([]TrafficUsage, error) {
+	normalized := make([]TrafficUsage, 0, len(entries))
+	for _, entry := range entries {
+		entry.UserID = strings.TrimSpace(entry.UserID)
+		if entry.UserID == "" || entry.Uplink < 0 || entry.Downlink < 0 {
+			return nil, ErrInvalidTrafficUsage
+		}
+		if entry.Uplink == 0 && entry.Downlink == 0 {
+			continue
+		}
+		entry.Day = UsageDay(entry.Day)
+		normalized = append(normalized, entry)
+	}
+	return normalized, nil
+}
+
+func trafficUsageKey(userID string, day time.Time) string {
+	return userID + "\x00" + day.Format(time.DateOnly)
+}
+
+// AddTrafficUsage adds the counters of entries to the per-day totals of their
+// users. Entries for unknown users are dropped.
+func (s *memoryStore) AddTrafficUsage(ctx context.Context, entries []TrafficUsage) error {
+	_ = ctx
+	normalized, err := normalizeTrafficUsage(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addTrafficUsageLocked(normalized, time.Now().UTC())
+	return nil
+}
+
+// AddReportedTrafficUsage adds the entries of a usage report once per agent
+// and report ID. It reports false without changing the totals when the report
+// was applied before.
+func (s *memoryStore) AddReportedTrafficUsage(ctx context.Context, agentID, reportID string, entries []TrafficUsage) (bool, error) {
+	_ = ctx
+	agentID = strings.TrimSpace(agentID)
+	reportID = strings.TrimSpace(reportID)
+	if agentID == "" || reportID == "" {
+		return false, ErrInvalidTrafficUsage
+	}
+	normalized, err := normalizeTrafficUsage(entries)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for key, receivedAt := range s.usageReports {
+		if now.Sub(receivedAt) > UsageReportRetention {
+			delete(s.usageReports, key)
+		}
+	}
+	key := agentID + "\x00" + reportID
+	if _, ok := s.usageReports[key]; ok {
+		return false, nil
+	}
+	s.usageReports[key] = now
+	s.addTrafficUsageLocked(normalized, now)
+	return true, nil
+}
+
+func (s *memoryStore) addTrafficUsageLocked(normalized []TrafficUsage, now time.Time) {
+	for _, entry := range normalized {
+		if _, ok := s.byID[entry.UserID]; !ok {
+			continue
+		}
+		key := trafficUsageKey(entry.UserID, entry.Day)
+		stored, ok := s.trafficUsage[key]
+		if !ok {
+			stored = &TrafficUsage{UserID: entry.UserID, Day: entry.Day}
+			s.trafficUsage[key] = stored
+		}
+		stored.Uplink += entry.Uplink
+		stored.Downlink += entry.Downlink
+		stored.UpdatedAt = now
+	}
+}
+
+// ListTrafficUsage returns daily usage ordered by day and user.
+func (s *memoryStore) ListTrafficUsage(ctx context.Context, filter TrafficUsageFilter) ([]TrafficUsage, error) {
+	_ = ctx
+	userID := strings.TrimSpace(filter.UserID)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := make([]TrafficUsage, 0)
+	for _, entry := range s.trafficUsage {
+		if userID != "" && entry.UserID != userID {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.Day.Before(UsageDay(filter.Since)) {
+			continue
+		}
+		if !filter.Until.IsZero() && !entry.Day.Before(UsageDay(filter.Until)) {
+			continue
+		}
+		usage = append(usage, *entry)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if !usage[i].Day.Equal(usage[j].Day) {
+			return usage[i].Day.Before(usage[j].Day)
+		}
+		return usage[i].UserID < usage[j].UserID
+	})
+	return usage, nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTrafficUsageAccumulatesPerDay(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	user := &User{Name: "alice", Email: "alice@example.com", Role: RoleUser}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	morning := time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC)
+	if err := s.AddTrafficUsage(ctx, []TrafficUsage{{UserID: user.ID, Day: morning, Uplink: -1}}); !errors.Is(err, ErrInvalidTrafficUsage) {
+		t.Fatalf("expected negative counters to be rejected, got %v", err)
+	}
+	reports := [][]TrafficUsage{
+		{{UserID: user.ID, Day: morning, Uplink: 10, Downlink: 100}, {UserID: "unknown", Day: morning, Uplink: 1}},
+		{{UserID: user.ID, Day: morning.Add(20 * time.Hour), Uplink: 5, Downlink: 50}},
+		{{UserID: user.ID, Day: morning.Add(24 * time.Hour), Downlink: 7}},
+	}
+	for _, report := range reports {
+		if err := s.AddTrafficUsage(ctx, report); err != nil {
+			t.Fatalf("add usage: %v", err)
+		}
+	}
+
+	usage, err := s.ListTrafficUsage(ctx, TrafficUsageFilter{})
+	if err != nil {
+		t.Fatalf("list usage: %v", err)
+	}
+	if len(usage) != 2 || !usage[0].Day.Equal(UsageDay(morning)) || usage[0].Uplink != 15 || usage[0].Downlink != 150 || usage[1].Total() != 7 {
+		t.Fatalf("expected usage summed per day, got %+v", usage)
+	}
+
+	usage, err = s.ListTrafficUsage(ctx, TrafficUsageFilter{UserID: user.ID, Since: morning.Add(24 * time.Hour), Until: morning.Add(48 * time.Hour)})
+	if err != nil || len(usage) != 1 || usage[0].Downlink != 7 {
+		t.Fatalf("expected the second day only, got %+v (%v)", usage, err)
+	}
+
+	if err := s.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if usage, _ := s.ListTrafficUsage(ctx, TrafficUsageFilter{}); len(usage) != 0 {
+		t.Fatalf("expected usage to be removed with the user, got %+v", usage)
+	}
+}
+
+func TestMemoryTrafficUsageAppliesReportsOnce(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	user := &User{Name: "alice", Email: "alice@example.com", Role: RoleUser}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	entries := []TrafficUsage{{UserID: user.ID, Day: day, Uplink: 10, Downlink: 100}}
+	if _, err := s.AddReportedTrafficUsage(ctx, "edge", "", entries); !errors.Is(err, ErrInvalidTrafficUsage) {
+		t.Fatalf("expected a report ID to be required, got %v", err)
+	}
+	for attempt, want := range []bool{true, false} {
+		applied, err := s.AddReportedTrafficUsage(ctx, "edge", "report-1", entries)
+		if err != nil || applied != want {
+			t.Fatalf("attempt %d: expected applied=%v, got %v (%v)", attempt, want, applied, err)
+		}
+	}
+	// Report IDs are scoped to the agent.
+	if applied, err := s.AddReportedTrafficUsage(ctx, "other", "report-1", entries); err != nil || !applied {
+		t.Fatalf("expected another agent's report to be applied, got %v (%v)", applied, err)
+	}
+
+	usage, err := s.ListTrafficUsage(ctx, TrafficUsageFilter{})
+	if err != nil || len(usage) != 1 || usage[0].Uplink != 20 || usage[0].Downlink != 200 {
+		t.Fatalf("expected each report to be counted once, got %+v (%v)", usage, err)
+	}
+}
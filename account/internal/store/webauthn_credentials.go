@@ -0,0 +1,197 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnCredential is a hardware security key or platform passkey
+// registered by a user. PublicKey holds the COSE encoded credential public
+// key and SignCount the last signature counter reported by the authenticator.
+type WebAuthnCredential struct {
+	ID           string
+	UserID       string
+	Name         string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	AAGUID       []byte
+	Transports   []string
+	CreatedAt    time.Time
+	LastUsedAt   *time.Time
+}
+
+// WebAuthn credential errors.
+var (
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+)
+
+func cloneWebAuthnCredential(credential *WebAuthnCredential) *WebAuthnCredential {
+	if credential == nil {
+		return nil
+	}
+	clone := *credential
+	clone.CredentialID = bytes.Clone(credential.CredentialID)
+	clone.PublicKey = bytes.Clone(credential.PublicKey)
+	clone.AAGUID = bytes.Clone(credential.AAGUID)
+	clone.Transports = cloneStringSlice(credential.Transports)
+	if credential.LastUsedAt != nil {
+		lastUsed := *credential.LastUsedAt
+		clone.LastUsedAt = &lastUsed
+	}
+	return &clone
+}
+
+func validateWebAuthnCredential(credential *WebAuthnCredential) error {
+	if credential == nil {
+		return errors.New("webauthn credential is required")
+	}
+	credential.UserID = strings.TrimSpace(credential.UserID)
+	if credential.UserID == "" {
+		return ErrUserNotFound
+	}
+	credential.Name = strings.TrimSpace(credential.Name)
+	if credential.Name == "" {
+		return errors.New("webauthn credential name is required")
+	}
+	if len(credential.CredentialID) == 0 {
+		return errors.New("webauthn credential id is required")
+	}
+	if len(credential.PublicKey) == 0 {
+		return errors.New("webauthn credential public key is required")
+	}
+	credential.Transports = normalizeStringSlice(credential.Transports)
+	return nil
+}
+
+// CreateWebAuthnCredential persists a new credential for an existing user.
+func (s *memoryStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	_ = ctx
+	if err := validateWebAuthnCredential(credential); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[credential.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	for _, existing := range s.webAuthnCredentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return ErrWebAuthnCredentialExists
+		}
+	}
+
+	stored := cloneWebAuthnCredential(credential)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now().UTC()
+	}
+	s.webAuthnCredentials[stored.ID] = stored
+
+	*credential = *cloneWebAuthnCredential(stored)
+	return nil
+}
+
+// ListWebAuthnCredentials returns the credentials registered by a user,
+// oldest first.
+func (s *memoryStore) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	_ = ctx
+	normalized := strings.TrimSpace(userID)
+	if normalized == "" {
+		return nil, ErrUserNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]WebAuthnCredential, 0)
+	for _, credential := range s.webAuthnCredentials {
+		if credential.UserID == normalized {
+			result = append(result, *cloneWebAuthnCredential(credential))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// GetWebAuthnCredentialByCredentialID looks up a credential by the ID chosen
+// by the authenticator.
+func (s *memoryStore) GetWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, credential := range s.webAuthnCredentials {
+		if len(credentialID) > 0 && bytes.Equal(credential.CredentialID, credentialID) {
+			return cloneWebAuthnCredential(credential), nil
+		}
+	}
+	return nil, ErrWebAuthnCredentialNotFound
+}
+
+// UpdateWebAuthnCredentialUsage records a successful assertion.
+func (s *memoryStore) UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webAuthnCredentials[strings.TrimSpace(id)]
+	if !ok {
+		return ErrWebAuthnCredentialNotFound
+	}
+	used := usedAt.UTC()
+	credential.SignCount = signCount
+	credential.LastUsedAt = &used
+	return nil
+}
+
+// RenameWebAuthnCredential changes the display name of a credential owned by
+// userID.
+func (s *memoryStore) RenameWebAuthnCredential(ctx context.Context, userID, id, name string) error {
+	_ = ctx
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("webauthn credential name is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webAuthnCredentials[strings.TrimSpace(id)]
+	if !ok || credential.UserID != strings.TrimSpace(userID) {
+		return ErrWebAuthnCredentialNotFound
+	}
+	credential.Name = name
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a credential owned by userID.
+func (s *memoryStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimSpace(id)
+	credential, ok := s.webAuthnCredentials[key]
+	if !ok || credential.UserID != strings.TrimSpace(userID) {
+		return ErrWebAuthnCredentialNotFound
+	}
+	delete(s.webAuthnCredentials, key)
+	return nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// XrayTemplate is an Xray server template managed at runtime. Content is the
+// JSON template with ${NAME} placeholders; it is assigned to the agents listed
+// in AgentIDs and to the agents belonging to one of Groups.
+type XrayTemplate struct {
+	Name      string
+	Content   []byte
+	AgentIDs  []string
+	Groups    []string
+	Variables map[string]string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+var ErrXrayTemplateNotFound = errors.New("xray template not found")
+
+func validateXrayTemplate(template *XrayTemplate) error {
+	if template == nil {
+		return errors.New("xray template is required")
+	}
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return errors.New("xray template name is required")
+	}
+	if len(template.Content) == 0 {
+		return errors.New("xray template content is required")
+	}
+	template.AgentIDs = normalizeStringSlice(template.AgentIDs)
+	template.Groups = normalizeStringSlice(template.Groups)
+	return nil
+}
+
+func cloneXrayTemplate(template *XrayTemplate) XrayTemplate {
+	clone := *template
+	clone.Content = append([]byte(nil), template.Content...)
+	clone.AgentIDs = cloneStringSlice(template.AgentIDs)
+	clone.Groups = cloneStringSlice(template.Groups)
+	clone.Variables = cloneStringMap(template.Variables)
+	return clone
+}
+
+func cloneStringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(values))
+	for key, value := range values {
+		clone[key] = value
+	}
+	return clone
+}
+
+// SaveXrayTemplate creates the template or replaces the template with the
+// same name.
+func (s *memoryStore) SaveXrayTemplate(ctx context.Context, template *XrayTemplate) error {
+	_ = ctx
+	if err := validateXrayTemplate(template); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	template.CreatedAt = now
+	if existing, ok := s.xrayTemplates[template.Name]; ok {
+		template.CreatedAt = existing.CreatedAt
+	}
+	template.UpdatedAt = now
+	stored := cloneXrayTemplate(template)
+	s.xrayTemplates[template.Name] = &stored
+	return nil
+}
+
+// ListXrayTemplates returns every template ordered by name.
+func (s *memoryStore) ListXrayTemplates(ctx context.Context) ([]XrayTemplate, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := make([]XrayTemplate, 0, len(s.xrayTemplates))
+	for _, template := range s.xrayTemplates {
+		templates = append(templates, cloneXrayTemplate(template))
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// DeleteXrayTemplate removes the template with the given name.
+func (s *memoryStore) DeleteXrayTemplate(ctx context.Context, name string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	if _, ok := s.xrayTemplates[name]; !ok {
+		return ErrXrayTemplateNotFound
+	}
+	delete(s.xrayTemplates, name)
+	return nil
+}
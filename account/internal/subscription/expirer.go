@@ -0,0 +1,105 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"account/internal/store"
+	"account/internal/utils"
+)
+
+// ExpiryStore is the persistence needed by the Expirer.
+type ExpiryStore interface {
+	ExpireSubscriptions(ctx context.Context, now time.Time) ([]store.Subscription, error)
+}
+
+// ExpirerOptions configure an Expirer.
+type ExpirerOptions struct {
+	Store    ExpiryStore
+	Interval time.Duration
+	Logger   *slog.Logger
+	// OnExpire is invoked with the subscriptions expired by each sweep.
+	OnExpire func([]store.Subscription)
+	Now      func() time.Time
+}
+
+// Expirer periodically moves subscriptions whose period has lapsed to the
+// expired state.
+type Expirer struct {
+	store    ExpiryStore
+	interval time.Duration
+	logger   *slog.Logger
+	onExpire func([]store.Subscription)
+	now      func() time.Time
+}
+
+// NewExpirer constructs an Expirer from the provided options.
+func NewExpirer(opts ExpirerOptions) (*Expirer, error) {
+	if opts.Store == nil {
+		return nil, errors.New("expiry store is required")
+	}
+	if opts.Interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Expirer{
+		store:    opts.Store,
+		interval: opts.Interval,
+		logger:   logger,
+		onExpire: opts.OnExpire,
+		now:      now,
+	}, nil
+}
+
+// Sweep expires every lapsed subscription once.
+func (e *Expirer) Sweep(ctx context.Context) ([]store.Subscription, error) {
+	expired, err := e.store.ExpireSubscriptions(ctx, e.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range expired {
+		e.logger.Info("subscription expired", "userID", sub.UserID, "externalID", sub.ExternalID, "planID", sub.PlanID)
+	}
+	if len(expired) > 0 && e.onExpire != nil {
+		e.onExpire(expired)
+	}
+	return expired, nil
+}
+
+// Start sweeps once right away and then every interval until ctx is done or
+// the returned stop function is called. A failed sweep is logged and retried
+// on the next tick; stop waits for a sweep in progress to finish.
+func (e *Expirer) Start(ctx context.Context) (func(context.Context) error, error) {
+	if e == nil {
+		return nil, errors.New("expirer is nil")
+	}
+	return utils.StartLoop(ctx, e.run), nil
+}
+
+func (e *Expirer) run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.Sweep(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			e.logger.Error("subscription expiry sweep failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
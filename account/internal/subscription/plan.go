@@ -0,0 +1,151 @@
+// Package subscription holds the plan catalogue and the background jobs that
+// drive the subscription lifecycle stored by the store package.
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TrialPlanID identifies the onboarding trial granted to new users.
+const TrialPlanID = "TRIAL-7D"
+
+// Plan kinds.
+const (
+	KindTrial        = "trial"
+	KindSubscription = "subscription"
+)
+
+var ErrPlanNotFound = errors.New("subscription plan not found")
+
+// Plan describes a purchasable or promotional plan.
+type Plan struct {
+	ID          string
+	Name        string
+	Description string
+	Kind        string
+	// Period is the length of one billing or trial period. Zero means the
+	// plan never lapses on its own.
+	Period time.Duration
+	// Price is expressed in the minor unit of Currency.
+	Price    int64
+	Currency string
+	// TrafficQuota caps the proxy traffic, in bytes, a subscriber may
+	// transfer per period. Zero means unlimited.
+	TrafficQuota int64
+}
+
+// Catalog is an immutable set of plans keyed by ID.
+type Catalog struct {
+	plans map[string]Plan
+	order []string
+}
+
+// NewCatalog validates plans and builds a catalogue from them.
+func NewCatalog(plans []Plan) (*Catalog, error) {
+	catalog := &Catalog{plans: make(map[string]Plan, len(plans))}
+	for _, plan := range plans {
+		plan.ID = strings.TrimSpace(plan.ID)
+		if plan.ID == "" {
+			return nil, errors.New("plan id is required")
+		}
+		if _, exists := catalog.plans[plan.ID]; exists {
+			return nil, fmt.Errorf("duplicate plan %q", plan.ID)
+		}
+		plan.Kind = strings.ToLower(strings.TrimSpace(plan.Kind))
+		if plan.Kind == "" {
+			plan.Kind = KindSubscription
+		}
+		if plan.Kind != KindTrial && plan.Kind != KindSubscription {
+			return nil, fmt.Errorf("plan %q: unsupported kind %q", plan.ID, plan.Kind)
+		}
+		if plan.Period < 0 {
+			return nil, fmt.Errorf("plan %q: period must not be negative", plan.ID)
+		}
+		if plan.Price < 0 {
+			return nil, fmt.Errorf("plan %q: price must not be negative", plan.ID)
+		}
+		if plan.TrafficQuota < 0 {
+			return nil, fmt.Errorf("plan %q: traffic quota must not be negative", plan.ID)
+		}
+		plan.Currency = strings.ToUpper(strings.TrimSpace(plan.Currency))
+		if strings.TrimSpace(plan.Name) == "" {
+			plan.Name = plan.ID
+		}
+		catalog.plans[plan.ID] = plan
+		catalog.order = append(catalog.order, plan.ID)
+	}
+	return catalog, nil
+}
+
+// DefaultCatalog returns the catalogue used when none is configured. It only
+// contains the onboarding trial.
+func DefaultCatalog() *Catalog {
+	catalog, _ := NewCatalog([]Plan{{
+		ID:     TrialPlanID,
+		Name:   "7-day trial",
+		Kind:   KindTrial,
+		Period: 7 * 24 * time.Hour,
+	}})
+	return catalog
+}
+
+// WithDefaults returns a catalogue containing the plans of c plus any default
+// plan c does not override, so the onboarding trial is always available.
+func (c *Catalog) WithDefaults() *Catalog {
+	plans := c.Plans()
+	for _, plan := range DefaultCatalog().Plans() {
+		if _, err := c.Lookup(plan.ID); errors.Is(err, ErrPlanNotFound) {
+			plans = append(plans, plan)
+		}
+	}
+	merged, _ := NewCatalog(plans)
+	return merged
+}
+
+// Lookup returns the plan with the given ID.
+func (c *Catalog) Lookup(id string) (Plan, error) {
+	if c != nil {
+		if plan, ok := c.plans[strings.TrimSpace(id)]; ok {
+			return plan, nil
+		}
+	}
+	return Plan{}, fmt.Errorf("%w: %q", ErrPlanNotFound, id)
+}
+
+// Plans returns the plans in the order they were declared.
+func (c *Catalog) Plans() []Plan {
+	if c == nil {
+		return nil
+	}
+	plans := make([]Plan, 0, len(c.order))
+	for _, id := range c.order {
+		plans = append(plans, c.plans[id])
+	}
+	return plans
+}
+
+// TrafficQuotas returns the traffic quota of every plan that has one, keyed
+// by plan ID.
+func (c *Catalog) TrafficQuotas() map[string]int64 {
+	quotas := make(map[string]int64)
+	for _, plan := range c.Plans() {
+		if plan.TrafficQuota > 0 {
+			quotas[plan.ID] = plan.TrafficQuota
+		}
+	}
+	return quotas
+}
+
+// PeriodFrom returns the period that starts at start for the plan. The end is
+// nil for plans without a period.
+func (p Plan) PeriodFrom(start time.Time) (time.Time, *time.Time) {
+	start = start.UTC()
+	if p.Period <= 0 {
+		return start, nil
+	}
+	end := start.Add(p.Period)
+	return start, &end
+}
@@ -0,0 +1,125 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func TestNewCatalog(t *testing.T) {
+	if _, err := NewCatalog([]Plan{{ID: "a"}, {ID: " a "}}); err == nil {
+		t.Fatal("expected duplicate plan to be rejected")
+	}
+	if _, err := NewCatalog([]Plan{{ID: "a", Kind: "lifetime"}}); err == nil {
+		t.Fatal("expected unsupported kind to be rejected")
+	}
+
+	catalog, err := NewCatalog([]Plan{{ID: "MONTHLY", Period: 30 * 24 * time.Hour, Price: 500, Currency: "usd"}})
+	if err != nil {
+		t.Fatalf("new catalog: %v", err)
+	}
+	plan, err := catalog.Lookup("MONTHLY")
+	if err != nil || plan.Kind != KindSubscription || plan.Currency != "USD" || plan.Name != "MONTHLY" {
+		t.Fatalf("unexpected plan %+v (%v)", plan, err)
+	}
+	if _, err := catalog.Lookup(TrialPlanID); !errors.Is(err, ErrPlanNotFound) {
+		t.Fatalf("expected trial to be missing before defaults, got %v", err)
+	}
+
+	merged := catalog.WithDefaults()
+	if plans := merged.Plans(); len(plans) != 2 || plans[0].ID != "MONTHLY" || plans[1].ID != TrialPlanID {
+		t.Fatalf("unexpected merged plans %+v", plans)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, end := plan.PeriodFrom(start); end == nil || !end.Equal(start.AddDate(0, 0, 30)) {
+		t.Fatalf("unexpected period end %v", end)
+	}
+	if _, end := (Plan{ID: "forever"}).PeriodFrom(start); end != nil {
+		t.Fatalf("expected open ended period, got %v", end)
+	}
+}
+
+type expiryStoreFunc func(ctx context.Context, now time.Time) ([]store.Subscription, error)
+
+func (f expiryStoreFunc) ExpireSubscriptions(ctx context.Context, now time.Time) ([]store.Subscription, error) {
+	return f(ctx, now)
+}
+
+func TestExpirerSweepsUntilStopped(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sweeps := make(chan time.Time, 10)
+	notified := make(chan []store.Subscription, 10)
+	expirer, err := NewExpirer(ExpirerOptions{
+		Store: expiryStoreFunc(func(_ context.Context, at time.Time) ([]store.Subscription, error) {
+			sweeps <- at
+			return []store.Subscription{{UserID: "user-1", ExternalID: "sub-1", Status: store.SubscriptionStatusExpired}}, nil
+		}),
+		Interval: 10 * time.Millisecond,
+		OnExpire: func(expired []store.Subscription) { notified <- expired },
+		Now:      func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("new expirer: %v", err)
+	}
+
+	stop, err := expirer.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case at := <-sweeps:
+			if !at.Equal(now) {
+				t.Fatalf("expected sweep at %v, got %v", now, at)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for sweep")
+		}
+	}
+	if expired := <-notified; len(expired) != 1 || expired[0].ExternalID != "sub-1" {
+		t.Fatalf("unexpected notification %+v", expired)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestBestAllowance(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	start := now.AddDate(0, 0, -2)
+	end := now.AddDate(0, 0, 28)
+	lapsed := now.Add(-time.Hour)
+	usage := []store.TrafficUsage{
+		{Day: store.UsageDay(now.AddDate(0, 0, -5)), Uplink: 10_000},
+		{Day: store.UsageDay(start), Uplink: 600},
+		{Day: store.UsageDay(now), Downlink: 500},
+	}
+	quotas := map[string]int64{"BASIC": 1000, "PLUS": 5000}
+
+	basic := store.Subscription{PlanID: "BASIC", Status: store.SubscriptionStatusActive, CurrentPeriodStart: &start, CurrentPeriodEnd: &end}
+	allowance, ok := BestAllowance(quotas, []store.Subscription{basic}, usage, now)
+	if !ok || allowance.Used != 1100 || !allowance.Exceeded() || allowance.Remaining() != 0 {
+		t.Fatalf("expected exceeded basic allowance, got %+v", allowance)
+	}
+
+	plus := store.Subscription{PlanID: "PLUS", Status: store.SubscriptionStatusTrialing, CurrentPeriodStart: &start, CurrentPeriodEnd: &end}
+	expired := store.Subscription{PlanID: "UNLIMITED", Status: store.SubscriptionStatusActive, CurrentPeriodEnd: &lapsed}
+	allowance, ok = BestAllowance(quotas, []store.Subscription{basic, expired, plus}, usage, now)
+	if !ok || allowance.PlanID != "PLUS" || allowance.Remaining() != 3900 {
+		t.Fatalf("expected the plus allowance, got %+v", allowance)
+	}
+
+	unlimited := store.Subscription{PlanID: "UNLIMITED", Status: store.SubscriptionStatusActive}
+	allowance, ok = BestAllowance(quotas, []store.Subscription{plus, unlimited}, usage, now)
+	if !ok || !allowance.Unlimited() || allowance.Exceeded() || allowance.Remaining() != -1 {
+		t.Fatalf("expected unlimited allowance, got %+v", allowance)
+	}
+
+	if _, ok := BestAllowance(quotas, []store.Subscription{expired}, usage, now); ok {
+		t.Fatal("expected no allowance without an entitled subscription")
+	}
+}
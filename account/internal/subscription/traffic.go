@@ -0,0 +1,87 @@
+package subscription
+
+import (
+	"time"
+
+	"account/internal/store"
+)
+
+// TrafficAllowance is the traffic quota granted by a subscription and the
+// usage counted against it in the current period.
+type TrafficAllowance struct {
+	PlanID      string
+	Quota       int64
+	Used        int64
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time
+}
+
+// Unlimited reports whether the allowance has no quota.
+func (a TrafficAllowance) Unlimited() bool {
+	return a.Quota <= 0
+}
+
+// Remaining returns the bytes left in the period, or -1 when unlimited.
+func (a TrafficAllowance) Remaining() int64 {
+	if a.Unlimited() {
+		return -1
+	}
+	if a.Used >= a.Quota {
+		return 0
+	}
+	return a.Quota - a.Used
+}
+
+// Exceeded reports whether the quota has been used up.
+func (a TrafficAllowance) Exceeded() bool {
+	return !a.Unlimited() && a.Used >= a.Quota
+}
+
+// UsedSince sums the usage recorded on or after the day of start. A nil start
+// counts all usage.
+func UsedSince(usage []store.TrafficUsage, start *time.Time) int64 {
+	var total int64
+	for _, entry := range usage {
+		if start != nil && entry.Day.Before(store.UsageDay(*start)) {
+			continue
+		}
+		total += entry.Total()
+	}
+	return total
+}
+
+// BestAllowance returns the most generous allowance among the subscriptions
+// entitled at now, using quotas keyed by plan ID and the daily usage of the
+// subscriber. Usage is counted from the day the subscription's period
+// started. ok is false when no subscription is entitled.
+func BestAllowance(quotas map[string]int64, subscriptions []store.Subscription, usage []store.TrafficUsage, now time.Time) (best TrafficAllowance, ok bool) {
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if !sub.Entitled(now) {
+			continue
+		}
+		allowance := TrafficAllowance{
+			PlanID:      sub.PlanID,
+			Quota:       quotas[sub.PlanID],
+			PeriodStart: sub.CurrentPeriodStart,
+			PeriodEnd:   sub.CurrentPeriodEnd,
+		}
+		if !allowance.Unlimited() {
+			allowance.Used = UsedSince(usage, sub.CurrentPeriodStart)
+		}
+		if !ok || betterAllowance(allowance, best) {
+			best, ok = allowance, true
+		}
+	}
+	return best, ok
+}
+
+func betterAllowance(candidate, current TrafficAllowance) bool {
+	if current.Unlimited() {
+		return false
+	}
+	if candidate.Unlimited() {
+		return true
+	}
+	return candidate.Remaining() > current.Remaining()
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "context"
+
+// StartLoop runs loop in a goroutine with a context derived from ctx. The
+// returned stop function cancels that context and waits for loop to return,
+// giving up when waitCtx is done first.
+func StartLoop(ctx context.Context, loop func(context.Context)) (stop func(waitCtx context.Context) error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		loop(runCtx)
+	}()
+	return func(waitCtx context.Context) error {
+		cancel()
+		if waitCtx == nil {
+			waitCtx = context.Background()
+		}
+		select {
+		case <-done:
+			return nil
+		case <-waitCtx.Done():
+			return waitCtx.Err()
+		}
+	}
+}
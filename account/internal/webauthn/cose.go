@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) accepted for credentials.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters.
+const (
+	coseKeyType      int64 = 1
+	coseKeyAlgorithm int64 = 3
+	coseKeyCurve     int64 = -1 // EC2 and OKP
+	coseKeyX         int64 = -2 // EC2 and OKP
+	coseKeyY         int64 = -3 // EC2
+	coseKeyModulus   int64 = -1 // RSA
+	coseKeyExponent  int64 = -2 // RSA
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+	coseKeyTypeRSA int64 = 3
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+)
+
+// supportedAlgorithms is the order in which algorithms are offered to
+// authenticators.
+var supportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey decodes a COSE_Key as stored in attested credential data.
+func parseCOSEKey(raw []byte) (coseKey, error) {
+	decoded, err := decodeCBOR(raw)
+	if err != nil {
+		return coseKey{}, err
+	}
+	params, ok := decoded.(map[any]any)
+	if !ok {
+		return coseKey{}, errors.New("cose key is not a map")
+	}
+
+	kty, _ := params[coseKeyType].(int64)
+	alg, _ := params[coseKeyAlgorithm].(int64)
+	switch alg {
+	case AlgES256:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+		if kty != coseKeyTypeEC2 || crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errors.New("invalid ES256 cose key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return coseKey{}, errors.New("ES256 cose key is not on the curve")
+		}
+		return coseKey{alg: alg, key: pub}, nil
+	case AlgEdDSA:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		if kty != coseKeyTypeOKP || crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return coseKey{}, errors.New("invalid EdDSA cose key")
+		}
+		return coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case AlgRS256:
+		n, _ := params[coseKeyModulus].([]byte)
+		e, _ := params[coseKeyExponent].([]byte)
+		if kty != coseKeyTypeRSA || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errors.New("invalid RS256 cose key")
+		}
+		return coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return coseKey{}, fmt.Errorf("unsupported cose algorithm %d", alg)
+	}
+}
+
+// verify checks sig over data with the key's algorithm.
+func (k coseKey) verify(data, sig []byte) error {
+	return verifySignature(k.alg, k.key, data, sig)
+}
+
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 requires an ECDSA key")
+		}
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("EdDSA requires an Ed25519 key")
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 requires an RSA key")
+		}
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	default:
+		return fmt.Errorf("unsupported cose algorithm %d", alg)
+	}
+}
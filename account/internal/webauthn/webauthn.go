@@ -0,0 +1,502 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies (W3C Web Authentication Level 2)
+// for the account service. Attestation is limited to the "none" and "packed"
+// formats; attestation certificates are not checked against a trust store
+// because credentials are only used to authenticate their owner.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrVerificationFailed is returned, wrapped with the reason, when a
+// registration or assertion response does not verify.
+var ErrVerificationFailed = errors.New("webauthn verification failed")
+
+// ErrSignCountRegressed is returned when an authenticator reports a signature
+// counter that did not increase, which indicates a cloned authenticator.
+var ErrSignCountRegressed = errors.New("webauthn signature counter did not increase")
+
+const (
+	challengeSize  = 32
+	defaultTimeout = 5 * time.Minute
+
+	flagUserPresent        byte = 0x01
+	flagUserVerified       byte = 0x04
+	flagAttestedCredential byte = 0x40
+
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+)
+
+// User verification requirements.
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// Config identifies the relying party.
+type Config struct {
+	// RPID is the relying party ID, usually the registrable domain of the
+	// site, for example "example.com".
+	RPID string
+	// RPName is the human readable name shown by authenticators.
+	RPName string
+	// Origins lists the exact origins, for example "https://example.com",
+	// from which ceremonies are accepted.
+	Origins []string
+	// Timeout bounds how long a ceremony may take. It defaults to five
+	// minutes.
+	Timeout time.Duration
+}
+
+// Validate reports whether the configuration is usable.
+func (cfg Config) Validate() error {
+	if strings.TrimSpace(cfg.RPID) == "" {
+		return errors.New("webauthn relying party id is required")
+	}
+	if len(cfg.Origins) == 0 {
+		return errors.New("webauthn requires at least one allowed origin")
+	}
+	return nil
+}
+
+// CeremonyTimeout returns the effective ceremony timeout.
+func (cfg Config) CeremonyTimeout() time.Duration {
+	if cfg.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return cfg.Timeout
+}
+
+// Credential is a verified public key credential.
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// RelyingParty is PublicKeyCredentialRpEntity.
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity is PublicKeyCredentialUserEntity. ID is base64url encoded.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is PublicKeyCredentialParameters.
+type CredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+// CredentialDescriptor is PublicKeyCredentialDescriptor. ID is base64url
+// encoded.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection is AuthenticatorSelectionCriteria.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is PublicKeyCredentialCreationOptions in the JSON form
+// expected by navigator.credentials.create after base64url decoding.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is PublicKeyCredentialRequestOptions in JSON form.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the JSON serialisation of the PublicKeyCredential
+// returned by navigator.credentials.create. Binary fields are base64url.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON serialisation of the PublicKeyCredential
+// returned by navigator.credentials.get. Binary fields are base64url.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// CredentialID decodes the credential ID of the assertion.
+func (r AssertionResponse) CredentialID() ([]byte, error) {
+	id := r.RawID
+	if id == "" {
+		id = r.ID
+	}
+	return DecodeBase64(id)
+}
+
+// UserHandle decodes the user handle returned by discoverable credentials.
+func (r AssertionResponse) UserHandle() ([]byte, error) {
+	return DecodeBase64(r.Response.UserHandle)
+}
+
+// NewChallenge returns a random ceremony challenge.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// EncodeBase64 encodes binary WebAuthn values as unpadded base64url.
+func EncodeBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeBase64 decodes base64url with or without padding.
+func DecodeBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+}
+
+// CreationOptions builds the options for registering a credential for user.
+// Credentials in exclude are rejected by authenticators that already hold
+// them.
+func (cfg Config) CreationOptions(challenge []byte, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]CredentialParameter, 0, len(supportedAlgorithms))
+	for _, alg := range supportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Algorithm: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	name := cfg.RPName
+	if strings.TrimSpace(name) == "" {
+		name = cfg.RPID
+	}
+	return CreationOptions{
+		Challenge:          EncodeBase64(challenge),
+		RP:                 RelyingParty{ID: cfg.RPID, Name: name},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            cfg.CeremonyTimeout().Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			// Discoverable credentials enable passwordless sign in.
+			ResidentKey:      "preferred",
+			UserVerification: UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options for an authentication ceremony. An empty
+// allow list lets the user pick any discoverable credential for the RP.
+func (cfg Config) RequestOptions(challenge []byte, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        EncodeBase64(challenge),
+		Timeout:          cfg.CeremonyTimeout().Milliseconds(),
+		RPID:             cfg.RPID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// VerifyRegistration verifies an attestation response for challenge and
+// returns the new credential.
+func (cfg Config) VerifyRegistration(challenge []byte, resp AttestationResponse, requireUserVerification bool) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, verificationError("unexpected credential type %q", resp.Type)
+	}
+
+	clientDataJSON, err := DecodeBase64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, verificationError("invalid clientDataJSON encoding")
+	}
+	if err := cfg.verifyClientData(clientDataJSON, clientDataTypeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := DecodeBase64(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, verificationError("invalid attestationObject encoding")
+	}
+	decoded, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, verificationError("invalid attestation object: %v", err)
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, verificationError("attestation object is not a map")
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil {
+		return nil, verificationError("attestation statement is missing")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredential == 0 || len(authData.credentialID) == 0 {
+		return nil, verificationError("attested credential data is missing")
+	}
+
+	key, err := parseCOSEKey(authData.credentialKey)
+	if err != nil {
+		return nil, verificationError("%v", err)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := verifyAttestationStatement(format, statement, key, signed); err != nil {
+		return nil, err
+	}
+
+	if rawID, err := DecodeBase64(resp.RawID); err == nil && len(rawID) > 0 && !bytes.Equal(rawID, authData.credentialID) {
+		return nil, verificationError("credential id does not match attested credential data")
+	}
+
+	return &Credential{
+		ID:         authData.credentialID,
+		PublicKey:  authData.credentialKey,
+		SignCount:  authData.signCount,
+		AAGUID:     authData.aaguid,
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion verifies an assertion response for challenge against a
+// stored credential and returns the new signature counter.
+func (cfg Config) VerifyAssertion(challenge []byte, credential Credential, resp AssertionResponse, requireUserVerification bool) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, verificationError("unexpected credential type %q", resp.Type)
+	}
+	credentialID, err := resp.CredentialID()
+	if err != nil || !bytes.Equal(credentialID, credential.ID) {
+		return 0, verificationError("credential id does not match")
+	}
+
+	clientDataJSON, err := DecodeBase64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return 0, verificationError("invalid clientDataJSON encoding")
+	}
+	if err := cfg.verifyClientData(clientDataJSON, clientDataTypeGet, challenge); err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := DecodeBase64(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, verificationError("invalid authenticatorData encoding")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := cfg.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return 0, err
+	}
+
+	signature, err := DecodeBase64(resp.Response.Signature)
+	if err != nil {
+		return 0, verificationError("invalid signature encoding")
+	}
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, verificationError("stored credential key: %v", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return 0, verificationError("%v", err)
+	}
+
+	// Authenticators that do not implement a counter always report zero.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+	return authData.signCount, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (cfg Config) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return verificationError("invalid clientDataJSON: %v", err)
+	}
+	if data.Type != ceremony {
+		return verificationError("unexpected client data type %q", data.Type)
+	}
+	received, err := DecodeBase64(data.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return verificationError("challenge mismatch")
+	}
+	for _, origin := range cfg.Origins {
+		if data.Origin == strings.TrimRight(strings.TrimSpace(origin), "/") {
+			return nil
+		}
+	}
+	return verificationError("origin %q is not allowed", data.Origin)
+}
+
+type authenticatorData struct {
+	rpIDHash      []byte
+	flags         byte
+	signCount     uint32
+	aaguid        []byte
+	credentialID  []byte
+	credentialKey []byte
+}
+
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	if len(raw) < 37 {
+		return authenticatorData{}, verificationError("authenticator data is too short")
+	}
+	data := authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.flags&flagAttestedCredential == 0 {
+		return data, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, verificationError("attested credential data is too short")
+	}
+	data.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return authenticatorData{}, verificationError("invalid credential id length")
+	}
+	data.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The credential public key is followed by extensions, if any.
+	_, extensions, err := decodeCBORPrefix(rest)
+	if err != nil {
+		return authenticatorData{}, verificationError("invalid credential public key: %v", err)
+	}
+	data.credentialKey = rest[:len(rest)-len(extensions)]
+	return data, nil
+}
+
+func (cfg Config) verifyAuthenticatorData(data authenticatorData, requireUserVerification bool) error {
+	expected := sha256.Sum256([]byte(cfg.RPID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, expected[:]) != 1 {
+		return verificationError("relying party id hash mismatch")
+	}
+	if data.flags&flagUserPresent == 0 {
+		return verificationError("user presence flag is not set")
+	}
+	if requireUserVerification && data.flags&flagUserVerified == 0 {
+		return verificationError("user verification flag is not set")
+	}
+	return nil
+}
+
+func verifyAttestationStatement(format string, statement map[any]any, key coseKey, signed []byte) error {
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return verificationError("none attestation must have an empty statement")
+		}
+		return nil
+	case "packed":
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+		if len(sig) == 0 {
+			return verificationError("packed attestation signature is missing")
+		}
+		chain, hasChain := statement["x5c"].([]any)
+		if !hasChain {
+			// Self attestation is signed with the credential key itself.
+			if alg != key.alg {
+				return verificationError("packed self attestation algorithm mismatch")
+			}
+			if err := key.verify(signed, sig); err != nil {
+				return verificationError("packed attestation: %v", err)
+			}
+			return nil
+		}
+		if len(chain) == 0 {
+			return verificationError("packed attestation certificate is missing")
+		}
+		der, _ := chain[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return verificationError("packed attestation certificate: %v", err)
+		}
+		if err := verifySignature(alg, cert.PublicKey, signed, sig); err != nil {
+			return verificationError("packed attestation: %v", err)
+		}
+		return nil
+	default:
+		return verificationError("unsupported attestation format %q", format)
+	}
+}
+
+func verificationError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrVerificationFailed, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,133 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"account/internal/webauthn"
+	"account/internal/webauthn/webauthntest"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+func testConfig() webauthn.Config {
+	return webauthn.Config{RPID: testRPID, RPName: "Example", Origins: []string{testOrigin}}
+}
+
+func register(t *testing.T, cfg webauthn.Config, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+	options := cfg.CreationOptions(challenge, webauthn.UserEntity{ID: webauthn.EncodeBase64([]byte("user-1")), Name: "alice"}, nil)
+	resp, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create credential: %v", err)
+	}
+	credential, err := cfg.VerifyRegistration(challenge, resp, true)
+	if err != nil {
+		t.Fatalf("expected registration to verify: %v", err)
+	}
+	return credential
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	cfg := testConfig()
+	authenticator := webauthntest.New(testRPID, testOrigin)
+	credential := register(t, cfg, authenticator)
+	if len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+		t.Fatalf("expected credential id and key, got %+v", credential)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	options := cfg.RequestOptions(challenge, []webauthn.CredentialDescriptor{{Type: "public-key", ID: webauthn.EncodeBase64(credential.ID)}}, webauthn.UserVerificationRequired)
+	resp, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to assert: %v", err)
+	}
+	signCount, err := cfg.VerifyAssertion(challenge, *credential, resp, true)
+	if err != nil {
+		t.Fatalf("expected assertion to verify: %v", err)
+	}
+	if signCount != 1 {
+		t.Fatalf("expected sign count 1, got %d", signCount)
+	}
+
+	// Replaying the response against a fresh challenge must fail.
+	other, _ := webauthn.NewChallenge()
+	if _, err := cfg.VerifyAssertion(other, *credential, resp, true); !errors.Is(err, webauthn.ErrVerificationFailed) {
+		t.Fatalf("expected challenge mismatch, got %v", err)
+	}
+
+	// A counter that does not advance indicates a cloned authenticator.
+	credential.SignCount = signCount
+	if _, err := cfg.VerifyAssertion(challenge, *credential, resp, true); !errors.Is(err, webauthn.ErrSignCountRegressed) {
+		t.Fatalf("expected sign count regression, got %v", err)
+	}
+}
+
+func TestVerificationRejectsTamperedResponses(t *testing.T) {
+	cfg := testConfig()
+	authenticator := webauthntest.New(testRPID, testOrigin)
+	credential := register(t, cfg, authenticator)
+
+	challenge, _ := webauthn.NewChallenge()
+	options := cfg.RequestOptions(challenge, nil, webauthn.UserVerificationPreferred)
+
+	t.Run("origin", func(t *testing.T) {
+		phishing := *authenticator
+		phishing.Origin = "https://example.com.evil.test"
+		resp, err := phishing.Get(options)
+		if err != nil {
+			t.Fatalf("authenticator failed to assert: %v", err)
+		}
+		if _, err := cfg.VerifyAssertion(challenge, *credential, resp, false); !errors.Is(err, webauthn.ErrVerificationFailed) {
+			t.Fatalf("expected origin to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		resp, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("authenticator failed to assert: %v", err)
+		}
+		var clientData map[string]any
+		raw, _ := webauthn.DecodeBase64(resp.Response.ClientDataJSON)
+		_ = json.Unmarshal(raw, &clientData)
+		clientData["crossOrigin"] = true
+		tampered, _ := json.Marshal(clientData)
+		resp.Response.ClientDataJSON = webauthn.EncodeBase64(tampered)
+		if _, err := cfg.VerifyAssertion(challenge, *credential, resp, false); !errors.Is(err, webauthn.ErrVerificationFailed) {
+			t.Fatalf("expected signature to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("user verification", func(t *testing.T) {
+		authenticator.SkipUserVerification = true
+		defer func() { authenticator.SkipUserVerification = false }()
+		resp, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("authenticator failed to assert: %v", err)
+		}
+		if _, err := cfg.VerifyAssertion(challenge, *credential, resp, true); !errors.Is(err, webauthn.ErrVerificationFailed) {
+			t.Fatalf("expected missing user verification to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("relying party", func(t *testing.T) {
+		other := cfg
+		other.RPID = "other.example"
+		resp, err := authenticator.Get(options)
+		if err != nil {
+			t.Fatalf("authenticator failed to assert: %v", err)
+		}
+		if _, err := other.VerifyAssertion(challenge, *credential, resp, false); !errors.Is(err, webauthn.ErrVerificationFailed) {
+			t.Fatalf("expected rp id mismatch to be rejected, got %v", err)
+		}
+	})
+}
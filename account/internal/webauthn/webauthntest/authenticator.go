@@ -0,0 +1,252 @@
+// Package webauthntest provides a software WebAuthn authenticator for tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"account/internal/webauthn"
+)
+
+const (
+	flagUserPresent        byte = 0x01
+	flagUserVerified       byte = 0x04
+	flagAttestedCredential byte = 0x40
+)
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	signCount  uint32
+}
+
+// Authenticator is a discoverable-credential capable ES256 authenticator that
+// always verifies the user and uses "none" attestation.
+type Authenticator struct {
+	RPID   string
+	Origin string
+	// SkipUserVerification clears the UV flag in responses.
+	SkipUserVerification bool
+
+	credentials []*credential
+}
+
+// New returns an authenticator bound to rpID that reports origin in its
+// client data.
+func New(rpID, origin string) *Authenticator {
+	return &Authenticator{RPID: rpID, Origin: origin}
+}
+
+// Create performs navigator.credentials.create for options.
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.AttestationResponse, error) {
+	var resp webauthn.AttestationResponse
+	if options.RP.ID != a.RPID {
+		return resp, errors.New("webauthntest: relying party id mismatch")
+	}
+	userHandle, err := webauthn.DecodeBase64(options.User.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(excluded.ID) != nil {
+			return resp, errors.New("webauthntest: credential already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return resp, err
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return resp, err
+	}
+	cred := &credential{id: id, key: key, userHandle: userHandle}
+	a.credentials = append(a.credentials, cred)
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.PublicKey.X.FillBytes(x)
+	key.PublicKey.Y.FillBytes(y)
+	publicKey := encodeCBOR(map[int64]any{1: int64(2), 3: webauthn.AlgES256, -1: int64(1), -2: x, -3: y})
+
+	authData := a.authenticatorData(flagAttestedCredential, cred.signCount)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, publicKey...)
+
+	clientData, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return resp, err
+	}
+	attestation := encodeCBOR(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+
+	resp.ID = webauthn.EncodeBase64(id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = webauthn.EncodeBase64(clientData)
+	resp.Response.AttestationObject = webauthn.EncodeBase64(attestation)
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Get performs navigator.credentials.get for options. Without an allow list
+// the most recently created credential is used.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var resp webauthn.AssertionResponse
+	if options.RPID != a.RPID {
+		return resp, errors.New("webauthntest: relying party id mismatch")
+	}
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		if len(a.credentials) > 0 {
+			cred = a.credentials[len(a.credentials)-1]
+		}
+	} else {
+		for _, allowed := range options.AllowCredentials {
+			if cred = a.find(allowed.ID); cred != nil {
+				break
+			}
+		}
+	}
+	if cred == nil {
+		return resp, errors.New("webauthntest: no matching credential")
+	}
+
+	cred.signCount++
+	authData := a.authenticatorData(0, cred.signCount)
+	clientData, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return resp, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return resp, err
+	}
+
+	resp.ID = webauthn.EncodeBase64(cred.id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = webauthn.EncodeBase64(clientData)
+	resp.Response.AuthenticatorData = webauthn.EncodeBase64(authData)
+	resp.Response.Signature = webauthn.EncodeBase64(signature)
+	resp.Response.UserHandle = webauthn.EncodeBase64(cred.userHandle)
+	return resp, nil
+}
+
+func (a *Authenticator) find(encodedID string) *credential {
+	id, err := webauthn.DecodeBase64(encodedID)
+	if err != nil {
+		return nil
+	}
+	for _, cred := range a.credentials {
+		if string(cred.id) == string(id) {
+			return cred
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(flags byte, signCount uint32) []byte {
+	flags |= flagUserPresent
+	if !a.SkipUserVerification {
+		flags |= flagUserVerified
+	}
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+// encodeCBOR encodes the handful of types used in attestation objects and
+// COSE keys.
+func encodeCBOR(value any) []byte {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 {
+			return cborHead(0, uint64(v))
+		}
+		return cborHead(1, uint64(-1-v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// CTAP2 canonical ordering: shorter keys first, then bytewise.
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		out := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			out = append(out, encodeCBOR(key)...)
+			out = append(out, encodeCBOR(v[key])...)
+		}
+		return out
+	case map[int64]any:
+		keys := make([]int64, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := encodeCBOR(keys[i]), encodeCBOR(keys[j])
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return string(a) < string(b)
+		})
+		out := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			out = append(out, encodeCBOR(key)...)
+			out = append(out, encodeCBOR(v[key])...)
+		}
+		return out
+	default:
+		panic("webauthntest: unsupported cbor value")
+	}
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return []byte{major | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major | 27}, arg)
+	}
+}
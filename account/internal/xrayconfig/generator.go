@@ -16,12 +16,18 @@ const (
 	DefaultFlow = "xtls-rprx-vision"
 )
 
-// Client represents an entry under inbounds[0].settings.clients[] in the Xray
-// config.
+// Client is a user the generated config grants access to. Each managed
+// inbound receives an entry derived from it for the inbound's protocol.
 type Client struct {
 	ID    string
 	Email string
-	Flow  string
+	// Flow overrides DefaultFlow on VLESS inbounds that support flows.
+	Flow string
+	// Password authenticates the client on Trojan and Shadowsocks inbounds.
+	// When empty it is derived from ID.
+	Password string
+	// Groups restrict the client to the inbounds admitting one of them.
+	Groups []string
 }
 
 // Generator updates the Xray configuration file based on a template and a set of
@@ -38,6 +44,11 @@ type Generator struct {
 	// FileMode controls the permissions for the generated file. When zero it
 	// defaults to 0644.
 	FileMode fs.FileMode
+
+	// Inbounds selects the template inbounds, by tag, whose clients are
+	// managed. When empty every inbound with a supported protocol and a
+	// settings.clients array is managed and admits all clients.
+	Inbounds []Inbound
 }
 
 // Generate writes a new Xray configuration with the provided clients. The base
@@ -52,7 +63,11 @@ func (g Generator) Generate(clients []Client) error {
 	if err != nil {
 		return err
 	}
+	return g.write(buf)
+}
 
+// write atomically replaces the output file with buf.
+func (g Generator) write(buf []byte) error {
 	mode := g.FileMode
 	if mode == 0 {
 		mode = 0o644
@@ -77,7 +92,7 @@ func (g Generator) Render(clients []Client) ([]byte, error) {
 		return nil, fmt.Errorf("load template: %w", err)
 	}
 
-	if err := replaceClients(root, clients); err != nil {
+	if err := applyClients(root, clients, g.Inbounds); err != nil {
 		return nil, err
 	}
 
@@ -89,70 +104,74 @@ func (g Generator) Render(clients []Client) ([]byte, error) {
 	return buf, nil
 }
 
-func replaceClients(root map[string]interface{}, clients []Client) error {
-	inboundsValue, ok := root["inbounds"]
-	if !ok {
-		return errors.New("template missing inbounds array")
+// RenderClient returns a client side configuration for a single user. The
+// credentials are injected into every vnext entry of the first outbound. When
+// Definition is nil, DefaultClientDefinition is used.
+func (g Generator) RenderClient(client Client) ([]byte, error) {
+	definition := g.Definition
+	if definition == nil {
+		definition = DefaultClientDefinition()
 	}
 
-	inboundsSlice, ok := inboundsValue.([]interface{})
-	if !ok {
-		return fmt.Errorf("template inbounds has unexpected type %T", inboundsValue)
-	}
-	if len(inboundsSlice) == 0 {
-		return errors.New("template missing inbound entry")
+	root, err := definition.Base()
+	if err != nil {
+		return nil, fmt.Errorf("load template: %w", err)
 	}
 
-	clientObjects := make([]interface{}, 0, len(clients))
-	for idx, client := range clients {
-		id := strings.TrimSpace(client.ID)
-		if id == "" {
-			return fmt.Errorf("client %d missing id", idx)
-		}
-		entry := map[string]interface{}{
-			"id": id,
-		}
-		if email := strings.TrimSpace(client.Email); email != "" {
-			entry["email"] = email
-		}
-		flow := strings.TrimSpace(client.Flow)
-		if flow == "" {
-			flow = DefaultFlow
-		}
-		entry["flow"] = flow
-		clientObjects = append(clientObjects, entry)
+	if err := replaceOutboundUser(root, client); err != nil {
+		return nil, err
 	}
 
-	inbound, ok := inboundsSlice[0].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("template inbound 0 has unexpected type %T", inboundsSlice[0])
+	buf, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
 	}
+	buf = append(buf, '\n')
+	return buf, nil
+}
 
-	settingsValue, ok := inbound["settings"]
-	if !ok {
-		return errors.New("template inbound missing settings object")
+func replaceOutboundUser(root map[string]interface{}, client Client) error {
+	id := strings.TrimSpace(client.ID)
+	if id == "" {
+		return errors.New("client missing id")
+	}
+	flow := strings.TrimSpace(client.Flow)
+	if flow == "" {
+		flow = DefaultFlow
 	}
 
-	settingsMap, ok := settingsValue.(map[string]interface{})
+	outboundsSlice, ok := root["outbounds"].([]interface{})
+	if !ok || len(outboundsSlice) == 0 {
+		return errors.New("template missing outbound entry")
+	}
+	outbound, ok := outboundsSlice[0].(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("template inbound settings has unexpected type %T", settingsValue)
+		return fmt.Errorf("template outbound 0 has unexpected type %T", outboundsSlice[0])
 	}
-
-	clientsValue, ok := settingsMap["clients"]
+	settingsMap, ok := outbound["settings"].(map[string]interface{})
 	if !ok {
-		return errors.New("template inbound missing clients array")
+		return errors.New("template outbound missing settings object")
 	}
-
-	if _, ok := clientsValue.([]interface{}); !ok {
-		return fmt.Errorf("template inbound clients has unexpected type %T", clientsValue)
+	vnextSlice, ok := settingsMap["vnext"].([]interface{})
+	if !ok || len(vnextSlice) == 0 {
+		return errors.New("template outbound missing vnext array")
 	}
 
-	// Always replace the clients array so the config reflects the exact
-	// state from the database.
-	settingsMap["clients"] = clientObjects
-	inbound["settings"] = settingsMap
-	inboundsSlice[0] = inbound
-	root["inbounds"] = inboundsSlice
+	for idx, value := range vnextSlice {
+		server, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("template vnext %d has unexpected type %T", idx, value)
+		}
+		user := map[string]interface{}{
+			"id":         id,
+			"encryption": "none",
+			"flow":       flow,
+		}
+		if email := strings.TrimSpace(client.Email); email != "" {
+			user["email"] = email
+		}
+		server["users"] = []interface{}{user}
+	}
 	return nil
 }
 
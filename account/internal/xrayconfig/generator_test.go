@@ -77,7 +77,7 @@ func TestGeneratorGenerate(t *testing.T) {
 	if clientsSection[0].Flow != "xtls-rprx-vision" {
 		t.Fatalf("unexpected first client flow: %+v", clientsSection[0])
 	}
-	if clientsSection[1].ID != "uuid-b" || clientsSection[1].Email != "" || clientsSection[1].Flow != DefaultFlow {
+	if clientsSection[1].ID != "uuid-b" || clientsSection[1].Email != "uuid-b" || clientsSection[1].Flow != DefaultFlow {
 		t.Fatalf("unexpected second client: %+v", clientsSection[1])
 	}
 
@@ -120,3 +120,54 @@ func TestGeneratorRenderUsesDefaultDefinition(t *testing.T) {
 		t.Fatal("expected render output to end with newline")
 	}
 }
+
+func TestGeneratorRenderClient(t *testing.T) {
+	gen := Generator{}
+
+	raw, err := gen.RenderClient(Client{ID: "uuid-a", Email: "a@demo"})
+	if err != nil {
+		t.Fatalf("render client: %v", err)
+	}
+
+	var cfg struct {
+		Outbounds []struct {
+			Protocol string `json:"protocol"`
+			Settings struct {
+				Vnext []struct {
+					Address string `json:"address"`
+					Users   []struct {
+						ID         string `json:"id"`
+						Encryption string `json:"encryption"`
+						Flow       string `json:"flow"`
+					} `json:"users"`
+				} `json:"vnext"`
+			} `json:"settings"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if len(cfg.Outbounds) == 0 || cfg.Outbounds[0].Protocol != "vless" {
+		t.Fatalf("expected vless outbound, got %+v", cfg.Outbounds)
+	}
+	vnext := cfg.Outbounds[0].Settings.Vnext
+	if len(vnext) != 1 || len(vnext[0].Users) != 1 {
+		t.Fatalf("expected a single user entry, got %+v", vnext)
+	}
+	user := vnext[0].Users[0]
+	if user.ID != "uuid-a" || user.Encryption != "none" || user.Flow != DefaultFlow {
+		t.Fatalf("unexpected user entry %+v", user)
+	}
+
+	again, err := gen.RenderClient(Client{ID: "uuid-a", Email: "a@demo"})
+	if err != nil {
+		t.Fatalf("render client again: %v", err)
+	}
+	if string(again) != string(raw) {
+		t.Fatalf("expected deterministic output")
+	}
+
+	if _, err := gen.RenderClient(Client{}); err == nil {
+		t.Fatalf("expected error for missing id")
+	}
+}
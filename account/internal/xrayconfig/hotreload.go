@@ -0,0 +1,264 @@
+package xrayconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DefaultAPIServer is the address of the API listener in the bundled server
+// template.
+const DefaultAPIServer = "127.0.0.1:10085"
+
+// UserManager adds and removes the users of running Xray inbounds, so client
+// changes apply without restarting Xray and dropping live connections.
+type UserManager interface {
+	// AddUsers adds the clients listed in each inbound, a rendered inbound
+	// object, to the running inbound with the same tag.
+	AddUsers(ctx context.Context, inbounds []map[string]interface{}) error
+	// RemoveUsers removes the users with the given emails from the running
+	// inbound tagged tag.
+	RemoveUsers(ctx context.Context, tag string, emails []string) error
+}
+
+// CommandUserManager manages users through the xray CLI. "xray api adu" and
+// "xray api rmu" call the HandlerService AlterInbound RPC with
+// AddUserOperation and RemoveUserOperation; the template must list
+// HandlerService in api.services.
+type CommandUserManager struct {
+	// Command is the api invocation without subcommand. Defaults to
+	// ["xray", "api"].
+	Command []string
+	// Server is the API listener address. Defaults to DefaultAPIServer.
+	Server string
+
+	runner commandRunner
+}
+
+// AddUsers implements UserManager. The inbounds are passed to "xray api adu"
+// through a temporary config file.
+func (m *CommandUserManager) AddUsers(ctx context.Context, inbounds []map[string]interface{}) error {
+	if len(inbounds) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(map[string]interface{}{"inbounds": inbounds})
+	if err != nil {
+		return fmt.Errorf("encode xray users: %w", err)
+	}
+	file, err := os.CreateTemp("", "xray-users-*.json")
+	if err != nil {
+		return fmt.Errorf("write xray users: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(payload); err != nil {
+		file.Close()
+		return fmt.Errorf("write xray users: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write xray users: %w", err)
+	}
+
+	cmd := append(m.command("adu"), file.Name())
+	if output, err := m.run(ctx, cmd); err != nil {
+		return commandError("add xray users", err, output)
+	}
+	return nil
+}
+
+// RemoveUsers implements UserManager through "xray api rmu".
+func (m *CommandUserManager) RemoveUsers(ctx context.Context, tag string, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	cmd := append(m.command("rmu"), "-tag="+tag)
+	cmd = append(cmd, emails...)
+	if output, err := m.run(ctx, cmd); err != nil {
+		return commandError("remove xray users", err, output)
+	}
+	return nil
+}
+
+func (m *CommandUserManager) command(subcommand string) []string {
+	cmd := append([]string(nil), m.Command...)
+	if len(cmd) == 0 {
+		cmd = []string{"xray", "api"}
+	}
+	server := strings.TrimSpace(m.Server)
+	if server == "" {
+		server = DefaultAPIServer
+	}
+	return append(cmd, subcommand, "--server="+server)
+}
+
+func (m *CommandUserManager) run(ctx context.Context, cmd []string) ([]byte, error) {
+	if m.runner != nil {
+		return m.runner(ctx, cmd)
+	}
+	return defaultCommandRunner(ctx, cmd)
+}
+
+func commandError(action string, err error, output []byte) error {
+	if len(output) > 0 {
+		return fmt.Errorf("%s: %w: %s", action, err, strings.TrimSpace(string(output)))
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+// userChanges lists the client changes of one tagged inbound.
+type userChanges struct {
+	Tag string
+	// Remove holds the emails of removed and modified clients.
+	Remove []string
+	// Add is the rendered inbound with only the added and modified clients.
+	Add map[string]interface{}
+}
+
+// diffUsers compares two rendered configurations. ok is false when they
+// differ in anything but the clients of tagged inbounds, or when a client has
+// no email to be removed by, in which case Xray has to be restarted.
+func diffUsers(previous, next []byte) (changes []userChanges, ok bool) {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(previous, &before); err != nil {
+		return nil, false
+	}
+	if err := json.Unmarshal(next, &after); err != nil {
+		return nil, false
+	}
+	beforeClients, okBefore := detachClients(before)
+	afterClients, okAfter := detachClients(after)
+	if !okBefore || !okAfter || len(beforeClients) != len(afterClients) || !reflect.DeepEqual(before, after) {
+		return nil, false
+	}
+
+	inbounds, _ := after["inbounds"].([]interface{})
+	for idx := range afterClients {
+		if reflect.DeepEqual(beforeClients[idx], afterClients[idx]) {
+			continue
+		}
+		inbound, _ := inbounds[idx].(map[string]interface{})
+		tag, _ := inbound["tag"].(string)
+		if strings.TrimSpace(tag) == "" {
+			return nil, false
+		}
+		old, okOld := clientsByEmail(beforeClients[idx])
+		current, okCurrent := clientsByEmail(afterClients[idx])
+		if !okOld || !okCurrent {
+			return nil, false
+		}
+
+		change := userChanges{Tag: tag}
+		added := make([]interface{}, 0)
+		for email, entry := range old {
+			if updated, exists := current[email]; !exists || !reflect.DeepEqual(entry, updated) {
+				change.Remove = append(change.Remove, email)
+			}
+		}
+		for _, entry := range afterClients[idx] {
+			email := entry.(map[string]interface{})["email"].(string)
+			if previous, exists := old[email]; !exists || !reflect.DeepEqual(previous, entry) {
+				added = append(added, entry)
+			}
+		}
+		sort.Strings(change.Remove)
+		if len(added) > 0 {
+			change.Add = withClients(inbound, added)
+		}
+		changes = append(changes, change)
+	}
+	return changes, true
+}
+
+// detachClients removes settings.clients from every inbound of root and
+// returns them by inbound index; inbounds without a clients array get nil.
+func detachClients(root map[string]interface{}) ([][]interface{}, bool) {
+	inbounds, ok := root["inbounds"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	clients := make([][]interface{}, len(inbounds))
+	for idx, value := range inbounds {
+		inbound, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		settings, ok := inbound["settings"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		list, ok := settings["clients"].([]interface{})
+		if !ok {
+			continue
+		}
+		clients[idx] = list
+		detached := withoutKey(settings, "clients")
+		inbounds[idx] = withEntry(inbound, "settings", detached)
+	}
+	return clients, true
+}
+
+func clientsByEmail(clients []interface{}) (map[string]interface{}, bool) {
+	byEmail := make(map[string]interface{}, len(clients))
+	for _, value := range clients {
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		email, _ := entry["email"].(string)
+		if email == "" {
+			return nil, false
+		}
+		if _, exists := byEmail[email]; exists {
+			return nil, false
+		}
+		byEmail[email] = entry
+	}
+	return byEmail, true
+}
+
+// withClients returns a copy of the detached inbound with clients restored.
+func withClients(inbound map[string]interface{}, clients []interface{}) map[string]interface{} {
+	settings, _ := inbound["settings"].(map[string]interface{})
+	return withEntry(inbound, "settings", withEntry(settings, "clients", clients))
+}
+
+func withEntry(values map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	clone := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		clone[k] = v
+	}
+	clone[key] = value
+	return clone
+}
+
+func withoutKey(values map[string]interface{}, key string) map[string]interface{} {
+	clone := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if k != key {
+			clone[k] = v
+		}
+	}
+	return clone
+}
+
+// applyUsers applies the user changes through the manager, removing before
+// adding so modified clients are replaced.
+func applyUsers(ctx context.Context, manager UserManager, changes []userChanges) error {
+	if manager == nil {
+		return errors.New("no user manager configured")
+	}
+	additions := make([]map[string]interface{}, 0, len(changes))
+	for _, change := range changes {
+		if err := manager.RemoveUsers(ctx, change.Tag, change.Remove); err != nil {
+			return err
+		}
+		if change.Add != nil {
+			additions = append(additions, change.Add)
+		}
+	}
+	return manager.AddUsers(ctx, additions)
+}
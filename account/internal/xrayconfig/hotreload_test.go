@@ -0,0 +1,143 @@
+package xrayconfig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingUsers struct {
+	added   [][]map[string]interface{}
+	removed map[string][]string
+	err     error
+}
+
+func (r *recordingUsers) AddUsers(ctx context.Context, inbounds []map[string]interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.added = append(r.added, inbounds)
+	return nil
+}
+
+func (r *recordingUsers) RemoveUsers(ctx context.Context, tag string, emails []string) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(emails) > 0 {
+		r.removed[tag] = append(r.removed[tag], emails...)
+	}
+	return nil
+}
+
+type mutableSource struct {
+	clients []Client
+}
+
+func (s *mutableSource) ListClients(context.Context) ([]Client, error) {
+	return append([]Client(nil), s.clients...), nil
+}
+
+func TestPeriodicSyncerHotReloadsClientChanges(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "config.json")
+	source := &mutableSource{clients: []Client{{ID: "uuid-a"}, {ID: "uuid-b", Email: "b@example.com"}}}
+	users := &recordingUsers{removed: make(map[string][]string)}
+	definition := &JSONDefinition{Raw: []byte(multiInboundTemplate)}
+	restarts := 0
+	syncer, err := NewPeriodicSyncer(PeriodicOptions{
+		Interval:       time.Minute,
+		Source:         source,
+		Generator:      Generator{Definition: definition, OutputPath: output},
+		RestartCommand: []string{"restart"},
+		Runner: func(context.Context, []string) ([]byte, error) {
+			restarts++
+			return nil, nil
+		},
+		Users: users,
+	})
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+	ctx := context.Background()
+
+	if result := syncer.sync(ctx); result.Error != nil || result.HotReloaded || restarts != 1 {
+		t.Fatalf("expected the first sync to restart xray, got %+v with %d restarts", result, restarts)
+	}
+
+	// uuid-a is removed, uuid-b changes its password and uuid-c is new.
+	source.clients = []Client{{ID: "uuid-b", Email: "b@example.com", Password: "secret"}, {ID: "uuid-c"}}
+	result := syncer.sync(ctx)
+	if result.Error != nil || !result.HotReloaded || restarts != 1 {
+		t.Fatalf("expected client changes to be hot reloaded, got %+v with %d restarts", result, restarts)
+	}
+	if !reflect.DeepEqual(users.removed["trojan"], []string{"b@example.com", "uuid-a"}) || !reflect.DeepEqual(users.removed["vmess-ws"], []string{"uuid-a"}) {
+		t.Fatalf("unexpected removals %v", users.removed)
+	}
+	if len(users.added) != 1 {
+		t.Fatalf("expected one add call, got %d", len(users.added))
+	}
+	addedByTag := make(map[string][]interface{})
+	for _, inbound := range users.added[0] {
+		addedByTag[inbound["tag"].(string)] = inbound["settings"].(map[string]interface{})["clients"].([]interface{})
+	}
+	if len(addedByTag["vmess-ws"]) != 1 || len(addedByTag["trojan"]) != 2 {
+		t.Fatalf("unexpected additions %v", addedByTag)
+	}
+	if data, _ := os.ReadFile(output); !strings.Contains(string(data), "uuid-c") {
+		t.Fatalf("expected the config file to be updated, got %s", data)
+	}
+
+	// Changes outside the clients still require a restart.
+	definition.Raw = []byte(strings.Replace(multiInboundTemplate, `"auth": "noauth"`, `"auth": "password"`, 1))
+	if result := syncer.sync(ctx); result.Error != nil || result.HotReloaded || restarts != 2 {
+		t.Fatalf("expected a template change to restart xray, got %+v with %d restarts", result, restarts)
+	}
+
+	// A failing API falls back to a restart.
+	users.err = errors.New("api unavailable")
+	source.clients = []Client{{ID: "uuid-d"}}
+	if result := syncer.sync(ctx); result.Error != nil || result.HotReloaded || restarts != 3 {
+		t.Fatalf("expected a failed hot reload to restart xray, got %+v with %d restarts", result, restarts)
+	}
+}
+
+func TestCommandUserManager(t *testing.T) {
+	var commands [][]string
+	var payload string
+	manager := &CommandUserManager{Server: "127.0.0.1:1234", runner: func(_ context.Context, cmd []string) ([]byte, error) {
+		commands = append(commands, cmd)
+		if cmd[2] == "adu" {
+			data, err := os.ReadFile(cmd[len(cmd)-1])
+			if err != nil {
+				t.Fatalf("read users file: %v", err)
+			}
+			payload = string(data)
+		}
+		return nil, nil
+	}}
+	ctx := context.Background()
+
+	if err := manager.RemoveUsers(ctx, "trojan", []string{"a@example.com", "uuid-b"}); err != nil {
+		t.Fatalf("remove users: %v", err)
+	}
+	inbound := map[string]interface{}{"tag": "trojan", "settings": map[string]interface{}{"clients": []interface{}{map[string]interface{}{"email": "c"}}}}
+	if err := manager.AddUsers(ctx, []map[string]interface{}{inbound}); err != nil {
+		t.Fatalf("add users: %v", err)
+	}
+
+	expected := []string{"xray", "api", "rmu", "--server=127.0.0.1:1234", "-tag=trojan", "a@example.com", "uuid-b"}
+	if len(commands) != 2 || !reflect.DeepEqual(commands[0], expected) {
+		t.Fatalf("unexpected commands %v", commands)
+	}
+	if commands[1][2] != "adu" || !strings.Contains(payload, `"tag":"trojan"`) {
+		t.Fatalf("unexpected add command %v with payload %s", commands[1], payload)
+	}
+	if _, err := os.Stat(commands[1][len(commands[1])-1]); !os.IsNotExist(err) {
+		t.Fatalf("expected the users file to be removed, got %v", err)
+	}
+}
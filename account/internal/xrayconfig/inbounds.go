@@ -0,0 +1,250 @@
+package xrayconfig
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Inbound protocols whose clients the generator manages.
+const (
+	ProtocolVLESS       = "vless"
+	ProtocolVMess       = "vmess"
+	ProtocolTrojan      = "trojan"
+	ProtocolShadowsocks = "shadowsocks"
+)
+
+// Inbound selects a template inbound by tag and the clients admitted to it.
+type Inbound struct {
+	Tag string
+	// Groups admits only the clients belonging to at least one of the
+	// groups. Empty admits every client.
+	Groups []string
+}
+
+func (in Inbound) admits(client Client) bool {
+	if len(in.Groups) == 0 {
+		return true
+	}
+	for _, group := range in.Groups {
+		for _, member := range client.Groups {
+			if strings.TrimSpace(member) == strings.TrimSpace(group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyClients replaces settings.clients of the managed inbounds with entries
+// for the admitted clients.
+func applyClients(root map[string]interface{}, clients []Client, targets []Inbound) error {
+	inboundsValue, ok := root["inbounds"]
+	if !ok {
+		return errors.New("template missing inbounds array")
+	}
+	inboundsSlice, ok := inboundsValue.([]interface{})
+	if !ok {
+		return fmt.Errorf("template inbounds has unexpected type %T", inboundsValue)
+	}
+	if len(inboundsSlice) == 0 {
+		return errors.New("template missing inbound entry")
+	}
+	for idx, client := range clients {
+		if strings.TrimSpace(client.ID) == "" {
+			return fmt.Errorf("client %d missing id", idx)
+		}
+	}
+
+	if len(targets) == 0 {
+		managed := 0
+		for idx, value := range inboundsSlice {
+			inbound, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("template inbound %d has unexpected type %T", idx, value)
+			}
+			if !isManagedInbound(inbound) {
+				continue
+			}
+			if err := replaceInboundClients(inbound, clients, Inbound{}); err != nil {
+				return fmt.Errorf("inbound %d: %w", idx, err)
+			}
+			managed++
+		}
+		if managed == 0 {
+			return errors.New("template inbound missing clients array")
+		}
+		return nil
+	}
+
+	byTag := make(map[string]map[string]interface{}, len(inboundsSlice))
+	for _, value := range inboundsSlice {
+		inbound, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if tag, _ := inbound["tag"].(string); tag != "" {
+			byTag[tag] = inbound
+		}
+	}
+	for _, target := range targets {
+		tag := strings.TrimSpace(target.Tag)
+		inbound, ok := byTag[tag]
+		if !ok {
+			return fmt.Errorf("template missing inbound with tag %q", tag)
+		}
+		if err := replaceInboundClients(inbound, clients, target); err != nil {
+			return fmt.Errorf("inbound %q: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// isManagedInbound reports whether an inbound carries client credentials the
+// generator knows how to derive. A missing protocol is treated as VLESS, the
+// protocol of the original template.
+func isManagedInbound(inbound map[string]interface{}) bool {
+	settings, ok := inbound["settings"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok := settings["clients"].([]interface{}); !ok {
+		return false
+	}
+	switch inboundProtocol(inbound) {
+	case ProtocolVLESS, ProtocolVMess, ProtocolTrojan, ProtocolShadowsocks:
+		return true
+	default:
+		return false
+	}
+}
+
+func inboundProtocol(inbound map[string]interface{}) string {
+	protocol, _ := inbound["protocol"].(string)
+	protocol = strings.ToLower(strings.TrimSpace(protocol))
+	if protocol == "" {
+		return ProtocolVLESS
+	}
+	return protocol
+}
+
+func replaceInboundClients(inbound map[string]interface{}, clients []Client, target Inbound) error {
+	settingsValue, ok := inbound["settings"]
+	if !ok {
+		return errors.New("template inbound missing settings object")
+	}
+	settings, ok := settingsValue.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("template inbound settings has unexpected type %T", settingsValue)
+	}
+	clientsValue, ok := settings["clients"]
+	if !ok {
+		return errors.New("template inbound missing clients array")
+	}
+	if _, ok := clientsValue.([]interface{}); !ok {
+		return fmt.Errorf("template inbound clients has unexpected type %T", clientsValue)
+	}
+
+	protocol := inboundProtocol(inbound)
+	method, _ := settings["method"].(string)
+	flowSupported := supportsFlow(inbound)
+
+	entries := make([]interface{}, 0, len(clients))
+	for _, client := range clients {
+		if !target.admits(client) {
+			continue
+		}
+		id := strings.TrimSpace(client.ID)
+		// Xray only keeps per-user traffic counters for clients with an
+		// email, so the ID stands in for users without one.
+		email := strings.TrimSpace(client.Email)
+		if email == "" {
+			email = id
+		}
+		entry := map[string]interface{}{"email": email}
+
+		switch protocol {
+		case ProtocolVLESS:
+			entry["id"] = id
+			if flowSupported {
+				flow := strings.TrimSpace(client.Flow)
+				if flow == "" {
+					flow = DefaultFlow
+				}
+				entry["flow"] = flow
+			}
+		case ProtocolVMess:
+			entry["id"] = id
+		case ProtocolTrojan:
+			entry["password"] = clientPassword(client)
+		case ProtocolShadowsocks:
+			if strings.HasPrefix(method, "2022-") {
+				password, err := ShadowsocksPassword(client, method)
+				if err != nil {
+					return err
+				}
+				entry["password"] = password
+			} else {
+				entry["password"] = clientPassword(client)
+				if method != "" {
+					entry["method"] = method
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported inbound protocol %q", protocol)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Always replace the clients array so the config reflects the exact
+	// state from the database.
+	settings["clients"] = entries
+	return nil
+}
+
+// supportsFlow reports whether a VLESS inbound accepts XTLS flows, which
+// require the raw TCP transport secured by TLS or REALITY. Unspecified
+// stream settings keep the flow for compatibility with older templates.
+func supportsFlow(inbound map[string]interface{}) bool {
+	stream, ok := inbound["streamSettings"].(map[string]interface{})
+	if !ok {
+		return true
+	}
+	if network, _ := stream["network"].(string); network != "" && network != "tcp" && network != "raw" {
+		return false
+	}
+	if security, _ := stream["security"].(string); security != "" && security != "tls" && security != "reality" {
+		return false
+	}
+	return true
+}
+
+func clientPassword(client Client) string {
+	if password := strings.TrimSpace(client.Password); password != "" {
+		return password
+	}
+	return strings.TrimSpace(client.ID)
+}
+
+// ShadowsocksPassword returns the per-user key of a client on a Shadowsocks
+// 2022 inbound using method. An explicit Client.Password is used as is;
+// otherwise the key is derived from the client ID so the controller and
+// agents agree on it without storing extra secrets.
+func ShadowsocksPassword(client Client, method string) (string, error) {
+	if password := strings.TrimSpace(client.Password); password != "" {
+		return password, nil
+	}
+	var size int
+	switch method {
+	case "2022-blake3-aes-128-gcm":
+		size = 16
+	case "2022-blake3-aes-256-gcm", "2022-blake3-chacha20-poly1305":
+		size = 32
+	default:
+		return "", fmt.Errorf("unsupported shadowsocks method %q", method)
+	}
+	digest := sha256.Sum256([]byte("xcontrol-shadowsocks-2022:" + strings.TrimSpace(client.ID)))
+	return base64.StdEncoding.EncodeToString(digest[:size]), nil
+}
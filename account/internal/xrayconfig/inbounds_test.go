@@ -0,0 +1,113 @@
+package xrayconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const multiInboundTemplate = `{
+  "inbounds": [
+    {"tag": "vless-reality", "protocol": "vless", "settings": {"clients": [], "decryption": "none"},
+     "streamSettings": {"network": "tcp", "security": "reality"}},
+    {"tag": "vmess-ws", "protocol": "vmess", "settings": {"clients": []},
+     "streamSettings": {"network": "ws", "security": "tls"}},
+    {"tag": "vless-ws", "protocol": "vless", "settings": {"clients": [], "decryption": "none"},
+     "streamSettings": {"network": "ws", "security": "tls"}},
+    {"tag": "trojan", "protocol": "trojan", "settings": {"clients": []}},
+    {"tag": "ss2022", "protocol": "shadowsocks", "settings": {"method": "2022-blake3-aes-128-gcm", "password": "c2VydmVyLWtleS0xNmJ5dGU=", "clients": []}},
+    {"tag": "socks", "protocol": "socks", "settings": {"auth": "noauth"}}
+  ]
+}`
+
+func renderInbounds(t *testing.T, gen Generator, clients []Client) map[string][]map[string]interface{} {
+	t.Helper()
+	raw, err := gen.Render(clients)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	var cfg struct {
+		Inbounds []struct {
+			Tag      string `json:"tag"`
+			Settings struct {
+				Clients []map[string]interface{} `json:"clients"`
+			} `json:"settings"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	byTag := make(map[string][]map[string]interface{})
+	for _, inbound := range cfg.Inbounds {
+		byTag[inbound.Tag] = inbound.Settings.Clients
+	}
+	return byTag
+}
+
+func TestGeneratorRendersClientsPerProtocol(t *testing.T) {
+	gen := Generator{Definition: JSONDefinition{Raw: []byte(multiInboundTemplate)}}
+	clients := []Client{{ID: "uuid-a", Email: "a@demo"}, {ID: "uuid-b", Password: "secret-b"}}
+	inbounds := renderInbounds(t, gen, clients)
+
+	reality := inbounds["vless-reality"]
+	if len(reality) != 2 || reality[0]["id"] != "uuid-a" || reality[0]["flow"] != DefaultFlow || reality[1]["email"] != "uuid-b" {
+		t.Fatalf("unexpected vless-reality clients %+v", reality)
+	}
+	if ws := inbounds["vless-ws"]; len(ws) != 2 || ws[0]["flow"] != nil {
+		t.Fatalf("expected no flow on a websocket transport, got %+v", ws)
+	}
+	if vmess := inbounds["vmess-ws"]; len(vmess) != 2 || vmess[1]["id"] != "uuid-b" || vmess[1]["flow"] != nil {
+		t.Fatalf("unexpected vmess clients %+v", vmess)
+	}
+	trojan := inbounds["trojan"]
+	if len(trojan) != 2 || trojan[0]["password"] != "uuid-a" || trojan[1]["password"] != "secret-b" || trojan[0]["id"] != nil {
+		t.Fatalf("unexpected trojan clients %+v", trojan)
+	}
+
+	ss := inbounds["ss2022"]
+	if len(ss) != 2 || ss[1]["password"] != "secret-b" {
+		t.Fatalf("unexpected shadowsocks clients %+v", ss)
+	}
+	key, err := base64.StdEncoding.DecodeString(ss[0]["password"].(string))
+	if err != nil || len(key) != 16 {
+		t.Fatalf("expected a 16 byte derived key, got %v (%v)", ss[0]["password"], err)
+	}
+	if derived, _ := ShadowsocksPassword(clients[0], "2022-blake3-aes-128-gcm"); derived != ss[0]["password"] {
+		t.Fatalf("expected the derived key to be reproducible")
+	}
+	if inbounds["socks"] != nil {
+		t.Fatalf("expected unsupported inbounds to be left untouched")
+	}
+}
+
+func TestGeneratorAssignsClientsToInboundsByGroup(t *testing.T) {
+	gen := Generator{
+		Definition: JSONDefinition{Raw: []byte(multiInboundTemplate)},
+		Inbounds: []Inbound{
+			{Tag: "vless-reality"},
+			{Tag: "trojan", Groups: []string{"premium"}},
+		},
+	}
+	clients := []Client{{ID: "uuid-a"}, {ID: "uuid-b", Groups: []string{"premium"}}}
+	inbounds := renderInbounds(t, gen, clients)
+
+	if len(inbounds["vless-reality"]) != 2 {
+		t.Fatalf("expected every client on the unrestricted inbound, got %+v", inbounds["vless-reality"])
+	}
+	if trojan := inbounds["trojan"]; len(trojan) != 1 || trojan[0]["password"] != "uuid-b" {
+		t.Fatalf("expected only premium clients on trojan, got %+v", trojan)
+	}
+	if len(inbounds["vmess-ws"]) != 0 {
+		t.Fatalf("expected untargeted inbounds to keep their template clients, got %+v", inbounds["vmess-ws"])
+	}
+
+	gen.Inbounds = []Inbound{{Tag: "missing"}}
+	if _, err := gen.Render(clients); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected unknown tag to be rejected, got %v", err)
+	}
+	gen.Inbounds = []Inbound{{Tag: "socks"}}
+	if _, err := gen.Render(clients); err == nil {
+		t.Fatalf("expected an inbound without clients to be rejected")
+	}
+}
@@ -0,0 +1,217 @@
+package xrayconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHistory is the number of applied configurations kept when
+	// PeriodicOptions.History is zero.
+	DefaultHistory = 5
+	// DefaultHealthTimeout bounds the health probe after a restart when
+	// PeriodicOptions.HealthTimeout is zero.
+	DefaultHealthTimeout = 10 * time.Second
+
+	// healthRetryInterval is the pause between failed health probes.
+	healthRetryInterval = time.Second
+
+	lastGoodSuffix     = ".last-good"
+	historySuffix      = ".history"
+	generationTimeForm = "20060102T150405.000000000Z"
+)
+
+// Generation is an applied configuration kept in the history directory.
+type Generation struct {
+	Path      string
+	Hash      string
+	AppliedAt time.Time
+}
+
+// configHash returns the hex encoded SHA-256 digest of a configuration.
+func configHash(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// Generations returns the applied configurations kept in the history
+// directory, newest first.
+func (s *PeriodicSyncer) Generations() ([]Generation, error) {
+	if s.historyDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.historyDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config history: %w", err)
+	}
+
+	generations := make([]Generation, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		stamp, hash, ok := strings.Cut(strings.TrimSuffix(name, ".json"), "-")
+		if !ok {
+			continue
+		}
+		appliedAt, err := time.Parse(generationTimeForm, stamp)
+		if err != nil {
+			continue
+		}
+		generations = append(generations, Generation{
+			Path:      filepath.Join(s.historyDir, name),
+			Hash:      hash,
+			AppliedAt: appliedAt,
+		})
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].AppliedAt.After(generations[j].AppliedAt) })
+	return generations, nil
+}
+
+// lastKnownGood returns the configuration to roll back to: the one applied
+// last, or after a process restart the persisted last-known-good copy and
+// finally the configuration Xray was started with.
+func (s *PeriodicSyncer) lastKnownGood() []byte {
+	if s.applied {
+		return s.lastConfig
+	}
+	for _, path := range []string{s.lastGoodPath(), s.generator.OutputPath} {
+		data, err := os.ReadFile(path)
+		if err == nil && len(bytes.TrimSpace(data)) > 0 {
+			return data
+		}
+	}
+	return nil
+}
+
+func (s *PeriodicSyncer) lastGoodPath() string {
+	return s.generator.OutputPath + lastGoodSuffix
+}
+
+// remember records buf as the applied configuration, persists it as the
+// last-known-good copy and adds it to the history. Persistence failures are
+// logged; the configuration is applied either way.
+func (s *PeriodicSyncer) remember(buf []byte, at time.Time) {
+	s.lastConfig = buf
+	s.lastDigest = sha256.Sum256(buf)
+	s.applied = true
+
+	mode := s.generator.FileMode
+	if mode == 0 {
+		mode = 0o644
+	}
+	if err := atomicWriteFile(s.lastGoodPath(), buf, mode); err != nil {
+		s.logger.Warn("failed to save last-known-good xray config", "err", err)
+	}
+	if s.historyDir == "" {
+		return
+	}
+	if err := s.recordGeneration(buf, at, mode); err != nil {
+		s.logger.Warn("failed to record xray config history", "err", err)
+	}
+}
+
+// recordGeneration writes buf to the history directory and prunes all but
+// the newest generations.
+func (s *PeriodicSyncer) recordGeneration(buf []byte, at time.Time, mode fs.FileMode) error {
+	if err := os.MkdirAll(s.historyDir, 0o755); err != nil {
+		return err
+	}
+	name := at.UTC().Format(generationTimeForm) + "-" + configHash(buf) + ".json"
+	if err := atomicWriteFile(filepath.Join(s.historyDir, name), buf, mode); err != nil {
+		return err
+	}
+
+	generations, err := s.Generations()
+	if err != nil {
+		return err
+	}
+	for idx := s.history; idx < len(generations); idx++ {
+		if err := os.Remove(generations[idx].Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restart runs the restart command followed by the health probe.
+func (s *PeriodicSyncer) restart(ctx context.Context) error {
+	if len(s.restartCommand) == 0 {
+		return nil
+	}
+	if err := s.runCommand(ctx, s.restartCommand, "restart xray"); err != nil {
+		return err
+	}
+	return s.probe(ctx)
+}
+
+// probe runs the health command until it succeeds or the health timeout
+// elapses, giving Xray time to come up after a restart.
+func (s *PeriodicSyncer) probe(ctx context.Context) error {
+	if len(s.healthCommand) == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(s.healthTimeout)
+	for {
+		err := s.runCommand(ctx, s.healthCommand, "xray health check")
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(min(healthRetryInterval, remaining)):
+		}
+	}
+}
+
+// rollback restores previous after buf failed with cause, restarting Xray
+// again when it was restarted with buf. Once rolled back, buf is not applied
+// again until the rendered configuration changes.
+func (s *PeriodicSyncer) rollback(ctx context.Context, result SyncResult, buf, previous []byte, cause error, restarted bool) SyncResult {
+	result.Error = cause
+	if previous == nil || bytes.Equal(previous, buf) {
+		return result
+	}
+
+	if err := s.generator.write(previous); err != nil {
+		result.Error = fmt.Errorf("%w; roll back: %v", cause, err)
+		return result
+	}
+	hash := configHash(previous)
+	if restarted {
+		if err := s.restart(ctx); err != nil {
+			result.Error = fmt.Errorf("%w; roll back to %s: %v", cause, hash[:12], err)
+			return result
+		}
+		s.lastConfig = previous
+		s.lastDigest = sha256.Sum256(previous)
+		s.applied = true
+	}
+
+	s.rejected = sha256.Sum256(buf)
+	s.hasRejected = true
+	s.logger.Warn("xray config rolled back", "cause", cause, "hash", hash)
+	result.RolledBack = true
+	result.ConfigHash = hash
+	result.Error = fmt.Errorf("%w; rolled back to %s", cause, hash[:12])
+	return result
+}
@@ -0,0 +1,153 @@
+package xrayconfig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodicSyncerRollsBackFailedRestart(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "config.json")
+	source := &mutableSource{clients: []Client{{ID: "uuid-a"}}}
+	var commands []string
+	syncer, err := NewPeriodicSyncer(PeriodicOptions{
+		Interval:       time.Minute,
+		Source:         source,
+		Generator:      Generator{OutputPath: output},
+		RestartCommand: []string{"restart"},
+		HealthCommand:  []string{"health"},
+		HealthTimeout:  time.Millisecond,
+		Runner: func(_ context.Context, cmd []string) ([]byte, error) {
+			commands = append(commands, cmd[0])
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("read config: %v", err)
+			}
+			if cmd[0] == "health" && strings.Contains(string(data), "uuid-broken") {
+				return []byte("connection refused"), errors.New("exit status 1")
+			}
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+	ctx := context.Background()
+
+	first := syncer.sync(ctx)
+	if first.Error != nil || first.ConfigHash == "" {
+		t.Fatalf("expected the first sync to succeed, got %+v", first)
+	}
+	good, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+
+	source.clients = []Client{{ID: "uuid-broken"}}
+	commands = nil
+	result := syncer.sync(ctx)
+	if result.Error == nil || !result.RolledBack || result.ConfigHash != first.ConfigHash {
+		t.Fatalf("expected the failed config to be rolled back, got %+v", result)
+	}
+	if joined := strings.Join(commands, ","); strings.Count(joined, "restart") != 2 || !strings.HasSuffix(joined, "restart,health") {
+		t.Fatalf("unexpected commands %v", commands)
+	}
+	if data, _ := os.ReadFile(output); string(data) != string(good) {
+		t.Fatalf("expected the last-known-good config to be restored, got %s", data)
+	}
+
+	// The rolled back config is not retried until it changes.
+	commands = nil
+	if result := syncer.sync(ctx); result.Error == nil || result.RolledBack || len(commands) != 0 {
+		t.Fatalf("expected the rejected config to be skipped, got %+v with commands %v", result, commands)
+	}
+	source.clients = []Client{{ID: "uuid-b"}}
+	if result := syncer.sync(ctx); result.Error != nil || result.RolledBack || result.ConfigHash == first.ConfigHash {
+		t.Fatalf("expected a new config to be applied, got %+v", result)
+	}
+
+	generations, err := syncer.Generations()
+	if err != nil {
+		t.Fatalf("generations: %v", err)
+	}
+	if len(generations) != 2 || generations[1].Hash != first.ConfigHash || generations[0].AppliedAt.Before(generations[1].AppliedAt) {
+		t.Fatalf("unexpected generations %+v", generations)
+	}
+}
+
+func TestPeriodicSyncerRestoresConfigFailingValidation(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(output+lastGoodSuffix, []byte("{\"previous\": true}\n"), 0o644); err != nil {
+		t.Fatalf("write last-known-good config: %v", err)
+	}
+	restarts := 0
+	syncer, err := NewPeriodicSyncer(PeriodicOptions{
+		Interval:        time.Minute,
+		Source:          staticSource{clients: []Client{{ID: "uuid-a"}}},
+		Generator:       Generator{OutputPath: output},
+		ValidateCommand: []string{"validate"},
+		RestartCommand:  []string{"restart"},
+		Runner: func(_ context.Context, cmd []string) ([]byte, error) {
+			if cmd[0] == "validate" {
+				return nil, errors.New("invalid config")
+			}
+			restarts++
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+
+	result := syncer.sync(context.Background())
+	if result.Error == nil || !result.RolledBack || restarts != 0 {
+		t.Fatalf("expected the invalid config to be rolled back without restart, got %+v with %d restarts", result, restarts)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "{\"previous\": true}\n" {
+		t.Fatalf("expected the last-known-good config to be restored, got %s", data)
+	}
+}
+
+func TestPeriodicSyncerPrunesHistory(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "config.json")
+	source := &mutableSource{}
+	syncer, err := NewPeriodicSyncer(PeriodicOptions{
+		Interval:  time.Minute,
+		Source:    source,
+		Generator: Generator{OutputPath: output},
+		History:   2,
+	})
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+
+	var hashes []string
+	for _, id := range []string{"uuid-a", "uuid-b", "uuid-c"} {
+		source.clients = []Client{{ID: id}}
+		result := syncer.sync(context.Background())
+		if result.Error != nil {
+			t.Fatalf("sync %s: %v", id, result.Error)
+		}
+		hashes = append(hashes, result.ConfigHash)
+	}
+
+	generations, err := syncer.Generations()
+	if err != nil {
+		t.Fatalf("generations: %v", err)
+	}
+	if len(generations) != 2 || generations[0].Hash != hashes[2] || generations[1].Hash != hashes[1] {
+		t.Fatalf("expected the two newest generations, got %+v", generations)
+	}
+	if filepath.Dir(generations[0].Path) != output+historySuffix {
+		t.Fatalf("unexpected history path %s", generations[0].Path)
+	}
+	lastGood, err := os.ReadFile(output + lastGoodSuffix)
+	if err != nil || configHash(lastGood) != hashes[2] {
+		t.Fatalf("expected the last-known-good copy to match the latest config, got %v", err)
+	}
+}
@@ -2,15 +2,26 @@ package xrayconfig
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
+
+	"account/internal/store"
+	"account/internal/subscription"
 )
 
 // GormClientSource reads Xray client credentials from the users table using GORM.
 type GormClientSource struct {
 	DB *gorm.DB
+
+	// TrafficQuotas maps plan IDs to their per-period traffic quota in bytes.
+	// Subscribers that used up the quota of every entitled subscription are
+	// left out of the client list.
+	TrafficQuotas map[string]int64
 }
 
 // NewGormClientSource constructs a ClientSource backed by the provided GORM instance.
@@ -21,23 +32,46 @@ func NewGormClientSource(db *gorm.DB) (*GormClientSource, error) {
 	return &GormClientSource{DB: db}, nil
 }
 
-// ListClients returns all users ordered by creation time.
+// ListClients returns the users holding an entitled subscription (trialing,
+// active or past due with an unexpired period) ordered by creation time.
+// Disabled users and users over their traffic quota are excluded.
 func (s *GormClientSource) ListClients(ctx context.Context) ([]Client, error) {
 	if s == nil || s.DB == nil {
 		return nil, errors.New("gorm client source is not configured")
 	}
 
+	db := s.DB.WithContext(ctx)
+	now := time.Now().UTC()
+	entitled := db.Table("subscriptions").
+		Select("1").
+		Where("subscriptions.user_uuid = users.uuid").
+		Where("subscriptions.status IN ?", store.EntitledSubscriptionStatuses()).
+		Where("subscriptions.current_period_end IS NULL OR subscriptions.current_period_end > ?", now)
+
 	type row struct {
-		UUID  string  `gorm:"column:uuid"`
-		Email *string `gorm:"column:email"`
+		UUID   string  `gorm:"column:uuid"`
+		Email  *string `gorm:"column:email"`
+		Groups *string `gorm:"column:groups"`
 	}
 
-	var rows []row
-	if err := s.DB.WithContext(ctx).
+	// Older schemas predate user groups.
+	columns := "uuid, email"
+	if db.Migrator().HasColumn("users", "groups") {
+		columns += ", groups"
+	}
+
+	query := db.
 		Table("users").
-		Select("uuid, email").
-		Order("created_at ASC, uuid ASC").
-		Find(&rows).Error; err != nil {
+		Select(columns).
+		Where("EXISTS (?)", entitled)
+	// Disabled accounts lose access without their subscription being
+	// touched; older schemas predate the flag.
+	if db.Migrator().HasColumn("users", "disabled") {
+		query = query.Where("users.disabled = ?", false)
+	}
+
+	var rows []row
+	if err := query.Order("created_at ASC, uuid ASC").Find(&rows).Error; err != nil {
 		return nil, err
 	}
 
@@ -51,7 +85,115 @@ func (s *GormClientSource) ListClients(ctx context.Context) ([]Client, error) {
 		if r.Email != nil {
 			client.Email = strings.TrimSpace(*r.Email)
 		}
+		if r.Groups != nil && strings.TrimSpace(*r.Groups) != "" {
+			if err := json.Unmarshal([]byte(*r.Groups), &client.Groups); err != nil {
+				return nil, fmt.Errorf("decode groups of user %s: %w", id, err)
+			}
+		}
 		clients = append(clients, client)
 	}
-	return clients, nil
+	if len(s.TrafficQuotas) == 0 || len(clients) == 0 {
+		return clients, nil
+	}
+
+	exceeded, err := s.quotaExceeded(ctx, clients, now)
+	if err != nil {
+		return nil, err
+	}
+	allowed := clients[:0]
+	for _, client := range clients {
+		if _, ok := exceeded[client.ID]; !ok {
+			allowed = append(allowed, client)
+		}
+	}
+	return allowed, nil
+}
+
+// quotaExceeded returns the IDs of clients whose entitled subscriptions all
+// carry a traffic quota that has been used up in the current period.
+func (s *GormClientSource) quotaExceeded(ctx context.Context, clients []Client, now time.Time) (map[string]struct{}, error) {
+	ids := make([]string, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.ID)
+	}
+
+	type subscriptionRow struct {
+		UserUUID           string     `gorm:"column:user_uuid"`
+		PlanID             *string    `gorm:"column:plan_id"`
+		Status             string     `gorm:"column:status"`
+		CurrentPeriodStart *time.Time `gorm:"column:current_period_start"`
+		CurrentPeriodEnd   *time.Time `gorm:"column:current_period_end"`
+	}
+	var subRows []subscriptionRow
+	if err := s.DB.WithContext(ctx).
+		Table("subscriptions").
+		Select("user_uuid, plan_id, status, current_period_start, current_period_end").
+		Where("user_uuid IN ?", ids).
+		Where("status IN ?", store.EntitledSubscriptionStatuses()).
+		Find(&subRows).Error; err != nil {
+		return nil, err
+	}
+
+	// Usage is only fetched from the earliest period start; BestAllowance
+	// then counts each subscription from its own start. A subscription
+	// without a start counts all usage, so nothing may be left out then.
+	subscriptions := make(map[string][]store.Subscription)
+	var (
+		since     *time.Time
+		unbounded bool
+	)
+	for _, row := range subRows {
+		sub := store.Subscription{
+			UserID:             row.UserUUID,
+			Status:             row.Status,
+			CurrentPeriodStart: row.CurrentPeriodStart,
+			CurrentPeriodEnd:   row.CurrentPeriodEnd,
+		}
+		if row.PlanID != nil {
+			sub.PlanID = strings.TrimSpace(*row.PlanID)
+		}
+		subscriptions[row.UserUUID] = append(subscriptions[row.UserUUID], sub)
+		switch {
+		case sub.CurrentPeriodStart == nil:
+			unbounded = true
+		case since == nil || sub.CurrentPeriodStart.Before(*since):
+			since = sub.CurrentPeriodStart
+		}
+	}
+
+	usageQuery := s.DB.WithContext(ctx).
+		Table("traffic_usage").
+		Select("user_uuid, day, uplink, downlink").
+		Where("user_uuid IN ?", ids)
+	if since != nil && !unbounded {
+		usageQuery = usageQuery.Where("day >= ?", store.UsageDay(*since))
+	}
+	type usageRow struct {
+		UserUUID string    `gorm:"column:user_uuid"`
+		Day      time.Time `gorm:"column:day"`
+		Uplink   int64     `gorm:"column:uplink"`
+		Downlink int64     `gorm:"column:downlink"`
+	}
+	var usageRows []usageRow
+	if err := usageQuery.Find(&usageRows).Error; err != nil {
+		return nil, err
+	}
+	usage := make(map[string][]store.TrafficUsage)
+	for _, row := range usageRows {
+		usage[row.UserUUID] = append(usage[row.UserUUID], store.TrafficUsage{
+			UserID:   row.UserUUID,
+			Day:      store.UsageDay(row.Day),
+			Uplink:   row.Uplink,
+			Downlink: row.Downlink,
+		})
+	}
+
+	exceeded := make(map[string]struct{})
+	for userID, subs := range subscriptions {
+		allowance, ok := subscription.BestAllowance(s.TrafficQuotas, subs, usage[userID], now)
+		if ok && allowance.Exceeded() {
+			exceeded[userID] = struct{}{}
+		}
+	}
+	return exceeded, nil
 }
@@ -17,6 +17,9 @@ func TestGormClientSourceListClients(t *testing.T) {
 	if err := db.Exec(`CREATE TABLE users (uuid TEXT PRIMARY KEY, email TEXT, created_at TIMESTAMP)`).Error; err != nil {
 		t.Fatalf("create table: %v", err)
 	}
+	if err := db.Exec(`CREATE TABLE subscriptions (user_uuid TEXT, status TEXT, current_period_end TIMESTAMP)`).Error; err != nil {
+		t.Fatalf("create subscriptions table: %v", err)
+	}
 
 	older := time.Now().Add(-time.Hour)
 	newer := time.Now()
@@ -27,6 +30,29 @@ func TestGormClientSourceListClients(t *testing.T) {
 		t.Fatalf("insert newer: %v", err)
 	}
 
+	if err := db.Exec(`INSERT INTO users (uuid, email, created_at) VALUES (?, ?, ?)`, "uuid-lapsed", "lapsed@example.com", older).Error; err != nil {
+		t.Fatalf("insert lapsed: %v", err)
+	}
+	if err := db.Exec(`INSERT INTO users (uuid, email, created_at) VALUES (?, ?, ?)`, "uuid-none", "none@example.com", older).Error; err != nil {
+		t.Fatalf("insert unsubscribed: %v", err)
+	}
+
+	subscriptions := []struct {
+		user, status string
+		periodEnd    any
+	}{
+		{"uuid-b", "active", nil},
+		{"uuid-a", "trialing", time.Now().UTC().Add(time.Hour)},
+		{"uuid-a", "cancelled", nil},
+		{"uuid-lapsed", "active", time.Now().UTC().Add(-time.Hour)},
+		{"uuid-lapsed", "expired", nil},
+	}
+	for _, sub := range subscriptions {
+		if err := db.Exec(`INSERT INTO subscriptions (user_uuid, status, current_period_end) VALUES (?, ?, ?)`, sub.user, sub.status, sub.periodEnd).Error; err != nil {
+			t.Fatalf("insert subscription: %v", err)
+		}
+	}
+
 	source, err := NewGormClientSource(db)
 	if err != nil {
 		t.Fatalf("new source: %v", err)
@@ -51,3 +77,144 @@ func TestNewGormClientSourceNilDB(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestGormClientSourceDropsClientsOverQuota(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:quota?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	statements := []string{
+		`CREATE TABLE users (uuid TEXT PRIMARY KEY, email TEXT, created_at TIMESTAMP)`,
+		`CREATE TABLE subscriptions (user_uuid TEXT, plan_id TEXT, status TEXT, current_period_start TIMESTAMP, current_period_end TIMESTAMP)`,
+		`CREATE TABLE traffic_usage (user_uuid TEXT, day TIMESTAMP, uplink INTEGER, downlink INTEGER)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	periodStart := now.Add(-48 * time.Hour)
+	periodEnd := now.Add(24 * time.Hour)
+	for i, id := range []string{"uuid-under", "uuid-over", "uuid-renewed", "uuid-unlimited", "uuid-unstarted"} {
+		if err := db.Exec(`INSERT INTO users (uuid, email, created_at) VALUES (?, ?, ?)`, id, id+"@example.com", now.Add(time.Duration(i)*time.Second)).Error; err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+	}
+	subscriptions := []struct {
+		user, plan string
+		start      any
+	}{
+		{"uuid-under", "BASIC", periodStart},
+		{"uuid-over", "BASIC", periodStart},
+		// Usage from before the current period does not count.
+		{"uuid-renewed", "BASIC", now},
+		{"uuid-unlimited", "BASIC", periodStart},
+		{"uuid-unlimited", "PRO", periodStart},
+		// Without a period start all usage counts.
+		{"uuid-unstarted", "BASIC", nil},
+	}
+	for _, sub := range subscriptions {
+		if err := db.Exec(`INSERT INTO subscriptions (user_uuid, plan_id, status, current_period_start, current_period_end) VALUES (?, ?, 'active', ?, ?)`,
+			sub.user, sub.plan, sub.start, periodEnd).Error; err != nil {
+			t.Fatalf("insert subscription: %v", err)
+		}
+	}
+	usage := []struct {
+		user string
+		day  time.Time
+		up   int64
+		down int64
+	}{
+		{"uuid-under", now, 100, 200},
+		{"uuid-over", now.Add(-24 * time.Hour), 600, 0},
+		{"uuid-over", now, 100, 400},
+		{"uuid-renewed", now.Add(-24 * time.Hour), 5000, 5000},
+		{"uuid-unlimited", now, 5000, 5000},
+		{"uuid-unstarted", now.Add(-72 * time.Hour), 1500, 0},
+	}
+	for _, entry := range usage {
+		if err := db.Exec(`INSERT INTO traffic_usage (user_uuid, day, uplink, downlink) VALUES (?, ?, ?, ?)`,
+			entry.user, time.Date(entry.day.Year(), entry.day.Month(), entry.day.Day(), 0, 0, 0, 0, time.UTC), entry.up, entry.down).Error; err != nil {
+			t.Fatalf("insert usage: %v", err)
+		}
+	}
+
+	source, err := NewGormClientSource(db)
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	source.TrafficQuotas = map[string]int64{"BASIC": 1000}
+	clients, err := source.ListClients(context.Background())
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	var ids []string
+	for _, client := range clients {
+		ids = append(ids, client.ID)
+	}
+	if len(ids) != 3 || ids[0] != "uuid-under" || ids[1] != "uuid-renewed" || ids[2] != "uuid-unlimited" {
+		t.Fatalf("expected the over quota client to be dropped, got %v", ids)
+	}
+}
+
+func TestGormClientSourceLoadsUserGroups(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:groups?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	statements := []string{
+		`CREATE TABLE users (uuid TEXT PRIMARY KEY, email TEXT, groups TEXT, created_at TIMESTAMP)`,
+		`CREATE TABLE subscriptions (user_uuid TEXT, status TEXT, current_period_end TIMESTAMP)`,
+		`INSERT INTO users (uuid, email, groups, created_at) VALUES ('uuid-a', NULL, '["premium","hk"]', '2024-01-01'), ('uuid-b', NULL, NULL, '2024-01-02')`,
+		`INSERT INTO subscriptions (user_uuid, status) VALUES ('uuid-a', 'active'), ('uuid-b', 'active')`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("prepare db: %v", err)
+		}
+	}
+
+	source, err := NewGormClientSource(db)
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	clients, err := source.ListClients(context.Background())
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 2 || len(clients[0].Groups) != 2 || clients[0].Groups[0] != "premium" || clients[1].Groups != nil {
+		t.Fatalf("unexpected client groups %+v", clients)
+	}
+}
+
+func TestGormClientSourceSkipsDisabledUsers(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:disabled?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	statements := []string{
+		`CREATE TABLE users (uuid TEXT PRIMARY KEY, email TEXT, disabled BOOLEAN NOT NULL DEFAULT FALSE, created_at TIMESTAMP)`,
+		`CREATE TABLE subscriptions (user_uuid TEXT, status TEXT, current_period_end TIMESTAMP)`,
+		`INSERT INTO users (uuid, email, disabled, created_at) VALUES ('uuid-a', NULL, FALSE, '2024-01-01'), ('uuid-b', NULL, TRUE, '2024-01-02')`,
+		`INSERT INTO subscriptions (user_uuid, status) VALUES ('uuid-a', 'active'), ('uuid-b', 'active')`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("prepare db: %v", err)
+		}
+	}
+
+	source, err := NewGormClientSource(db)
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	clients, err := source.ListClients(context.Background())
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].ID != "uuid-a" {
+		t.Fatalf("expected disabled user to be skipped, got %+v", clients)
+	}
+}
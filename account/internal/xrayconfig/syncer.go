@@ -1,13 +1,20 @@
 package xrayconfig
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
+
+	"account/internal/utils"
 )
 
 // ClientSource provides the list of active Xray clients to encode in the config.
@@ -15,6 +22,24 @@ type ClientSource interface {
 	ListClients(ctx context.Context) ([]Client, error)
 }
 
+// ChangeWatcher is implemented by client sources that can block until the
+// client list changes. The syncer then resynchronises as soon as a change is
+// reported instead of waiting for the next interval.
+type ChangeWatcher interface {
+	// WaitForChange blocks until the clients differ from those last returned
+	// by ListClients, reporting false when it gave up waiting without a
+	// change. It returns ErrWatchUnsupported when the source cannot watch,
+	// after which the syncer stops calling it.
+	WaitForChange(ctx context.Context) (bool, error)
+}
+
+// ErrWatchUnsupported is returned by ChangeWatcher.WaitForChange when changes
+// cannot be watched.
+var ErrWatchUnsupported = errors.New("client source cannot watch for changes")
+
+// watchRetryDelay is the pause after a failed WaitForChange call.
+const watchRetryDelay = 5 * time.Second
+
 type commandRunner func(ctx context.Context, cmd []string) ([]byte, error)
 
 // PeriodicOptions configures a PeriodicSyncer instance.
@@ -27,6 +52,19 @@ type PeriodicOptions struct {
 	RestartCommand  []string
 	Runner          commandRunner
 	OnSync          func(SyncResult)
+	// Users, when set, applies changes limited to the clients of tagged
+	// inbounds through the Xray API instead of RestartCommand.
+	Users UserManager
+	// HealthCommand probes Xray after a restart. It is retried until it
+	// succeeds or HealthTimeout (default DefaultHealthTimeout) elapses; a
+	// failure rolls back to the last-known-good configuration.
+	HealthCommand []string
+	HealthTimeout time.Duration
+	// History is the number of applied configurations kept in HistoryDir,
+	// which defaults to OutputPath with a ".history" suffix. Zero keeps
+	// DefaultHistory configurations and a negative value disables history.
+	History    int
+	HistoryDir string
 }
 
 // PeriodicSyncer periodically rebuilds the Xray configuration from the database.
@@ -39,11 +77,39 @@ type PeriodicSyncer struct {
 	restartCommand  []string
 	runner          commandRunner
 	onSync          func(SyncResult)
+	users           UserManager
+	healthCommand   []string
+	healthTimeout   time.Duration
+	history         int
+	historyDir      string
+
+	// lastConfig and lastDigest hold the last configuration that was
+	// written, validated and applied.
+	lastConfig []byte
+	lastDigest [32]byte
+	applied    bool
+	// rejected is the digest of the configuration rolled back last, which
+	// is not applied again until the rendered configuration changes.
+	rejected    [32]byte
+	hasRejected bool
 }
 
 // SyncResult describes the outcome of a synchronization attempt.
 type SyncResult struct {
-	Clients     int
+	Clients int
+	// Unchanged reports that the rendered configuration matched the one
+	// already applied, so it was neither written nor was Xray restarted.
+	Unchanged bool
+	// HotReloaded reports that only clients changed and they were applied
+	// through the Xray API without a restart.
+	HotReloaded bool
+	// RolledBack reports that the new configuration failed validation, the
+	// restart or the health probe and the last-known-good configuration was
+	// restored. Error describes the failure.
+	RolledBack bool
+	// ConfigHash is the SHA-256 digest of the configuration Xray runs with,
+	// empty when it is unknown.
+	ConfigHash  string
 	Error       error
 	CompletedAt time.Time
 }
@@ -67,6 +133,21 @@ func NewPeriodicSyncer(opts PeriodicOptions) (*PeriodicSyncer, error) {
 	if runner == nil {
 		runner = defaultCommandRunner
 	}
+	healthTimeout := opts.HealthTimeout
+	if healthTimeout <= 0 {
+		healthTimeout = DefaultHealthTimeout
+	}
+	history := opts.History
+	if history == 0 {
+		history = DefaultHistory
+	}
+	historyDir := strings.TrimSpace(opts.HistoryDir)
+	switch {
+	case history < 0:
+		historyDir = ""
+	case historyDir == "":
+		historyDir = opts.Generator.OutputPath + historySuffix
+	}
 	return &PeriodicSyncer{
 		logger:          logger,
 		interval:        opts.Interval,
@@ -76,6 +157,11 @@ func NewPeriodicSyncer(opts PeriodicOptions) (*PeriodicSyncer, error) {
 		restartCommand:  append([]string(nil), opts.RestartCommand...),
 		runner:          runner,
 		onSync:          opts.OnSync,
+		users:           opts.Users,
+		healthCommand:   append([]string(nil), opts.HealthCommand...),
+		healthTimeout:   healthTimeout,
+		history:         history,
+		historyDir:      historyDir,
 	}, nil
 }
 
@@ -85,83 +171,178 @@ func (s *PeriodicSyncer) Start(ctx context.Context) (func(context.Context) error
 	if s == nil {
 		return nil, errors.New("syncer is nil")
 	}
-	runCtx, cancel := context.WithCancel(ctx)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		s.run(runCtx)
-	}()
-	stop := func(waitCtx context.Context) error {
-		cancel()
-		if waitCtx == nil {
-			waitCtx = context.Background()
-		}
-		select {
-		case <-done:
-			return nil
-		case <-waitCtx.Done():
-			return waitCtx.Err()
-		}
-	}
-	return stop, nil
+	return utils.StartLoop(ctx, s.run), nil
 }
 
 func (s *PeriodicSyncer) run(ctx context.Context) {
-	if n, err := s.sync(ctx); err != nil {
-		s.notify(SyncResult{Clients: n, Error: err, CompletedAt: time.Now().UTC()})
-		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-			s.logger.Error("xray config sync failed", "err", err)
-		}
-		if ctx.Err() != nil {
-			return
-		}
-	} else {
-		s.logger.Info("xray config synchronized", "clients", n)
-		s.notify(SyncResult{Clients: n, CompletedAt: time.Now().UTC()})
+	if !s.syncAndNotify(ctx) {
+		return
 	}
 
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	changes := s.watch(ctx, &wg)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			n, err := s.sync(ctx)
+		case <-changes:
+		}
+		if !s.syncAndNotify(ctx) {
+			return
+		}
+	}
+}
+
+// syncAndNotify runs one synchronization and reports its result. It returns
+// false once the context is done.
+func (s *PeriodicSyncer) syncAndNotify(ctx context.Context) bool {
+	result := s.sync(ctx)
+	result.CompletedAt = time.Now().UTC()
+	s.notify(result)
+	switch {
+	case result.Error != nil:
+		if ctx.Err() != nil {
+			return false
+		}
+		s.logger.Error("xray config sync failed", "err", result.Error, "rolledBack", result.RolledBack)
+	case result.Unchanged:
+		s.logger.Debug("xray config unchanged", "clients", result.Clients)
+	case result.HotReloaded:
+		s.logger.Info("xray clients updated without restart", "clients", result.Clients)
+	default:
+		s.logger.Info("xray config synchronized", "clients", result.Clients)
+	}
+	return true
+}
+
+// watch forwards the changes reported by a ChangeWatcher source. It returns
+// nil, which never fires, for other sources.
+func (s *PeriodicSyncer) watch(ctx context.Context, wg *sync.WaitGroup) <-chan struct{} {
+	watcher, ok := s.source.(ChangeWatcher)
+	if !ok {
+		return nil
+	}
+	changes := make(chan struct{}, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			changed, err := watcher.WaitForChange(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, ErrWatchUnsupported) {
+				s.logger.Info("client source cannot watch for changes, syncing on the interval only")
+				return
+			}
 			if err != nil {
-				s.notify(SyncResult{Clients: n, Error: err, CompletedAt: time.Now().UTC()})
-				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				s.logger.Warn("waiting for client changes failed", "err", err)
+				select {
+				case <-ctx.Done():
 					return
+				case <-time.After(watchRetryDelay):
 				}
-				s.logger.Error("xray config sync failed", "err", err)
 				continue
 			}
-			s.logger.Info("xray config synchronized", "clients", n)
-			s.notify(SyncResult{Clients: n, CompletedAt: time.Now().UTC()})
+			if changed {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
 		}
-	}
+	}()
+	return changes
 }
 
-func (s *PeriodicSyncer) sync(ctx context.Context) (int, error) {
+// sync renders the configuration and applies it when it differs from the
+// configuration applied last. When only clients changed and a UserManager is
+// configured they are applied through the Xray API; Xray is restarted when
+// that fails or anything else changed. A configuration failing validation,
+// the restart or the health probe is replaced by the last-known-good one.
+func (s *PeriodicSyncer) sync(ctx context.Context) SyncResult {
 	clients, err := s.source.ListClients(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("list clients: %w", err)
+		return SyncResult{Error: fmt.Errorf("list clients: %w", err)}
+	}
+	buf, err := s.generator.Render(clients)
+	if err != nil {
+		return SyncResult{Error: fmt.Errorf("generate config: %w", err)}
+	}
+	result := SyncResult{Clients: len(clients)}
+	if s.applied {
+		result.ConfigHash = hex.EncodeToString(s.lastDigest[:])
 	}
-	if err := s.generator.Generate(clients); err != nil {
-		return 0, fmt.Errorf("generate config: %w", err)
+	digest := sha256.Sum256(buf)
+	if s.applied && digest == s.lastDigest {
+		result.Unchanged = true
+		if err := s.restoreOutput(buf); err != nil {
+			result.Error = fmt.Errorf("restore config: %w", err)
+		}
+		return result
+	}
+	if s.hasRejected && digest == s.rejected {
+		result.Error = fmt.Errorf("xray config %s was rolled back, waiting for a change", configHash(buf)[:12])
+		return result
+	}
+
+	previous := s.lastKnownGood()
+	if err := s.generator.write(buf); err != nil {
+		return SyncResult{Error: fmt.Errorf("generate config: %w", err)}
 	}
 	if len(s.validateCommand) > 0 {
 		if err := s.runCommand(ctx, s.validateCommand, "validate config"); err != nil {
-			return 0, err
+			return s.rollback(ctx, result, buf, previous, err, false)
 		}
 	}
-	if len(s.restartCommand) > 0 {
-		if err := s.runCommand(ctx, s.restartCommand, "restart xray"); err != nil {
-			return 0, err
+	result.HotReloaded = s.hotReload(ctx, buf)
+	if !result.HotReloaded {
+		if err := s.restart(ctx); err != nil {
+			return s.rollback(ctx, result, buf, previous, err, true)
 		}
 	}
-	return len(clients), nil
+	s.remember(buf, time.Now())
+	s.hasRejected = false
+	result.ConfigHash = hex.EncodeToString(digest[:])
+	return result
+}
+
+// restoreOutput rewrites the applied configuration buf when the output file
+// was removed or modified behind the syncer's back. Xray already runs buf, so
+// it is neither validated nor restarted.
+func (s *PeriodicSyncer) restoreOutput(buf []byte) error {
+	current, err := os.ReadFile(s.generator.OutputPath)
+	if err == nil && bytes.Equal(current, buf) {
+		return nil
+	}
+	if err := s.generator.write(buf); err != nil {
+		return err
+	}
+	s.logger.Warn("xray config file was missing or modified, restored", "path", s.generator.OutputPath)
+	return nil
+}
+
+// hotReload applies buf through the user manager when it only differs from
+// the applied configuration in clients. It reports whether it succeeded.
+func (s *PeriodicSyncer) hotReload(ctx context.Context, buf []byte) bool {
+	if s.users == nil || !s.applied {
+		return false
+	}
+	changes, ok := diffUsers(s.lastConfig, buf)
+	if !ok {
+		return false
+	}
+	if err := applyUsers(ctx, s.users, changes); err != nil {
+		s.logger.Warn("xray hot reload failed, restarting instead", "err", err)
+		return false
+	}
+	return true
 }
 
 func (s *PeriodicSyncer) notify(result SyncResult) {
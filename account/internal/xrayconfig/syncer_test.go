@@ -87,12 +87,12 @@ func TestPeriodicSyncerSyncSuccess(t *testing.T) {
 		t.Fatalf("new syncer: %v", err)
 	}
 
-	n, err := syncer.sync(context.Background())
-	if err != nil {
-		t.Fatalf("sync: %v", err)
+	result := syncer.sync(context.Background())
+	if result.Error != nil {
+		t.Fatalf("sync: %v", result.Error)
 	}
-	if n != 2 {
-		t.Fatalf("expected 2 clients, got %d", n)
+	if result.Clients != 2 || result.Unchanged {
+		t.Fatalf("expected 2 clients to be applied, got %+v", result)
 	}
 	data, err := os.ReadFile(output)
 	if err != nil {
@@ -107,6 +107,27 @@ func TestPeriodicSyncerSyncSuccess(t *testing.T) {
 	if commands[0][0] != "echo" || commands[1][0] != "echo" {
 		t.Fatalf("unexpected commands: %+v", commands)
 	}
+
+	// An identical render is not applied again, but a missing or modified
+	// output file is restored.
+	if err := os.Remove(output); err != nil {
+		t.Fatalf("remove output: %v", err)
+	}
+	if result := syncer.sync(context.Background()); result.Error != nil || !result.Unchanged || result.Clients != 2 {
+		t.Fatalf("expected unchanged sync, got %+v", result)
+	}
+	if restored, err := os.ReadFile(output); err != nil || string(restored) != string(data) || len(commands) != 2 {
+		t.Fatalf("expected the output to be restored without restart, got %v and %d commands", err, len(commands))
+	}
+	if err := os.WriteFile(output, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("write output: %v", err)
+	}
+	if result := syncer.sync(context.Background()); result.Error != nil || !result.Unchanged {
+		t.Fatalf("expected unchanged sync, got %+v", result)
+	}
+	if restored, _ := os.ReadFile(output); string(restored) != string(data) || len(commands) != 2 {
+		t.Fatalf("expected the modified output to be restored without restart, got %d commands", len(commands))
+	}
 }
 
 func TestPeriodicSyncerSyncError(t *testing.T) {
@@ -120,7 +141,7 @@ func TestPeriodicSyncerSyncError(t *testing.T) {
 	if err != nil {
 		t.Fatalf("new syncer: %v", err)
 	}
-	if _, err := syncer.sync(context.Background()); err == nil {
+	if result := syncer.sync(context.Background()); result.Error == nil {
 		t.Fatalf("expected sync error")
 	}
 }
@@ -198,6 +219,54 @@ func TestPeriodicSyncerOnSyncCallback(t *testing.T) {
 	}
 }
 
+// watchingSource reports a change whenever a value is sent on changes.
+type watchingSource struct {
+	staticSource
+	changes chan struct{}
+}
+
+func (s watchingSource) WaitForChange(ctx context.Context) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-s.changes:
+		return true, nil
+	}
+}
+
+func TestPeriodicSyncerResyncsOnChange(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "config.json")
+	results := make(chan SyncResult, 4)
+	source := watchingSource{staticSource: staticSource{clients: []Client{{ID: "uuid-a"}}}, changes: make(chan struct{})}
+	syncer, err := NewPeriodicSyncer(PeriodicOptions{
+		Interval:  time.Hour,
+		Source:    source,
+		Generator: Generator{Definition: JSONDefinition{Raw: []byte(minimalTemplate)}, OutputPath: output},
+		OnSync:    func(res SyncResult) { results <- res },
+	})
+	if err != nil {
+		t.Fatalf("new syncer: %v", err)
+	}
+	stop, err := syncer.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer stop(context.Background())
+
+	if res := <-results; res.Error != nil || res.Unchanged {
+		t.Fatalf("expected initial sync to apply the config, got %+v", res)
+	}
+	source.changes <- struct{}{}
+	select {
+	case res := <-results:
+		if res.Error != nil || !res.Unchanged {
+			t.Fatalf("expected resync with an unchanged config, got %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a change to trigger a resync before the interval")
+	}
+}
+
 type clientSourceFunc func(ctx context.Context) ([]Client, error)
 
 func (f clientSourceFunc) ListClients(ctx context.Context) ([]Client, error) {
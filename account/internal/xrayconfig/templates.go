@@ -5,6 +5,9 @@ import _ "embed"
 var (
 	//go:embed template_server.json
 	serverTemplateJSON []byte
+
+	//go:embed template_client.json
+	clientTemplateJSON []byte
 )
 
 // DefaultDefinition returns the built-in Xray configuration definition used when
@@ -14,3 +17,10 @@ var (
 func DefaultDefinition() Definition {
 	return JSONDefinition{Raw: append([]byte(nil), serverTemplateJSON...)}
 }
+
+// DefaultClientDefinition returns the built-in desktop client configuration.
+// Its first outbound points at the managed VLESS endpoint and receives the
+// user credentials through Generator.RenderClient.
+func DefaultClientDefinition() Definition {
+	return JSONDefinition{Raw: append([]byte(nil), clientTemplateJSON...)}
+}
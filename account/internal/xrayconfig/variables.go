@@ -0,0 +1,100 @@
+package xrayconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in template variables describing the node a template is rendered for.
+const (
+	VariableNodeID   = "NODE_ID"
+	VariableNodeName = "NODE_NAME"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// TemplateVariables returns the sorted, de-duplicated names of the ${NAME}
+// placeholders used in raw.
+func TemplateVariables(raw []byte) []string {
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, match := range placeholderPattern.FindAllSubmatch(raw, -1) {
+		name := string(match[1])
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Substitute replaces every ${NAME} placeholder in raw with the value of the
+// variable. Values are JSON string escaped so they can be placed inside
+// string literals ("serverNames": ["${SNI}"]) as well as used bare for
+// numbers ("port": ${PORT}). Placeholders without a value are an error, so a
+// missing per-node setting never reaches Xray as a literal "${...}".
+func Substitute(raw []byte, variables map[string]string) ([]byte, error) {
+	var missing []string
+	for _, name := range TemplateVariables(raw) {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template variables not set: %s", strings.Join(missing, ", "))
+	}
+
+	return placeholderPattern.ReplaceAllFunc(raw, func(match []byte) []byte {
+		name := string(placeholderPattern.FindSubmatch(match)[1])
+		return []byte(escapeJSONString(variables[name]))
+	}), nil
+}
+
+func escapeJSONString(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded[1 : len(encoded)-1])
+}
+
+// ValidateTemplate checks that raw decodes to a JSON object once its
+// placeholders are substituted. Placeholders are replaced with "0", which is
+// valid both inside string literals and as a bare number.
+func ValidateTemplate(raw []byte) error {
+	variables := make(map[string]string)
+	for _, name := range TemplateVariables(raw) {
+		variables[name] = "0"
+	}
+	root, err := TemplateDefinition{Raw: raw, Variables: variables}.Base()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("template must be a JSON object")
+	}
+	return nil
+}
+
+// TemplateDefinition implements Definition for a JSON template containing
+// ${NAME} placeholders that are substituted with Variables on every render.
+type TemplateDefinition struct {
+	Raw       []byte
+	Variables map[string]string
+}
+
+// Base substitutes the template variables and decodes the result.
+func (d TemplateDefinition) Base() (map[string]interface{}, error) {
+	payload, err := Substitute(d.Raw, d.Variables)
+	if err != nil {
+		return nil, err
+	}
+	root, err := JSONDefinition{Raw: payload}.Base()
+	if err != nil {
+		return nil, fmt.Errorf("decode template: %w", err)
+	}
+	return root, nil
+}
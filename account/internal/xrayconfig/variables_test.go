@@ -0,0 +1,60 @@
+package xrayconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+const nodeTemplate = `{
+  "log": {"access": "/var/log/xray/${NODE_NAME}.log"},
+  "inbounds": [
+    {"tag": "vless-reality", "port": ${PORT}, "protocol": "vless", "settings": {"clients": [], "decryption": "none"},
+     "streamSettings": {"network": "tcp", "security": "reality",
+       "realitySettings": {"serverNames": ["${SNI}"], "privateKey": "${REALITY_PRIVATE_KEY}"}}}
+  ]
+}`
+
+func TestTemplateDefinitionSubstitutesVariables(t *testing.T) {
+	definition := TemplateDefinition{Raw: []byte(nodeTemplate), Variables: map[string]string{
+		"NODE_NAME":           `edge "hk"`,
+		"PORT":                "8443",
+		"SNI":                 "www.example.com",
+		"REALITY_PRIVATE_KEY": "private-key",
+	}}
+	root, err := definition.Base()
+	if err != nil {
+		t.Fatalf("base: %v", err)
+	}
+
+	if access := root["log"].(map[string]interface{})["access"]; access != `/var/log/xray/edge "hk".log` {
+		t.Fatalf("expected escaped node name, got %v", access)
+	}
+	inbound := root["inbounds"].([]interface{})[0].(map[string]interface{})
+	if port, ok := inbound["port"].(float64); !ok || port != 8443 {
+		t.Fatalf("expected numeric port, got %#v", inbound["port"])
+	}
+	reality := inbound["streamSettings"].(map[string]interface{})["realitySettings"].(map[string]interface{})
+	if reality["privateKey"] != "private-key" || reality["serverNames"].([]interface{})[0] != "www.example.com" {
+		t.Fatalf("unexpected reality settings %v", reality)
+	}
+
+	delete(definition.Variables, "SNI")
+	delete(definition.Variables, "PORT")
+	if _, err := definition.Base(); err == nil || !strings.Contains(err.Error(), "PORT, SNI") {
+		t.Fatalf("expected missing variables to be reported, got %v", err)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	if err := ValidateTemplate([]byte(nodeTemplate)); err != nil {
+		t.Fatalf("expected template with placeholders to validate: %v", err)
+	}
+	for _, raw := range []string{`{"port": }`, `null`, `[]`} {
+		if err := ValidateTemplate([]byte(raw)); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+	if names := TemplateVariables([]byte(nodeTemplate)); strings.Join(names, ",") != "NODE_NAME,PORT,REALITY_PRIVATE_KEY,SNI" {
+		t.Fatalf("unexpected variables %v", names)
+	}
+}
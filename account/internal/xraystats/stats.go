@@ -0,0 +1,190 @@
+// Package xraystats reads per-user traffic counters from the Xray stats
+// service.
+package xraystats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultServer is the address of the API listener in the bundled server
+// template.
+const DefaultServer = "127.0.0.1:10085"
+
+// UserTraffic holds the bytes a single Xray user transferred. Users are
+// identified by the email label of their client entry.
+type UserTraffic struct {
+	Email    string
+	Uplink   int64
+	Downlink int64
+}
+
+// Querier reads the per-user traffic counters of a running Xray instance.
+// When reset is true the counters are zeroed after being read so each call
+// returns the traffic since the previous one.
+type Querier interface {
+	QueryUserTraffic(ctx context.Context, reset bool) ([]UserTraffic, error)
+}
+
+// ConnectionQuerier reads the number of live connections of a user. Xray
+// only tracks them when statsUserOnline is enabled in the policy.
+type ConnectionQuerier interface {
+	QueryUserConnections(ctx context.Context, email string) (int64, error)
+}
+
+type commandRunner func(ctx context.Context, cmd []string) ([]byte, error)
+
+// CommandQuerier queries the stats service through the xray CLI
+// ("xray api statsquery"), which speaks gRPC to the API listener.
+type CommandQuerier struct {
+	// Command is the statsquery invocation without flags. Defaults to
+	// ["xray", "api", "statsquery"].
+	Command []string
+	// Server is the API listener address. Defaults to DefaultServer.
+	Server string
+
+	runner commandRunner
+}
+
+// QueryUserTraffic implements Querier.
+func (q *CommandQuerier) QueryUserTraffic(ctx context.Context, reset bool) ([]UserTraffic, error) {
+	cmd := append([]string(nil), q.Command...)
+	if len(cmd) == 0 {
+		cmd = []string{"xray", "api", "statsquery"}
+	}
+	cmd = append(cmd, "--server="+q.server(), "-pattern", "user>>>")
+	if reset {
+		cmd = append(cmd, "-reset")
+	}
+
+	output, err := q.run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("query xray stats: %w", err)
+	}
+	return ParseUserStats(output)
+}
+
+// QueryUserConnections implements ConnectionQuerier through
+// "xray api statsonline". The statsquery invocation in Command is reused with
+// its last element replaced. Users without live connections report zero.
+func (q *CommandQuerier) QueryUserConnections(ctx context.Context, email string) (int64, error) {
+	cmd := append([]string(nil), q.Command...)
+	if len(cmd) == 0 {
+		cmd = []string{"xray", "api", "statsquery"}
+	}
+	cmd[len(cmd)-1] = "statsonline"
+	cmd = append(cmd, "--server="+q.server(), "-email", email)
+
+	output, err := q.run(ctx, cmd)
+	if err != nil {
+		// Xray reports users without an online map as not found.
+		if strings.Contains(err.Error(), "not found") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("query xray online stats: %w", err)
+	}
+	var payload struct {
+		Stat struct {
+			Value statValue `json:"value"`
+		} `json:"stat"`
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return 0, nil
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return 0, fmt.Errorf("decode xray online stats: %w", err)
+	}
+	return int64(payload.Stat.Value), nil
+}
+
+func (q *CommandQuerier) server() string {
+	if server := strings.TrimSpace(q.Server); server != "" {
+		return server
+	}
+	return DefaultServer
+}
+
+func (q *CommandQuerier) run(ctx context.Context, cmd []string) ([]byte, error) {
+	if q.runner != nil {
+		return q.runner(ctx, cmd)
+	}
+	return defaultCommandRunner(ctx, cmd)
+}
+
+func defaultCommandRunner(ctx context.Context, cmd []string) ([]byte, error) {
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Stderr = &stderr
+	output, err := c.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
+
+// statValue accepts the int64 counters both as JSON numbers and as the
+// strings protobuf JSON encoding produces.
+type statValue int64
+
+func (v *statValue) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*v = statValue(parsed)
+	return nil
+}
+
+// ParseUserStats decodes a QueryStats response and aggregates the
+// "user>>>{email}>>>traffic>>>{uplink|downlink}" counters by user. Other
+// counters are ignored. The result is sorted by email.
+func ParseUserStats(data []byte) ([]UserTraffic, error) {
+	var payload struct {
+		Stat []struct {
+			Name  string    `json:"name"`
+			Value statValue `json:"value"`
+		} `json:"stat"`
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("decode xray stats: %w", err)
+	}
+
+	byEmail := make(map[string]*UserTraffic)
+	for _, stat := range payload.Stat {
+		parts := strings.Split(stat.Name, ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" || parts[1] == "" {
+			continue
+		}
+		if stat.Value < 0 {
+			return nil, errors.New("decode xray stats: negative counter")
+		}
+		traffic, ok := byEmail[parts[1]]
+		if !ok {
+			traffic = &UserTraffic{Email: parts[1]}
+			byEmail[parts[1]] = traffic
+		}
+		switch parts[3] {
+		case "uplink":
+			traffic.Uplink += int64(stat.Value)
+		case "downlink":
+			traffic.Downlink += int64(stat.Value)
+		}
+	}
+
+	users := make([]UserTraffic, 0, len(byEmail))
+	for _, traffic := range byEmail {
+		users = append(users, *traffic)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users, nil
+}
@@ -0,0 +1,63 @@
+package xraystats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// statsQueryOutput is the output of "xray api statsquery" for two users. The
+// stats service omits zero counters and encodes values as strings.
+const statsQueryOutput = `{
+    "stat": [
+        {"name": "inbound>>>api>>>traffic>>>downlink", "value": "512"},
+        {"name": "user>>>b@example.com>>>traffic>>>uplink", "value": "1024"},
+        {"name": "user>>>b@example.com>>>traffic>>>downlink", "value": "4096"},
+        {"name": "user>>>uuid-a>>>traffic>>>downlink", "value": 300},
+        {"name": "user>>>idle@example.com>>>traffic>>>uplink"}
+    ]
+}`
+
+func TestCommandQuerierParsesStatsQuery(t *testing.T) {
+	var invoked []string
+	querier := &CommandQuerier{
+		Command: []string{"/usr/local/bin/xray", "api", "statsquery"},
+		runner: func(ctx context.Context, cmd []string) ([]byte, error) {
+			invoked = cmd
+			return []byte(statsQueryOutput), nil
+		},
+	}
+
+	users, err := querier.QueryUserTraffic(context.Background(), true)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	expectedCmd := []string{"/usr/local/bin/xray", "api", "statsquery", "--server=" + DefaultServer, "-pattern", "user>>>", "-reset"}
+	if !reflect.DeepEqual(invoked, expectedCmd) {
+		t.Fatalf("unexpected command %v", invoked)
+	}
+	expected := []UserTraffic{
+		{Email: "b@example.com", Uplink: 1024, Downlink: 4096},
+		{Email: "idle@example.com"},
+		{Email: "uuid-a", Downlink: 300},
+	}
+	if !reflect.DeepEqual(users, expected) {
+		t.Fatalf("unexpected traffic %+v", users)
+	}
+}
+
+func TestCommandQuerierReportsFailures(t *testing.T) {
+	querier := &CommandQuerier{runner: func(ctx context.Context, cmd []string) ([]byte, error) {
+		return nil, errors.New("connection refused")
+	}}
+	if _, err := querier.QueryUserTraffic(context.Background(), false); err == nil {
+		t.Fatal("expected runner failure to be reported")
+	}
+	if _, err := ParseUserStats([]byte("failed to dial")); err == nil {
+		t.Fatal("expected malformed output to be rejected")
+	}
+	if users, err := ParseUserStats([]byte("{}\n")); err != nil || len(users) != 0 {
+		t.Fatalf("expected empty stats, got %+v (%v)", users, err)
+	}
+}
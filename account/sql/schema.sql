@@ -14,6 +14,19 @@ DROP TABLE IF EXISTS public.identities CASCADE;
 DROP TABLE IF EXISTS public.users CASCADE;
 DROP TABLE IF EXISTS public.admin_settings CASCADE;
 DROP TABLE IF EXISTS public.subscriptions CASCADE;
+DROP TABLE IF EXISTS public.device_tokens CASCADE;
+DROP TABLE IF EXISTS public.refresh_tokens CASCADE;
+DROP TABLE IF EXISTS public.audit_events CASCADE;
+DROP TABLE IF EXISTS public.webauthn_credentials CASCADE;
+DROP TABLE IF EXISTS public.mfa_recovery_codes CASCADE;
+DROP TABLE IF EXISTS public.outbound_emails CASCADE;
+DROP TABLE IF EXISTS public.payment_events CASCADE;
+DROP TABLE IF EXISTS public.usage_reports CASCADE;
+DROP TABLE IF EXISTS public.traffic_usage CASCADE;
+DROP TABLE IF EXISTS public.agent_status_history CASCADE;
+DROP TABLE IF EXISTS public.agent_enrollments CASCADE;
+DROP TABLE IF EXISTS public.agents CASCADE;
+DROP TABLE IF EXISTS public.xray_templates CASCADE;
 
 -- =========================================
 -- Extensions
@@ -55,6 +68,14 @@ BEGIN
 END;
 $$;
 
+-- 审计日志只允许追加
+CREATE OR REPLACE FUNCTION public.reject_audit_event_mutation() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$;
+
 -- Tables
 -- =========================================
 
@@ -75,6 +96,9 @@ CREATE TABLE public.users (
   mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE,
   mfa_secret_issued_at TIMESTAMPTZ,
   mfa_confirmed_at TIMESTAMPTZ,
+  sync_secret TEXT,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE, -- 管理员禁用后无法登录
+  locale TEXT, -- 事务邮件语言偏好，例如 en、zh-CN
   email_verified_at TIMESTAMPTZ,
   email_verified BOOLEAN GENERATED ALWAYS AS ((email_verified_at IS NOT NULL)) STORED
 );
@@ -125,12 +149,175 @@ CREATE TABLE public.subscriptions (
   status TEXT NOT NULL DEFAULT 'pending',
   payment_qr TEXT,
   meta JSONB NOT NULL DEFAULT '{}'::jsonb,
+  current_period_start TIMESTAMPTZ,
+  current_period_end TIMESTAMPTZ,
+  provider_updated_at TIMESTAMPTZ,
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   cancelled_at TIMESTAMPTZ,
   CONSTRAINT subscriptions_user_external_uk UNIQUE (user_uuid, external_id)
 );
 
+-- 设备令牌：桌面端/CLI 使用的具名、可撤销凭证，仅保存 SHA-256 哈希
+CREATE TABLE public.device_tokens (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL,
+  scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  expires_at TIMESTAMPTZ,
+  CONSTRAINT device_tokens_token_hash_uk UNIQUE (token_hash)
+);
+
+-- 刷新令牌：记录每个已签发的 refresh token，轮换后同一 family 共享 family_uuid
+CREATE TABLE public.refresh_tokens (
+  uuid UUID PRIMARY KEY,
+  family_uuid UUID NOT NULL,
+  user_id TEXT NOT NULL,
+  email TEXT,
+  roles JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);
+
+-- 安全审计日志（只追加）
+CREATE TABLE public.audit_events (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  actor_id TEXT,
+  subject_id TEXT,
+  action TEXT NOT NULL,
+  outcome TEXT NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  request_id TEXT,
+  metadata JSONB NOT NULL DEFAULT '{}'::jsonb
+);
+
+-- WebAuthn 凭证：硬件密钥与平台通行密钥，public_key 为 COSE 编码公钥
+CREATE TABLE public.webauthn_credentials (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  credential_id BYTEA NOT NULL,
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  aaguid BYTEA,
+  transports JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  CONSTRAINT webauthn_credentials_credential_id_uk UNIQUE (credential_id)
+);
+
+-- MFA 恢复码：一次性使用，仅保存 SHA-256 哈希
+CREATE TABLE public.mfa_recovery_codes (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at TIMESTAMPTZ,
+  CONSTRAINT mfa_recovery_codes_user_code_uk UNIQUE (user_uuid, code_hash)
+);
+
+-- 邮件发送队列：投递成功后删除，永久失败的消息保留 dead_lettered_at 供排查
+CREATE TABLE public.outbound_emails (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  recipients JSONB NOT NULL DEFAULT '[]'::jsonb,
+  subject TEXT NOT NULL,
+  plain_body TEXT NOT NULL DEFAULT '',
+  html_body TEXT NOT NULL DEFAULT '',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error TEXT,
+  dead_lettered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- 支付回调事件日志：(provider, event_id) 作为幂等键，保留原始报文与处理结果
+CREATE TABLE public.payment_events (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  provider TEXT NOT NULL,
+  event_id TEXT NOT NULL,
+  event_type TEXT NOT NULL DEFAULT '',
+  payload BYTEA NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  processing_at TIMESTAMPTZ, -- 正在处理该事件的投递的领取时间，超过租约后可被重投接管
+  processed_at TIMESTAMPTZ,
+  result TEXT,
+  error TEXT,
+  CONSTRAINT payment_events_provider_event_uk UNIQUE (provider, event_id)
+);
+
+-- 代理流量用量：按用户、按 UTC 自然日累计 agent 上报的 Xray 上下行字节数
+CREATE TABLE public.traffic_usage (
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  day DATE NOT NULL,
+  uplink BIGINT NOT NULL DEFAULT 0,
+  downlink BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_uuid, day)
+);
+
+-- 已入账的 agent 用量上报：按 agent 与上报 ID 去重，避免重试导致重复计量
+CREATE TABLE public.usage_reports (
+  agent_id TEXT NOT NULL,
+  report_id TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (agent_id, report_id)
+);
+
+-- 节点 agent：运行期注册的 agent 凭据，仅保存令牌的 SHA-256 哈希；配置文件中的静态凭据不入库
+CREATE TABLE public.agents (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  groups JSONB NOT NULL DEFAULT '[]'::jsonb,
+  token_hash TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMPTZ,
+  CONSTRAINT agents_token_hash_uk UNIQUE (token_hash)
+);
+
+-- agent 一次性注册码：新节点用其换取长期凭据，仅保存哈希
+CREATE TABLE public.agent_enrollments (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  code_hash TEXT NOT NULL,
+  agent_id TEXT NOT NULL DEFAULT '',
+  name TEXT NOT NULL DEFAULT '',
+  groups JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  used_by TEXT,
+  CONSTRAINT agent_enrollments_code_hash_uk UNIQUE (code_hash)
+);
+
+-- agent 状态历史：控制器重启后仍可查询节点的历史上报
+CREATE TABLE public.agent_status_history (
+  uuid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  agent_id TEXT NOT NULL,
+  healthy BOOLEAN NOT NULL DEFAULT false,
+  report JSONB NOT NULL DEFAULT '{}'::jsonb,
+  reported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Xray 节点模板：按 agent ID 或分组下发的服务端模板，content 中的 ${NAME} 占位符在渲染时替换
+CREATE TABLE public.xray_templates (
+  name TEXT PRIMARY KEY,
+  content TEXT NOT NULL,
+  agent_ids JSONB NOT NULL DEFAULT '[]'::jsonb,
+  groups JSONB NOT NULL DEFAULT '[]'::jsonb,
+  variables JSONB NOT NULL DEFAULT '{}'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
 -- =========================================
 -- Indexes
 -- =========================================
@@ -141,6 +328,23 @@ CREATE INDEX idx_sessions_user_uuid ON public.sessions (user_uuid);
 CREATE INDEX idx_admin_settings_version ON public.admin_settings (version);
 CREATE INDEX idx_subscriptions_user_uuid ON public.subscriptions (user_uuid);
 CREATE INDEX idx_subscriptions_status ON public.subscriptions (status);
+CREATE INDEX idx_subscriptions_period_end ON public.subscriptions (current_period_end) WHERE status IN ('trialing', 'active', 'past_due');
+CREATE INDEX idx_device_tokens_user_uuid ON public.device_tokens (user_uuid);
+CREATE INDEX idx_refresh_tokens_family_uuid ON public.refresh_tokens (family_uuid);
+CREATE INDEX idx_refresh_tokens_user_id ON public.refresh_tokens (user_id);
+CREATE INDEX idx_refresh_tokens_expires_at ON public.refresh_tokens (expires_at);
+CREATE INDEX idx_audit_events_occurred_at ON public.audit_events (occurred_at DESC);
+CREATE INDEX idx_audit_events_actor_id ON public.audit_events (actor_id, occurred_at DESC);
+CREATE INDEX idx_audit_events_action ON public.audit_events (action, occurred_at DESC);
+CREATE INDEX idx_webauthn_credentials_user_uuid ON public.webauthn_credentials (user_uuid);
+CREATE INDEX idx_outbound_emails_due ON public.outbound_emails (next_attempt_at) WHERE dead_lettered_at IS NULL;
+CREATE INDEX idx_payment_events_received_at ON public.payment_events (received_at DESC);
+CREATE INDEX idx_subscriptions_provider_external_id ON public.subscriptions (provider, external_id);
+CREATE INDEX idx_traffic_usage_day ON public.traffic_usage (day);
+CREATE INDEX idx_usage_reports_received_at ON public.usage_reports (received_at);
+CREATE INDEX idx_agent_enrollments_expires_at ON public.agent_enrollments (expires_at) WHERE used_at IS NULL;
+CREATE INDEX idx_agent_status_history_agent_reported ON public.agent_status_history (agent_id, reported_at DESC);
+CREATE INDEX idx_agent_status_history_reported_at ON public.agent_status_history (reported_at);
 
 -- =========================================
 -- Triggers
@@ -190,3 +394,23 @@ CREATE TRIGGER trg_admin_settings_bump_version
 CREATE TRIGGER trg_subscriptions_set_updated_at
   BEFORE UPDATE ON public.subscriptions
   FOR EACH ROW EXECUTE FUNCTION public.set_updated_at();
+
+-- outbound_emails
+CREATE TRIGGER trg_outbound_emails_set_updated_at
+  BEFORE UPDATE ON public.outbound_emails
+  FOR EACH ROW EXECUTE FUNCTION public.set_updated_at();
+
+-- agents
+CREATE TRIGGER trg_agents_set_updated_at
+  BEFORE UPDATE ON public.agents
+  FOR EACH ROW EXECUTE FUNCTION public.set_updated_at();
+
+-- xray_templates
+CREATE TRIGGER trg_xray_templates_set_updated_at
+  BEFORE UPDATE ON public.xray_templates
+  FOR EACH ROW EXECUTE FUNCTION public.set_updated_at();
+
+-- audit_events
+CREATE TRIGGER trg_audit_events_append_only
+  BEFORE UPDATE OR DELETE ON public.audit_events
+  FOR EACH ROW EXECUTE FUNCTION public.reject_audit_event_mutation();
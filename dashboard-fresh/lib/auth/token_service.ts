@@ -175,6 +175,10 @@ export class TokenService {
 
       const data = await response.json();
       this.accessToken = data.access_token;
+      // Refresh tokens rotate: the previous one is no longer valid.
+      if (data.refresh_token) {
+        this.refreshToken = data.refresh_token;
+      }
       this.storeTokens();
 
       return this.accessToken;
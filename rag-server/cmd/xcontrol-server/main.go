@@ -123,6 +123,7 @@ var rootCmd = &cobra.Command{
 			authConfig := auth.DefaultConfig()
 			authConfig.AuthURL = cfg.Auth.AuthURL
 			authConfig.PublicToken = cfg.Auth.PublicToken
+			authConfig.JWKSURL = cfg.Auth.JWKSURL
 
 			authClient := auth.NewAuthClient(authConfig)
 
@@ -141,6 +142,7 @@ var rootCmd = &cobra.Command{
 
 			logger.Info("authentication middleware enabled",
 				"auth_url", cfg.Auth.AuthURL,
+				"jwks_url", authClient.JWKSURL(),
 				"cache_ttl", middlewareConfig.CacheTTL.String(),
 			)
 		} else {
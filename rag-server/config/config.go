@@ -165,6 +165,9 @@ type AuthCfg struct {
 	AuthURL     string `yaml:"authUrl"`
 	APIBaseURL  string `yaml:"apiBaseUrl"`
 	PublicToken string `yaml:"publicToken"`
+	// JWKSURL overrides where access token public keys are fetched from.
+	// It defaults to <authUrl>/.well-known/jwks.json.
+	JWKSURL string `yaml:"jwksUrl"`
 }
 
 type Config struct {
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	accountIssuer         = "xcontrol-account"
+	accountAccessAudience = "xcontrol-access"
+
+	// jwksMinRefreshInterval bounds how often an unknown key ID can trigger
+	// a refetch, so forged tokens cannot be used to hammer the account
+	// service.
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+// AccountClaims are the access token claims issued by the account service.
+type AccountClaims struct {
+	UserID string   `json:"user_id"`
+	Email  string   `json:"email"`
+	Roles  []string `json:"roles"`
+	MFA    bool     `json:"mfa_verified"`
+	jwt.RegisteredClaims
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+// JWKSVerifier verifies account service access tokens with the public keys
+// published at the account service's /.well-known/jwks.json. Concurrent
+// refreshes share a single fetch, which runs without holding the key cache
+// lock so cached keys keep being served meanwhile.
+type JWKSVerifier struct {
+	url     string
+	ttl     time.Duration
+	client  *http.Client
+	refresh singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSVerifier creates a verifier for the JWKS document at url. Keys are
+// cached for ttl and refetched early when a token references an unknown key.
+func NewJWKSVerifier(url string, ttl time.Duration) *JWKSVerifier {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &JWKSVerifier{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Verify validates the signature, issuer, audience and expiry of an access
+// token and returns its claims.
+func (v *JWKSVerifier) Verify(ctx context.Context, accessToken string) (*AccountClaims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &AccountClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key id")
+		}
+		key, err := v.lookup(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(accountIssuer),
+		jwt.WithAudience(accountAccessAudience),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse access token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*AccountClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid access token")
+	}
+	return claims, nil
+}
+
+func (v *JWKSVerifier) lookup(ctx context.Context, kid string) (publicKey, error) {
+	v.mu.Lock()
+	now := time.Now()
+	key, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) >= v.ttl
+	due := stale || now.Sub(v.fetchedAt) >= jwksMinRefreshInterval
+	v.mu.Unlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+	if due {
+		keys, err := v.refreshKeys(ctx)
+		if err != nil {
+			// Keep serving cached keys if the account service is briefly
+			// unavailable.
+			if ok {
+				return key, nil
+			}
+			return publicKey{}, err
+		}
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return publicKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the key set and swaps it into the cache. Callers that
+// arrive while a fetch is running wait for its result instead of starting
+// another one. The fetch is not cancelled with the caller that started it,
+// since others may be waiting on it; it is bounded by the client timeout.
+func (v *JWKSVerifier) refreshKeys(ctx context.Context) (map[string]publicKey, error) {
+	result := v.refresh.DoChan("jwks", func() (interface{}, error) {
+		keys, err := v.fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.keys = keys
+		v.fetchedAt = time.Now()
+		v.mu.Unlock()
+		return keys, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[string]publicKey), nil
+	}
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		parsed, err := parseJWK(k)
+		if err != nil {
+			// Skip keys this verifier does not understand rather than
+			// rejecting the whole set.
+			continue
+		}
+		keys[k.KeyID] = parsed
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (publicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{
+			alg: jwt.SigningMethodRS256.Alg(),
+			key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())},
+		}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return publicKey{}, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid Ed25519 public key")
+		}
+		return publicKey{alg: jwt.SigningMethodEdDSA.Alg(), key: ed25519.PublicKey(x)}, nil
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
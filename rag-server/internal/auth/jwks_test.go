@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signAccountToken(t *testing.T, kid string, key ed25519.PrivateKey, audience string) string {
+	t.Helper()
+	claims := AccountClaims{
+		UserID: "user-1",
+		Email:  "user@example.com",
+		Roles:  []string{"admin"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Issuer:    accountIssuer,
+			Audience:  []string{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyTokenMiddlewareWithJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var fetches atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "OKP",
+			"kid": "k1",
+			"alg": "EdDSA",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	}))
+	defer jwksServer.Close()
+
+	client := NewAuthClient(&TokenConfig{AuthURL: jwksServer.URL})
+	if got, want := client.JWKSURL(), jwksServer.URL+"/.well-known/jwks.json"; got != want {
+		t.Fatalf("expected default jwks url %q, got %q", want, got)
+	}
+	cfg := DefaultMiddlewareConfig(client)
+	cfg.Verifier = NewJWKSVerifier(jwksServer.URL, time.Minute)
+	cfg.SkipPaths = []string{"/healthz"}
+
+	router := gin.New()
+	router.Use(VerifyTokenMiddleware(cfg))
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user": GetUserID(c), "roles": GetRoles(c)})
+	})
+
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := request(signAccountToken(t, "k1", private, accountAccessAudience)); rr.Code != http.StatusOK {
+		t.Fatalf("expected valid token to pass, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := request(signAccountToken(t, "k1", private, accountAccessAudience)); rr.Code != http.StatusOK {
+		t.Fatalf("expected cached key to verify, got %d", rr.Code)
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("expected keys to be cached, got %d fetches", fetches.Load())
+	}
+
+	if rr := request(signAccountToken(t, "k1", private, "xcontrol-refresh")); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong audience to be rejected, got %d", rr.Code)
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if rr := request(signAccountToken(t, "k1", other, accountAccessAudience)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected forged signature to be rejected, got %d", rr.Code)
+	}
+	if rr := request(signAccountToken(t, "unknown", private, accountAccessAudience)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unknown key id to be rejected, got %d", rr.Code)
+	}
+	if rr := request(""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected missing token to be rejected, got %d", rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected skipped path to bypass auth, got %d", rr.Code)
+	}
+}
+
+func TestJWKSVerifierFetchesOutsideTheLock(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "OKP",
+			"kid": "k1",
+			"alg": "EdDSA",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	}))
+	defer jwksServer.Close()
+	defer close(release)
+
+	verifier := NewJWKSVerifier(jwksServer.URL, 5*time.Minute)
+	ctx := context.Background()
+	if _, err := verifier.Verify(ctx, signAccountToken(t, "k1", private, accountAccessAudience)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// Unknown key IDs trigger a refetch once the minimum interval passed.
+	// Concurrent lookups share the blocked fetch.
+	verifier.mu.Lock()
+	verifier.fetchedAt = time.Now().Add(-time.Minute)
+	verifier.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = verifier.Verify(ctx, signAccountToken(t, "k2", private, accountAccessAudience))
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Cached keys are served while the fetch is in flight.
+	verified := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(ctx, signAccountToken(t, "k1", private, accountAccessAudience))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Fatalf("verify cached key: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected cached keys to be served during a refresh")
+	}
+
+	// A caller that gives up does not wait for the fetch.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := verifier.Verify(cancelled, signAccountToken(t, "k3", private, accountAccessAudience)); err == nil {
+		t.Fatal("expected the cancelled lookup to fail")
+	}
+
+	release <- struct{}{}
+	wg.Wait()
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("expected concurrent refreshes to share one fetch, got %d fetches", got)
+	}
+}
@@ -134,11 +134,60 @@ func GetRoles(c *gin.Context) []string {
 	return roles.([]string)
 }
 
-// VerifyTokenMiddleware creates a middleware that verifies JWT tokens
+// VerifyTokenMiddleware creates a middleware that verifies account service
+// access tokens with the published JWKS, so only public keys are needed.
 func VerifyTokenMiddleware(config *MiddlewareConfig) gin.HandlerFunc {
-	// For now, just return the basic auth middleware
-	// The config parameters (SkipPaths, CacheTTL) can be used for optimization
-	return (&TokenService{}).AuthMiddleware()
+	if config == nil || config.Verifier == nil {
+		return (&TokenService{}).AuthMiddleware()
+	}
+
+	skip := make(map[string]struct{}, len(config.SkipPaths))
+	for _, path := range config.SkipPaths {
+		skip[path] = struct{}{}
+	}
+	verifier := config.Verifier
+
+	return func(c *gin.Context) {
+		if _, ok := skip[c.Request.URL.Path]; ok {
+			c.Next()
+			return
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "missing authorization header",
+			})
+			c.Abort()
+			return
+		}
+
+		if !strings.HasPrefix(authHeader, bearerPrefix) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid authorization header format",
+			})
+			c.Abort()
+			return
+		}
+
+		claims, err := verifier.Verify(c.Request.Context(), strings.TrimPrefix(authHeader, bearerPrefix))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":  "invalid or expired token",
+				"detail": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		// Store claims in context
+		ctx := context.WithValue(c.Request.Context(), userIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, emailKey, claims.Email)
+		ctx = context.WithValue(ctx, rolesKey, claims.Roles)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
 }
 
 // HealthCheckHandler returns a health check handler
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -38,6 +39,7 @@ type TokenService struct {
 type AuthClient struct {
 	*TokenService
 	authURL     string
+	jwksURL     string
 	publicToken string
 }
 
@@ -53,27 +55,52 @@ func DefaultConfig() *TokenConfig {
 func NewAuthClient(config *TokenConfig) *AuthClient {
 	return &AuthClient{
 		TokenService: NewTokenService(*config),
+		authURL:      config.AuthURL,
+		jwksURL:      config.JWKSURL,
 		publicToken:  config.PublicToken,
 	}
 }
 
+// JWKSURL returns the location of the account service's public keys. It
+// defaults to /.well-known/jwks.json under the auth URL.
+func (c *AuthClient) JWKSURL() string {
+	if url := strings.TrimSpace(c.jwksURL); url != "" {
+		return url
+	}
+	if base := strings.TrimRight(strings.TrimSpace(c.authURL), "/"); base != "" {
+		return base + "/.well-known/jwks.json"
+	}
+	return ""
+}
+
 // MiddlewareConfig holds middleware configuration
 type MiddlewareConfig struct {
 	SkipPaths []string
 	CacheTTL  time.Duration
+
+	// Verifier checks account service access tokens against the published
+	// JWKS. When nil the middleware falls back to the local TokenService.
+	Verifier *JWKSVerifier
 }
 
 // DefaultMiddlewareConfig creates default middleware configuration
 func DefaultMiddlewareConfig(client *AuthClient) *MiddlewareConfig {
-	return &MiddlewareConfig{
+	cfg := &MiddlewareConfig{
 		SkipPaths: []string{},
 		CacheTTL:  time.Minute * 5,
 	}
+	if client != nil {
+		if url := client.JWKSURL(); url != "" {
+			cfg.Verifier = NewJWKSVerifier(url, cfg.CacheTTL)
+		}
+	}
+	return cfg
 }
 
 // TokenConfig holds configuration for token service
 type TokenConfig struct {
 	AuthURL       string
+	JWKSURL       string
 	PublicToken   string
 	RefreshSecret string
 	AccessSecret  string
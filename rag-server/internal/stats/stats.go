@@ -1,5 +0,0 @@
-package stats
-
-// Placeholder for traffic stats collection.
-
-func Collect() error { return nil }